@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseCIDRs parses a comma separated list of IP ranges, a bare address is
+// a range of one
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, v := range splitList(s) {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("[%s] is not an address or range", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] is not an address or range", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// inRanges returns true if ip is in one of the ranges
+func inRanges(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of a connection's remote address, or nil
+func addrIP(addr net.Addr) net.IP {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// AllowedIP returns true if connections from ip are accepted, an address
+// in TCDenyCIDRs is refused, and when TCAllowCIDRs is set only addresses in
+// it are accepted
+func (s *Server) AllowedIP(ip net.IP) bool {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	if len(s.DenyNets) == 0 && len(s.AllowNets) == 0 {
+		return true
+	}
+	if ip == nil || inRanges(ip, s.DenyNets) {
+		return false
+	}
+	return len(s.AllowNets) == 0 || inRanges(ip, s.AllowNets)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowedIP(t *testing.T) {
+	serv := NewServer()
+	if !serv.AllowedIP(net.ParseIP("203.0.113.9")) {
+		t.Errorf("expected every address to be allowed by default")
+	}
+
+	var err error
+	if serv.AllowNets, err = parseCIDRs("10.0.0.0/8, 2001:db8::/32"); err != nil {
+		t.Fatal(err)
+	}
+	if serv.DenyNets, err = parseCIDRs("10.6.6.0/24,10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseCIDRs("10.0.0.0/33"); err == nil {
+		t.Errorf("expected bad range to be refused")
+	}
+	if _, err := parseCIDRs("gotham"); err == nil {
+		t.Errorf("expected bad address to be refused")
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":        true,
+		"2001:db8::1":     true,
+		"10.6.6.6":        false,
+		"10.0.0.1":        false,
+		"10.0.0.2":        true,
+		"203.0.113.9":     false,
+		"::ffff:10.1.2.3": true,
+	}
+	for addr, want := range cases {
+		if got := serv.AllowedIP(net.ParseIP(addr)); got != want {
+			t.Errorf("expected %s allowed to be %t, got %t", addr, want, got)
+		}
+	}
+	if serv.AllowedIP(nil) {
+		t.Errorf("expected unknown addresses to be refused")
+	}
+	if ip := addrIP(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 8091}); !ip.Equal(net.ParseIP("10.1.2.3")) {
+		t.Errorf("expected the address of the connection, got %v", ip)
+	}
+}
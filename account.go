@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const accountsName = "accounts.json"
+
+// hashIterations is the pbkdf2 work factor for stored passwords
+const hashIterations = 10000
+
+// errBadLogin is returned for a wrong nick or password
+var errBadLogin = errors.New("invalid nick or password\r\n")
+
+// Account is a registered nick and its credentials
+type Account struct {
+	Name string      `json:"name"`
+	Salt string      `json:"salt"`
+	Hash string      `json:"hash"`
+	Push *PushTarget `json:"push,omitempty"`
+
+	Email     string `json:"email,omitempty"`
+	EmailMode string `json:"email_mode,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Lang      string `json:"lang,omitempty"`
+
+	Timezone   string `json:"timezone,omitempty"`
+	TimeFormat string `json:"time_format,omitempty"`
+	NoEmoji    bool   `json:"no_emoji,omitempty"`
+	Plain      bool   `json:"plain,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+
+	Stars []Event  `json:"stars,omitempty"`
+	Memos []Memo   `json:"memos,omitempty"`
+	Watch []string `json:"watch,omitempty"`
+
+	Keywords       []string `json:"keywords,omitempty"`
+	KeywordsPublic bool     `json:"keywords_public,omitempty"`
+	AutoJoin       []string `json:"autojoin,omitempty"`
+
+	Favorites map[string]string `json:"favorites,omitempty"`
+}
+
+// AccountStore keeps the registered accounts and persists them to disk
+type AccountStore struct {
+	mu       sync.Mutex
+	path     string
+	Accounts map[string]*Account
+
+	// Propose replicates every account registered or updated, which is
+	// then stored with Put, without it the account is stored at once
+	Propose func(a Account) error
+
+	// changing makes changes one at a time, each is made to the account as
+	// the last one left it
+	changing sync.Mutex
+}
+
+// NewAccountStore returns a store backed by the file at path, an empty path
+// keeps the accounts in memory only
+func NewAccountStore(path string) *AccountStore {
+	return &AccountStore{
+		path:     path,
+		Accounts: make(map[string]*Account),
+	}
+}
+
+// Load reads the accounts from disk, a missing file is not an error
+func (as *AccountStore) Load() error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(as.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = openData(b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &as.Accounts)
+}
+
+// save writes the accounts to disk, it must be called with the lock held
+func (as *AccountStore) save() error {
+	if as.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(as.Accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if b, err = sealData(b); err != nil {
+		return err
+	}
+
+	tmp := as.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, as.path)
+}
+
+// find returns the account whose name folds the same as name, it must be
+// called with the lock held
+func (as *AccountStore) find(name string) (*Account, bool) {
+	if a, ok := as.Accounts[name]; ok {
+		return a, true
+	}
+	key := foldNick(name)
+	for n, a := range as.Accounts {
+		if foldNick(n) == key {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Exists returns true if the name is registered in any casing
+func (as *AccountStore) Exists(name string) bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	_, ok := as.find(name)
+	return ok
+}
+
+// Get returns a copy of the account
+func (as *AccountStore) Get(name string) (Account, bool) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	a, ok := as.find(name)
+	if !ok {
+		return Account{}, false
+	}
+	return *a, true
+}
+
+// Update applies fn to a copy of the account and stores it, the store is
+// unchanged until the change is replicated, it must not be called with
+// the server lock held
+func (as *AccountStore) Update(name string, fn func(a *Account)) error {
+	as.changing.Lock()
+	defer as.changing.Unlock()
+
+	as.mu.Lock()
+	a, ok := as.find(name)
+	if !ok {
+		as.mu.Unlock()
+		return fmt.Errorf("nick [%s] is not registered\r\n", name)
+	}
+	c := a.clone()
+	as.mu.Unlock()
+
+	fn(&c)
+	return as.store(c)
+}
+
+// store replicates the account through Propose, or stores it at once
+func (as *AccountStore) store(a Account) error {
+	if as.Propose != nil {
+		return as.Propose(a)
+	}
+	return as.Put(a)
+}
+
+// Put stores a copy of the account as is, replacing any previous one
+func (as *AccountStore) Put(a Account) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.Accounts[a.Name] = &a
+	return as.save()
+}
+
+// All returns a copy of every account
+func (as *AccountStore) All() []Account {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	out := make([]Account, 0, len(as.Accounts))
+	for _, a := range as.Accounts {
+		out = append(out, a.clone())
+	}
+	return out
+}
+
+// Replace stores the accounts in place of all the others
+func (as *AccountStore) Replace(accounts []Account) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.Accounts = make(map[string]*Account)
+	for _, a := range accounts {
+		a := a
+		as.Accounts[a.Name] = &a
+	}
+	return as.save()
+}
+
+// clone returns a copy of the account that shares none of its lists
+func (a *Account) clone() Account {
+	c := *a
+	c.Stars = append([]Event(nil), a.Stars...)
+	c.Memos = append([]Memo(nil), a.Memos...)
+	c.Watch = append([]string(nil), a.Watch...)
+	c.Keywords = append([]string(nil), a.Keywords...)
+	c.AutoJoin = append([]string(nil), a.AutoJoin...)
+	if a.Favorites != nil {
+		c.Favorites = make(map[string]string, len(a.Favorites))
+		for k, v := range a.Favorites {
+			c.Favorites[k] = v
+		}
+	}
+	return c
+}
+
+// Notifiable returns the accounts that want push or email notifications
+func (as *AccountStore) Notifiable() []string {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	var out []string
+	for name, a := range as.Accounts {
+		if a.Push != nil || a.EmailMode != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// Register creates an account for name with the given password, it must
+// not be called with the server lock held, the slow hash is worked out
+// before taking any lock
+func (as *AccountStore) Register(name, password string) error {
+	if as.Exists(name) {
+		return fmt.Errorf("nick [%s] is already registered\r\n", name)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hash := pbkdf2([]byte(password), salt, hashIterations)
+
+	as.changing.Lock()
+	defer as.changing.Unlock()
+
+	// checked again, someone may have registered it while hashing
+	if as.Exists(name) {
+		return fmt.Errorf("nick [%s] is already registered\r\n", name)
+	}
+
+	return as.store(Account{
+		Name: name,
+		Salt: hex.EncodeToString(salt),
+		Hash: hex.EncodeToString(hash),
+	})
+}
+
+// Authenticate returns an error unless the password matches the account,
+// the slow hash is worked out without holding the lock
+func (as *AccountStore) Authenticate(name, password string) error {
+	e := errBadLogin
+	a, ok := as.Get(name)
+	if !ok {
+		return e
+	}
+
+	salt, err := hex.DecodeString(a.Salt)
+	if err != nil {
+		return e
+	}
+	want, err := hex.DecodeString(a.Hash)
+	if err != nil {
+		return e
+	}
+
+	if subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, hashIterations), want) != 1 {
+		return e
+	}
+	return nil
+}
+
+// pbkdf2 derives a single block key from the password with HMAC-SHA256
+func pbkdf2(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	out := make([]byte, len(u))
+	copy(out, u)
+
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+// Register registers the client's current nick as an account
+func (s *Server) Register(cl *Client, password string) error {
+	if cl.Account() != "" {
+		return fmt.Errorf("already logged in as [%s]\r\n", cl.Account())
+	}
+	s.mu.Lock()
+	restricted := s.restricted(cl)
+	s.mu.Unlock()
+	if restricted {
+		return errors.New("connections from your address can't register\r\n")
+	}
+
+	nick := cl.Nick()
+	if err := s.Accounts.Register(nick, password); err != nil {
+		return err
+	}
+
+	cl.mu.Lock()
+	cl.account = nick
+	cl.mu.Unlock()
+	errl(nil, fmt.Sprintf("Account [%s] registered", nick))
+	return nil
+}
+
+// Login authenticates cl as the account name, if the account is already
+// connected on another device cl's connection joins that client, which is
+// returned, so every device shares the same nick and room
+func (s *Server) Login(name, password string, cl *Client) (*Client, error) {
+	// the password is checked before locking, the hash is slow on purpose
+	if err := s.Accounts.Authenticate(name, password); err != nil {
+		return nil, err
+	}
+	// the account's own casing of its name, whichever was typed
+	if a, ok := s.Accounts.Get(name); ok {
+		name = a.Name
+	}
+
+	s.mu.Lock()
+
+	if existing := s.findAccount(name); existing != nil && existing != cl {
+		unread := s.adopt(existing, cl)
+		existing.mu.Lock()
+		devices := len(existing.Conns)
+		existing.mu.Unlock()
+		s.mu.Unlock()
+
+		existing.Write(fmt.Sprintf("Logged in as [%s], %d device(s) connected\r\n", existing.Nick(), devices))
+		for _, ev := range unread {
+			existing.Send(ev)
+		}
+		return existing, nil
+	}
+	defer s.mu.Unlock()
+
+	a, _ := s.Accounts.Get(name)
+	cl.mu.Lock()
+	cl.account = name
+	if a.Status != "" {
+		cl.status = a.Status
+	}
+	if a.Lang != "" {
+		cl.lang = a.Lang
+	}
+	if loc, err := time.LoadLocation(a.Timezone); err == nil && a.Timezone != "" {
+		cl.tz = loc
+	}
+	if a.TimeFormat != "" {
+		cl.timefmt = a.TimeFormat
+	}
+	cl.noEmoji = a.NoEmoji
+	cl.plain = a.Plain
+	if a.PublicKey != "" {
+		cl.pubkey = a.PublicKey
+	}
+	cl.watch = append([]string{}, a.Watch...)
+	cl.keywords, cl.keywordsPublic = append([]string{}, a.Keywords...), a.KeywordsPublic
+	cl.mu.Unlock()
+
+	if cl.Nick() != name {
+		if err := s.changeNick(cl.Nick(), name); err != nil {
+			cl.mu.Lock()
+			cl.account = ""
+			cl.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	cl.Write(fmt.Sprintf("Logged in as [%s]\r\n", name))
+	return cl, nil
+}
+
+// findAccount returns the connected client logged in to the account
+func (s *Server) findAccount(name string) *Client {
+	for _, c := range s.Clients {
+		if c.Account() == name {
+			return c
+		}
+	}
+	return nil
+}
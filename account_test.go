@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"path"
+	"testing"
+)
+
+func TestAccountStore(t *testing.T) {
+	p := path.Join(t.TempDir(), accountsName)
+	as := NewAccountStore(p)
+
+	if err := as.Register("batman", "hunter2"); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	if err := as.Register("batman", "hunter3"); err == nil {
+		t.Errorf("expected duplicate registration to fail")
+	}
+
+	loaded := NewAccountStore(p)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	if err := loaded.Authenticate("batman", "hunter2"); err != nil {
+		t.Errorf("expected password to authenticate")
+	}
+
+	if err := loaded.Authenticate("batman", "wrong"); err == nil {
+		t.Errorf("expected wrong password to fail")
+	}
+}
+
+func TestLoginMultiDevice(t *testing.T) {
+	serv := NewServer()
+
+	c1, p1 := net.Pipe()
+	cl := &Client{nick: "batman", Conns: []*Conn{NewConn(c1)}}
+	serv.JoinRoom("gotham", cl)
+	if err := serv.Register(cl, "hunter2"); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	c2, p2 := net.Pipe()
+	second := &Client{nick: "user1", Conns: []*Conn{NewConn(c2)}}
+	serv.JoinRoom(DefaultRoom, second)
+
+	if err := serv.ChangeNick("user1", "batman"); err == nil {
+		t.Errorf("expected guest to be refused a registered nick")
+	}
+
+	r1 := bufio.NewReader(p1)
+	r2 := bufio.NewReader(p2)
+	done := make(chan *Client)
+	go func() {
+		acct, err := serv.Login("batman", "hunter2", second)
+		if err != nil {
+			t.Errorf("expected error to be nil")
+		}
+		done <- acct
+		cl.Write("hi freeze\r\n")
+	}()
+
+	for _, r := range []*bufio.Reader{r1, r2} {
+		line, _ := r.ReadString('\n')
+		if line != "Logged in as [batman], 2 device(s) connected\r\n" {
+			t.Errorf("expected login notice on every device, got [%s]", line)
+		}
+	}
+
+	if acct := <-done; acct != cl {
+		t.Fatalf("expected second device to join the existing client")
+	}
+
+	for _, r := range []*bufio.Reader{r1, r2} {
+		line, _ := r.ReadString('\n')
+		if line != "hi freeze\r\n" {
+			t.Errorf("expected every device to receive the message, got [%s]", line)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultArchiveInterval is how often logs and journal segments are
+// rotated and uploaded
+const DefaultArchiveInterval = 24 * time.Hour
+
+// S3 uploads objects to a bucket of S3 compatible storage, signing
+// requests with AWS signature version 4
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+}
+
+// NewS3 returns a client for the bucket, endpoint is a base URL such as
+// https://s3.amazonaws.com, objects are addressed path style
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) *S3 {
+	return &S3{
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		client:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Put uploads body as the object key
+func (s3 *S3) Put(key string, body []byte, contentType string) error {
+	return s3.do("PUT", "/"+s3.Bucket+"/"+key, "", body, map[string]string{"Content-Type": contentType})
+}
+
+// SetExpiry makes the bucket delete objects under prefix after days
+func (s3 *S3) SetExpiry(prefix string, days int) error {
+	body := []byte(fmt.Sprintf("<LifecycleConfiguration><Rule><ID>tinychat-archive</ID><Filter><Prefix>%s</Prefix></Filter><Status>Enabled</Status><Expiration><Days>%d</Days></Expiration></Rule></LifecycleConfiguration>",
+		xmlEscape(prefix), days))
+	sum := md5.Sum(body)
+	return s3.do("PUT", "/"+s3.Bucket, "lifecycle=", body, map[string]string{
+		"Content-Type": "application/xml",
+		"Content-MD5":  base64.StdEncoding.EncodeToString(sum[:]),
+	})
+}
+
+// do sends a signed request and fails unless the response is a 2xx
+func (s3 *S3) do(method, path, query string, body []byte, headers map[string]string) error {
+	url := s3.Endpoint + path
+	if query != "" {
+		url = url + "?" + query
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	s3.sign(req, path, query, body, time.Now().UTC())
+
+	res, err := s3.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("s3 %s %s returned %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds the AWS signature version 4 headers to the request
+func (s3 *S3) sign(req *http.Request, path, query string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{req.Method, s3Escape(path), query, canonHeaders.String(), signed, payloadHash}, "\n")
+	canonSum := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + s3.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s3.SecretKey), day)
+	key = hmacSHA256(key, s3.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape URI encodes a path as S3 expects, keeping the slashes
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// rotator is a file that can be closed off for archiving, Rotate returns
+// the path of the closed file
+type rotator interface {
+	Rotate() (string, error)
+}
+
+// RotatingFile is a log file that can be rotated while in use
+type RotatingFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// OpenRotatingFile opens the file at path for appending
+func OpenRotatingFile(path string) (*RotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &RotatingFile{path: path, f: f}, nil
+}
+
+// Write appends to the current file, sealed a line at a time with the
+// data key when there is one
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	b, err := sealLine(p)
+	if err != nil {
+		return 0, err
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if _, err := rf.f.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+// Rotate renames the current file with a timestamp and starts a new one
+func (rf *RotatingFile) Rotate() (string, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	closed := rotatedName(rf.path, time.Now())
+	if err := os.Rename(rf.path, closed); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return "", err
+	}
+	rf.f.Close()
+	rf.f = f
+	return closed, nil
+}
+
+// rotatedName returns an unused name for a file rotated at now
+func rotatedName(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + now.UTC().Format("20060102T150405Z")
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// Archiver periodically rotates files then compresses and uploads the
+// closed ones, removing them locally once uploaded
+type Archiver struct {
+	Store    *S3
+	Prefix   string
+	Interval time.Duration
+
+	mu      sync.Mutex
+	files   []rotator
+	pending []string
+}
+
+// NewArchiver returns an archiver uploading under prefix every interval
+func NewArchiver(store *S3, prefix string, interval time.Duration) *Archiver {
+	return &Archiver{Store: store, Prefix: prefix, Interval: interval}
+}
+
+// Add rotates the file along with the others
+func (a *Archiver) Add(r rotator) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.files = append(a.files, r)
+}
+
+// Run rotates and archives every interval
+func (a *Archiver) Run() {
+	for {
+		time.Sleep(a.Interval)
+		a.Archive()
+	}
+}
+
+// Archive rotates every file and uploads the closed ones, uploads that
+// fail are retried next time
+func (a *Archiver) Archive() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, r := range a.files {
+		closed, err := r.Rotate()
+		if err != nil {
+			errl(err, "")
+			continue
+		}
+		a.pending = append(a.pending, closed)
+	}
+
+	var failed []string
+	for _, p := range a.pending {
+		if err := a.upload(p); err != nil {
+			errl(fmt.Errorf("archiving [%s] failed: %v", p, err), "")
+			failed = append(failed, p)
+			continue
+		}
+		errl(os.Remove(p), fmt.Sprintf("Archived [%s]", p))
+	}
+	a.pending = failed
+}
+
+// upload compresses the file and puts it under the prefix
+func (a *Archiver) upload(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = filepath.Base(path)
+	if _, err := zw.Write(raw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return a.Store.Put(a.Prefix+filepath.Base(path)+".gz", buf.Bytes(), "application/gzip")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		objects[r.URL.Path] = b
+	}))
+	defer ts.Close()
+
+	logFile := path.Join(dir, "tinychat.log")
+	rf, err := OpenRotatingFile(logFile)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("first\n"))
+
+	a := NewArchiver(NewS3(ts.URL, "us-east-1", "logs", "key", "secret"), "node-1/", time.Hour)
+	a.Add(rf)
+
+	// a failed upload keeps the rotated file for the next run
+	a.Archive()
+	if len(a.pending) != 1 {
+		t.Fatalf("expected failed upload to be pending, got %d", len(a.pending))
+	}
+	if _, err := os.Stat(a.pending[0]); err != nil {
+		t.Errorf("expected rotated file to be kept, got %v", err)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	rf.Write([]byte("second\n"))
+	a.Archive()
+
+	if len(a.pending) != 0 {
+		t.Errorf("expected nothing pending, got %v", a.pending)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(objects) != 2 {
+		t.Fatalf("expected 2 uploads, got %d", len(objects))
+	}
+
+	var contents []string
+	for key, b := range objects {
+		if !strings.HasPrefix(key, "/logs/node-1/tinychat-") || !strings.HasSuffix(key, ".gz") {
+			t.Errorf("unexpected key %s", key)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("expected gzip body, got %v", err)
+		}
+		raw, _ := ioutil.ReadAll(zr)
+		contents = append(contents, string(raw))
+	}
+	if !(contents[0] == "first\n" && contents[1] == "second\n" || contents[0] == "second\n" && contents[1] == "first\n") {
+		t.Errorf("unexpected contents %q", contents)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "tinychat.log" {
+		t.Errorf("expected only the current log to be left, got %d file(s)", len(files))
+	}
+}
+
+func TestJournalRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "journal.log")
+
+	serv := NewServer()
+	serv.Journal, err = OpenJournal(serv, file, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.SetTopic(batman, "the dark knight")
+	serv.Ban(batman, "joker")
+	serv.Message([]string{"hello"}, batman)
+
+	closed, err := serv.Journal.Rotate()
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	os.Remove(closed)
+	serv.Message([]string{"gotham"}, batman)
+
+	// the new segment replays on its own
+	replica := NewServer()
+	if _, err := OpenJournal(replica, file, nil); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	r, ok := replica.Rooms["gotham"]
+	if !ok {
+		t.Fatalf("expected room to be rebuilt")
+	}
+	if r.Owner != "batman" || r.Topic != "the dark knight" || !r.Bans["joker"] {
+		t.Errorf("unexpected room %+v", r)
+	}
+	if len(r.History) != 2 || r.History[0].Text != "hello" || r.History[1].Text != "gotham" {
+		t.Errorf("expected history to be rebuilt, got %d message(s)", len(r.History))
+	}
+}
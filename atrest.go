@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// sealedPrefix starts data encrypted at rest, anything else is plain text,
+// which is only read with a data key while migrating so existing files
+// are encrypted the next time they are saved
+const sealedPrefix = "tcsealed1:"
+
+// dataKeySize is the size of the AES-256 key data is sealed with
+const dataKeySize = 32
+
+var errNoDataKey = errors.New("data is encrypted, set TCDataKey or TCDataKeyFile")
+
+var errPlainData = errors.New("data isn't encrypted, set TCDataMigrate=on to read and encrypt it")
+
+// atRest is the AEAD the accounts, snapshots, journal, raft log,
+// reminders, spam filter, and logs are sealed with before they are
+// written, nil leaves them plain
+var atRest struct {
+	sync.RWMutex
+	aead cipher.AEAD
+
+	// migrate reads plain data even with a key, so files written before
+	// the key was set can be read once and sealed
+	migrate bool
+}
+
+// loadDataKey returns the base64 key in the file at path or, when path is
+// empty, in env, as injected by a KMS or secret manager, it returns nil
+// when neither is set
+func loadDataKey(path, env string) ([]byte, error) {
+	text := env
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(key) != dataKeySize {
+		return nil, fmt.Errorf("the data key must be %d bytes of base64", dataKeySize)
+	}
+	return key, nil
+}
+
+// setDataKey seals everything written from now on with key, nil stops
+// sealing, sealed data can then no longer be read
+func setDataKey(key []byte) error {
+	var aead cipher.AEAD
+	if key != nil {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	atRest.Lock()
+	defer atRest.Unlock()
+	atRest.aead = aead
+	return nil
+}
+
+// setDataMigrate lets plain data be read while a data key is set
+func setDataMigrate(on bool) {
+	atRest.Lock()
+	defer atRest.Unlock()
+	atRest.migrate = on
+}
+
+// sealData encrypts b with the data key, as a single line of text so it
+// fits a line of the journal too, b is returned as is without a key
+func sealData(b []byte) ([]byte, error) {
+	atRest.RLock()
+	aead := atRest.aead
+	atRest.RUnlock()
+	if aead == nil {
+		return b, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, b, []byte(sealedPrefix))
+	return []byte(sealedPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// openData decrypts data sealed by sealData, plain data is returned as is
+// without a data key or while migrating, and refused otherwise so data
+// can't be swapped for a plain copy
+func openData(b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+	atRest.RLock()
+	aead, migrate := atRest.aead, atRest.migrate
+	atRest.RUnlock()
+	if !bytes.HasPrefix(b, []byte(sealedPrefix)) {
+		if aead != nil && !migrate && len(b) > 0 {
+			return nil, errPlainData
+		}
+		return b, nil
+	}
+	if aead == nil {
+		return nil, errNoDataKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(b[len(sealedPrefix):]))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is corrupt")
+	}
+	n := aead.NonceSize()
+	plain, err := aead.Open(nil, sealed[:n], sealed[n:], []byte(sealedPrefix))
+	if err != nil {
+		return nil, errors.New("sealed data can't be opened, is the data key right?")
+	}
+	return plain, nil
+}
+
+// sealLine seals a line of a log, the newline is kept so the log can still
+// be read a line at a time, p is returned as is without a key
+func sealLine(p []byte) ([]byte, error) {
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	b, err := sealData(line)
+	if err != nil || bytes.Equal(b, line) {
+		return p, err
+	}
+	return append(b, '\n'), nil
+}
+
+// openLog writes the log read from r to w with every sealed line opened,
+// for reading logs written with a data key
+func openLog(w io.Writer, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		b, err := openData(sc.Bytes())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealData(t *testing.T) {
+	defer setDataKey(nil)
+
+	plain := []byte(`{"batman":{"name":"batman"}}`)
+	if b, _ := sealData(plain); !bytes.Equal(b, plain) {
+		t.Errorf("expected data to stay plain without a key")
+	}
+
+	key := bytes.Repeat([]byte{7}, dataKeySize)
+	if err := setDataKey(key); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	sealed, err := sealData(plain)
+	if err != nil || bytes.Contains(sealed, []byte("batman")) || bytes.ContainsRune(sealed, '\n') {
+		t.Fatalf("expected a single sealed line, got [%s] %v", sealed, err)
+	}
+	if b, err := openData(sealed); err != nil || !bytes.Equal(b, plain) {
+		t.Errorf("expected the data back, got [%s] %v", b, err)
+	}
+	if _, err := openData(plain); err != errPlainData {
+		t.Errorf("expected plain data to be refused with a key, got %v", err)
+	}
+	setDataMigrate(true)
+	if b, err := openData(plain); err != nil || !bytes.Equal(b, plain) {
+		t.Errorf("expected plain data to be read as is while migrating, got [%s] %v", b, err)
+	}
+	setDataMigrate(false)
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(sealedPrefix)+20] ^= 1
+	if _, err := openData(tampered); err == nil {
+		t.Errorf("expected tampered data to be refused")
+	}
+	setDataKey(bytes.Repeat([]byte{8}, dataKeySize))
+	if _, err := openData(sealed); err == nil {
+		t.Errorf("expected the wrong key to be refused")
+	}
+	setDataKey(nil)
+	if _, err := openData(sealed); err != errNoDataKey {
+		t.Errorf("expected sealed data to need a key, got %v", err)
+	}
+}
+
+func TestLoadDataKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, dataKeySize))
+	if key, err := loadDataKey("", encoded); err != nil || len(key) != dataKeySize {
+		t.Errorf("expected the key from env, got %v", err)
+	}
+	if key, err := loadDataKey("", ""); err != nil || key != nil {
+		t.Errorf("expected no key, got %v %v", key, err)
+	}
+	if _, err := loadDataKey("", "c2hvcnQ="); err == nil {
+		t.Errorf("expected a short key to be refused")
+	}
+
+	dir, err := ioutil.TempDir("", "datakey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	ioutil.WriteFile(path, []byte(encoded+"\n"), 0600)
+	if key, err := loadDataKey(path, ""); err != nil || len(key) != dataKeySize {
+		t.Errorf("expected the key from the file, got %v", err)
+	}
+}
+
+func TestAccountsAtRest(t *testing.T) {
+	defer setDataKey(nil)
+	defer setDataMigrate(false)
+
+	dir, err := ioutil.TempDir("", "atrest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, accountsName)
+
+	// a plain store is only read while migrating, and encrypted the next
+	// time it is saved
+	plain := NewAccountStore(path)
+	plain.Register("batman", "alfred")
+	setDataKey(bytes.Repeat([]byte{7}, dataKeySize))
+	if err := NewAccountStore(path).Load(); err != errPlainData {
+		t.Errorf("expected the plain store refused, got %v", err)
+	}
+	setDataMigrate(true)
+	sealed := NewAccountStore(path)
+	if err := sealed.Load(); err != nil || !sealed.Exists("batman") {
+		t.Fatalf("expected the plain store to load, got %v", err)
+	}
+	sealed.Register("robin", "wonder")
+	setDataMigrate(false)
+
+	b, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(b), sealedPrefix) || strings.Contains(string(b), "robin") {
+		t.Errorf("expected the accounts to be sealed, got [%s]", b)
+	}
+	again := NewAccountStore(path)
+	if err := again.Load(); err != nil || !again.Exists("batman") || !again.Exists("robin") {
+		t.Errorf("expected the sealed store to load, got %v", err)
+	}
+}
+
+func TestJournalAtRest(t *testing.T) {
+	defer setDataKey(nil)
+	setDataKey(bytes.Repeat([]byte{7}, dataKeySize))
+
+	dir, err := ioutil.TempDir("", "atrest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.log")
+
+	serv := NewServer()
+	serv.Journal, err = OpenJournal(serv, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("batcave", batman)
+	serv.Message([]string{"the", "joker", "is", "loose"}, batman)
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "joker") {
+		t.Errorf("expected the journal to be sealed, got [%s]", b)
+	}
+
+	replica := NewServer()
+	if _, err := OpenJournal(replica, path, nil); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if h := replica.Rooms["batcave"].History; len(h) == 0 || h[len(h)-1].Text != "the joker is loose" {
+		t.Errorf("expected the history replayed from the sealed journal, got %+v", h)
+	}
+}
+
+func TestLogAtRest(t *testing.T) {
+	defer setDataKey(nil)
+	setDataKey(bytes.Repeat([]byte{7}, dataKeySize))
+
+	path := filepath.Join(t.TempDir(), "tinychat.log")
+	rf, err := OpenRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("[batman] joined\n"))
+	rf.Write([]byte("[joker] joined\n"))
+	rf.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "joker") || strings.Count(string(b), "\n") != 2 {
+		t.Errorf("expected the log sealed a line at a time, got [%s]", b)
+	}
+	var out bytes.Buffer
+	if err := openLog(&out, bytes.NewReader(b)); err != nil || out.String() != "[batman] joined\n[joker] joined\n" {
+		t.Errorf("expected the log opened, got [%s] %v", out.String(), err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxAutoJoin is how many rooms an account may auto-join
+const maxAutoJoin = 10
+
+// AutoJoin adds room to the auto-join list of the client's account, or
+// removes it when add is false, it returns the list as it is now
+// example: /autojoin add gotham
+func (s *Server) AutoJoin(cl *Client, room string, add bool) ([]string, error) {
+	name := cl.Account()
+	if name == "" {
+		return nil, fmt.Errorf("you must be logged in to auto-join rooms\r\n")
+	}
+	if err := validRoom(room); err != nil {
+		return nil, err
+	}
+	room = roomDisplay(room)
+
+	var list []string
+	var err error
+	uerr := s.Accounts.Update(name, func(a *Account) {
+		defer func() { list = append([]string{}, a.AutoJoin...) }()
+		for i, r := range a.AutoJoin {
+			if roomKey(r) != roomKey(room) {
+				continue
+			}
+			if add {
+				err = fmt.Errorf("room [%s] is already auto-joined\r\n", room)
+			} else {
+				a.AutoJoin = append(a.AutoJoin[:i:i], a.AutoJoin[i+1:]...)
+			}
+			return
+		}
+		switch {
+		case !add:
+			err = fmt.Errorf("room [%s] is not auto-joined\r\n", room)
+		case len(a.AutoJoin) >= maxAutoJoin:
+			err = fmt.Errorf("you can auto-join up to %d rooms\r\n", maxAutoJoin)
+		default:
+			a.AutoJoin = append(a.AutoJoin, room)
+		}
+	})
+	if uerr != nil {
+		return nil, uerr
+	}
+	return list, err
+}
+
+// autojoin moves the client into the first room of its account's
+// auto-join list it may join, only 1 room may be joined so the rest are
+// there in case it can't, it returns the room joined
+func (s *Server) autojoin(cl *Client) (string, bool) {
+	a, ok := s.Accounts.Get(cl.Account())
+	if !ok {
+		return "", false
+	}
+	for _, room := range a.AutoJoin {
+		if err := s.JoinRoom(room, cl); err != nil {
+			cl.Write(fmt.Sprintf("Unable to auto-join room [%s]: %s", room, err.Error()))
+			continue
+		}
+		cl.Write(fmt.Sprintf("Auto-joining room %s\r\n", roomKey(room)))
+		if summary, err := s.RoomSummary(cl); err == nil && !cl.Bot() {
+			cl.Write(summary)
+		}
+		return room, true
+	}
+	return "", false
+}
+
+// autojoinCommand runs /autojoin
+func (s *Server) autojoinCommand(in *Input) {
+	if len(in.Args) == 1 {
+		a, _ := s.Accounts.Get(in.Client.Account())
+		in.Client.Write(fmt.Sprintf("Auto-joined rooms: %s\r\n", listOrNone(a.AutoJoin)))
+		return
+	}
+	action := strings.ToLower(in.Args[1])
+	if action != "add" && action != "del" {
+		in.Client.Write(fmt.Sprintf("[%s] is not an /autojoin action, use add or del\r\n", in.Args[1]))
+		return
+	}
+	args, err := in.Quoted(2)
+	if err == nil && len(args) != 1 {
+		err = fmt.Errorf("name 1 room, quote names with spaces, /autojoin add \"gotham city\"\r\n")
+	}
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	list, err := s.AutoJoin(in.Client, args[0], action == "add")
+	reply(in.Client, fmt.Sprintf("Auto-joined rooms: %s\r\n", listOrNone(list)), err)
+}
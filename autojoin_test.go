@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAutoJoin(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman := &Client{nick: "batman", account: "batman"}
+	serv.JoinRoom("batcave", batman)
+
+	if _, err := serv.AutoJoin(&Client{nick: "joker"}, "gotham", true); err == nil {
+		t.Errorf("expected guests to be unable to auto-join")
+	}
+	for _, room := range []string{"arkham", "wayne  manor", "gotham"} {
+		if _, err := serv.AutoJoin(batman, room, true); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+	}
+	if _, err := serv.AutoJoin(batman, "Gotham", true); err == nil {
+		t.Errorf("expected a room added twice refused")
+	}
+	list, err := serv.AutoJoin(batman, "ARKHAM", false)
+	if err != nil || len(list) != 2 || list[0] != "wayne manor" || list[1] != "gotham" {
+		t.Errorf("expected arkham removed, got %v, %v", list, err)
+	}
+	if _, err := serv.AutoJoin(batman, "arkham", false); err == nil {
+		t.Errorf("expected a room not auto-joined refused")
+	}
+
+	room := func(cl *Client) string {
+		serv.mu.Lock()
+		defer serv.mu.Unlock()
+		if r, err := serv.findRoom(cl); err == nil {
+			return r.Name
+		}
+		return ""
+	}
+
+	// a ban skips to the next room
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("wayne manor", joker)
+	serv.Ban(joker, "batman")
+
+	guest := &Client{nick: "guest1"}
+	serv.JoinRoom(DefaultRoom, guest)
+	serv.Dispatch(&Input{Client: guest, Command: "/login", Args: []string{"/login", "batman", "alfred"}})
+	if r := room(batman); r != "batcave" {
+		t.Errorf("expected the logged in session to stay put, got %s", r)
+	}
+
+	serv.mu.Lock()
+	serv.removeClient(batman)
+	serv.tryDeleteFromRoom(batman)
+	serv.mu.Unlock()
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom(DefaultRoom, robin)
+	serv.Dispatch(&Input{Client: robin, Command: "/login", Args: []string{"/login", "batman", "alfred"}})
+	if r := room(robin); r != "gotham" {
+		t.Errorf("expected gotham auto-joined, got %s", r)
+	}
+}
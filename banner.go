@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultServerName is what the banner calls the server unless
+// TCServerName is set
+const DefaultServerName = "TinyChat"
+
+// bannerData is what a banner template may show
+type bannerData struct {
+	Nick   string
+	Server string
+	Users  int
+	Rooms  []string
+}
+
+// loadBanner parses the banner template in path, an empty path keeps the
+// built in banner
+func loadBanner(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New("banner").Parse(string(b))
+}
+
+// Banner returns the welcome and help shown to a user, the built in
+// welcome is in lang, a TCBanner template is shown as written, while
+// sessions are recorded the banner always starts by saying so
+func (s *Server) Banner(nick, lang string) string {
+	s.cfg.RLock()
+	tmpl, name := s.BannerFile, s.ServerName
+	s.cfg.RUnlock()
+	if name == "" {
+		name = DefaultServerName
+	}
+
+	if tmpl == nil {
+		var err error
+		if tmpl, err = template.New("banner").Parse(translate(lang, banner)); err != nil {
+			errl(err, "")
+			tmpl = template.Must(template.New("banner").Parse(banner))
+		}
+	}
+
+	data := bannerData{Nick: nick, Server: name}
+	s.mu.Lock()
+	data.Users = len(s.Clients)
+	for _, r := range s.Rooms {
+		data.Rooms = append(data.Rooms, r.Display)
+	}
+	s.mu.Unlock()
+	sort.Strings(data.Rooms)
+
+	notice := ""
+	if recorder() != nil {
+		notice = "\n" + translate(lang, recordingNotice)
+	}
+	var b strings.Builder
+	b.WriteString(notice)
+	if err := tmpl.Execute(&b, data); err != nil {
+		errl(fmt.Errorf("error showing TCBanner: %v", err), "")
+		b.Reset()
+		b.WriteString(notice)
+		template.Must(template.New("banner").Parse(banner)).Execute(&b, data)
+	}
+	return b.String() + s.Commands.Help() + bannerEnd
+}
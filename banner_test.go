@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBanner(t *testing.T) {
+	serv := NewServer()
+	serv.JoinRoom("gotham", &Client{nick: "batman"})
+	serv.JoinRoom("Wayne Manor", &Client{nick: "alfred"})
+
+	if out := serv.Banner("robin", ""); !strings.HasPrefix(out, "\n--|Welcome|") || !strings.Contains(out, "You are user [robin], Welcome to TinyChat.") {
+		t.Errorf("expected the built in banner, got [%s]", out)
+	}
+	if out := serv.Banner("robin", "de"); !strings.Contains(out, "Du bist [robin], willkommen bei TinyChat.") {
+		t.Errorf("expected the built in banner translated, got [%s]", out)
+	}
+
+	dir, err := ioutil.TempDir("", "banner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "banner.tmpl")
+	ioutil.WriteFile(path, []byte("Hi {{.Nick}}, {{.Server}} has {{.Users}} users in {{range $i, $r := .Rooms}}{{if $i}}, {{end}}{{$r}}{{end}}\n"), 0644)
+
+	os.Setenv("TCBanner", path)
+	os.Setenv("TCServerName", "Gotham Chat")
+	defer os.Unsetenv("TCBanner")
+	defer os.Unsetenv("TCServerName")
+	if err := serv.Reload(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if out := serv.Banner("robin", "de"); !strings.HasPrefix(out, "Hi robin, Gotham Chat has 2 users in Wayne Manor, gotham\n") {
+		t.Errorf("expected the template, got [%s]", out)
+	}
+
+	// a broken template is refused on reload and the last one kept
+	ioutil.WriteFile(path, []byte("Hi {{.Nick"), 0644)
+	if err := serv.Reload(); err == nil {
+		t.Errorf("expected a broken template to be refused")
+	}
+	if out := serv.Banner("robin", ""); !strings.HasPrefix(out, "Hi robin,") {
+		t.Errorf("expected the last template kept, got [%s]", out)
+	}
+}
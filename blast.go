@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// who may blast, admins only, or registered users now and then
+const (
+	BlastAdmins  = "admins"
+	BlastLimited = "limited"
+)
+
+// DefaultBlastInterval is how often a registered user may blast when
+// TCBlastPolicy is limited
+const DefaultBlastInterval = time.Hour
+
+// parseBlastPolicy reads TCBlastPolicy and TCBlastInterval
+func parseBlastPolicy() (string, time.Duration, error) {
+	policy := os.Getenv("TCBlastPolicy")
+	switch policy {
+	case "":
+		policy = BlastAdmins
+	case BlastAdmins, BlastLimited:
+	default:
+		return "", 0, fmt.Errorf("TCBlastPolicy must be %s or %s", BlastAdmins, BlastLimited)
+	}
+	every := DefaultBlastInterval
+	if v := os.Getenv("TCBlastInterval"); len(v) > 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return "", 0, fmt.Errorf("error parsing TCBlastInterval: [%s] is not a duration", v)
+		}
+		every = d
+	}
+	return policy, every, nil
+}
+
+// blastPolicy returns who may blast and how often registered users may
+func (s *Server) blastPolicy() (string, time.Duration) {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	if s.BlastPolicy == "" {
+		return BlastAdmins, DefaultBlastInterval
+	}
+	return s.BlastPolicy, s.BlastInterval
+}
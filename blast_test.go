@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlastPolicy(t *testing.T) {
+	serv := NewServer()
+	serv.Admins = map[string]bool{"batman": true}
+	batman := &Client{nick: "batman", account: "batman"}
+	robin := &Client{nick: "robin", account: "robin"}
+	joker := &Client{nick: "joker"}
+	for _, cl := range []*Client{batman, robin, joker} {
+		serv.JoinRoom("gotham", cl)
+	}
+
+	if err := serv.Blast([]string{"/blast", "hello"}, robin); err == nil || !strings.Contains(err.Error(), "only admins") {
+		t.Errorf("expected only admins to blast by default, got %v", err)
+	}
+	if err := serv.Blast([]string{"/blast", "the", "ice", "man", "cometh"}, batman); err != nil {
+		t.Errorf("expected admins to blast, got %v", err)
+	}
+	if log := serv.ModLog(); len(log) != 1 || log[0].Action != "blast" || log[0].Target != "the ice man cometh" {
+		t.Errorf("expected the blast logged, got %+v", log)
+	}
+
+	os.Setenv("TCBlastPolicy", BlastLimited)
+	os.Setenv("TCBlastInterval", "1h")
+	defer os.Unsetenv("TCBlastPolicy")
+	defer os.Unsetenv("TCBlastInterval")
+	if err := serv.applyConfig(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Admins = map[string]bool{"batman": true}
+	if err := serv.Blast([]string{"/blast", "holy", "blasts"}, robin); err != nil {
+		t.Errorf("expected a registered user to blast, got %v", err)
+	}
+	if err := serv.Blast([]string{"/blast", "again"}, robin); err == nil || !strings.Contains(err.Error(), "blast again in") {
+		t.Errorf("expected a second blast within the interval refused, got %v", err)
+	}
+	if err := serv.Blast([]string{"/blast", "hahaha"}, joker); err == nil {
+		t.Errorf("expected guests refused")
+	}
+	serv.lastBlast["robin"] = time.Now().Add(-2 * time.Hour)
+	if err := serv.Blast([]string{"/blast", "again"}, robin); err != nil {
+		t.Errorf("expected a blast after the interval, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := serv.Blast([]string{"/blast", "again"}, batman); err != nil {
+			t.Errorf("expected admins never limited, got %v", err)
+		}
+	}
+
+	os.Setenv("TCBlastPolicy", "everyone")
+	if err := serv.applyConfig(); err == nil {
+		t.Errorf("expected an unknown policy refused")
+	}
+}
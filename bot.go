@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimit is how many lines per second a person may send
+const DefaultRateLimit = 5
+
+// DefaultBotRateLimit is how many lines per second a bot may send when its
+// key doesn't say
+const DefaultBotRateLimit = 50
+
+// botAuthTimeout is how long a bot connection has to send its key
+const botAuthTimeout = 10 * time.Second
+
+// BotKey is the API key a bot connects with and its rate limit
+type BotKey struct {
+	Name string
+	Key  string
+	Rate int
+}
+
+// parseBotKeys parses a comma separated list of name:key or name:key:rate
+func parseBotKeys(s string) []BotKey {
+	var out []BotKey
+	for _, v := range splitList(s) {
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			errl(fmt.Errorf("ignoring malformed bot key for [%s]", parts[0]), "")
+			continue
+		}
+		k := BotKey{Name: parts[0], Key: parts[1], Rate: DefaultBotRateLimit}
+		if len(parts) == 3 {
+			rate, err := strconv.Atoi(parts[2])
+			if err != nil {
+				errl(fmt.Errorf("ignoring bot key for [%s], bad rate: %v", k.Name, err), "")
+				continue
+			}
+			k.Rate = rate
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+// BotKey returns the bot an API key belongs to
+func (s *Server) BotKey(key string) (BotKey, bool) {
+	if key == "" {
+		return BotKey{}, false
+	}
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	for _, k := range s.BotKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+	return BotKey{}, false
+}
+
+// isBotName returns true if the nick belongs to a bot in any casing
+func (s *Server) isBotName(nick string) bool {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	key := foldNick(nick)
+	for _, k := range s.BotKeys {
+		if foldNick(k.Name) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Bot returns true if the client is a bot
+func (cl *Client) Bot() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.bot
+}
+
+// initBot authenticates a bot by the API key on the first line it sends,
+// bots skip the banner and session token and receive JSON events
+func initBot(conn net.Conn) {
+	buf := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(botAuthTimeout))
+	line, err := buf.ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	key, ok := Serv.BotKey(strings.TrimSpace(line))
+	if !ok {
+		conn.Write([]byte("Invalid API key\r\n"))
+		conn.Close()
+		return
+	}
+	if Serv.HasClient(key.Name) || Serv.remoteNick(key.Name) {
+		conn.Write([]byte(fmt.Sprintf("Bot [%s] is already connected\r\n", key.Name)))
+		conn.Close()
+		return
+	}
+
+	cn := NewConn(conn)
+	cn.SetJSON(true)
+	cn.limit = newRateLimiter(key.Rate)
+	cl := &Client{nick: key.Name, bot: true, Conns: []*Conn{cn}}
+	if err := Serv.JoinRoom(DefaultRoom, cl); err != nil {
+		conn.Write([]byte(err.Error()))
+		conn.Close()
+		return
+	}
+	errl(nil, fmt.Sprintf("Bot [%s] connected", key.Name))
+	cl.Write(fmt.Sprintf("Authenticated as bot [%s]\r\n", key.Name))
+	Serv.Plugins.Connect(key.Name)
+	clientRun(cl, cn, buf)
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second holding
+// at most rate tokens
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of rate per second, or nil for no limit
+// when rate isn't positive
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// Allow takes a token, it returns false if none are left
+func (rl *rateLimiter) Allow() bool {
+	if rl == nil {
+		return true
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
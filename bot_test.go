@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredfolkins/telnacl/client"
+)
+
+func TestParseBotKeys(t *testing.T) {
+	keys := parseBotKeys("alfred:k3y:100, lucius:s3cret, broken, oracle:key:fast")
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if keys[0] != (BotKey{Name: "alfred", Key: "k3y", Rate: 100}) {
+		t.Errorf("unexpected key %+v", keys[0])
+	}
+	if keys[1].Rate != DefaultBotRateLimit {
+		t.Errorf("expected default rate, got %d", keys[1].Rate)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(3)
+	for i := 0; i < 3; i++ {
+		if !rl.Allow() {
+			t.Fatalf("expected line %d to be allowed", i)
+		}
+	}
+	if rl.Allow() {
+		t.Errorf("expected the fourth line to be limited")
+	}
+	rl.last = rl.last.Add(-time.Second)
+	if !rl.Allow() {
+		t.Errorf("expected the bucket to refill")
+	}
+
+	var unlimited *rateLimiter
+	if !unlimited.Allow() {
+		t.Errorf("expected no limit")
+	}
+}
+
+func TestBot(t *testing.T) {
+	Serv = NewServer()
+	Serv.BotKeys = parseBotKeys("alfred:k3y:100")
+
+	batman := &Client{nick: "batman"}
+	Serv.JoinRoom(DefaultRoom, batman)
+
+	// a bad key is turned away
+	c1, c2 := net.Pipe()
+	go initBot(c2)
+	go c1.Write([]byte("wrong\n"))
+	line, _ := bufio.NewReader(c1).ReadString('\n')
+	if line != "Invalid API key\r\n" {
+		t.Errorf("expected key to be refused, got %q", line)
+	}
+	c1.Close()
+
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	done := make(chan bool)
+	go func() {
+		initBot(c2)
+		close(done)
+	}()
+	go c1.Write([]byte("k3y\n"))
+
+	buf := bufio.NewReader(c1)
+	line, err := buf.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Text != "Authenticated as bot [alfred]" {
+		t.Errorf("expected JSON welcome without the banner, got %q", line)
+	}
+
+	out, err := Serv.Who(batman)
+	if err != nil || out != "Members of room [Gotham City]: alfred [bot], batman\r\n" {
+		t.Errorf("expected bot to be marked, got %q", out)
+	}
+	if err := Serv.ChangeNick("batman", "alfred"); err == nil {
+		t.Errorf("expected bot nick to be reserved")
+	}
+	if err := Serv.ChangeNick("alfred", "pennyworth"); err == nil {
+		t.Errorf("expected bot to keep its nick")
+	}
+
+	go c1.Write([]byte("good evening, master wayne\n"))
+	line, _ = buf.ReadString('\n')
+	if !strings.Contains(line, `"from":"alfred"`) || !strings.Contains(line, "master wayne") {
+		t.Errorf("expected bot message, got %q", line)
+	}
+
+	c1.Close()
+	<-done
+}
+
+func TestBotClient(t *testing.T) {
+	Serv = NewServer()
+	Serv.BotKeys = parseBotKeys("alfred:k3y:100")
+
+	c1, c2 := net.Pipe()
+	go initBot(c2)
+	if _, err := client.New(c1, "wrong"); err == nil || err.Error() != "Invalid API key" {
+		t.Errorf("expected key to be refused, got %v", err)
+	}
+
+	c1, c2 = net.Pipe()
+	done := make(chan bool)
+	go func() {
+		initBot(c2)
+		close(done)
+	}()
+	b, err := client.New(c1, "k3y")
+	if err != nil || b.Name != "alfred" {
+		t.Fatalf("expected bot to authenticate, got %v", err)
+	}
+	defer b.Close()
+
+	// next reads events until one matches
+	next := func(what string, match func(ev client.Event) bool) {
+		for {
+			ev, err := b.Next()
+			if err != nil {
+				t.Fatalf("expected %s, got %v", what, err)
+			}
+			if match(ev) {
+				return
+			}
+		}
+	}
+
+	b.Join("Batcave")
+	next("to join", func(ev client.Event) bool { return ev.Text == "Joining room batcave" })
+
+	robin := &Client{nick: "robin"}
+	go Serv.JoinRoom("batcave", robin)
+	next("join event", func(ev client.Event) bool { return ev.Type == client.EventJoin && ev.From == "robin" })
+
+	b.Command("op", "robin")
+	next("op", func(ev client.Event) bool { return ev.Text == "[robin] is now an op of this room" })
+	go Serv.SetTopic(robin, "stately wayne manor")
+	next("topic", func(ev client.Event) bool { return ev.Text == "[robin] set the topic to [stately wayne manor]" })
+	if err := Serv.Ban(robin, "alfred"); err == nil {
+		t.Errorf("expected op not to ban the owner")
+	}
+	if out, _ := Serv.Who(robin); out != "Members of room [Batcave]: alfred [bot], robin [op]\r\n" {
+		t.Errorf("expected op to be marked, got %q", out)
+	}
+
+	b.Say("!echo hello")
+	next("own message", func(ev client.Event) bool { return ev.Type == client.EventMessage && ev.From == "alfred" })
+
+	b.Close()
+	<-done
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the line that ends a multi-line paste, and the one that drops it
+const (
+	captureEnd    = "."
+	captureCancel = "/cancel"
+)
+
+// limits of a multi-line paste
+const (
+	maxCaptureLines = 200
+	maxCaptureBytes = 16 << 10
+)
+
+// capture is a multi-line paste being collected
+type capture struct {
+	lines []string
+	size  int
+}
+
+// StartCapture switches the connection into paste mode, its lines are
+// collected until the end line instead of being run, it returns false if
+// the connection is already pasting
+func (c *Conn) StartCapture() bool {
+	c.captureMu.Lock()
+	defer c.captureMu.Unlock()
+	if c.capture != nil {
+		return false
+	}
+	c.capture = &capture{}
+	return true
+}
+
+// Capturing returns true while the connection is in paste mode
+func (c *Conn) Capturing() bool {
+	c.captureMu.Lock()
+	defer c.captureMu.Unlock()
+	return c.capture != nil
+}
+
+// Capture adds a line to the paste, at the end line it leaves paste mode
+// and returns the lines collected and true, the cancel line or a paste
+// over the limits leaves paste mode with nothing
+func (c *Conn) Capture(line string) (string, bool, error) {
+	c.captureMu.Lock()
+	defer c.captureMu.Unlock()
+	if c.capture == nil {
+		return "", false, nil
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch strings.TrimSpace(line) {
+	case captureEnd:
+		text := strings.Join(c.capture.lines, "\n")
+		c.capture = nil
+		return text, true, nil
+	case captureCancel:
+		c.capture = nil
+		return "", false, fmt.Errorf("Paste cancelled\r\n")
+	}
+	c.capture.lines = append(c.capture.lines, line)
+	c.capture.size += len(line) + 1
+	if len(c.capture.lines) > maxCaptureLines || c.capture.size > maxCaptureBytes {
+		c.capture = nil
+		return "", false, fmt.Errorf("Paste dropped, pastes may be up to %d lines and %s\r\n", maxCaptureLines, fileSize(maxCaptureBytes))
+	}
+	return "", false, nil
+}
+
+// captureLine takes a line read from a connection in paste mode, and
+// posts the paste once it ends
+func (s *Server) captureLine(cl *Client, conn *Conn, line string) {
+	recorder().Input(cl, strings.Fields(line))
+	text, done, err := conn.Capture(line)
+	if err != nil || !done {
+		reply(cl, "", err)
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		cl.Write("Nothing was pasted\r\n")
+		return
+	}
+	// its lines skipped the pipeline while they were collected, the paste
+	// goes through it as one message
+	in := &Input{Client: cl, Conn: conn, Args: strings.Split(text, "\n"), Lines: true}
+	s.Inbound.Run(in, func(in *Input) {
+		reply(in.Client, "", s.PasteBlock(in.Client, in.Text()))
+	})
+}
+
+// PasteBlock sends lines pasted together to the client's room as a single
+// code message
+func (s *Server) PasteBlock(cl *Client, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+	if err := s.probation(cl, "paste"); err != nil {
+		return err
+	}
+	ev := Event{
+		ID:   s.nextID(),
+		Type: EventMessage,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Room: r.Name,
+		Text: text,
+		Code: true,
+	}
+	if !s.spam(r, cl, ev) {
+		s.post(r, s.paste(r, ev))
+	}
+	return nil
+}
+
+// codeBlock renders the lines of a code message below a header, each set
+// off with a bar so the block reads apart from the chat around it
+func codeBlock(text string) string {
+	lines := strings.Split(text, "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "pasted %d line(s):", len(lines))
+	for _, line := range lines {
+		b.WriteString("\r\n  | " + line)
+	}
+	return b.String()
+}
+
+// pasteCommand runs /paste, with an id it reads a pasted long message and
+// without one it starts a multi-line paste
+func (s *Server) pasteCommand(in *Input) {
+	if len(in.Args) > 1 {
+		out, err := s.ReadPaste(in.Client, in.Args[1])
+		reply(in.Client, out, err)
+		return
+	}
+	if in.Conn == nil {
+		in.Client.Write("Unable to paste without a connection\r\n")
+		return
+	}
+	if !in.Conn.StartCapture() {
+		in.Client.Write("You are already pasting\r\n")
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Paste your lines, end with a line of just %s, or %s to drop them\r\n", captureEnd, captureCancel))
+}
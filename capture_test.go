@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapture(t *testing.T) {
+	serv := NewServer()
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("batcave", robin)
+	conn := NewConn(nil)
+
+	paste := func(lines ...string) {
+		for _, line := range lines {
+			serv.captureLine(robin, conn, line+"\r\n")
+		}
+	}
+
+	serv.Dispatch(&Input{Client: robin, Conn: conn, Command: "/paste", Args: []string{"/paste"}})
+	if !conn.Capturing() {
+		t.Fatalf("expected the connection in paste mode")
+	}
+	if conn.StartCapture() {
+		t.Errorf("expected a second paste refused while pasting")
+	}
+	paste("func main() {", "\tfmt.Println(\"holy pasted code\")", "}", ".")
+	if conn.Capturing() {
+		t.Errorf("expected the end line to leave paste mode")
+	}
+
+	h := serv.Rooms["batcave"].History
+	if len(h) != 1 || !h[0].Code || h[0].Text != "func main() {\n\tfmt.Println(\"holy pasted code\")\n}" {
+		t.Fatalf("expected 1 code message, got %+v", h)
+	}
+	out := h[0].String()
+	if !strings.Contains(out, "pasted 3 line(s):\r\n  | func main() {\r\n  | \tfmt.Println") {
+		t.Errorf("expected the lines set out as a block, got %q", out)
+	}
+
+	// cancelled and oversized pastes are dropped
+	conn.StartCapture()
+	paste("nothing to see", "/cancel")
+	conn.StartCapture()
+	for i := 0; i <= maxCaptureLines; i++ {
+		paste("again")
+	}
+	if conn.Capturing() {
+		t.Errorf("expected a paste over the limit to leave paste mode")
+	}
+	if len(serv.Rooms["batcave"].History) != 1 {
+		t.Errorf("expected dropped pastes kept out of the room")
+	}
+
+	// middleware added to the pipeline sees the paste as one input
+	var seen []string
+	serv.Inbound.Use("spy", func(next Handler) Handler {
+		return func(in *Input) {
+			if in.Lines {
+				seen = append(seen, in.Text())
+			}
+			next(in)
+		}
+	})
+	conn.StartCapture()
+	paste("holy", "middleware", ".")
+	if len(seen) != 1 || seen[0] != "holy\nmiddleware" {
+		t.Errorf("expected the paste through the pipeline, got %q", seen)
+	}
+	serv.Inbound.Remove("spy")
+
+	// guests on probation can't paste
+	serv.ProbationPeriod = time.Hour
+	conn.StartCapture()
+	paste("let me in", ".")
+	serv.ProbationPeriod = 0
+	if h := serv.Rooms["batcave"].History; h[len(h)-1].Text == "let me in" {
+		t.Errorf("expected a paste on probation refused")
+	}
+
+	// a finished paste goes through the filters and the quota
+	serv.Plugins = NewPlugins(&filterPlugin{})
+	serv.Quotas.Configure(QuotaLimits{Hour: 3}, QuotaLimits{})
+	robin.account = "robin"
+	for _, text := range []string{"why so serious", "the joker", "holy quota", "again"} {
+		conn.StartCapture()
+		paste(text, ".")
+	}
+	h = serv.Rooms["batcave"].History
+	if len(h) != 4 || h[1].Text != "holy\nmiddleware" || h[2].Text != "WHY SO SERIOUS" || h[3].Text != "HOLY QUOTA" {
+		t.Errorf("expected the filtered paste dropped and the one over the quota refused, got %+v", h)
+	}
+}
@@ -0,0 +1,180 @@
+// Package client connects bots to a TinyChat server's bot port, it
+// authenticates with an API key and reads the server's JSON events
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event types sent by the server
+const (
+	EventMessage = "message"
+	EventDirect  = "direct"
+	EventBlast   = "blast"
+	EventService = "service"
+	EventNotice  = "notice"
+	EventJoin    = "join"
+	EventText    = "text"
+)
+
+// Event is a line of JSON sent by the server
+type Event struct {
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type"`
+	Time string `json:"time,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	Room string `json:"room,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// authenticated matches the server's reply to a good key
+var authenticated = regexp.MustCompile(`^Authenticated as bot \[(.+)\]$`)
+
+// authTimeout is how long to wait for the server to accept the key
+const authTimeout = 10 * time.Second
+
+// Bot is an authenticated bot connection, Name is the nick its key
+// belongs to and Room the room it last joined
+type Bot struct {
+	Name string
+	Room string
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  *bufio.Reader
+}
+
+// Dial connects to addr and authenticates with key
+func Dial(addr, key string) (*Bot, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := New(conn, key)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// DialEnv connects to TCHost and TCBotPort with the key in TCBotKey
+func DialEnv() (*Bot, error) {
+	host := os.Getenv("TCHost")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("TCBotPort")
+	if port == "" {
+		return nil, errors.New("TCBotPort is not set")
+	}
+	return Dial(net.JoinHostPort(host, port), os.Getenv("TCBotKey"))
+}
+
+// New authenticates a bot over conn
+func New(conn net.Conn, key string) (*Bot, error) {
+	b := &Bot{conn: conn, buf: bufio.NewReader(conn)}
+	if err := b.Send(key); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	line, err := b.buf.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	var ev Event
+	if json.Unmarshal([]byte(line), &ev) != nil {
+		return nil, errors.New(strings.TrimSpace(line))
+	}
+	m := authenticated.FindStringSubmatch(ev.Text)
+	if m == nil {
+		return nil, errors.New(ev.Text)
+	}
+	b.Name = m[1]
+	return b, nil
+}
+
+// Send sends a raw line, a message to the room or a command
+func (b *Bot) Send(line string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.conn.Write([]byte(strings.Replace(line, "\n", " ", -1) + "\n"))
+	return err
+}
+
+// Say sends text to the bot's room
+func (b *Bot) Say(text string) error {
+	return b.Send(text)
+}
+
+// Notice sends text to the bot's room as a notice, automated replies
+// should be notices, and a bot should never answer one, so bots can't set
+// each other off
+func (b *Bot) Notice(text string) error {
+	return b.Command("notice", text)
+}
+
+// Command runs a server command, name may be given without its slash
+func (b *Bot) Command(name string, args ...string) error {
+	return b.Send(strings.Join(append([]string{"/" + strings.TrimPrefix(name, "/")}, args...), " "))
+}
+
+// Msg sends a private message
+func (b *Bot) Msg(nick, text string) error {
+	return b.Command("msg", nick, text)
+}
+
+// Join moves the bot to room
+func (b *Bot) Join(room string) error {
+	if err := b.Command("room", room); err != nil {
+		return err
+	}
+	b.Room = strings.ToLower(room)
+	return nil
+}
+
+// Next reads the next event
+func (b *Bot) Next() (Event, error) {
+	line, err := b.buf.ReadString('\n')
+	if err != nil {
+		return Event{}, err
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(line), &ev); err != nil {
+		return Event{}, fmt.Errorf("bad event %q: %v", line, err)
+	}
+	ev.Text = strings.TrimRight(ev.Text, "\r\n")
+	return ev, nil
+}
+
+// Run calls handle for every event until the connection closes, the bot's
+// own messages are skipped so it doesn't answer itself
+func (b *Bot) Run(handle func(b *Bot, ev Event)) error {
+	for {
+		ev, err := b.Next()
+		if err != nil {
+			return err
+		}
+		if ev.From == b.Name && ev.Type != EventText {
+			continue
+		}
+		handle(b, ev)
+	}
+}
+
+// Close disconnects the bot
+func (b *Bot) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestBot(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	// a fake server that accepts the key and sends a few events
+	go func() {
+		buf := bufio.NewReader(c2)
+		buf.ReadString('\n')
+		c2.Write([]byte(`{"type":"text","text":"Authenticated as bot [alfred]"}` + "\r\n"))
+		c2.Write([]byte(`{"type":"message","from":"alfred","text":"my own"}` + "\r\n"))
+		c2.Write([]byte(`{"type":"message","from":"batman","room":"batcave","text":"hello alfred"}` + "\r\n"))
+		line, _ := buf.ReadString('\n')
+		c2.Write([]byte(`{"type":"text","text":"got ` + line[:len(line)-1] + `\r\n"}` + "\r\n"))
+		c2.Close()
+	}()
+
+	b, err := New(c1, "k3y")
+	if err != nil || b.Name != "alfred" {
+		t.Fatalf("expected to authenticate as alfred, got %v", err)
+	}
+
+	var seen []Event
+	err = b.Run(func(b *Bot, ev Event) {
+		seen = append(seen, ev)
+		if ev.Type == EventMessage {
+			b.Msg(ev.From, "good evening")
+		}
+	})
+	if err == nil {
+		t.Errorf("expected run to end with the connection")
+	}
+	if len(seen) != 2 || seen[0].From != "batman" || seen[1].Text != "got /msg batman good evening" {
+		t.Errorf("unexpected events %+v", seen)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeFormats are the timestamp formats a client may pick, rfc3339 is the
+// default
+var timeFormats = map[string]string{
+	"rfc3339": time.RFC3339,
+	"long":    "2006-01-02 15:04:05",
+	"short":   "15:04",
+	"12h":     "3:04PM",
+}
+
+// timeFormatNames returns the names of the timestamp formats
+func timeFormatNames() string {
+	var names []string
+	for name := range timeFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// clock returns the timestamp of an event in the client's timezone and
+// format, it must be called with the client lock held
+func (cl *Client) clock(stamp string) string {
+	if cl.tz == nil && cl.timefmt == "" {
+		return stamp
+	}
+	t, err := time.Parse(time.RFC3339, stamp)
+	if err != nil {
+		return stamp
+	}
+	if cl.tz != nil {
+		t = t.In(cl.tz)
+	}
+	layout := time.RFC3339
+	if f, ok := timeFormats[cl.timefmt]; ok {
+		layout = f
+	}
+	return t.Format(layout)
+}
+
+// Timezone returns the name of the client's timezone, empty for the
+// server's
+func (cl *Client) Timezone() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.tz == nil {
+		return ""
+	}
+	return cl.tz.String()
+}
+
+// TimeFormat returns the name of the client's timestamp format
+func (cl *Client) TimeFormat() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.timefmt == "" {
+		return "rfc3339"
+	}
+	return cl.timefmt
+}
+
+// SetTimezone shows the client's timestamps in the IANA timezone name,
+// saved to its account if it is logged in
+func (s *Server) SetTimezone(cl *Client, name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return fmt.Errorf("[%s] is not a timezone, try UTC or Europe/Berlin\r\n", name)
+	}
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.Timezone = name }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.tz = loc
+	cl.mu.Unlock()
+	return nil
+}
+
+// SetTimeFormat picks the format of the client's timestamps, saved to its
+// account if it is logged in
+func (s *Server) SetTimeFormat(cl *Client, name string) error {
+	name = strings.ToLower(name)
+	if _, ok := timeFormats[name]; !ok {
+		return fmt.Errorf("[%s] is not a time format, pick one of %s\r\n", name, timeFormatNames())
+	}
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.TimeFormat = name }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.timefmt = name
+	cl.mu.Unlock()
+	return nil
+}
+
+// tzCommand runs /tz
+func (s *Server) tzCommand(in *Input) {
+	if len(in.Args) < 2 {
+		tz := in.Client.Timezone()
+		if tz == "" {
+			tz = "the server's"
+		}
+		in.Client.Write(fmt.Sprintf("Your timezone is [%s]\r\n", tz))
+		return
+	}
+	err := s.SetTimezone(in.Client, in.Args[1])
+	reply(in.Client, fmt.Sprintf("Your timezone is [%s]\r\n", in.Args[1]), err)
+}
+
+// timefmtCommand runs /timefmt
+func (s *Server) timefmtCommand(in *Input) {
+	if len(in.Args) < 2 {
+		in.Client.Write(fmt.Sprintf("Your time format is [%s], pick one of %s\r\n", in.Client.TimeFormat(), timeFormatNames()))
+		return
+	}
+	err := s.SetTimeFormat(in.Client, in.Args[1])
+	reply(in.Client, fmt.Sprintf("Your time format is [%s]\r\n", strings.ToLower(in.Args[1])), err)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClock(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+
+	const stamp = "2026-10-16T18:30:00Z"
+	if got := batman.clock(stamp); got != stamp {
+		t.Errorf("expected the server's timestamp by default, got [%s]", got)
+	}
+
+	if err := serv.SetTimezone(batman, "Gotham/Downtown"); err == nil {
+		t.Errorf("expected an unknown timezone to be refused")
+	}
+	if err := serv.SetTimeFormat(batman, "sundial"); err == nil {
+		t.Errorf("expected an unknown format to be refused")
+	}
+
+	serv.SetTimezone(batman, "Europe/Berlin")
+	if got := batman.clock(stamp); got != "2026-10-16T20:30:00+02:00" {
+		t.Errorf("expected Berlin time, got [%s]", got)
+	}
+	serv.SetTimeFormat(batman, "short")
+	if got := batman.clock(stamp); got != "20:30" {
+		t.Errorf("expected a short timestamp, got [%s]", got)
+	}
+	serv.SetTimeFormat(batman, "12h")
+	if got := batman.clock(stamp); got != "8:30PM" {
+		t.Errorf("expected a 12 hour timestamp, got [%s]", got)
+	}
+	if got := batman.clock("not a time"); got != "not a time" {
+		t.Errorf("expected a bad timestamp left alone, got [%s]", got)
+	}
+
+	// text connections see the client's time, JSON ones the original
+	c1, c2 := net.Pipe()
+	batman.Conns = []*Conn{NewConn(c1)}
+	go batman.Send(Event{Type: EventMessage, Time: stamp, From: "robin", Text: "holy clocks"})
+	if out, _ := bufio.NewReader(c2).ReadString('\n'); out != "[8:30PM:robin] holy clocks\r\n" {
+		t.Errorf("expected the client's timestamp, got [%s]", out)
+	}
+	batman.Conns[0].SetJSON(true)
+	go batman.Send(Event{Type: EventMessage, Time: stamp, From: "robin", Text: "holy clocks"})
+	if out, _ := bufio.NewReader(c2).ReadString('\n'); !strings.Contains(out, stamp) {
+		t.Errorf("expected JSON to keep the timestamp, got [%s]", out)
+	}
+}
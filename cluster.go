@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	clusterChannel = "tinychat:events"
+	presenceKey    = "tinychat:presence"
+	nodesKey       = "tinychat:nodes"
+	addrsKey       = "tinychat:addrs"
+)
+
+// clusterRetry is how long a node waits before reconnecting to redis
+const clusterRetry = 5 * time.Second
+
+// clusterHeartbeat is how often a node announces itself, nodes not heard
+// from for three heartbeats are dropped from the hash ring
+const clusterHeartbeat = 10 * time.Second
+
+// clusterQueue is how many events may wait to be relayed
+const clusterQueue = 1024
+
+// envelope routes
+const (
+	routeOwner   = "owner"
+	routeDeliver = "deliver"
+)
+
+// envelope is an event relayed between nodes, events without a route are
+// broadcast to every node, room events go to the node owning the room
+// which delivers them to the nodes with members in the room
+type envelope struct {
+	Node  string `json:"node"`
+	Route string `json:"route,omitempty"`
+	Event Event  `json:"event"`
+}
+
+// clusterMsg is an envelope waiting to be published on a channel
+type clusterMsg struct {
+	channel string
+	env     envelope
+}
+
+// nodeChannel returns the channel a node receives routed events on
+func nodeChannel(node string) string {
+	return "tinychat:node:" + node
+}
+
+// Cluster relays events between tinychat nodes through redis pub/sub and
+// keeps the presence registry of which node each nick is connected to
+type Cluster struct {
+	Server   *Server
+	Node     string
+	Addr     string
+	Password string
+
+	// Advertise is the address chat clients connect to this node on
+	Advertise string
+
+	pubMu sync.Mutex
+	pub   *redisConn
+
+	mu     sync.Mutex
+	remote map[string]remoteUser
+	ring   *hashRing
+	nodes  []string
+	addrs  map[string]string
+	queue  chan clusterMsg
+}
+
+// NewCluster returns the cluster membership of the server as node
+func NewCluster(s *Server, node, addr, password string) *Cluster {
+	return &Cluster{
+		Server:   s,
+		Node:     node,
+		Addr:     addr,
+		Password: password,
+		remote:   make(map[string]remoteUser),
+		ring:     newHashRing([]string{node}),
+		nodes:    []string{node},
+		addrs:    make(map[string]string),
+		queue:    make(chan clusterMsg, clusterQueue),
+	}
+}
+
+// Start begins relaying events in both directions
+func (c *Cluster) Start() {
+	go c.publishLoop()
+	go c.subscribeLoop()
+	go c.heartbeat()
+}
+
+// Publish routes a locally originated event to the other nodes, it never
+// blocks the caller
+func (c *Cluster) Publish(ev Event) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	msgs := c.route(envelope{Node: c.Node, Event: ev})
+	c.mu.Unlock()
+	c.enqueue(msgs)
+}
+
+func (c *Cluster) enqueue(msgs []clusterMsg) {
+	for _, m := range msgs {
+		select {
+		case c.queue <- m:
+		default:
+			errl(fmt.Errorf("cluster queue is full, dropping %s event", m.env.Event.Type), "")
+		}
+	}
+}
+
+// route returns where a locally originated event is published, room
+// events go to the owner of the room unless this node owns it, private
+// messages go straight to the node of the recipient, it must be called
+// with the lock held
+func (c *Cluster) route(env envelope) []clusterMsg {
+	ev := env.Event
+	switch ev.Type {
+	case EventMessage, EventService, EventNotice:
+		if owner := c.ring.Owner(ev.Room); owner != "" && owner != c.Node {
+			env.Route = routeOwner
+			return []clusterMsg{{nodeChannel(owner), env}}
+		}
+		return c.fanout(env)
+	case EventDirect:
+		if u, ok := c.remote[ev.To]; ok {
+			env.Route = routeDeliver
+			return []clusterMsg{{nodeChannel(u.via), env}}
+		}
+		return nil
+	}
+	return []clusterMsg{{clusterChannel, env}}
+}
+
+// fanout returns a delivery for every other node with members in the room
+// of the event, it must be called with the lock held
+func (c *Cluster) fanout(env envelope) []clusterMsg {
+	nodes := make(map[string]bool)
+	for _, u := range c.remote {
+		if u.room == env.Event.Room && u.via != env.Node && u.via != c.Node {
+			nodes[u.via] = true
+		}
+	}
+
+	var names []string
+	for n := range nodes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	env.Route = routeDeliver
+	var out []clusterMsg
+	for _, n := range names {
+		out = append(out, clusterMsg{nodeChannel(n), env})
+	}
+	return out
+}
+
+// Has returns true if the nick is connected to another node
+func (c *Cluster) Has(nick string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.remote[nick]
+	return ok
+}
+
+// do runs fn on the publishing connection, reconnecting as needed
+func (c *Cluster) do(fn func(rc *redisConn) error) error {
+	c.pubMu.Lock()
+	defer c.pubMu.Unlock()
+
+	if c.pub == nil {
+		rc, err := dialRedis(c.Addr, c.Password)
+		if err != nil {
+			return err
+		}
+		c.pub = rc
+	}
+
+	err := fn(c.pub)
+	if err != nil {
+		c.pub.Close()
+		c.pub = nil
+	}
+	return err
+}
+
+// publishLoop relays queued events and keeps this node's presence entries
+// in the registry
+func (c *Cluster) publishLoop() {
+	for m := range c.queue {
+		b, err := json.Marshal(m.env)
+		if err != nil {
+			errl(err, "")
+			continue
+		}
+
+		ev := m.env.Event
+		err = c.do(func(rc *redisConn) error {
+			if _, err := rc.Do("PUBLISH", m.channel, string(b)); err != nil {
+				return err
+			}
+			if m.env.Node != c.Node {
+				return nil
+			}
+			switch ev.Type {
+			case EventJoin:
+				_, err = rc.Do("HSET", presenceKey, ev.From, c.Node)
+			case EventLeave:
+				_, err = rc.Do("HDEL", presenceKey, ev.From)
+			case EventNick:
+				if _, err = rc.Do("HDEL", presenceKey, ev.From); err == nil {
+					_, err = rc.Do("HSET", presenceKey, ev.To, c.Node)
+				}
+			}
+			return err
+		})
+		if err != nil {
+			errl(fmt.Errorf("cluster publish failed: %v", err), "")
+		}
+	}
+}
+
+// heartbeat announces the node and its address then rebuilds the hash
+// ring from the nodes that announced themselves recently
+func (c *Cluster) heartbeat() {
+	for {
+		var kv, addrs []string
+		err := c.do(func(rc *redisConn) error {
+			if _, err := rc.Do("HSET", nodesKey, c.Node, strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+				return err
+			}
+			if c.Advertise != "" {
+				if _, err := rc.Do("HSET", addrsKey, c.Node, c.Advertise); err != nil {
+					return err
+				}
+			}
+			v, err := rc.Do("HGETALL", nodesKey)
+			if err != nil {
+				return err
+			}
+			kv = redisStrings(v)
+			v, err = rc.Do("HGETALL", addrsKey)
+			addrs = redisStrings(v)
+			return err
+		})
+		if err != nil {
+			errl(fmt.Errorf("cluster heartbeat failed: %v", err), "")
+		} else {
+			c.setNodes(kv, time.Now())
+			c.setAddrs(addrs)
+		}
+		time.Sleep(clusterHeartbeat)
+	}
+}
+
+// setAddrs records the chat addresses of the nodes from node, address
+// pairs
+func (c *Cluster) setAddrs(kv []string) {
+	addrs := make(map[string]string)
+	for i := 0; i+1 < len(kv); i += 2 {
+		addrs[kv[i]] = kv[i+1]
+	}
+
+	c.mu.Lock()
+	c.addrs = addrs
+	c.mu.Unlock()
+}
+
+// setNodes rebuilds the hash ring from node, last seen pairs
+func (c *Cluster) setNodes(kv []string, now time.Time) {
+	nodes := []string{c.Node}
+	for i := 0; i+1 < len(kv); i += 2 {
+		seen, err := strconv.ParseInt(kv[i+1], 10, 64)
+		if err != nil || kv[i] == c.Node || now.Sub(time.Unix(seen, 0)) > 3*clusterHeartbeat {
+			continue
+		}
+		nodes = append(nodes, kv[i])
+	}
+
+	c.mu.Lock()
+	c.ring = newHashRing(nodes)
+	c.nodes = nodes
+	c.mu.Unlock()
+}
+
+// subscribeLoop receives the events of other nodes, after every
+// (re)connect the presence registry is reloaded
+func (c *Cluster) subscribeLoop() {
+	for {
+		err := c.subscribe()
+		errl(fmt.Errorf("cluster subscription lost: %v", err), "")
+		time.Sleep(clusterRetry)
+	}
+}
+
+func (c *Cluster) subscribe() error {
+	rc, err := dialRedis(c.Addr, c.Password)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	v, err := rc.Do("HGETALL", presenceKey)
+	if err != nil {
+		return err
+	}
+	c.load(redisStrings(v), rc)
+
+	if err := rc.Send("SUBSCRIBE", clusterChannel, nodeChannel(c.Node)); err != nil {
+		return err
+	}
+	errl(nil, fmt.Sprintf("Cluster node [%s] subscribed to %s", c.Node, c.Addr))
+
+	for {
+		v, err := rc.Receive()
+		if err != nil {
+			return err
+		}
+		msg := redisStrings(v)
+		if len(msg) == 3 && msg[0] == "message" {
+			c.handle([]byte(msg[2]))
+		}
+	}
+}
+
+// load replaces the remote presence with the registry contents, entries
+// left behind by a previous run of this node are removed
+func (c *Cluster) load(kv []string, rc *redisConn) {
+	remote := make(map[string]remoteUser)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == c.Node {
+			if !c.Server.HasClient(kv[i]) {
+				rc.Do("HDEL", presenceKey, kv[i])
+			}
+			continue
+		}
+		remote[kv[i]] = remoteUser{via: kv[i+1]}
+	}
+
+	c.mu.Lock()
+	c.remote = remote
+	c.mu.Unlock()
+}
+
+// handle applies an event relayed from another node, as the owner of a
+// room it also delivers the event to the other nodes with members
+func (c *Cluster) handle(payload []byte) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		errl(err, "")
+		return
+	}
+	if env.Node == c.Node {
+		return
+	}
+
+	ev := env.Event
+	c.mu.Lock()
+	switch env.Route {
+	case routeOwner:
+		c.enqueue(c.fanout(env))
+	case "":
+		switch ev.Type {
+		case EventJoin:
+			c.remote[ev.From] = remoteUser{via: env.Node, room: ev.Room}
+		case EventLeave:
+			if u, ok := c.remote[ev.From]; ok && u.via == env.Node && u.room == ev.Room {
+				delete(c.remote, ev.From)
+			}
+		case EventNick:
+			u := c.remote[ev.From]
+			delete(c.remote, ev.From)
+			c.remote[ev.To] = remoteUser{via: env.Node, room: u.room}
+		}
+	}
+	c.mu.Unlock()
+
+	c.Server.applyRemote(ev)
+}
+
+// HasClient returns true if the nick is connected to this node
+func (s *Server) HasClient(nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientExists(nick)
+}
+
+// applyRemote delivers an event from another node to the local clients it
+// concerns, without relaying it again
+func (s *Server) applyRemote(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev.Type {
+	case EventMessage, EventService, EventNotice:
+		if r, ok := s.Rooms[ev.Room]; ok {
+			for _, c := range r.Clients {
+				c.Send(ev)
+			}
+			r.record(ev)
+			s.notifyKeywords(r, ev)
+		}
+	case EventPresence:
+		if r, ok := s.Rooms[ev.Room]; ok && r.Notices {
+			for _, c := range r.Clients {
+				c.Send(ev)
+			}
+		}
+	case EventBlast:
+		for _, c := range s.Clients {
+			c.Send(ev)
+		}
+	case EventDirect:
+		if c, ok := s.Clients[ev.To]; ok {
+			c.Send(ev)
+		}
+	}
+}
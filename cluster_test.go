@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestRedisReceive(t *testing.T) {
+	c, p := net.Pipe()
+	rc := &redisConn{conn: c, buf: bufio.NewReader(c)}
+
+	go p.Write([]byte("*3\r\n$7\r\nmessage\r\n$15\r\ntinychat:events\r\n$2\r\nhi\r\n"))
+	v, err := rc.Receive()
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	msg := redisStrings(v)
+	if len(msg) != 3 || msg[0] != "message" || msg[2] != "hi" {
+		t.Errorf("unexpected reply %v", msg)
+	}
+
+	go p.Write([]byte("-ERR wrong type\r\n"))
+	if _, err := rc.Receive(); err == nil || err.Error() != "ERR wrong type" {
+		t.Errorf("expected redis error, got %v", err)
+	}
+}
+
+func TestClusterHandle(t *testing.T) {
+	serv := NewServer()
+	c := NewCluster(serv, "node-1", "", "")
+	serv.Cluster = c
+
+	c1, p1 := net.Pipe()
+	batman := &Client{nick: "batman", Conns: []*Conn{NewConn(c1)}}
+	serv.JoinRoom("gotham", batman)
+
+	relay := func(node string, ev Event) {
+		b, _ := json.Marshal(envelope{Node: node, Event: ev})
+		c.handle(b)
+	}
+
+	relay("node-2", Event{Type: EventJoin, From: "robin", Room: "gotham"})
+	if !c.Has("robin") {
+		t.Errorf("expected remote nick to be registered")
+	}
+
+	if err := serv.ChangeNick("batman", "robin"); err == nil {
+		t.Errorf("expected remote nick to be taken")
+	}
+
+	go relay("node-2", Event{Type: EventMessage, Time: "now", From: "robin", Room: "gotham", Text: "holy relay"})
+	line, _ := bufio.NewReader(p1).ReadString('\n')
+	if line != "[now:robin] holy relay\r\n" {
+		t.Errorf("expected remote message to be delivered, got [%s]", line)
+	}
+
+	relay("node-1", Event{Type: EventJoin, From: "alfred", Room: "gotham"})
+	if c.Has("alfred") {
+		t.Errorf("expected own events to be ignored")
+	}
+
+	relay("node-2", Event{Type: EventLeave, From: "robin", Room: "gotham"})
+	if c.Has("robin") {
+		t.Errorf("expected remote nick to be removed")
+	}
+}
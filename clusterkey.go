@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// clusterMaxAge is how old a sealed cluster message may be, it allows for
+// the clocks of the nodes to drift a little
+const clusterMaxAge = 30 * time.Second
+
+var errClusterSeal = errors.New("cluster message is not sealed with the cluster secret")
+
+// clusterKey seals the messages the nodes of a cluster exchange with the
+// secret they share, only nodes that know it can send or read them
+type clusterKey struct {
+	aead cipher.AEAD
+}
+
+// newClusterKey returns the key of secret, it may not be empty
+func newClusterKey(secret string) (*clusterKey, error) {
+	if secret == "" {
+		return nil, errors.New("a cluster secret is required")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterKey{aead: aead}, nil
+}
+
+// seal encrypts b along with when it was sealed, bound to what it is for
+// so it can't be replayed as something else
+func (k *clusterKey) seal(b []byte, what string) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plain := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(plain, uint64(time.Now().UnixNano()))
+	copy(plain[8:], b)
+	return k.aead.Seal(nonce, nonce, plain, []byte(what)), nil
+}
+
+// open decrypts what seal sealed for the same purpose, anything sealed
+// with another secret, for something else, or too long ago is refused
+func (k *clusterKey) open(b []byte, what string) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(b) < n {
+		return nil, errClusterSeal
+	}
+	plain, err := k.aead.Open(nil, b[:n], b[n:], []byte(what))
+	if err != nil || len(plain) < 8 {
+		return nil, errClusterSeal
+	}
+	sealed := time.Unix(0, int64(binary.BigEndian.Uint64(plain)))
+	if age := time.Since(sealed); age > clusterMaxAge || age < -clusterMaxAge {
+		return nil, errors.New("cluster message is too old, check the clocks of the nodes")
+	}
+	return plain[8:], nil
+}
@@ -0,0 +1,54 @@
+// echobot repeats what it's asked to, say "!echo text" in its room or send
+// it a private message, it answers in the room with a notice so it never
+// answers another bot
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jaredfolkins/telnacl/client"
+)
+
+// reply returns what the bot answers an event with
+func reply(ev client.Event) (string, bool) {
+	switch ev.Type {
+	case client.EventMessage:
+		if strings.HasPrefix(ev.Text, "!echo ") {
+			return strings.TrimPrefix(ev.Text, "!echo "), true
+		}
+	case client.EventDirect:
+		return ev.Text, true
+	}
+	return "", false
+}
+
+func handle(b *client.Bot, ev client.Event) {
+	text, ok := reply(ev)
+	if !ok {
+		return
+	}
+	var err error
+	if ev.Type == client.EventDirect {
+		err = b.Msg(ev.From, text)
+	} else {
+		err = b.Notice(text)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func main() {
+	b, err := client.DialEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+	if room := os.Getenv("TCBotRoom"); room != "" {
+		b.Join(room)
+	}
+	log.Printf("Connected as [%s]\n", b.Name)
+	log.Println(b.Run(handle))
+}
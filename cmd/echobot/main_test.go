@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaredfolkins/telnacl/client"
+)
+
+func TestReply(t *testing.T) {
+	tests := []struct {
+		ev   client.Event
+		want string
+		ok   bool
+	}{
+		{client.Event{Type: client.EventMessage, Text: "!echo to the batcave"}, "to the batcave", true},
+		{client.Event{Type: client.EventMessage, Text: "hello"}, "", false},
+		{client.Event{Type: client.EventDirect, Text: "hello"}, "hello", true},
+		{client.Event{Type: client.EventJoin, From: "robin"}, "", false},
+		{client.Event{Type: client.EventNotice, Text: "!echo to the batcave"}, "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := reply(tt.ev); got != tt.want || ok != tt.ok {
+			t.Errorf("expected %q %t for %+v, got %q %t", tt.want, tt.ok, tt.ev, got, ok)
+		}
+	}
+}
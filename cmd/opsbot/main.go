@@ -0,0 +1,59 @@
+// opsbot creates a room and makes its owners ops as they join, the bot has
+// to be the first into the room so it owns it
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jaredfolkins/telnacl/client"
+)
+
+// opsBot ops the nicks in owners when they join room
+type opsBot struct {
+	room   string
+	owners map[string]bool
+}
+
+func newOpsBot(room, owners string) *opsBot {
+	o := &opsBot{room: strings.ToLower(room), owners: make(map[string]bool)}
+	for _, n := range strings.Split(owners, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			o.owners[n] = true
+		}
+	}
+	return o
+}
+
+func (o *opsBot) handle(b *client.Bot, ev client.Event) {
+	switch ev.Type {
+	case client.EventJoin:
+		if ev.Room == o.room && o.owners[ev.From] {
+			if err := b.Command("op", ev.From); err != nil {
+				log.Println(err)
+			}
+		}
+	case client.EventText:
+		log.Println(ev.Text)
+	}
+}
+
+func main() {
+	room := os.Getenv("TCOpsRoom")
+	if room == "" {
+		log.Fatal("TCOpsRoom is not set")
+	}
+	o := newOpsBot(room, os.Getenv("TCOpsOwners"))
+
+	b, err := client.DialEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Join(room); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Connected as [%s], opping %d owner(s) of [%s]\n", b.Name, len(o.owners), o.room)
+	log.Println(b.Run(o.handle))
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type action int
+
+const (
+	actNone action = iota
+	actSubmit
+	actEOF
+	actCandidates
+)
+
+// completer returns the candidates for a word, first is true if the word
+// is the first on the line
+type completer func(word string, first bool) []string
+
+// lineEditor is a minimal emacs-style line editor with history
+type lineEditor struct {
+	buf        []rune
+	pos        int
+	history    [][]rune
+	hist       int
+	esc        []byte
+	utf        []byte
+	complete   completer
+	candidates []string
+}
+
+func newLineEditor(c completer) *lineEditor {
+	return &lineEditor{complete: c}
+}
+
+// String returns the current contents of the edit buffer
+func (e *lineEditor) String() string { return string(e.buf) }
+
+// Len returns the length of the edit buffer in runes
+func (e *lineEditor) Len() int { return len(e.buf) }
+
+// Pos returns the cursor position in runes
+func (e *lineEditor) Pos() int { return e.pos }
+
+// Candidates returns the completion candidates from the last ambiguous tab
+func (e *lineEditor) Candidates() []string { return e.candidates }
+
+// Feed processes a single byte of input, returning the submitted line when
+// the action is actSubmit
+func (e *lineEditor) Feed(b byte) (string, action) {
+	// escape sequences, we only care about CSI
+	if len(e.esc) > 0 {
+		e.esc = append(e.esc, b)
+		if len(e.esc) == 2 && b != '[' && b != 'O' {
+			e.esc = nil
+			return "", actNone
+		}
+		if len(e.esc) > 2 && (b >= 'A' && b <= 'Z' || b == '~') {
+			seq := string(e.esc[2:])
+			e.esc = nil
+			e.escape(seq)
+		}
+		return "", actNone
+	}
+
+	// multi byte utf-8
+	if b >= 0x80 {
+		e.utf = append(e.utf, b)
+		if utf8.FullRune(e.utf) {
+			r, _ := utf8.DecodeRune(e.utf)
+			e.utf = nil
+			e.insert(r)
+		}
+		return "", actNone
+	}
+
+	switch b {
+	case '\r', '\n':
+		line := string(e.buf)
+		if strings.TrimSpace(line) != "" {
+			e.history = append(e.history, e.buf)
+		}
+		e.buf = nil
+		e.pos = 0
+		e.hist = len(e.history)
+		return line, actSubmit
+	case 3: // ctrl-c
+		return "", actEOF
+	case 4: // ctrl-d
+		if len(e.buf) == 0 {
+			return "", actEOF
+		}
+		e.delete()
+	case 1: // ctrl-a
+		e.pos = 0
+	case 5: // ctrl-e
+		e.pos = len(e.buf)
+	case 2: // ctrl-b
+		e.left()
+	case 6: // ctrl-f
+		e.right()
+	case 16: // ctrl-p
+		e.prev()
+	case 14: // ctrl-n
+		e.next()
+	case 11: // ctrl-k
+		e.buf = e.buf[:e.pos]
+	case 21: // ctrl-u
+		e.buf = append([]rune{}, e.buf[e.pos:]...)
+		e.pos = 0
+	case 23: // ctrl-w
+		start := e.pos
+		for start > 0 && e.buf[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && e.buf[start-1] != ' ' {
+			start--
+		}
+		e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+		e.pos = start
+	case 127, 8: // backspace
+		if e.pos > 0 {
+			e.pos--
+			e.delete()
+		}
+	case '\t':
+		if e.tab() {
+			return "", actCandidates
+		}
+	case 27:
+		e.esc = []byte{b}
+	default:
+		if b >= 32 {
+			e.insert(rune(b))
+		}
+	}
+	return "", actNone
+}
+
+// escape handles the body of a CSI sequence
+func (e *lineEditor) escape(seq string) {
+	switch seq {
+	case "A":
+		e.prev()
+	case "B":
+		e.next()
+	case "C":
+		e.right()
+	case "D":
+		e.left()
+	case "H", "1~":
+		e.pos = 0
+	case "F", "4~":
+		e.pos = len(e.buf)
+	case "3~":
+		e.delete()
+	}
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+func (e *lineEditor) delete() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+func (e *lineEditor) left() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEditor) right() {
+	if e.pos < len(e.buf) {
+		e.pos++
+	}
+}
+
+func (e *lineEditor) prev() {
+	if e.hist > 0 {
+		e.hist--
+		e.buf = append([]rune{}, e.history[e.hist]...)
+		e.pos = len(e.buf)
+	}
+}
+
+func (e *lineEditor) next() {
+	if e.hist < len(e.history) {
+		e.hist++
+	}
+	if e.hist == len(e.history) {
+		e.buf = nil
+	} else {
+		e.buf = append([]rune{}, e.history[e.hist]...)
+	}
+	e.pos = len(e.buf)
+}
+
+// tab completes the word under the cursor, it returns true when the
+// candidates are ambiguous and should be shown to the user
+func (e *lineEditor) tab() bool {
+	if e.complete == nil {
+		return false
+	}
+
+	start := e.pos
+	for start > 0 && e.buf[start-1] != ' ' {
+		start--
+	}
+	word := string(e.buf[start:e.pos])
+	first := strings.TrimSpace(string(e.buf[:start])) == ""
+
+	e.candidates = e.complete(word, first)
+	switch len(e.candidates) {
+	case 0:
+		return false
+	case 1:
+		e.replace(start, e.candidates[0]+" ")
+		return false
+	}
+
+	prefix := commonPrefix(e.candidates)
+	if len([]rune(prefix)) > len([]rune(word)) {
+		e.replace(start, prefix)
+		return false
+	}
+	return true
+}
+
+// replace swaps the text between start and the cursor for s
+func (e *lineEditor) replace(start int, s string) {
+	tail := append([]rune{}, e.buf[e.pos:]...)
+	e.buf = append(append(e.buf[:start], []rune(s)...), tail...)
+	e.pos = start + len([]rune(s))
+}
+
+// complete returns the sorted options that start with the prefix
+func complete(prefix string, options []string) []string {
+	var out []string
+	for _, o := range options {
+		if strings.HasPrefix(strings.ToLower(o), strings.ToLower(prefix)) {
+			out = append(out, o)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// commonPrefix returns the longest prefix shared by every string
+func commonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	p := []rune(ss[0])
+	for _, s := range ss[1:] {
+		r := []rune(s)
+		i := 0
+		for i < len(p) && i < len(r) && p[i] == r[i] {
+			i++
+		}
+		p = p[:i]
+	}
+	return string(p)
+}
+
+// nickRe matches the [timestamp:nick] prefix of a chat line
+var nickRe = regexp.MustCompile(`^\[[^\]]*:([^:\]\s]+)\]`)
+
+// nickSet keeps track of nicks seen in server output for completion
+type nickSet struct {
+	mu    sync.Mutex
+	nicks map[string]bool
+}
+
+// Learn records the nick found in a chat line, if any
+func (n *nickSet) Learn(line string) {
+	m := nickRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nicks[m[1]] = true
+}
+
+// List returns every nick seen so far
+func (n *nickSet) List() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []string
+	for k := range n.nicks {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func feed(e *lineEditor, s string) (string, action) {
+	var line string
+	var act action
+	for _, b := range []byte(s) {
+		line, act = e.Feed(b)
+	}
+	return line, act
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	e := newLineEditor(nil)
+
+	line, act := feed(e, "hi batman\r")
+	if act != actSubmit || line != "hi batman" {
+		t.Errorf("expected [hi batman] to be submitted, got [%s]", line)
+	}
+
+	feed(e, "\x1b[A")
+	if e.String() != "hi batman" {
+		t.Errorf("expected history to recall [hi batman], got [%s]", e.String())
+	}
+
+	feed(e, "\x01X")
+	if e.String() != "Xhi batman" {
+		t.Errorf("expected insert at start of line, got [%s]", e.String())
+	}
+}
+
+func TestLineEditorComplete(t *testing.T) {
+	nicks := []string{"batman", "bane"}
+	e := newLineEditor(func(word string, first bool) []string {
+		if first {
+			return complete(word, commands)
+		}
+		return complete(word, nicks)
+	})
+
+	feed(e, "/remi\t")
+	if e.String() != "/remind " {
+		t.Errorf("expected command to complete, got [%s]", e.String())
+	}
+
+	e = newLineEditor(e.complete)
+	feed(e, "hi ba\t")
+	if e.String() != "hi ba" {
+		t.Errorf("expected ambiguous nick to stay put, got [%s]", e.String())
+	}
+
+	if _, act := e.Feed('\t'); act != actCandidates {
+		t.Errorf("expected candidates to be listed")
+	}
+
+	feed(e, "t\t")
+	if e.String() != "hi batman " {
+		t.Errorf("expected nick to complete, got [%s]", e.String())
+	}
+}
+
+func TestNickSetLearn(t *testing.T) {
+	n := &nickSet{nicks: make(map[string]bool)}
+	n.Learn("[2018-10-01T12:00:00-07:00:batman] hi freeze")
+	n.Learn("Joining room gotham")
+
+	l := n.List()
+	if len(l) != 1 || l[0] != "batman" {
+		t.Errorf("expected only [batman] to be learned, got %v", l)
+	}
+}
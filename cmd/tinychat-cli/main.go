@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const prompt = "> "
+
+// commands are the server commands offered for tab completion
+var commands = []string{"/autojoin", "/away", "/ban", "/blast", "/color", "/complete", "/deop", "/drain", "/email", "/emoji", "/emsg", "/expire", "/fav", "/find", "/forget", "/gate", "/ham", "/held", "/help", "/ids", "/json", "/key", "/lang", "/linelimit", "/login", "/maintenance", "/memo", "/memos", "/motd", "/msg", "/mydata", "/nick", "/notice", "/notify", "/op", "/paste", "/ping", "/plain", "/poll", "/pong", "/presence", "/pubkey", "/public", "/push", "/quit", "/quota", "/react", "/read", "/register", "/remind", "/reply", "/restart", "/resume", "/role", "/roll", "/room", "/roominfo", "/rooms", "/schedule", "/scheduled", "/sessions", "/shutdown", "/snapshot", "/spam", "/spamfilter", "/star", "/starred", "/status", "/timefmt", "/topic", "/tz", "/unban", "/upload", "/uptime", "/version", "/vote", "/wall", "/watch", "/who", "/whoami", "/whois", "/width"}
+
+// Term is the state of the local terminal, output is serialized so server
+// lines and the prompt redraw don't interleave
+type Term struct {
+	mu    sync.Mutex
+	saved string
+	ed    *lineEditor
+}
+
+// raw puts the terminal in raw mode, saving the previous state for restore
+func (t *Term) raw() error {
+	out, err := stty("-g")
+	if err != nil {
+		return err
+	}
+	t.saved = strings.TrimSpace(out)
+	_, err = stty("raw", "-echo")
+	return err
+}
+
+// restore puts the terminal back the way we found it
+func (t *Term) restore() {
+	if t.saved != "" {
+		stty(t.saved)
+	}
+}
+
+// redraw clears the current line and prints the prompt and edit buffer
+func (t *Term) redraw() {
+	fmt.Print("\r\x1b[K" + prompt + t.ed.String())
+	if back := t.ed.Len() - t.ed.Pos(); back > 0 {
+		fmt.Printf("\x1b[%dD", back)
+	}
+}
+
+// Println prints a line above the prompt
+func (t *Term) Println(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Print("\r\x1b[K" + s + "\r\n")
+	t.redraw()
+}
+
+// stty runs stty against the controlling terminal
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readServer prints every line the server sends and learns nicks along the way
+func readServer(conn net.Conn, t *Term, nicks *nickSet) {
+	buf := bufio.NewReader(conn)
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			t.Println("Disconnected.")
+			t.restore()
+			os.Exit(0)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if f := strings.Fields(line); len(f) == 3 && f[0] == "POW" {
+			bits, err := strconv.Atoi(f[2])
+			if err == nil {
+				t.Println("Solving the server's proof of work...")
+				conn.Write([]byte(solvePOW(f[1], bits) + "\r\n"))
+				continue
+			}
+		}
+		// answer pings at once so the server times the round trip
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "PING" {
+			conn.Write([]byte("/pong " + f[1] + "\r\n"))
+			continue
+		}
+		nicks.Learn(line)
+		t.Println(line)
+	}
+}
+
+// solvePOW finds a number N where the SHA-256 of challenge:N starts with
+// bits zero bits
+func solvePOW(challenge string, bits int) string {
+	for n := 0; ; n++ {
+		answer := strconv.Itoa(n)
+		sum := sha256.Sum256([]byte(challenge + ":" + answer))
+		ok := true
+		for i := 0; i < bits && ok; i++ {
+			ok = sum[i/8]&(0x80>>uint(i%8)) == 0
+		}
+		if ok {
+			return answer
+		}
+	}
+}
+
+func main() {
+	host := os.Getenv("TCHost")
+	if len(host) == 0 {
+		host = "localhost"
+	}
+
+	port := os.Getenv("TCPort")
+	if len(port) == 0 {
+		port = "8091"
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to connect: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	nicks := &nickSet{nicks: make(map[string]bool)}
+	t := &Term{ed: newLineEditor(func(word string, first bool) []string {
+		if first && strings.HasPrefix(word, "/") {
+			return complete(word, commands)
+		}
+		return complete(word, nicks.List())
+	})}
+
+	if err := t.raw(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to set raw mode: %v\n", err)
+		os.Exit(1)
+	}
+	defer t.restore()
+
+	go readServer(conn, t, nicks)
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		line, act := t.ed.Feed(b)
+		switch act {
+		case actSubmit:
+			fmt.Print("\r\x1b[K" + prompt + line + "\r\n")
+			conn.Write([]byte(line + "\r\n"))
+		case actEOF:
+			t.mu.Unlock()
+			fmt.Print("\r\n")
+			return
+		case actCandidates:
+			fmt.Print("\r\n" + strings.Join(t.ed.Candidates(), "  ") + "\r\n")
+		}
+		t.redraw()
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestSolvePOW(t *testing.T) {
+	answer := solvePOW("gotham", 12)
+	sum := sha256.Sum256([]byte("gotham:" + answer))
+	if sum[0] != 0 || sum[1]&0xf0 != 0 {
+		t.Errorf("expected 12 zero bits, got %x for %s", sum[:2], answer)
+	}
+}
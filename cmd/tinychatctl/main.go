@@ -0,0 +1,75 @@
+// tinychatctl runs administration commands against a running server over
+// its control socket, set TCControlSocket and TCControlToken as the server
+// has them
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// request and reply mirror the server's control protocol
+type request struct {
+	Token string   `json:"token"`
+	Cmd   string   `json:"cmd"`
+	Args  []string `json:"args,omitempty"`
+}
+
+type reply struct {
+	OK    bool   `json:"ok"`
+	Text  string `json:"text,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const timeout = 10 * time.Second
+
+// run sends one command over the socket at path and returns its output
+func run(path, token, cmd string, args []string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(request{Token: token, Cmd: cmd, Args: args}); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return "", err
+	}
+	var rep reply
+	if err := json.Unmarshal(line, &rep); err != nil {
+		return "", err
+	}
+	if !rep.OK {
+		return "", errors.New(rep.Error)
+	}
+	return rep.Text, nil
+}
+
+func main() {
+	path := os.Getenv("TCControlSocket")
+	if path == "" {
+		path = "./tinychat.sock"
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: tinychatctl <command> [args], try tinychatctl help")
+		os.Exit(2)
+	}
+
+	out, err := run(path, os.Getenv("TCControlToken"), os.Args[1], os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if out != "" {
+		fmt.Println(out)
+	}
+}
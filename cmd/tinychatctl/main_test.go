@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychatctl")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	sock := path.Join(dir, "control.sock")
+
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req request
+			line, _ := bufio.NewReader(conn).ReadBytes('\n')
+			json.Unmarshal(line, &req)
+			rep := reply{OK: req.Token == "s3cret", Text: req.Cmd + " " + strings.Join(req.Args, " ")}
+			if !rep.OK {
+				rep.Error = "bad token"
+			}
+			json.NewEncoder(conn).Encode(rep)
+			conn.Close()
+		}
+	}()
+
+	out, err := run(sock, "s3cret", "kick", []string{"joker", "no", "jokes"})
+	if err != nil || out != "kick joker no jokes" {
+		t.Errorf("expected command to be sent, got %q %v", out, err)
+	}
+	if _, err := run(sock, "wrong", "clients", nil); err == nil || err.Error() != "bad token" {
+		t.Errorf("expected bad token, got %v", err)
+	}
+}
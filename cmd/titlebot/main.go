@@ -0,0 +1,89 @@
+// titlebot posts the title of web pages linked in its room
+package main
+
+import (
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jaredfolkins/telnacl/client"
+)
+
+// maxPage is how much of a page is read looking for its title
+const maxPage = 64 << 10
+
+var (
+	linkRe  = regexp.MustCompile(`https?://[^\s<>"]+`)
+	titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// title returns the title of an HTML page
+func title(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxPage))
+	if err != nil {
+		return "", err
+	}
+	m := titleRe.FindSubmatch(b)
+	if m == nil {
+		return "", errors.New("no title")
+	}
+	t := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	if t == "" {
+		return "", errors.New("empty title")
+	}
+	return t, nil
+}
+
+// fetchTitle gets a page and returns its title
+func fetchTitle(url string) (string, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "text/html") {
+		return "", errors.New("not a web page")
+	}
+	return title(resp.Body)
+}
+
+func handle(b *client.Bot, ev client.Event) {
+	if ev.Type != client.EventMessage {
+		return
+	}
+	for _, url := range linkRe.FindAllString(ev.Text, 3) {
+		t, err := fetchTitle(url)
+		if err != nil {
+			log.Printf("%s: %v\n", url, err)
+			continue
+		}
+		if err := b.Notice("Title: " + t); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func main() {
+	b, err := client.DialEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+	if room := os.Getenv("TCBotRoom"); room != "" {
+		b.Join(room)
+	}
+	log.Printf("Connected as [%s]\n", b.Name)
+	log.Println(b.Run(handle))
+}
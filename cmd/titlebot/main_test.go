@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{"<html><head><title>The Dark Knight</title></head></html>", "The Dark Knight"},
+		{"<TITLE lang=en>\n  Wayne &amp; Sons\n</TITLE>", "Wayne & Sons"},
+		{"<html><body>no title</body></html>", ""},
+	}
+	for _, tt := range tests {
+		got, _ := title(strings.NewReader(tt.page))
+		if got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestFetchTitle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		fmt.Fprint(w, "<title>Gotham Gazette</title>")
+	}))
+	defer ts.Close()
+
+	if got, err := fetchTitle(ts.URL + "/news"); err != nil || got != "Gotham Gazette" {
+		t.Errorf("expected title, got %q %v", got, err)
+	}
+	if _, err := fetchTitle(ts.URL + "/image"); err == nil {
+		t.Errorf("expected images to be skipped")
+	}
+	if links := linkRe.FindAllString("see https://gotham.gov/news, and http://x.io", -1); len(links) != 2 {
+		t.Errorf("expected two links, got %v", links)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiDim   = "\x1b[90m"
+	ansiBold  = "\x1b[1m"
+)
+
+// nickColors are the palette nicks are hashed into
+var nickColors = []string{"31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96"}
+
+// nickColor returns a stable color escape for the nick
+func nickColor(nick string) string {
+	h := fnv.New32a()
+	h.Write([]byte(nick))
+	return "\x1b[" + nickColors[h.Sum32()%uint32(len(nickColors))] + "m"
+}
+
+// SetColor switches ANSI color output on or off for the connection
+func (c *Conn) SetColor(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.color, v)
+}
+
+// Color returns true if the connection receives ANSI colored output
+func (c *Conn) Color() bool {
+	return atomic.LoadInt32(&c.color) == 1
+}
+
+// Colored renders the event for humans with ANSI colors, timestamps are
+// dimmed, nicks get a color of their own, notices are bold, and the
+// markdown of messages is rendered
+func (ev Event) Colored() string {
+	switch ev.Type {
+	case EventMessage, EventBlast:
+		body := markdown(ev.body())
+		if ev.Code {
+			body = ev.body()
+		}
+		out := fmt.Sprintf("[%s%s%s:%s%s%s] %s", ansiDim, ev.Time, ansiReset, nickColor(ev.From), ev.From, ansiReset, body)
+		return strings.TrimSpace(out) + "\r\n"
+	case EventDirect:
+		out := fmt.Sprintf("[%s%s%s:%s%s%s -> %s%s%s] %s", ansiDim, ev.Time, ansiReset, nickColor(ev.From), ev.From, ansiReset, nickColor(ev.To), ev.To, ansiReset, markdown(ev.directText()))
+		return strings.TrimSpace(out) + "\r\n"
+	case EventService:
+		out := fmt.Sprintf("[%s%s%s:%s%s (service)%s] %s", ansiDim, ev.Time, ansiReset, ansiBold, ev.From, ansiReset, ev.Text)
+		return strings.TrimSpace(out) + "\r\n"
+	case EventNotice:
+		out := fmt.Sprintf("%s-!-%s [%s%s%s:%s%s%s] %s", ansiBold, ansiReset, ansiDim, ev.Time, ansiReset, ansiBold, ev.From, ansiReset, ev.Text)
+		return strings.TrimSpace(out) + "\r\n"
+	case EventText:
+		text := strings.TrimRight(ev.Text, "\r\n")
+		if text == "" {
+			return ev.Text
+		}
+		return ansiBold + text + ansiReset + ev.Text[len(text):]
+	}
+	return ev.String()
+}
@@ -0,0 +1,1119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is a command clients run by sending /name followed by its args,
+// Args describes them with <required> and [optional] words, Role is the
+// least trusted role that may run it
+type Command struct {
+	Name     string
+	Args     string
+	Help     string
+	Examples []string
+	Role     string
+	Run      Handler
+
+	// Metered returns true if running the command with the input sends a
+	// message to a room or a user, which counts against the sender's
+	// quota, nil never does
+	Metered func(in *Input) bool
+}
+
+// minArgs returns how many args the command needs
+func (c *Command) minArgs() int {
+	return strings.Count(c.Args, "<")
+}
+
+// Usage returns how the command is used
+func (c *Command) Usage() string {
+	return strings.TrimSpace(c.Name + " " + c.Args)
+}
+
+// Registry holds the commands built in to the server and added by plugins,
+// in the order they were registered
+type Registry struct {
+	mu    sync.RWMutex
+	cmds  map[string]*Command
+	order []string
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{cmds: make(map[string]*Command)}
+}
+
+// Register adds a command, the name may be given without its slash
+func (r *Registry) Register(c *Command) error {
+	c.Name = "/" + strings.TrimPrefix(c.Name, "/")
+	if c.Name == "/" || c.Run == nil {
+		return fmt.Errorf("command [%s] needs a name and a handler", c.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cmds[c.Name]; ok {
+		return fmt.Errorf("command [%s] is already registered", c.Name)
+	}
+	r.cmds[c.Name] = c
+	r.order = append(r.order, c.Name)
+	return nil
+}
+
+// Unregister removes a command
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cmds[name]; !ok {
+		return
+	}
+	delete(r.cmds, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Lookup returns the command by name
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.cmds[name]
+	return c, ok
+}
+
+// List returns the commands in the order they were registered
+func (r *Registry) List() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Command, 0, len(r.order))
+	for _, n := range r.order {
+		out = append(out, r.cmds[n])
+	}
+	return out
+}
+
+// Names returns the command names sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.order))
+	out = append(out, r.order...)
+	sort.Strings(out)
+	return out
+}
+
+// Help returns the help text of every command
+func (r *Registry) Help() string {
+	var b strings.Builder
+	b.WriteString("{no flag needed}\nsend a message to the room you are in\n(example: hi freeze, i'm batman)\n\n")
+	for _, c := range r.List() {
+		help := c.Help
+		if rank(c.Role) > rank(RoleGuest) {
+			help += ", " + rolePlurals[c.Role] + " only"
+		}
+		fmt.Fprintf(&b, "%s\n%s\n", c.Usage(), help)
+		for _, ex := range c.Examples {
+			fmt.Fprintf(&b, "(example: %s)\n", ex)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// argRe matches a <required> or [optional] argument of a usage
+var argRe = regexp.MustCompile(`<[^>]*>|\[[^\]]*\]`)
+
+// CommandHelp returns the usage, arguments, role and examples of a single
+// command, the role is the one TCPermissions may have changed it to
+func (s *Server) CommandHelp(name string) (string, error) {
+	name = "/" + strings.TrimPrefix(strings.ToLower(name), "/")
+	c, ok := s.Commands.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("no command named [%s], /help lists them all\r\n", name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Usage: %s\r\n%s\r\n", c.Usage(), c.Help)
+	for _, arg := range argRe.FindAllString(c.Args, -1) {
+		kind := "required"
+		if strings.HasPrefix(arg, "[") {
+			kind = "optional"
+		}
+		if choices := strings.Split(strings.Trim(arg, "<>[]"), "|"); len(choices) > 1 {
+			kind += ", one of " + strings.Join(choices, ", ")
+		}
+		fmt.Fprintf(&b, "  %s %s\r\n", arg, kind)
+	}
+	if role := s.permission(c.Name, c.Role); rank(role) > rank(RoleGuest) {
+		fmt.Fprintf(&b, "Only %s can use it\r\n", rolePlurals[role])
+	} else {
+		b.WriteString("Anyone can use it\r\n")
+	}
+	for _, ex := range c.Examples {
+		fmt.Fprintf(&b, "(example: %s)\r\n", ex)
+	}
+	return b.String(), nil
+}
+
+// Dispatch runs the command the input names, anything that isn't a
+// command is sent to the client's room
+func (s *Server) Dispatch(in *Input) {
+	if in.Command != "" {
+		if c, ok := s.Commands.Lookup(in.Command); ok {
+			s.runCommand(c, in)
+			return
+		}
+		if !s.authorize(in.Client, in.Command, RoleGuest) {
+			return
+		}
+		if out, ok := s.Plugins.Command(in.Client.Nick(), in.Args); ok {
+			in.Client.Write(out)
+			return
+		}
+	}
+	err := s.Message(in.Args, in.Client)
+	if err == errMuted || err == errRestricted {
+		in.Client.Write(err.Error())
+	}
+	errl(err, "Message sent to room successfully")
+}
+
+// runCommand checks the role and args of the command before running it
+func (s *Server) runCommand(c *Command, in *Input) {
+	if !s.authorize(in.Client, c.Name, c.Role) {
+		return
+	}
+	if len(in.Args)-1 < c.minArgs() {
+		in.Client.Write(fmt.Sprintf("Unable to run %s, use %s\r\n", c.Name, c.Usage()))
+		return
+	}
+	c.Run(in)
+}
+
+// onOff returns true for on, and an error for anything but on or off
+func onOff(args []string, what string) (bool, error) {
+	if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+		return false, fmt.Errorf("Unable to %s, use %s on or %s off\r\n", what, args[0], args[0])
+	}
+	return args[1] == "on", nil
+}
+
+// reply writes the error if there is one, or else the text
+func reply(cl *Client, text string, err error) {
+	if err != nil {
+		cl.Write(err.Error())
+	} else if text != "" {
+		cl.Write(text)
+	}
+}
+
+// registerBuiltins registers the commands built in to the server, in the
+// order they are listed by /help
+func (s *Server) registerBuiltins() {
+	builtins := []*Command{
+		{
+			Name:     "/help",
+			Args:     "[command]",
+			Help:     "prints this banner, or the usage, arguments, and role of a single command",
+			Examples: []string{"/help", "/help nick"},
+			Run: func(in *Input) {
+				if len(in.Args) < 2 {
+					in.Client.Write(s.Banner(in.Client.Nick(), in.Client.Lang()))
+					return
+				}
+				out, err := s.CommandHelp(in.Args[1])
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/quit",
+			Args:     "[reason]",
+			Help:     "quits the application, your room is told why if you give a reason",
+			Examples: []string{"/quit", "/quit brb"},
+			Run: func(in *Input) {
+				s.CloseClient(in.Client, in.Conn, strings.Join(in.Args[1:], " "))
+			},
+		},
+		{
+			Name:     "/nick",
+			Args:     "[nick]",
+			Help:     "sets your nickname",
+			Examples: []string{"/nick batman"},
+			Run: func(in *Input) {
+				from := in.Client.Nick()
+				if len(in.Args) < 2 {
+					in.Client.Write(fmt.Sprintf("Nick unchanged and is currently [%s] \r\n", from))
+					return
+				}
+				err := s.ChangeNick(from, in.Args[1])
+				reply(in.Client, fmt.Sprintf("Nick changed from [%s] to [%s]\r\n", from, in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/room",
+			Args:     "<room>",
+			Help:     "change chat room, only 1 room may be joined, quote names with spaces, a number joins that room of /rooms and an alias that favorite of /fav",
+			Examples: []string{"/room gotham", "/room \"wayne manor\"", "/room 3", "/room g"},
+			Run: func(in *Input) {
+				args, err := in.Quoted(1)
+				if err == nil && len(args) != 1 {
+					err = fmt.Errorf("quote room names with spaces, /room \"gotham city\"\r\n")
+				}
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				room := s.shortcut(in.Client, args[0])
+				err = s.JoinRoom(room, in.Client)
+				reply(in.Client, fmt.Sprintf("Joining room %s\r\n", roomKey(room)), err)
+				// bots read the room from its events, they aren't sent the summary
+				if summary, err := s.RoomSummary(in.Client); err == nil && !in.Client.Bot() {
+					in.Client.Write(summary)
+				}
+			},
+		},
+		{
+			Name:     "/rooms",
+			Help:     "list the rooms numbered, your favorites first, so /room 3 joins the third",
+			Examples: []string{"/rooms"},
+			Run: func(in *Input) {
+				in.Client.Write(s.RoomList(in.Client))
+			},
+		},
+		{
+			Name:     "/fav",
+			Args:     "[add|del] [alias] [room]",
+			Help:     "keep favorite rooms under short aliases with your account, /room g joins the room aliased g, /fav lists them",
+			Examples: []string{"/fav add g gotham", "/fav add wm \"wayne manor\"", "/fav del g", "/fav"},
+			Run:      s.favCommand,
+		},
+		{
+			Name:     "/motd",
+			Args:     "[text]",
+			Help:     "set the message of the day everyone joining a room you created sees, /motd with no text clears it",
+			Examples: []string{"/motd no capes in the batcave"},
+			Run: func(in *Input) {
+				text := strings.Join(in.Args[1:], " ")
+				msg := fmt.Sprintf("Message of the day set to [%s]\r\n", text)
+				if text == "" {
+					msg = "Message of the day cleared\r\n"
+				}
+				reply(in.Client, msg, s.SetMOTD(in.Client, text))
+			},
+		},
+		{
+			Name:     "/public",
+			Args:     "<on|off>",
+			Help:     "publish the history of a room you created on the web log and Atom feed, from then on, what was said before stays private",
+			Examples: []string{"/public on"},
+			Run: func(in *Input) {
+				on, err := onOff(in.Args, "change room")
+				if err == nil {
+					err = s.SetPublic(in.Client, on)
+				}
+				reply(in.Client, fmt.Sprintf("Room is now %s\r\n", map[bool]string{true: "public", false: "private"}[on]), err)
+			},
+		},
+		{
+			Name:     "/topic",
+			Args:     "[topic]",
+			Help:     "show the topic of your room, or set it if you created the room or are an op",
+			Examples: []string{"/topic the dark knight rises"},
+			Run: func(in *Input) {
+				if len(in.Args) >= 2 {
+					reply(in.Client, "", s.SetTopic(in.Client, strings.Join(in.Args[1:], " ")))
+					return
+				}
+				topic, err := s.Topic(in.Client)
+				reply(in.Client, fmt.Sprintf("Topic is [%s]\r\n", topic), err)
+			},
+		},
+		{
+			Name:     "/expire",
+			Args:     "[duration|off]",
+			Help:     "make the messages of your room disappear after a while, JSON clients are told which to remove",
+			Examples: []string{"/expire 1h", "/expire off"},
+			Run:      s.expireCommand,
+		},
+		{
+			Name:     "/linelimit",
+			Args:     "[chars|off|default]",
+			Help:     "paste the messages of your room longer than this many characters, a preview is posted with how to read the rest",
+			Examples: []string{"/linelimit 500", "/linelimit off", "/linelimit default"},
+			Run:      s.lineLimitCommand,
+		},
+		{
+			Name:     "/paste",
+			Args:     "[id]",
+			Help:     "paste several lines sent to your room as 1 code message, end them with a line of just . or /cancel, with an id read the full text of a message that was too long for its room",
+			Examples: []string{"/paste", "/paste Xk2_9aQe"},
+			Run:      s.pasteCommand,
+		},
+		{
+			Name:     "/spamfilter",
+			Args:     "[off|low|medium|high] [hold|drop]",
+			Help:     "screen your room's messages with the spam filter, high catches the most, likely spam is held for review unless dropped",
+			Examples: []string{"/spamfilter medium", "/spamfilter high drop", "/spamfilter off"},
+			Run:      s.spamfilterCommand,
+		},
+		{
+			Name:     "/spam",
+			Args:     "<id>",
+			Help:     "report a message of your room as spam, it is removed and the spam filter learns from it",
+			Examples: []string{"/spam 42"},
+			Run: func(in *Input) {
+				id, err := parseMessageID(in.Args[1])
+				if err == nil {
+					err = s.ReportSpam(in.Client, id)
+				}
+				reply(in.Client, fmt.Sprintf("Message [%d] reported as spam\r\n", id), err)
+			},
+		},
+		{
+			Name:     "/ham",
+			Args:     "<id>",
+			Help:     "tell the spam filter a message isn't spam, a message held for review is posted",
+			Examples: []string{"/ham 42"},
+			Run: func(in *Input) {
+				id, err := parseMessageID(in.Args[1])
+				if err == nil {
+					err = s.ReportHam(in.Client, id)
+				}
+				reply(in.Client, fmt.Sprintf("Message [%d] marked as not spam\r\n", id), err)
+			},
+		},
+		{
+			Name:     "/held",
+			Help:     "list the messages of your room held for review as likely spam",
+			Examples: []string{"/held"},
+			Run: func(in *Input) {
+				out, err := s.Held(in.Client)
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/ban",
+			Args:     "<nick>",
+			Help:     "keep a user out of a room you created or are an op of",
+			Examples: []string{"/ban joker"},
+			Run: func(in *Input) {
+				err := s.Ban(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("[%s] is banned from this room\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/unban",
+			Args:     "<nick>",
+			Help:     "let a user banned from a room you created or are an op of back in",
+			Examples: []string{"/unban joker"},
+			Run: func(in *Input) {
+				err := s.Unban(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("[%s] may join this room again\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/op",
+			Args:     "<nick>",
+			Help:     "let a user set the topic and ban in a room you created, /deop takes it back",
+			Examples: []string{"/op robin"},
+			Run: func(in *Input) {
+				err := s.Op(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("[%s] is now an op of this room\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/deop",
+			Args:     "<nick>",
+			Help:     "take back ops given with /op",
+			Examples: []string{"/deop robin"},
+			Run: func(in *Input) {
+				err := s.Deop(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("[%s] is no longer an op of this room\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/who",
+			Help:     "list the members of your room, bots are marked [bot] and ops [op]",
+			Examples: []string{"/who"},
+			Run: func(in *Input) {
+				out, err := s.Who(in.Client)
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/roominfo",
+			Args:     "[room]",
+			Help:     "show when a room, yours by default, was created and by whom, its topic, how many are in it now and have been at most, and how many messages were sent to it",
+			Examples: []string{"/roominfo", "/roominfo gotham"},
+			Run: func(in *Input) {
+				name := ""
+				if len(in.Args) > 1 {
+					name = in.Args[1]
+				}
+				out, err := s.RoomInfo(in.Client, name)
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/whois",
+			Args:     "<nick>",
+			Help:     "show whether a user is online or away and where, across every node, with the cloaked hostmasks they connect from, admins see the real addresses and countries",
+			Examples: []string{"/whois batman"},
+			Run: func(in *Input) {
+				out, err := s.Whois(in.Args[1])
+				if err == nil {
+					out += s.whoisAddrs(in.Args[1], s.isAdmin(in.Client))
+				}
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/version",
+			Help:     "show the version, commit, and build date of the server",
+			Examples: []string{"/version"},
+			Run: func(in *Input) {
+				in.Client.Write(Version() + "\r\n")
+			},
+		},
+		{
+			Name:     "/uptime",
+			Help:     "show how long the server has been running and how many are connected",
+			Examples: []string{"/uptime"},
+			Run: func(in *Input) {
+				in.Client.Write(s.Uptime())
+			},
+		},
+		{
+			Name:     "/ping",
+			Help:     "show how long the server took to answer, clients that echo the PING line it sends with /pong are told the round trip",
+			Examples: []string{"/ping"},
+			Run:      s.pingCommand,
+		},
+		{
+			Name:     "/pong",
+			Args:     "<token>",
+			Help:     "answer a PING from the server to time the round trip to your client",
+			Examples: []string{"/pong 3f2a9c"},
+			Run:      s.pongCommand,
+		},
+		{
+			Name:     "/whoami",
+			Help:     "show your nick, account, room, status, and where and since when each of your connections is connected",
+			Examples: []string{"/whoami"},
+			Run: func(in *Input) {
+				in.Client.Write(s.Whoami(in.Client, in.Conn))
+			},
+		},
+		{
+			Name:     "/away",
+			Args:     "[message]",
+			Help:     "mark yourself away with a message, /away with no message marks you back",
+			Examples: []string{"/away patrolling gotham"},
+			Run: func(in *Input) {
+				message := strings.Join(in.Args[1:], " ")
+				s.SetAway(in.Client, message)
+				if message == "" {
+					in.Client.Write("You are no longer away\r\n")
+				} else {
+					in.Client.Write(fmt.Sprintf("You are away [%s]\r\n", message))
+				}
+			},
+		},
+		{
+			Name:     "/status",
+			Args:     "[text]",
+			Help:     "set a status others see in /who and /whois, kept with your account, /status with no text clears it",
+			Examples: []string{"/status in a meeting"},
+			Run: func(in *Input) {
+				text := strings.Join(in.Args[1:], " ")
+				msg := fmt.Sprintf("Your status is [%s]\r\n", text)
+				if text == "" {
+					msg = "Your status is cleared\r\n"
+				}
+				reply(in.Client, msg, s.SetStatusText(in.Client, text))
+			},
+		},
+		{
+			Name:     "/presence",
+			Args:     "<on|off>",
+			Help:     "tell your room when its members go away, come back, or go offline",
+			Examples: []string{"/presence on"},
+			Run: func(in *Input) {
+				on, err := onOff(in.Args, "set presence notices")
+				if err == nil {
+					err = s.SetNotices(in.Client, on)
+				}
+				reply(in.Client, fmt.Sprintf("Presence notices are %s\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/watch",
+			Args:     "[nick]",
+			Help:     "get told when a nick connects or disconnects, kept with your account, again to stop, /watch lists who you watch",
+			Examples: []string{"/watch robin", "/watch"},
+			Run:      s.watchCommand,
+		},
+		{
+			Name:     "/autojoin",
+			Args:     "[add|del] [room]",
+			Help:     "join a room when you log in, kept with your account, the first you may join is joined since only 1 room may be, /autojoin lists them",
+			Examples: []string{"/autojoin add gotham", "/autojoin del gotham", "/autojoin"},
+			Run:      s.autojoinCommand,
+		},
+		{
+			Name:     "/notify",
+			Args:     "[add|del|public] [keyword|on|off]",
+			Help:     "get a flagged copy of messages in your room containing a keyword, kept with your account, public on adds public rooms you aren't in, /notify lists your keywords",
+			Examples: []string{"/notify add joker", "/notify del joker", "/notify public on", "/notify"},
+			Run:      s.notifyCommand,
+		},
+		{
+			Name:     "/remind",
+			Args:     "[room] <duration> <text>",
+			Help:     "remind yourself, or your room, of something later, even across restarts",
+			Examples: []string{"/remind 10m check the bat signal", "/remind room 2h patrol starts"},
+			Metered:  func(in *Input) bool { return len(in.Args) > 1 && in.Args[1] == "room" },
+			Run: func(in *Input) {
+				args := in.Args[1:]
+				room := args[0] == "room"
+				if room {
+					args = args[1:]
+				}
+				if len(args) < 2 {
+					in.Client.Write("Unable to set reminder, use /remind [room] <duration> <text>\r\n")
+					return
+				}
+				d, err := parseRemindIn(args[0])
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				r, err := s.Reminders.Remind(in.Client, d, strings.Join(args[1:], " "), room)
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				in.Client.Write(fmt.Sprintf("Reminder set for %s\r\n", r.Due.Format(time.RFC1123)))
+			},
+		},
+		{
+			Name:     "/schedule",
+			Args:     "<when> <text>",
+			Help:     "send a message to your room later, in a while, at a time of day in UTC, or at a timestamp",
+			Examples: []string{"/schedule 2h patrol starts", "/schedule 18:30 dinner at the manor"},
+			Metered:  metered,
+			Run:      s.scheduleCommand,
+		},
+		{
+			Name:     "/scheduled",
+			Args:     "[cancel] [id]",
+			Help:     "list your scheduled messages, or cancel one",
+			Examples: []string{"/scheduled", "/scheduled cancel 3"},
+			Run:      s.scheduledCommand,
+		},
+		{
+			Name:     "/poll",
+			Args:     "[\"question\" option option...|close]",
+			Help:     "ask your room a question, /poll shows the votes so far and /poll close ends it",
+			Examples: []string{"/poll \"who is the best robin?\" dick jason tim", "/poll close"},
+			Metered:  func(in *Input) bool { return len(in.Args) > 1 },
+			Run: func(in *Input) {
+				if len(in.Args) == 1 {
+					out, err := s.PollStatus(in.Client)
+					reply(in.Client, out, err)
+					return
+				}
+				if len(in.Args) == 2 && in.Args[1] == "close" {
+					reply(in.Client, "", s.ClosePoll(in.Client))
+					return
+				}
+				words, err := splitQuoted(strings.Join(in.Args[1:], " "))
+				if err == nil {
+					err = s.StartPoll(in.Client, words[0], words[1:])
+				}
+				reply(in.Client, "", err)
+			},
+		},
+		{
+			Name:     "/vote",
+			Args:     "<number>",
+			Help:     "vote in the poll of your room, voting again changes your vote",
+			Examples: []string{"/vote 2"},
+			Run: func(in *Input) {
+				option, err := s.Vote(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("You voted for [%s]\r\n", option), err)
+			},
+		},
+		{
+			Name:     "/roll",
+			Args:     "<dice>",
+			Help:     "roll dice for your room to see, the server rolls so nobody can fake it",
+			Examples: []string{"/roll 2d6", "/roll d20"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Roll(in.Client, in.Args[1]))
+			},
+		},
+		{
+			Name:     "/msg",
+			Args:     "<nick> <text>",
+			Help:     "send a private message to a single user",
+			Examples: []string{"/msg batman the joker is loose"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Direct(in.Args, in.Client))
+			},
+		},
+		{
+			Name:     "/emsg",
+			Args:     "<nick> <ciphertext>",
+			Help:     "relay a message encrypted for a user with their /pubkey, the server passes it on unread",
+			Examples: []string{"/emsg batman c2VjcmV0IHNpZ25hbA=="},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.DirectEncrypted(in.Client, in.Args[1], in.Args[2]))
+			},
+		},
+		{
+			Name:     "/upload",
+			Args:     "<file> [nick]",
+			Help:     "get a link to upload a file to, its download link is posted to your room, or sent to the user named, files expire after a while",
+			Examples: []string{"/upload map.png", "/upload map.png robin"},
+			Run: func(in *Input) {
+				to := ""
+				if len(in.Args) > 2 {
+					to = in.Args[2]
+				}
+				out, err := s.RequestUpload(in.Client, in.Args[1], to)
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/key",
+			Args:     "[key|clear]",
+			Help:     "show, publish, or withdraw your base64 X25519 public key for encrypted messages, kept with your account",
+			Examples: []string{"/key", "/key clear"},
+			Run:      s.keyCommand,
+		},
+		{
+			Name:     "/pubkey",
+			Args:     "<nick>",
+			Help:     "show the public key and fingerprint of a user, compare the fingerprint with them to be sure it is theirs",
+			Examples: []string{"/pubkey batman"},
+			Run:      s.pubkeyCommand,
+		},
+		{
+			Name:     "/mydata",
+			Help:     "send everything stored about your account as JSON, your profile, memos, stars, reminders, and the messages you sent",
+			Examples: []string{"/mydata"},
+			Role:     RoleUser,
+			Run:      s.mydataCommand,
+		},
+		{
+			Name:     "/forget",
+			Args:     "<password>",
+			Help:     "delete your account and purge every message, file, and paste you sent from rooms, journal, and snapshot, archived journal segments and exported events are kept until they expire, it can't be undone",
+			Examples: []string{"/forget hunter2"},
+			Role:     RoleUser,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Forget(in.Client, in.Args[1]))
+			},
+		},
+		{
+			Name:     "/memo",
+			Args:     "<nick> <text>",
+			Help:     "leave a message for an offline registered user, they get it when they next log in",
+			Examples: []string{"/memo batman the joker escaped arkham"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				err := s.SendMemo(in.Args, in.Client)
+				reply(in.Client, fmt.Sprintf("Memo left for [%s]\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/memos",
+			Args:     "[delete] [number|all]",
+			Help:     "review the memos left for you, or delete one or all of them",
+			Examples: []string{"/memos", "/memos delete 2", "/memos delete all"},
+			Run: func(in *Input) {
+				text, err := s.Memos(in.Args, in.Client)
+				reply(in.Client, text, err)
+			},
+		},
+		{
+			Name:     "/read",
+			Args:     "<id>",
+			Help:     "tell the sender of a private message you read it, for clients that show receipts",
+			Examples: []string{"/read 42"},
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Read(in.Client, in.Args[1]))
+			},
+		},
+		{
+			Name:     "/blast",
+			Args:     "<text>",
+			Help:     "blast a message to all connected clients, only admins may unless the server lets registered users blast now and then",
+			Examples: []string{"/blast the ice man cometh"},
+			Role:     RoleUser,
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Blast(in.Args, in.Client))
+			},
+		},
+		{
+			Name:     "/notice",
+			Args:     "<text>",
+			Help:     "post a notice to your room, bots send their automated replies as notices and never answer one",
+			Examples: []string{"/notice build 42 passed"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.RoomNotice(in.Client, strings.Join(in.Args[1:], " ")))
+			},
+		},
+		{
+			Name:     "/wall",
+			Args:     "<rooms|all> <text>",
+			Help:     "post a server notice to the rooms named, separated by commas, or to every room, it stays in their history unlike a blast",
+			Examples: []string{"/wall gotham,metropolis the bridges are closed tonight", "/wall all maintenance at midnight"},
+			Role:     RoleAdmin,
+			Metered:  metered,
+			Run: func(in *Input) {
+				n, err := s.Wall(in.Client, in.Args[1], strings.Join(in.Args[2:], " "))
+				reply(in.Client, fmt.Sprintf("Wall posted to %d room(s)\r\n", n), err)
+			},
+		},
+		{
+			Name:     "/reply",
+			Args:     "<id> <text>",
+			Help:     "reply to a message of your room, quoting it, /ids on shows the ids",
+			Examples: []string{"/reply 42 the joker is loose"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.Reply(in.Args, in.Client))
+			},
+		},
+		{
+			Name:     "/react",
+			Args:     "<id> <emoji>",
+			Help:     "react to a message of your room, send it again to take it back",
+			Examples: []string{"/react 42 👍"},
+			Metered:  metered,
+			Run: func(in *Input) {
+				reply(in.Client, "", s.React(in.Args, in.Client))
+			},
+		},
+		{
+			Name:     "/star",
+			Args:     "<id>",
+			Help:     "save a message of your room to your starred list, send it again to remove it",
+			Examples: []string{"/star 42"},
+			Run: func(in *Input) {
+				text, err := s.Star(in.Client, in.Args[1])
+				reply(in.Client, text, err)
+			},
+		},
+		{
+			Name:     "/starred",
+			Help:     "list the messages you starred",
+			Examples: []string{"/starred"},
+			Run: func(in *Input) {
+				text, err := s.Starred(in.Client)
+				reply(in.Client, text, err)
+			},
+		},
+		{
+			Name:     "/quota",
+			Help:     "show how many messages you sent this hour and today, and your quota",
+			Examples: []string{"/quota"},
+			Run:      s.quotaCommand,
+		},
+		{
+			Name:     "/register",
+			Args:     "<password>",
+			Help:     "register your current nick with a password",
+			Examples: []string{"/register hunter2"},
+			Run: func(in *Input) {
+				err := s.Register(in.Client, in.Args[1])
+				reply(in.Client, fmt.Sprintf("Nick [%s] is now registered\r\n", in.Client.Nick()), err)
+			},
+		},
+		{
+			Name:     "/login",
+			Args:     "<nick> <password>",
+			Help:     "log in to a registered nick, other devices logged in share the session",
+			Examples: []string{"/login batman hunter2"},
+			Run: func(in *Input) {
+				acct, err := s.Login(in.Args[1], in.Args[2], in.Client)
+				if err != nil {
+					in.Client.Write(err.Error())
+					if err == errBadLogin {
+						s.strike(in, "login")
+					}
+					return
+				}
+				// a device joining a session stays in the session's room
+				if acct == in.Client {
+					s.autojoin(acct)
+				}
+				in.Client = acct
+				s.deliverMemos(acct)
+			},
+		},
+		{
+			Name:     "/push",
+			Args:     "<ntfy|gotify|off> [url] [token]",
+			Help:     "get ntfy or Gotify notifications for mentions and messages while offline",
+			Examples: []string{"/push ntfy https://ntfy.sh/batcave", "/push gotify https://gotify.example.com AbCdEf123"},
+			Run: func(in *Input) {
+				if len(in.Args) == 2 && in.Args[1] == "off" {
+					reply(in.Client, "Push notifications are off\r\n", s.SetPush(in.Client, nil))
+					return
+				}
+				t, err := parsePushTarget(in.Args[1:])
+				if err == nil {
+					err = s.SetPush(in.Client, t)
+				}
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				in.Client.Write(fmt.Sprintf("Push notifications will be sent via %s while you are offline\r\n", t.Provider))
+			},
+		},
+		{
+			Name:     "/email",
+			Args:     "<address|immediate|digest|off>",
+			Help:     "get emailed, immediately or as a digest, when mentioned while offline",
+			Examples: []string{"/email batman@wayne.com", "/email digest"},
+			Run: func(in *Input) {
+				if strings.Contains(in.Args[1], "@") {
+					reply(in.Client, fmt.Sprintf("Email set to [%s]\r\n", in.Args[1]), s.SetEmail(in.Client, in.Args[1]))
+					return
+				}
+				reply(in.Client, fmt.Sprintf("Email notifications are %s\r\n", in.Args[1]), s.SetEmailMode(in.Client, in.Args[1]))
+			},
+		},
+		{
+			Name:     "/json",
+			Args:     "<on|off>",
+			Help:     "switch this connection to JSON events for machine clients",
+			Examples: []string{"/json on"},
+			Run: func(in *Input) {
+				on, err := onOff(in.Args, "set JSON mode")
+				if err == nil && in.Conn == nil {
+					err = fmt.Errorf("JSON mode is only available to direct connections\r\n")
+				}
+				if err == nil {
+					in.Conn.SetJSON(on)
+				}
+				reply(in.Client, fmt.Sprintf("JSON mode is %s\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/color",
+			Args:     "<on|off>",
+			Help:     "colorize timestamps, nicks, and notices, and render *bold*, _italic_, and `code` on this connection",
+			Examples: []string{"/color on"},
+			Run: func(in *Input) {
+				on, err := onOff(in.Args, "set color")
+				if err == nil && in.Conn == nil {
+					err = fmt.Errorf("color is only available to direct connections\r\n")
+				}
+				if err == nil {
+					in.Conn.SetColor(on)
+				}
+				reply(in.Client, fmt.Sprintf("Color is %s\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/plain",
+			Args:     "<on|off>",
+			Help:     "plain output for screen readers, without colors, box drawing, or framing, kept with your account",
+			Examples: []string{"/plain on"},
+			Run:      s.plainCommand,
+		},
+		{
+			Name:     "/lang",
+			Args:     "[language]",
+			Help:     "show or pick the language of server messages, kept with your account",
+			Examples: []string{"/lang", "/lang de"},
+			Run:      s.langCommand,
+		},
+		{
+			Name:     "/tz",
+			Args:     "[timezone]",
+			Help:     "show or set the timezone of your timestamps, kept with your account",
+			Examples: []string{"/tz", "/tz Europe/Berlin"},
+			Run:      s.tzCommand,
+		},
+		{
+			Name:     "/timefmt",
+			Args:     "[rfc3339|long|short|12h]",
+			Help:     "show or pick the format of your timestamps, kept with your account",
+			Examples: []string{"/timefmt short"},
+			Run:      s.timefmtCommand,
+		},
+		{
+			Name:     "/emoji",
+			Args:     "<on|off>",
+			Help:     "expand :shortcodes: like :fire: to emoji in what you send, on by default",
+			Examples: []string{"/emoji off"},
+			Run:      s.emojiCommand,
+		},
+		{
+			Name:     "/ids",
+			Args:     "<on|off>",
+			Help:     "show the id of each message on this connection, to /reply to it",
+			Examples: []string{"/ids on"},
+			Run: func(in *Input) {
+				on, err := onOff(in.Args, "show ids")
+				if err == nil && in.Conn == nil {
+					err = fmt.Errorf("ids are only available to direct connections\r\n")
+				}
+				if err == nil {
+					in.Conn.SetIDs(on)
+				}
+				reply(in.Client, fmt.Sprintf("Ids are %s\r\n", in.Args[1]), err)
+			},
+		},
+		{
+			Name:     "/width",
+			Args:     "[columns|off]",
+			Help:     "show or set the width output is wrapped to on this connection, telnet clients report it themselves",
+			Examples: []string{"/width 80", "/width off"},
+			Run:      widthCommand,
+		},
+		{
+			Name:     "/complete",
+			Args:     "[prefix]",
+			Help:     "list the commands, nicks, and rooms starting with a prefix",
+			Examples: []string{"/complete bat"},
+			Run: func(in *Input) {
+				prefix := ""
+				if len(in.Args) >= 2 {
+					prefix = in.Args[1]
+				}
+				s.Complete(prefix, in.Client, in.Conn)
+			},
+		},
+		{
+			Name:     "/sessions",
+			Args:     "[logout <n>]",
+			Help:     "list the connections of your account, or log one of them out",
+			Examples: []string{"/sessions logout 2"},
+			Run: func(in *Input) {
+				if len(in.Args) >= 3 && in.Args[1] == "logout" {
+					reply(in.Client, "", s.Logout(in.Client, in.Args[2]))
+					return
+				}
+				out, err := s.ListSessions(in.Client, in.Conn)
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/resume",
+			Args:     "<token>",
+			Help:     "restore a disconnected session using its token",
+			Examples: []string{"/resume 5f2b9c0e4d1a"},
+			Run: func(in *Input) {
+				old, err := s.Resume(in.Args[1], in.Client)
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				in.Client = old
+			},
+		},
+		{
+			Name:     "/role",
+			Args:     "[nick] [role]",
+			Help:     "show your role or a user's, or give a less trusted user a role: guest, user, moderator, or admin",
+			Examples: []string{"/role", "/role robin moderator"},
+			Run:      s.roleCommand,
+		},
+		{
+			Name:     "/find",
+			Args:     "<nick|pattern*>",
+			Help:     "find connected users by nick, or by the start of it, with the room each is in and where each connection comes from, since when, and how long it has been idle",
+			Examples: []string{"/find batman", "/find bat*"},
+			Role:     RoleAdmin,
+			Run: func(in *Input) {
+				out, err := s.Find(in.Args[1])
+				reply(in.Client, out, err)
+			},
+		},
+		{
+			Name:     "/gate",
+			Args:     "[off|pow|captcha] [bits]",
+			Help:     "show or change the gate new connections must pass, a proof of work or a question, to slow down an attack",
+			Examples: []string{"/gate pow 20", "/gate captcha", "/gate off"},
+			Role:     RoleAdmin,
+			Run:      s.gateCommand,
+		},
+		{
+			Name:     "/drain",
+			Help:     "hand every session to other cluster nodes and stop accepting connections",
+			Examples: []string{"/drain"},
+			Role:     RoleAdmin,
+			Run: func(in *Input) {
+				n, err := s.Drain(in.Client)
+				if err != nil {
+					in.Client.Write(err.Error())
+					return
+				}
+				errl(nil, fmt.Sprintf("Drained, %d session(s) handed off", n))
+			},
+		},
+		{
+			Name:     "/snapshot",
+			Help:     "save rooms, topics, bans, and registered nicks to disk",
+			Examples: []string{"/snapshot"},
+			Role:     RoleAdmin,
+			Run: func(in *Input) {
+				reply(in.Client, fmt.Sprintf("Snapshot written to [%s]\r\n", s.SnapshotPath), s.Snapshot(in.Client))
+			},
+		},
+		{
+			Name:     "/shutdown",
+			Args:     "[delay|cancel]",
+			Help:     "warn everyone, then close every connection and stop the server, a minute from now by default",
+			Examples: []string{"/shutdown 5m", "/shutdown cancel"},
+			Role:     RoleAdmin,
+			Run: func(in *Input) {
+				s.shutdownCommand(in, false)
+			},
+		},
+		{
+			Name:     "/maintenance",
+			Args:     "[delay|off]",
+			Help:     "warn everyone, then close every connection a little apart and refuse new ones until turned off, the server keeps running",
+			Examples: []string{"/maintenance 10m", "/maintenance off"},
+			Role:     RoleAdmin,
+			Run:      s.maintenanceCommand,
+		},
+		{
+			Name:     "/restart",
+			Args:     "[delay]",
+			Help:     "like /shutdown, but the server starts again",
+			Examples: []string{"/restart 30"},
+			Role:     RoleAdmin,
+			Run: func(in *Input) {
+				s.shutdownCommand(in, true)
+			},
+		},
+	}
+	for _, c := range builtins {
+		if err := s.Commands.Register(c); err != nil {
+			errl(err, "")
+		}
+	}
+}
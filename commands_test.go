@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	err := r.Register(&Command{
+		Name: "signal",
+		Args: "<who> [where]",
+		Help: "light the signal",
+		Run:  func(in *Input) {},
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := r.Register(&Command{Name: "/signal", Run: func(in *Input) {}}); err == nil {
+		t.Errorf("expected duplicate command to be refused")
+	}
+	if err := r.Register(&Command{Name: "/nothing"}); err == nil {
+		t.Errorf("expected command without a handler to be refused")
+	}
+
+	c, ok := r.Lookup("/signal")
+	if !ok || c.Usage() != "/signal <who> [where]" || c.minArgs() != 1 {
+		t.Fatalf("expected /signal to be registered, got %+v", c)
+	}
+	if !strings.Contains(r.Help(), "/signal <who> [where]\nlight the signal\n") {
+		t.Errorf("expected help to list /signal, got %q", r.Help())
+	}
+
+	r.Unregister("/signal")
+	if _, ok := r.Lookup("/signal"); ok || len(r.Names()) != 0 {
+		t.Errorf("expected /signal to be removed")
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+
+	var ran []string
+	serv.Commands.Register(&Command{
+		Name: "/signal",
+		Args: "<who>",
+		Run:  func(in *Input) { ran = append(ran, in.Args[1]) },
+	})
+	serv.Commands.Register(&Command{
+		Name: "/lockdown",
+		Role: RoleAdmin,
+		Run:  func(in *Input) { ran = append(ran, "lockdown") },
+	})
+
+	run := func(args ...string) {
+		in := &Input{Client: batman, Args: args}
+		if strings.HasPrefix(args[0], "/") {
+			in.Command = args[0]
+		}
+		serv.Dispatch(in)
+	}
+	run("/signal", "gordon")
+	run("/signal")
+	run("/lockdown")
+	run("/unknown", "command")
+	run("hello")
+	if strings.Join(ran, ",") != "gordon" {
+		t.Errorf("expected only the complete /signal to run, got %v", ran)
+	}
+
+	r := serv.Rooms["gotham"]
+	if len(r.History) != 2 || r.History[0].Text != "/unknown command" || r.History[1].Text != "hello" {
+		t.Errorf("expected unknown commands and text to reach the room, got %+v", r.History)
+	}
+
+	serv.Admins = map[string]bool{"batman": true}
+	batman.account = "batman"
+	run("/lockdown")
+	if len(ran) != 2 {
+		t.Errorf("expected admin to run /lockdown, got %v", ran)
+	}
+
+	names := strings.Join(serv.Commands.Names(), " ")
+	if !strings.Contains(names, "/help") || !strings.Contains(names, "/signal") {
+		t.Errorf("expected built in and registered commands, got %s", names)
+	}
+	if help := serv.Banner("batman", ""); !strings.Contains(help, "You are user [batman]") || !strings.Contains(help, "/drain\nhand every session to other cluster nodes and stop accepting connections, admins only\n") {
+		t.Errorf("unexpected banner %q", help)
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	serv := NewServer()
+	out, err := serv.CommandHelp("json")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	want := "Usage: /json <on|off>\r\nswitch this connection to JSON events for machine clients\r\n" +
+		"  <on|off> required, one of on, off\r\nAnyone can use it\r\n(example: /json on)\r\n"
+	if out != want {
+		t.Errorf("expected [%q], got [%q]", want, out)
+	}
+
+	if out, _ := serv.CommandHelp("/drain"); !strings.Contains(out, "Only admins can use it\r\n") {
+		t.Errorf("expected the role of /drain, got [%q]", out)
+	}
+	serv.Permissions = map[string]string{"/json": RoleUser}
+	if out, _ := serv.CommandHelp("/json"); !strings.Contains(out, "Only registered users can use it\r\n") {
+		t.Errorf("expected TCPermissions to change the role shown, got [%q]", out)
+	}
+	if _, err := serv.CommandHelp("/batarang"); err == nil {
+		t.Errorf("expected an unknown command to fail")
+	}
+
+	batman := &Client{nick: "batman"}
+	serv.Dispatch(&Input{Client: batman, Command: "/help", Args: []string{"/help", "nick"}})
+	if len(batman.unread) != 1 || !strings.HasPrefix(batman.unread[0].Text, "Usage: /nick [nick]\r\n") {
+		t.Errorf("expected the help of /nick, got %+v", batman.unread)
+	}
+}
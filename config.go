@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfig reads KEY=value lines from path into the environment, values
+// in the file override the environment, blank lines and lines starting
+// with # are skipped
+func LoadConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return fmt.Errorf("%s:%d: expected KEY=value", path, n)
+		}
+		val := strings.TrimSpace(kv[1])
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		os.Setenv(strings.TrimSpace(kv[0]), val)
+	}
+	return sc.Err()
+}
+
+// applyConfig sets the roles, permissions, address ranges, countries,
+// strikes, quotas, reserved nicks, bot keys, rate limit, blast policy,
+// probation, and auto-away from the environment, they are the settings a
+// reload changes on a running server
+func (s *Server) applyConfig() error {
+	rate := DefaultRateLimit
+	if v := os.Getenv("TCRateLimit"); len(v) > 0 {
+		var err error
+		if rate, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("error parsing TCRateLimit: %v", err)
+		}
+	}
+	perms, err := parsePermissions(os.Getenv("TCPermissions"))
+	if err != nil {
+		return fmt.Errorf("error parsing TCPermissions: %v", err)
+	}
+	set := func(env string) map[string]bool {
+		m := make(map[string]bool)
+		for _, a := range splitList(os.Getenv(env)) {
+			m[a] = true
+		}
+		return m
+	}
+	allow, err := parseCIDRs(os.Getenv("TCAllowCIDRs"))
+	if err != nil {
+		return fmt.Errorf("error parsing TCAllowCIDRs: %v", err)
+	}
+	deny, err := parseCIDRs(os.Getenv("TCDenyCIDRs"))
+	if err != nil {
+		return fmt.Errorf("error parsing TCDenyCIDRs: %v", err)
+	}
+	limit, window, ban, banMax, err := parseOffenses()
+	if err != nil {
+		return err
+	}
+	users, guests, err := parseQuotas()
+	if err != nil {
+		return err
+	}
+	var autoAway time.Duration
+	if v := os.Getenv("TCAutoAway"); len(v) > 0 {
+		if autoAway, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("error parsing TCAutoAway: %v", err)
+		}
+	}
+	var readTimeout time.Duration
+	if v := os.Getenv("TCReadTimeout"); len(v) > 0 {
+		if readTimeout, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("error parsing TCReadTimeout: %v", err)
+		}
+	}
+	blast, blastEvery, err := parseBlastPolicy()
+	if err != nil {
+		return err
+	}
+	probation, probationRate, err := parseProbation()
+	if err != nil {
+		return err
+	}
+	catalogs, err := loadCatalogs(os.Getenv("TCLocales"))
+	if err != nil {
+		return fmt.Errorf("error loading TCLocales: %v", err)
+	}
+	if err := configureLocales(catalogs, os.Getenv("TCLocale")); err != nil {
+		return err
+	}
+	if err := configureLogIPs(os.Getenv("TCLogIPs"), os.Getenv("TCLogIPSalt")); err != nil {
+		return err
+	}
+	configureCloak(os.Getenv("TCCloakKey"))
+	bannerFile, err := loadBanner(os.Getenv("TCBanner"))
+	if err != nil {
+		return fmt.Errorf("error loading TCBanner: %v", err)
+	}
+	keys := parseBotKeys(os.Getenv("TCBotKeys"))
+	s.Offenses.Configure(limit, window, ban, banMax)
+	s.Quotas.Configure(users, guests)
+
+	s.cfg.Lock()
+	defer s.cfg.Unlock()
+	s.Owners = set("TCOwners")
+	s.Admins = set("TCAdmins")
+	s.Moderators = set("TCModerators")
+	s.Permissions = perms
+	s.AllowNets = allow
+	s.DenyNets = deny
+	s.AllowCountries = parseCountries(os.Getenv("TCGeoAllow"))
+	s.DenyCountries = parseCountries(os.Getenv("TCGeoDeny"))
+	s.Reserved = splitList(os.Getenv("TCReservedNicks"))
+	s.BotKeys = keys
+	s.RateLimit = rate
+	s.AutoAway = autoAway
+	s.ReadTimeout = readTimeout
+	s.BlastPolicy = blast
+	s.BlastInterval = blastEvery
+	s.ProbationPeriod = probation
+	s.ProbationRate = probationRate
+	s.ServerName = os.Getenv("TCServerName")
+	s.BannerFile = bannerFile
+	return nil
+}
+
+// Reload reads the config file again and applies what can change while
+// running
+func (s *Server) Reload() error {
+	if s.ConfigPath != "" {
+		if err := LoadConfig(s.ConfigPath); err != nil {
+			return err
+		}
+	}
+	if err := s.applyConfig(); err != nil {
+		return err
+	}
+	errl(nil, "Config reloaded")
+	return nil
+}
+
+// rateLimit returns how many lines per second a person may send
+func (s *Server) rateLimit() int {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	return s.RateLimit
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ControlRequest is a line of JSON sent to the control socket
+type ControlRequest struct {
+	Token string   `json:"token"`
+	Cmd   string   `json:"cmd"`
+	Args  []string `json:"args,omitempty"`
+}
+
+// ControlReply answers a ControlRequest
+type ControlReply struct {
+	OK    bool   `json:"ok"`
+	Text  string `json:"text,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Control serves administration commands on a unix socket to anyone with
+// the token, without connecting as a chat user
+type Control struct {
+	Server *Server
+	Token  string
+	ln     net.Listener
+}
+
+// NewControl returns a control server for s
+func NewControl(s *Server, token string) *Control {
+	return &Control{Server: s, Token: token}
+}
+
+// Listen opens the control socket at path, only the server's user may
+// connect to it
+func (c *Control) Listen(path string) error {
+	if c.Token == "" {
+		return errors.New("the control socket needs a token")
+	}
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+	c.ln = ln
+	go c.Serve(ln)
+	return nil
+}
+
+// Close closes the control socket
+func (c *Control) Close() error {
+	if c.ln == nil {
+		return nil
+	}
+	return c.ln.Close()
+}
+
+// Serve answers control connections on ln
+func (c *Control) Serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(conn)
+	}
+}
+
+// handle answers requests until the connection closes, a bad token ends it
+func (c *Control) handle(conn net.Conn) {
+	defer conn.Close()
+	buf := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		line, err := buf.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var req ControlRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			enc.Encode(ControlReply{Error: "bad request"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Token), []byte(c.Token)) != 1 {
+			errl(errors.New("control request with a bad token"), "")
+			enc.Encode(ControlReply{Error: "bad token"})
+			return
+		}
+
+		text, err := c.Run(req.Cmd, req.Args)
+		if err != nil {
+			enc.Encode(ControlReply{Error: strings.TrimSpace(err.Error())})
+			continue
+		}
+		errl(nil, fmt.Sprintf("Control ran [%s %s]", req.Cmd, strings.Join(req.Args, " ")))
+		enc.Encode(ControlReply{OK: true, Text: text})
+	}
+}
+
+// controlHelp lists the control commands
+const controlHelp = `clients                   list connected clients
+rooms                     list rooms
+room <room>               show a room's owner, ops, bans, and members
+kick <nick> [reason]      disconnect a user
+ban <room> <nick>         keep a user out of a room
+unban <room> <nick>       let a user back into a room
+notice <text>             send a notice to every client
+role <account> <role>     give an account a role
+pardon <address>          lift a ban for too many strikes
+gate <mode> [bits]        set the gate new connections pass, off, pow, or captcha
+reload                    read the config file again
+transcripts               verify the hash chain of the recorded transcripts
+version                   show the build and uptime of the server`
+
+// Run runs a control command and returns its output
+func (c *Control) Run(cmd string, args []string) (string, error) {
+	s := c.Server
+	need := func(n int) error {
+		if len(args) < n {
+			return fmt.Errorf("%s needs %d argument(s), try help", cmd, n)
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "help":
+		return controlHelp, nil
+	case "clients":
+		return s.ListClients(), nil
+	case "rooms":
+		return s.ListRooms(), nil
+	case "room":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		return s.InspectRoom(args[0])
+	case "kick":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("kicked [%s]", args[0]), s.Kick("control", args[0], strings.Join(args[1:], " "))
+	case "ban":
+		if err := need(2); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("banned [%s] from [%s]", args[1], args[0]), s.BanFrom("control", args[0], args[1])
+	case "unban":
+		if err := need(2); err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		r, ok := s.Rooms[args[0]]
+		s.mu.Unlock()
+		if !ok {
+			return "", fmt.Errorf("room [%s] does not exist", args[0])
+		}
+		return fmt.Sprintf("unbanned [%s] from [%s]", args[1], r.Name), s.unban(r, args[1], "control")
+	case "notice":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		n := s.Notice(strings.Join(args, " "))
+		return fmt.Sprintf("sent to %d client(s)", n), nil
+	case "role":
+		if err := need(2); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[%s] is now a %s", args[0], args[1]), s.SetRole("control", RoleOwner, args[0], args[1])
+	case "pardon":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("pardoned [%s]", args[0]), s.Offenses.Pardon(args[0])
+	case "gate":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		bits := ""
+		if len(args) >= 2 {
+			bits = args[1]
+		}
+		mode, n, err := parseGate(args[0], bits)
+		if err != nil {
+			return "", err
+		}
+		s.SetGate("control", mode, n)
+		return fmt.Sprintf("the gate is now [%s]", mode), nil
+	case "reload":
+		return "reloaded", s.Reload()
+	case "version":
+		return Version() + "\n" + strings.TrimRight(s.Uptime(), "\r\n"), nil
+	case "transcripts":
+		r := recorder()
+		if r == nil {
+			return "", errors.New("sessions aren't recorded, set TCRecord")
+		}
+		n, head, err := r.Verify()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("verified %d entries, head %s", n, head), nil
+	}
+	return "", fmt.Errorf("unknown command [%s], try help", cmd)
+}
+
+// ListClients describes every client, one per line
+func (s *Server) ListClients() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	for nick, cl := range s.Clients {
+		var b strings.Builder
+		b.WriteString(nick)
+		if r, err := s.findRoom(cl); err == nil {
+			fmt.Fprintf(&b, " room=%q", r.Name)
+		}
+		if a := cl.Account(); a != "" {
+			fmt.Fprintf(&b, " account=%s", a)
+		}
+		cl.mu.Lock()
+		conns := len(cl.Conns)
+		var listed []string
+		for _, c := range cl.Conns {
+			listed = append(listed, c.Listed()...)
+		}
+		cl.mu.Unlock()
+		fmt.Fprintf(&b, " conns=%d", conns)
+		if len(listed) > 0 {
+			fmt.Fprintf(&b, " listed=%s", strings.Join(listed, ","))
+		}
+		if cl.Bot() {
+			b.WriteString(" bot")
+		}
+		if status := cl.Status(); status != StatusOnline {
+			b.WriteString(" " + status)
+		}
+		lines = append(lines, b.String())
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// ListRooms describes every room, one per line
+func (s *Server) ListRooms() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	for name, r := range s.Rooms {
+		lines = append(lines, fmt.Sprintf("%q members=%d owner=%s public=%t", name, len(r.Clients), r.Owner, r.Public))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// InspectRoom describes a room in detail
+func (s *Server) InspectRoom(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Rooms[name]
+	if !ok {
+		return "", fmt.Errorf("room [%s] does not exist", name)
+	}
+	keys := func(m map[string]bool) string {
+		var out []string
+		for k := range m {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+		return strings.Join(out, ", ")
+	}
+	members := make(map[string]bool)
+	for nick := range r.Clients {
+		members[nick] = true
+	}
+	return fmt.Sprintf("name: %s\nowner: %s\ntopic: %s\npublic: %t\nops: %s\nbans: %s\nmembers: %s\nhistory: %d event(s)",
+		r.Name, r.Owner, r.Topic, r.Public, keys(r.Ops), keys(r.Bans), keys(members), len(r.History)), nil
+}
+
+// Kick disconnects every connection of nick and ends its session
+func (s *Server) Kick(by, nick, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl, ok := s.Clients[nick]
+	if ok {
+		cl.mu.Lock()
+		ok = cl.relay == nil
+		cl.mu.Unlock()
+	}
+	if !ok {
+		return fmt.Errorf("user [%s] is not connected here", nick)
+	}
+	delete(s.Sessions, cl.Token())
+	s.logMod(by, "kick", nick, "")
+
+	if reason != "" {
+		cl.Write(fmt.Sprintf("You were kicked [%s]\r\n", reason))
+	} else {
+		cl.Write("You were kicked\r\n")
+	}
+
+	cl.mu.Lock()
+	conns := append([]*Conn{}, cl.Conns...)
+	if cl.expire != nil {
+		cl.expire.Stop()
+	}
+	cl.mu.Unlock()
+
+	// a disconnected session is gone at once, otherwise closing its
+	// connections ends it
+	if len(conns) == 0 {
+		s.tryDeleteFromRoom(cl)
+		s.removeClient(cl)
+	}
+	for _, c := range conns {
+		c.sendClose("")
+	}
+	errl(nil, fmt.Sprintf("[%s] kicked by [%s] [%s]", nick, by, reason))
+	return nil
+}
+
+// Notice sends a server notice to every client and returns how many
+func (s *Server) Notice(text string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ev := Event{
+		Type: EventNotice,
+		Time: time.Now().Format(time.RFC3339),
+		From: "server",
+		Text: text,
+	}
+	for _, cl := range s.Clients {
+		cl.Send(ev)
+	}
+	return len(s.Clients)
+}
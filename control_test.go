@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "tinychat.conf")
+
+	ioutil.WriteFile(file, []byte("# admins\nTCAdmins=batman\nexport TCRateLimit=\"9\"\n\nTCBotKeys='alfred:k3y'\n"), 0600)
+	defer os.Unsetenv("TCAdmins")
+	defer os.Unsetenv("TCRateLimit")
+	defer os.Unsetenv("TCBotKeys")
+
+	serv := NewServer()
+	serv.ConfigPath = file
+	if err := serv.Reload(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !serv.Admins["batman"] || serv.rateLimit() != 9 || len(serv.BotKeys) != 1 {
+		t.Errorf("expected config to be applied, got %v %d %v", serv.Admins, serv.RateLimit, serv.BotKeys)
+	}
+
+	ioutil.WriteFile(file, []byte("TCAdmins\n"), 0600)
+	if err := serv.Reload(); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("expected malformed line to be reported, got %v", err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	sock := path.Join(dir, "control.sock")
+
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+
+	if err := NewControl(serv, "").Listen(sock); err == nil {
+		t.Errorf("expected a token to be required")
+	}
+	c := NewControl(serv, "s3cret")
+	if err := c.Listen(sock); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	defer conn.Close()
+	buf := bufio.NewReader(conn)
+	send := func(token, cmd string, args ...string) ControlReply {
+		json.NewEncoder(conn).Encode(ControlRequest{Token: token, Cmd: cmd, Args: args})
+		var rep ControlReply
+		line, _ := buf.ReadBytes('\n')
+		json.Unmarshal(line, &rep)
+		return rep
+	}
+
+	if rep := send("s3cret", "clients"); !rep.OK || rep.Text != "batman room=\"gotham\" conns=0\njoker room=\"gotham\" conns=0" {
+		t.Errorf("unexpected clients %+v", rep)
+	}
+	if rep := send("s3cret", "ban", "gotham", "joker"); !rep.OK {
+		t.Errorf("expected ban, got %+v", rep)
+	}
+	if rep := send("s3cret", "room", "gotham"); !strings.Contains(rep.Text, "bans: joker") || !strings.Contains(rep.Text, "members: batman") {
+		t.Errorf("unexpected room %+v", rep)
+	}
+	if rep := send("s3cret", "kick", "batman", "out"); !rep.OK {
+		t.Errorf("expected kick, got %+v", rep)
+	}
+	if serv.HasClient("batman") || len(batman.unread) != 1 || batman.unread[0].Text != "You were kicked [out]\r\n" {
+		t.Errorf("expected batman to be kicked")
+	}
+	if rep := send("s3cret", "notice", "maintenance", "at", "noon"); rep.Text != "sent to 1 client(s)" {
+		t.Errorf("unexpected notice %+v", rep)
+	}
+	if rep := send("s3cret", "fly"); rep.OK || !strings.Contains(rep.Error, "unknown command") {
+		t.Errorf("expected unknown command, got %+v", rep)
+	}
+
+	if rep := send("wrong", "clients"); rep.Error != "bad token" {
+		t.Errorf("expected bad token, got %+v", rep)
+	}
+	if _, err := buf.ReadBytes('\n'); err == nil {
+		t.Errorf("expected connection to be closed")
+	}
+}
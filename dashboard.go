@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dashboardRefresh is how often the dashboard page reloads itself
+const dashboardRefresh = 5
+
+// dashboardSession is how long a dashboard sign in lasts before the
+// password is asked for again
+const dashboardSession = 12 * time.Hour
+
+// dashboardCookie names the cookie holding a dashboard session
+const dashboardCookie = "tcadmin"
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Admin - TinyChat</title>
+<style>
+body { font-family: monospace; max-width: 70em; margin: 2em auto; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { padding: 0.2em 0.6em; text-align: left; }
+form { display: inline; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>TinyChat</h1>
+<p>{{.Clients}} client(s), {{len .Rooms}} room(s), {{.Rate}} message(s) in the last minute, signed in as {{.Admin}}</p>
+{{$csrf := .CSRF}}
+{{range .Rooms}}
+<h2>{{.Name}}</h2>
+<p>owner: {{.Owner}} | topic: {{.Topic}} | {{.Rate}} message(s) in the last minute</p>
+<table>
+{{$room := .Name}}
+{{range .Members}}<tr{{if .Muted}} class="muted"{{end}}>
+<td>{{.Nick}}{{if .Op}} [op]{{end}}{{if .Bot}} [bot]{{end}}{{if .Muted}} [muted]{{end}}</td>
+<td><form method="post" action="/kick"><input type="hidden" name="csrf" value="{{$csrf}}"><input type="hidden" name="nick" value="{{.Nick}}"><input name="reason" placeholder="reason"><button>kick</button></form></td>
+<td>{{if $.Bannable $room}}<form method="post" action="/ban"><input type="hidden" name="csrf" value="{{$csrf}}"><input type="hidden" name="room" value="{{$room}}"><input type="hidden" name="nick" value="{{.Nick}}"><button>ban</button></form>{{end}}</td>
+<td><form method="post" action="{{if .Muted}}/unmute{{else}}/mute{{end}}"><input type="hidden" name="csrf" value="{{$csrf}}"><input type="hidden" name="nick" value="{{.Nick}}"><button>{{if .Muted}}unmute{{else}}mute{{end}}</button></form></td>
+</tr>
+{{end}}
+</table>
+{{end}}
+<h2>Moderation</h2>
+<table>
+{{range .ModLog}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.By}}</td><td>{{.Action}}</td><td>{{.Target}}</td><td>{{.Room}}</td></tr>
+{{else}}<tr><td>nothing yet</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+type dashMember struct {
+	Nick  string
+	Op    bool
+	Bot   bool
+	Muted bool
+}
+
+type dashRoom struct {
+	Name    string
+	Owner   string
+	Topic   string
+	Rate    int
+	Members []dashMember
+}
+
+type dashPage struct {
+	Admin   string
+	CSRF    string
+	Refresh int
+	Clients int
+	Rate    int
+	Rooms   []dashRoom
+	ModLog  []ModAction
+}
+
+// Dashboard serves the admin web dashboard, only admins may sign in, with
+// the password of their account
+type Dashboard struct {
+	Server *Server
+	csrf   string
+
+	// sessions are the signed in admins by cookie, so the slow password
+	// hash is only worked out once and not on every refresh
+	mu       sync.Mutex
+	sessions map[string]dashSession
+}
+
+// dashSession is an admin signed in to the dashboard until expires
+type dashSession struct {
+	admin   string
+	expires time.Time
+}
+
+// NewDashboard returns the admin dashboard of s
+func NewDashboard(s *Server) *Dashboard {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return &Dashboard{Server: s, csrf: hex.EncodeToString(b), sessions: make(map[string]dashSession)}
+}
+
+// admin returns the admin account signing the request, or false, a
+// request without a session signs in with Basic auth and is given one
+func (d *Dashboard) admin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	now := time.Now()
+	if c, err := r.Cookie(dashboardCookie); err == nil {
+		d.mu.Lock()
+		ds, ok := d.sessions[c.Value]
+		d.mu.Unlock()
+		// the account may have stopped being an admin since
+		if ok && now.Before(ds.expires) && d.Server.adminAccount(ds.admin) {
+			return ds.admin, true
+		}
+	}
+
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	// a wrong name or password is a strike, like a failed chat login
+	if !d.Server.adminAccount(name) || d.Server.Accounts.Authenticate(name, password) != nil {
+		d.Server.strikeAddr(requestIP(r), "dashboard login")
+		return "", false
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		errl(err, "")
+		return name, true
+	}
+	token := hex.EncodeToString(b)
+	d.mu.Lock()
+	for t, ds := range d.sessions {
+		if !now.Before(ds.expires) {
+			delete(d.sessions, t)
+		}
+	}
+	d.sessions[token] = dashSession{admin: name, expires: now.Add(dashboardSession)}
+	d.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(dashboardSession),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return name, true
+}
+
+// requestIP returns the address a request came from, or nil
+func requestIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// ServeHTTP serves the dashboard page and the actions its buttons post
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a banned address isn't even asked for a password
+	if ip := requestIP(r); ip != nil {
+		if ban, ok := d.Server.Offenses.Banned(ip.String(), time.Now()); ok {
+			http.Error(w, fmt.Sprintf("banned for %s, too many strikes", ban.Round(time.Second)), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	admin, ok := d.admin(w, r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="tinychat admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/" {
+		d.serveDashboard(w, admin)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(d.csrf)) != 1 {
+		http.Error(w, "invalid form", http.StatusForbidden)
+		return
+	}
+
+	s := d.Server
+	nick := r.PostFormValue("nick")
+	var err error
+	switch r.URL.Path {
+	case "/kick":
+		err = s.Kick(admin, nick, r.PostFormValue("reason"))
+	case "/ban":
+		err = s.BanFrom(admin, r.PostFormValue("room"), nick)
+	case "/mute":
+		err = s.Mute(admin, nick)
+	case "/unmute":
+		err = s.Unmute(admin, nick)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// serveDashboard renders the rooms, their members, and the moderation log
+func (d *Dashboard) serveDashboard(w http.ResponseWriter, admin string) {
+	page := d.Server.dashboard(time.Now())
+	page.Admin = admin
+	page.CSRF = d.csrf
+	page.Refresh = dashboardRefresh
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		errl(err, "")
+	}
+}
+
+// Bannable returns true if members can be banned from the room
+func (p dashPage) Bannable(room string) bool {
+	return room != DefaultRoom
+}
+
+// dashboard returns what the dashboard shows at now
+func (s *Server) dashboard(now time.Time) dashPage {
+	page := dashPage{ModLog: s.ModLog()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page.Clients = len(s.Clients)
+	for _, r := range s.Rooms {
+		dr := dashRoom{Name: r.Name, Owner: r.Owner, Topic: r.Topic, Rate: r.rate(now)}
+		for _, c := range r.Clients {
+			dr.Members = append(dr.Members, dashMember{Nick: c.Nick(), Op: r.opped(c), Bot: c.Bot(), Muted: s.muted(c)})
+		}
+		sort.Slice(dr.Members, func(i, j int) bool { return dr.Members[i].Nick < dr.Members[j].Nick })
+		page.Rate += dr.Rate
+		page.Rooms = append(page.Rooms, dr)
+	}
+	sort.Slice(page.Rooms, func(i, j int) bool { return page.Rooms[i].Name < page.Rooms[j].Name })
+	return page
+}
+
+// rate returns how many messages were sent to the room in the minute
+// before now, it must be called with the server lock held
+func (r *Room) rate(now time.Time) int {
+	since := now.Add(-time.Minute)
+	n := 0
+	for i := len(r.History) - 1; i >= 0; i-- {
+		ev := r.History[i]
+		t, err := time.Parse(time.RFC3339, ev.Time)
+		if err != nil {
+			continue
+		}
+		if t.Before(since) {
+			break
+		}
+		if ev.Type == EventMessage {
+			n++
+		}
+	}
+	return n
+}
+
+// BanFrom bans nick from the named room on behalf of an admin
+func (s *Server) BanFrom(by, roomname, nick string) error {
+	s.mu.Lock()
+	r, ok := s.Rooms[roomname]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("room [%s] does not exist", roomname)
+	}
+	if r.Name == DefaultRoom {
+		return fmt.Errorf("nobody can be banned from room [%s]", DefaultRoom)
+	}
+	return s.ban(r, nick, by)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	serv := NewServer()
+	serv.Admins = map[string]bool{"alfred": true}
+	if err := serv.Accounts.Register("alfred", "butler"); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.Accounts.Register("robin", "wonder"); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDashboard(serv)
+
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+	serv.Message([]string{"hahaha"}, joker)
+
+	do := func(method, path, user, password string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("GET", "/", "", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected sign in to be required, got %d", w.Code)
+	}
+	if w := do("GET", "/", "robin", "wonder", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected non admins to be refused, got %d", w.Code)
+	}
+	if w := do("GET", "/", "alfred", "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bad password to be refused, got %d", w.Code)
+	}
+
+	w := do("GET", "/", "alfred", "butler", nil)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "<h2>gotham</h2>") || !strings.Contains(body, "1 message(s) in the last minute") {
+		t.Fatalf("expected rooms and rates, got %d [%s]", w.Code, body)
+	}
+
+	// the sign in is remembered, the password isn't needed again
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != dashboardCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("expected the session to sign in, got %d", w.Code)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: dashboardCookie, Value: "forged"})
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown session to be refused, got %d", w.Code)
+	}
+
+	form := url.Values{"nick": {"joker"}}
+	if w := do("POST", "/mute", "alfred", "butler", form); w.Code != http.StatusForbidden {
+		t.Errorf("expected a missing csrf token to be refused, got %d", w.Code)
+	}
+	form.Set("csrf", d.csrf)
+	if w := do("POST", "/mute", "alfred", "butler", form); w.Code != http.StatusSeeOther {
+		t.Errorf("expected joker to be muted, got %d [%s]", w.Code, w.Body.String())
+	}
+	if err := serv.Message([]string{"hahaha"}, joker); err != errMuted {
+		t.Errorf("expected joker to be muted, got %v", err)
+	}
+
+	form.Set("room", "gotham")
+	if w := do("POST", "/ban", "alfred", "butler", form); w.Code != http.StatusSeeOther {
+		t.Errorf("expected joker to be banned, got %d [%s]", w.Code, w.Body.String())
+	}
+	if serv.RoomOf(joker) != DefaultRoom {
+		t.Errorf("expected joker to be moved out of gotham")
+	}
+
+	body = do("GET", "/", "alfred", "butler", nil).Body.String()
+	if !strings.Contains(body, "joker [muted]") || !strings.Contains(body, "<td>alfred</td><td>ban</td><td>joker</td><td>gotham</td>") {
+		t.Errorf("expected mute and moderation log, got [%s]", body)
+	}
+}
+
+func TestDashboardStrikes(t *testing.T) {
+	serv := NewServer()
+	serv.Admins = map[string]bool{"alfred": true}
+	if err := serv.Accounts.Register("alfred", "butler"); err != nil {
+		t.Fatal(err)
+	}
+	serv.Offenses.Configure(2, time.Minute, time.Minute, time.Hour)
+	d := NewDashboard(serv)
+
+	do := func(password string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("alfred", password)
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected a bad password to be refused, got %d", code)
+	}
+	// strikes for the same reason within a second count once
+	time.Sleep(1100 * time.Millisecond)
+	if code := do("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected a bad password to be refused, got %d", code)
+	}
+	if _, ok := serv.Offenses.Banned("192.0.2.1", time.Now()); !ok {
+		t.Fatalf("expected the address to be banned after two strikes")
+	}
+	if code := do("butler"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a banned address to be refused, got %d", code)
+	}
+}
+
+func TestMute(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+
+	if err := serv.Mute("batman", "joker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.Mute("batman", "joker"); err == nil {
+		t.Errorf("expected joker to be muted already")
+	}
+	if err := serv.Direct([]string{"/msg", "batman", "hi"}, joker); err != errMuted {
+		t.Errorf("expected direct messages to be refused, got %v", err)
+	}
+	if err := serv.Unmute("batman", "joker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.Message([]string{"hahaha"}, joker); err != nil {
+		t.Errorf("expected joker to talk again, got %v", err)
+	}
+
+	log := serv.ModLog()
+	if len(log) != 2 || log[0].Action != "unmute" || log[1].Action != "mute" || log[1].By != "batman" {
+		t.Errorf("expected mute and unmute to be logged, got %+v", log)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limits on a roll so it fits on a line
+const (
+	maxDice  = 20
+	maxSides = 1000
+)
+
+// parseDice parses NdM, N defaults to 1
+func parseDice(s string) (int, int, error) {
+	bad := fmt.Errorf("[%s] is not a roll, try 2d6 or d20\r\n", s)
+	parts := strings.SplitN(strings.ToLower(s), "d", 2)
+	if len(parts) != 2 {
+		return 0, 0, bad
+	}
+	n := 1
+	if parts[0] != "" {
+		var err error
+		if n, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, 0, bad
+		}
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || n < 1 || m < 2 {
+		return 0, 0, bad
+	}
+	if n > maxDice || m > maxSides {
+		return 0, 0, fmt.Errorf("roll at most %d dice of %d sides\r\n", maxDice, maxSides)
+	}
+	return n, m, nil
+}
+
+// rollDice rolls n dice of m sides
+func rollDice(n, m int) ([]int, error) {
+	out := make([]int, n)
+	for i := range out {
+		v, err := rand.Int(rand.Reader, big.NewInt(int64(m)))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = int(v.Int64()) + 1
+	}
+	return out, nil
+}
+
+// Roll rolls dice for the client and tells its room the result, the
+// server rolls so the result can't be faked
+func (s *Server) Roll(cl *Client, dice string) error {
+	n, m, err := parseDice(dice)
+	if err != nil {
+		return err
+	}
+	rolls, err := rollDice(n, m)
+	if err != nil {
+		return errors.New("unable to roll, try again\r\n")
+	}
+
+	var parts []string
+	total := 0
+	for _, r := range rolls {
+		parts = append(parts, strconv.Itoa(r))
+		total += r
+	}
+	text := fmt.Sprintf("[%s] rolled %dd%d: %d", cl.Nick(), n, m, total)
+	if n > 1 {
+		text = fmt.Sprintf("[%s] rolled %dd%d: %s = %d", cl.Nick(), n, m, strings.Join(parts, " + "), total)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	return s.deliver(Event{
+		Type: EventService,
+		Time: time.Now().Format(time.RFC3339),
+		From: "dice",
+		Room: r.Name,
+		Text: text,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		in   string
+		n, m int
+		ok   bool
+	}{
+		{"2d6", 2, 6, true},
+		{"d20", 1, 20, true},
+		{"3D8", 3, 8, true},
+		{"6", 0, 0, false},
+		{"0d6", 0, 0, false},
+		{"2d1", 0, 0, false},
+		{"100d6", 0, 0, false},
+		{"xdy", 0, 0, false},
+	}
+	for _, tt := range tests {
+		n, m, err := parseDice(tt.in)
+		if n != tt.n || m != tt.m || (err == nil) != tt.ok {
+			t.Errorf("expected %d %d %t for %s, got %d %d %v", tt.n, tt.m, tt.ok, tt.in, n, m, err)
+		}
+	}
+}
+
+func TestRoll(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		rolls, _ := rollDice(3, 6)
+		for _, r := range rolls {
+			if r < 1 || r > 6 {
+				t.Fatalf("expected roll from 1 to 6, got %d", r)
+			}
+		}
+	}
+
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	if err := serv.Roll(batman, "2d6"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	r := serv.Rooms["gotham"]
+	re := regexp.MustCompile(`^\[batman\] rolled 2d6: \d \+ \d = \d+$`)
+	if len(r.History) != 1 || r.History[0].From != "dice" || !re.MatchString(r.History[0].Text) {
+		t.Errorf("expected roll to be announced, got %+v", r.History)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policies for connections from listed addresses, they are tagged for
+// admins to see, restricted from talking or registering until they log in,
+// or rejected
+const (
+	ListedTag      = "tag"
+	ListedRestrict = "restrict"
+	ListedReject   = "reject"
+)
+
+// DefaultTorExitList is where the Tor exit list is fetched unless
+// TCTorExitList names another
+const DefaultTorExitList = "https://check.torproject.org/torbulkexitlist"
+
+// dnsblTimeout bounds the blocklist lookups of a connection
+const dnsblTimeout = 2 * time.Second
+
+// dnsblCache is how long the lookups of an address are remembered
+const dnsblCache = 10 * time.Minute
+
+// torRefresh is how often the Tor exit list is fetched again
+const torRefresh = time.Hour
+
+// maxTorList bounds the size of the Tor exit list fetched
+const maxTorList = 4 << 20
+
+var errRestricted = errors.New("connections from your address must /login to a registered account to talk\r\n")
+
+// listing is the lists an address was found on, and until when that is
+// remembered
+type listing struct {
+	lists   []string
+	expires time.Time
+}
+
+// Blocklists checks connecting addresses against DNS blocklists and the
+// exits of the Tor network
+type Blocklists struct {
+	Zones  []string
+	Policy string
+	TorURL string
+
+	// lookup resolves a blocklist query, net.DefaultResolver.LookupHost by
+	// default
+	lookup func(ctx context.Context, host string) ([]string, error)
+	client *http.Client
+
+	mu    sync.Mutex
+	tor   map[string]bool
+	cache map[string]listing
+}
+
+// NewBlocklists checks addresses against the DNS blocklist zones and, when
+// torURL is set, the Tor exit list there, policy is what becomes of the
+// connections listed
+func NewBlocklists(zones []string, torURL, policy string) (*Blocklists, error) {
+	switch policy {
+	case "":
+		policy = ListedTag
+	case ListedTag, ListedRestrict, ListedReject:
+	default:
+		return nil, fmt.Errorf("TCListedPolicy must be %s, %s, or %s", ListedTag, ListedRestrict, ListedReject)
+	}
+	return &Blocklists{
+		Zones:  zones,
+		Policy: policy,
+		TorURL: torURL,
+		lookup: net.DefaultResolver.LookupHost,
+		client: &http.Client{Timeout: 30 * time.Second},
+		cache:  make(map[string]listing),
+	}, nil
+}
+
+// dnsblQuery returns the name to look up for ip in zone, the address
+// reversed by octet, or by nibble for IPv6
+func dnsblQuery(ip net.IP, zone string) string {
+	var parts []string
+	if v4 := ip.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprint(v4[i]))
+		}
+	} else {
+		v6 := ip.To16()
+		for i := len(v6) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%x.%x", v6[i]&0xf, v6[i]>>4))
+		}
+	}
+	return strings.Join(parts, ".") + "." + zone
+}
+
+// Check returns the lists ip is on, the zones that answered with an
+// address in 127.0.0.0/8, and tor for an exit of the Tor network
+func (b *Blocklists) Check(ip net.IP) []string {
+	if b == nil || ip == nil {
+		return nil
+	}
+	key := ip.String()
+	now := time.Now()
+	b.mu.Lock()
+	if l, ok := b.cache[key]; ok && now.Before(l.expires) {
+		b.mu.Unlock()
+		return l.lists
+	}
+	tor := b.tor[key]
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsblTimeout)
+	defer cancel()
+	found := make([]bool, len(b.Zones))
+	var wg sync.WaitGroup
+	for i, zone := range b.Zones {
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer wg.Done()
+			addrs, err := b.lookup(ctx, dnsblQuery(ip, zone))
+			if err != nil {
+				return
+			}
+			for _, a := range addrs {
+				if v4 := net.ParseIP(a).To4(); v4 != nil && v4[0] == 127 {
+					found[i] = true
+				}
+			}
+		}(i, zone)
+	}
+	wg.Wait()
+
+	var lists []string
+	for i, zone := range b.Zones {
+		if found[i] {
+			lists = append(lists, zone)
+		}
+	}
+	if tor {
+		lists = append(lists, "tor")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, l := range b.cache {
+		if now.After(l.expires) {
+			delete(b.cache, k)
+		}
+	}
+	b.cache[key] = listing{lists: lists, expires: now.Add(dnsblCache)}
+	return lists
+}
+
+// parseTorList reads the addresses of a Tor exit list, one to a line,
+// comments and anything that isn't an address are skipped
+func parseTorList(r io.Reader) (map[string]bool, error) {
+	out := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// the exit-addresses format puts the address second
+		if len(fields) >= 2 && fields[0] == "ExitAddress" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			out[ip.String()] = true
+		}
+	}
+	return out, sc.Err()
+}
+
+// FetchTor fetches the Tor exit list again
+func (b *Blocklists) FetchTor() error {
+	resp, err := b.client.Get(b.TorURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the Tor exit list: %s", resp.Status)
+	}
+	exits, err := parseTorList(io.LimitReader(resp.Body, maxTorList))
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tor = exits
+	b.cache = make(map[string]listing)
+	return nil
+}
+
+// RunTor fetches the Tor exit list every so often, a failed fetch keeps
+// the list fetched before
+func (b *Blocklists) RunTor() {
+	for {
+		if err := b.FetchTor(); err != nil {
+			errl(err, "")
+		}
+		time.Sleep(torRefresh)
+	}
+}
+
+// Restricts returns true if listed connections are restricted
+func (b *Blocklists) Restricts() bool {
+	return b != nil && b.Policy == ListedRestrict
+}
+
+// Rejects returns true if listed connections are rejected
+func (b *Blocklists) Rejects() bool {
+	return b != nil && b.Policy == ListedReject
+}
+
+// Listed returns the lists the connection's address is on
+func (c *Conn) Listed() []string {
+	return c.listed
+}
+
+// restricted returns true if the client connected from a listed address
+// and hasn't logged in, while listed connections are restricted
+func (s *Server) restricted(cl *Client) bool {
+	if !s.Blocklists.Restricts() || cl.Account() != "" {
+		return false
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, c := range cl.Conns {
+		if len(c.listed) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// silenced returns why the client may not talk, it is muted or restricted
+func (s *Server) silenced(cl *Client) error {
+	if s.muted(cl) {
+		return errMuted
+	}
+	if s.restricted(cl) {
+		return errRestricted
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDNSBLQuery(t *testing.T) {
+	if q := dnsblQuery(net.ParseIP("192.0.2.7"), "zen.example"); q != "7.2.0.192.zen.example" {
+		t.Errorf("expected the octets reversed, got %s", q)
+	}
+	q := dnsblQuery(net.ParseIP("2001:db8::1"), "zen.example")
+	if !strings.HasPrefix(q, "1.0.0.0.0.0.0.0") || !strings.HasSuffix(q, "8.b.d.0.1.0.0.2.zen.example") {
+		t.Errorf("expected the nibbles reversed, got %s", q)
+	}
+}
+
+func TestBlocklists(t *testing.T) {
+	if _, err := NewBlocklists(nil, "", "ignore"); err == nil {
+		t.Errorf("expected an unknown policy to be refused")
+	}
+
+	exits := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# exits\n198.51.100.9\nExitAddress 198.51.100.10 2026-10-16 00:00:00\n")
+	}))
+	defer exits.Close()
+
+	b, err := NewBlocklists([]string{"bad.example", "good.example"}, exits.URL, ListedRestrict)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	var lookups int32
+	b.lookup = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		if host == "7.2.0.192.bad.example" {
+			return []string{"127.0.0.2"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	if err := b.FetchTor(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	if lists := b.Check(net.ParseIP("192.0.2.7")); len(lists) != 1 || lists[0] != "bad.example" {
+		t.Errorf("expected the address listed on bad.example, got %v", lists)
+	}
+	b.Check(net.ParseIP("192.0.2.7"))
+	if lookups != 2 {
+		t.Errorf("expected the lookups remembered, got %d", lookups)
+	}
+	for _, addr := range []string{"198.51.100.9", "198.51.100.10"} {
+		if lists := b.Check(net.ParseIP(addr)); len(lists) != 1 || lists[0] != "tor" {
+			t.Errorf("expected %s to be a Tor exit, got %v", addr, lists)
+		}
+	}
+	if lists := b.Check(net.ParseIP("203.0.113.1")); len(lists) != 0 {
+		t.Errorf("expected the address unlisted, got %v", lists)
+	}
+
+	serv := NewServer()
+	serv.Blocklists = b
+	serv.Accounts.Register("batman", "alfred")
+	joker := &Client{nick: "joker", Conns: []*Conn{{listed: []string{"tor"}}}}
+	serv.JoinRoom("gotham", joker)
+	if err := serv.Message([]string{"hello"}, joker); err != errRestricted {
+		t.Errorf("expected a listed guest kept from talking, got %v", err)
+	}
+	if err := serv.Register(joker, "haha"); err == nil {
+		t.Errorf("expected a listed guest kept from registering")
+	}
+	joker.account = "batman"
+	if err := serv.Message([]string{"hello"}, joker); err != nil {
+		t.Errorf("expected a logged in client to talk, got %v", err)
+	}
+
+	b.Policy = ListedTag
+	joker.account = ""
+	if err := serv.Message([]string{"hello"}, joker); err != nil {
+		t.Errorf("expected tagged clients to talk, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// publicKeySize is the size of the X25519 keys clients publish, as
+	// used by NaCl box
+	publicKeySize = 32
+	// maxCiphertext is the longest encrypted message relayed, in base64
+	maxCiphertext = 8192
+)
+
+// parsePublicKey checks key is a base64 X25519 public key
+func parsePublicKey(key string) error {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(b) != publicKeySize {
+		return fmt.Errorf("a public key is %d bytes of base64\r\n", publicKeySize)
+	}
+	return nil
+}
+
+// fingerprint returns a short form of a public key for people to compare
+// out of band, the first 16 bytes of its SHA-256 in groups of four
+func fingerprint(key string) string {
+	b, _ := base64.StdEncoding.DecodeString(key)
+	digest := sha256.Sum256(b)
+	sum := hex.EncodeToString(digest[:16])
+	var groups []string
+	for i := 0; i < len(sum); i += 4 {
+		groups = append(groups, sum[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// directText returns the text of a private message, marking ciphertext
+func (ev Event) directText() string {
+	if ev.Encrypted {
+		return "(encrypted) " + ev.Text
+	}
+	return ev.Text
+}
+
+// PublicKey returns the public key the client published, empty if none
+func (cl *Client) PublicKey() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.pubkey
+}
+
+// SetPublicKey publishes the client's public key, saved to its account if
+// it is logged in, an empty key withdraws it
+func (s *Server) SetPublicKey(cl *Client, key string) error {
+	if key != "" {
+		if err := parsePublicKey(key); err != nil {
+			return err
+		}
+	}
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.PublicKey = key }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.pubkey = key
+	cl.mu.Unlock()
+	return nil
+}
+
+// PublicKey returns the public key of nick, online or registered
+func (s *Server) PublicKey(nick string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publicKey(nick)
+}
+
+// publicKey is PublicKey without the lock
+func (s *Server) publicKey(nick string) (string, error) {
+	key := ""
+	if c, ok := s.Clients[nick]; ok {
+		key = c.PublicKey()
+	} else if a, ok := s.Accounts.Get(nick); ok {
+		key = a.PublicKey
+	}
+	if key == "" {
+		return "", fmt.Errorf("[%s] has not published a public key\r\n", nick)
+	}
+	return key, nil
+}
+
+// DirectEncrypted relays a message encrypted for nick, the server only
+// checks it looks like ciphertext and passes it on untouched, both sides
+// need a published key so the recipient can decrypt it
+func (s *Server) DirectEncrypted(cl *Client, to, ciphertext string) error {
+	if cl.PublicKey() == "" {
+		return fmt.Errorf("publish your public key with /key first\r\n")
+	}
+	if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil || len(ciphertext) > maxCiphertext {
+		return fmt.Errorf("encrypted messages are base64, at most %d characters\r\n", maxCiphertext)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+	if _, err := s.publicKey(to); err != nil {
+		return err
+	}
+	return s.direct(cl, Event{
+		Type:      EventDirect,
+		Time:      time.Now().Format(time.RFC3339),
+		From:      cl.Nick(),
+		To:        to,
+		Text:      ciphertext,
+		Encrypted: true,
+	})
+}
+
+// keyCommand runs /key
+func (s *Server) keyCommand(in *Input) {
+	if len(in.Args) >= 2 {
+		key := in.Args[1]
+		if key == "clear" {
+			key = ""
+		}
+		if err := s.SetPublicKey(in.Client, key); err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+	}
+	if key := in.Client.PublicKey(); key != "" {
+		in.Client.Write(fmt.Sprintf("Your public key is %s, fingerprint [%s]\r\n", key, fingerprint(key)))
+	} else {
+		in.Client.Write("You have not published a public key\r\n")
+	}
+}
+
+// pubkeyCommand runs /pubkey
+func (s *Server) pubkeyCommand(in *Input) {
+	key, err := s.PublicKey(in.Args[1])
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Public key of [%s] is %s, fingerprint [%s]\r\n", in.Args[1], key, fingerprint(key)))
+}
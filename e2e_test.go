@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedDirect(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", robin)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, publicKeySize))
+	if err := serv.SetPublicKey(batman, "c2hvcnQ="); err == nil {
+		t.Errorf("expected a short key to be refused")
+	}
+	if err := serv.DirectEncrypted(batman, "robin", "c2VjcmV0"); err == nil {
+		t.Errorf("expected a sender without a key to be refused")
+	}
+	serv.SetPublicKey(batman, key)
+	if err := serv.DirectEncrypted(batman, "robin", "c2VjcmV0"); err == nil {
+		t.Errorf("expected a recipient without a key to be refused")
+	}
+	serv.SetPublicKey(robin, key)
+	if err := serv.DirectEncrypted(batman, "robin", "not base64!"); err == nil {
+		t.Errorf("expected plain text to be refused")
+	}
+	if err := serv.DirectEncrypted(batman, "robin", "c2VjcmV0"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	ev := robin.unread[len(robin.unread)-1]
+	if !ev.Encrypted || ev.Text != "c2VjcmV0" || ev.From != "batman" {
+		t.Errorf("expected the ciphertext relayed untouched, got %+v", ev)
+	}
+	if out := ev.String(); !strings.HasSuffix(out, "] (encrypted) c2VjcmV0\r\n") {
+		t.Errorf("unexpected rendering [%s]", out)
+	}
+
+	fp := fingerprint(key)
+	if len(fp) != 39 {
+		t.Errorf("expected 8 groups of 4, got [%s]", fp)
+	}
+	if out, _ := serv.Whois("robin"); !strings.Contains(out, ", key fingerprint ["+fp+"]") {
+		t.Errorf("expected whois to show the fingerprint, got [%s]", out)
+	}
+
+	serv.Dispatch(&Input{Client: robin, Command: "/key", Args: []string{"/key", "clear"}})
+	if _, err := serv.PublicKey("robin"); err == nil {
+		t.Errorf("expected the key to be withdrawn")
+	}
+}
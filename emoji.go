@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// shortcodes are the :name: codes expanded to emoji in what people send
+var shortcodes = map[string]string{
+	"+1":           "👍",
+	"-1":           "👎",
+	"100":          "💯",
+	"bat":          "🦇",
+	"beer":         "🍺",
+	"broken_heart": "💔",
+	"check":        "✅",
+	"clap":         "👏",
+	"coffee":       "☕",
+	"cry":          "😢",
+	"eyes":         "👀",
+	"fire":         "🔥",
+	"grin":         "😁",
+	"heart":        "❤️",
+	"joy":          "😂",
+	"laughing":     "😆",
+	"ok_hand":      "👌",
+	"pray":         "🙏",
+	"rocket":       "🚀",
+	"sad":          "🙁",
+	"shrug":        "🤷",
+	"smile":        "😄",
+	"sob":          "😭",
+	"star":         "⭐",
+	"sunglasses":   "😎",
+	"tada":         "🎉",
+	"thinking":     "🤔",
+	"thumbsdown":   "👎",
+	"thumbsup":     "👍",
+	"wave":         "👋",
+	"wink":         "😉",
+	"x":            "❌",
+}
+
+// shortcodeRe matches a :name: shortcode
+var shortcodeRe = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// expandShortcodes replaces the known shortcodes of text with their emoji,
+// unknown ones are left as typed
+func expandShortcodes(text string) string {
+	return shortcodeRe.ReplaceAllStringFunc(text, func(code string) string {
+		if e, ok := shortcodes[code[1:len(code)-1]]; ok {
+			return e
+		}
+		return code
+	})
+}
+
+// emojiStage expands shortcodes in room messages, replies and direct
+// messages of clients that haven't turned it off, so everyone sees the
+// same emoji whatever they are connected with
+func emojiStage(next Handler) Handler {
+	return func(in *Input) {
+		from := 0
+		switch in.Command {
+		case "":
+		case "/msg", "/reply":
+			from = 2
+		default:
+			next(in)
+			return
+		}
+		if in.Client.Emoji() {
+			for i := from; i < len(in.Args); i++ {
+				in.Args[i] = expandShortcodes(in.Args[i])
+			}
+		}
+		next(in)
+	}
+}
+
+// Emoji returns true if the client's shortcodes are expanded
+func (cl *Client) Emoji() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return !cl.noEmoji
+}
+
+// SetEmoji turns the expansion of the client's shortcodes on or off, saved
+// to its account if it is logged in
+func (s *Server) SetEmoji(cl *Client, on bool) error {
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.NoEmoji = !on }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.noEmoji = !on
+	cl.mu.Unlock()
+	return nil
+}
+
+// emojiCommand runs /emoji
+func (s *Server) emojiCommand(in *Input) {
+	on, err := onOff(in.Args, "expand shortcodes")
+	if err == nil {
+		err = s.SetEmoji(in.Client, on)
+	}
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Emoji shortcodes are %s\r\n", in.Args[1]))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandShortcodes(t *testing.T) {
+	for text, want := range map[string]string{
+		"this is :fire:":         "this is 🔥",
+		":thumbsup::tada:":       "👍🎉",
+		"at 10:30:00 sharp":      "at 10:30:00 sharp",
+		":not_a_code: stays":     ":not_a_code: stays",
+		"half :fire and :heart:": "half :fire and ❤️",
+	} {
+		if got := expandShortcodes(text); got != want {
+			t.Errorf("expected [%s], got [%s]", want, got)
+		}
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+
+	var got []string
+	deliver := func(in *Input) { got = append(got, in.Text()) }
+	serv.Inbound.Run(&Input{Client: batman, Args: []string{"on", ":fire:"}}, deliver)
+	serv.Inbound.Run(&Input{Client: batman, Command: "/msg", Args: []string{"/msg", "robin", ":wave:"}}, deliver)
+	serv.Inbound.Run(&Input{Client: batman, Command: "/topic", Args: []string{"/topic", ":bat:"}}, deliver)
+
+	serv.Dispatch(&Input{Client: batman, Command: "/emoji", Args: []string{"/emoji", "off"}})
+	if batman.Emoji() {
+		t.Errorf("expected emoji to be off")
+	}
+	serv.Inbound.Run(&Input{Client: batman, Args: []string{":fire:"}}, deliver)
+
+	if want := "on 🔥|/msg robin 👋|/topic :bat:|:fire:"; strings.Join(got, "|") != want {
+		t.Errorf("expected [%s], got [%s]", want, strings.Join(got, "|"))
+	}
+}
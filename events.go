@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// event types sent to machine clients
+const (
+	EventText       = "text"
+	EventMessage    = "message"
+	EventBlast      = "blast"
+	EventJoin       = "join"
+	EventLeave      = "leave"
+	EventNick       = "nick"
+	EventService    = "service"
+	EventNotice     = "notice"
+	EventDirect     = "direct"
+	EventCompletion = "completion"
+	EventReconnect  = "reconnect"
+	EventReaction   = "reaction"
+	EventExpire     = "expire"
+	EventReceipt    = "receipt"
+	EventPresence   = "presence"
+	EventQuit       = "quit"
+	EventPing       = "ping"
+	EventKeyword    = "keyword"
+)
+
+// Event is a single unit of output, machine clients receive it as a line
+// of JSON and everyone else receives its text rendering
+type Event struct {
+	ID         int64          `json:"id,omitempty"`
+	Type       string         `json:"type"`
+	Time       string         `json:"time,omitempty"`
+	From       string         `json:"from,omitempty"`
+	To         string         `json:"to,omitempty"`
+	Room       string         `json:"room,omitempty"`
+	Text       string         `json:"text,omitempty"`
+	Prefix     string         `json:"prefix,omitempty"`
+	Token      string         `json:"token,omitempty"`
+	Candidates []Candidate    `json:"candidates,omitempty"`
+	Parent     int64          `json:"parent,omitempty"`
+	Quote      string         `json:"quote,omitempty"`
+	Reactions  map[string]int `json:"reactions,omitempty"`
+	Removed    bool           `json:"removed,omitempty"`
+	IDs        []int64        `json:"ids,omitempty"`
+	Status     string         `json:"status,omitempty"`
+	Encrypted  bool           `json:"encrypted,omitempty"`
+	Keyword    string         `json:"keyword,omitempty"`
+	File       string         `json:"file,omitempty"`
+	Paste      string         `json:"paste,omitempty"`
+	Code       bool           `json:"code,omitempty"`
+}
+
+// Candidate is a completion candidate and what kind of thing it names
+type Candidate struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// String renders the event for humans
+func (ev Event) String() string {
+	switch ev.Type {
+	case EventMessage, EventBlast:
+		return strings.TrimSpace(fmt.Sprintf("[%s:%s] %s", ev.Time, ev.From, ev.body())) + "\r\n"
+	case EventDirect:
+		return strings.TrimSpace(fmt.Sprintf("[%s:%s -> %s] %s", ev.Time, ev.From, ev.To, ev.directText())) + "\r\n"
+	case EventService:
+		return strings.TrimSpace(fmt.Sprintf("[%s:%s (service)] %s", ev.Time, ev.From, ev.Text)) + "\r\n"
+	case EventNotice:
+		return strings.TrimSpace(fmt.Sprintf("-!- [%s:%s] %s", ev.Time, ev.From, ev.Text)) + "\r\n"
+	case EventPresence:
+		if ev.Text != "" {
+			return fmt.Sprintf("[%s] is %s: %s\r\n", ev.From, ev.Status, ev.Text)
+		}
+		return fmt.Sprintf("[%s] is %s\r\n", ev.From, ev.Status)
+	case EventQuit:
+		if ev.Text != "" {
+			return fmt.Sprintf("[%s] has quit: %s\r\n", ev.From, ev.Text)
+		}
+		return fmt.Sprintf("[%s] has quit\r\n", ev.From)
+	case EventReaction:
+		verb := "reacted " + ev.Text + " to"
+		if ev.Removed {
+			verb = "took back " + ev.Text + " from"
+		}
+		return fmt.Sprintf("[%s:%s] %s (%s), reactions: %s\r\n", ev.Time, ev.From, verb, ev.Quote, reactionSummary(ev.Reactions))
+	case EventCompletion:
+		var values []string
+		for _, c := range ev.Candidates {
+			values = append(values, c.Value)
+		}
+		return fmt.Sprintf("Completions for [%s]: %s\r\n", ev.Prefix, strings.Join(values, " "))
+	case EventPing:
+		return fmt.Sprintf("PING %s\r\n", ev.Token)
+	case EventKeyword:
+		return strings.TrimSpace(fmt.Sprintf("[%s:%s in %s] (%s) %s", ev.Time, ev.From, ev.Room, ev.Keyword, ev.body())) + "\r\n"
+	}
+	return ev.Text
+}
+
+// SetJSON switches the connection between text and JSON output
+func (c *Conn) SetJSON(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.json, v)
+}
+
+// JSON returns true if the connection receives JSON events
+func (c *Conn) JSON() bool {
+	return atomic.LoadInt32(&c.json) == 1
+}
+
+// Render returns the event formatted for the connection's mode, text is
+// wrapped to the connection's width
+func (c *Conn) Render(ev Event) string {
+	return c.render(ev, false)
+}
+
+// render is Render, plain text for screen readers when plain is set
+func (c *Conn) render(ev Event, plain bool) string {
+	if !c.JSON() {
+		out := ev.String()
+		if c.Color() && !plain {
+			out = ev.Colored()
+		}
+		if c.IDs() && ev.ID != 0 && ev.Type == EventMessage {
+			out = fmt.Sprintf("#%d %s", ev.ID, out)
+		}
+		if plain {
+			out = plainText(out)
+		}
+		return wrap(out, c.Width())
+	}
+
+	if ev.Type == EventText {
+		ev.Text = strings.TrimRight(ev.Text, "\r\n")
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		errl(err, "")
+		return ""
+	}
+	return string(b) + "\r\n"
+}
+
+// Complete sends the commands, nicks, and rooms that start with prefix to
+// the connection that asked
+func (s *Server) Complete(prefix string, cl *Client, conn *Conn) {
+	commands := s.Commands.Names()
+	s.mu.Lock()
+	var cands []Candidate
+	lower := strings.ToLower(prefix)
+	if strings.HasPrefix(prefix, "/") {
+		for _, c := range commands {
+			if strings.HasPrefix(c, lower) {
+				cands = append(cands, Candidate{Kind: "command", Value: c})
+			}
+		}
+	} else {
+		var nicks, rooms []string
+		for n := range s.Clients {
+			if strings.HasPrefix(strings.ToLower(n), lower) {
+				nicks = append(nicks, n)
+			}
+		}
+		for r := range s.Rooms {
+			if strings.HasPrefix(strings.ToLower(r), lower) {
+				rooms = append(rooms, r)
+			}
+		}
+		sort.Strings(nicks)
+		sort.Strings(rooms)
+		for _, n := range nicks {
+			cands = append(cands, Candidate{Kind: "nick", Value: n})
+		}
+		for _, r := range rooms {
+			cands = append(cands, Candidate{Kind: "room", Value: r})
+		}
+	}
+	s.mu.Unlock()
+
+	ev := Event{Type: EventCompletion, Prefix: prefix, Candidates: cands}
+	conn.send(conn.Render(ev))
+}
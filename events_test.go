@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestEventRender(t *testing.T) {
+	ev := Event{Type: EventMessage, Time: "now", From: "batman", Room: "gotham", Text: "hi freeze"}
+
+	c := NewConn(nil)
+	if c.Render(ev) != "[now:batman] hi freeze\r\n" {
+		t.Errorf("expected text rendering, got [%s]", c.Render(ev))
+	}
+
+	c.SetJSON(true)
+	var got Event
+	if err := json.Unmarshal([]byte(c.Render(ev)), &got); err != nil {
+		t.Fatalf("expected valid JSON, got [%s]", c.Render(ev))
+	}
+
+	if got.Type != ev.Type || got.From != ev.From || got.Room != ev.Room || got.Text != ev.Text {
+		t.Errorf("expected JSON to round trip, got %+v", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	serv := NewServer()
+	serv.JoinRoom("batcave", &Client{nick: "batman"})
+	serv.JoinRoom("gotham", &Client{nick: "bane"})
+
+	c, p := net.Pipe()
+	cn := NewConn(c)
+	cn.SetJSON(true)
+	cl := &Client{nick: "robin", Conns: []*Conn{cn}}
+
+	go serv.Complete("ba", cl, cn)
+
+	line, _ := bufio.NewReader(p).ReadString('\n')
+	var ev Event
+	if err := json.Unmarshal([]byte(line), &ev); err != nil {
+		t.Fatalf("expected valid JSON, got [%s]", line)
+	}
+
+	want := []Candidate{{"nick", "bane"}, {"nick", "batman"}, {"room", "batcave"}}
+	if len(ev.Candidates) != len(want) {
+		t.Fatalf("expected %v, got %v", want, ev.Candidates)
+	}
+	for i := range want {
+		if ev.Candidates[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], ev.Candidates[i])
+		}
+	}
+}
+
+func TestEventColored(t *testing.T) {
+	ev := Event{Type: EventMessage, Time: "now", From: "batman", Text: "hi freeze"}
+
+	c := NewConn(nil)
+	c.SetColor(true)
+	out := c.Render(ev)
+	if out == ev.String() {
+		t.Errorf("expected colored output")
+	}
+
+	if nickColor("batman") != nickColor("batman") {
+		t.Errorf("expected nick colors to be stable")
+	}
+
+	c.SetColor(false)
+	if c.Render(ev) != ev.String() {
+		t.Errorf("expected plain output once color is off")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// minTTL is the shortest lifetime a room may give its messages
+const minTTL = time.Minute
+
+// expiryTick is how often expired messages are purged
+const expiryTick = 10 * time.Second
+
+// parseTTL parses a message lifetime, off is no lifetime
+func parseTTL(arg string) (time.Duration, error) {
+	if arg == "off" || arg == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil || d < minTTL {
+		return 0, fmt.Errorf("[%s] is not a lifetime, use a duration of at least %s, or off\r\n", arg, minTTL)
+	}
+	return d, nil
+}
+
+// TTL returns how long the messages of the client's room last, 0 is forever
+func (s *Server) TTL(cl *Client) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return 0, err
+	}
+	return r.TTL, nil
+}
+
+// SetTTL makes the messages of the client's room disappear after ttl, only
+// the owner of the room, its ops, and moderators may change it
+func (s *Server) SetTTL(cl *Client, ttl time.Duration) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can change how long messages last\r\n")
+	}
+	s.mu.Unlock()
+
+	if err := s.change(raftCommand{Op: raftRoom, Room: r.Name, TTL: ttl, Fields: []string{"ttl"}}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := fmt.Sprintf("[%s] made messages disappear after %s\r\n", cl.Nick(), ttl)
+	if ttl == 0 {
+		text = fmt.Sprintf("[%s] made messages last\r\n", cl.Nick())
+	}
+	for _, c := range r.Clients {
+		c.Write(text)
+	}
+	return nil
+}
+
+// expire drops the messages older than the room's lifetime from its
+// history and returns their ids, it must be called with the server lock
+// held
+func (r *Room) expire(now time.Time) []int64 {
+	if r.TTL <= 0 {
+		return nil
+	}
+	var ids []int64
+	kept := r.History[:0:0]
+	for _, ev := range r.History {
+		t, err := time.Parse(time.RFC3339, ev.Time)
+		if err == nil && now.Sub(t) >= r.TTL && (ev.Type == EventMessage || ev.Type == EventService || ev.Type == EventNotice) {
+			if ev.ID != 0 {
+				ids = append(ids, ev.ID)
+			}
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	if len(kept) == len(r.History) {
+		return nil
+	}
+	r.History = kept
+	for _, id := range ids {
+		delete(r.Reactions, id)
+	}
+	return ids
+}
+
+// Expire purges the expired messages of every room and tells the members
+// which messages to remove, only JSON clients are told
+func (s *Server) Expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.Rooms {
+		ids := r.expire(now)
+		if len(ids) == 0 {
+			continue
+		}
+		s.dropPastes(r, ids)
+		ev := Event{Type: EventExpire, Time: now.Format(time.RFC3339), Room: r.Name, IDs: ids}
+		for _, c := range r.Clients {
+			c.Send(ev)
+		}
+	}
+}
+
+// RunExpiry purges expired messages every so often
+func (s *Server) RunExpiry() {
+	for now := range time.Tick(expiryTick) {
+		s.Expire(now)
+	}
+}
+
+// expireCommand runs /expire
+func (s *Server) expireCommand(in *Input) {
+	if len(in.Args) == 1 {
+		ttl, err := s.TTL(in.Client)
+		if err == nil && ttl == 0 {
+			in.Client.Write("Messages in this room last\r\n")
+			return
+		}
+		reply(in.Client, fmt.Sprintf("Messages in this room disappear after %s\r\n", ttl), err)
+		return
+	}
+	ttl, err := parseTTL(in.Args[1])
+	if err == nil {
+		err = s.SetTTL(in.Client, ttl)
+	}
+	reply(in.Client, "", err)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	serv := NewServer()
+	batman, joker := &Client{nick: "batman"}, &Client{nick: "joker"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", joker)
+
+	if _, err := parseTTL("30s"); err == nil {
+		t.Errorf("expected lifetimes under a minute to be refused")
+	}
+	if err := serv.SetTTL(joker, time.Hour); err == nil {
+		t.Errorf("expected only the owner to set a lifetime")
+	}
+	if err := serv.SetTTL(batman, time.Hour); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	serv.Message([]string{"the", "code", "is", "1939"}, batman)
+	old := batman.unread[len(batman.unread)-1]
+	serv.React([]string{"/react", strconv.FormatInt(old.ID, 10), "🤫"}, joker)
+
+	serv.Expire(time.Now())
+	r := serv.Rooms["batcave"]
+	if len(r.History) != 1 {
+		t.Fatalf("expected the message kept within its lifetime, got %v", r.History)
+	}
+
+	serv.Expire(time.Now().Add(time.Hour))
+	if len(r.History) != 0 || len(r.Reactions) != 0 {
+		t.Errorf("expected the message and its reactions purged, got %v %v", r.History, r.Reactions)
+	}
+	ev := joker.unread[len(joker.unread)-1]
+	if ev.Type != EventExpire || len(ev.IDs) != 1 || ev.IDs[0] != old.ID {
+		t.Errorf("expected members told to remove %d, got %+v", old.ID, ev)
+	}
+	if ttl, _ := serv.TTL(joker); ttl != time.Hour {
+		t.Errorf("expected a lifetime of 1h, got %s", ttl)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exportQueue is how many events may wait for export before new ones are
+// dropped
+const exportQueue = 1024
+
+// exportTimeout bounds every network operation of an export backend
+const exportTimeout = 10 * time.Second
+
+// maxKafkaResponse bounds the size of a response read from a broker,
+// fetches ask for at most 1MB of records
+const maxKafkaResponse = 1<<20 + 64<<10
+
+// emit fans an event out to every integration, it must not block
+func (s *Server) emit(ev Event) {
+	s.Hooks.Fire(ev)
+	for _, e := range s.Exports {
+		e.Export(ev)
+	}
+	s.Cluster.Publish(ev)
+	s.Links.Publish(ev)
+	s.Gossip.Observe(ev)
+	s.Journal.Event(ev)
+}
+
+// publisher is a backend events are exported to
+type publisher interface {
+	Publish(payload []byte) error
+	Close()
+}
+
+// Exporter streams every event as JSON to a message broker
+type Exporter struct {
+	name  string
+	pub   publisher
+	queue chan Event
+
+	// Direct exports direct messages too, they are left out unless the
+	// operator opts in
+	Direct bool
+}
+
+// NewExporter returns an exporter for the backend with its delivery
+// goroutine running
+func NewExporter(name string, pub publisher) *Exporter {
+	e := &Exporter{name: name, pub: pub, queue: make(chan Event, exportQueue)}
+	go e.run()
+	return e
+}
+
+// Export queues the event, it never blocks the caller
+func (e *Exporter) Export(ev Event) {
+	if ev.Type == EventDirect && !e.Direct {
+		return
+	}
+	select {
+	case e.queue <- ev:
+	default:
+		errl(fmt.Errorf("%s export queue is full, dropping %s event", e.name, ev.Type), "")
+	}
+}
+
+func (e *Exporter) run() {
+	for ev := range e.queue {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			errl(err, "")
+			continue
+		}
+		if err := e.pub.Publish(b); err != nil {
+			errl(fmt.Errorf("%s export failed: %v", e.name, err), "")
+			e.pub.Close()
+		}
+	}
+}
+
+// NATS publishes payloads to a subject over the NATS text protocol
+type NATS struct {
+	Addr    string
+	Subject string
+	user    *url.Userinfo
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewNATS returns a publisher for a nats://[user:pass@]host:port URL
+func NewNATS(rawurl, subject string) (*NATS, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS url [%s]", rawurl)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATS{Addr: host, Subject: subject, user: u.User}, nil
+}
+
+// connect dials the server and performs the CONNECT handshake
+func (n *NATS) connect() error {
+	conn, err := net.DialTimeout("tcp", n.Addr, exportTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(exportTimeout))
+
+	buf := bufio.NewReader(conn)
+	info, err := buf.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting [%s]", strings.TrimSpace(info))
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "tinychat", "lang": "go", "version": "1.0.0"}
+	if n.user != nil {
+		opts["user"] = n.user.Username()
+		if p, ok := n.user.Password(); ok {
+			opts["pass"] = p
+		}
+	}
+	b, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", b); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// the server answers PONG once the connection is accepted
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return errors.New(strings.TrimSpace(line))
+		}
+	}
+
+	n.conn = conn
+	go n.keepalive(conn, buf)
+	return nil
+}
+
+// keepalive answers server PINGs until the connection drops
+func (n *NATS) keepalive(conn net.Conn, buf *bufio.Reader) {
+	conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			n.mu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(exportTimeout))
+			conn.Write([]byte("PONG\r\n"))
+			n.mu.Unlock()
+		} else if strings.HasPrefix(line, "-ERR") {
+			errl(errors.New(strings.TrimSpace(line)), "")
+		}
+	}
+}
+
+// Publish sends the payload to the subject, connecting if needed
+func (n *NATS) Publish(payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+
+	n.conn.SetWriteDeadline(time.Now().Add(exportTimeout))
+	_, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", n.Subject, len(payload), payload)
+	return err
+}
+
+// Close drops the connection, the next publish reconnects
+func (n *NATS) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// Kafka produces payloads to partition 0 of a topic using the v3 produce
+// API, the broker must lead that partition, as single broker setups do
+type Kafka struct {
+	Addr  string
+	Topic string
+
+	mu   sync.Mutex
+	conn net.Conn
+	corr int32
+}
+
+// NewKafka returns a producer for the topic on the broker at addr
+func NewKafka(addr, topic string) *Kafka {
+	return &Kafka{Addr: addr, Topic: topic}
+}
+
+// Publish produces the payload as a single record and waits for the
+// leader to acknowledge it
+func (k *Kafka) Publish(payload []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.conn == nil {
+		conn, err := net.DialTimeout("tcp", k.Addr, exportTimeout)
+		if err != nil {
+			return err
+		}
+		k.conn = conn
+	}
+	k.conn.SetDeadline(time.Now().Add(exportTimeout))
+
+	k.corr++
+	if _, err := k.conn.Write(kafkaProduceRequest(k.corr, k.Topic, payload, time.Now())); err != nil {
+		return err
+	}
+
+	resp, err := kafkaResponse(k.conn)
+	if err != nil {
+		return err
+	}
+	return kafkaProduceError(resp)
+}
+
+// Close drops the connection, the next publish reconnects
+func (k *Kafka) Close() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.conn != nil {
+		k.conn.Close()
+		k.conn = nil
+	}
+}
+
+// kafkaString encodes a kafka int16 length prefixed string
+func kafkaString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, int16(len(s)))
+	b.WriteString(s)
+}
+
+// kafkaVarint encodes a zigzag varint as used inside record batches
+func kafkaVarint(b *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	b.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+// kafkaRecordBatch encodes a v2 record batch holding a single record
+func kafkaRecordBatch(value []byte, ts time.Time) []byte {
+	var rec bytes.Buffer
+	rec.WriteByte(0)     // attributes
+	kafkaVarint(&rec, 0) // timestamp delta
+	kafkaVarint(&rec, 0) // offset delta
+	kafkaVarint(&rec, -1)
+	kafkaVarint(&rec, int64(len(value)))
+	rec.Write(value)
+	kafkaVarint(&rec, 0) // headers
+
+	var body bytes.Buffer
+	millis := ts.UnixNano() / int64(time.Millisecond)
+	binary.Write(&body, binary.BigEndian, int16(0)) // attributes
+	binary.Write(&body, binary.BigEndian, int32(0)) // last offset delta
+	binary.Write(&body, binary.BigEndian, millis)   // first timestamp
+	binary.Write(&body, binary.BigEndian, millis)   // max timestamp
+	binary.Write(&body, binary.BigEndian, int64(-1))
+	binary.Write(&body, binary.BigEndian, int16(-1))
+	binary.Write(&body, binary.BigEndian, int32(-1))
+	binary.Write(&body, binary.BigEndian, int32(1)) // records
+	kafkaVarint(&body, int64(rec.Len()))
+	body.Write(rec.Bytes())
+
+	var batch bytes.Buffer
+	binary.Write(&batch, binary.BigEndian, int64(0))                // base offset
+	binary.Write(&batch, binary.BigEndian, int32(body.Len()+4+1+4)) // batch length
+	binary.Write(&batch, binary.BigEndian, int32(-1))               // leader epoch
+	batch.WriteByte(2)                                              // magic
+	binary.Write(&batch, binary.BigEndian, crc32.Checksum(body.Bytes(), crc32.MakeTable(crc32.Castagnoli)))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaProduceRequest encodes a v3 produce request for partition 0
+func kafkaProduceRequest(corr int32, topic string, value []byte, ts time.Time) []byte {
+	batch := kafkaRecordBatch(value, ts)
+
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, int16(0)) // produce
+	binary.Write(&req, binary.BigEndian, int16(3))
+	binary.Write(&req, binary.BigEndian, corr)
+	kafkaString(&req, "tinychat")
+	binary.Write(&req, binary.BigEndian, int16(-1))   // transactional id
+	binary.Write(&req, binary.BigEndian, int16(1))    // acks
+	binary.Write(&req, binary.BigEndian, int32(5000)) // timeout
+	binary.Write(&req, binary.BigEndian, int32(1))    // topics
+	kafkaString(&req, topic)
+	binary.Write(&req, binary.BigEndian, int32(1)) // partitions
+	binary.Write(&req, binary.BigEndian, int32(0))
+	binary.Write(&req, binary.BigEndian, int32(len(batch)))
+	req.Write(batch)
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, int32(req.Len()))
+	out.Write(req.Bytes())
+	return out.Bytes()
+}
+
+// kafkaProduceError returns the error code of the first partition of a v3
+// produce response, if any
+func kafkaProduceError(resp []byte) error {
+	r := bytes.NewReader(resp)
+	var corr, topics, partitions, partition int32
+	var nameLen, code int16
+
+	binary.Read(r, binary.BigEndian, &corr)
+	binary.Read(r, binary.BigEndian, &topics)
+	binary.Read(r, binary.BigEndian, &nameLen)
+	if nameLen > 0 {
+		r.Seek(int64(nameLen), io.SeekCurrent)
+	}
+	binary.Read(r, binary.BigEndian, &partitions)
+	binary.Read(r, binary.BigEndian, &partition)
+	if err := binary.Read(r, binary.BigEndian, &code); err != nil {
+		return errors.New("short kafka produce response")
+	}
+	if code != 0 {
+		return fmt.Errorf("kafka produce failed with error code %d", code)
+	}
+	return nil
+}
+
+// Fetch reads the records of partition 0 from offset, it returns their
+// values, the offset to fetch next, and the partition's high watermark
+func (k *Kafka) Fetch(offset int64) ([][]byte, int64, int64, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.conn == nil {
+		conn, err := net.DialTimeout("tcp", k.Addr, exportTimeout)
+		if err != nil {
+			return nil, offset, 0, err
+		}
+		k.conn = conn
+	}
+	k.conn.SetDeadline(time.Now().Add(exportTimeout))
+
+	k.corr++
+	if _, err := k.conn.Write(kafkaFetchRequest(k.corr, k.Topic, offset)); err != nil {
+		return nil, offset, 0, err
+	}
+
+	resp, err := kafkaResponse(k.conn)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+	return kafkaFetchResponse(resp, offset)
+}
+
+// kafkaResponse reads a size prefixed response from the broker
+func kafkaResponse(r io.Reader) ([]byte, error) {
+	var size int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size <= 0 || size > maxKafkaResponse {
+		return nil, fmt.Errorf("kafka response of %d bytes is out of bounds", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(r, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// kafkaFetchRequest encodes a v4 fetch request for partition 0
+func kafkaFetchRequest(corr int32, topic string, offset int64) []byte {
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, int16(1)) // fetch
+	binary.Write(&req, binary.BigEndian, int16(4))
+	binary.Write(&req, binary.BigEndian, corr)
+	kafkaString(&req, "tinychat")
+	binary.Write(&req, binary.BigEndian, int32(-1))    // replica id
+	binary.Write(&req, binary.BigEndian, int32(500))   // max wait
+	binary.Write(&req, binary.BigEndian, int32(1))     // min bytes
+	binary.Write(&req, binary.BigEndian, int32(1<<20)) // max bytes
+	req.WriteByte(0)                                   // isolation level
+	binary.Write(&req, binary.BigEndian, int32(1))     // topics
+	kafkaString(&req, topic)
+	binary.Write(&req, binary.BigEndian, int32(1)) // partitions
+	binary.Write(&req, binary.BigEndian, int32(0))
+	binary.Write(&req, binary.BigEndian, offset)
+	binary.Write(&req, binary.BigEndian, int32(1<<20))
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, int32(req.Len()))
+	out.Write(req.Bytes())
+	return out.Bytes()
+}
+
+// kafkaFetchResponse decodes the records of the first partition of a v4
+// fetch response, records before offset are skipped
+func kafkaFetchResponse(resp []byte, offset int64) ([][]byte, int64, int64, error) {
+	r := bytes.NewReader(resp)
+	var corr, throttle, topics, partitions, partition, aborted, size int32
+	var nameLen, code int16
+	var high, stable int64
+
+	binary.Read(r, binary.BigEndian, &corr)
+	binary.Read(r, binary.BigEndian, &throttle)
+	binary.Read(r, binary.BigEndian, &topics)
+	binary.Read(r, binary.BigEndian, &nameLen)
+	if nameLen > 0 {
+		r.Seek(int64(nameLen), io.SeekCurrent)
+	}
+	binary.Read(r, binary.BigEndian, &partitions)
+	binary.Read(r, binary.BigEndian, &partition)
+	binary.Read(r, binary.BigEndian, &code)
+	binary.Read(r, binary.BigEndian, &high)
+	binary.Read(r, binary.BigEndian, &stable)
+	binary.Read(r, binary.BigEndian, &aborted)
+	if aborted > 0 {
+		r.Seek(int64(aborted)*16, io.SeekCurrent)
+	}
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, offset, 0, errors.New("short kafka fetch response")
+	}
+	if code != 0 {
+		return nil, offset, high, fmt.Errorf("kafka fetch failed with error code %d", code)
+	}
+
+	if size < 0 || int(size) > r.Len() {
+		return nil, offset, high, errors.New("short kafka fetch response")
+	}
+	records := make([]byte, size)
+	io.ReadFull(r, records)
+
+	var values [][]byte
+	next := offset
+	for len(records) >= 12 {
+		base := int64(binary.BigEndian.Uint64(records))
+		length := int(binary.BigEndian.Uint32(records[8:]))
+		// the broker may cut the last batch short
+		if len(records) < 12+length {
+			break
+		}
+		batch := records[12 : 12+length]
+		records = records[12+length:]
+
+		if len(batch) < 49 || batch[4] != 2 {
+			return values, next, high, errors.New("unsupported kafka record batch")
+		}
+		if binary.BigEndian.Uint16(batch[9:])&0x07 != 0 {
+			return values, next, high, errors.New("compressed kafka record batches are not supported")
+		}
+		last := base + int64(int32(binary.BigEndian.Uint32(batch[11:])))
+
+		br := bytes.NewReader(batch[49:])
+		count := int(binary.BigEndian.Uint32(batch[45:]))
+		for i := 0; i < count; i++ {
+			if _, err := binary.ReadVarint(br); err != nil { // length
+				return values, next, high, err
+			}
+			br.ReadByte()         // attributes
+			binary.ReadVarint(br) // timestamp delta
+			delta, _ := binary.ReadVarint(br)
+			keyLen, _ := binary.ReadVarint(br)
+			if keyLen > 0 {
+				br.Seek(keyLen, io.SeekCurrent)
+			}
+			valueLen, _ := binary.ReadVarint(br)
+			var value []byte
+			if valueLen >= 0 {
+				value = make([]byte, valueLen)
+				io.ReadFull(br, value)
+			}
+			headers, _ := binary.ReadVarint(br)
+			for h := int64(0); h < headers; h++ {
+				n, _ := binary.ReadVarint(br)
+				br.Seek(n, io.SeekCurrent)
+				n, _ = binary.ReadVarint(br)
+				if n > 0 {
+					br.Seek(n, io.SeekCurrent)
+				}
+			}
+
+			if base+delta >= offset {
+				values = append(values, value)
+			}
+		}
+		if last+1 > next {
+			next = last + 1
+		}
+	}
+	return values, next, high, nil
+}
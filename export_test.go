@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNATSPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		buf := bufio.NewReader(conn)
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				conn.Write([]byte("PONG\r\n"))
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := buf.ReadString('\n')
+				got <- line + payload
+			}
+		}
+	}()
+
+	n, err := NewNATS("nats://"+ln.Addr().String(), "tinychat.events")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	serv := NewServer()
+	serv.Exports = []*Exporter{NewExporter("nats", n)}
+	serv.JoinRoom("gotham", &Client{nick: "batman"})
+
+	select {
+	case msg := <-got:
+		if !strings.HasPrefix(msg, "PUB tinychat.events ") || !strings.Contains(msg, `"type":"join"`) {
+			t.Errorf("unexpected publish [%s]", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the join event to be published")
+	}
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	batch := kafkaRecordBatch([]byte(`{"type":"join"}`), time.Now())
+
+	var length int32
+	binary.Read(bytes.NewReader(batch[8:12]), binary.BigEndian, &length)
+	if int(length) != len(batch)-12 {
+		t.Errorf("expected batch length %d, got %d", len(batch)-12, length)
+	}
+
+	if batch[16] != 2 {
+		t.Errorf("expected magic 2, got %d", batch[16])
+	}
+
+	crc := binary.BigEndian.Uint32(batch[17:21])
+	if crc != crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) {
+		t.Errorf("expected crc to cover the batch body")
+	}
+
+	if kafkaProduceError([]byte{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 't', 0, 0, 0, 1, 0, 0, 0, 0, 0, 6}) == nil {
+		t.Errorf("expected error code 6 to be reported")
+	}
+
+	// a broker can't make us allocate whatever it claims
+	for _, size := range []int32{-1, 0, 1 << 30} {
+		var b bytes.Buffer
+		binary.Write(&b, binary.BigEndian, size)
+		if _, err := kafkaResponse(&b); err == nil {
+			t.Errorf("expected a response of %d bytes refused", size)
+		}
+	}
+}
+
+func TestExportDirect(t *testing.T) {
+	e := &Exporter{name: "test", queue: make(chan Event, 2)}
+	e.Export(Event{Type: EventDirect})
+	e.Export(Event{Type: EventMessage})
+	if len(e.queue) != 1 {
+		t.Errorf("expected direct messages left out")
+	}
+	e.Direct = true
+	e.Export(Event{Type: EventDirect})
+	if len(e.queue) != 2 {
+		t.Errorf("expected direct messages exported once opted in")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// feedEntries is the number of recent messages in a room feed
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Link    atomLink   `xml:"link"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Content string     `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// serveFeed renders the recent messages of a public room as Atom
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request, roomname string) {
+	history, ok := s.PublicHistory(roomname)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	self := fmt.Sprintf("http://%s/rooms/%s/feed.atom", r.Host, url.PathEscape(roomname))
+	feed := atomFeed{
+		ID:      "urn:tinychat:room:" + url.PathEscape(roomname),
+		Title:   roomname,
+		Updated: time.Now().Format(time.RFC3339),
+		Link:    atomLink{Href: self, Rel: "self"},
+	}
+
+	// newest first
+	for i := len(history) - 1; i >= 0 && len(feed.Entries) < feedEntries; i-- {
+		ev := history[i]
+		if ev.Type != EventMessage && ev.Type != EventService && ev.Type != EventNotice {
+			continue
+		}
+		if len(feed.Entries) == 0 {
+			feed.Updated = ev.Time
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("urn:tinychat:message:%d", ev.ID),
+			Title:   fmt.Sprintf("%s: %s", ev.From, truncate(ev.Text, 60)),
+			Link:    atomLink{Href: fmt.Sprintf("http://%s%s", r.Host, messageURL(roomname, ev.ID))},
+			Updated: ev.Time,
+			Author:  atomAuthor{Name: ev.From},
+			Content: ev.Text,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		errl(err, "")
+	}
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoomFeed(t *testing.T) {
+	serv := NewServer()
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("wayne manor", batman)
+	serv.Message([]string{"the", "batcave", "is", "under", "the", "manor"}, batman)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, httptest.NewRequest("GET", "/rooms/wayne%20manor/feed.atom", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Errorf("expected private room to be hidden, got %d", w.Code)
+	}
+
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("wayne manor", robin)
+	if err := serv.SetPublic(robin, true); err == nil {
+		t.Errorf("expected only the owner to publish the room")
+	}
+
+	if err := serv.SetPublic(batman, true); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	serv.Message([]string{"hi", "freeze"}, batman)
+
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected feed, got %d", w.Code)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("expected valid Atom, got [%s]", w.Body.String())
+	}
+
+	if len(feed.Entries) != 1 || feed.Entries[0].Content != "hi freeze" || feed.Entries[0].Author.Name != "batman" {
+		t.Errorf("unexpected entries %+v", feed.Entries)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// file transfer defaults, the largest upload and how long a file is kept
+const (
+	DefaultFileMaxSize = 10 << 20
+	DefaultFileTTL     = 24 * time.Hour
+)
+
+// uploadWindow is how long an upload link may be used
+const uploadWindow = 10 * time.Minute
+
+// filesTick is how often expired files are removed
+const filesTick = time.Minute
+
+// pendingUpload is an upload a client asked for, to its room or to a user
+type pendingUpload struct {
+	From    string
+	Room    string
+	To      string
+	Name    string
+	Expires time.Time
+}
+
+// StoredFile is an uploaded file kept until it expires
+type StoredFile struct {
+	ID      string
+	Name    string
+	From    string
+	Type    string
+	Size    int64
+	Expires time.Time
+	sealed  bool
+}
+
+// Files accepts uploads over the HTTP API, keeps them in a directory for a
+// while, and serves them to whoever has the link, URL is where the HTTP API
+// is reached
+type Files struct {
+	URL     string
+	MaxSize int64
+	TTL     time.Duration
+
+	mu      sync.Mutex
+	dir     string
+	pending map[string]pendingUpload
+	files   map[string]*StoredFile
+}
+
+// filesDir is the directory under TCFiles the server keeps uploads in, it
+// is the only one it clears so nothing else in TCFiles is touched
+const filesDir = "tinychat-uploads"
+
+// OpenFiles keeps uploads in a directory of its own under dir, files are
+// only kept for a while so any left from before a restart are removed
+func OpenFiles(dir, baseURL string, maxSize int64, ttl time.Duration) (*Files, error) {
+	dir = filepath.Join(dir, filesDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Files{
+		URL:     strings.TrimSuffix(baseURL, "/"),
+		MaxSize: maxSize,
+		TTL:     ttl,
+		dir:     dir,
+		pending: make(map[string]pendingUpload),
+		files:   make(map[string]*StoredFile),
+	}, nil
+}
+
+// parseFiles reads the largest upload and how long files are kept from the
+// environment
+func parseFiles() (int64, time.Duration, error) {
+	size, ttl := int64(DefaultFileMaxSize), DefaultFileTTL
+	var err error
+	if v := os.Getenv("TCFileMaxSize"); len(v) > 0 {
+		if size, err = strconv.ParseInt(v, 10, 64); err != nil || size <= 0 {
+			return 0, 0, fmt.Errorf("error parsing TCFileMaxSize: expected a number of bytes, got [%s]", v)
+		}
+	}
+	if v := os.Getenv("TCFileTTL"); len(v) > 0 {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return 0, 0, fmt.Errorf("error parsing TCFileTTL: expected a duration, got [%s]", v)
+		}
+	}
+	return size, ttl, nil
+}
+
+// fileSize describes a number of bytes
+func fileSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// Expect issues the link the upload is sent to
+func (f *Files) Expect(up pendingUpload) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	up.Expires = time.Now().Add(uploadWindow)
+	f.pending[token] = up
+	return f.URL + "/files/upload/" + token, nil
+}
+
+// claim returns the upload of the token, a link is used once
+func (f *Files) claim(token string) (pendingUpload, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	up, ok := f.pending[token]
+	delete(f.pending, token)
+	return up, ok && time.Now().Before(up.Expires)
+}
+
+// Store keeps the contents of an upload, sealed with the data key when
+// there is one, and returns the file kept
+func (f *Files) Store(up pendingUpload, b []byte) (*StoredFile, error) {
+	id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	return f.store(&StoredFile{ID: id, Name: up.Name, From: up.From}, b)
+}
+
+// store writes b as the contents of sf and keeps it until it expires
+func (f *Files) store(sf *StoredFile, b []byte) (*StoredFile, error) {
+	data, err := sealData(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(f.dir, sf.ID), data, 0600); err != nil {
+		return nil, err
+	}
+	sf.Size = int64(len(b))
+	sf.Expires = time.Now().Add(f.TTL)
+	sf.sealed = !bytes.Equal(data, b)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[sf.ID] = sf
+	return sf, nil
+}
+
+// Link returns where the file is downloaded from
+func (f *Files) Link(sf *StoredFile) string {
+	return fmt.Sprintf("%s/files/%s/%s", f.URL, sf.ID, url.PathEscape(sf.Name))
+}
+
+// Open returns the file with id and its contents, false once it expired
+func (f *Files) Open(id string) (*StoredFile, []byte, bool) {
+	f.mu.Lock()
+	sf, ok := f.files[id]
+	f.mu.Unlock()
+	if !ok || time.Now().After(sf.Expires) {
+		return nil, nil, false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(f.dir, id))
+	if err == nil && sf.sealed {
+		b, err = openData(b)
+	}
+	if err != nil {
+		errl(err, "")
+		return nil, nil, false
+	}
+	return sf, b, true
+}
+
+// Expire removes the files and upload links that expired
+func (f *Files) Expire(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for token, up := range f.pending {
+		if now.After(up.Expires) {
+			delete(f.pending, token)
+		}
+	}
+	for id, sf := range f.files {
+		if now.After(sf.Expires) {
+			f.remove(id)
+		}
+	}
+}
+
+// Remove removes the file with id, one that was stored but never shared
+func (f *Files) Remove(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.remove(id)
+}
+
+// remove removes the file with id, it must be called with the lock held
+func (f *Files) remove(id string) {
+	delete(f.files, id)
+	if err := os.Remove(filepath.Join(f.dir, id)); err != nil && !os.IsNotExist(err) {
+		errl(err, "")
+	}
+}
+
+// Forget removes the files nick sent and its unused upload links
+func (f *Files) Forget(nick string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for token, up := range f.pending {
+		if up.From == nick {
+			delete(f.pending, token)
+		}
+	}
+	for id, sf := range f.files {
+		if sf.From == nick {
+			f.remove(id)
+		}
+	}
+}
+
+// Run removes expired files every so often
+func (f *Files) Run() {
+	for now := range time.Tick(filesTick) {
+		f.Expire(now)
+	}
+}
+
+// RequestUpload issues the client a link to upload a file to its room, or
+// to the user to when it isn't empty
+// example: /upload map.png robin
+func (s *Server) RequestUpload(cl *Client, name, to string) (string, error) {
+	if s.Files == nil {
+		return "", fmt.Errorf("file transfer is not enabled on this server\r\n")
+	}
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == "/" || strings.ContainsAny(name, "\r\n") {
+		return "", fmt.Errorf("[%s] is not a file name\r\n", name)
+	}
+
+	s.mu.Lock()
+	if err := s.silenced(cl); err != nil {
+		s.mu.Unlock()
+		return "", err
+	}
+	if err := s.probation(cl, "send files"); err != nil {
+		s.mu.Unlock()
+		return "", err
+	}
+	up := pendingUpload{From: cl.Nick(), Name: name, To: to}
+	if to == "" {
+		r, err := s.findRoom(cl)
+		if err != nil {
+			s.mu.Unlock()
+			return "", err
+		}
+		up.Room = r.Name
+	} else if _, ok := s.Clients[to]; !ok {
+		s.mu.Unlock()
+		return "", fmt.Errorf("user [%s] does not exist\r\n", to)
+	}
+	s.mu.Unlock()
+
+	link, err := s.Files.Expect(up)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Upload %s, up to %s, within %s: curl -T %q %s\r\n", name, fileSize(s.Files.MaxSize), uploadWindow, name, link), nil
+}
+
+// shareFile posts the link to an uploaded file to the room or the user it
+// was uploaded for, as a message from whoever uploaded it, the uploader is
+// checked again since they may have been muted or left the room while
+// uploading, and the file counts against their quota
+func (s *Server) shareFile(up pendingUpload, sf *StoredFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl, ok := s.Clients[up.From]
+	if !ok {
+		return fmt.Errorf("user [%s] left\r\n", up.From)
+	}
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+	if err := s.probation(cl, "send files"); err != nil {
+		return err
+	}
+
+	link := s.Files.Link(sf)
+	ev := Event{
+		Type: EventMessage,
+		Time: time.Now().Format(time.RFC3339),
+		From: up.From,
+		Text: fmt.Sprintf("shared %s (%s) %s", sf.Name, fileSize(sf.Size), link),
+		File: link,
+	}
+	if up.To != "" {
+		if err := s.takeQuota(cl); err != nil {
+			return err
+		}
+		ev.Type, ev.To = EventDirect, up.To
+		return s.direct(cl, ev)
+	}
+	r, ok := s.Rooms[up.Room]
+	if !ok {
+		return fmt.Errorf("room [%s] does not exist\r\n", up.Room)
+	}
+	if cur, err := s.findRoom(cl); err != nil || cur != r {
+		return fmt.Errorf("you are no longer in [%s]\r\n", up.Room)
+	}
+	if err := s.takeQuota(cl); err != nil {
+		return err
+	}
+	ev.Room, ev.ID = r.Name, s.nextID()
+	s.post(r, ev)
+	return nil
+}
+
+// handleFiles accepts uploads at /files/upload/<token> and serves the
+// files at /files/<id>/<name>
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if s.Files == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/files/")
+	if token := strings.TrimPrefix(rest, "upload/"); token != rest {
+		s.handleUpload(w, r, token)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.SplitN(rest, "/", 2)[0]
+	sf, b, ok := s.Files.Open(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// files are never rendered, a page uploaded can't run in the server's
+	// origin
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sf.Name))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+// handleUpload stores the body of the request sent to an upload link and
+// shares the file
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	up, ok := s.Files.claim(token)
+	if !ok {
+		http.Error(w, "unknown or expired upload link", http.StatusNotFound)
+		return
+	}
+	if r.ContentLength > s.Files.MaxSize {
+		http.Error(w, fmt.Sprintf("files may be up to %s", fileSize(s.Files.MaxSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.Files.MaxSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("files may be up to %s", fileSize(s.Files.MaxSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	sf, err := s.Files.Store(up, b)
+	if err != nil {
+		errl(err, "")
+		http.Error(w, "unable to store the file", http.StatusInternalServerError)
+		return
+	}
+	if err := s.shareFile(up, sf); err != nil {
+		s.Files.Remove(sf.ID)
+		http.Error(w, strings.TrimSpace(err.Error()), http.StatusForbidden)
+		return
+	}
+	errl(nil, fmt.Sprintf("[%s] uploaded %s (%s)", up.From, sf.Name, fileSize(sf.Size)))
+	fmt.Fprintf(w, "%s\n", s.Files.Link(sf))
+}
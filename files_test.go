@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiles(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("gotham", robin)
+
+	if _, err := serv.RequestUpload(batman, "map.png", ""); err == nil {
+		t.Errorf("expected uploads refused while file transfer is off")
+	}
+
+	dir := filepath.Join(t.TempDir(), "files")
+	os.MkdirAll(dir, 0700)
+	ioutil.WriteFile(filepath.Join(dir, "keep.txt"), []byte("not ours"), 0600)
+	files, err := OpenFiles(dir, "http://chat.example/", 16, time.Hour)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Errorf("expected files the server didn't write left alone, got %v", err)
+	}
+	serv.Files = files
+
+	if _, err := serv.RequestUpload(batman, "map.png", "joker"); err == nil {
+		t.Errorf("expected an upload to a missing user refused")
+	}
+	out, err := serv.RequestUpload(batman, "../../etc/map.png", "")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	i := strings.Index(out, "http://chat.example/files/upload/")
+	if i < 0 || !strings.Contains(out, "\"map.png\"") {
+		t.Fatalf("expected an upload link for map.png, got %q", out)
+	}
+	link := strings.TrimSpace(out[i:])
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, strings.TrimPrefix(target, "http://chat.example"), strings.NewReader(body))
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PUT", link, "far too large a file"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a large upload refused, got %d", w.Code)
+	}
+	if w := do("PUT", link, "small"); w.Code != http.StatusNotFound {
+		t.Errorf("expected an upload link used once, got %d", w.Code)
+	}
+
+	out, _ = serv.RequestUpload(batman, "map.png", "")
+	link = strings.TrimSpace(out[strings.Index(out, "http://"):])
+	w := do("PUT", link, "the batcave")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the upload accepted, got %d %s", w.Code, w.Body.String())
+	}
+	download := strings.TrimSpace(w.Body.String())
+
+	h := serv.Rooms["batcave"].History
+	if len(h) == 0 || h[len(h)-1].File != download || h[len(h)-1].From != "batman" {
+		t.Fatalf("expected the download link posted to the room, got %+v", h)
+	}
+
+	w = do("GET", download, "")
+	if b, _ := ioutil.ReadAll(w.Body); w.Code != http.StatusOK || string(b) != "the batcave" {
+		t.Errorf("expected the file downloaded, got %d %q", w.Code, b)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+		t.Errorf("expected the file served as an attachment, got %q", cd)
+	}
+
+	// the uploader must still be in the room when the upload is done
+	out, _ = serv.RequestUpload(batman, "plans.txt", "")
+	link = strings.TrimSpace(out[strings.Index(out, "http://"):])
+	serv.JoinRoom("gotham", batman)
+	if w := do("PUT", link, "plans"); w.Code != http.StatusForbidden {
+		t.Errorf("expected an upload to a room left refused, got %d", w.Code)
+	}
+	serv.JoinRoom("batcave", batman)
+	if len(files.files) != 1 {
+		t.Errorf("expected a refused upload removed, got %d file(s)", len(files.files))
+	}
+
+	// to a user
+	out, _ = serv.RequestUpload(batman, "note.txt", "robin")
+	link = strings.TrimSpace(out[strings.Index(out, "http://"):])
+	if w := do("POST", link, "hi"); w.Code != http.StatusOK {
+		t.Errorf("expected the upload accepted, got %d", w.Code)
+	}
+	if len(serv.Rooms["gotham"].History) != 0 {
+		t.Errorf("expected a file to a user kept out of rooms")
+	}
+
+	// uploads count against the quota
+	serv.Quotas.Configure(QuotaLimits{}, QuotaLimits{Hour: 1})
+	for _, want := range []int{http.StatusOK, http.StatusForbidden} {
+		out, _ = serv.RequestUpload(batman, "map.png", "")
+		link = strings.TrimSpace(out[strings.Index(out, "http://"):])
+		if w := do("PUT", link, "map"); w.Code != want {
+			t.Errorf("expected %d for an upload against the quota, got %d", want, w.Code)
+		}
+	}
+
+	files.Expire(time.Now().Add(2 * time.Hour))
+	if w := do("GET", download, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected an expired file gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Find describes the clients whose nick matches pattern, ignoring case, a
+// pattern ending in * matches a prefix, with the room each is in and where
+// each of their connections comes from, since when, and how long it has
+// been idle
+func (s *Server) Find(pattern string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nicks []string
+	for nick := range s.Clients {
+		if reservedMatch(nick, pattern) {
+			nicks = append(nicks, nick)
+		}
+	}
+	if len(nicks) == 0 {
+		return "", fmt.Errorf("nobody matching [%s] is connected\r\n", pattern)
+	}
+	sort.Strings(nicks)
+
+	var b strings.Builder
+	for _, nick := range nicks {
+		cl := s.Clients[nick]
+		fmt.Fprintf(&b, "[%s]", nick)
+		if a := cl.Account(); a != "" {
+			fmt.Fprintf(&b, " logged in as [%s]", a)
+		}
+		if r, err := s.findRoom(cl); err == nil {
+			fmt.Fprintf(&b, " in room [%s]", r.Display)
+		} else {
+			b.WriteString(" in no room")
+		}
+		fmt.Fprintf(&b, ", %s\r\n", cl.Status())
+
+		cl.mu.Lock()
+		conns := append([]*Conn{}, cl.Conns...)
+		cl.mu.Unlock()
+		if len(conns) == 0 {
+			b.WriteString("  no connections, the session waits to be resumed\r\n")
+		}
+		for _, cn := range conns {
+			addr := "unknown"
+			if cn.Conn != nil {
+				addr = cn.RemoteAddr().String()
+				if country := s.Country(addrIP(cn.RemoteAddr())); country != "" {
+					addr += " (" + country + ")"
+				}
+			}
+			fmt.Fprintf(&b, "  from %s since %s, idle %s, capabilities: %s", addr, cn.Connected.Format(time.RFC1123), cn.Idle().Truncate(time.Second), listOrNone(cn.Capabilities()))
+			if listed := cn.Listed(); len(listed) > 0 {
+				fmt.Fprintf(&b, ", listed on %s", strings.Join(listed, ", "))
+			}
+			b.WriteString("\r\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	serv := NewServer()
+	c1, _ := net.Pipe()
+	conn := NewConn(c1)
+	conn.SetJSON(true)
+	conn.listed = []string{"tor"}
+	batman := &Client{nick: "batman", account: "batman", Conns: []*Conn{conn}}
+	bane := &Client{nick: "bane", Conns: []*Conn{NewConn(nil)}}
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("gotham", bane)
+	serv.JoinRoom("gotham", robin)
+
+	out, err := serv.Find("BAT*")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !strings.HasPrefix(out, "[batman] logged in as [batman] in room [batcave], online\r\n  from pipe since") {
+		t.Errorf("expected batman found, got [%s]", out)
+	}
+	if !strings.Contains(out, "capabilities: json, listed on tor\r\n") || strings.Contains(out, "bane") {
+		t.Errorf("expected only batman's connection, got [%s]", out)
+	}
+
+	out, _ = serv.Find("ba*")
+	if strings.Index(out, "[bane] in room [gotham]") != 0 || !strings.Contains(out, "[batman]") || !strings.Contains(out, "from unknown since") {
+		t.Errorf("expected bane and batman in order, got [%s]", out)
+	}
+	if out, _ := serv.Find("robin"); !strings.Contains(out, "no connections") {
+		t.Errorf("expected robin without connections, got [%s]", out)
+	}
+	if _, err := serv.Find("joker"); err == nil {
+		t.Errorf("expected nobody found")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// connect gate modes, new connections must solve a proof of work or answer
+// a question before they are let in
+const (
+	GateOff     = "off"
+	GatePOW     = "pow"
+	GateCaptcha = "captcha"
+)
+
+// DefaultGateBits is how many leading zero bits a proof of work needs
+const DefaultGateBits = 20
+
+// maxGateBits keeps the proof of work solvable
+const maxGateBits = 28
+
+// gateTimeout is how long a new connection has to pass the gate
+const gateTimeout = time.Minute
+
+// gateTries is how many answers to a question a connection may give
+const gateTries = 3
+
+// numberWords spells out the numbers captcha questions use
+var numberWords = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten"}
+
+// powValid returns true if the SHA-256 of challenge:answer starts with bits
+// zero bits
+func powValid(challenge, answer string, bits int) bool {
+	sum := sha256.Sum256([]byte(challenge + ":" + answer))
+	for i := 0; i < bits; i++ {
+		if sum[i/8]&(0x80>>uint(i%8)) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// randInt returns a random number from 0 to n-1
+func randInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
+
+// parseGate parses a gate mode and, for proofs of work, the bits needed
+func parseGate(mode, bits string) (string, int, error) {
+	n := DefaultGateBits
+	if bits != "" {
+		var err error
+		if n, err = strconv.Atoi(bits); err != nil || n < 1 || n > maxGateBits {
+			return "", 0, fmt.Errorf("bits must be from 1 to %d\r\n", maxGateBits)
+		}
+	}
+	switch mode {
+	case GateOff, GatePOW, GateCaptcha:
+		return mode, n, nil
+	}
+	return "", 0, fmt.Errorf("[%s] is not a gate, try off, pow, or captcha\r\n", mode)
+}
+
+// Gate returns the connect gate mode and the bits a proof of work needs
+func (s *Server) Gate() (string, int) {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	if s.gate == "" {
+		return GateOff, s.gateBits
+	}
+	return s.gate, s.gateBits
+}
+
+// SetGate changes the connect gate, connections already let in stay
+func (s *Server) SetGate(by, mode string, bits int) {
+	s.cfg.Lock()
+	s.gate, s.gateBits = mode, bits
+	s.cfg.Unlock()
+
+	s.mu.Lock()
+	s.logMod(by, "gate "+mode, "", "")
+	s.mu.Unlock()
+	errl(nil, fmt.Sprintf("Connect gate set to [%s] by [%s]", mode, by))
+}
+
+// Admit holds a new connection at the gate, it returns false if the
+// connection didn't pass and should be closed
+func (s *Server) Admit(conn net.Conn, buf *bufio.Reader) bool {
+	mode, bits := s.Gate()
+	if mode == GateOff {
+		return true
+	}
+	conn.SetReadDeadline(time.Now().Add(gateTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var err error
+	if mode == GatePOW {
+		err = admitPOW(conn, buf, bits)
+	} else {
+		err = admitCaptcha(conn, buf)
+	}
+	if err != nil {
+		errl(fmt.Errorf("%s kept out by the gate: %v", logAddr(conn.RemoteAddr()), err), "")
+		conn.Write([]byte("You were not let in\r\n"))
+		return false
+	}
+	return true
+}
+
+// admitPOW asks for a proof of work, the last line of the challenge is
+// meant for clients to solve it without their user
+func admitPOW(conn net.Conn, buf *bufio.Reader, bits int) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	challenge := hex.EncodeToString(b)
+	fmt.Fprintf(conn, "This server is checking new connections, send a number N where the SHA-256 of %s:N starts with %d zero bits\r\n", challenge, bits)
+	fmt.Fprintf(conn, "POW %s %d\r\n", challenge, bits)
+
+	line, err := buf.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !powValid(challenge, strings.TrimSpace(line), bits) {
+		return errors.New("wrong proof of work")
+	}
+	return nil
+}
+
+// admitCaptcha asks a simple sum spelled out in words
+func admitCaptcha(conn net.Conn, buf *bufio.Reader) error {
+	a, b := randInt(len(numberWords)), randInt(len(numberWords))
+	want := strconv.Itoa(a + b)
+	for i := 0; i < gateTries; i++ {
+		fmt.Fprintf(conn, "This server is checking new connections, what is %s plus %s? Answer in digits\r\n", numberWords[a], numberWords[b])
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == want {
+			return nil
+		}
+	}
+	return errors.New("wrong answers")
+}
+
+// gateCommand runs /gate
+func (s *Server) gateCommand(in *Input) {
+	if len(in.Args) == 1 {
+		mode, bits := s.Gate()
+		if mode == GatePOW {
+			in.Client.Write(fmt.Sprintf("The gate is [%s] with %d bits\r\n", mode, bits))
+		} else {
+			in.Client.Write(fmt.Sprintf("The gate is [%s]\r\n", mode))
+		}
+		return
+	}
+	bits := ""
+	if len(in.Args) >= 3 {
+		bits = in.Args[2]
+	}
+	mode, n, err := parseGate(in.Args[1], bits)
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	s.SetGate(in.Client.Nick(), mode, n)
+	in.Client.Write(fmt.Sprintf("The gate is now [%s]\r\n", mode))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// passGate runs the gate on one end of a pipe and answers it with answer,
+// which is given the last line the gate sent
+func passGate(t *testing.T, serv *Server, answer func(line string) string) bool {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	admitted := make(chan bool, 1)
+	go func() {
+		admitted <- serv.Admit(c2, bufio.NewReader(c2))
+		c2.Close()
+	}()
+
+	r := bufio.NewReader(c1)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return <-admitted
+		}
+		if a := answer(strings.TrimSpace(line)); a != "" {
+			fmt.Fprintf(c1, "%s\r\n", a)
+		}
+	}
+}
+
+func TestGate(t *testing.T) {
+	serv := NewServer()
+	if !serv.Admit(nil, nil) {
+		t.Fatalf("expected everyone to be let in with the gate off")
+	}
+
+	serv.SetGate("batman", GatePOW, 8)
+	solve := func(line string) string {
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != "POW" {
+			return ""
+		}
+		for n := 0; ; n++ {
+			if powValid(f[1], strconv.Itoa(n), 8) {
+				return strconv.Itoa(n)
+			}
+		}
+	}
+	if !passGate(t, serv, solve) {
+		t.Errorf("expected a proof of work to be let in")
+	}
+	wrong := func(line string) string {
+		if strings.HasPrefix(line, "POW ") {
+			return "batarang"
+		}
+		return ""
+	}
+	if passGate(t, serv, wrong) {
+		t.Errorf("expected a wrong proof of work to be kept out")
+	}
+
+	serv.SetGate("batman", GateCaptcha, 0)
+	words := make(map[string]int)
+	for i, w := range numberWords {
+		words[w] = i
+	}
+	tries := 0
+	answer := func(line string) string {
+		var a, b string
+		if _, err := fmt.Sscanf(line, "This server is checking new connections, what is %s plus %s", &a, &b); err != nil {
+			return ""
+		}
+		tries++
+		if tries == 1 {
+			return "lots"
+		}
+		return strconv.Itoa(words[a] + words[strings.TrimSuffix(b, "?")])
+	}
+	if !passGate(t, serv, answer) || tries != 2 {
+		t.Errorf("expected the right answer on the second try to be let in, after %d tries", tries)
+	}
+	if passGate(t, serv, func(line string) string { return "-1" }) {
+		t.Errorf("expected wrong answers to be kept out")
+	}
+
+	if _, _, err := parseGate("moat", ""); err == nil {
+		t.Errorf("expected unknown gates to be refused")
+	}
+	if _, _, err := parseGate(GatePOW, "64"); err == nil {
+		t.Errorf("expected too many bits to be refused")
+	}
+	if log := serv.ModLog(); len(log) != 2 || log[0].Action != "gate captcha" {
+		t.Errorf("expected gate changes to be logged, got %+v", log)
+	}
+}
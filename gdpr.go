@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// raftForget erases an account and everything it sent, on every replica
+const raftForget = "forget"
+
+// accountExport is everything stored about an account
+type accountExport struct {
+	Exported  string      `json:"exported"`
+	Account   Account     `json:"account"`
+	Messages  []Event     `json:"messages"`
+	MemosSent []Memo      `json:"memos_sent"`
+	Reminders []*Reminder `json:"reminders"`
+}
+
+// ExportAccount returns everything stored about the account as JSON, its
+// profile, memos, and stars, the messages it sent that rooms still keep,
+// the memos it left others, and its pending reminders
+func (s *Server) ExportAccount(name string) ([]byte, error) {
+	a, ok := s.Accounts.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("nick [%s] is not registered\r\n", name)
+	}
+	a.Salt, a.Hash = "", ""
+	out := accountExport{
+		Exported:  time.Now().Format(time.RFC3339),
+		Account:   a,
+		Messages:  []Event{},
+		MemosSent: []Memo{},
+		Reminders: s.Reminders.Of(name),
+	}
+
+	s.Accounts.mu.Lock()
+	for _, other := range s.Accounts.Accounts {
+		for _, m := range other.Memos {
+			if m.From == name {
+				out.MemosSent = append(out.MemosSent, m)
+			}
+		}
+	}
+	s.Accounts.mu.Unlock()
+
+	s.mu.Lock()
+	for _, r := range s.Rooms {
+		for _, ev := range r.History {
+			if ev.From == name {
+				out.Messages = append(out.Messages, ev)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// Forget erases the client's account after checking its password, the
+// messages, files, and pastes it sent are purged, replies quoting them lose
+// the quote, and its memos, stars, reactions, and reminders are dropped,
+// the journal and snapshot are rewritten without them, journal segments
+// already rotated for archiving, the journal topic, exported events, and
+// transcripts are out of reach and kept
+func (s *Server) Forget(cl *Client, password string) error {
+	name := cl.Account()
+	if name == "" {
+		return errors.New("you must be logged in to delete your account\r\n")
+	}
+	if err := s.Accounts.Authenticate(name, password); err != nil {
+		return err
+	}
+	if err := s.change(raftCommand{Op: raftForget, Nick: name}); err != nil {
+		return err
+	}
+
+	if s.Journal != nil {
+		closed, err := s.Journal.Rotate()
+		if err == nil {
+			err = os.Remove(closed)
+		}
+		errl(err, "")
+	}
+	if s.SnapshotPath != "" {
+		errl(s.writeSnapshot(s.SnapshotPath), "")
+	}
+	errl(nil, fmt.Sprintf("Account [%s] erased at its request", name))
+
+	cl.Write("Your account and the messages, files, and pastes you sent were deleted from the server, copies the operator archived or exported elsewhere are kept until they expire there, goodbye\r\n")
+	cl.mu.Lock()
+	cl.account = ""
+	conns := append([]*Conn{}, cl.Conns...)
+	cl.mu.Unlock()
+	for _, c := range conns {
+		s.CloseClient(cl, c, "")
+	}
+	return nil
+}
+
+// forget erases what the server keeps of an account
+func (s *Server) forget(name string) {
+	errl(s.Accounts.Forget(name), "")
+	s.Reminders.Forget(name)
+	s.Files.Forget(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.Rooms {
+		r.forget(name)
+	}
+	for id, d := range s.directs {
+		if d.from == name || d.to == name {
+			delete(s.directs, id)
+		}
+	}
+	kept := s.pasteOrder[:0]
+	for _, id := range s.pasteOrder {
+		if s.pastes[id].From == name {
+			delete(s.pastes, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.pasteOrder = kept
+	delete(s.seen, name)
+}
+
+// forget purges what nick sent from the room, it must be called with the
+// server lock held
+func (r *Room) forget(nick string) {
+	purged := make(map[int64]bool)
+	history := r.History[:0]
+	for _, ev := range r.History {
+		if ev.From == nick {
+			purged[ev.ID] = true
+			continue
+		}
+		if purged[ev.Parent] || ev.Parent != 0 && strings.HasPrefix(ev.Quote, nick+": ") {
+			ev.Quote = ""
+		}
+		history = append(history, ev)
+	}
+	r.History = history
+
+	for id, byEmoji := range r.Reactions {
+		if purged[id] {
+			delete(r.Reactions, id)
+			continue
+		}
+		for emoji, nicks := range byEmoji {
+			kept := nicks[:0]
+			for _, n := range nicks {
+				if n != nick {
+					kept = append(kept, n)
+				}
+			}
+			if len(kept) == 0 {
+				delete(byEmoji, emoji)
+			} else {
+				byEmoji[emoji] = kept
+			}
+		}
+	}
+	delete(r.Ops, nick)
+	if r.Owner == nick {
+		r.Owner = ""
+	}
+}
+
+// Forget deletes the account and drops the memos, stars, and watches of
+// other accounts that came from or name it, every replica applies the
+// same, so it isn't proposed again
+func (as *AccountStore) Forget(name string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if a, ok := as.find(name); ok {
+		name = a.Name
+	}
+	delete(as.Accounts, name)
+	for _, a := range as.Accounts {
+		memos := a.Memos[:0]
+		for _, m := range a.Memos {
+			if m.From != name {
+				memos = append(memos, m)
+			}
+		}
+		a.Memos = memos
+		stars := a.Stars[:0]
+		for _, ev := range a.Stars {
+			if ev.From != name {
+				stars = append(stars, ev)
+			}
+		}
+		a.Stars = stars
+		watch := a.Watch[:0]
+		for _, w := range a.Watch {
+			if w != name {
+				watch = append(watch, w)
+			}
+		}
+		a.Watch = watch
+	}
+	return as.save()
+}
+
+// Of returns copies of the pending reminders of the account
+func (rs *Reminders) Of(account string) []*Reminder {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	out := []*Reminder{}
+	for _, r := range rs.list {
+		if r.Account == account {
+			r := *r
+			out = append(out, &r)
+		}
+	}
+	return out
+}
+
+// Forget drops the pending reminders of the account
+func (rs *Reminders) Forget(account string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	list := rs.list[:0]
+	for _, r := range rs.list {
+		if r.Account != account {
+			list = append(list, r)
+		}
+	}
+	rs.list = list
+	errl(rs.save(), "")
+}
+
+// mydataCommand runs /mydata
+func (s *Server) mydataCommand(in *Input) {
+	b, err := s.ExportAccount(in.Client.Account())
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	in.Client.Write(strings.Replace(string(b), "\n", "\r\n", -1) + "\r\n")
+}
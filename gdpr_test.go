@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportAccount(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("joker", "haha")
+	serv.Accounts.Register("batman", "alfred")
+	joker, batman := &Client{nick: "joker", account: "joker"}, &Client{nick: "batman", account: "batman"}
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("gotham", batman)
+	serv.Message([]string{"why", "so", "serious"}, joker)
+	serv.Message([]string{"not", "me"}, batman)
+	serv.Accounts.Update("batman", func(a *Account) { a.Memos = append(a.Memos, Memo{From: "joker", Text: "see you soon"}) })
+
+	b, err := serv.ExportAccount("joker")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	var out accountExport
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("expected JSON, got %v", err)
+	}
+	if out.Account.Name != "joker" || out.Account.Hash != "" || out.Account.Salt != "" {
+		t.Errorf("expected the profile without the password hash, got %+v", out.Account)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Text != "why so serious" {
+		t.Errorf("expected only the messages joker sent, got %+v", out.Messages)
+	}
+	if len(out.MemosSent) != 1 || out.MemosSent[0].Text != "see you soon" {
+		t.Errorf("expected the memos joker left, got %+v", out.MemosSent)
+	}
+
+	serv.Dispatch(&Input{Client: joker, Command: "/mydata", Args: []string{"/mydata"}})
+	if last := joker.unread[len(joker.unread)-1].Text; !strings.Contains(last, "\"why so serious\"") {
+		t.Errorf("expected /mydata to send the export, got [%s]", last)
+	}
+}
+
+func TestForget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.log")
+
+	serv := NewServer()
+	if serv.Journal, err = OpenJournal(serv, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	serv.Accounts.Register("joker", "haha")
+	serv.Accounts.Register("batman", "alfred")
+	joker, batman := &Client{nick: "joker", account: "joker"}, &Client{nick: "batman", account: "batman"}
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("gotham", batman)
+	serv.Message([]string{"the", "vault", "code", "is", "1939"}, joker)
+	serv.Message([]string{strings.Repeat("haha ", 300)}, joker)
+	if len(serv.pastes) != 1 {
+		t.Fatalf("expected a paste")
+	}
+	if serv.Files, err = OpenFiles(filepath.Join(dir, "files"), "http://chat.example", 64, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	upload, err := serv.Files.Store(pendingUpload{From: "joker", Name: "plans.txt"}, []byte("1939"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := batman.unread[len(batman.unread)-1].ID
+	serv.message(batman, "nice try", id)
+	serv.Star(batman, strconv.FormatInt(id, 10))
+	serv.Accounts.Update("batman", func(a *Account) {
+		a.Memos = append(a.Memos, Memo{From: "joker", Text: "see you soon"})
+		a.Watch = append(a.Watch, "joker")
+	})
+
+	if err := serv.Forget(joker, "wrong"); err == nil {
+		t.Errorf("expected the password to be checked")
+	}
+	if err := serv.Forget(joker, "haha"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	if serv.Accounts.Exists("joker") || joker.Account() != "" {
+		t.Errorf("expected the account to be gone")
+	}
+	history := serv.Rooms["gotham"].History
+	for _, ev := range history {
+		if ev.From == "joker" || strings.Contains(ev.Quote, "1939") {
+			t.Errorf("expected joker's messages purged, got %+v", ev)
+		}
+	}
+	if a, _ := serv.Accounts.Get("batman"); len(a.Memos)+len(a.Stars)+len(a.Watch) != 0 {
+		t.Errorf("expected the memos, stars, and watches of joker dropped, got %+v", a)
+	}
+
+	if len(serv.pastes) != 0 {
+		t.Errorf("expected joker's pastes dropped")
+	}
+	if _, _, ok := serv.Files.Open(upload.ID); ok {
+		t.Errorf("expected joker's files removed")
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "1939") {
+		t.Errorf("expected the journal rewritten without joker's messages")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(files) != 1 {
+		t.Errorf("expected the old journal removed, got %v", files)
+	}
+
+	replica := NewServer()
+	if _, err := OpenJournal(replica, path, nil); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if replica.Accounts.Exists("joker") {
+		t.Errorf("expected the replayed journal to stay erased")
+	}
+}
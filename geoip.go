@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+)
+
+// mmdbMarker starts the metadata at the end of a MaxMind database
+var mmdbMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// GeoIP looks up the country of an address in a MaxMind database, such as
+// GeoLite2-Country.mmdb, it reads the whole file into memory
+type GeoIP struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+}
+
+// OpenGeoIP loads the MaxMind database at path
+func OpenGeoIP(path string) (*GeoIP, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewGeoIP(b)
+}
+
+// NewGeoIP reads a MaxMind database from b
+func NewGeoIP(b []byte) (*GeoIP, error) {
+	i := bytes.LastIndex(b, mmdbMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind database")
+	}
+	meta := b[i+len(mmdbMarker):]
+	v, _, err := mmdbDecode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("reading metadata: not a map")
+	}
+	num := func(key string) uint {
+		n, _ := m[key].(uint64)
+		return uint(n)
+	}
+
+	g := &GeoIP{buf: b, nodeCount: num("node_count"), recordSize: num("record_size"), ipVersion: num("ip_version")}
+	if g.recordSize != 24 && g.recordSize != 28 && g.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", g.recordSize)
+	}
+	if g.ipVersion != 4 && g.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", g.ipVersion)
+	}
+	tree := g.nodeCount * g.recordSize / 4
+	if tree+16 > uint(i) {
+		return nil, errors.New("search tree is larger than the database")
+	}
+	g.data = b[tree+16 : i]
+	return g, nil
+}
+
+// record returns the left or right record of a node of the search tree
+func (g *GeoIP) record(node uint, right bool) uint {
+	off := node * g.recordSize / 4
+	b := g.buf[off:]
+	switch g.recordSize {
+	case 24:
+		if right {
+			b = b[3:]
+		}
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if right {
+			return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+		}
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	}
+	if right {
+		b = b[4:]
+	}
+	return uint(binary.BigEndian.Uint32(b))
+}
+
+// Lookup returns the record for ip, or nil if the database has none
+func (g *GeoIP) Lookup(ip net.IP) (map[string]interface{}, error) {
+	key := ip.To4()
+	if key == nil || g.ipVersion == 6 {
+		// IPv4 addresses live under ::/96 in an IPv6 database
+		key = ip.To16()
+		if v4 := ip.To4(); v4 != nil {
+			key = append(make(net.IP, 12), v4...)
+		}
+	}
+	if key == nil || (g.ipVersion == 4 && len(key) != net.IPv4len) {
+		return nil, nil
+	}
+
+	node := uint(0)
+	for i := 0; i < len(key)*8 && node < g.nodeCount; i++ {
+		node = g.record(node, key[i/8]&(0x80>>uint(i%8)) != 0)
+	}
+	if node <= g.nodeCount {
+		return nil, nil
+	}
+
+	v, _, err := mmdbDecode(g.data, node-g.nodeCount-16)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+// Country returns the ISO code of the country ip is in, or "" if unknown
+func (g *GeoIP) Country(ip net.IP) string {
+	m, err := g.Lookup(ip)
+	if err != nil {
+		errl(err, "")
+		return ""
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := m[key].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// mmdbDecode decodes the value at off in a MaxMind data section, it returns
+// the value and the offset after it, integers are returned as uint64 or
+// int64 and floats as float64
+func mmdbDecode(d []byte, off uint) (interface{}, uint, error) {
+	errShort := errors.New("data section is truncated")
+	next := func(n uint) ([]byte, error) {
+		if off+n > uint(len(d)) {
+			return nil, errShort
+		}
+		b := d[off : off+n]
+		off += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	typ := uint(ctrl >> 5)
+
+	if typ == 1 {
+		// a pointer to a value elsewhere in the data section
+		ss, vvv := uint(ctrl>>3)&3, uint(ctrl&7)
+		b, err := next(ss + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p uint
+		for _, c := range b {
+			p = p<<8 | uint(c)
+		}
+		switch ss {
+		case 0:
+			p |= vvv << 8
+		case 1:
+			p = (p | vvv<<16) + 2048
+		case 2:
+			p = (p | vvv<<24) + 526336
+		}
+		v, _, err := mmdbDecode(d, p)
+		return v, off, err
+	}
+
+	if typ == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := next(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+
+	switch typ {
+	case 2:
+		b, err := next(size)
+		return string(b), off, err
+	case 3:
+		b, err := next(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 4:
+		b, err := next(size)
+		return append([]byte{}, b...), off, err
+	case 5, 6, 9, 10:
+		b, err := next(size)
+		if err != nil {
+			return nil, 0, err
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 8:
+		b, err := next(size)
+		if err != nil {
+			return nil, 0, err
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), off, nil
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, o, err := mmdbDecode(d, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, o, err := mmdbDecode(d, o)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], off = v, o
+		}
+		return m, off, nil
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, o, err := mmdbDecode(d, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), o
+		}
+		return a, off, nil
+	case 14:
+		return size != 0, off, nil
+	case 15:
+		b, err := next(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// parseCountries parses a comma separated list of ISO country codes
+func parseCountries(s string) map[string]bool {
+	out := make(map[string]bool)
+	for _, c := range splitList(s) {
+		out[strings.ToUpper(c)] = true
+	}
+	return out
+}
+
+// Country returns the country of ip, or "" without a GeoIP database or
+// when the database doesn't know the address
+func (s *Server) Country(ip net.IP) string {
+	if s.GeoIP == nil || ip == nil {
+		return ""
+	}
+	return s.GeoIP.Country(ip)
+}
+
+// AllowedCountry returns true if connections from ip's country are
+// accepted, a country in TCGeoDeny is refused, and when TCGeoAllow is set
+// only its countries are accepted, addresses of no known country, such as
+// private ones, are always accepted
+func (s *Server) AllowedCountry(ip net.IP) bool {
+	s.cfg.RLock()
+	allow, deny := s.AllowCountries, s.DenyCountries
+	s.cfg.RUnlock()
+	if len(allow) == 0 && len(deny) == 0 {
+		return true
+	}
+	c := s.Country(ip)
+	if c == "" {
+		return true
+	}
+	return !deny[c] && (len(allow) == 0 || allow[c])
+}
+
+// whoisAddrs lists where the connections of a local client come from,
+// admins see the addresses and countries, everyone else their cloaked
+// hostmasks
+func (s *Server) whoisAddrs(nick string, admin bool) string {
+	s.mu.Lock()
+	c, ok := s.Clients[nick]
+	s.mu.Unlock()
+	if !ok {
+		return ""
+	}
+
+	c.mu.Lock()
+	conns := append([]*Conn{}, c.Conns...)
+	c.mu.Unlock()
+
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, cn := range conns {
+		if cn.Conn == nil {
+			continue
+		}
+		ip := addrIP(cn.RemoteAddr())
+		addr := cloak(ip)
+		if admin {
+			addr = cn.RemoteAddr().String()
+			if country := s.Country(ip); country != "" {
+				addr += " (" + country + ")"
+			}
+			if listed := cn.Listed(); len(listed) > 0 {
+				addr += " listed on " + strings.Join(listed, ", ")
+			}
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[%s] is connected from %s\r\n", nick, strings.Join(addrs, ", "))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// mmdbString encodes a string for a test database
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// mmdbUint encodes an unsigned int of 2 or 4 bytes for a test database
+func mmdbUint(n uint32, size int) []byte {
+	typ := byte(5)
+	if size == 4 {
+		typ = 6
+	}
+	b := []byte{typ<<5 | byte(size)}
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(n>>(8*uint(i))))
+	}
+	return b
+}
+
+// mmdbMap encodes a map of already encoded values for a test database
+func mmdbMap(kv ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(kv)/2)}
+	for _, v := range kv {
+		b = append(b, v...)
+	}
+	return b
+}
+
+// buildMMDB builds an IPv6 database with 24 bit records mapping each
+// network to the country it's listed with
+func buildMMDB(t *testing.T, countries map[string]string) []byte {
+	// nodes hold their two records, -1 until set, -2-i for data i
+	nodes := [][2]int{{-1, -1}}
+	var data [][]byte
+	for cidr, country := range countries {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := n.Mask.Size()
+		ip := n.IP.To16()
+		if v4 := n.IP.To4(); v4 != nil {
+			ip, ones = append(make(net.IP, 12), v4...), ones+96
+		}
+		data = append(data, mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString(country))))
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := 0
+			if ip[i/8]&(0x80>>uint(i%8)) != 0 {
+				bit = 1
+			}
+			if i == ones-1 {
+				nodes[node][bit] = -2 - (len(data) - 1)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var offsets []int
+	var section []byte
+	for _, d := range data {
+		offsets = append(offsets, len(section))
+		section = append(section, d...)
+	}
+
+	var out []byte
+	for _, n := range nodes {
+		for _, r := range n {
+			v := r
+			switch {
+			case r == -1:
+				v = len(nodes)
+			case r <= -2:
+				v = len(nodes) + 16 + offsets[-2-r]
+			}
+			out = append(out, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, section...)
+	out = append(out, mmdbMarker...)
+	out = append(out, mmdbMap(
+		mmdbString("node_count"), mmdbUint(uint32(len(nodes)), 4),
+		mmdbString("record_size"), mmdbUint(24, 2),
+		mmdbString("ip_version"), mmdbUint(6, 2),
+	)...)
+	return out
+}
+
+func TestGeoIP(t *testing.T) {
+	g, err := NewGeoIP(buildMMDB(t, map[string]string{
+		"203.0.113.0/24":  "US",
+		"198.51.100.0/24": "FR",
+		"2001:db8::/32":   "DE",
+	}))
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	cases := map[string]string{
+		"203.0.113.9":  "US",
+		"198.51.100.1": "FR",
+		"2001:db8::1":  "DE",
+		"192.0.2.1":    "",
+		"10.0.0.1":     "",
+		"2001:db9::1":  "",
+	}
+	for addr, want := range cases {
+		if got := g.Country(net.ParseIP(addr)); got != want {
+			t.Errorf("expected %s to be in %q, got %q", addr, want, got)
+		}
+	}
+
+	if _, err := NewGeoIP([]byte("gotham")); err == nil {
+		t.Errorf("expected a file without metadata to be refused")
+	}
+
+	serv := NewServer()
+	serv.GeoIP = g
+	serv.DenyCountries = parseCountries("fr")
+	if serv.AllowedCountry(net.ParseIP("198.51.100.1")) || !serv.AllowedCountry(net.ParseIP("203.0.113.9")) {
+		t.Errorf("expected only FR to be refused")
+	}
+	serv.AllowCountries = parseCountries("us")
+	if serv.AllowedCountry(net.ParseIP("2001:db8::1")) || !serv.AllowedCountry(net.ParseIP("203.0.113.9")) {
+		t.Errorf("expected only US to be accepted")
+	}
+	if !serv.AllowedCountry(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expected addresses of no known country to be accepted")
+	}
+}
+
+func TestMMDBPointer(t *testing.T) {
+	// a map whose value points back at the string before it
+	d := mmdbString("gotham")
+	d = append(d, mmdbMap(mmdbString("city"), []byte{1 << 5, 0})...)
+	v, _, err := mmdbDecode(d, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(map[string]interface{}); !ok || m["city"] != "gotham" {
+		t.Errorf("expected the pointer to be followed, got %v", v)
+	}
+	if _, _, err := mmdbDecode(mmdbString("gotham")[:3], 0); err == nil || !bytes.Contains([]byte(err.Error()), []byte("truncated")) {
+		t.Errorf("expected truncated data to be refused, got %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/jaredfolkins/telnacl
 
+go 1.27.1
+
 require (
 	github.com/reiver/go-oi v0.0.0-20160325061615-431c83978379
 	github.com/reiver/go-telnet v0.0.0-20180421082511-9ff0b2ab096e
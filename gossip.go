@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// gossipInterval is how often a node gossips with gossipFanout random
+// peers, a node not heard from for gossipDead is considered gone along
+// with its nicks, and tombstones of nicks are kept for gossipTombstone
+const (
+	gossipInterval  = time.Second
+	gossipFanout    = 3
+	gossipDead      = 30 * time.Second
+	gossipTombstone = time.Minute
+)
+
+// gossipChunk is the number of entries sent in a single datagram
+const gossipChunk = 200
+
+// presence statuses
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// gossipEntry is the presence of a nick, the node it is connected to owns
+// the entry and every change gets a higher version
+type gossipEntry struct {
+	Node    string `json:"node"`
+	Status  string `json:"status,omitempty"`
+	Away    string `json:"away,omitempty"`
+	Version int64  `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// gossipPacket is a datagram exchanged between nodes
+type gossipPacket struct {
+	Node    string                 `json:"node"`
+	Addr    string                 `json:"addr"`
+	Members map[string]string      `json:"members,omitempty"`
+	Entries map[string]gossipEntry `json:"entries,omitempty"`
+}
+
+type gossipMember struct {
+	addr string
+	seen time.Time
+}
+
+// Gossip synchronizes presence and nick ownership between nodes by
+// periodically pushing the known state to a few random peers over UDP
+type Gossip struct {
+	Server *Server
+	Node   string
+	Addr   string
+	Seeds  []string
+
+	conn net.PacketConn
+	key  *clusterKey
+
+	mu      sync.Mutex
+	members map[string]*gossipMember
+	entries map[string]gossipEntry
+}
+
+// NewGossip returns the gossip member for node, advertised at addr and
+// joining the network through the seed addresses, datagrams are sealed
+// with key and any that aren't are dropped
+func NewGossip(s *Server, node, addr string, seeds []string, key *clusterKey) *Gossip {
+	return &Gossip{
+		Server:  s,
+		Node:    node,
+		Addr:    addr,
+		Seeds:   seeds,
+		key:     key,
+		members: make(map[string]*gossipMember),
+		entries: make(map[string]gossipEntry),
+	}
+}
+
+// Start listens on the advertised address and begins gossiping
+func (g *Gossip) Start() error {
+	conn, err := net.ListenPacket("udp", g.Addr)
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+
+	go g.receive()
+	go func() {
+		for {
+			time.Sleep(gossipInterval)
+			g.round()
+		}
+	}()
+	return nil
+}
+
+// Observe records the presence changes of local clients from an event
+func (g *Gossip) Observe(ev Event) {
+	if g == nil {
+		return
+	}
+
+	switch ev.Type {
+	case EventJoin:
+		g.set(ev.From, gossipEntry{Status: StatusOnline})
+	case EventNick:
+		g.mu.Lock()
+		e, ok := g.entries[ev.From]
+		g.mu.Unlock()
+		if !ok || e.Deleted {
+			e = gossipEntry{Status: StatusOnline}
+		}
+		g.set(ev.From, gossipEntry{Deleted: true})
+		g.set(ev.To, e)
+	case EventLeave:
+		g.set(ev.From, gossipEntry{Deleted: true})
+	case EventPresence:
+		g.set(ev.From, gossipEntry{Status: ev.Status, Away: ev.Text})
+	}
+}
+
+// SetAway records a local nick as away with the message, or back online
+// if the message is empty
+func (g *Gossip) SetAway(nick, message string) {
+	if g == nil {
+		return
+	}
+	if message == "" {
+		g.set(nick, gossipEntry{Status: StatusOnline})
+		return
+	}
+	g.set(nick, gossipEntry{Status: StatusAway, Away: message})
+}
+
+// set updates the entry of a local nick
+func (g *Gossip) set(nick string, e gossipEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e.Node = g.Node
+	e.Version = time.Now().UnixNano()
+	if old, ok := g.entries[nick]; ok && old.Version >= e.Version {
+		e.Version = old.Version + 1
+	}
+	g.entries[nick] = e
+}
+
+// Lookup returns the presence of a nick connected to another live node
+func (g *Gossip) Lookup(nick string) (gossipEntry, bool) {
+	if g == nil {
+		return gossipEntry{}, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e, ok := g.entries[nick]
+	if !ok || e.Deleted || e.Node == g.Node {
+		return gossipEntry{}, false
+	}
+	m, ok := g.members[e.Node]
+	if !ok || time.Since(m.seen) > gossipDead {
+		return gossipEntry{}, false
+	}
+	return e, true
+}
+
+// Has returns true if the nick is connected to another live node
+func (g *Gossip) Has(nick string) bool {
+	_, ok := g.Lookup(nick)
+	return ok
+}
+
+// round drops dead members and old tombstones then pushes the state to a
+// few random peers
+func (g *Gossip) round() {
+	now := time.Now()
+
+	g.mu.Lock()
+	for name, m := range g.members {
+		if now.Sub(m.seen) > 2*gossipDead {
+			delete(g.members, name)
+		}
+	}
+	for nick, e := range g.entries {
+		_, alive := g.members[e.Node]
+		expired := e.Deleted && now.Sub(time.Unix(0, e.Version)) > gossipTombstone
+		if expired || (e.Node != g.Node && !alive) {
+			delete(g.entries, nick)
+		}
+	}
+
+	var targets []string
+	for _, m := range g.members {
+		if now.Sub(m.seen) <= gossipDead {
+			targets = append(targets, m.addr)
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > gossipFanout {
+		targets = targets[:gossipFanout]
+	}
+	if len(targets) == 0 {
+		targets = g.Seeds
+	}
+
+	packets := g.packets()
+	g.mu.Unlock()
+
+	for _, t := range targets {
+		addr, err := net.ResolveUDPAddr("udp", t)
+		if err != nil {
+			errl(err, "")
+			continue
+		}
+		for _, b := range packets {
+			g.conn.WriteTo(b, addr)
+		}
+	}
+}
+
+// packets encodes the known state in datagrams of at most gossipChunk
+// entries, it must be called with the lock held
+func (g *Gossip) packets() [][]byte {
+	members := map[string]string{g.Node: g.Addr}
+	for name, m := range g.members {
+		members[name] = m.addr
+	}
+
+	var out [][]byte
+	p := gossipPacket{Node: g.Node, Addr: g.Addr, Members: members, Entries: make(map[string]gossipEntry)}
+	flush := func() {
+		b, err := json.Marshal(p)
+		if err == nil {
+			b, err = g.key.seal(b, "gossip")
+		}
+		if err != nil {
+			errl(err, "")
+			return
+		}
+		out = append(out, b)
+		p = gossipPacket{Node: g.Node, Addr: g.Addr, Entries: make(map[string]gossipEntry)}
+	}
+
+	for nick, e := range g.entries {
+		p.Entries[nick] = e
+		if len(p.Entries) == gossipChunk {
+			flush()
+		}
+	}
+	if len(p.Entries) > 0 || len(out) == 0 {
+		flush()
+	}
+	return out
+}
+
+// receive merges the datagrams of other nodes
+func (g *Gossip) receive() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := g.conn.ReadFrom(buf)
+		if err != nil {
+			errl(fmt.Errorf("gossip stopped: %v", err), "")
+			return
+		}
+
+		b, err := g.key.open(buf[:n], "gossip")
+		if err != nil {
+			errl(fmt.Errorf("gossip from %s dropped: %v", from, err), "")
+			continue
+		}
+		var p gossipPacket
+		if err := json.Unmarshal(b, &p); err != nil {
+			errl(err, "")
+			continue
+		}
+		g.merge(p, time.Now())
+	}
+}
+
+// merge adopts the newer entries of a packet, the entries of local nicks
+// are only changed locally
+func (g *Gossip) merge(p gossipPacket, now time.Time) {
+	if p.Node == "" || p.Node == g.Node {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.members[p.Node] = &gossipMember{addr: p.Addr, seen: now}
+	for name, addr := range p.Members {
+		if _, ok := g.members[name]; !ok && name != g.Node {
+			g.members[name] = &gossipMember{addr: addr, seen: now}
+		}
+	}
+
+	for nick, e := range p.Entries {
+		if e.Node == g.Node {
+			continue
+		}
+		if old, ok := g.entries[nick]; ok && (old.Node == g.Node && !old.Deleted || old.Version >= e.Version) {
+			continue
+		}
+		g.entries[nick] = e
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGossipMerge(t *testing.T) {
+	key, _ := newClusterKey("s3cret")
+	g := NewGossip(NewServer(), "node-1", "127.0.0.1:0", nil, key)
+	g.Observe(Event{Type: EventJoin, From: "batman"})
+
+	now := time.Now()
+	g.merge(gossipPacket{
+		Node:    "node-2",
+		Addr:    "10.0.0.2:8095",
+		Members: map[string]string{"node-3": "10.0.0.3:8095"},
+		Entries: map[string]gossipEntry{
+			"robin":  {Node: "node-2", Status: StatusAway, Away: "patrolling", Version: 2},
+			"batman": {Node: "node-2", Status: StatusOnline, Version: now.UnixNano() + 1000},
+		},
+	}, now)
+
+	e, ok := g.Lookup("robin")
+	if !ok || e.Node != "node-2" || e.Away != "patrolling" {
+		t.Errorf("expected remote entry to be merged, got %+v", e)
+	}
+	if g.Has("batman") {
+		t.Errorf("expected local nick to win over a remote entry")
+	}
+	if _, ok := g.members["node-3"]; !ok {
+		t.Errorf("expected members to be learned from peers")
+	}
+
+	g.merge(gossipPacket{Node: "node-2", Entries: map[string]gossipEntry{
+		"robin": {Node: "node-2", Version: 1, Deleted: true},
+	}}, now)
+	if !g.Has("robin") {
+		t.Errorf("expected older entries to be ignored")
+	}
+
+	g.merge(gossipPacket{Node: "node-2", Entries: map[string]gossipEntry{
+		"robin": {Node: "node-2", Version: 3, Deleted: true},
+	}}, now)
+	if g.Has("robin") {
+		t.Errorf("expected newer tombstone to remove the nick")
+	}
+}
+
+func TestGossipSync(t *testing.T) {
+	s1, s2 := NewServer(), NewServer()
+	key, _ := newClusterKey("s3cret")
+	s1.Gossip = NewGossip(s1, "node-1", "127.0.0.1:0", nil, key)
+	if err := s1.Gossip.Start(); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer s1.Gossip.conn.Close()
+	s1.Gossip.Addr = s1.Gossip.conn.LocalAddr().String()
+
+	s2.Gossip = NewGossip(s2, "node-2", "127.0.0.1:0", []string{s1.Gossip.Addr}, key)
+	if err := s2.Gossip.Start(); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer s2.Gossip.conn.Close()
+	s2.Gossip.Addr = s2.Gossip.conn.LocalAddr().String()
+
+	batman := &Client{nick: "batman"}
+	s1.JoinRoom("gotham", batman)
+	s1.SetAway(batman, "patrolling")
+
+	waitFor(t, "gossip", func() bool { return s2.Gossip.Has("batman") })
+
+	out, err := s2.Whois("batman")
+	if err != nil || !strings.Contains(out, "away (patrolling) on node [node-1]") {
+		t.Errorf("unexpected whois [%s]", out)
+	}
+
+	robin := &Client{nick: "robin"}
+	s2.JoinRoom(DefaultRoom, robin)
+	if err := s2.ChangeNick("robin", "batman"); err == nil {
+		t.Errorf("expected gossiped nick to be taken")
+	}
+
+	// datagrams that aren't sealed with the secret are dropped
+	spoof := func(b []byte) {
+		conn, err := net.Dial("udp", s2.Gossip.Addr)
+		if err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		defer conn.Close()
+		conn.Write(b)
+	}
+	p, _ := json.Marshal(gossipPacket{Node: "node-3", Addr: "10.0.0.3:8095", Entries: map[string]gossipEntry{
+		"joker": {Node: "node-3", Status: StatusOnline, Version: time.Now().UnixNano()},
+	}})
+	other, _ := newClusterKey("guess")
+	sealed, _ := other.seal(p, "gossip")
+	spoof(p)
+	spoof(sealed)
+	time.Sleep(100 * time.Millisecond)
+	if s2.Gossip.Has("joker") {
+		t.Errorf("expected unsealed gossip dropped")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const handoffKey = "tinychat:handoff:"
+
+// handoff is a session handed to another node while this one is drained
+type handoff struct {
+	Nick    string `json:"nick"`
+	Account string `json:"account,omitempty"`
+	Room    string `json:"room"`
+	Away    string `json:"away,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
+// target returns a live peer node and its chat address for the nick,
+// spreading the sessions of a drained node over the rest of the cluster
+func (c *Cluster) target(nick string) (string, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var peers []string
+	for _, n := range c.nodes {
+		if n != c.Node && c.addrs[n] != "" {
+			peers = append(peers, n)
+		}
+	}
+	node := newHashRing(peers).Owner(nick)
+	if node == "" {
+		return "", "", false
+	}
+	return node, c.addrs[node], true
+}
+
+// putHandoff stores the session under its token for ttl
+func (c *Cluster) putHandoff(token string, h handoff, ttl time.Duration) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return c.do(func(rc *redisConn) error {
+		_, err := rc.Do("SET", handoffKey+token, string(b), "EX", strconv.Itoa(int(ttl/time.Second)+1))
+		return err
+	})
+}
+
+// TakeHandoff returns and removes the session handed off under token
+func (c *Cluster) TakeHandoff(token string) (handoff, bool, error) {
+	var h handoff
+	if c == nil {
+		return h, false, nil
+	}
+
+	var v interface{}
+	err := c.do(func(rc *redisConn) error {
+		var err error
+		if v, err = rc.Do("GET", handoffKey+token); err != nil || v == nil {
+			return err
+		}
+		_, err = rc.Do("DEL", handoffKey+token)
+		return err
+	})
+	if err != nil || v == nil {
+		return h, false, err
+	}
+
+	s, _ := v.(string)
+	if err := json.Unmarshal([]byte(s), &h); err != nil {
+		return h, false, err
+	}
+	return h, true, nil
+}
+
+// Draining returns true once the server is handing its sessions off
+func (s *Server) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// Drain hands every resumable session to a peer node and tells its client
+// where to reconnect, then disconnects it, new connections are refused
+// from then on, only admins may drain a node
+func (s *Server) Drain(cl *Client) (int, error) {
+	if !s.isAdmin(cl) {
+		return 0, errors.New("only admins can drain the server\r\n")
+	}
+	if s.Cluster == nil {
+		return 0, errors.New("draining needs a cluster to hand sessions to\r\n")
+	}
+
+	type session struct {
+		cl    *Client
+		token string
+		h     handoff
+	}
+
+	s.mu.Lock()
+	s.draining = true
+	var sessions []session
+	for token, c := range s.Sessions {
+		h := handoff{Nick: c.Nick(), Account: c.Account(), Away: c.Away(), Status: c.StatusText()}
+		if r, err := s.findRoom(c); err == nil {
+			h.Room = r.Name
+		}
+		sessions = append(sessions, session{c, token, h})
+		delete(s.Sessions, token)
+	}
+	s.mu.Unlock()
+
+	handed := 0
+	for _, se := range sessions {
+		node, addr, ok := s.Cluster.target(se.h.Nick)
+		if !ok {
+			se.cl.Write("This server is going down for maintenance and no other node is available\r\n")
+		} else if err := s.Cluster.putHandoff(se.token, se.h, s.ResumeWindow); err != nil {
+			errl(err, "")
+			se.cl.Write("This server is going down for maintenance, your session could not be handed off\r\n")
+		} else {
+			se.cl.Send(Event{
+				Type:  EventReconnect,
+				To:    addr,
+				Token: se.token,
+				Text:  fmt.Sprintf("This server is going down for maintenance, reconnect to [%s] and /resume %s\r\n", addr, se.token),
+			})
+			errl(nil, fmt.Sprintf("Session of [%s] handed off to node [%s]", se.h.Nick, node))
+			handed++
+		}
+
+		se.cl.mu.Lock()
+		conns := append([]*Conn{}, se.cl.Conns...)
+		se.cl.mu.Unlock()
+		for _, c := range conns {
+			c.sendClose("")
+		}
+	}
+	return handed, nil
+}
+
+// resumeHandoff restores a session handed off by a drained node onto cl
+func (s *Server) resumeHandoff(token string, h handoff, cl *Client) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl.mu.Lock()
+	cl.account = h.Account
+	cl.away = h.Away
+	cl.status = h.Status
+	cl.mu.Unlock()
+
+	if cl.Nick() != h.Nick {
+		if err := s.changeNick(cl.Nick(), h.Nick); err != nil {
+			cl.mu.Lock()
+			cl.account, cl.away, cl.status = "", "", ""
+			cl.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	if h.Room != "" {
+		if r, ok := s.Rooms[h.Room]; !ok || !r.banned(cl) {
+			s.tryDeleteFromRoom(cl)
+			s.joinRoom(h.Room, cl)
+			s.emit(Event{
+				Type: EventJoin,
+				Time: time.Now().Format(time.RFC3339),
+				From: cl.Nick(),
+				Room: h.Room,
+			})
+		}
+	}
+
+	delete(s.Sessions, cl.Token())
+	cl.setToken(token)
+	s.Sessions[token] = cl
+
+	cl.Write(fmt.Sprintf("Session handed off as [%s] in room [%s]\r\n", h.Nick, h.Room))
+	return cl, nil
+}
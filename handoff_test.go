@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis answers the string commands used for handoffs
+func fakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				rc := &redisConn{conn: conn, buf: bufio.NewReader(conn)}
+				for {
+					v, err := rc.Receive()
+					if err != nil {
+						return
+					}
+					args := redisStrings(v)
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "SET":
+						data[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					case "GET":
+						if s, ok := data[args[1]]; ok {
+							rc.conn.Write([]byte("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"))
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "DEL":
+						delete(data, args[1])
+						conn.Write([]byte(":1\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDrainHandoff(t *testing.T) {
+	addr := fakeRedis(t)
+
+	drained := NewServer()
+	drained.Admins = map[string]bool{"alfred": true}
+	drained.Cluster = NewCluster(drained, "node-1", addr, "")
+	drained.Cluster.nodes = []string{"node-1", "node-2"}
+	drained.Cluster.addrs = map[string]string{"node-2": "chat2.example.com:8091"}
+
+	c1, p1 := net.Pipe()
+	batman := &Client{nick: "batman", Conns: []*Conn{NewConn(c1)}}
+	batman.Conns[0].SetJSON(true)
+	drained.JoinRoom("gotham", batman)
+	drained.SetAway(batman, "patrolling")
+	token, _ := drained.NewSession(batman)
+
+	if _, err := drained.Drain(batman); err == nil {
+		t.Errorf("expected only admins to drain")
+	}
+
+	alfred := &Client{nick: "alfred", account: "alfred"}
+	done := make(chan int)
+	go func() {
+		n, err := drained.Drain(alfred)
+		if err != nil {
+			t.Errorf("expected error to be nil, got %v", err)
+		}
+		done <- n
+	}()
+
+	line, _ := bufio.NewReader(p1).ReadString('\n')
+	var ev Event
+	json.Unmarshal([]byte(line), &ev)
+	if ev.Type != EventReconnect || ev.To != "chat2.example.com:8091" || ev.Token != token {
+		t.Errorf("unexpected reconnect event %+v", ev)
+	}
+	if n := <-done; n != 1 {
+		t.Errorf("expected 1 session to be handed off, got %d", n)
+	}
+	if !drained.Draining() {
+		t.Errorf("expected the server to be draining")
+	}
+
+	peer := NewServer()
+	peer.Cluster = NewCluster(peer, "node-2", addr, "")
+	fresh := &Client{nick: "user1"}
+	peer.JoinRoom(DefaultRoom, fresh)
+
+	restored, err := peer.Resume(token, fresh)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if restored.Nick() != "batman" || restored.Away() != "patrolling" {
+		t.Errorf("unexpected restored client %s", restored.Nick())
+	}
+	if r, _ := peer.findRoom(restored); r == nil || r.Name != "gotham" {
+		t.Errorf("expected restored client to be in its room")
+	}
+
+	if peer.Sessions[token] != restored {
+		t.Errorf("expected the token to resume the session on its new node")
+	}
+	if _, ok, _ := peer.Cluster.TakeHandoff(token); ok {
+		t.Errorf("expected a handoff to be used once")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxHookBody is the largest incoming webhook payload accepted
+const maxHookBody = 64 << 10
+
+// HookPayload is the body posted to the incoming webhook endpoint
+type HookPayload struct {
+	Room string `json:"room"`
+	Text string `json:"text"`
+}
+
+// HTTPHandler returns the handler served on the HTTP listener
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hooks/incoming", s.handleIncoming)
+	mux.HandleFunc("/rooms/", s.handleRooms)
+	mux.HandleFunc("/search", s.handleSearch)
+	mux.HandleFunc("/files/", s.handleFiles)
+	mux.HandleFunc("/images", s.handleImages)
+	mux.HandleFunc("/i/", s.handleImages)
+	mux.HandleFunc("/paste/", s.handlePaste)
+	return mux
+}
+
+// handleRooms serves the per room HTTP resources
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/rooms/")
+	if rest == "" {
+		s.serveRooms(w, r)
+		return
+	}
+
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		http.Redirect(w, r, "/rooms/"+rest+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	roomname, err := url.PathUnescape(rest[:i])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch rest[i+1:] {
+	case "":
+		s.serveLog(w, r, roomname)
+	case "feed.atom":
+		s.serveFeed(w, r, roomname)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// hookService returns the service name for a bearer token, or false if the
+// token isn't configured
+func (s *Server) hookService(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	for name, t := range s.HookTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// handleIncoming delivers a token authenticated payload into a room as a
+// service message
+func (s *Server) handleIncoming(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := s.hookService(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var p HookPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHookBody)).Decode(&p); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(p.Text) == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.Deliver(Event{
+		Type: EventService,
+		Time: time.Now().Format(time.RFC3339),
+		From: name,
+		Room: p.Room,
+		Text: p.Text,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	errl(nil, fmt.Sprintf("Service [%s] delivered a message to room [%s]", name, p.Room))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliver sends the event to every client in the event's room
+func (s *Server) Deliver(ev Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deliver(ev)
+}
+
+// deliver is Deliver without the lock
+func (s *Server) deliver(ev Event) error {
+	r, ok := s.Rooms[ev.Room]
+	if !ok {
+		return fmt.Errorf("room [%s] does not exist", ev.Room)
+	}
+
+	ev.ID = s.nextID()
+	for _, c := range r.Clients {
+		c.Send(ev)
+	}
+	r.record(ev)
+	s.emit(ev)
+	return nil
+}
+
+// parseTokens parses a comma separated list of name:token pairs
+func parseTokens(s string) map[string]string {
+	out := make(map[string]string)
+	for _, v := range splitList(s) {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) == 2 && kv[0] != "" && kv[1] != "" {
+			out[kv[0]] = kv[1]
+		}
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIncomingHook(t *testing.T) {
+	serv := NewServer()
+	serv.HookTokens = parseTokens("ci:abc123")
+
+	c, p := net.Pipe()
+	cl := &Client{nick: "batman", Conns: []*Conn{NewConn(c)}}
+	serv.JoinRoom("gotham", cl)
+
+	post := func(token, body string) int {
+		req := httptest.NewRequest("POST", "/hooks/incoming", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post("wrong", `{"room":"gotham","text":"hi"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %d", code)
+	}
+
+	if code := post("abc123", `{"room":"arkham","text":"hi"}`); code != http.StatusNotFound {
+		t.Errorf("expected unknown room to be not found, got %d", code)
+	}
+
+	done := make(chan int)
+	go func() { done <- post("abc123", `{"room":"gotham","text":"build passed"}`) }()
+
+	line, _ := bufio.NewReader(p).ReadString('\n')
+	if !strings.HasSuffix(line, ":ci (service)] build passed\r\n") {
+		t.Errorf("expected service message, got [%s]", line)
+	}
+
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("expected no content, got %d", code)
+	}
+}
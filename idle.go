@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// idleMessage is the away message of clients marked away for being idle
+const idleMessage = "idle"
+
+// idleShown is how long a client must be idle before /who shows it
+const idleShown = time.Minute
+
+// autoAwayTick is how often idle clients are checked for
+const autoAwayTick = 30 * time.Second
+
+// Idle returns how long ago the client last sent input on any of its
+// connections, it returns false if the client has no connections
+func (cl *Client) Idle() (time.Duration, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if len(cl.Conns) == 0 {
+		return 0, false
+	}
+	idle := cl.Conns[0].Idle()
+	for _, c := range cl.Conns[1:] {
+		if d := c.Idle(); d < idle {
+			idle = d
+		}
+	}
+	return idle, true
+}
+
+// autoAway returns how long clients may be idle before they are marked
+// away, 0 never marks them
+func (s *Server) autoAway() time.Duration {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	return s.AutoAway
+}
+
+// readTimeout returns how long a connection may send nothing before it is
+// closed, 0 never closes it
+func (s *Server) readTimeout() time.Duration {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	return s.ReadTimeout
+}
+
+// readDeadline sets when the connection is closed unless it sends
+// something, bots are never timed out
+func (s *Server) readDeadline(cl *Client, conn *Conn) {
+	if conn.Conn == nil || cl.Bot() {
+		return
+	}
+	var deadline time.Time
+	if d := s.readTimeout(); d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	conn.SetReadDeadline(deadline)
+}
+
+// TimeOut closes a connection that sent nothing for longer than
+// TCReadTimeout, the session ends with it
+func (s *Server) TimeOut(cl *Client, conn *Conn) {
+	conn.send(conn.Render(Event{Type: EventText, Text: fmt.Sprintf("Disconnected after %s without input\r\n", s.readTimeout())}))
+	s.CloseClient(cl, conn, "timed out")
+}
+
+// MarkIdle marks away the clients idle for longer than TCAutoAway, bots and
+// clients already away are left alone
+func (s *Server) MarkIdle() {
+	after := s.autoAway()
+	if after <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.Clients {
+		idle, ok := c.Idle()
+		if !ok || idle < after || c.Bot() {
+			continue
+		}
+		c.mu.Lock()
+		mark := c.away == ""
+		if mark {
+			c.away, c.idle = idleMessage, true
+		}
+		c.mu.Unlock()
+		if mark {
+			s.announce(c)
+		}
+	}
+}
+
+// RunAutoAway marks idle clients away every so often
+func (s *Server) RunAutoAway() {
+	for range time.Tick(autoAwayTick) {
+		s.MarkIdle()
+	}
+}
+
+// Active brings a client marked away for being idle back once it sends
+// input
+func (s *Server) Active(cl *Client) {
+	cl.mu.Lock()
+	idle := cl.idle
+	cl.mu.Unlock()
+	if !idle {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl.mu.Lock()
+	back := cl.idle
+	if back {
+		cl.away, cl.idle = "", false
+	}
+	cl.mu.Unlock()
+	if back {
+		s.announce(cl)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoAway(t *testing.T) {
+	serv := NewServer()
+	conn := &Conn{active: time.Now().Add(-time.Hour).UnixNano()}
+	batman, robin := &Client{nick: "batman", Conns: []*Conn{conn}}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", robin)
+	serv.SetNotices(batman, true)
+
+	if idle, ok := batman.Idle(); !ok || idle < time.Hour {
+		t.Errorf("expected batman idle for an hour, got %s", idle)
+	}
+	if _, ok := robin.Idle(); ok {
+		t.Errorf("expected no idle time without connections")
+	}
+	if out, _ := serv.Whois("batman"); !strings.Contains(out, ", idle 1h0m") {
+		t.Errorf("expected whois to show idle time, got [%s]", out)
+	}
+	if out, _ := serv.Who(robin); !strings.Contains(out, "batman (idle 1h0m0s)") {
+		t.Errorf("expected who to show idle time, got [%s]", out)
+	}
+
+	// without TCAutoAway nobody is marked
+	serv.MarkIdle()
+	if batman.Away() != "" {
+		t.Errorf("expected auto-away to be off")
+	}
+
+	serv.AutoAway = 10 * time.Minute
+	serv.MarkIdle()
+	if batman.Away() != idleMessage || batman.Status() != StatusAway {
+		t.Fatalf("expected batman marked away, got [%s]", batman.Away())
+	}
+	if ev := robin.unread[len(robin.unread)-1]; ev.Type != EventPresence || ev.Status != StatusAway {
+		t.Errorf("expected the room told, got %+v", ev)
+	}
+
+	conn.Touch()
+	serv.Active(batman)
+	if batman.Away() != "" {
+		t.Errorf("expected batman back once active")
+	}
+
+	// an away message set by hand stays
+	serv.SetAway(batman, "patrolling")
+	serv.Active(batman)
+	if batman.Away() != "patrolling" {
+		t.Errorf("expected the away message kept, got [%s]", batman.Away())
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	Serv = NewServer()
+	Serv.ReadTimeout = 50 * time.Millisecond
+
+	c1, c2 := net.Pipe()
+	conn := NewConn(c1)
+	batman, robin := &Client{nick: "batman", Conns: []*Conn{conn}}, &Client{nick: "robin"}
+	Serv.JoinRoom("gotham", batman)
+	Serv.JoinRoom("gotham", robin)
+
+	done := make(chan bool)
+	go func() {
+		clientRun(batman, conn, bufio.NewReader(c1))
+		close(done)
+	}()
+	if out, _ := bufio.NewReader(c2).ReadString('\n'); out != "Disconnected after 50ms without input\r\n" {
+		t.Errorf("expected to be told, got [%s]", out)
+	}
+	go ioutil.ReadAll(c2)
+	<-done
+
+	if Serv.HasClient("batman") {
+		t.Errorf("expected batman to be disconnected")
+	}
+	if ev := robin.unread[len(robin.unread)-1]; ev.Type != EventQuit || ev.Text != "timed out" {
+		t.Errorf("expected the room told, got %+v", ev)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultImageTypes are the content types of images that may be uploaded
+const DefaultImageTypes = "image/png,image/jpeg,image/gif,image/webp"
+
+// parseImageTypes reads the content types images may have from the
+// environment
+func parseImageTypes() map[string]bool {
+	v := os.Getenv("TCImageTypes")
+	if len(v) == 0 {
+		v = DefaultImageTypes
+	}
+	types := make(map[string]bool)
+	for _, t := range splitList(v) {
+		types[strings.ToLower(t)] = true
+	}
+	return types
+}
+
+// shortID returns a random id short enough for a link pasted in chat
+func shortID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StoreImage keeps an image of type typ, under a short id
+func (f *Files) StoreImage(from, typ string, b []byte) (*StoredFile, error) {
+	id, err := shortID()
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	_, taken := f.files[id]
+	f.mu.Unlock()
+	if taken {
+		return nil, fmt.Errorf("image id [%s] is taken", id)
+	}
+	return f.store(&StoredFile{ID: id, Name: id, From: from, Type: typ}, b)
+}
+
+// ImageLink returns the short link an image is shown at
+func (f *Files) ImageLink(sf *StoredFile) string {
+	return fmt.Sprintf("%s/i/%s", f.URL, sf.ID)
+}
+
+// sessionClient returns the client the session token of the request was
+// issued to
+func (s *Server) sessionClient(r *http.Request) (*Client, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, cl := range s.Sessions {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return cl, true
+		}
+	}
+	return nil, false
+}
+
+// imageType returns the content type of an upload when it may be shared,
+// the type sent must be allowed and match what the contents look like
+func (s *Server) imageType(r *http.Request, b []byte) (string, error) {
+	typ, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !s.ImageTypes[typ] {
+		var types []string
+		for t := range s.ImageTypes {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return "", fmt.Errorf("images may be %s", strings.Join(types, ", "))
+	}
+	if sniffed := http.DetectContentType(b); sniffed != typ {
+		return "", fmt.Errorf("the image is not %s", typ)
+	}
+	return typ, nil
+}
+
+// ShareImage posts the short link to an image in the client's room as a
+// message from it
+func (s *Server) ShareImage(cl *Client, sf *StoredFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.imageRoom(cl)
+	if err != nil {
+		return err
+	}
+	if err := s.takeQuota(cl); err != nil {
+		return err
+	}
+	link := s.Files.ImageLink(sf)
+	s.post(r, Event{
+		ID:   s.nextID(),
+		Type: EventMessage,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Room: r.Name,
+		Text: fmt.Sprintf("shared an image (%s) %s", fileSize(sf.Size), link),
+		File: link,
+	})
+	return nil
+}
+
+// CanShareImage returns an error if cl may not share an image in its room
+// now, it's checked before an upload is stored
+func (s *Server) CanShareImage(cl *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.imageRoom(cl)
+	return err
+}
+
+// imageRoom returns the room cl shares images to, or an error if cl may
+// not, it must be called with the server lock held
+func (s *Server) imageRoom(cl *Client) (*Room, error) {
+	if err := s.silenced(cl); err != nil {
+		return nil, err
+	}
+	if err := s.probation(cl, "share images"); err != nil {
+		return nil, err
+	}
+	return s.findRoom(cl)
+}
+
+// handleImages accepts images posted to /images by a client with its
+// session token and shows them at /i/<id>
+func (s *Server) handleImages(w http.ResponseWriter, r *http.Request) {
+	if s.Files == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if id := strings.TrimPrefix(r.URL.Path, "/i/"); id != r.URL.Path {
+		s.serveImage(w, r, id)
+		return
+	}
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cl, ok := s.sessionClient(r)
+	if !ok {
+		http.Error(w, "invalid session token", http.StatusUnauthorized)
+		return
+	}
+	// refused before anything is stored
+	if err := s.CanShareImage(cl); err != nil {
+		http.Error(w, strings.TrimSpace(err.Error()), http.StatusForbidden)
+		return
+	}
+	tooLarge := fmt.Sprintf("images may be up to %s", fileSize(s.Files.MaxSize))
+	if r.ContentLength > s.Files.MaxSize {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.Files.MaxSize))
+	if err != nil {
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	typ, err := s.imageType(r, b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	sf, err := s.Files.StoreImage(cl.Nick(), typ, b)
+	if err != nil {
+		errl(err, "")
+		http.Error(w, "unable to store the image", http.StatusInternalServerError)
+		return
+	}
+	if err := s.ShareImage(cl, sf); err != nil {
+		s.Files.Remove(sf.ID)
+		http.Error(w, strings.TrimSpace(err.Error()), http.StatusForbidden)
+		return
+	}
+	errl(nil, fmt.Sprintf("[%s] shared an image %s (%s)", cl.Nick(), sf.ID, fileSize(sf.Size)))
+	fmt.Fprintf(w, "%s\n", s.Files.ImageLink(sf))
+}
+
+// serveImage shows the image with id inline
+func (s *Server) serveImage(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sf, b, ok := s.Files.Open(id)
+	if !ok || sf.Type == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", sf.Type)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Header().Set("Content-Disposition", "inline")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
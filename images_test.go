@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImages(t *testing.T) {
+	serv := NewServer()
+	files, err := OpenFiles(filepath.Join(t.TempDir(), "files"), "http://chat.example", 64, time.Hour)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Files = files
+	serv.ImageTypes = parseImageTypes()
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("batcave", batman)
+	token, err := serv.NewSession(batman)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 8)
+	post := func(token, typ, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/images", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", typ)
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("wrong", "image/png", png); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown session refused, got %d", w.Code)
+	}
+	if w := post(token, "text/html", "<script>alert(1)</script>"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected html refused, got %d", w.Code)
+	}
+	if w := post(token, "image/png", "<script>alert(1)</script>"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected contents that aren't a png refused, got %d", w.Code)
+	}
+	if w := post(token, "image/png", png+strings.Repeat("\x00", 64)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a large image refused, got %d", w.Code)
+	}
+
+	// guests on probation are refused before anything is stored
+	serv.ProbationPeriod = time.Hour
+	if w := post(token, "image/png", png); w.Code != http.StatusForbidden {
+		t.Errorf("expected an image on probation refused, got %d", w.Code)
+	}
+	serv.ProbationPeriod = 0
+	if len(files.files) != 0 {
+		t.Errorf("expected a refused image not stored, got %d file(s)", len(files.files))
+	}
+
+	w := post(token, "image/png", png)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the image accepted, got %d %s", w.Code, w.Body.String())
+	}
+	link := strings.TrimSpace(w.Body.String())
+	if !strings.HasPrefix(link, "http://chat.example/i/") || len(link) > len("http://chat.example/i/")+8 {
+		t.Errorf("expected a short link, got %s", link)
+	}
+	h := serv.Rooms["batcave"].History
+	if len(h) != 1 || h[0].File != link || h[0].From != "batman" {
+		t.Fatalf("expected the link posted to the room, got %+v", h)
+	}
+
+	req := httptest.NewRequest("GET", strings.TrimPrefix(link, "http://chat.example"), nil)
+	w = httptest.NewRecorder()
+	serv.HTTPHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != png || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("expected the image shown, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	files.Expire(time.Now().Add(2 * time.Hour))
+	w = httptest.NewRecorder()
+	serv.HTTPHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected an expired image gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// journalQueue is how many records may wait to be shipped to kafka
+const journalQueue = 1024
+
+// journalRecord is a line of the journal, either an event or a change to
+// the rooms, bans, or accounts
+type journalRecord struct {
+	Event *Event       `json:"event,omitempty"`
+	Cmd   *raftCommand `json:"cmd,omitempty"`
+}
+
+// Journal appends every event and state change to a file, optionally
+// shipping each record to kafka, replaying it rebuilds the rooms, their
+// history, and the accounts
+type Journal struct {
+	mu     sync.Mutex
+	server *Server
+	path   string
+	f      *os.File
+	ship   *Kafka
+	out    chan []byte
+}
+
+// OpenJournal replays the journal at path into the server and opens it
+// for appending, a new replica without a journal is bootstrapped from
+// kafka when ship is set
+func OpenJournal(s *Server, path string, ship *Kafka) (*Journal, error) {
+	j := &Journal{server: s, path: path, ship: ship}
+
+	if f, err := os.Open(path); err == nil {
+		err = s.Replay(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else if ship != nil {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		err = s.replayKafka(ship, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+
+	if ship != nil {
+		j.out = make(chan []byte, journalQueue)
+		go j.run()
+	}
+	return j, nil
+}
+
+// Event appends an event to the journal
+func (j *Journal) Event(ev Event) {
+	if j == nil {
+		return
+	}
+	j.append(journalRecord{Event: &ev})
+}
+
+// Command appends a state change to the journal
+func (j *Journal) Command(cmd raftCommand) {
+	if j == nil {
+		return
+	}
+	j.append(journalRecord{Cmd: &cmd})
+}
+
+func (j *Journal) append(rec journalRecord) {
+	b, err := json.Marshal(rec)
+	if err == nil {
+		b, err = sealData(b)
+	}
+	if err != nil {
+		errl(err, "")
+		return
+	}
+
+	j.mu.Lock()
+	_, err = j.f.Write(append(b, '\n'))
+	j.mu.Unlock()
+	errl(err, "")
+
+	if j.out != nil {
+		select {
+		case j.out <- b:
+		default:
+			errl(fmt.Errorf("journal queue is full, record not shipped"), "")
+		}
+	}
+}
+
+// Rotate closes the current segment of the journal and starts a new one
+// beginning with the state of the rooms, bans, accounts, and history so
+// the closed segment is no longer needed to replay, it returns the path
+// of the closed segment
+func (j *Journal) Rotate() (string, error) {
+	var recs []journalRecord
+	s := j.server
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := s.Rooms[name]
+		for nick := range r.Bans {
+			recs = append(recs, journalRecord{Cmd: &raftCommand{Op: raftBan, Room: r.Name, Nick: nick}})
+		}
+		for nick := range r.Ops {
+			recs = append(recs, journalRecord{Cmd: &raftCommand{Op: raftOp, Room: r.Name, Nick: nick}})
+		}
+		for _, ev := range r.History {
+			ev := ev
+			recs = append(recs, journalRecord{Event: &ev})
+		}
+		// the settings follow the history so replaying it doesn't count
+		// its messages on top of the room's counters
+		cmd := r.settings()
+		recs = append(recs, journalRecord{Cmd: &cmd})
+	}
+
+	s.Accounts.mu.Lock()
+	for _, a := range s.Accounts.Accounts {
+		a := *a
+		recs = append(recs, journalRecord{Cmd: &raftCommand{Op: raftAccount, Account: &a}})
+	}
+	s.Accounts.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	closed := rotatedName(j.path, time.Now())
+	if err := os.Rename(j.path, closed); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return "", err
+	}
+	j.f.Close()
+	j.f = f
+
+	for _, rec := range recs {
+		b, err := json.Marshal(rec)
+		if err == nil {
+			b, err = sealData(b)
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return "", err
+		}
+	}
+	return closed, nil
+}
+
+// run ships queued records to kafka
+func (j *Journal) run() {
+	for b := range j.out {
+		if err := j.ship.Publish(b); err != nil {
+			errl(fmt.Errorf("journal shipping failed: %v", err), "")
+			j.ship.Close()
+		}
+	}
+}
+
+// Replay applies every record of a journal to the server, messages already
+// known from a snapshot are skipped
+func (s *Server) Replay(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	n := 0
+	for sc.Scan() {
+		if err := s.replayLine(sc.Bytes()); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	errl(nil, fmt.Sprintf("Replayed %d journal record(s)", n))
+	return nil
+}
+
+// replayKafka applies the records shipped to kafka up to its high
+// watermark, copying each to the local journal w
+func (s *Server) replayKafka(k *Kafka, w io.Writer) error {
+	var offset int64
+	for {
+		values, next, high, err := k.Fetch(offset)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err := s.replayLine(v); err != nil {
+				return err
+			}
+			if _, err := w.Write(append(v, '\n')); err != nil {
+				return err
+			}
+		}
+		offset = next
+		if offset >= high {
+			errl(nil, fmt.Sprintf("Bootstrapped from kafka at offset %d", offset))
+			return nil
+		}
+	}
+}
+
+// replayLine applies a single record, sealed or not
+func (s *Server) replayLine(line []byte) error {
+	line, err := openData(line)
+	if err != nil {
+		return err
+	}
+	var rec journalRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return err
+	}
+
+	if rec.Cmd != nil {
+		s.applyCommand(*rec.Cmd)
+		return nil
+	}
+	if rec.Event == nil {
+		return nil
+	}
+
+	ev := *rec.Event
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev.Type {
+	case EventJoin:
+		if !s.roomExists(ev.Room) {
+			s.createRoom(ev.Room)
+		}
+	case EventMessage, EventService, EventNotice:
+		if ev.ID != 0 && ev.ID <= s.lastID {
+			return nil
+		}
+		r, ok := s.Rooms[ev.Room]
+		if !ok {
+			r = s.createRoom(ev.Room)
+		}
+		r.record(ev)
+		if ev.ID > s.lastID {
+			s.lastID = ev.ID
+		}
+	}
+	return nil
+}
+
+// change makes a change to the rooms, bans, or accounts, replicating and
+// journaling it, it is only applied once committed, so it must not be
+// called with the server lock held
+func (s *Server) change(cmd raftCommand) error {
+	if s.Raft == nil {
+		s.applyCommand(cmd)
+	} else if err := s.Raft.Propose(cmd); err != nil {
+		return err
+	}
+	s.Journal.Command(cmd)
+	return nil
+}
+
+// changeLocked is change to a room for callers holding the server lock,
+// without a
+// cluster it is applied at once, otherwise it is applied once committed
+// and the caller doesn't wait for it
+func (s *Server) changeLocked(cmd raftCommand) {
+	if s.Raft == nil {
+		s.applyRoom(cmd)
+		s.Journal.Command(cmd)
+		return
+	}
+	s.Raft.Submit(cmd, func(ok bool) {
+		if ok {
+			s.Journal.Command(cmd)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "journal.log")
+
+	serv := NewServer()
+	serv.Journal, err = OpenJournal(serv, file, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.SetTopic(batman, "the dark knight")
+	serv.Ban(batman, "joker")
+	serv.Message([]string{"hello"}, batman)
+	serv.Message([]string{"gotham"}, batman)
+
+	replica := NewServer()
+	if _, err := OpenJournal(replica, file, nil); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	r, ok := replica.Rooms["gotham"]
+	if !ok {
+		t.Fatalf("expected room to be rebuilt")
+	}
+	if r.Owner != "batman" || r.Topic != "the dark knight" || !r.Bans["joker"] {
+		t.Errorf("unexpected room %+v", r)
+	}
+	if len(r.History) != 2 || r.History[1].Text != "gotham" {
+		t.Errorf("expected history to be rebuilt, got %d message(s)", len(r.History))
+	}
+
+	// messages already restored from a snapshot are not duplicated
+	f, _ := os.Open(file)
+	defer f.Close()
+	replica.Replay(f)
+	if len(r.History) != 2 {
+		t.Errorf("expected replay to skip known messages, got %d", len(r.History))
+	}
+}
+
+func TestKafkaFetchResponse(t *testing.T) {
+	rec, _ := json.Marshal(journalRecord{Event: &Event{Type: EventMessage, Text: "hi"}})
+	batch := kafkaRecordBatch(rec, time.Now())
+	binary.BigEndian.PutUint64(batch, 7) // base offset
+
+	var resp bytes.Buffer
+	binary.Write(&resp, binary.BigEndian, int32(1)) // correlation id
+	binary.Write(&resp, binary.BigEndian, int32(0)) // throttle
+	binary.Write(&resp, binary.BigEndian, int32(1)) // topics
+	kafkaString(&resp, "tinychat-journal")
+	binary.Write(&resp, binary.BigEndian, int32(1)) // partitions
+	binary.Write(&resp, binary.BigEndian, int32(0))
+	binary.Write(&resp, binary.BigEndian, int16(0))
+	binary.Write(&resp, binary.BigEndian, int64(8)) // high watermark
+	binary.Write(&resp, binary.BigEndian, int64(8))
+	binary.Write(&resp, binary.BigEndian, int32(-1)) // aborted transactions
+	binary.Write(&resp, binary.BigEndian, int32(len(batch)))
+	resp.Write(batch)
+
+	values, next, high, err := kafkaFetchResponse(resp.Bytes(), 7)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if len(values) != 1 || string(values[0]) != string(rec) {
+		t.Errorf("unexpected values %q", values)
+	}
+	if next != 8 || high != 8 {
+		t.Errorf("expected next offset 8 and high watermark 8, got %d and %d", next, high)
+	}
+
+	if values, _, _, _ := kafkaFetchResponse(resp.Bytes(), 8); len(values) != 0 {
+		t.Errorf("expected records before the offset to be skipped")
+	}
+}
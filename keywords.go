@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxKeywords is how many keywords an account may subscribe to
+const maxKeywords = 20
+
+// Keywords returns the keywords the client is flagged messages for, and
+// whether public rooms it isn't in are included
+func (cl *Client) Keywords() ([]string, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return append([]string{}, cl.keywords...), cl.keywordsPublic
+}
+
+// keywordIn returns the first of the client's keywords the text contains
+// as a word, with the client's lock held
+func (cl *Client) keywordIn(text string) (string, bool) {
+	for _, kw := range cl.keywords {
+		if mentions(text, kw) {
+			return kw, true
+		}
+	}
+	return "", false
+}
+
+// updateKeywords applies fn to the account the client is logged in to and
+// keeps the client's copy of its keywords in step
+func (s *Server) updateKeywords(cl *Client, fn func(a *Account) error) error {
+	name := cl.Account()
+	if name == "" {
+		return fmt.Errorf("you must be logged in to be notified of keywords\r\n")
+	}
+
+	var err error
+	var list []string
+	var public bool
+	uerr := s.Accounts.Update(name, func(a *Account) {
+		err = fn(a)
+		list, public = append([]string{}, a.Keywords...), a.KeywordsPublic
+	})
+	if uerr != nil {
+		return uerr
+	}
+
+	cl.mu.Lock()
+	cl.keywords, cl.keywordsPublic = list, public
+	cl.mu.Unlock()
+	return err
+}
+
+// AddKeyword subscribes the client's account to messages containing the
+// keyword as a word, case is ignored
+// example: /notify add joker
+func (s *Server) AddKeyword(cl *Client, keyword string) error {
+	keyword = strings.ToLower(keyword)
+	if !mentions(keyword, keyword) {
+		return fmt.Errorf("[%s] is not a word that can be looked for\r\n", keyword)
+	}
+	return s.updateKeywords(cl, func(a *Account) error {
+		for _, kw := range a.Keywords {
+			if kw == keyword {
+				return fmt.Errorf("you are already notified of [%s]\r\n", keyword)
+			}
+		}
+		if len(a.Keywords) >= maxKeywords {
+			return fmt.Errorf("you can be notified of up to %d keywords\r\n", maxKeywords)
+		}
+		a.Keywords = append(a.Keywords, keyword)
+		return nil
+	})
+}
+
+// RemoveKeyword unsubscribes the client's account from the keyword
+func (s *Server) RemoveKeyword(cl *Client, keyword string) error {
+	keyword = strings.ToLower(keyword)
+	return s.updateKeywords(cl, func(a *Account) error {
+		for i, kw := range a.Keywords {
+			if kw == keyword {
+				a.Keywords = append(a.Keywords[:i:i], a.Keywords[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("you are not notified of [%s]\r\n", keyword)
+	})
+}
+
+// KeywordsPublic sets whether the client's account is also flagged
+// messages from public rooms it isn't in
+func (s *Server) KeywordsPublic(cl *Client, on bool) error {
+	return s.updateKeywords(cl, func(a *Account) error {
+		a.KeywordsPublic = on
+		return nil
+	})
+}
+
+// notifyKeywords sends a flagged copy of the message to every client
+// subscribed to a keyword it contains, in the room or, for those who want
+// them, in a public room, it must be called with the server lock held
+func (s *Server) notifyKeywords(r *Room, ev Event) {
+	if ev.Type != EventMessage || ev.Encrypted {
+		return
+	}
+	for nick, c := range s.Clients {
+		if nick == ev.From {
+			continue
+		}
+		c.mu.Lock()
+		kw, ok := c.keywordIn(ev.Text)
+		public := c.keywordsPublic
+		c.mu.Unlock()
+		if !ok || (r.Clients[nick] != c && !(public && r.shownPublicly(ev.ID))) {
+			continue
+		}
+		flagged := ev
+		flagged.Type, flagged.Keyword = EventKeyword, kw
+		c.Send(flagged)
+	}
+}
+
+// notifyCommand runs /notify
+func (s *Server) notifyCommand(in *Input) {
+	if len(in.Args) == 1 {
+		keywords, public := in.Client.Keywords()
+		sort.Strings(keywords)
+		where := "your room"
+		if public {
+			where = "your room and public rooms"
+		}
+		in.Client.Write(fmt.Sprintf("Notified of %s in %s\r\n", listOrNone(keywords), where))
+		return
+	}
+	if len(in.Args) < 3 {
+		in.Client.Write("Use /notify add <keyword>, /notify del <keyword>, or /notify public <on|off>\r\n")
+		return
+	}
+	arg := in.Args[2]
+	switch strings.ToLower(in.Args[1]) {
+	case "add":
+		reply(in.Client, fmt.Sprintf("You will be notified of [%s]\r\n", strings.ToLower(arg)), s.AddKeyword(in.Client, arg))
+	case "del":
+		reply(in.Client, fmt.Sprintf("You are no longer notified of [%s]\r\n", strings.ToLower(arg)), s.RemoveKeyword(in.Client, arg))
+	case "public":
+		on, err := onOff([]string{"/notify public", arg}, "change keyword notifications")
+		if err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+		reply(in.Client, fmt.Sprintf("Keywords in public rooms you aren't in turned %s\r\n", arg), s.KeywordsPublic(in.Client, on))
+	default:
+		in.Client.Write(fmt.Sprintf("[%s] is not a /notify action, use add, del, or public\r\n", in.Args[1]))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman := &Client{nick: "batman", account: "batman"}
+	robin := &Client{nick: "robin"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+	serv.JoinRoom("arkham", joker)
+
+	if err := serv.AddKeyword(robin, "joker"); err == nil {
+		t.Errorf("expected guests to be unable to subscribe")
+	}
+	if err := serv.AddKeyword(batman, "the joker"); err == nil {
+		t.Errorf("expected more than a word refused")
+	}
+	if err := serv.AddKeyword(batman, "Joker"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := serv.AddKeyword(batman, "joker"); err == nil {
+		t.Errorf("expected a keyword subscribed twice refused")
+	}
+	if a, _ := serv.Accounts.Get("batman"); len(a.Keywords) != 1 || a.Keywords[0] != "joker" {
+		t.Errorf("expected the keyword kept with the account, got %v", a.Keywords)
+	}
+
+	flagged := func() []Event {
+		var out []Event
+		for _, ev := range batman.unread {
+			if ev.Type == EventKeyword {
+				out = append(out, ev)
+			}
+		}
+		return out
+	}
+	serv.Message([]string{"the", "JOKER", "is", "loose"}, robin)
+	serv.Message([]string{"jokers", "wild"}, robin)
+	if got := flagged(); len(got) != 1 || got[0].Keyword != "joker" || got[0].From != "robin" || got[0].Room != "gotham" {
+		t.Fatalf("expected one flagged copy, got %+v", got)
+	}
+	ev := flagged()[0]
+	ev.Time = "now"
+	if got := ev.String(); got != "[now:robin in gotham] (joker) the JOKER is loose\r\n" {
+		t.Errorf("unexpected rendering [%s]", got)
+	}
+	serv.Message([]string{"joker", "here"}, batman)
+	if len(flagged()) != 1 {
+		t.Errorf("expected no copy of the client's own message")
+	}
+
+	// other rooms only when public and asked for
+	serv.Message([]string{"joker", "in", "arkham"}, joker)
+	serv.Rooms["arkham"].Public = true
+	serv.Message([]string{"joker", "in", "arkham"}, joker)
+	if len(flagged()) != 1 {
+		t.Errorf("expected no copies from other rooms yet")
+	}
+	if err := serv.KeywordsPublic(batman, true); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Message([]string{"joker", "in", "arkham"}, joker)
+	if got := flagged(); len(got) != 2 || got[1].Room != "arkham" {
+		t.Errorf("expected a copy from the public room, got %+v", got)
+	}
+	serv.Rooms["arkham"].Public = false
+	serv.Message([]string{"joker", "in", "arkham"}, joker)
+	if len(flagged()) != 2 {
+		t.Errorf("expected no copies from private rooms")
+	}
+
+	// keywords come back with a login
+	replica := NewServer()
+	replica.Accounts = serv.Accounts
+	other := &Client{nick: "guest1"}
+	replica.JoinRoom("gotham", other)
+	if _, err := replica.Login("batman", "alfred", other); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if keywords, public := other.Keywords(); len(keywords) != 1 || !public {
+		t.Errorf("expected the keywords restored, got %v %t", keywords, public)
+	}
+
+	if err := serv.RemoveKeyword(batman, "JOKER"); err != nil {
+		t.Errorf("expected error to be nil, got %v", err)
+	}
+	if err := serv.RemoveKeyword(batman, "joker"); err == nil {
+		t.Errorf("expected an unknown keyword refused")
+	}
+}
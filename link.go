@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// linkRetry is how long a leaf waits before reconnecting to its hub
+const linkRetry = 10 * time.Second
+
+// linkQueue is how many frames may wait to be written to a link
+const linkQueue = 1024
+
+// maxLinkFrame is the longest frame a server reads, before the handshake
+// as after it
+const maxLinkFrame = 1 << 20
+
+// link frame types
+const (
+	linkHello = "hello"
+	linkAuth  = "auth"
+	linkEvent = "event"
+)
+
+// linkFrame is a single line sent between linked servers, a hello carries
+// the challenge the other server must answer and an auth the answer
+type linkFrame struct {
+	Type   string `json:"type"`
+	Server string `json:"server"`
+	Nonce  string `json:"nonce,omitempty"`
+	Proof  string `json:"proof,omitempty"`
+	Event  *Event `json:"event,omitempty"`
+}
+
+// remoteUser is a nick connected to another server of the network
+type remoteUser struct {
+	via  string
+	room string
+}
+
+// link is a connection to a directly linked server
+type link struct {
+	name string
+	conn net.Conn
+	out  chan linkFrame
+}
+
+// Links joins the server to a network of linked servers, a hub accepts
+// leaf servers and a leaf connects to one hub, events are forwarded along
+// the tree so every server sees every join, nick change, and message
+type Links struct {
+	Server   *Server
+	Name     string
+	Password string
+
+	mu     sync.Mutex
+	peers  map[string]*link
+	remote map[string]remoteUser
+}
+
+// NewLinks returns the links of the server, name must be unique in the
+// network and every server must share the password
+func NewLinks(s *Server, name, password string) (*Links, error) {
+	if password == "" {
+		return nil, errors.New("a link password is required")
+	}
+	return &Links{
+		Server:   s,
+		Name:     name,
+		Password: password,
+		peers:    make(map[string]*link),
+		remote:   make(map[string]remoteUser),
+	}, nil
+}
+
+// Listen accepts leaf servers on addr
+func (l *Links) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				errl(err, "")
+				time.Sleep(maxAcceptBackoff)
+				continue
+			}
+			go func() {
+				err := l.serve(conn, true)
+				errl(err, "")
+			}()
+		}
+	}()
+	return nil
+}
+
+// Connect keeps the server linked to the hub at addr
+func (l *Links) Connect(addr string) {
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			errl(err, "")
+		} else {
+			err = l.serve(conn, false)
+			errl(fmt.Errorf("link to hub [%s] lost: %v", addr, err), "")
+		}
+		time.Sleep(linkRetry)
+	}
+}
+
+// serve authenticates the other server, sends the netburst, and relays
+// frames until the link drops, accept is true on the hub's side
+func (l *Links) serve(conn net.Conn, accept bool) error {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	buf := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(linkRetry))
+	name, err := l.handshake(enc, buf, accept)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	lk := &link{name: name, conn: conn, out: make(chan linkFrame, linkQueue)}
+	l.mu.Lock()
+	if _, ok := l.peers[lk.name]; ok {
+		l.mu.Unlock()
+		return fmt.Errorf("link refused, [%s] is already linked", lk.name)
+	}
+	l.peers[lk.name] = lk
+	l.mu.Unlock()
+	errl(nil, fmt.Sprintf("Linked to server [%s]", lk.name))
+
+	go func() {
+		for f := range lk.out {
+			if err := enc.Encode(f); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	for _, ev := range l.burst(lk.name) {
+		ev := ev
+		lk.send(linkFrame{Type: linkEvent, Server: l.Name, Event: &ev})
+	}
+
+	for {
+		var f linkFrame
+		if err = readFrame(buf, &f); err != nil {
+			break
+		}
+		if f.Type == linkEvent && f.Event != nil {
+			l.receive(lk.name, f)
+		}
+	}
+
+	l.unlink(lk)
+	return err
+}
+
+// handshake proves both servers know the password without sending it, each
+// answers the other's challenge with an HMAC of both challenges, the leaf
+// answers first so the hub tells nothing to a server that can't, it returns
+// the name of the other server
+func (l *Links) handshake(enc *json.Encoder, buf *bufio.Reader, accept bool) (string, error) {
+	nonce, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	hello := linkFrame{Type: linkHello, Server: l.Name, Nonce: nonce}
+
+	// the hub reads the leaf's hello before saying anything
+	var peer linkFrame
+	if !accept {
+		if err := enc.Encode(hello); err != nil {
+			return "", err
+		}
+	}
+	if err := readFrame(buf, &peer); err != nil {
+		return "", err
+	}
+	if peer.Type != linkHello || peer.Server == "" || peer.Server == l.Name || peer.Nonce == "" || peer.Nonce == nonce {
+		return "", errors.New("link refused, invalid hello")
+	}
+	if accept {
+		if err := enc.Encode(hello); err != nil {
+			return "", err
+		}
+	}
+
+	mine := linkFrame{Type: linkAuth, Server: l.Name, Proof: l.proof(peer.Nonce, nonce, l.Name)}
+	want := l.proof(nonce, peer.Nonce, peer.Server)
+	if !accept {
+		if err := enc.Encode(mine); err != nil {
+			return "", err
+		}
+	}
+	var auth linkFrame
+	if err := readFrame(buf, &auth); err != nil {
+		return "", err
+	}
+	if auth.Type != linkAuth || !hmac.Equal([]byte(auth.Proof), []byte(want)) {
+		return "", fmt.Errorf("link refused, bad password from [%s]", peer.Server)
+	}
+	if accept {
+		if err := enc.Encode(mine); err != nil {
+			return "", err
+		}
+	}
+	return peer.Server, nil
+}
+
+// proof answers the challenge of the other server, bound to the challenge
+// this server sent and to the name of the server answering
+func (l *Links) proof(challenge, own, name string) string {
+	mac := hmac.New(sha256.New, []byte(l.Password))
+	fmt.Fprintf(mac, "tinychat link\n%s\n%s\n%s", challenge, own, name)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readFrame reads one JSON line of at most maxLinkFrame bytes
+func readFrame(buf *bufio.Reader, f *linkFrame) error {
+	var line []byte
+	for {
+		part, err := buf.ReadSlice('\n')
+		line = append(line, part...)
+		if len(line) > maxLinkFrame {
+			return fmt.Errorf("link frame is longer than %d bytes", maxLinkFrame)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(line, f)
+	}
+}
+
+// send queues a frame, it never blocks the caller
+func (lk *link) send(f linkFrame) {
+	select {
+	case lk.out <- f:
+	default:
+		errl(fmt.Errorf("link [%s] queue is full, dropping frame", lk.name), "")
+	}
+}
+
+// burst returns a join for every nick the new link doesn't know about, the
+// local clients and those reached through other links
+func (l *Links) burst(to string) []Event {
+	now := time.Now().Format(time.RFC3339)
+	var out []Event
+
+	s := l.Server
+	s.mu.Lock()
+	for _, r := range s.Rooms {
+		for nick := range r.Clients {
+			out = append(out, Event{Type: EventJoin, Time: now, From: nick, Room: r.Name})
+		}
+	}
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	for nick, u := range l.remote {
+		if u.via != to {
+			out = append(out, Event{Type: EventJoin, Time: now, From: nick, Room: u.room})
+		}
+	}
+	l.mu.Unlock()
+	return out
+}
+
+// receive applies an event arriving over a link and forwards it to every
+// other link
+func (l *Links) receive(via string, f linkFrame) {
+	ev := *f.Event
+	local := ev.Type == EventJoin && l.Server.HasClient(ev.From)
+
+	l.mu.Lock()
+	switch ev.Type {
+	case EventJoin:
+		if u, ok := l.remote[ev.From]; (ok && u.via != via) || local {
+			l.mu.Unlock()
+			errl(fmt.Errorf("nick collision on [%s] from server [%s], ignoring", ev.From, f.Server), "")
+			return
+		}
+		l.remote[ev.From] = remoteUser{via: via, room: ev.Room}
+	case EventLeave:
+		if u, ok := l.remote[ev.From]; ok && u.via == via && u.room == ev.Room {
+			delete(l.remote, ev.From)
+		}
+	case EventNick:
+		if u, ok := l.remote[ev.From]; ok {
+			delete(l.remote, ev.From)
+			l.remote[ev.To] = u
+		}
+	}
+	l.forward(via, f)
+	l.mu.Unlock()
+
+	l.Server.applyRemote(ev)
+}
+
+// forward sends a frame to every link but the one it came from, it must
+// be called with the lock held
+func (l *Links) forward(from string, f linkFrame) {
+	for name, lk := range l.peers {
+		if name != from {
+			lk.send(f)
+		}
+	}
+}
+
+// unlink drops a link along with every nick reached through it, the other
+// links are told those nicks left
+func (l *Links) unlink(lk *link) {
+	now := time.Now().Format(time.RFC3339)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.peers, lk.name)
+	close(lk.out)
+	for nick, u := range l.remote {
+		if u.via != lk.name {
+			continue
+		}
+		delete(l.remote, nick)
+		ev := Event{Type: EventLeave, Time: now, From: nick, Room: u.room}
+		l.forward(lk.name, linkFrame{Type: linkEvent, Server: l.Name, Event: &ev})
+	}
+	errl(nil, fmt.Sprintf("Unlinked from server [%s]", lk.name))
+}
+
+// Publish sends a locally originated event to every linked server
+func (l *Links) Publish(ev Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.forward("", linkFrame{Type: linkEvent, Server: l.Name, Event: &ev})
+}
+
+// Has returns true if the nick is connected to another server of the
+// network
+func (l *Links) Has(nick string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.remote[nick]
+	return ok
+}
+
+// remoteNick returns true if the nick is connected to another node or
+// linked server
+func (s *Server) remoteNick(nick string) bool {
+	return s.Cluster.Has(nick) || s.Links.Has(nick) || s.Gossip.Has(nick)
+}
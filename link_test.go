@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// linkPair links a hub and a leaf over loopback TCP
+func linkPair(t *testing.T, hub, leaf *Links) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			hub.serve(conn, true)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	go leaf.serve(conn, false)
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLinkBurstAndRelay(t *testing.T) {
+	hubServ, leafServ := NewServer(), NewServer()
+	hubServ.Links, _ = NewLinks(hubServ, "hub", "s3cret")
+	leafServ.Links, _ = NewLinks(leafServ, "leaf", "s3cret")
+
+	hubServ.JoinRoom("gotham", &Client{nick: "batman"})
+
+	c1, p1 := net.Pipe()
+	robin := &Client{nick: "robin", Conns: []*Conn{NewConn(c1)}}
+	leafServ.JoinRoom("gotham", robin)
+
+	linkPair(t, hubServ.Links, leafServ.Links)
+	waitFor(t, "netburst", func() bool {
+		return leafServ.Links.Has("batman") && hubServ.Links.Has("robin")
+	})
+
+	if err := leafServ.ChangeNick("robin", "batman"); err == nil {
+		t.Errorf("expected linked nick to be taken")
+	}
+
+	go hubServ.Message([]string{"to", "the", "batmobile"}, hubServ.Clients["batman"])
+	line, _ := bufio.NewReader(p1).ReadString('\n')
+	if want := "to the batmobile\r\n"; len(line) < len(want) || line[len(line)-len(want):] != want {
+		t.Errorf("expected linked message to be delivered, got [%s]", line)
+	}
+}
+
+func TestLinkBadPassword(t *testing.T) {
+	hub, _ := NewLinks(NewServer(), "hub", "s3cret")
+	leaf, _ := NewLinks(NewServer(), "leaf", "wrong")
+
+	linkPair(t, hub, leaf)
+	time.Sleep(100 * time.Millisecond)
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.peers) != 0 {
+		t.Errorf("expected link to be refused")
+	}
+}
+
+func TestLinkHelloKeepsSecret(t *testing.T) {
+	hub, _ := NewLinks(NewServer(), "hub", "s3cret")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			hub.serve(conn, true)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer conn.Close()
+
+	// the hub says nothing until it hears a hello
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := bufio.NewReader(conn)
+	if _, err := buf.ReadString('\n'); err == nil {
+		t.Errorf("expected the hub to wait for the leaf's hello")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	json.NewEncoder(conn).Encode(linkFrame{Type: linkHello, Server: "joker", Nonce: "abc"})
+	var hello linkFrame
+	if err := readFrame(buf, &hello); err != nil || hello.Nonce == "" {
+		t.Fatalf("expected the hub's challenge, got %+v %v", hello, err)
+	}
+	if line, _ := json.Marshal(hello); strings.Contains(string(line), "s3cret") {
+		t.Errorf("expected the password kept out of the hello")
+	}
+
+	json.NewEncoder(conn).Encode(linkFrame{Type: linkAuth, Server: "joker", Proof: "guess"})
+	if _, err := buf.ReadString('\n'); err == nil {
+		t.Errorf("expected the hub to hang up on a bad proof without answering")
+	}
+}
+
+func TestLinkPasswordRequired(t *testing.T) {
+	if _, err := NewLinks(NewServer(), "hub", ""); err == nil {
+		t.Errorf("expected links without a password refused")
+	}
+}
+
+func TestReadFrameLimit(t *testing.T) {
+	long := strings.Repeat("a", maxLinkFrame+1) + "\n"
+	var f linkFrame
+	if err := readFrame(bufio.NewReader(strings.NewReader(long)), &f); err == nil {
+		t.Errorf("expected a frame over the limit refused")
+	}
+	if err := readFrame(bufio.NewReader(strings.NewReader(`{"type":"hello","server":"leaf"}`+"\n")), &f); err != nil || f.Server != "leaf" {
+		t.Errorf("expected a frame read, got %+v %v", f, err)
+	}
+}
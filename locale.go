@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is the language the server's messages are written in
+const DefaultLocale = "en"
+
+// Catalog maps the English text of a message, as written in the source
+// with its fmt verbs, to its translation, which may reorder the verbs with
+// %[n]s
+type Catalog map[string]string
+
+// builtinCatalogs are the translations shipped with the server, TCLocales
+// adds more or overrides these
+var builtinCatalogs = map[string]Catalog{
+	"de": {
+		banner:                                      "\n--|Willkommen|-----------------------------------------------------------------------------------\n\nDu bist [{{.Nick}}], willkommen bei {{.Server}}. \n\n--|Hilfe|----------------------------------------------------------------------------------------\n\n",
+		"Command not recognized\r\n":                "Befehl nicht erkannt\r\n",
+		"Joining room %s\r\n":                       "Betrete Raum %s\r\n",
+		"Nick changed from [%s] to [%s]\r\n":        "Nick von [%s] zu [%s] geändert\r\n",
+		"Nick unchanged and is currently [%s] \r\n": "Nick unverändert, er ist [%s] \r\n",
+		"Your session token is [%s], use /resume %s to restore this session within %s\r\n": "Dein Sitzungstoken ist [%s], mit /resume %s stellst du diese Sitzung innerhalb von %s wieder her\r\n",
+		"Logged in as [%s]\r\n":                                 "Angemeldet als [%s]\r\n",
+		"Goodbye [%s]\r\n":                                      "Auf Wiedersehen [%s]\r\n",
+		"user [%s] already exists\r\n":                          "Benutzer [%s] existiert bereits\r\n",
+		"user [%s] does not exist\r\n":                          "Benutzer [%s] existiert nicht\r\n",
+		"you are muted\r\n":                                     "du bist stummgeschaltet\r\n",
+		"invalid nick or password\r\n":                          "ungültiger Nick oder ungültiges Passwort\r\n",
+		"only registered users can use %s, /register first\r\n": "nur registrierte Benutzer können %s nutzen, zuerst /register\r\n",
+		"only %s can use %s\r\n":                                "nur %s können %[2]s nutzen\r\n",
+		"Something went wrong, you were disconnected\r\n":       "Etwas ist schiefgelaufen, deine Verbindung wurde getrennt\r\n",
+		"Disconnected after %s without input\r\n":               "Verbindung nach %s ohne Eingabe getrennt\r\n",
+		"Language is [%s], available: %s\r\n":                   "Sprache ist [%s], verfügbar: %s\r\n",
+		"Language set to [%s]\r\n":                              "Sprache auf [%s] gesetzt\r\n",
+		"[%s] is not a language, available: %s\r\n":             "[%s] ist keine Sprache, verfügbar: %s\r\n",
+	},
+}
+
+// verbRe matches a fmt verb, with an optional argument index, flags, width
+// and precision
+var verbRe = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+// pattern matches a formatted English message and rewrites it translated
+type pattern struct {
+	re *regexp.Regexp
+	to string
+}
+
+// catalog is a Catalog ready to translate, messages without verbs are
+// looked up as is, the rest are matched against their patterns
+type catalog struct {
+	exact    map[string]string
+	patterns []pattern
+}
+
+// compile prepares c to translate
+func (c Catalog) compile() *catalog {
+	out := &catalog{exact: make(map[string]string)}
+	var keys []string
+	for from := range c {
+		keys = append(keys, from)
+	}
+	// longer formats are more specific, try them first
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, from := range keys {
+		to := c[from]
+		if !verbRe.MatchString(strings.Replace(from, "%%", "", -1)) {
+			out.exact[strings.Replace(from, "%%", "%", -1)] = fill(to, nil)
+			continue
+		}
+		var b strings.Builder
+		b.WriteString("(?s)^")
+		last := 0
+		for _, m := range verbRe.FindAllStringIndex(from, -1) {
+			b.WriteString(regexp.QuoteMeta(from[last:m[0]]))
+			if from[m[0]:m[1]] == "%%" {
+				b.WriteString("%")
+			} else {
+				b.WriteString("(.*?)")
+			}
+			last = m[1]
+		}
+		b.WriteString(regexp.QuoteMeta(from[last:]) + "$")
+		out.patterns = append(out.patterns, pattern{regexp.MustCompile(b.String()), to})
+	}
+	return out
+}
+
+// translate returns text translated, or text itself if the catalog has no
+// translation for it
+func (c *catalog) translate(text string) string {
+	if to, ok := c.exact[text]; ok {
+		return to
+	}
+	for _, p := range c.patterns {
+		if m := p.re.FindStringSubmatch(text); m != nil {
+			return fill(p.to, m[1:])
+		}
+	}
+	return text
+}
+
+// fill replaces the verbs of format with args, in order or by their index
+func fill(format string, args []string) string {
+	next := 0
+	return verbRe.ReplaceAllStringFunc(format, func(verb string) string {
+		if verb == "%%" {
+			return "%"
+		}
+		i := next
+		if m := verbRe.FindStringSubmatch(verb); m[1] != "" {
+			n, _ := strconv.Atoi(strings.Trim(m[1], "[]"))
+			i = n - 1
+		}
+		next = i + 1
+		if i < 0 || i >= len(args) {
+			return verb
+		}
+		return args[i]
+	})
+}
+
+// locales are the catalogs by language and the language of clients that
+// haven't picked one
+var locales = struct {
+	sync.RWMutex
+	catalogs map[string]*catalog
+	def      string
+}{catalogs: compileCatalogs(builtinCatalogs, nil), def: DefaultLocale}
+
+// compileCatalogs compiles the built in catalogs with those loaded on top
+func compileCatalogs(builtin, loaded map[string]Catalog) map[string]*catalog {
+	merged := make(map[string]Catalog)
+	for _, cats := range []map[string]Catalog{builtin, loaded} {
+		for lang, c := range cats {
+			if merged[lang] == nil {
+				merged[lang] = make(Catalog)
+			}
+			for from, to := range c {
+				merged[lang][from] = to
+			}
+		}
+	}
+	out := make(map[string]*catalog)
+	for lang, c := range merged {
+		out[lang] = c.compile()
+	}
+	return out
+}
+
+// loadCatalogs reads a catalog from every <lang>.json file of dir, an
+// empty dir loads none
+func loadCatalogs(dir string) (map[string]Catalog, error) {
+	out := make(map[string]Catalog)
+	if dir == "" {
+		return out, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var c Catalog
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		out[strings.TrimSuffix(filepath.Base(f), ".json")] = c
+	}
+	return out, nil
+}
+
+// configureLocales replaces the catalogs with the built in ones and those
+// loaded, def is the language of clients that haven't picked one
+func configureLocales(loaded map[string]Catalog, def string) error {
+	cats := compileCatalogs(builtinCatalogs, loaded)
+	if def == "" {
+		def = DefaultLocale
+	}
+	if _, ok := cats[def]; !ok && def != DefaultLocale {
+		return fmt.Errorf("no catalog for TCLocale [%s]", def)
+	}
+	locales.Lock()
+	defer locales.Unlock()
+	locales.catalogs = cats
+	locales.def = def
+	return nil
+}
+
+// Languages returns the languages clients may pick
+func Languages() []string {
+	locales.RLock()
+	defer locales.RUnlock()
+	langs := []string{DefaultLocale}
+	for lang := range locales.catalogs {
+		if lang != DefaultLocale {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// translate returns text in lang, the server's default language when lang
+// is empty
+func translate(lang, text string) string {
+	locales.RLock()
+	defer locales.RUnlock()
+	if lang == "" {
+		lang = locales.def
+	}
+	c, ok := locales.catalogs[lang]
+	if !ok {
+		return text
+	}
+	return c.translate(text)
+}
+
+// localize translates the text of server replies and notices, the
+// messages people send are left alone
+func localize(ev Event, lang string) Event {
+	if ev.Type == EventText || (ev.Type == EventService || ev.Type == EventNotice) && ev.From == "server" {
+		ev.Text = translate(lang, ev.Text)
+	}
+	return ev
+}
+
+// Lang returns the language the client picked, empty for the server's
+func (cl *Client) Lang() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.lang
+}
+
+// SetLang switches the language of the client's server messages, saved to
+// its account if it is logged in
+func (s *Server) SetLang(cl *Client, lang string) error {
+	lang = strings.ToLower(lang)
+	known := false
+	for _, l := range Languages() {
+		known = known || l == lang
+	}
+	if !known {
+		return fmt.Errorf("[%s] is not a language, available: %s\r\n", lang, strings.Join(Languages(), ", "))
+	}
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.Lang = lang }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.lang = lang
+	cl.mu.Unlock()
+	return nil
+}
+
+// langCommand runs /lang
+func (s *Server) langCommand(in *Input) {
+	if len(in.Args) < 2 {
+		lang := in.Client.Lang()
+		if lang == "" {
+			lang = defaultLang()
+		}
+		in.Client.Write(fmt.Sprintf("Language is [%s], available: %s\r\n", lang, strings.Join(Languages(), ", ")))
+		return
+	}
+	err := s.SetLang(in.Client, in.Args[1])
+	reply(in.Client, fmt.Sprintf("Language set to [%s]\r\n", strings.ToLower(in.Args[1])), err)
+}
+
+// defaultLang returns the language of clients that haven't picked one
+func defaultLang() string {
+	locales.RLock()
+	defer locales.RUnlock()
+	return locales.def
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	c := Catalog{
+		"Joining room %s\r\n":            "Betrete Raum %s\r\n",
+		"only %s can use %s\r\n":         "%[2]s ist nur für %[1]s\r\n",
+		"100%% done\r\n":                 "100%% fertig\r\n",
+		"Command not recognized\r\n":     "Befehl nicht erkannt\r\n",
+		"[%s] is %d%% sure\r\n":          "[%s] ist sich zu %d%% sicher\r\n",
+		"Nick changed from [%s] to [%s]": "Nick von [%s] zu [%s] geändert",
+	}.compile()
+
+	for text, want := range map[string]string{
+		"Joining room wayne manor\r\n":        "Betrete Raum wayne manor\r\n",
+		"only admins can use /drain\r\n":      "/drain ist nur für admins\r\n",
+		"100% done\r\n":                       "100% fertig\r\n",
+		"Command not recognized\r\n":          "Befehl nicht erkannt\r\n",
+		"[robin] is 50% sure\r\n":             "[robin] ist sich zu 50% sicher\r\n",
+		"Nick changed from [a] to [b]":        "Nick von [a] zu [b] geändert",
+		"[robin] isn't in the catalog\r\n":    "[robin] isn't in the catalog\r\n",
+		"Joining room wayne manor\r\nextra\n": "Joining room wayne manor\r\nextra\n",
+	} {
+		if got := c.translate(text); got != want {
+			t.Errorf("expected [%q], got [%q]", want, got)
+		}
+	}
+}
+
+func TestLang(t *testing.T) {
+	defer configureLocales(nil, "")
+
+	dir, err := ioutil.TempDir("", "locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "es.json"), []byte(`{"Joining room %s\r\n": "Entrando en la sala %s\r\n"}`), 0644)
+	catalogs, err := loadCatalogs(dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := configureLocales(catalogs, "fr"); err == nil {
+		t.Errorf("expected an unknown default language to be refused")
+	}
+	configureLocales(catalogs, "")
+	if langs := strings.Join(Languages(), " "); langs != "de en es" {
+		t.Errorf("expected de en es, got [%s]", langs)
+	}
+
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	if err := serv.SetLang(batman, "klingon"); err == nil {
+		t.Errorf("expected an unknown language to be refused")
+	}
+
+	serv.SetLang(batman, "ES")
+	batman.Write("Joining room gotham\r\n")
+	if got := batman.unread[len(batman.unread)-1].Text; got != "Entrando en la sala gotham\r\n" {
+		t.Errorf("expected the reply translated, got [%s]", got)
+	}
+	serv.SetLang(batman, "de")
+	batman.Write("Command not recognized\r\n")
+	if got := batman.unread[len(batman.unread)-1].Text; got != "Befehl nicht erkannt\r\n" {
+		t.Errorf("expected the built in German, got [%s]", got)
+	}
+	if !strings.Contains(serv.Banner("batman", "de"), "Du bist [batman], willkommen bei TinyChat.") {
+		t.Errorf("expected the banner translated")
+	}
+
+	// what people say is never translated
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("gotham", robin)
+	serv.Message([]string{"Command", "not", "recognized"}, robin)
+	if got := batman.unread[len(batman.unread)-1].Text; got != "Command not recognized" {
+		t.Errorf("expected messages left alone, got [%s]", got)
+	}
+
+	// the server's default applies until a language is picked
+	configureLocales(catalogs, "de")
+	robin.Write("Command not recognized\r\n")
+	if got := robin.unread[len(robin.unread)-1].Text; got != "Befehl nicht erkannt\r\n" {
+		t.Errorf("expected the default language, got [%s]", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// logPageSize is the number of messages on a page of the log viewer
+const logPageSize = 50
+
+var logTemplate = template.Must(template.New("log").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - TinyChat</title>
+{{if .Feed}}<link rel="alternate" type="application/atom+xml" href="{{.Feed}}">{{end}}
+<style>
+body { font-family: monospace; max-width: 60em; margin: 2em auto; }
+.msg:target { background: #ffc; }
+.time a { color: #888; text-decoration: none; }
+.service { font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Rooms}}<ul>{{range .Rooms}}<li><a href="{{.URL}}">{{.Name}}</a></li>{{end}}</ul>{{end}}
+{{if .Feed}}<p><a href="/rooms/">all rooms</a> | <a href="{{.Feed}}">feed</a></p>{{end}}
+{{if .Older}}<p><a href="?before={{.Older}}">older</a></p>{{end}}
+{{range .Messages}}<div class="msg {{.Type}}" id="m{{.ID}}"><span class="time"><a href="?at={{.ID}}#m{{.ID}}">[{{.Time}}]</a></span> &lt;{{.From}}&gt; {{.Text}}</div>
+{{end}}
+{{if .Newer}}<p><a href="?after={{.Newer}}">newer</a></p>{{end}}
+</body>
+</html>
+`))
+
+type logRoom struct {
+	Name string
+	URL  string
+}
+
+type logPage struct {
+	Title    string
+	Feed     string
+	Rooms    []logRoom
+	Messages []Event
+	Older    int64
+	Newer    int64
+}
+
+// roomURL returns the log viewer path of a room
+func roomURL(roomname string) string {
+	return "/rooms/" + url.PathEscape(roomname) + "/"
+}
+
+// messageURL returns the log viewer link to a single message
+func messageURL(roomname string, id int64) string {
+	return fmt.Sprintf("%s?at=%d#m%d", roomURL(roomname), id, id)
+}
+
+// serveRooms lists the public rooms
+func (s *Server) serveRooms(w http.ResponseWriter, r *http.Request) {
+	page := logPage{Title: "Public rooms"}
+	for _, name := range s.PublicRooms() {
+		page.Rooms = append(page.Rooms, logRoom{Name: name, URL: roomURL(name)})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := logTemplate.Execute(w, page); err != nil {
+		errl(err, "")
+	}
+}
+
+// serveLog renders a page of a public room's history, by default the
+// newest, ?before and ?after page through it and ?at shows the page
+// holding a message
+func (s *Server) serveLog(w http.ResponseWriter, r *http.Request, roomname string) {
+	history, ok := s.PublicHistory(roomname)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var msgs []Event
+	for _, ev := range history {
+		if ev.Type == EventMessage || ev.Type == EventService || ev.Type == EventNotice {
+			msgs = append(msgs, ev)
+		}
+	}
+
+	q := r.URL.Query()
+	before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+	after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+	at, _ := strconv.ParseInt(q.Get("at"), 10, 64)
+
+	start, end := paginate(msgs, before, after, at, logPageSize)
+	page := logPage{
+		Title:    roomname,
+		Feed:     roomURL(roomname) + "feed.atom",
+		Messages: msgs[start:end],
+	}
+	if start > 0 {
+		page.Older = msgs[start].ID
+	}
+	if end < len(msgs) {
+		page.Newer = msgs[end-1].ID
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := logTemplate.Execute(w, page); err != nil {
+		errl(err, "")
+	}
+}
+
+// paginate returns the bounds of the page of msgs, which are sorted by ID,
+// selected by the before, after, or at message IDs
+func paginate(msgs []Event, before, after, at int64, size int) (int, int) {
+	// index of the first message with an ID of at least id
+	find := func(id int64) int {
+		for i, ev := range msgs {
+			if ev.ID >= id {
+				return i
+			}
+		}
+		return len(msgs)
+	}
+
+	end := len(msgs)
+	switch {
+	case at > 0:
+		// center the page on the message
+		end = find(at) + size/2
+	case before > 0:
+		end = find(before)
+	case after > 0:
+		end = find(after+1) + size
+	}
+	if end > len(msgs) {
+		end = len(msgs)
+	}
+
+	start := end - size
+	if start < 0 {
+		start = 0
+		if end < size {
+			end = size
+		}
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	var msgs []Event
+	for i := 1; i <= 120; i++ {
+		msgs = append(msgs, Event{ID: int64(i)})
+	}
+
+	cases := []struct {
+		before, after, at int64
+		start, end        int
+	}{
+		{0, 0, 0, 70, 120},
+		{71, 0, 0, 20, 70},
+		{21, 0, 0, 0, 50},
+		{0, 20, 0, 20, 70},
+		{0, 0, 60, 34, 84},
+		{0, 0, 2, 0, 50},
+	}
+
+	for _, c := range cases {
+		start, end := paginate(msgs, c.before, c.after, c.at, 50)
+		if start != c.start || end != c.end {
+			t.Errorf("before=%d after=%d at=%d: expected [%d:%d], got [%d:%d]", c.before, c.after, c.at, c.start, c.end, start, end)
+		}
+	}
+}
+
+func TestLogViewer(t *testing.T) {
+	serv := NewServer()
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.Message([]string{"the", "batcave", "is", "under", "the", "manor"}, batman)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/rooms/gotham/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected private room to be hidden, got %d", w.Code)
+	}
+
+	serv.SetPublic(batman, true)
+	serv.Message([]string{"<b>hi</b>", "freeze"}, batman)
+
+	w := get("/rooms/gotham/?at=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected log page, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, `id="m2"`) || !strings.Contains(body, "&lt;b&gt;hi&lt;/b&gt; freeze") {
+		t.Errorf("expected escaped, linkable message, got [%s]", body)
+	}
+	if strings.Contains(body, "batcave") {
+		t.Errorf("expected messages from before the room went public kept private")
+	}
+
+	if w := get("/rooms/"); !strings.Contains(w.Body.String(), `href="/rooms/gotham/"`) {
+		t.Errorf("expected room to be listed, got [%s]", w.Body.String())
+	}
+}
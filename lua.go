@@ -0,0 +1,1568 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This is a small interpreter for the subset of Lua 5.1 scripts need:
+// locals, closures, tables, if, while, repeat, numeric and generic for,
+// and the string, table, and math functions that don't touch the host.
+// Scripts can't reach files, processes, or the network, and every call
+// is bounded by luaMaxSteps and luaMaxDepth, no string a script builds
+// may grow past luaMaxString. The string library matches Lua patterns.
+
+// luaMaxSteps is how many statements and loop iterations a single call
+// into a script may run
+const luaMaxSteps = 1000000
+
+// luaMaxDepth is how deeply script functions may recurse
+const luaMaxDepth = 200
+
+// luaMaxString is the longest string a script may build
+const luaMaxString = 1 << 20
+
+// errLuaStringTooLarge is returned when a script builds a string past
+// luaMaxString
+var errLuaStringTooLarge = errors.New("resulting string too large")
+
+// errLuaTooLong stops a script that used up its steps, pcall can't catch it
+var errLuaTooLong = errors.New("script ran too long")
+
+// lua token kinds
+const (
+	luaEOF = iota
+	luaNameTok
+	luaNumberTok
+	luaStringTok
+	luaOpTok
+)
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+type luaToken struct {
+	kind int
+	s    string
+	n    float64
+	line int
+}
+
+// luaLex splits source into tokens, keywords are returned as operators
+func luaLex(src string) ([]luaToken, error) {
+	var out []luaToken
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			i += 2
+			if n := luaLongBracket(src[i:]); n >= 0 {
+				end := strings.Index(src[i:], "]"+strings.Repeat("=", n)+"]")
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unfinished comment", line)
+				}
+				line += strings.Count(src[i:i+end], "\n")
+				i += end + n + 2
+				continue
+			}
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			word := src[i:j]
+			if luaKeywords[word] {
+				out = append(out, luaToken{kind: luaOpTok, s: word, line: line})
+			} else {
+				out = append(out, luaToken{kind: luaNameTok, s: word, line: line})
+			}
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				j += 2
+				for j < len(src) && strings.IndexByte("0123456789abcdefABCDEF", src[j]) >= 0 {
+					j++
+				}
+				n, err := strconv.ParseInt(src[i+2:j], 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: malformed number", line)
+				}
+				out = append(out, luaToken{kind: luaNumberTok, n: float64(n), line: line})
+				i = j
+				continue
+			}
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed number", line)
+			}
+			out = append(out, luaToken{kind: luaNumberTok, n: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(src) || src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unfinished string", line)
+				}
+				if src[j] == c {
+					break
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case 'r':
+						b.WriteByte('\r')
+					default:
+						b.WriteByte(src[j])
+					}
+				} else {
+					b.WriteByte(src[j])
+				}
+				j++
+			}
+			out = append(out, luaToken{kind: luaStringTok, s: b.String(), line: line})
+			i = j + 1
+		case c == '[' && luaLongBracket(src[i:]) >= 0:
+			n := luaLongBracket(src[i:])
+			start := i + n + 2
+			end := strings.Index(src[start:], "]"+strings.Repeat("=", n)+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unfinished string", line)
+			}
+			s := strings.TrimPrefix(src[start:start+end], "\n")
+			out = append(out, luaToken{kind: luaStringTok, s: s, line: line})
+			line += strings.Count(src[start:start+end], "\n")
+			i = start + end + n + 2
+		default:
+			op := ""
+			for _, o := range []string{"...", "..", "==", "~=", "<=", ">="} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if strings.IndexByte("+-*/%^#<>=(){}[];:,.", c) < 0 {
+					return nil, fmt.Errorf("line %d: unexpected symbol %q", line, c)
+				}
+				op = string(c)
+			}
+			out = append(out, luaToken{kind: luaOpTok, s: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(out, luaToken{kind: luaEOF, line: line}), nil
+}
+
+// luaLongBracket returns the level of the long bracket [[ or [==[ at the
+// start of s, or -1
+func luaLongBracket(s string) int {
+	if len(s) < 2 || s[0] != '[' {
+		return -1
+	}
+	n := 1
+	for n < len(s) && s[n] == '=' {
+		n++
+	}
+	if n < len(s) && s[n] == '[' {
+		return n - 1
+	}
+	return -1
+}
+
+// expressions
+type (
+	luaConst    struct{ v interface{} }
+	luaNameExpr struct{ name string }
+	luaIndex    struct{ obj, key luaExpr }
+	luaCall     struct {
+		fn     luaExpr
+		method string
+		args   []luaExpr
+		line   int
+	}
+	luaFuncExpr struct {
+		params []string
+		body   []luaStmt
+	}
+	luaBinop struct {
+		op   string
+		a, b luaExpr
+		line int
+	}
+	luaUnop struct {
+		op   string
+		a    luaExpr
+		line int
+	}
+	luaTableExpr struct {
+		keys []luaExpr
+		vals []luaExpr
+	}
+	luaParen struct{ e luaExpr }
+)
+
+type luaExpr interface{}
+
+// statements
+type (
+	luaLocal struct {
+		names []string
+		exprs []luaExpr
+	}
+	luaAssign struct {
+		targets []luaExpr
+		exprs   []luaExpr
+	}
+	luaCallStmt struct{ call *luaCall }
+	luaDo       struct{ body []luaStmt }
+	luaWhile    struct {
+		cond luaExpr
+		body []luaStmt
+	}
+	luaRepeat struct {
+		body []luaStmt
+		cond luaExpr
+	}
+	luaIf struct {
+		conds  []luaExpr
+		blocks [][]luaStmt
+		els    []luaStmt
+	}
+	luaNumFor struct {
+		name              string
+		start, stop, step luaExpr
+		body              []luaStmt
+	}
+	luaGenFor struct {
+		names []string
+		exprs []luaExpr
+		body  []luaStmt
+	}
+	luaLocalFunc struct {
+		name string
+		fn   *luaFuncExpr
+	}
+	luaReturn struct{ exprs []luaExpr }
+	luaBreak  struct{}
+)
+
+type luaStmt interface{}
+
+type luaParser struct {
+	toks []luaToken
+	pos  int
+}
+
+// luaParse parses a chunk
+func luaParse(src string) ([]luaStmt, error) {
+	toks, err := luaLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &luaParser{toks: toks}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != luaEOF {
+		return nil, p.errorf("unexpected %s", p.describe())
+	}
+	return body, nil
+}
+
+func (p *luaParser) peek() luaToken {
+	return p.toks[p.pos]
+}
+
+func (p *luaParser) next() luaToken {
+	t := p.toks[p.pos]
+	if t.kind != luaEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *luaParser) is(op string) bool {
+	t := p.peek()
+	return t.kind == luaOpTok && t.s == op
+}
+
+func (p *luaParser) accept(op string) bool {
+	if p.is(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf("'%s' expected near %s", op, p.describe())
+	}
+	return nil
+}
+
+func (p *luaParser) name() (string, error) {
+	t := p.peek()
+	if t.kind != luaNameTok {
+		return "", p.errorf("name expected near %s", p.describe())
+	}
+	p.pos++
+	return t.s, nil
+}
+
+func (p *luaParser) describe() string {
+	t := p.peek()
+	switch t.kind {
+	case luaEOF:
+		return "end of script"
+	case luaNumberTok:
+		return luaToString(t.n)
+	case luaStringTok:
+		return strconv.Quote(t.s)
+	}
+	return "'" + t.s + "'"
+}
+
+func (p *luaParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// blockEnd returns true at a token that ends a block
+func (p *luaParser) blockEnd() bool {
+	t := p.peek()
+	if t.kind == luaEOF {
+		return true
+	}
+	return t.kind == luaOpTok && (t.s == "end" || t.s == "else" || t.s == "elseif" || t.s == "until")
+}
+
+func (p *luaParser) block() ([]luaStmt, error) {
+	var out []luaStmt
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.accept("return") {
+			var exprs []luaExpr
+			if !p.blockEnd() && !p.is(";") {
+				var err error
+				if exprs, err = p.exprList(); err != nil {
+					return nil, err
+				}
+			}
+			p.accept(";")
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected near %s", p.describe())
+			}
+			return append(out, &luaReturn{exprs}), nil
+		}
+		st, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (p *luaParser) statement() (luaStmt, error) {
+	switch {
+	case p.accept("break"):
+		return &luaBreak{}, nil
+	case p.accept("do"):
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &luaDo{body}, p.expect("end")
+	case p.accept("while"):
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &luaWhile{cond, body}, p.expect("end")
+	case p.accept("repeat"):
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("until"); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		return &luaRepeat{body, cond}, err
+	case p.accept("if"):
+		return p.ifStatement()
+	case p.accept("for"):
+		return p.forStatement()
+	case p.accept("function"):
+		return p.funcStatement()
+	case p.accept("local"):
+		if p.accept("function") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := p.funcBody(false)
+			return &luaLocalFunc{name, fn}, err
+		}
+		var names []string
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+		var exprs []luaExpr
+		if p.accept("=") {
+			var err error
+			if exprs, err = p.exprList(); err != nil {
+				return nil, err
+			}
+		}
+		return &luaLocal{names, exprs}, nil
+	}
+
+	e, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if call, ok := e.(*luaCall); ok && !p.is("=") && !p.is(",") {
+		return &luaCallStmt{call}, nil
+	}
+
+	targets := []luaExpr{e}
+	for p.accept(",") {
+		t, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	for _, t := range targets {
+		switch t.(type) {
+		case *luaNameExpr, *luaIndex:
+		default:
+			return nil, p.errorf("syntax error near %s", p.describe())
+		}
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	return &luaAssign{targets, exprs}, err
+}
+
+func (p *luaParser) ifStatement() (luaStmt, error) {
+	st := &luaIf{}
+	for {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		st.conds = append(st.conds, cond)
+		st.blocks = append(st.blocks, body)
+		if !p.accept("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		st.els = body
+	}
+	return st, p.expect("end")
+}
+
+func (p *luaParser) forStatement() (luaStmt, error) {
+	first, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("=") {
+		st := &luaNumFor{name: first}
+		if st.start, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if st.stop, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			if st.step, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("do"); err != nil {
+			return nil, err
+		}
+		if st.body, err = p.block(); err != nil {
+			return nil, err
+		}
+		return st, p.expect("end")
+	}
+
+	st := &luaGenFor{names: []string{first}}
+	for p.accept(",") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		st.names = append(st.names, name)
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if st.exprs, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	if st.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return st, p.expect("end")
+}
+
+// funcStatement parses function a.b.c:m() ... end as an assignment
+func (p *luaParser) funcStatement() (luaStmt, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	var target luaExpr = &luaNameExpr{name}
+	method := false
+	for p.is(".") || p.is(":") {
+		method = p.next().s == ":"
+		key, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		target = &luaIndex{target, &luaConst{key}}
+		if method {
+			break
+		}
+	}
+	fn, err := p.funcBody(method)
+	if err != nil {
+		return nil, err
+	}
+	return &luaAssign{[]luaExpr{target}, []luaExpr{fn}}, nil
+}
+
+func (p *luaParser) funcBody(method bool) (*luaFuncExpr, error) {
+	fn := &luaFuncExpr{}
+	if method {
+		fn.params = append(fn.params, "self")
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fn.params = append(fn.params, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = body
+	return fn, p.expect("end")
+}
+
+func (p *luaParser) exprList() ([]luaExpr, error) {
+	var out []luaExpr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+		if !p.accept(",") {
+			return out, nil
+		}
+	}
+}
+
+// luaPriority holds the left and right priorities of the binary operators
+var luaPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8}, "+": {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+const luaUnaryPriority = 12
+
+func (p *luaParser) expr() (luaExpr, error) {
+	return p.subExpr(0)
+}
+
+func (p *luaParser) subExpr(limit int) (luaExpr, error) {
+	var e luaExpr
+	var err error
+	if t := p.peek(); t.kind == luaOpTok && (t.s == "not" || t.s == "-" || t.s == "#") {
+		p.pos++
+		a, err := p.subExpr(luaUnaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		e = &luaUnop{t.s, a, t.line}
+	} else if e, err = p.simpleExpr(); err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prio, ok := luaPriority[t.s]
+		if t.kind != luaOpTok || !ok || prio[0] <= limit {
+			return e, nil
+		}
+		p.pos++
+		b, err := p.subExpr(prio[1])
+		if err != nil {
+			return nil, err
+		}
+		e = &luaBinop{t.s, e, b, t.line}
+	}
+}
+
+func (p *luaParser) simpleExpr() (luaExpr, error) {
+	t := p.peek()
+	switch t.kind {
+	case luaNumberTok:
+		p.pos++
+		return &luaConst{t.n}, nil
+	case luaStringTok:
+		p.pos++
+		return &luaConst{t.s}, nil
+	}
+	switch {
+	case p.accept("nil"):
+		return &luaConst{nil}, nil
+	case p.accept("true"):
+		return &luaConst{true}, nil
+	case p.accept("false"):
+		return &luaConst{false}, nil
+	case p.accept("function"):
+		return p.funcBody(false)
+	case p.is("{"):
+		return p.table()
+	}
+	return p.suffixedExpr()
+}
+
+func (p *luaParser) table() (luaExpr, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	te := &luaTableExpr{}
+	for !p.is("}") {
+		var key luaExpr
+		switch {
+		case p.accept("["):
+			k, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			key = k
+		case p.peek().kind == luaNameTok && p.toks[p.pos+1].kind == luaOpTok && p.toks[p.pos+1].s == "=":
+			key = &luaConst{p.next().s}
+			p.pos++
+		}
+		v, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		te.keys = append(te.keys, key)
+		te.vals = append(te.vals, v)
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	return te, p.expect("}")
+}
+
+func (p *luaParser) primaryExpr() (luaExpr, error) {
+	if p.accept("(") {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &luaParen{e}, p.expect(")")
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, p.errorf("unexpected %s", p.describe())
+	}
+	return &luaNameExpr{name}, nil
+}
+
+func (p *luaParser) suffixedExpr() (luaExpr, error) {
+	e, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		line := p.peek().line
+		switch {
+		case p.accept("."):
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			e = &luaIndex{e, &luaConst{key}}
+		case p.accept("["):
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &luaIndex{e, key}
+		case p.accept(":"):
+			method, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &luaCall{e, method, args, line}
+		case p.is("(") || p.is("{") || p.peek().kind == luaStringTok:
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &luaCall{e, "", args, line}
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *luaParser) callArgs() ([]luaExpr, error) {
+	if t := p.peek(); t.kind == luaStringTok {
+		p.pos++
+		return []luaExpr{&luaConst{t.s}}, nil
+	}
+	if p.is("{") {
+		te, err := p.table()
+		return []luaExpr{te}, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+// luaTable is a Lua table, pairs visits keys in insertion order
+type luaTable struct {
+	hash map[interface{}]interface{}
+	keys []interface{}
+}
+
+func newLuaTable() *luaTable {
+	return &luaTable{hash: make(map[interface{}]interface{})}
+}
+
+func (t *luaTable) Get(k interface{}) interface{} {
+	return t.hash[k]
+}
+
+func (t *luaTable) Set(k, v interface{}) {
+	if v == nil {
+		if _, ok := t.hash[k]; ok {
+			delete(t.hash, k)
+			for i, key := range t.keys {
+				if key == k {
+					t.keys = append(t.keys[:i], t.keys[i+1:]...)
+					break
+				}
+			}
+		}
+		return
+	}
+	if _, ok := t.hash[k]; !ok {
+		t.keys = append(t.keys, k)
+	}
+	t.hash[k] = v
+}
+
+// Len returns the border of the array part
+func (t *luaTable) Len() int {
+	n := 0
+	for t.hash[float64(n+1)] != nil {
+		n++
+	}
+	return n
+}
+
+// luaFunction is a closure defined by a script
+type luaFunction struct {
+	fn    *luaFuncExpr
+	scope *luaScope
+}
+
+// luaBuiltin is a function provided by the host
+type luaBuiltin struct {
+	name string
+	fn   func(L *luaState, args []interface{}) ([]interface{}, error)
+}
+
+type luaScope struct {
+	vars   map[string]*interface{}
+	parent *luaScope
+}
+
+func (sc *luaScope) lookup(name string) *interface{} {
+	for ; sc != nil; sc = sc.parent {
+		if v, ok := sc.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (sc *luaScope) declare(name string, v interface{}) {
+	sc.vars[name] = &v
+}
+
+func newLuaScope(parent *luaScope) *luaScope {
+	return &luaScope{vars: make(map[string]*interface{}), parent: parent}
+}
+
+// luaError is an error raised by a script
+type luaError struct {
+	value interface{}
+}
+
+func (e *luaError) Error() string {
+	return luaToString(e.value)
+}
+
+// control flow out of a block
+const (
+	luaNormal = iota
+	luaBreakOut
+	luaReturnOut
+)
+
+// luaState is an interpreter with its own globals, it isn't safe for
+// concurrent use
+type luaState struct {
+	Globals *luaTable
+	strlib  *luaTable
+	steps   int
+	depth   int
+}
+
+// newLuaState returns an interpreter with the sandboxed standard library
+func newLuaState() *luaState {
+	L := &luaState{Globals: newLuaTable()}
+	L.openLibs()
+	return L
+}
+
+// Register sets a global host function
+func (L *luaState) Register(name string, fn func(L *luaState, args []interface{}) ([]interface{}, error)) {
+	L.Globals.Set(name, &luaBuiltin{name, fn})
+}
+
+// DoString runs a chunk of source
+func (L *luaState) DoString(src string) error {
+	body, err := luaParse(src)
+	if err != nil {
+		return err
+	}
+	L.steps = 0
+	_, err = L.Call(&luaFunction{fn: &luaFuncExpr{body: body}}, nil)
+	return err
+}
+
+// Call calls a function value with the arguments, resetting the step budget
+// when called from the host, a panic in the script is returned as an error
+func (L *luaState) Call(fn interface{}, args []interface{}) (vals []interface{}, err error) {
+	if L.depth == 0 {
+		L.steps = 0
+		depth := L.depth
+		defer func() {
+			if p := recover(); p != nil {
+				L.depth = depth
+				vals, err = nil, fmt.Errorf("script failed: %v", p)
+			}
+		}()
+	}
+	return L.call(fn, args, 0)
+}
+
+func (L *luaState) call(fn interface{}, args []interface{}, line int) ([]interface{}, error) {
+	switch f := fn.(type) {
+	case *luaBuiltin:
+		return f.fn(L, args)
+	case *luaFunction:
+		if L.depth >= luaMaxDepth {
+			return nil, fmt.Errorf("line %d: stack overflow", line)
+		}
+		L.depth++
+		defer func() { L.depth-- }()
+
+		sc := newLuaScope(f.scope)
+		for i, p := range f.fn.params {
+			var v interface{}
+			if i < len(args) {
+				v = args[i]
+			}
+			sc.declare(p, v)
+		}
+		ctl, vals, err := L.execBlock(f.fn.body, sc)
+		if err != nil {
+			return nil, err
+		}
+		if ctl == luaReturnOut {
+			return vals, nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("line %d: attempt to call a %s value", line, luaType(fn))
+}
+
+func (L *luaState) step() error {
+	L.steps++
+	if L.steps > luaMaxSteps {
+		return errLuaTooLong
+	}
+	return nil
+}
+
+func (L *luaState) execBlock(body []luaStmt, sc *luaScope) (int, []interface{}, error) {
+	for _, st := range body {
+		ctl, vals, err := L.exec(st, sc)
+		if err != nil || ctl != luaNormal {
+			return ctl, vals, err
+		}
+	}
+	return luaNormal, nil, nil
+}
+
+func (L *luaState) exec(st luaStmt, sc *luaScope) (int, []interface{}, error) {
+	if err := L.step(); err != nil {
+		return 0, nil, err
+	}
+
+	switch st := st.(type) {
+	case *luaLocal:
+		vals, err := L.evalList(st.exprs, sc)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, name := range st.names {
+			var v interface{}
+			if i < len(vals) {
+				v = vals[i]
+			}
+			sc.declare(name, v)
+		}
+	case *luaLocalFunc:
+		sc.declare(st.name, nil)
+		*sc.lookup(st.name) = &luaFunction{st.fn, sc}
+	case *luaAssign:
+		vals, err := L.evalList(st.exprs, sc)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, t := range st.targets {
+			var v interface{}
+			if i < len(vals) {
+				v = vals[i]
+			}
+			if err := L.assign(t, v, sc); err != nil {
+				return 0, nil, err
+			}
+		}
+	case *luaCallStmt:
+		if _, err := L.evalCall(st.call, sc); err != nil {
+			return 0, nil, err
+		}
+	case *luaDo:
+		return L.execBlock(st.body, newLuaScope(sc))
+	case *luaWhile:
+		for {
+			if err := L.step(); err != nil {
+				return 0, nil, err
+			}
+			cond, err := L.eval(st.cond, sc)
+			if err != nil {
+				return 0, nil, err
+			}
+			if !luaTruthy(cond) {
+				break
+			}
+			ctl, vals, err := L.execBlock(st.body, newLuaScope(sc))
+			if err != nil || ctl == luaReturnOut {
+				return ctl, vals, err
+			}
+			if ctl == luaBreakOut {
+				break
+			}
+		}
+	case *luaRepeat:
+		for {
+			if err := L.step(); err != nil {
+				return 0, nil, err
+			}
+			inner := newLuaScope(sc)
+			ctl, vals, err := L.execBlock(st.body, inner)
+			if err != nil || ctl == luaReturnOut {
+				return ctl, vals, err
+			}
+			if ctl == luaBreakOut {
+				break
+			}
+			cond, err := L.eval(st.cond, inner)
+			if err != nil {
+				return 0, nil, err
+			}
+			if luaTruthy(cond) {
+				break
+			}
+		}
+	case *luaIf:
+		for i, c := range st.conds {
+			cond, err := L.eval(c, sc)
+			if err != nil {
+				return 0, nil, err
+			}
+			if luaTruthy(cond) {
+				return L.execBlock(st.blocks[i], newLuaScope(sc))
+			}
+		}
+		if st.els != nil {
+			return L.execBlock(st.els, newLuaScope(sc))
+		}
+	case *luaNumFor:
+		return L.numFor(st, sc)
+	case *luaGenFor:
+		return L.genFor(st, sc)
+	case *luaReturn:
+		vals, err := L.evalList(st.exprs, sc)
+		return luaReturnOut, vals, err
+	case *luaBreak:
+		return luaBreakOut, nil, nil
+	}
+	return luaNormal, nil, nil
+}
+
+func (L *luaState) numFor(st *luaNumFor, sc *luaScope) (int, []interface{}, error) {
+	var bounds [3]float64
+	bounds[2] = 1
+	for i, e := range []luaExpr{st.start, st.stop, st.step} {
+		if e == nil {
+			continue
+		}
+		v, err := L.eval(e, sc)
+		if err != nil {
+			return 0, nil, err
+		}
+		n, ok := luaToNumber(v)
+		if !ok {
+			return 0, nil, fmt.Errorf("'for' limit must be a number")
+		}
+		bounds[i] = n
+	}
+	if bounds[2] == 0 {
+		return 0, nil, fmt.Errorf("'for' step is zero")
+	}
+
+	for i := bounds[0]; bounds[2] > 0 && i <= bounds[1] || bounds[2] < 0 && i >= bounds[1]; i += bounds[2] {
+		if err := L.step(); err != nil {
+			return 0, nil, err
+		}
+		inner := newLuaScope(sc)
+		inner.declare(st.name, i)
+		ctl, vals, err := L.execBlock(st.body, inner)
+		if err != nil || ctl == luaReturnOut {
+			return ctl, vals, err
+		}
+		if ctl == luaBreakOut {
+			break
+		}
+	}
+	return luaNormal, nil, nil
+}
+
+func (L *luaState) genFor(st *luaGenFor, sc *luaScope) (int, []interface{}, error) {
+	init, err := L.evalList(st.exprs, sc)
+	if err != nil {
+		return 0, nil, err
+	}
+	init = append(init, nil, nil, nil)
+	fn, state, control := init[0], init[1], init[2]
+
+	for {
+		if err := L.step(); err != nil {
+			return 0, nil, err
+		}
+		vals, err := L.call(fn, []interface{}{state, control}, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(vals) == 0 || vals[0] == nil {
+			break
+		}
+		control = vals[0]
+
+		inner := newLuaScope(sc)
+		for i, name := range st.names {
+			var v interface{}
+			if i < len(vals) {
+				v = vals[i]
+			}
+			inner.declare(name, v)
+		}
+		ctl, rets, err := L.execBlock(st.body, inner)
+		if err != nil || ctl == luaReturnOut {
+			return ctl, rets, err
+		}
+		if ctl == luaBreakOut {
+			break
+		}
+	}
+	return luaNormal, nil, nil
+}
+
+func (L *luaState) assign(target luaExpr, v interface{}, sc *luaScope) error {
+	switch t := target.(type) {
+	case *luaNameExpr:
+		if ref := sc.lookup(t.name); ref != nil {
+			*ref = v
+		} else {
+			L.Globals.Set(t.name, v)
+		}
+		return nil
+	case *luaIndex:
+		obj, err := L.eval(t.obj, sc)
+		if err != nil {
+			return err
+		}
+		key, err := L.eval(t.key, sc)
+		if err != nil {
+			return err
+		}
+		tbl, ok := obj.(*luaTable)
+		if !ok {
+			return fmt.Errorf("attempt to index a %s value", luaType(obj))
+		}
+		if key == nil {
+			return fmt.Errorf("table index is nil")
+		}
+		tbl.Set(key, v)
+		return nil
+	}
+	return fmt.Errorf("cannot assign")
+}
+
+// evalList evaluates expressions, a call in last position contributes all
+// of its results
+func (L *luaState) evalList(exprs []luaExpr, sc *luaScope) ([]interface{}, error) {
+	var out []interface{}
+	for i, e := range exprs {
+		if call, ok := e.(*luaCall); ok && i == len(exprs)-1 {
+			vals, err := L.evalCall(call, sc)
+			if err != nil {
+				return nil, err
+			}
+			return append(out, vals...), nil
+		}
+		v, err := L.eval(e, sc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (L *luaState) evalCall(c *luaCall, sc *luaScope) ([]interface{}, error) {
+	fn, err := L.eval(c.fn, sc)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	if c.method != "" {
+		self := fn
+		if fn, err = L.index(self, c.method); err != nil {
+			return nil, fmt.Errorf("line %d: %v", c.line, err)
+		}
+		args = append(args, self)
+	}
+	rest, err := L.evalList(c.args, sc)
+	if err != nil {
+		return nil, err
+	}
+	return L.call(fn, append(args, rest...), c.line)
+}
+
+func (L *luaState) index(obj, key interface{}) (interface{}, error) {
+	switch o := obj.(type) {
+	case *luaTable:
+		return o.Get(key), nil
+	case string:
+		return L.strlib.Get(key), nil
+	}
+	return nil, fmt.Errorf("attempt to index a %s value", luaType(obj))
+}
+
+func (L *luaState) eval(e luaExpr, sc *luaScope) (interface{}, error) {
+	switch e := e.(type) {
+	case *luaConst:
+		return e.v, nil
+	case *luaNameExpr:
+		if ref := sc.lookup(e.name); ref != nil {
+			return *ref, nil
+		}
+		return L.Globals.Get(e.name), nil
+	case *luaParen:
+		return L.eval(e.e, sc)
+	case *luaIndex:
+		obj, err := L.eval(e.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		key, err := L.eval(e.key, sc)
+		if err != nil {
+			return nil, err
+		}
+		return L.index(obj, key)
+	case *luaCall:
+		vals, err := L.evalCall(e, sc)
+		if err != nil || len(vals) == 0 {
+			return nil, err
+		}
+		return vals[0], nil
+	case *luaFuncExpr:
+		return &luaFunction{e, sc}, nil
+	case *luaTableExpr:
+		t := newLuaTable()
+		n := 0
+		for i, k := range e.keys {
+			if k == nil && i == len(e.keys)-1 {
+				if call, ok := e.vals[i].(*luaCall); ok {
+					vals, err := L.evalCall(call, sc)
+					if err != nil {
+						return nil, err
+					}
+					for _, v := range vals {
+						n++
+						t.Set(float64(n), v)
+					}
+					continue
+				}
+			}
+			v, err := L.eval(e.vals[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			if k == nil {
+				n++
+				t.Set(float64(n), v)
+				continue
+			}
+			key, err := L.eval(k, sc)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return nil, fmt.Errorf("table index is nil")
+			}
+			t.Set(key, v)
+		}
+		return t, nil
+	case *luaUnop:
+		a, err := L.eval(e.a, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "not":
+			return !luaTruthy(a), nil
+		case "-":
+			n, ok := luaToNumber(a)
+			if !ok {
+				return nil, fmt.Errorf("line %d: attempt to perform arithmetic on a %s value", e.line, luaType(a))
+			}
+			return -n, nil
+		case "#":
+			switch a := a.(type) {
+			case string:
+				return float64(len(a)), nil
+			case *luaTable:
+				return float64(a.Len()), nil
+			}
+			return nil, fmt.Errorf("line %d: attempt to get length of a %s value", e.line, luaType(a))
+		}
+	case *luaBinop:
+		return L.binop(e, sc)
+	}
+	return nil, fmt.Errorf("cannot evaluate expression")
+}
+
+func (L *luaState) binop(e *luaBinop, sc *luaScope) (interface{}, error) {
+	a, err := L.eval(e.a, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !luaTruthy(a) {
+			return a, nil
+		}
+		return L.eval(e.b, sc)
+	case "or":
+		if luaTruthy(a) {
+			return a, nil
+		}
+		return L.eval(e.b, sc)
+	}
+
+	b, err := L.eval(e.b, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return luaEqual(a, b), nil
+	case "~=":
+		return !luaEqual(a, b), nil
+	case "..":
+		as, aok := luaConcatable(a)
+		bs, bok := luaConcatable(b)
+		if !aok || !bok {
+			bad := a
+			if aok {
+				bad = b
+			}
+			return nil, fmt.Errorf("line %d: attempt to concatenate a %s value", e.line, luaType(bad))
+		}
+		if len(as)+len(bs) > luaMaxString {
+			return nil, fmt.Errorf("line %d: %v", e.line, errLuaStringTooLarge)
+		}
+		return as + bs, nil
+	case "<", "<=", ">", ">=":
+		if e.op == ">" || e.op == ">=" {
+			a, b = b, a
+		}
+		less, eq, err := luaCompare(a, b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", e.line, err)
+		}
+		if e.op == "<" || e.op == ">" {
+			return less, nil
+		}
+		return less || eq, nil
+	}
+
+	x, xok := luaToNumber(a)
+	y, yok := luaToNumber(b)
+	if !xok || !yok {
+		bad := a
+		if xok {
+			bad = b
+		}
+		return nil, fmt.Errorf("line %d: attempt to perform arithmetic on a %s value", e.line, luaType(bad))
+	}
+	switch e.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return x / y, nil
+	case "%":
+		return luaMod(x, y), nil
+	case "^":
+		return luaPow(x, y), nil
+	}
+	return nil, fmt.Errorf("line %d: unknown operator %s", e.line, e.op)
+}
+
+func luaEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, bool, float64, string, *luaTable, *luaFunction, *luaBuiltin:
+		return a == b
+	}
+	return false
+}
+
+func luaCompare(a, b interface{}) (less, eq bool, err error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x < y, x == y, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return x < y, x == y, nil
+		}
+	}
+	return false, false, fmt.Errorf("attempt to compare %s with %s", luaType(a), luaType(b))
+}
+
+func luaTruthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+func luaType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *luaTable:
+		return "table"
+	case *luaFunction, *luaBuiltin:
+		return "function"
+	}
+	return "userdata"
+}
+
+func luaToNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			n, err := strconv.ParseInt(s[2:], 16, 64)
+			return float64(n), err == nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func luaConcatable(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return luaToString(v), true
+	}
+	return "", false
+}
+
+func luaToString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == float64(int64(v)) && v < 1e15 && v > -1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', 14, 64)
+	case string:
+		return v
+	case *luaTable:
+		return fmt.Sprintf("table: %p", v)
+	case *luaFunction:
+		return fmt.Sprintf("function: %p", v)
+	case *luaBuiltin:
+		return "builtin: " + v.name
+	}
+	return fmt.Sprint(v)
+}
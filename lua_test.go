@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// luaEval runs src and returns the global result
+func luaEval(t *testing.T, src string) interface{} {
+	L := newLuaState()
+	if err := L.DoString(src); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	return L.Globals.Get("result")
+}
+
+func TestLua(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`result = 1 + 2 * 3 ^ 2 - 10 / 5`, float64(17)},
+		{`result = 2 ^ 3 ^ 2`, float64(512)},
+		{`result = -7 % 3`, float64(2)},
+		{`result = "bat" .. "man" .. 1`, "batman1"},
+		{`result = not nil and 1 or 2`, float64(1)},
+		{`result = false or nil`, nil},
+		{`result = #"gotham" + #{1, 2, 3}`, float64(9)},
+		{`result = ("Gotham"):upper()`, "GOTHAM"},
+		{`local s = "hello gotham" result = s:sub(-6)`, "gotham"},
+		{`result = string.format("%s has %d gadgets, %.1f%%", "batman", 12.7, 99.5)`, "batman has 12 gadgets, 99.5%"},
+		{`result = string.find("to the batcave", "bat")`, float64(8)},
+		{`result = tostring(nil) .. tostring(1.5) .. type({})`, "nil1.5table"},
+		{`
+			local function fib(n)
+				if n < 2 then return n end
+				return fib(n - 1) + fib(n - 2)
+			end
+			result = fib(15)`, float64(610)},
+		{`
+			local function counter()
+				local n = 0
+				return function() n = n + 1 return n end
+			end
+			local c = counter()
+			c() c()
+			result = c()`, float64(3)},
+		{`
+			local t = {}
+			for i = 10, 1, -2 do table.insert(t, i) end
+			result = table.concat(t, ",")`, "10,8,6,4,2"},
+		{`
+			local t = {a = 1, b = 2, [3] = "c"}
+			local keys = {}
+			for k, v in pairs(t) do keys[#keys + 1] = tostring(k) .. "=" .. tostring(v) end
+			result = table.concat(keys, " ")`, "a=1 b=2 3=c"},
+		{`
+			local sum = 0
+			for i, v in ipairs({5, 6, 7, nil, 9}) do sum = sum + v end
+			result = sum`, float64(18)},
+		{`
+			local i = 0
+			while true do
+				i = i + 1
+				if i == 5 then break end
+			end
+			repeat i = i * 2 until i > 30
+			result = i`, float64(40)},
+		{`
+			local robin = {name = "robin"}
+			function robin:greet(who) return self.name .. " greets " .. who end
+			result = robin:greet("batman")`, "robin greets batman"},
+		{`
+			local ok, err = pcall(function() error("boom") end)
+			result = tostring(ok) .. " " .. err`, "false boom"},
+		{`
+			local a, b, c = (function() return 1, 2, 3 end)()
+			result = a + b + c`, float64(6)},
+		{`
+			--[[ a long
+			comment ]] result = [[long
+string]] -- trailing`, "long\nstring"},
+		{`if 1 > 2 then result = "a" elseif 2 > 1 then result = "b" else result = "c" end`, "b"},
+		{`result = string.find("a.b", ".", 1, true)`, float64(2)},
+		{`local s, e, who = string.find("hello batman", "(%a+)$") result = s .. e .. who`, "712batman"},
+		{`result = string.match("  robin  ", "^%s*(.-)%s*$")`, "robin"},
+		{`result = string.match("key = value", "(%w+)%s*=%s*(%w+)")`, "key"},
+		{`result = string.match("gotham", "()ham")`, float64(4)},
+		{`result = string.match("f(a(b)c)d", "%b()")`, "(a(b)c)"},
+		{`result = string.match("THE (quick) fox", "%f[%a]%a+%f[%A]", 5)`, "quick"},
+		{`result = string.match("abcabc", "(a%w-)%1")`, "abc"},
+		{`result = string.match("[x]", "[]x[]+")`, "[x]"},
+		{`result = string.match("2026-10-16", "^(%d+)-0?(%d+)")`, "2026"},
+		{`
+			local words = {}
+			for w in string.gmatch("one two  three", "%a+") do words[#words + 1] = w end
+			result = table.concat(words, ",")`, "one,two,three"},
+		{`result = string.gsub("hello world", "(%w+)", "<%1>")`, "<hello> <world>"},
+		{`local _, n = string.gsub("abc", "", "-") result = n`, float64(4)},
+		{`result = string.gsub("$name is $role", "%$(%w+)", {name = "bruce", role = "batman"})`, "bruce is batman"},
+		{`result = string.gsub("1 2 3", "%d", function(d) if d ~= "2" then return d * 2 end end)`, "2 2 6"},
+		{`result = string.gsub("aaa", "a", "b", 2)`, "bba"},
+	}
+
+	for _, tt := range tests {
+		if got := luaEval(t, tt.src); got != tt.want {
+			t.Errorf("expected %v from %s, got %v", tt.want, tt.src, got)
+		}
+	}
+}
+
+func TestLuaErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`result = `, "line 1: unexpected end of script"},
+		{`x = nil + 1`, "attempt to perform arithmetic on a nil value"},
+		{`undefined()`, "attempt to call a nil value"},
+		{`while true do end`, "script ran too long"},
+		{`local function f() return f() + 1 end f()`, "stack overflow"},
+		{`pcall(function() while true do end end)`, "script ran too long"},
+		{`os.exit()`, "attempt to index a nil value"},
+		{`io.open("/etc/passwd")`, "attempt to index a nil value"},
+		{`string.rep("x", 0/0)`, "number is not finite"},
+		{`string.sub("gotham", "nan")`, "number is not finite"},
+		{`string.rep("x", 1/0)`, "number is not finite"},
+		{`string.rep("x", 2 ^ 21)`, "resulting string too large"},
+		{`local s = string.rep("x", 2 ^ 19) s = s .. s .. s`, "resulting string too large"},
+		{`local s = string.rep("x", 2 ^ 19) table.concat({s, s, s})`, "resulting string too large"},
+		{`local s = string.rep("x", 2 ^ 19) string.gsub(s, "x", "xxx")`, "resulting string too large"},
+		{`string.find("x", "[x")`, "malformed pattern (missing ']')"},
+		{`string.match("x", "%")`, "malformed pattern (ends with '%')"},
+		{`string.match("x", "(x")`, "unfinished capture"},
+		{`string.match("x", "x)")`, "invalid pattern capture"},
+		{`string.gsub("x", "x", "%2")`, "invalid capture index %2"},
+		{`string.match(string.rep("a", 300), string.rep("a?", 300) .. "b")`, "pattern too complex"},
+		{`pcall(string.match, string.rep("a", 5000), string.rep("a*", 40) .. "b")`, "script ran too long"},
+	}
+
+	for _, tt := range tests {
+		err := newLuaState().DoString(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected error %q from %s, got %v", tt.want, tt.src, err)
+		}
+	}
+}
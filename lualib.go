@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+func luaMod(a, b float64) float64 {
+	return a - math.Floor(a/b)*b
+}
+
+func luaPow(a, b float64) float64 {
+	return math.Pow(a, b)
+}
+
+// luaArg returns argument i or nil
+func luaArg(args []interface{}, i int) interface{} {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func luaCheckString(name string, args []interface{}, i int) (string, error) {
+	switch v := luaArg(args, i).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return luaToString(v), nil
+	}
+	return "", fmt.Errorf("bad argument #%d to '%s' (string expected, got %s)", i+1, name, luaType(luaArg(args, i)))
+}
+
+func luaCheckNumber(name string, args []interface{}, i int) (float64, error) {
+	if n, ok := luaToNumber(luaArg(args, i)); ok {
+		// NaN fails every comparison, so it would pass any bounds check
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return 0, fmt.Errorf("bad argument #%d to '%s' (number is not finite)", i+1, name)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("bad argument #%d to '%s' (number expected, got %s)", i+1, name, luaType(luaArg(args, i)))
+}
+
+func luaCheckTable(name string, args []interface{}, i int) (*luaTable, error) {
+	if t, ok := luaArg(args, i).(*luaTable); ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("bad argument #%d to '%s' (table expected, got %s)", i+1, name, luaType(luaArg(args, i)))
+}
+
+// luaOptNumber returns argument i as a number or def if it's missing
+func luaOptNumber(name string, args []interface{}, i int, def float64) (float64, error) {
+	if luaArg(args, i) == nil {
+		return def, nil
+	}
+	return luaCheckNumber(name, args, i)
+}
+
+func (L *luaState) lib(t *luaTable, name string, fn func(L *luaState, args []interface{}) ([]interface{}, error)) {
+	t.Set(name, &luaBuiltin{name, fn})
+}
+
+// openLibs installs the base, string, table, and math functions, nothing
+// that reaches outside the interpreter is provided
+func (L *luaState) openLibs() {
+	g := L.Globals
+
+	L.lib(g, "print", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		var parts []string
+		for _, a := range args {
+			parts = append(parts, luaToString(a))
+		}
+		errl(nil, "lua: "+strings.Join(parts, "\t"))
+		return nil, nil
+	})
+	L.lib(g, "type", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		return []interface{}{luaType(luaArg(args, 0))}, nil
+	})
+	L.lib(g, "tostring", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		return []interface{}{luaToString(luaArg(args, 0))}, nil
+	})
+	L.lib(g, "tonumber", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		if n, ok := luaToNumber(luaArg(args, 0)); ok {
+			return []interface{}{n}, nil
+		}
+		return []interface{}{nil}, nil
+	})
+	L.lib(g, "error", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		return nil, &luaError{luaArg(args, 0)}
+	})
+	L.lib(g, "assert", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		if !luaTruthy(luaArg(args, 0)) {
+			msg := luaArg(args, 1)
+			if msg == nil {
+				msg = "assertion failed!"
+			}
+			return nil, &luaError{msg}
+		}
+		return args, nil
+	})
+	L.lib(g, "pcall", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("bad argument #1 to 'pcall' (value expected)")
+		}
+		vals, err := L.call(args[0], args[1:], 0)
+		if err == errLuaTooLong {
+			return nil, err
+		}
+		if err != nil {
+			if le, ok := err.(*luaError); ok {
+				return []interface{}{false, le.value}, nil
+			}
+			return []interface{}{false, err.Error()}, nil
+		}
+		return append([]interface{}{true}, vals...), nil
+	})
+	L.lib(g, "pairs", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		t, err := luaCheckTable("pairs", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		keys := append([]interface{}(nil), t.keys...)
+		i := 0
+		next := &luaBuiltin{"next", func(L *luaState, _ []interface{}) ([]interface{}, error) {
+			for i < len(keys) {
+				k := keys[i]
+				i++
+				if v := t.Get(k); v != nil {
+					return []interface{}{k, v}, nil
+				}
+			}
+			return []interface{}{nil}, nil
+		}}
+		return []interface{}{next, t, nil}, nil
+	})
+	L.lib(g, "ipairs", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		t, err := luaCheckTable("ipairs", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		next := &luaBuiltin{"ipairs_next", func(L *luaState, args []interface{}) ([]interface{}, error) {
+			i, _ := luaToNumber(luaArg(args, 1))
+			i++
+			v := t.Get(i)
+			if v == nil {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{i, v}, nil
+		}}
+		return []interface{}{next, t, float64(0)}, nil
+	})
+
+	L.strlib = newLuaTable()
+	L.openString(L.strlib)
+	g.Set("string", L.strlib)
+
+	tbl := newLuaTable()
+	L.openTable(tbl)
+	g.Set("table", tbl)
+
+	m := newLuaTable()
+	L.openMath(m)
+	g.Set("math", m)
+}
+
+// luaRange converts Lua's 1 based, possibly negative, i and j to a slice
+// range of a string of length n
+func luaRange(i, j float64, n int) (int, int) {
+	if i < 0 {
+		i = float64(n) + i + 1
+	}
+	if j < 0 {
+		j = float64(n) + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > float64(n) {
+		j = float64(n)
+	}
+	if i > j {
+		return 0, 0
+	}
+	return int(i) - 1, int(j)
+}
+
+func (L *luaState) openString(t *luaTable) {
+	L.lib(t, "len", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("len", args, 0)
+		return []interface{}{float64(len(s))}, err
+	})
+	L.lib(t, "lower", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("lower", args, 0)
+		return []interface{}{strings.ToLower(s)}, err
+	})
+	L.lib(t, "upper", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("upper", args, 0)
+		return []interface{}{strings.ToUpper(s)}, err
+	})
+	L.lib(t, "reverse", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("reverse", args, 0)
+		b := []byte(s)
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		return []interface{}{string(b)}, err
+	})
+	L.lib(t, "rep", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("rep", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := luaCheckNumber("rep", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			n = 0
+		}
+		if float64(len(s))*n > luaMaxString {
+			return nil, errLuaStringTooLarge
+		}
+		return []interface{}{strings.Repeat(s, int(n))}, nil
+	})
+	L.lib(t, "sub", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		s, err := luaCheckString("sub", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		i, err := luaOptNumber("sub", args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		j, err := luaOptNumber("sub", args, 2, -1)
+		if err != nil {
+			return nil, err
+		}
+		from, to := luaRange(i, j, len(s))
+		return []interface{}{s[from:to]}, nil
+	})
+	L.lib(t, "find", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		return luaFind(L, "find", args, true)
+	})
+	L.lib(t, "match", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		return luaFind(L, "match", args, false)
+	})
+	L.lib(t, "gmatch", luaGmatch)
+	L.lib(t, "gsub", luaGsub)
+	L.lib(t, "format", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		f, err := luaCheckString("format", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		s, err := luaFormat(f, args[1:])
+		return []interface{}{s}, err
+	})
+}
+
+// luaFormat implements string.format for the s, d, i, f, g, e, x, X, c,
+// and q verbs
+func luaFormat(f string, args []interface{}) (string, error) {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			continue
+		}
+		j := i + 1
+		for j < len(f) && strings.IndexByte("-+ #0123456789.", f[j]) >= 0 {
+			j++
+		}
+		if j >= len(f) {
+			return "", fmt.Errorf("invalid format string")
+		}
+		verb := f[j]
+		spec := f[i:j]
+		i = j
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+
+		arg := luaArg(args, n)
+		n++
+		switch verb {
+		case 'd', 'i':
+			x, ok := luaToNumber(arg)
+			if !ok {
+				return "", fmt.Errorf("bad argument #%d to 'format' (number expected, got %s)", n+1, luaType(arg))
+			}
+			fmt.Fprintf(&b, spec+"d", int64(x))
+		case 'f', 'g', 'e', 'G', 'E':
+			x, ok := luaToNumber(arg)
+			if !ok {
+				return "", fmt.Errorf("bad argument #%d to 'format' (number expected, got %s)", n+1, luaType(arg))
+			}
+			fmt.Fprintf(&b, spec+string(verb), x)
+		case 'x', 'X', 'c':
+			x, ok := luaToNumber(arg)
+			if !ok {
+				return "", fmt.Errorf("bad argument #%d to 'format' (number expected, got %s)", n+1, luaType(arg))
+			}
+			fmt.Fprintf(&b, spec+string(verb), int64(x))
+		case 's':
+			fmt.Fprintf(&b, spec+"s", luaToString(arg))
+		case 'q':
+			b.WriteString(strconv.Quote(luaToString(arg)))
+		default:
+			return "", fmt.Errorf("invalid option '%%%c' to 'format'", verb)
+		}
+	}
+	return b.String(), nil
+}
+
+func (L *luaState) openTable(t *luaTable) {
+	L.lib(t, "insert", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		tbl, err := luaCheckTable("insert", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n := tbl.Len()
+		if len(args) < 3 {
+			tbl.Set(float64(n+1), luaArg(args, 1))
+			return nil, nil
+		}
+		pos, err := luaCheckNumber("insert", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if pos < 1 || pos > float64(n+1) {
+			return nil, fmt.Errorf("bad argument #2 to 'insert' (position out of bounds)")
+		}
+		for i := float64(n); i >= pos; i-- {
+			tbl.Set(i+1, tbl.Get(i))
+		}
+		tbl.Set(pos, args[2])
+		return nil, nil
+	})
+	L.lib(t, "remove", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		tbl, err := luaCheckTable("remove", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n := float64(tbl.Len())
+		pos, err := luaOptNumber("remove", args, 1, n)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return []interface{}{nil}, nil
+		}
+		v := tbl.Get(pos)
+		for i := pos; i < n; i++ {
+			tbl.Set(i, tbl.Get(i+1))
+		}
+		tbl.Set(n, nil)
+		return []interface{}{v}, nil
+	})
+	L.lib(t, "concat", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		tbl, err := luaCheckTable("concat", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		sep := ""
+		if luaArg(args, 1) != nil {
+			if sep, err = luaCheckString("concat", args, 1); err != nil {
+				return nil, err
+			}
+		}
+		var parts []string
+		size := 0
+		for i := 1; i <= tbl.Len(); i++ {
+			s, ok := luaConcatable(tbl.Get(float64(i)))
+			if !ok {
+				return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", i)
+			}
+			if size += len(s) + len(sep); size > luaMaxString+len(sep) {
+				return nil, errLuaStringTooLarge
+			}
+			parts = append(parts, s)
+		}
+		return []interface{}{strings.Join(parts, sep)}, nil
+	})
+}
+
+func (L *luaState) openMath(t *luaTable) {
+	t.Set("pi", math.Pi)
+	t.Set("huge", math.Inf(1))
+	unary := func(name string, fn func(float64) float64) {
+		L.lib(t, name, func(L *luaState, args []interface{}) ([]interface{}, error) {
+			x, err := luaCheckNumber(name, args, 0)
+			return []interface{}{fn(x)}, err
+		})
+	}
+	unary("floor", math.Floor)
+	unary("ceil", math.Ceil)
+	unary("abs", math.Abs)
+	unary("sqrt", math.Sqrt)
+
+	L.lib(t, "max", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		m, err := luaCheckNumber("max", args, 0)
+		for i := 1; i < len(args) && err == nil; i++ {
+			var x float64
+			if x, err = luaCheckNumber("max", args, i); x > m {
+				m = x
+			}
+		}
+		return []interface{}{m}, err
+	})
+	L.lib(t, "min", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		m, err := luaCheckNumber("min", args, 0)
+		for i := 1; i < len(args) && err == nil; i++ {
+			var x float64
+			if x, err = luaCheckNumber("min", args, i); x < m {
+				m = x
+			}
+		}
+		return []interface{}{m}, err
+	})
+	L.lib(t, "random", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		switch len(args) {
+		case 0:
+			return []interface{}{rand.Float64()}, nil
+		case 1:
+			n, err := luaCheckNumber("random", args, 0)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad argument #1 to 'random' (interval is empty)")
+			}
+			return []interface{}{float64(rand.Int63n(int64(n)) + 1)}, nil
+		}
+		lo, err := luaCheckNumber("random", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := luaCheckNumber("random", args, 1)
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("bad argument #2 to 'random' (interval is empty)")
+		}
+		return []interface{}{lo + float64(rand.Int63n(int64(hi-lo)+1))}, nil
+	})
+}
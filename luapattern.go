@@ -0,0 +1,619 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// luaMaxCaptures is how many captures a pattern may have
+const luaMaxCaptures = 32
+
+// luaMaxMatchDepth is how deeply a pattern may recurse while matching
+const luaMaxMatchDepth = 200
+
+// capture lengths of a capture still open and of a position capture
+const (
+	luaCapUnfinished = -1
+	luaCapPosition   = -2
+)
+
+// luaPatternSpecials are the characters that make a find a pattern search
+const luaPatternSpecials = "^$*+?.([%-"
+
+// luaPatternError stops a match, it carries the error to return
+type luaPatternError struct {
+	err error
+}
+
+// luaMatcher matches a Lua pattern against a string the way lstrlib.c
+// does, every step of the match counts against the script's budget
+type luaMatcher struct {
+	L       *luaState
+	src     string
+	pat     string
+	level   int
+	depth   int
+	capture [luaMaxCaptures]struct{ init, len int }
+}
+
+func (m *luaMatcher) fail(format string, args ...interface{}) {
+	panic(luaPatternError{fmt.Errorf(format, args...)})
+}
+
+// find returns where the pattern from p matches the source from s, and
+// where the match ends, -1 when it doesn't match
+func (m *luaMatcher) find(s, p int) (end int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(luaPatternError)
+			if !ok {
+				panic(r)
+			}
+			end, err = -1, pe.err
+		}
+	}()
+	m.level, m.depth = 0, 0
+	return m.match(s, p), nil
+}
+
+// classEnd returns the end of the single character class at p
+func (m *luaMatcher) classEnd(p int) int {
+	c := m.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(m.pat) {
+			m.fail("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(m.pat) && m.pat[p] == '^' {
+			p++
+		}
+		// the first character may be a ], it doesn't close the set
+		for {
+			if p >= len(m.pat) {
+				m.fail("malformed pattern (missing ']')")
+			}
+			c := m.pat[p]
+			p++
+			if c == '%' && p < len(m.pat) {
+				p++
+			}
+			if p >= len(m.pat) {
+				m.fail("malformed pattern (missing ']')")
+			}
+			if m.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+// luaMatchClass returns true if c is in the class of %cl
+func luaMatchClass(c, cl byte) bool {
+	var res bool
+	lower := cl | 0x20
+	switch lower {
+	case 'a':
+		res = luaIsLower(c) || luaIsUpper(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = luaIsLower(c)
+	case 'p':
+		res = c > 32 && c < 127 && !luaIsAlnum(c)
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = luaIsUpper(c)
+	case 'w':
+		res = luaIsAlnum(c)
+	case 'x':
+		res = (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'f')
+	default:
+		return cl == c
+	}
+	if luaIsUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+func luaIsLower(c byte) bool { return c >= 'a' && c <= 'z' }
+func luaIsUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+func luaIsAlnum(c byte) bool { return luaIsLower(c) || luaIsUpper(c) || (c >= '0' && c <= '9') }
+
+// matchBracket returns true if c is in the set from the [ at p to the ]
+// at end
+func (m *luaMatcher) matchBracket(c byte, p, end int) bool {
+	sig := true
+	if m.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < end; p++ {
+		switch {
+		case m.pat[p] == '%':
+			p++
+			if luaMatchClass(c, m.pat[p]) {
+				return sig
+			}
+		case m.pat[p+1] == '-' && p+2 < end:
+			p += 2
+			if m.pat[p-2] <= c && c <= m.pat[p] {
+				return sig
+			}
+		case m.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+// single returns true if the source character at s is in the class from
+// p to ep
+func (m *luaMatcher) single(s, p, ep int) bool {
+	if s >= len(m.src) {
+		return false
+	}
+	c := m.src[s]
+	switch m.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return luaMatchClass(c, m.pat[p+1])
+	case '[':
+		return m.matchBracket(c, p, ep-1)
+	}
+	return m.pat[p] == c
+}
+
+// at returns the pattern character at p, 0 past the end
+func (m *luaMatcher) at(p int) byte {
+	if p < len(m.pat) {
+		return m.pat[p]
+	}
+	return 0
+}
+
+// match matches the pattern from p at s, it returns the end of the match
+// or -1
+func (m *luaMatcher) match(s, p int) int {
+	if err := m.L.step(); err != nil {
+		panic(luaPatternError{err})
+	}
+	if m.depth++; m.depth > luaMaxMatchDepth {
+		m.fail("pattern too complex")
+	}
+	defer func() { m.depth-- }()
+
+	for p < len(m.pat) {
+		switch m.pat[p] {
+		case '(':
+			if m.at(p+1) == ')' {
+				return m.startCapture(s, p+2, luaCapPosition)
+			}
+			return m.startCapture(s, p+1, luaCapUnfinished)
+		case ')':
+			return m.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(m.pat) {
+				if s == len(m.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			switch c := m.at(p + 1); {
+			case c == 'b':
+				if s = m.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case c == 'f':
+				p += 2
+				if m.at(p) != '[' {
+					m.fail("missing '[' after '%%f' in pattern")
+				}
+				ep := m.classEnd(p)
+				var prev, cur byte
+				if s > 0 {
+					prev = m.src[s-1]
+				}
+				if s < len(m.src) {
+					cur = m.src[s]
+				}
+				if m.matchBracket(prev, p, ep-1) || !m.matchBracket(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case c >= '0' && c <= '9':
+				if s = m.matchCapture(s, c); s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		ep := m.classEnd(p)
+		if !m.single(s, p, ep) {
+			switch m.at(ep) {
+			case '*', '?', '-':
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		switch m.at(ep) {
+		case '?':
+			if res := m.match(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1
+		case '+':
+			return m.maxExpand(s+1, p, ep)
+		case '*':
+			return m.maxExpand(s, p, ep)
+		case '-':
+			return m.minExpand(s, p, ep)
+		default:
+			s, p = s+1, ep
+		}
+	}
+	return s
+}
+
+func (m *luaMatcher) maxExpand(s, p, ep int) int {
+	i := 0
+	for m.single(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res := m.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (m *luaMatcher) minExpand(s, p, ep int) int {
+	for {
+		if res := m.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !m.single(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (m *luaMatcher) startCapture(s, p, what int) int {
+	if m.level >= luaMaxCaptures {
+		m.fail("too many captures")
+	}
+	m.capture[m.level].init = s
+	m.capture[m.level].len = what
+	m.level++
+	res := m.match(s, p)
+	if res == -1 {
+		m.level--
+	}
+	return res
+}
+
+func (m *luaMatcher) endCapture(s, p int) int {
+	l := -1
+	for i := m.level - 1; i >= 0; i-- {
+		if m.capture[i].len == luaCapUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		m.fail("invalid pattern capture")
+	}
+	m.capture[l].len = s - m.capture[l].init
+	res := m.match(s, p)
+	if res == -1 {
+		m.capture[l].len = luaCapUnfinished
+	}
+	return res
+}
+
+func (m *luaMatcher) matchBalance(s, p int) int {
+	if p+1 >= len(m.pat) {
+		m.fail("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(m.src) || m.src[s] != m.pat[p] {
+		return -1
+	}
+	b, e, cont := m.pat[p], m.pat[p+1], 1
+	for s++; s < len(m.src); s++ {
+		switch m.src[s] {
+		case e:
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+func (m *luaMatcher) matchCapture(s int, c byte) int {
+	l := int(c - '1')
+	if l < 0 || l >= m.level || m.capture[l].len == luaCapUnfinished {
+		m.fail("invalid capture index %%%d", l+1)
+	}
+	cp := m.capture[l]
+	if len(m.src)-s >= cp.len && m.src[cp.init:cp.init+cp.len] == m.src[s:s+cp.len] {
+		return s + cp.len
+	}
+	return -1
+}
+
+// captureValue returns capture i of the match from s to e, the whole
+// match when the pattern has no captures
+func (m *luaMatcher) captureValue(i, s, e int) (interface{}, error) {
+	if i >= m.level {
+		if i == 0 {
+			return m.src[s:e], nil
+		}
+		return nil, fmt.Errorf("invalid capture index %%%d", i+1)
+	}
+	switch cp := m.capture[i]; cp.len {
+	case luaCapUnfinished:
+		return nil, errors.New("unfinished capture")
+	case luaCapPosition:
+		return float64(cp.init + 1), nil
+	default:
+		return m.src[cp.init : cp.init+cp.len], nil
+	}
+}
+
+// captures returns the captures of the match from s to e, or the whole
+// match when the pattern has none
+func (m *luaMatcher) captures(s, e int) ([]interface{}, error) {
+	n := m.level
+	if n == 0 {
+		n = 1
+	}
+	out := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := m.captureValue(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// luaFind runs string.find, or string.match when find is false
+func luaFind(L *luaState, name string, args []interface{}, find bool) ([]interface{}, error) {
+	s, err := luaCheckString(name, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := luaCheckString(name, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	init, err := luaOptNumber(name, args, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	from, _ := luaRange(init, -1, len(s))
+	if init > float64(len(s)) {
+		from = len(s)
+	}
+
+	if find && (luaTruthy(luaArg(args, 3)) || !strings.ContainsAny(pat, luaPatternSpecials)) {
+		i := strings.Index(s[from:], pat)
+		if i < 0 {
+			return []interface{}{nil}, nil
+		}
+		return []interface{}{float64(from + i + 1), float64(from + i + len(pat))}, nil
+	}
+
+	m := &luaMatcher{L: L, src: s, pat: pat}
+	p, anchor := 0, strings.HasPrefix(pat, "^")
+	if anchor {
+		p = 1
+	}
+	for start := from; start <= len(s); start++ {
+		e, err := m.find(start, p)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			if !find {
+				return m.captures(start, e)
+			}
+			out := []interface{}{float64(start + 1), float64(e)}
+			if m.level > 0 {
+				caps, err := m.captures(start, e)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, caps...)
+			}
+			return out, nil
+		}
+		if anchor {
+			break
+		}
+	}
+	return []interface{}{nil}, nil
+}
+
+// luaGmatch runs string.gmatch, it returns an iterator over the matches
+func luaGmatch(L *luaState, args []interface{}) ([]interface{}, error) {
+	s, err := luaCheckString("gmatch", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := luaCheckString("gmatch", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &luaMatcher{L: L, src: s, pat: pat}
+	pos := 0
+	next := &luaBuiltin{"gmatch_next", func(L *luaState, _ []interface{}) ([]interface{}, error) {
+		m.L = L
+		for start := pos; start <= len(s); start++ {
+			e, err := m.find(start, 0)
+			if err != nil {
+				return nil, err
+			}
+			if e != -1 {
+				// an empty match moves on by one so the loop ends
+				pos = e
+				if e == start {
+					pos++
+				}
+				return m.captures(start, e)
+			}
+		}
+		pos = len(s) + 1
+		return []interface{}{nil}, nil
+	}}
+	return []interface{}{next}, nil
+}
+
+// luaGsub runs string.gsub, the replacement is a string with %0 to %9, a
+// table looked up by the first capture, or a function called with the
+// captures, a false or nil result keeps the match
+func luaGsub(L *luaState, args []interface{}) ([]interface{}, error) {
+	s, err := luaCheckString("gsub", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := luaCheckString("gsub", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	repl := luaArg(args, 2)
+	switch repl.(type) {
+	case string, float64, *luaTable, *luaBuiltin, *luaFunction:
+	default:
+		return nil, fmt.Errorf("bad argument #3 to 'gsub' (string/function/table expected, got %s)", luaType(repl))
+	}
+	max, err := luaOptNumber("gsub", args, 3, float64(len(s)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &luaMatcher{L: L, src: s, pat: pat}
+	p, anchor := 0, strings.HasPrefix(pat, "^")
+	if anchor {
+		p = 1
+	}
+
+	var b strings.Builder
+	src, n := 0, 0
+	for float64(n) < max {
+		e, err := m.find(src, p)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			n++
+			if err := m.replace(&b, repl, src, e); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case e != -1 && e > src:
+			src = e
+		case src < len(s):
+			b.WriteByte(s[src])
+			src++
+		default:
+			src = len(s) + 1
+		}
+		if b.Len() > luaMaxString {
+			return nil, errLuaStringTooLarge
+		}
+		if src > len(s) || anchor {
+			break
+		}
+	}
+	if src < len(s) {
+		b.WriteString(s[src:])
+	}
+	if b.Len() > luaMaxString {
+		return nil, errLuaStringTooLarge
+	}
+	return []interface{}{b.String(), float64(n)}, nil
+}
+
+// replace writes the replacement of the match from s to e
+func (m *luaMatcher) replace(b *strings.Builder, repl interface{}, s, e int) error {
+	var v interface{}
+	switch r := repl.(type) {
+	case string, float64:
+		text, _ := luaConcatable(r)
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c != '%' || i+1 >= len(text) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			switch c = text[i]; {
+			case c == '0':
+				b.WriteString(m.src[s:e])
+			case c >= '1' && c <= '9':
+				cp, err := m.captureValue(int(c-'1'), s, e)
+				if err != nil {
+					return err
+				}
+				b.WriteString(luaToString(cp))
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil
+	case *luaTable:
+		key, err := m.captureValue(0, s, e)
+		if err != nil {
+			return err
+		}
+		v = r.Get(key)
+	default:
+		caps, err := m.captures(s, e)
+		if err != nil {
+			return err
+		}
+		vals, err := m.L.call(repl, caps, 0)
+		if err != nil {
+			return err
+		}
+		v = luaArg(vals, 0)
+	}
+
+	if !luaTruthy(v) {
+		b.WriteString(m.src[s:e])
+		return nil
+	}
+	text, ok := luaConcatable(v)
+	if !ok {
+		return fmt.Errorf("invalid replacement value (a %s)", luaType(v))
+	}
+	b.WriteString(text)
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// email notification modes
+const (
+	EmailImmediate = "immediate"
+	EmailDigest    = "digest"
+)
+
+// DefaultDigestInterval is how often digest emails are sent
+const DefaultDigestInterval = time.Hour
+
+// mailQueue is how many immediate emails may wait for delivery
+const mailQueue = 256
+
+type email struct {
+	to      string
+	subject string
+	body    string
+}
+
+// Mailer sends notification emails over SMTP, either one per notification
+// or batched into a periodic digest
+type Mailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+
+	mu      sync.Mutex
+	digests map[string][]string
+	queue   chan email
+	send    func(e email) error
+}
+
+// NewMailer returns a mailer for the SMTP server at addr, digests are sent
+// every interval
+func NewMailer(addr, from string, auth smtp.Auth, interval time.Duration) *Mailer {
+	m := &Mailer{
+		Addr:    addr,
+		From:    from,
+		Auth:    auth,
+		digests: make(map[string][]string),
+		queue:   make(chan email, mailQueue),
+	}
+	m.send = m.smtp
+	go m.run()
+	go func() {
+		for range time.Tick(interval) {
+			m.Flush()
+		}
+	}()
+	return m
+}
+
+// Notify sends or queues a notification email, it never blocks the caller
+func (m *Mailer) Notify(to, mode, title, body string) {
+	if m == nil || to == "" {
+		return
+	}
+
+	if mode == EmailDigest {
+		m.mu.Lock()
+		m.digests[to] = append(m.digests[to], fmt.Sprintf("[%s] %s\n%s", time.Now().Format(time.RFC3339), title, body))
+		m.mu.Unlock()
+		return
+	}
+
+	select {
+	case m.queue <- email{to: to, subject: "TinyChat: " + title, body: body}:
+	default:
+		errl(fmt.Errorf("mail queue is full, dropping email for %s", to), "")
+	}
+}
+
+// Flush sends every pending digest
+func (m *Mailer) Flush() {
+	m.mu.Lock()
+	digests := m.digests
+	m.digests = make(map[string][]string)
+	m.mu.Unlock()
+
+	for to, lines := range digests {
+		subject := fmt.Sprintf("TinyChat: %d notification(s) while you were away", len(lines))
+		m.queue <- email{to: to, subject: subject, body: strings.Join(lines, "\n\n")}
+	}
+}
+
+func (m *Mailer) run() {
+	for e := range m.queue {
+		if err := m.send(e); err != nil {
+			errl(err, "")
+		}
+	}
+}
+
+// smtp delivers a single email through the configured server
+func (m *Mailer) smtp(e email) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		m.From, e.to, e.subject, time.Now().Format(time.RFC1123Z), strings.Replace(e.body, "\n", "\r\n", -1))
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{e.to}, []byte(msg))
+}
+
+// SetEmail sets the email address of the client's account
+func (s *Server) SetEmail(cl *Client, address string) error {
+	name := cl.Account()
+	if name == "" {
+		return fmt.Errorf("you must be logged in to receive email notifications\r\n")
+	}
+
+	a, err := mail.ParseAddress(address)
+	if err != nil || a.Name != "" {
+		return fmt.Errorf("email [%s] is not valid\r\n", address)
+	}
+
+	return s.Accounts.Update(name, func(acct *Account) {
+		acct.Email = a.Address
+	})
+}
+
+// SetEmailMode opts the client's account in to immediate or digest email
+// notifications, or out of them with off
+func (s *Server) SetEmailMode(cl *Client, mode string) error {
+	name := cl.Account()
+	if name == "" {
+		return fmt.Errorf("you must be logged in to receive email notifications\r\n")
+	}
+
+	if s.Mail == nil {
+		return fmt.Errorf("email notifications are not configured on this server\r\n")
+	}
+
+	if mode == "off" {
+		mode = ""
+	} else if mode != EmailImmediate && mode != EmailDigest {
+		return fmt.Errorf("use /email immediate, /email digest, or /email off\r\n")
+	}
+
+	var err error
+	uerr := s.Accounts.Update(name, func(acct *Account) {
+		if mode != "" && acct.Email == "" {
+			err = fmt.Errorf("set an address first with /email <address>\r\n")
+			return
+		}
+		acct.EmailMode = mode
+	})
+	if uerr != nil {
+		return uerr
+	}
+	return err
+}
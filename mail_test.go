@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailDigest(t *testing.T) {
+	sent := make(chan email, 1)
+	m := &Mailer{
+		digests: make(map[string][]string),
+		queue:   make(chan email, mailQueue),
+		send: func(e email) error {
+			sent <- e
+			return nil
+		},
+	}
+	go m.run()
+
+	serv := NewServer()
+	serv.Mail = m
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.Register(batman, "hunter2")
+
+	if err := serv.SetEmailMode(batman, EmailDigest); err == nil {
+		t.Errorf("expected digest without an address to fail")
+	}
+
+	if err := serv.SetEmail(batman, "batman@wayne.com"); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	if err := serv.SetEmailMode(batman, EmailDigest); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("gotham", robin)
+	serv.Message([]string{"batman", "the", "signal"}, robin)
+	serv.Message([]string{"batman?"}, robin)
+
+	select {
+	case <-sent:
+		t.Fatalf("expected digest to wait for a flush")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.Flush()
+	select {
+	case e := <-sent:
+		if e.to != "batman@wayne.com" || e.subject != "TinyChat: 2 notification(s) while you were away" {
+			t.Errorf("unexpected digest %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a digest email")
+	}
+}
@@ -6,49 +6,45 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/smtp"
 	"os"
 	"path"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 )
 
 const logName = "tinychat.log"
-const DefaultRoom = "Gotham City"
 
-// banner is a const displayed to the user as the connect to the system
-const banner = `
---|Welcome|--------------------------------------------------------------------------------------
-
-You are user [%s], Welcome to TinyChat. 
+// DefaultRoom is the key of the room every client starts in, it is shown as
+// defaultRoomDisplay
+const DefaultRoom = "gotham city"
+const defaultRoomDisplay = "Gotham City"
 
---|Help|-----------------------------------------------------------------------------------------
-
-{no flag needed}
-send a message to the room you are in
-(example: hi freeze, i'm batman)
+// DefaultResumeWindow is how long a disconnected session is kept for /resume
+const DefaultResumeWindow = 5 * time.Minute
 
-/help
-prints this banner
-(example: /help) 
+// maxUnread is the number of messages buffered for a disconnected session
+const maxUnread = 100
 
-/quit
-quits the application
-(example: /quit) 
+// banner is the template displayed to the user as they connect to the
+// system unless TCBanner names another, it is followed by the help of every
+// command and bannerEnd
+const banner = `
+--|Welcome|--------------------------------------------------------------------------------------
 
-/nick
-sets your nickname
-(example: /nick batman)
+You are user [{{.Nick}}], Welcome to {{.Server}}. 
 
-/room 
-change chat room, only 1 room may be joined
-(example: /room gotham)
+--|Help|-----------------------------------------------------------------------------------------
 
-/blast
-blast a message to all connected clients 
-(example: /blast the ice man cometh)
+`
 
--------------------------------------------------------------------------------------------------
+const bannerEnd = `-------------------------------------------------------------------------------------------------
 `
 
 // helper logging function
@@ -62,9 +58,79 @@ func errl(err error, message string) {
 
 // Client is a structure keeping the state of the user connected to the server
 type Client struct {
-	mu   sync.Mutex
-	nick string
-	Conn net.Conn
+	mu      sync.Mutex
+	nick    string
+	account string
+	token   string
+	away    string
+	status  string
+	lang    string
+	tz      *time.Location
+	timefmt string
+	noEmoji bool
+	plain   bool
+	pubkey  string
+	watch   []string
+	idle    bool
+	bot     bool
+	unread  []Event
+	expire  *time.Timer
+	relay   func(Event)
+	Conns   []*Conn
+
+	// keywords are flagged in messages of the client's room, and of public
+	// rooms when keywordsPublic is set
+	keywords       []string
+	keywordsPublic bool
+
+	// roomList is the rooms the client was last shown by /rooms, in order
+	roomList []string
+}
+
+// Conn is a single connection of a client along with its bookkeeping
+type Conn struct {
+	active int64
+	json   int32
+	color  int32
+	ids    int32
+	width  int32
+	quit   int32
+	limit  *rateLimiter
+	// probation limits the connection instead while its client is on
+	// probation
+	probation *rateLimiter
+	listed    []string
+	pingMu    sync.Mutex
+	pings     map[string]time.Time
+	net.Conn
+	Connected time.Time
+	// capture collects the lines of a multi-line /paste until its end line
+	captureMu sync.Mutex
+	capture   *capture
+
+	// out queues what is sent to the connection for its writer, so a peer
+	// that stopped reading only holds up itself, done is closed with the
+	// connection
+	outOnce   sync.Once
+	out       chan outbound
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConn wraps a network connection, stamping its connect time
+func NewConn(c net.Conn) *Conn {
+	now := time.Now()
+	return &Conn{Conn: c, Connected: now, active: now.UnixNano()}
+}
+
+// Touch records input activity on the connection
+func (c *Conn) Touch() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+}
+
+// Idle returns how long ago the connection last sent input
+func (c *Conn) Idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.active)))
 }
 
 // Nick returns the nickname of the client
@@ -74,11 +140,187 @@ func (cl *Client) Nick() string {
 	return cl.nick
 }
 
-// Write writes the output to a client
+// setNick renames the client, identity is only changed under the client's
+// lock so it never races Nick or Write
+func (cl *Client) setNick(nick string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.nick = nick
+}
+
+// Token returns the session token of the client, empty if it has none
+func (cl *Client) Token() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.token
+}
+
+// setToken sets the session token of the client
+func (cl *Client) setToken(token string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.token = token
+}
+
+// Account returns the registered account of the client, empty for guests
+func (cl *Client) Account() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.account
+}
+
+// Write writes the output to every connection of a client
 func (cl *Client) Write(s string) {
+	cl.Send(Event{Type: EventText, Text: s})
+}
+
+// Send writes the event to every connection of a client, rendered for the
+// mode of each connection, if the client is disconnected the event is
+// buffered until the session is resumed, clients bridged from elsewhere
+// relay the event instead, server messages are translated to the client's
+// language and timestamps shown in its timezone, events shown here are
+// recorded while TCRecord is set
+func (cl *Client) Send(ev Event) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
-	cl.Conn.Write([]byte(s))
+	ev = localize(ev, cl.lang)
+	if cl.relay != nil {
+		cl.relay(ev)
+		return
+	}
+	recorder().Output(cl, ev)
+	if len(cl.Conns) == 0 {
+		cl.unread = append(cl.unread, ev)
+		if len(cl.unread) > maxUnread {
+			cl.unread = cl.unread[len(cl.unread)-maxUnread:]
+		}
+		return
+	}
+	// people read timestamps in their own timezone and format, programs
+	// reading JSON get them as sent
+	text := ev
+	text.Time = cl.clock(ev.Time)
+	for _, c := range cl.Conns {
+		if c.JSON() {
+			c.send(c.Render(ev))
+		} else {
+			c.send(c.render(text, cl.plain))
+		}
+	}
+}
+
+// writeTimeout bounds every write to a connection, so a peer that stopped
+// reading can't hold up whoever is sending to it
+var writeTimeout = 10 * time.Second
+
+// connQueue is how many writes may wait for a connection, one that falls
+// further behind is closed
+const connQueue = 256
+
+var errConnClosed = errors.New("connection is closed")
+
+// outbound is a write waiting for a connection, close closes the
+// connection once it is written
+type outbound struct {
+	b     []byte
+	close bool
+}
+
+// send queues out for the connection, it never blocks, a connection that
+// can't keep up or can't take a write within writeTimeout is closed, its
+// read loop then cleans it up
+func (c *Conn) send(out string) error {
+	return c.queue(outbound{b: []byte(out)})
+}
+
+// sendClose queues out and closes the connection once it is written
+func (c *Conn) sendClose(out string) error {
+	return c.queue(outbound{b: []byte(out), close: true})
+}
+
+// Write queues b like send so nothing writes to the connection around its
+// writer
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.queue(outbound{b: append([]byte(nil), b...)}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// queue hands o to the connection's writer, starting it on first use
+func (c *Conn) queue(o outbound) error {
+	if c.Conn == nil {
+		return nil
+	}
+	c.start()
+	select {
+	case <-c.done:
+		return errConnClosed
+	default:
+	}
+	select {
+	case c.out <- o:
+		return nil
+	default:
+		errl(fmt.Errorf("closing %s: it fell %d writes behind", logAddr(c.RemoteAddr()), connQueue), "")
+		c.Close()
+		return errConnClosed
+	}
+}
+
+// start starts the writer of the connection once
+func (c *Conn) start() {
+	c.outOnce.Do(func() {
+		c.out = make(chan outbound, connQueue)
+		c.done = make(chan struct{})
+		go c.write()
+	})
+}
+
+// write writes what is queued for the connection in order until it is
+// closed, every write gets writeTimeout of its own
+func (c *Conn) write() {
+	for {
+		select {
+		case o := <-c.out:
+			if len(o.b) > 0 {
+				c.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if _, err := c.Conn.Write(o.b); err != nil {
+					errl(fmt.Errorf("closing %s: %v", logAddr(c.RemoteAddr()), err), "")
+					c.Close()
+					return
+				}
+			}
+			if o.close {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// closed is closed once the connection is
+func (c *Conn) closed() <-chan struct{} {
+	if c.Conn == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	c.start()
+	return c.done
+}
+
+// Close closes the connection and stops its writer, anything still queued
+// is dropped
+func (c *Conn) Close() error {
+	if c.Conn == nil {
+		return nil
+	}
+	c.start()
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
 }
 
 // Serv is a pointer to our Server instance
@@ -86,23 +328,174 @@ var Serv *Server
 
 // Server is the struct that keeps the state of the entire application
 type Server struct {
-	mu      sync.Mutex
-	Rooms   map[string]*Room
-	Clients map[string]*Client
+	mu           sync.Mutex
+	Rooms        map[string]*Room
+	Clients      map[string]*Client
+	Sessions     map[string]*Client
+	Accounts     *AccountStore
+	Hooks        *Webhooks
+	Previews     *Previews
+	Files        *Files
+	ImageTypes   map[string]bool
+	Push         *Pusher
+	Mail         *Mailer
+	Exports      []*Exporter
+	Cluster      *Cluster
+	Raft         *Raft
+	Links        *Links
+	Gossip       *Gossip
+	Journal      *Journal
+	Plugins      *Plugins
+	Inbound      *Pipeline
+	Commands     *Registry
+	Reminders    *Reminders
+	Spam         *SpamFilter
+	SnapshotPath string
+	ConfigPath   string
+	Control      *Control
+	Muted        map[string]bool
+	GeoIP        *GeoIP
+	Blocklists   *Blocklists
+	Offenses     *Offenses
+	Quotas       *Quotas
+	lastID       int64
+	modlog       []ModAction
+	directs      map[int64]sentDirect
+	directOrder  []int64
+	seen         map[string]time.Time
+	lastBlast    map[string]time.Time
+	started      time.Time
+	HookTokens   map[string]string
+	SearchTokens []SearchToken
+	Telnet       bool
+
+	// cfg guards the settings a reload changes
+	cfg             sync.RWMutex
+	Owners          map[string]bool
+	Admins          map[string]bool
+	Moderators      map[string]bool
+	Permissions     map[string]string
+	AllowNets       []*net.IPNet
+	DenyNets        []*net.IPNet
+	AllowCountries  map[string]bool
+	DenyCountries   map[string]bool
+	BotKeys         []BotKey
+	RateLimit       int
+	AutoAway        time.Duration
+	ReadTimeout     time.Duration
+	BlastPolicy     string
+	BlastInterval   time.Duration
+	ProbationPeriod time.Duration
+	ProbationRate   int
+	ServerName      string
+	BannerFile      *template.Template
+	Reserved        []string
+	gate            string
+	gateBits        int
+
+	ResumeWindow time.Duration
+	draining     bool
+	stopping     *shutdown
+	exit         func(restart bool)
+
+	// LineLimit is how many characters a room message may have before it
+	// is pasted, pastes keeps the full text of those messages by id, and
+	// PublicURL is where users reach the HTTP API
+	LineLimit  int
+	PublicURL  string
+	pastes     map[string]*Paste
+	pasteOrder []string
 }
 
 // Room is the data strucutre used for a Chat Room, it keeps a map of all connected clients
 type Room struct {
 	mu      sync.Mutex
+	Name    string
+	Display string
+	Owner   string
+	Topic   string
+	MOTD    string
+	Public  bool
+	Bans    map[string]bool
+	Ops     map[string]bool
+	Poll    *Poll
+	TTL     time.Duration
+	Notices bool
+	History []Event
 	Clients map[string]*Client
+
+	// Spam is how readily messages are taken for spam, empty when they
+	// aren't screened, SpamDrop drops them instead of holding them in Held
+	// for review
+	Spam     string
+	SpamDrop bool
+	Held     []Event
+
+	// Created is when the room was made, Peak the most members it had at
+	// once, and Messages how many messages were sent to it
+	Created  time.Time
+	Peak     int
+	Messages int64
+
+	// LineLimit is how many characters a message may have before it is
+	// pasted, 0 is the server's limit
+	LineLimit int
+
+	// Reactions are the nicks who reacted to a message of the history with
+	// each emoji
+	Reactions map[int64]map[string][]string
+
+	// PublicSince is the last event ID when the room went public, only
+	// what came after it is shown to those outside the room
+	PublicSince int64
 }
 
-// CloseClient accpets a client pointer, closes the connection, and deletes it from the Clients map
-func (s *Server) CloseClient(cl *Client) {
+// CloseClient accpets a client pointer and closes one of its connections
+// after saying goodbye on it, when the last connection is closed the room is
+// told the client quit, with the reason if one is given, and the client is
+// deleted from the Clients map
+func (s *Server) CloseClient(cl *Client, conn *Conn, reason string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	cl.Conn.Close()
-	delete(s.Clients, cl.Nick())
+	cl.mu.Lock()
+	last := len(cl.Conns) <= 1
+	cl.mu.Unlock()
+
+	if last {
+		if r, err := s.findRoom(cl); err == nil {
+			ev := Event{
+				Type: EventQuit,
+				Time: time.Now().Format(time.RFC3339),
+				From: cl.Nick(),
+				Room: r.Name,
+				Text: reason,
+			}
+			for _, c := range r.Clients {
+				if c != cl {
+					c.Send(ev)
+				}
+			}
+			s.emit(ev)
+		}
+	}
+
+	if conn != nil {
+		atomic.StoreInt32(&conn.quit, 1)
+		conn.sendClose(conn.Render(Event{Type: EventText, Text: fmt.Sprintf("Goodbye [%s]\r\n", cl.Nick())}))
+		cl.mu.Lock()
+		cl.Conns = removeConn(cl.Conns, conn)
+		cl.mu.Unlock()
+	}
+	if last {
+		delete(s.Sessions, cl.Token())
+		s.tryDeleteFromRoom(cl)
+		s.removeClient(cl)
+	}
+}
+
+// Quitting returns true once the connection was closed by /quit
+func (c *Conn) Quitting() bool {
+	return atomic.LoadInt32(&c.quit) == 1
 }
 
 // ChangeNick valides if the nick is in use
@@ -110,14 +503,43 @@ func (s *Server) CloseClient(cl *Client) {
 func (s *Server) ChangeNick(from, to string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.changeNick(from, to)
+}
 
-	// if the name we are changing TO exists, error
-	if s.clientExists(to) {
+// changeNick is a helper function that doesn't lock
+func (s *Server) changeNick(from, to string) error {
+	// if the name we are changing TO exists in any casing, error, though a
+	// client may change the casing of its own nick
+	if to == from || s.nickInUse(to, s.Clients[from]) {
 		e := errors.New(fmt.Sprintf("user [%s] already exists\r\n", to))
 		errl(e, "user already exists")
 		return e
 	}
 
+	if err := validNick(to); err != nil {
+		return err
+	}
+
+	// bot nicks are reserved and bots keep theirs
+	if s.isBotName(to) {
+		return fmt.Errorf("nick [%s] belongs to a bot\r\n", to)
+	}
+	if cl, ok := s.Clients[from]; ok && cl.Bot() {
+		return errors.New("bots can't change nick\r\n")
+	}
+
+	// registered nicks may only be taken by their owner
+	if cl, ok := s.Clients[from]; ok && s.Accounts.Exists(to) && foldNick(cl.Account()) != foldNick(to) {
+		e := errors.New(fmt.Sprintf("nick [%s] is registered, use /login\r\n", to))
+		errl(e, "nick is registered")
+		return e
+	}
+
+	// reserved nicks may only be taken once logged in
+	if cl, ok := s.Clients[from]; ok && cl.Account() == "" && s.reservedNick(to) {
+		return fmt.Errorf("nick [%s] is reserved, /login to take it\r\n", to)
+	}
+
 	// the client should exist
 	if s.clientExists(from) {
 		// if the name we are changing FROM exists, proceed
@@ -130,9 +552,21 @@ func (s *Server) ChangeNick(from, to string) error {
 
 		delete(r.Clients, from)
 		delete(s.Clients, from)
-		cl.nick = to
+		cl.setNick(to)
 		r.Clients[to] = cl
 		s.Clients[to] = cl
+		if cl.Account() == "" {
+			s.handOver(from, to)
+		}
+		s.watchNotify(from, false)
+		s.watchNotify(to, true)
+		s.emit(Event{
+			Type: EventNick,
+			Time: time.Now().Format(time.RFC3339),
+			From: from,
+			To:   to,
+			Room: r.Name,
+		})
 	} else {
 		e := errors.New(fmt.Sprintf("user [%s] does not exists\r\n", to))
 		errl(e, "user does not exists")
@@ -146,54 +580,196 @@ func (s *Server) ChangeNick(from, to string) error {
 func (s *Server) Message(inputs []string, cl *Client) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.message(cl, strings.Join(inputs, " "), 0)
+}
 
-	msg := fmt.Sprintf("[%s:%s]", time.Now().Format(time.RFC3339), cl.Nick())
-	for _, v := range inputs {
-		msg = fmt.Sprintf("%s %s", msg, v)
-	}
-	msg = msg + "\r\n"
-
+// message is a helper function that doesn't lock, a parent other than 0
+// makes the message a reply to an earlier message of the room
+func (s *Server) message(cl *Client, text string, parent int64) error {
 	r, err := s.findRoom(cl)
 	if err != nil {
 		return err
 	}
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
 
-	if r != nil {
-		for _, c := range r.Clients {
-			c.Write(strings.TrimSpace(msg) + "\r\n")
+	ev := Event{
+		Type: EventMessage,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Room: r.Name,
+		Text: text,
+	}
+	if parent != 0 {
+		p, ok := r.find(parent)
+		if !ok {
+			return fmt.Errorf("message [%d] is not in room [%s]\r\n", parent, r.Name)
 		}
+		ev.Parent, ev.Quote = parent, quote(p)
+	}
+	ev.ID = s.nextID()
+	if !s.spam(r, cl, ev) {
+		s.post(r, s.paste(r, ev))
 	}
 	return nil
 }
 
-// Blast sends a message to every client connected to the server
+// post delivers the message to the room and records it, it must be called
+// with the server lock held
+func (s *Server) post(r *Room, ev Event) {
+	for _, c := range r.Clients {
+		c.Send(ev)
+	}
+	r.record(ev)
+	s.emit(ev)
+	s.notifyMentions(ev)
+	s.notifyKeywords(r, ev)
+	s.Previews.Fire(ev)
+}
+
+// Direct sends a private message to a single client
+// example: /msg batman the joker is loose
+func (s *Server) Direct(inputs []string, cl *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+
+	return s.direct(cl, Event{
+		Type: EventDirect,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		To:   inputs[1],
+		Text: strings.Join(inputs[2:], " "),
+	})
+}
+
+// direct delivers a private message to ev.To, it must be called with the
+// server lock held
+func (s *Server) direct(cl *Client, ev Event) error {
+	ev.ID = s.nextID()
+	to := ev.To
+	// the server can't read encrypted messages, so neither can notifications
+	notice := ev.Text
+	if ev.Encrypted {
+		notice = "an encrypted message"
+	}
+
+	if c, ok := s.Clients[to]; ok {
+		c.Send(ev)
+		if c != cl {
+			cl.Send(ev)
+			s.delivered(ev, c, cl)
+		}
+		s.emit(ev)
+		s.notifyOffline(c.Account(), fmt.Sprintf("Message from %s", ev.From), notice)
+		return nil
+	}
+
+	if s.remoteNick(to) {
+		cl.Send(ev)
+		s.emit(ev)
+		return nil
+	}
+
+	if s.notifyOffline(to, fmt.Sprintf("Message from %s", ev.From), notice) {
+		cl.Write(fmt.Sprintf("user [%s] is offline, a notification was sent\r\n", to))
+		return nil
+	}
+
+	return fmt.Errorf("user [%s] does not exist\r\n", to)
+}
+
+// Blast sends a message to every connected client, admins may always
+// blast, registered users only once an interval when the policy allows,
+// every blast is logged
 // example: servide will be stopped for service in 45 minutes
-func (s *Server) Blast(inputs []string, cl *Client) {
+func (s *Server) Blast(inputs []string, cl *Client) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	msg := fmt.Sprintf("[%s:%s]", time.Now().Format(time.RFC3339), cl.Nick())
-	for _, v := range inputs[1:] {
-		msg = fmt.Sprintf("%s %s", msg, v)
+
+	if !s.isAdmin(cl) {
+		if err := s.probation(cl, "blast"); err != nil {
+			return err
+		}
+		policy, every := s.blastPolicy()
+		a := cl.Account()
+		switch {
+		case policy != BlastLimited:
+			return fmt.Errorf("only %s can use %s\r\n", rolePlurals[RoleAdmin], "/blast")
+		case a == "":
+			return fmt.Errorf("only %s can use %s, /register first\r\n", rolePlurals[RoleUser], "/blast")
+		}
+		if wait := every - time.Since(s.lastBlast[a]); wait > 0 {
+			return fmt.Errorf("you can blast again in %s\r\n", wait.Round(time.Second))
+		}
+		if s.lastBlast == nil {
+			s.lastBlast = make(map[string]time.Time)
+		}
+		s.lastBlast[a] = time.Now()
+	}
+
+	ev := Event{
+		Type: EventBlast,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Text: strings.Join(inputs[1:], " "),
 	}
-	msg = msg + "\r\n"
+	s.logMod(cl.Nick(), "blast", truncate(ev.Text, 80), "")
+	errl(nil, fmt.Sprintf("Blast from [%s]: %s", cl.Nick(), ev.Text))
 
 	for _, c := range s.Clients {
-		c.Write(strings.TrimSpace(msg) + "\r\n")
+		c.Send(ev)
 	}
+	s.emit(ev)
+	return nil
 }
 
 // JoinRoom is a public function for joining the room
-func (s *Server) JoinRoom(roomname string, cl *Client) error {
+func (s *Server) JoinRoom(name string, cl *Client) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := validRoom(name); err != nil {
+		return err
+	}
+	roomname := roomKey(name)
+	if r, ok := s.Rooms[roomname]; ok && r.banned(cl) {
+		return fmt.Errorf("you are banned from room [%s]\r\n", roomname)
+	}
+	if !s.roomExists(roomname) && roomname != DefaultRoom {
+		if err := s.probation(cl, "create rooms"); err != nil {
+			return err
+		}
+	}
+
 	s.tryDeleteFromRoom(cl)
 
-	err := s.joinRoom(roomname, cl)
+	err := s.joinRoom(name, cl)
 	if err != nil {
 		return err
 	}
 
+	ev := Event{
+		Type: EventJoin,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Room: roomname,
+	}
+	s.emit(ev)
+
+	// bots are told who joins their room so they can greet or op them
+	for _, c := range s.Rooms[roomname].Clients {
+		if c != cl && c.Bot() {
+			c.Send(ev)
+		}
+	}
+	if away := cl.Away(); away != "" {
+		s.Gossip.SetAway(cl.Nick(), away)
+	}
 	return nil
 }
 
@@ -213,34 +789,82 @@ func (s *Server) roomExists(roomname string) bool {
 	return false
 }
 
-// addClient accpets accepts a client and adds it to the Server's Client map
+// addClient accpets accepts a client and adds it to the Server's Client map,
+// refusing it if its nick is in use in any casing
 func (s *Server) addClient(cl *Client) error {
-	if !s.clientExists(cl.Nick()) {
+	if c, ok := s.findNick(cl.Nick()); !ok || c == cl {
 		s.Clients[cl.Nick()] = cl
+		if !ok {
+			s.watchNotify(cl.Nick(), true)
+		}
 		return nil
 	}
 
 	return errors.New("Client already exists")
 }
 
+// removeClient removes the client from the server, it must be called with
+// the server lock held
+func (s *Server) removeClient(cl *Client) {
+	if s.Clients[cl.Nick()] == cl {
+		delete(s.Clients, cl.Nick())
+		s.watchNotify(cl.Nick(), false)
+		if cl.Account() == "" {
+			s.handOver(cl.Nick(), "")
+		}
+	}
+}
+
+// handOver moves the rooms owned by the guest nick from to the nick to, an
+// empty to leaves them without an owner, guests own rooms by nick so they
+// can't be left to whoever takes the nick next, it must be called with the
+// server lock held
+func (s *Server) handOver(from, to string) {
+	for _, r := range s.Rooms {
+		if r.Owner == from {
+			s.changeLocked(raftCommand{Op: raftRoom, Room: r.Name, Owner: to, Fields: []string{"owner"}})
+		}
+	}
+}
+
 func (s *Server) createRoom(roomname string) *Room {
 	r := &Room{
+		Name:    roomname,
+		Display: roomname,
+		Bans:    make(map[string]bool),
+		Ops:     make(map[string]bool),
 		Clients: make(map[string]*Client),
+		Created: time.Now(),
+	}
+	if roomname == DefaultRoom {
+		r.Display = defaultRoomDisplay
 	}
 	s.Rooms[roomname] = r
 	return r
 }
 
-// joinRoom is a helper function that doesn't lock
-func (s *Server) joinRoom(roomname string, cl *Client) error {
+// joinRoom is a helper function that doesn't lock, a room it creates keeps
+// name as it was given for display
+func (s *Server) joinRoom(name string, cl *Client) error {
 	var r *Room
+	roomname := roomKey(name)
 	if !s.roomExists(roomname) {
 		r = s.createRoom(roomname)
+		if roomname != DefaultRoom {
+			owner := cl.Nick()
+			if a := cl.Account(); a != "" {
+				owner = a
+			}
+			s.changeLocked(raftCommand{Op: raftRoom, Room: roomname, Display: roomDisplay(name), Owner: owner, Fields: []string{"display", "owner"}})
+		}
 	} else {
 		r = s.Rooms[roomname]
 	}
 
 	r.Clients[cl.Nick()] = cl
+	if len(r.Clients) > r.Peak {
+		r.Peak = len(r.Clients)
+	}
 	err := s.addClient(cl)
 	if err != nil {
 		return err
@@ -252,7 +876,14 @@ func (s *Server) joinRoom(roomname string, cl *Client) error {
 func (s *Server) tryDeleteFromRoom(cl *Client) {
 	r, _ := s.findRoom(cl)
 	if r != nil {
+		s.sawLast(cl)
 		delete(r.Clients, cl.Nick())
+		s.emit(Event{
+			Type: EventLeave,
+			Time: time.Now().Format(time.RFC3339),
+			From: cl.Nick(),
+			Room: r.Name,
+		})
 	}
 }
 
@@ -268,14 +899,42 @@ func (s *Server) findRoom(cl *Client) (*Room, error) {
 }
 
 // clientRun is the method that a client runs while it waits for, and then processes, input
-func clientRun(cl *Client, buf *bufio.Reader) {
+func clientRun(cl *Client, conn *Conn, buf *bufio.Reader) {
+	// a panic handling input only drops this connection, the session can
+	// still be resumed
+	defer func() {
+		if p := recover(); p != nil {
+			errl(fmt.Errorf("panic serving [%s]: %v\n%s", cl.Nick(), p, debug.Stack()), "")
+			conn.sendClose("Something went wrong, you were disconnected\r\n")
+			Serv.Detach(cl, conn)
+			Serv.Plugins.Disconnect(cl.Nick())
+		}
+	}()
+
 	for {
 
+		Serv.readDeadline(cl, conn)
 		cmd, err := buf.ReadString('\n')
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			fmt.Printf("Client timed out.\n")
+			Serv.TimeOut(cl, conn)
+			Serv.Plugins.Disconnect(cl.Nick())
+			break
+		}
 		if err != nil {
 			fmt.Printf("Client disconnected.\n")
+			Serv.Detach(cl, conn)
+			Serv.Plugins.Disconnect(cl.Nick())
 			break
 		}
+		conn.Touch()
+		Serv.Active(cl)
+
+		// lines of a multi-line paste are collected, not run
+		if conn.Capturing() {
+			Serv.captureLine(cl, conn, cmd)
+			continue
+		}
 
 		// split up the inputs
 		inputs := strings.Fields(cmd)
@@ -283,78 +942,122 @@ func clientRun(cl *Client, buf *bufio.Reader) {
 		// if command is empty, do not process
 		if len(inputs) == 0 {
 			cl.Write("Command not recognized\r\n")
-		} else {
-			switch inputs[0] {
-			case "/help":
-				out := fmt.Sprintf(banner, cl.Nick())
-				cl.Write(out)
-			case "/quit":
-				Serv.CloseClient(cl)
-			case "/blast":
-				Serv.Blast(inputs, cl)
-			case "/room":
-				if len(inputs) >= 2 {
-					var roomname string
-					for _, v := range inputs[1:] {
-						roomname = fmt.Sprintf("%s%s", roomname, v)
-					}
-					Serv.JoinRoom(strings.ToLower(roomname), cl)
-					resp := fmt.Sprintf("Joining room %s\r\n", strings.ToLower(roomname))
-					cl.Write(resp)
-				} else {
-					resp := fmt.Sprintf("Unable to join room\r\n")
-					cl.Write(resp)
-				}
-			case "/nick":
-				if len(inputs) >= 2 {
-					from := cl.Nick()
-					to := inputs[1]
-					err := Serv.ChangeNick(from, to)
-					resp := fmt.Sprintf("Nick changed from [%s] to [%s]\r\n", from, to)
-					if err != nil {
-						cl.Write(err.Error())
-					} else {
-						cl.Write(resp)
-					}
-				} else {
-					resp := fmt.Sprintf("Nick unchanged and is currently [%s] \r\n", cl.Nick())
-					cl.Write(resp)
-				}
-			default:
-				err := Serv.Message(inputs, cl)
-				errl(err, "Message sent to room successfully")
-			}
+			continue
+		}
+
+		recorder().Input(cl, inputs)
+		in := &Input{Client: cl, Conn: conn, Args: inputs}
+		if strings.HasPrefix(inputs[0], "/") {
+			in.Command = inputs[0]
 		}
+		Serv.Inbound.Run(in, func(in *Input) {
+			Serv.Dispatch(in)
+			cl = in.Client
+		})
 
+		// the connection was closed by /quit, stop reading from it
+		if conn.Quitting() {
+			fmt.Printf("Client quit.\n")
+			Serv.Plugins.Disconnect(cl.Nick())
+			break
+		}
 	}
 }
 
 // initClient is a helper function that sets up the client
 // TODO handle the errors, derp
 func initClient(conn net.Conn) {
-	buf := bufio.NewReader(conn)
-	uname := fmt.Sprintf("%s%d", "user", time.Now().UnixNano())
-	cl := &Client{nick: uname, Conn: conn}
+	tr := newTelnetReader(conn, conn, Serv.Telnet)
+	if Serv.Telnet {
+		conn.Write(askNAWS)
+	}
+	buf := bufio.NewReader(tr)
+	listed := Serv.Blocklists.Check(addrIP(conn.RemoteAddr()))
+	if len(listed) > 0 {
+		errl(nil, fmt.Sprintf("%s is listed on %s", logAddr(conn.RemoteAddr()), strings.Join(listed, ", ")))
+		if Serv.Blocklists.Rejects() {
+			conn.Write([]byte("Connections from your address are not accepted\r\n"))
+			conn.Close()
+			return
+		}
+	}
+	if !Serv.Admit(conn, buf) {
+		conn.Close()
+		return
+	}
+	uname := Serv.guestNick()
+	cn := NewConn(conn)
+	cn.listed = listed
+	tr.report(cn.SetWidth)
+	// negotiation answers from here on queue behind the session's output
+	tr.w = cn
+	cn.limit = newRateLimiter(Serv.rateLimit())
+	if period, rate := Serv.probationRules(); period > 0 {
+		cn.probation = newRateLimiter(rate)
+	}
+	cl := &Client{nick: uname, Conns: []*Conn{cn}}
 	err := Serv.JoinRoom(DefaultRoom, cl)
 	errl(err, "Joined room")
-	cl.Write(fmt.Sprintf(banner, uname))
-	clientRun(cl, buf)
+	cl.Write(Serv.Banner(uname, cl.Lang()))
+	if Serv.restricted(cl) {
+		cl.Write("Your address is listed, /login to a registered account to talk\r\n")
+	}
+	if summary, err := Serv.RoomSummary(cl); err == nil {
+		cl.Write(summary)
+	}
+	Serv.Plugins.Connect(uname)
+	token, err := Serv.NewSession(cl)
+	if err != nil {
+		errl(err, "")
+	} else {
+		cl.Write(fmt.Sprintf("Your session token is [%s], use /resume %s to restore this session within %s\r\n", token, token, Serv.ResumeWindow))
+	}
+	clientRun(cl, cn, buf)
 }
 
 func NewServer() *Server {
-	return &Server{
-		Clients: make(map[string]*Client),
-		Rooms:   make(map[string]*Room),
+	s := &Server{
+		Clients:      make(map[string]*Client),
+		Rooms:        make(map[string]*Room),
+		Sessions:     make(map[string]*Client),
+		Muted:        make(map[string]bool),
+		Offenses:     NewOffenses(),
+		Quotas:       NewQuotas(),
+		Accounts:     NewAccountStore(""),
+		ResumeWindow: DefaultResumeWindow,
+		RateLimit:    DefaultRateLimit,
+		LineLimit:    DefaultLineLimit,
+		Commands:     NewRegistry(),
+		exit:         exitProcess,
+		started:      time.Now(),
 	}
-
+	s.Reminders = NewReminders(s, "")
+	s.Spam = NewSpamFilter("")
+	s.registerBuiltins()
+	s.Inbound = s.newInbound()
+	return s
 }
+
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-version" || os.Args[1] == "--version") {
+		fmt.Println(Version())
+		return
+	}
+
 	// working directory
 	cwd, err := os.Getwd()
 	if err != nil {
 		panic("unable to detect current working directory")
 	}
 
+	// a config file sets the same variables as the environment
+	tcConfig := os.Getenv("TCConfig")
+	if len(tcConfig) > 0 {
+		if err := LoadConfig(tcConfig); err != nil {
+			panic(fmt.Sprintf("unable to load config: %v", err))
+		}
+	}
+
 	// env variables
 	tcLog := os.Getenv("TCLogPath")
 	if len(tcLog) == 0 {
@@ -363,6 +1066,33 @@ func main() {
 		tcLog = path.Join(tcLog, logName)
 	}
 
+	tcData := os.Getenv("TCData")
+	if len(tcData) == 0 {
+		tcData = cwd
+	}
+
+	key, err := loadDataKey(os.Getenv("TCDataKeyFile"), os.Getenv("TCDataKey"))
+	if err == nil {
+		err = setDataKey(key)
+	}
+	if err != nil {
+		log.Fatalf("error loading data key: %v", err)
+	}
+	setDataMigrate(os.Getenv("TCDataMigrate") == "on")
+
+	// -open prints a log sealed with the data key
+	if len(os.Args) > 2 && os.Args[1] == "-open" {
+		lf, err := os.Open(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lf.Close()
+		if err := openLog(os.Stdout, lf); err != nil {
+			log.Fatalf("error opening log: %v", err)
+		}
+		return
+	}
+
 	tcPort := os.Getenv("TCPort")
 	if len(tcPort) == 0 {
 		tcPort = "8091"
@@ -373,8 +1103,10 @@ func main() {
 		tcHost = "localhost"
 	}
 
+	tcHTTPPort := os.Getenv("TCHTTPPort")
+
 	// logfile
-	f, err := os.OpenFile(tcLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	f, err := OpenRotatingFile(tcLog)
 	if err != nil {
 		log.Fatalf("error opening file: %v", err)
 	}
@@ -384,14 +1116,423 @@ func main() {
 
 	// instantiate server
 	Serv = NewServer()
+	Serv.ConfigPath = tcConfig
+	if err := Serv.applyConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if tcGate := os.Getenv("TCGate"); len(tcGate) > 0 {
+		mode, bits, err := parseGate(tcGate, os.Getenv("TCGateBits"))
+		if err != nil {
+			log.Fatalf("error parsing TCGate: %s", strings.TrimSpace(err.Error()))
+		}
+		Serv.SetGate("config", mode, bits)
+	}
+	tcDNSBL, tcTor := splitList(os.Getenv("TCDNSBL")), os.Getenv("TCTorExitList")
+	if len(tcDNSBL) > 0 || len(tcTor) > 0 {
+		if tcTor == "on" {
+			tcTor = DefaultTorExitList
+		}
+		Serv.Blocklists, err = NewBlocklists(tcDNSBL, tcTor, os.Getenv("TCListedPolicy"))
+		if err != nil {
+			log.Fatalf("error setting up blocklists: %v", err)
+		}
+		if len(tcTor) > 0 {
+			go Serv.Blocklists.RunTor()
+		}
+	}
+
+	if tcGeoIP := os.Getenv("TCGeoIPDB"); len(tcGeoIP) > 0 {
+		if Serv.GeoIP, err = OpenGeoIP(tcGeoIP); err != nil {
+			log.Fatalf("error loading GeoIP database: %v", err)
+		}
+	}
+
+	Serv.Accounts = NewAccountStore(path.Join(tcData, accountsName))
+	if err := Serv.Accounts.Load(); err != nil {
+		log.Fatalf("error loading accounts: %v", err)
+	}
+
+	Serv.Reminders = NewReminders(Serv, path.Join(tcData, remindersName))
+	if err := Serv.Reminders.Load(); err != nil {
+		log.Fatalf("error loading reminders: %v", err)
+	}
+	go Serv.Reminders.Run()
+
+	Serv.Spam = NewSpamFilter(path.Join(tcData, spamName))
+	if err := Serv.Spam.Load(); err != nil {
+		log.Fatalf("error loading spam filter: %v", err)
+	}
+	go Serv.Offenses.Run()
+	go Serv.Quotas.Run()
+	go Serv.RunExpiry()
+	go Serv.RunAutoAway()
+
+	Serv.SnapshotPath = os.Getenv("TCSnapshot")
+	if len(Serv.SnapshotPath) == 0 {
+		Serv.SnapshotPath = path.Join(tcData, snapshotName)
+	}
+	if err := Serv.Restore(Serv.SnapshotPath); err != nil {
+		log.Fatalf("error restoring snapshot: %v", err)
+	}
+
+	if tcHooks := os.Getenv("TCWebhooks"); len(tcHooks) > 0 {
+		events := splitList(os.Getenv("TCWebhookEvents"))
+		Serv.Hooks = NewWebhooks(splitList(tcHooks), events, os.Getenv("TCWebhookSecret"))
+	}
+
+	Serv.HookTokens = parseTokens(os.Getenv("TCHookTokens"))
+	Serv.SearchTokens = parseSearchTokens(os.Getenv("TCSearchTokens"))
+
+	Serv.Telnet = os.Getenv("TCTelnet") == "on"
+
+	Serv.PublicURL = os.Getenv("TCPublicURL")
+	if len(Serv.PublicURL) == 0 && len(tcHTTPPort) > 0 {
+		Serv.PublicURL = "http://" + net.JoinHostPort(tcHost, tcHTTPPort)
+	}
+
+	if tcLimit := os.Getenv("TCLineLimit"); len(tcLimit) > 0 {
+		n, err := parseLineLimit(tcLimit)
+		if err != nil {
+			log.Fatalf("error parsing TCLineLimit: %v", err)
+		}
+		Serv.LineLimit = n
+	}
+
+	if tcFiles := os.Getenv("TCFiles"); len(tcFiles) > 0 {
+		size, ttl, err := parseFiles()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if Serv.Files, err = OpenFiles(tcFiles, Serv.PublicURL, size, ttl); err != nil {
+			log.Fatalf("error opening files: %v", err)
+		}
+		Serv.ImageTypes = parseImageTypes()
+		go Serv.Files.Run()
+	}
+
+	if os.Getenv("TCPreviews") == "on" {
+		Serv.Previews = NewPreviews(Serv.Deliver, publicIP)
+	}
+
+	allowPush := publicIP
+	if os.Getenv("TCPushPrivate") == "on" {
+		allowPush = func(net.IP) bool { return true }
+	}
+	Serv.Push = NewPusher(allowPush)
+
+	if tcNATS := os.Getenv("TCNATSURL"); len(tcNATS) > 0 {
+		subject := os.Getenv("TCNATSSubject")
+		if len(subject) == 0 {
+			subject = "tinychat.events"
+		}
+		n, err := NewNATS(tcNATS, subject)
+		if err != nil {
+			log.Fatalf("error parsing TCNATSURL: %v", err)
+		}
+		e := NewExporter("nats", n)
+		e.Direct = os.Getenv("TCExportDirect") == "on"
+		Serv.Exports = append(Serv.Exports, e)
+	}
+
+	if tcKafka := os.Getenv("TCKafkaBroker"); len(tcKafka) > 0 {
+		topic := os.Getenv("TCKafkaTopic")
+		if len(topic) == 0 {
+			topic = "tinychat-events"
+		}
+		e := NewExporter("kafka", NewKafka(tcKafka, topic))
+		e.Direct = os.Getenv("TCExportDirect") == "on"
+		Serv.Exports = append(Serv.Exports, e)
+	}
+
+	if tcSMTP := os.Getenv("TCSMTPHost"); len(tcSMTP) > 0 {
+		tcSMTPPort := os.Getenv("TCSMTPPort")
+		if len(tcSMTPPort) == 0 {
+			tcSMTPPort = "587"
+		}
+
+		var auth smtp.Auth
+		if user := os.Getenv("TCSMTPUser"); len(user) > 0 {
+			auth = smtp.PlainAuth("", user, os.Getenv("TCSMTPPass"), tcSMTP)
+		}
+
+		interval := DefaultDigestInterval
+		if tcDigest := os.Getenv("TCDigestInterval"); len(tcDigest) > 0 {
+			interval, err = time.ParseDuration(tcDigest)
+			if err != nil {
+				log.Fatalf("error parsing TCDigestInterval: %v", err)
+			}
+		}
+
+		Serv.Mail = NewMailer(net.JoinHostPort(tcSMTP, tcSMTPPort), os.Getenv("TCSMTPFrom"), auth, interval)
+	}
+
+	if tcRaftPeers := os.Getenv("TCRaftPeers"); len(tcRaftPeers) > 0 {
+		id := os.Getenv("TCRaftID")
+		peers, err := parsePeers(tcRaftPeers, id)
+		if err != nil || len(id) == 0 {
+			log.Fatalf("error parsing TCRaftPeers, TCRaftID must name this node: %v", err)
+		}
+
+		// the RPCs replicate accounts, they are only served on the address
+		// given and sealed with the secret every node shares
+		tcRaftAddr := os.Getenv("TCRaftAddr")
+		if len(tcRaftAddr) == 0 {
+			log.Fatal("error starting raft: TCRaftAddr must be set")
+		}
+		key, err := newClusterKey(os.Getenv("TCRaftSecret"))
+		if err != nil {
+			log.Fatalf("error starting raft, TCRaftSecret must be set: %v", err)
+		}
+
+		Serv.Raft = NewRaft(Serv, id, peers, path.Join(tcData, raftName), key)
+		if err := Serv.Raft.Load(); err != nil {
+			log.Fatalf("error loading raft state: %v", err)
+		}
+		Serv.Raft.Start()
+
+		go func() {
+			err := http.ListenAndServe(tcRaftAddr, Serv.Raft.Handler())
+			errl(err, "")
+		}()
+	}
+
+	if tcLinkName := os.Getenv("TCLinkName"); len(tcLinkName) > 0 {
+		links, err := NewLinks(Serv, tcLinkName, os.Getenv("TCLinkPassword"))
+		if err != nil {
+			log.Fatalf("error starting links, TCLinkPassword must be set: %v", err)
+		}
+		Serv.Links = links
+		if tcLinkListen := os.Getenv("TCLinkListen"); len(tcLinkListen) > 0 {
+			if err := Serv.Links.Listen(tcLinkListen); err != nil {
+				log.Fatalf("error listening for links: %v", err)
+			}
+		}
+		if tcLinkHub := os.Getenv("TCLinkHub"); len(tcLinkHub) > 0 {
+			go Serv.Links.Connect(tcLinkHub)
+		}
+	}
+
+	if tcGossip := os.Getenv("TCGossipAddr"); len(tcGossip) > 0 {
+		node := os.Getenv("TCNodeID")
+		if len(node) == 0 {
+			node = tcGossip
+		}
+		key, err := newClusterKey(os.Getenv("TCGossipSecret"))
+		if err != nil {
+			log.Fatalf("error starting gossip, TCGossipSecret must be set: %v", err)
+		}
+		Serv.Gossip = NewGossip(Serv, node, tcGossip, splitList(os.Getenv("TCGossipSeeds")), key)
+		if err := Serv.Gossip.Start(); err != nil {
+			log.Fatalf("error starting gossip: %v", err)
+		}
+	}
+
+	Serv.Accounts.Propose = func(a Account) error {
+		return Serv.change(raftCommand{Op: raftAccount, Account: &a})
+	}
+
+	if tcRecord := os.Getenv("TCRecord"); len(tcRecord) > 0 {
+		r, err := OpenRecorder(tcRecord)
+		if err != nil {
+			log.Fatalf("error opening transcripts: %v", err)
+		}
+		setRecorder(r)
+		defer r.Close()
+	}
+
+	if tcJournal := os.Getenv("TCJournal"); len(tcJournal) > 0 {
+		var ship *Kafka
+		if topic := os.Getenv("TCJournalTopic"); len(topic) > 0 {
+			ship = NewKafka(os.Getenv("TCKafkaBroker"), topic)
+		}
+		Serv.Journal, err = OpenJournal(Serv, tcJournal, ship)
+		if err != nil {
+			log.Fatalf("error opening journal: %v", err)
+		}
+	}
+
+	var plugins []Plugin
+	for _, spec := range splitList(os.Getenv("TCPlugins")) {
+		pl, err := LoadPlugin(spec)
+		if err != nil {
+			log.Fatalf("error loading plugin %s: %v", spec, err)
+		}
+		plugins = append(plugins, pl)
+		errl(nil, fmt.Sprintf("Loaded plugin [%s]", spec))
+	}
+	if tcScripts := os.Getenv("TCScripts"); len(tcScripts) > 0 {
+		scripts, err := LoadScripts(Serv, tcScripts)
+		if err != nil {
+			log.Fatalf("error loading scripts: %v", err)
+		}
+		plugins = append(plugins, scripts)
+	}
+	if len(plugins) > 0 {
+		Serv.Plugins = NewPlugins(plugins...)
+	}
+
+	if tcBucket := os.Getenv("TCArchiveBucket"); len(tcBucket) > 0 {
+		endpoint := os.Getenv("TCArchiveEndpoint")
+		if len(endpoint) == 0 {
+			endpoint = "https://s3.amazonaws.com"
+		}
+		region := os.Getenv("TCArchiveRegion")
+		if len(region) == 0 {
+			region = "us-east-1"
+		}
+		interval := DefaultArchiveInterval
+		if v := os.Getenv("TCArchiveInterval"); len(v) > 0 {
+			interval, err = time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("error parsing TCArchiveInterval: %v", err)
+			}
+		}
+		store := NewS3(endpoint, region, tcBucket, os.Getenv("TCArchiveAccessKey"), os.Getenv("TCArchiveSecretKey"))
+		prefix := os.Getenv("TCArchivePrefix")
+		if v := os.Getenv("TCArchiveExpireDays"); len(v) > 0 {
+			days, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatalf("error parsing TCArchiveExpireDays: %v", err)
+			}
+			errl(store.SetExpiry(prefix, days), fmt.Sprintf("Archive under [%s] expires after %d day(s)", prefix, days))
+		}
+
+		archiver := NewArchiver(store, prefix, interval)
+		archiver.Add(f)
+		if Serv.Journal != nil {
+			archiver.Add(Serv.Journal)
+		}
+		go archiver.Run()
+	}
+
+	if tcRedis := os.Getenv("TCRedis"); len(tcRedis) > 0 {
+		node := os.Getenv("TCNodeID")
+		if len(node) == 0 {
+			hostname, _ := os.Hostname()
+			node = net.JoinHostPort(hostname, tcPort)
+		}
+		Serv.Cluster = NewCluster(Serv, node, tcRedis, os.Getenv("TCRedisPassword"))
+		Serv.Cluster.Advertise = os.Getenv("TCAdvertise")
+		if len(Serv.Cluster.Advertise) == 0 {
+			Serv.Cluster.Advertise = net.JoinHostPort(tcHost, tcPort)
+		}
+		Serv.Cluster.Start()
+	}
+
+	if tcXMPP := os.Getenv("TCXMPPServer"); len(tcXMPP) > 0 {
+		g := NewXMPPGateway(Serv, tcXMPP, os.Getenv("TCXMPPDomain"), os.Getenv("TCXMPPSecret"))
+		go g.Run()
+	}
+
+	if tcResume := os.Getenv("TCResumeWindow"); len(tcResume) > 0 {
+		d, err := time.ParseDuration(tcResume)
+		if err != nil {
+			log.Fatalf("error parsing TCResumeWindow: %v", err)
+		}
+		Serv.ResumeWindow = d
+	}
+
+	if len(tcHTTPPort) > 0 {
+		go func() {
+			err := http.ListenAndServe(net.JoinHostPort(tcHost, tcHTTPPort), Serv.HTTPHandler())
+			errl(err, "")
+		}()
+	}
+
+	if tcAdminPort := os.Getenv("TCAdminPort"); len(tcAdminPort) > 0 {
+		go func() {
+			err := http.ListenAndServe(net.JoinHostPort(tcHost, tcAdminPort), NewDashboard(Serv))
+			errl(err, "")
+		}()
+	}
+
+	if tcBotPort := os.Getenv("TCBotPort"); len(tcBotPort) > 0 {
+		bl, err := net.Listen("tcp", net.JoinHostPort(tcHost, tcBotPort))
+		if err != nil {
+			log.Fatalf("error listening for bots: %v", err)
+		}
+		go func() {
+			errl(acceptLoop(bl, initBot), "")
+		}()
+	}
+
+	if tcControl := os.Getenv("TCControlSocket"); len(tcControl) > 0 {
+		Serv.Control = NewControl(Serv, os.Getenv("TCControlToken"))
+		if err := Serv.Control.Listen(tcControl); err != nil {
+			log.Fatalf("error opening control socket: %v", err)
+		}
+		defer Serv.Control.Close()
+	}
 
 	uri := fmt.Sprintf("%s:%s", tcHost, tcPort)
 	ln, err := net.Listen("tcp", uri)
-	errl(err, "Server is ready.")
+	if err != nil {
+		log.Fatalf("error listening: %v", err)
+	}
+	errl(nil, "Server is ready, running "+Version()+".")
+	if err := acceptLoop(ln, initClient); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// maxAcceptBackoff is the longest acceptLoop waits after temporary errors
+const maxAcceptBackoff = time.Second
 
+// acceptLoop accepts connections on ln and sets each up with setup, unless
+// this node can't take clients right now, temporary errors such as running
+// out of file descriptors are retried after a growing pause, any other error
+// stops the loop and is returned
+func acceptLoop(ln net.Listener, setup func(net.Conn)) error {
+	var backoff time.Duration
 	for {
 		conn, err := ln.Accept()
-		errl(err, "Client connected successfully")
-		go initClient(conn)
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			errl(fmt.Errorf("accepting on %s: %v, retrying in %s", ln.Addr(), err, backoff), "")
+			time.Sleep(backoff)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("accepting on %s: %v", ln.Addr(), err)
+		}
+		backoff = 0
+		if ip := addrIP(conn.RemoteAddr()); !Serv.AllowedIP(ip) || !Serv.AllowedCountry(ip) {
+			errl(fmt.Errorf("refused connection from %s", logAddr(conn.RemoteAddr())), "")
+			conn.Close()
+			continue
+		}
+		if d, ok := Serv.Offenses.Banned(addrIP(conn.RemoteAddr()).String(), time.Now()); ok {
+			conn.Write([]byte(fmt.Sprintf("You are banned for another %s\r\n", d.Truncate(time.Second))))
+			conn.Close()
+			continue
+		}
+		errl(nil, "Client connected successfully")
+		if !Serv.Raft.Leader() {
+			conn.Write([]byte("This node is a standby, try again later\r\n"))
+			conn.Close()
+			continue
+		}
+		if Serv.Draining() {
+			conn.Write([]byte("This node is down for maintenance, try another node\r\n"))
+			conn.Close()
+			continue
+		}
+		go serveConn(conn, setup)
 	}
 }
+
+// serveConn sets up conn with setup, a panic before the client is running
+// is logged and closes the connection instead of the server
+func serveConn(conn net.Conn, setup func(net.Conn)) {
+	defer func() {
+		if p := recover(); p != nil {
+			errl(fmt.Errorf("panic setting up %s: %v\n%s", logAddr(conn.RemoteAddr()), p, debug.Stack()), "")
+			conn.Close()
+		}
+	}()
+	setup(conn)
+}
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestFindRoom(t *testing.T) {
@@ -40,6 +49,62 @@ func TestJoinRoom(t *testing.T) {
 
 }
 
+func TestRoomSummary(t *testing.T) {
+	serv := NewServer()
+
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	if _, err := serv.RoomSummary(batman); err == nil {
+		t.Errorf("expected error to NOT be nil")
+	}
+	serv.JoinRoom("gotham", robin)
+	serv.JoinRoom("gotham", batman)
+	serv.Rooms["gotham"].Topic = "the joker is loose"
+
+	want := "Joined room [gotham], the topic is [the joker is loose], 2 member(s): batman, robin\r\n"
+	if out, err := serv.RoomSummary(batman); err != nil || out != want {
+		t.Errorf("expected [%s], got [%s] %v", want, out, err)
+	}
+
+	for i := 0; i < maxSummaryNicks; i++ {
+		serv.JoinRoom("gotham", &Client{nick: fmt.Sprintf("goon%02d", i)})
+	}
+	out, _ := serv.RoomSummary(batman)
+	if !strings.HasSuffix(out, "goon18 and 2 more\r\n") {
+		t.Errorf("expected the list to be cut short, got [%s]", out)
+	}
+}
+
+func TestMOTD(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", robin)
+
+	if err := serv.SetMOTD(robin, "capes welcome"); err == nil {
+		t.Errorf("expected only the owner to set it")
+	}
+	if err := serv.SetMOTD(batman, strings.Repeat("na", maxMOTD)); err == nil {
+		t.Errorf("expected a long message to be refused")
+	}
+	if err := serv.SetMOTD(batman, "no capes in the batcave"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	alfred := &Client{nick: "alfred"}
+	serv.JoinRoom("batcave", alfred)
+	if out, _ := serv.RoomSummary(alfred); !strings.HasSuffix(out, "\r\nMessage of the day: no capes in the batcave\r\n") {
+		t.Errorf("expected the message of the day on join, got [%s]", out)
+	}
+	if serv.Rooms["batcave"].Topic != "" {
+		t.Errorf("expected the topic left alone")
+	}
+
+	serv.SetMOTD(batman, "")
+	if out, _ := serv.RoomSummary(alfred); strings.Contains(out, "Message of the day") {
+		t.Errorf("expected the message of the day cleared, got [%s]", out)
+	}
+}
+
 func TestChangeNick(t *testing.T) {
 	const otu = "oldTestUser"
 	const ntu = "newTestUser"
@@ -70,3 +135,264 @@ func TestChangeNick(t *testing.T) {
 	}
 
 }
+
+func TestQuit(t *testing.T) {
+	serv := NewServer()
+	c1, c2 := net.Pipe()
+	conn := NewConn(c1)
+	batman, robin := &Client{nick: "batman", Conns: []*Conn{conn}}, &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+
+	got := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(c2)
+		got <- string(b)
+	}()
+	serv.CloseClient(batman, conn, "brb")
+
+	if out := <-got; out != "Goodbye [batman]\r\n" {
+		t.Errorf("expected a goodbye, got [%s]", out)
+	}
+	if !conn.Quitting() || len(batman.Conns) != 0 {
+		t.Errorf("expected the connection to be closed and dropped")
+	}
+	ev := robin.unread[len(robin.unread)-1]
+	if ev.Type != EventQuit || ev.String() != "[batman] has quit: brb\r\n" {
+		t.Errorf("expected the room told of the quit, got %+v", ev)
+	}
+	if _, ok := serv.Clients["batman"]; ok {
+		t.Errorf("expected batman to be removed")
+	}
+	if _, ok := serv.Rooms["gotham"].Clients["batman"]; ok {
+		t.Errorf("expected batman to leave the room")
+	}
+}
+
+func TestNickCase(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+
+	for _, nick := range []string{"Batman", "BATMAN", "ｂａｔｍａｎ", "bat\u200bman"} {
+		if err := serv.ChangeNick("robin", nick); err == nil {
+			t.Errorf("expected [%q] to be taken", nick)
+		}
+	}
+	if err := serv.JoinRoom("gotham", &Client{nick: "BatMan"}); err == nil {
+		t.Errorf("expected a new client to be refused the nick in another casing")
+	}
+	if err := serv.ChangeNick("robin", "rob\u0301in"); err == nil {
+		t.Errorf("expected combining marks to be refused")
+	}
+
+	// registered and bot nicks are kept in any casing, online or not
+	serv.Accounts.Register("alfred", "hunter2")
+	serv.BotKeys = []BotKey{{Name: "Joker", Key: "k"}}
+	for _, nick := range []string{"Alfred", "ALFRED", "joker", "ＪＯＫＥＲ"} {
+		if err := serv.ChangeNick("robin", nick); err == nil {
+			t.Errorf("expected [%q] to be taken", nick)
+		}
+	}
+	if err := serv.Accounts.Register("Alfred", "hunter3"); err == nil {
+		t.Errorf("expected an account in another casing refused")
+	}
+
+	// the casing of one's own nick may change and is kept
+	if err := serv.ChangeNick("batman", "Batman"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if batman.Nick() != "Batman" || serv.Clients["Batman"] != batman {
+		t.Errorf("expected the display casing kept, got [%s]", batman.Nick())
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	Serv = NewServer()
+	Serv.Commands.Register(&Command{Name: "/boom", Run: func(in *Input) { panic("boom") }})
+
+	c1, c2 := net.Pipe()
+	conn := NewConn(c1)
+	batman := &Client{nick: "batman", Conns: []*Conn{conn}}
+	Serv.JoinRoom("gotham", batman)
+
+	done := make(chan bool)
+	go func() {
+		clientRun(batman, conn, bufio.NewReader(c1))
+		close(done)
+	}()
+	c2.Write([]byte("/boom\n"))
+	if out, _ := bufio.NewReader(c2).ReadString('\n'); out != "Something went wrong, you were disconnected\r\n" {
+		t.Errorf("expected to be told, got [%s]", out)
+	}
+	<-done
+
+	if Serv.HasClient("batman") || len(batman.Conns) != 0 {
+		t.Errorf("expected batman to be cleaned up")
+	}
+}
+
+func TestIdentityRace(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+
+	// readers race the renames, go test -race reports any unlocked access,
+	// more threads than CPUs interleave them even on a single core
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					batman.Nick()
+					batman.Token()
+					batman.Write("holy race condition\r\n")
+				}
+			}
+		}()
+	}
+
+	nick := "batman"
+	for i := 0; i < 500; i++ {
+		to := fmt.Sprintf("batman%d", i)
+		if err := serv.ChangeNick(nick, to); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+		nick = to
+		serv.NewSession(batman)
+	}
+	close(stop)
+	wg.Wait()
+
+	if batman.Nick() != "batman499" || serv.Clients["batman499"] != batman {
+		t.Errorf("expected the last rename to stick, got [%s]", batman.Nick())
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 50 * time.Millisecond
+
+	serv := NewServer()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	stuck := NewConn(c1)
+	joker, batman := &Client{nick: "joker", Conns: []*Conn{stuck}}, &Client{nick: "batman"}
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("gotham", batman)
+
+	// nobody reads c2, the message must not hang batman
+	sent := make(chan bool)
+	go func() {
+		serv.Message([]string{"to", "the", "batmobile"}, batman)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the write to give up")
+	}
+	if _, err := c1.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected the stuck connection to be closed")
+	}
+}
+
+func TestSendQueued(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	cn := NewConn(c1)
+
+	// nobody reads yet, sending must not wait on the client
+	sent := make(chan bool)
+	go func() {
+		cn.send("to the ")
+		cn.sendClose("batmobile\r\n")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("expected send not to wait for the client")
+	}
+
+	b, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "to the batmobile\r\n" {
+		t.Errorf("expected the queued writes in order before the close, got %q", b)
+	}
+	if err := cn.send("again\r\n"); err == nil {
+		t.Errorf("expected send on a closed connection to fail")
+	}
+}
+
+// flakyListener fails Accept with each error in turn
+type flakyListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+// tempError is a temporary network error, like running out of descriptors
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+func TestAcceptLoop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	fl := &flakyListener{Listener: ln, errs: []error{tempError{}, tempError{}, net.ErrClosed}}
+	start := time.Now()
+	err = acceptLoop(fl, func(net.Conn) { t.Errorf("expected no connection to be set up") })
+	if err == nil || !strings.Contains(err.Error(), ln.Addr().String()) {
+		t.Errorf("expected the closed listener to stop the loop, got %v", err)
+	}
+	if len(fl.errs) != 0 || time.Since(start) < 15*time.Millisecond {
+		t.Errorf("expected temporary errors to be retried after a pause")
+	}
+}
+
+func TestGuestRoomOwner(t *testing.T) {
+	serv := NewServer()
+	penguin := &Client{nick: "user1"}
+	serv.JoinRoom("iceberg lounge", penguin)
+
+	// the room follows the guest to its new nick
+	if err := serv.ChangeNick("user1", "penguin"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if owner := serv.Rooms["iceberg lounge"].Owner; owner != "penguin" {
+		t.Errorf("expected the room to follow the nick, got [%s]", owner)
+	}
+
+	// and isn't left to whoever takes the nick after the guest is gone
+	serv.Leave(penguin)
+	if owner := serv.Rooms["iceberg lounge"].Owner; owner != "" {
+		t.Errorf("expected the room released, got [%s]", owner)
+	}
+	impostor := &Client{nick: "penguin"}
+	serv.JoinRoom("iceberg lounge", impostor)
+	if err := serv.SetPublic(impostor, true); err == nil {
+		t.Errorf("expected the new holder of the nick refused the room")
+	}
+}
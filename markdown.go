@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ansiItalic = "\x1b[3m"
+	ansiCode   = "\x1b[36m"
+)
+
+// markStyles are the escapes of the *bold*, _italic_ and `code` spans
+var markStyles = map[byte]string{
+	'*': ansiBold,
+	'_': ansiItalic,
+	'`': ansiCode,
+}
+
+// markdown renders the *bold*, _italic_ and `code` spans of text with ANSI
+// escapes, a span must hug its text and not sit inside a word, so 2*3*4
+// and snake_case_names are left alone, spans don't nest
+func markdown(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		style, ok := markStyles[text[i]]
+		if !ok || !opens(text, i) {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		end := closes(text, i)
+		if end < 0 {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		b.WriteString(style + text[i+1:end] + ansiReset)
+		i = end + 1
+	}
+	return b.String()
+}
+
+// opens returns true if the marker at i may open a span, it must not
+// follow a letter, digit or another marker and must be followed by text
+func opens(text string, i int) bool {
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	next, _ := utf8.DecodeRuneInString(text[i+1:])
+	if i > 0 && (wordRune(prev) || prev == rune(text[i])) {
+		return false
+	}
+	return i+1 < len(text) && !unicode.IsSpace(next) && next != rune(text[i])
+}
+
+// closes returns the index of the marker closing the span opened at i, or
+// -1 if there is none
+func closes(text string, i int) int {
+	for j := i + 2; j < len(text); j++ {
+		if text[j] != text[i] {
+			continue
+		}
+		prev, _ := utf8.DecodeLastRuneInString(text[:j])
+		next, _ := utf8.DecodeRuneInString(text[j+1:])
+		if !unicode.IsSpace(prev) && (j+1 == len(text) || !wordRune(next) && next != rune(text[i])) {
+			return j
+		}
+	}
+	return -1
+}
+
+// wordRune returns true for the letters and digits markers can't touch
+func wordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	for text, want := range map[string]string{
+		"the *joker* is loose":      "the " + ansiBold + "joker" + ansiReset + " is loose",
+		"_really_ loose":            ansiItalic + "really" + ansiReset + " loose",
+		"run `go test` first":       "run " + ansiCode + "go test" + ansiReset + " first",
+		"`snake_case_name` is fine": ansiCode + "snake_case_name" + ansiReset + " is fine",
+		"snake_case_name stays":     "snake_case_name stays",
+		"2*3*4 is math":             "2*3*4 is math",
+		"a * b * c":                 "a * b * c",
+		"**not bold**":              "**not bold**",
+		"*unclosed":                 "*unclosed",
+		"(*bat*)":                   "(" + ansiBold + "bat" + ansiReset + ")",
+	} {
+		if got := markdown(text); got != want {
+			t.Errorf("expected [%q], got [%q]", want, got)
+		}
+	}
+}
+
+func TestMarkdownModes(t *testing.T) {
+	ev := Event{Type: EventMessage, Time: "now", From: "batman", Text: "the *joker*"}
+
+	c := NewConn(nil)
+	if out := c.Render(ev); !strings.Contains(out, "the *joker*") {
+		t.Errorf("expected markup to pass through plain output, got [%q]", out)
+	}
+	c.SetColor(true)
+	if out := c.Render(ev); !strings.Contains(out, ansiBold+"joker"+ansiReset) {
+		t.Errorf("expected bold with color on, got [%q]", out)
+	}
+	c.SetJSON(true)
+	if out := c.Render(ev); !strings.Contains(out, `"text":"the *joker*"`) {
+		t.Errorf("expected markup to pass through JSON, got [%q]", out)
+	}
+}
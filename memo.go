@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMemos is how many memos an account keeps
+const maxMemos = 50
+
+// Memo is a message left for a registered user while they were offline
+type Memo struct {
+	From string `json:"from"`
+	Time string `json:"time"`
+	Text string `json:"text"`
+	Read bool   `json:"read,omitempty"`
+}
+
+// String renders the memo for humans
+func (m Memo) String() string {
+	return fmt.Sprintf("[%s:%s] %s", m.Time, m.From, m.Text)
+}
+
+// SendMemo leaves a memo for an offline registered user, it is delivered
+// when they next log in
+// example: /memo batman the joker escaped arkham
+func (s *Server) SendMemo(inputs []string, cl *Client) error {
+	to := inputs[1]
+	memo := Memo{
+		From: cl.Nick(),
+		Time: time.Now().Format(time.RFC3339),
+		Text: strings.Join(inputs[2:], " "),
+	}
+
+	s.mu.Lock()
+	if err := s.silenced(cl); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !s.Accounts.Exists(to) {
+		s.mu.Unlock()
+		return fmt.Errorf("nick [%s] is not registered\r\n", to)
+	}
+	if !s.offline(to) {
+		s.mu.Unlock()
+		return fmt.Errorf("user [%s] is online, use /msg\r\n", to)
+	}
+	s.mu.Unlock()
+
+	var full bool
+	err := s.Accounts.Update(to, func(a *Account) {
+		if full = len(a.Memos) >= maxMemos; !full {
+			a.Memos = append(a.Memos, memo)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if full {
+		return fmt.Errorf("the memos of [%s] are full\r\n", to)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifyOffline(to, fmt.Sprintf("Memo from %s", memo.From), memo.Text)
+	return nil
+}
+
+// deliverMemos shows the client the memos left for its account since it
+// last logged in, and marks them read
+func (s *Server) deliverMemos(cl *Client) {
+	name := cl.Account()
+	if name == "" {
+		return
+	}
+	var unread []Memo
+	err := s.Accounts.Update(name, func(a *Account) {
+		for i := range a.Memos {
+			if !a.Memos[i].Read {
+				unread = append(unread, a.Memos[i])
+				a.Memos[i].Read = true
+			}
+		}
+	})
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	if len(unread) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("You have %d new memo(s), /memos to review them:\r\n", len(unread)))
+	for _, m := range unread {
+		b.WriteString(m.String() + "\r\n")
+	}
+	cl.Write(b.String())
+}
+
+// Memos lists the memos of the client's account, or deletes one of them, or
+// all of them
+// example: /memos delete 2
+func (s *Server) Memos(inputs []string, cl *Client) (string, error) {
+	name := cl.Account()
+	if name == "" {
+		return "", fmt.Errorf("you must be logged in to read memos\r\n")
+	}
+
+	if len(inputs) > 1 {
+		if inputs[1] != "delete" || len(inputs) < 3 {
+			return "", fmt.Errorf("Unable to manage memos, use /memos or /memos delete <number|all>\r\n")
+		}
+		var err error
+		uerr := s.Accounts.Update(name, func(a *Account) {
+			if inputs[2] == "all" {
+				a.Memos = nil
+				return
+			}
+			n, perr := strconv.Atoi(inputs[2])
+			if perr != nil || n < 1 || n > len(a.Memos) {
+				err = fmt.Errorf("[%s] is not one of your memos\r\n", inputs[2])
+				return
+			}
+			a.Memos = append(a.Memos[:n-1:n-1], a.Memos[n:]...)
+		})
+		if uerr != nil {
+			return "", uerr
+		}
+		if err != nil {
+			return "", err
+		}
+		return "Memo deleted\r\n", nil
+	}
+
+	a, ok := s.Accounts.Get(name)
+	if !ok {
+		return "", fmt.Errorf("nick [%s] is not registered\r\n", name)
+	}
+	if len(a.Memos) == 0 {
+		return "You have no memos\r\n", nil
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("You have %d memo(s):\r\n", len(a.Memos)))
+	for i, m := range a.Memos {
+		b.WriteString(fmt.Sprintf("%d. %s\r\n", i+1, m))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemos(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", joker)
+
+	if err := serv.SendMemo([]string{"/memo", "robin", "hi"}, joker); err == nil {
+		t.Errorf("expected a memo to an unregistered nick to fail")
+	}
+	for _, text := range []string{"catch me", "if you can"} {
+		if err := serv.SendMemo(append([]string{"/memo", "batman"}, strings.Fields(text)...), joker); err != nil {
+			t.Fatalf("expected error to be nil, got %v", err)
+		}
+	}
+
+	batman := &Client{nick: "batman", account: "batman"}
+	serv.deliverMemos(batman)
+	if len(batman.unread) != 1 || !strings.Contains(batman.unread[0].Text, "You have 2 new memo(s)") || !strings.Contains(batman.unread[0].Text, "joker] if you can") {
+		t.Fatalf("expected both memos delivered, got %v", batman.unread)
+	}
+	serv.deliverMemos(batman)
+	if len(batman.unread) != 1 {
+		t.Errorf("expected memos to be delivered once, got %v", batman.unread)
+	}
+
+	out, _ := serv.Memos([]string{"/memos"}, batman)
+	if !strings.Contains(out, "1. [") || !strings.Contains(out, "2. [") {
+		t.Errorf("expected two memos listed, got [%s]", out)
+	}
+	if _, err := serv.Memos([]string{"/memos", "delete", "3"}, batman); err == nil {
+		t.Errorf("expected deleting a missing memo to fail")
+	}
+	serv.Memos([]string{"/memos", "delete", "1"}, batman)
+	if out, _ := serv.Memos([]string{"/memos"}, batman); strings.Contains(out, "catch me") || !strings.Contains(out, "if you can") {
+		t.Errorf("expected the first memo deleted, got [%s]", out)
+	}
+	serv.Memos([]string{"/memos", "delete", "all"}, batman)
+	if out, _ := serv.Memos([]string{"/memos"}, batman); out != "You have no memos\r\n" {
+		t.Errorf("expected no memos, got [%s]", out)
+	}
+
+	// online users get a /msg instead
+	serv.mu.Lock()
+	batman.relay = func(Event) {}
+	serv.Clients["batman"] = batman
+	serv.mu.Unlock()
+	if err := serv.SendMemo([]string{"/memo", "batman", "hi"}, joker); err == nil {
+		t.Errorf("expected a memo to an online user to fail")
+	}
+}
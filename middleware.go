@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Input is a line sent by a client on its way through the inbound
+// middleware, Conn is nil for input arriving through a gateway and
+// Command is set when the line is a command
+type Input struct {
+	Client  *Client
+	Conn    *Conn
+	Command string
+	Args    []string
+
+	// Lines is set for a multi-line paste, each of Args is then a line
+	// rather than a word
+	Lines bool
+}
+
+// Quoted splits the arguments from i on, words in double or single quotes
+// stay together, so /room "gotham city" has the one argument gotham city
+func (in *Input) Quoted(i int) ([]string, error) {
+	var args []string
+	var b strings.Builder
+	var quote rune
+	word := false
+	for _, c := range strings.Join(in.Args[i:], " ") {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, word = c, true
+		case c == ' ':
+			if word {
+				args = append(args, b.String())
+				b.Reset()
+			}
+			word = false
+		default:
+			b.WriteRune(c)
+			word = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c\r\n", quote)
+	}
+	if word {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// Text returns the input as a single line, or the lines of a paste
+func (in *Input) Text() string {
+	if in.Lines {
+		return strings.Join(in.Args, "\n")
+	}
+	return strings.Join(in.Args, " ")
+}
+
+// Handler handles an input
+type Handler func(in *Input)
+
+// Middleware wraps the next handler, it may change the input, stop it by
+// not calling next, or act after it was handled
+type Middleware func(next Handler) Handler
+
+type stage struct {
+	name string
+	mw   Middleware
+}
+
+// Pipeline is the ordered chain of middleware every input passes through
+// before it is delivered
+type Pipeline struct {
+	mu     sync.RWMutex
+	stages []stage
+}
+
+// Use adds a middleware at the end of the chain
+func (p *Pipeline) Use(name string, mw Middleware) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stages = append(p.stages, stage{name, mw})
+}
+
+// Insert adds a middleware right before the named one
+func (p *Pipeline) Insert(before, name string, mw Middleware) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, st := range p.stages {
+		if st.name == before {
+			p.stages = append(p.stages[:i], append([]stage{{name, mw}}, p.stages[i:]...)...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no middleware named [%s]", before)
+}
+
+// Remove drops the named middleware
+func (p *Pipeline) Remove(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, st := range p.stages {
+		if st.name == name {
+			p.stages = append(p.stages[:i], p.stages[i+1:]...)
+			return
+		}
+	}
+}
+
+// Names returns the names of the middleware in order
+func (p *Pipeline) Names() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var out []string
+	for _, st := range p.stages {
+		out = append(out, st.name)
+	}
+	return out
+}
+
+// Run passes the input through the chain, ending with deliver
+func (p *Pipeline) Run(in *Input, deliver Handler) {
+	p.mu.RLock()
+	h := deliver
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		h = p.stages[i].mw(h)
+	}
+	p.mu.RUnlock()
+	h(in)
+}
+
+// newInbound returns the default chain, rate limit then filter then emoji
+// then logging
+func (s *Server) newInbound() *Pipeline {
+	p := &Pipeline{}
+	p.Use("ratelimit", s.rateLimitStage)
+	p.Use("quota", s.quotaStage)
+	p.Use("filter", s.filterStage)
+	p.Use("emoji", emojiStage)
+	p.Use("log", logStage)
+	return p
+}
+
+// rateLimitStage drops input from connections over their rate limit, or
+// the lower one while on probation, and counts a strike against them
+func (s *Server) rateLimitStage(next Handler) Handler {
+	return func(in *Input) {
+		if in.Conn != nil && !s.limiter(in).Allow() {
+			in.Client.Write("You are sending too fast, slow down\r\n")
+			s.strike(in, "flood")
+			return
+		}
+		next(in)
+	}
+}
+
+// filterCommands are the commands other than plain messages that post text
+// to a room, with how many arguments come before the text
+var filterCommands = map[string]int{"/reply": 2, "/notice": 1}
+
+// filterStage passes room messages, replies, and notices through the
+// plugins, which may rewrite or drop them, a dropped message counts a strike
+func (s *Server) filterStage(next Handler) Handler {
+	return func(in *Input) {
+		skip, ok := filterCommands[in.Command]
+		if s.Plugins == nil || (in.Command != "" && (!ok || len(in.Args) <= skip)) {
+			next(in)
+			return
+		}
+		var head []string
+		if in.Command != "" {
+			head, in.Args = in.Args[:skip], in.Args[skip:]
+		}
+		text, ok := s.Plugins.Message(in.Client.Nick(), s.RoomOf(in.Client), in.Text())
+		if !ok {
+			s.strike(in, "filter")
+			return
+		}
+		if in.Lines {
+			if strings.TrimSpace(text) != "" {
+				in.Args = append(head, strings.Split(text, "\n")...)
+				next(in)
+			}
+			return
+		}
+		if fields := strings.Fields(text); len(fields) > 0 {
+			in.Args = append(head, fields...)
+			next(in)
+		}
+	}
+}
+
+// logStage logs every command run, messages are logged on delivery
+func logStage(next Handler) Handler {
+	return func(in *Input) {
+		if in.Command != "" {
+			errl(nil, fmt.Sprintf("Command %s from [%s]", in.Command, in.Client.Nick()))
+		}
+		next(in)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// say sends a room message through the inbound pipeline
+func say(s *Server, cl *Client, args []string) {
+	s.Inbound.Run(&Input{Client: cl, Args: args}, func(in *Input) {
+		s.Message(in.Args, in.Client)
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	var trail []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(in *Input) {
+				trail = append(trail, name)
+				next(in)
+			}
+		}
+	}
+
+	p := &Pipeline{}
+	p.Use("a", mark("a"))
+	p.Use("c", mark("c"))
+	if err := p.Insert("c", "b", mark("b")); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := p.Insert("z", "y", mark("y")); err == nil {
+		t.Errorf("expected insert before a missing middleware to fail")
+	}
+	p.Use("stop", func(next Handler) Handler { return func(in *Input) {} })
+
+	delivered := false
+	p.Run(&Input{Args: []string{"hi"}}, func(in *Input) { delivered = true })
+	if strings.Join(trail, "") != "abc" || delivered {
+		t.Errorf("expected abc and no delivery, got %v %v", trail, delivered)
+	}
+
+	p.Remove("stop")
+	p.Run(&Input{Args: []string{"hi"}}, func(in *Input) { delivered = true })
+	if !delivered {
+		t.Errorf("expected delivery once the stop was removed")
+	}
+	if names := strings.Join(p.Names(), ","); names != "a,b,c" {
+		t.Errorf("expected a,b,c, got %s", names)
+	}
+}
+
+func TestInbound(t *testing.T) {
+	serv := NewServer()
+	if names := strings.Join(serv.Inbound.Names(), ","); names != "ratelimit,quota,filter,emoji,log" {
+		t.Errorf("unexpected default pipeline %s", names)
+	}
+	serv.Plugins = NewPlugins(&filterPlugin{})
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	cn := &Conn{limit: newRateLimiter(1)}
+
+	var got []string
+	deliver := func(in *Input) { got = append(got, in.Text()) }
+	serv.Inbound.Run(&Input{Client: batman, Conn: cn, Args: []string{"hello"}}, deliver)
+	serv.Inbound.Run(&Input{Client: batman, Conn: cn, Args: []string{"again"}}, deliver)
+	if len(got) != 1 || got[0] != "HELLO" {
+		t.Errorf("expected one filtered line, got %v", got)
+	}
+
+	// commands skip the filter
+	got = nil
+	serv.Inbound.Run(&Input{Client: batman, Command: "/nick", Args: []string{"/nick", "robin"}}, deliver)
+	if len(got) != 1 || got[0] != "/nick robin" {
+		t.Errorf("expected command untouched, got %v", got)
+	}
+
+	// replies are filtered like room messages
+	got = nil
+	serv.Inbound.Run(&Input{Client: batman, Command: "/reply", Args: []string{"/reply", "7", "hi"}}, deliver)
+	if len(got) != 1 || got[0] != "/reply 7 HI" {
+		t.Errorf("expected the reply text filtered, got %v", got)
+	}
+
+	// and so are notices
+	got = nil
+	serv.Inbound.Run(&Input{Client: batman, Command: "/notice", Args: []string{"/notice", "hi"}}, deliver)
+	serv.Inbound.Run(&Input{Client: batman, Command: "/notice", Args: []string{"/notice", "the", "joker"}}, deliver)
+	if len(got) != 1 || got[0] != "/notice HI" {
+		t.Errorf("expected the notice text filtered, got %v", got)
+	}
+}
+
+func TestQuoted(t *testing.T) {
+	for line, want := range map[string][]string{
+		`/room gotham`:                {"gotham"},
+		`/room "gotham city"`:         {"gotham city"},
+		`/room 'wayne manor' batcave`: {"wayne manor", "batcave"},
+		`/room ""`:                    {""},
+		`/room`:                       nil,
+	} {
+		in := &Input{Args: strings.Fields(line)}
+		got, err := in.Quoted(1)
+		if err != nil || strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("expected %q from [%s], got %q %v", want, line, got, err)
+		}
+	}
+	in := &Input{Args: strings.Fields(`/room "gotham city`)}
+	if _, err := in.Quoted(1); err == nil {
+		t.Errorf("expected an unclosed quote to fail")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxModLog is the number of moderation actions kept
+const maxModLog = 100
+
+// errMuted is returned to a muted client that tries to talk
+var errMuted = errors.New("you are muted\r\n")
+
+// ModAction is a moderation action taken by an op, an admin, or the control
+// socket
+type ModAction struct {
+	Time   time.Time
+	By     string
+	Action string
+	Target string
+	Room   string
+}
+
+// logMod records a moderation action, it must be called with the server
+// lock held
+func (s *Server) logMod(by, action, target, room string) {
+	s.modlog = append(s.modlog, ModAction{Time: time.Now(), By: by, Action: action, Target: target, Room: room})
+	if len(s.modlog) > maxModLog {
+		s.modlog = s.modlog[len(s.modlog)-maxModLog:]
+	}
+}
+
+// ModLog returns the recent moderation actions, newest first
+func (s *Server) ModLog() []ModAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]ModAction, 0, len(s.modlog))
+	for i := len(s.modlog) - 1; i >= 0; i-- {
+		out = append(out, s.modlog[i])
+	}
+	return out
+}
+
+// muted returns true if the client may not talk, it must be called with
+// the server lock held
+func (s *Server) muted(cl *Client) bool {
+	if s.Muted[cl.Nick()] {
+		return true
+	}
+	a := cl.Account()
+	return a != "" && s.Muted[a]
+}
+
+// Mute stops nick from sending messages to rooms and users until unmuted
+func (s *Server) Mute(by, nick string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Muted[nick] {
+		return fmt.Errorf("[%s] is already muted\r\n", nick)
+	}
+	s.Muted[nick] = true
+	s.logMod(by, "mute", nick, "")
+	errl(nil, fmt.Sprintf("[%s] muted by [%s]", nick, by))
+
+	if c, ok := s.Clients[nick]; ok {
+		c.Write("You were muted\r\n")
+	}
+	return nil
+}
+
+// Unmute lets nick talk again
+func (s *Server) Unmute(by, nick string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Muted[nick] {
+		return fmt.Errorf("[%s] is not muted\r\n", nick)
+	}
+	delete(s.Muted, nick)
+	s.logMod(by, "unmute", nick, "")
+	errl(nil, fmt.Sprintf("[%s] unmuted by [%s]", nick, by))
+
+	if c, ok := s.Clients[nick]; ok {
+		c.Write("You are no longer muted\r\n")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNick is how many characters a nick may be
+const maxNick = 32
+
+// maxRoomName is how many characters a room name may be
+const maxRoomName = 64
+
+// NameError explains why a nick or room name was refused
+type NameError struct {
+	Kind   string
+	Name   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%s [%s] %s\r\n", e.Kind, e.Name, e.Reason)
+}
+
+// nickChar returns true if c may be part of a nick
+func nickChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("-_.", c)
+}
+
+// validNick returns an error if nick can't be used, a nick is a word of
+// letters, digits, dashes, underscores and dots, accents must be written
+// precomposed so that every way of writing a nick folds the same
+func validNick(nick string) error {
+	switch {
+	case strings.TrimSpace(nick) == "":
+		return &NameError{"nick", nick, "is empty"}
+	case !utf8.ValidString(nick):
+		return &NameError{"nick", nick, "is not valid UTF-8"}
+	case utf8.RuneCountInString(nick) > maxNick:
+		return &NameError{"nick", nick, fmt.Sprintf("is longer than %d characters", maxNick)}
+	case strings.HasPrefix(nick, "/"):
+		return &NameError{"nick", nick, "can't start with /"}
+	}
+	for _, c := range nick {
+		if unicode.Is(unicode.M, c) {
+			return &NameError{"nick", nick, "has a combining mark, use the accented letter instead"}
+		}
+		if !nickChar(c) {
+			return &NameError{"nick", nick, fmt.Sprintf("can't contain %q, only letters, digits, - _ and .", c)}
+		}
+	}
+	return nil
+}
+
+// roomKey returns the key a room is known by, its name in lower case with
+// runs of spaces collapsed, so "Gotham  City" and "gotham city" are the same
+// room
+func roomKey(name string) string {
+	return strings.ToLower(roomDisplay(name))
+}
+
+// roomDisplay returns the name of a room as shown, its casing kept and runs
+// of spaces collapsed
+func roomDisplay(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// validRoom returns an error if name can't be used for a room, a room name
+// is printable and doesn't start or end with spaces
+func validRoom(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return &NameError{"room", name, "is empty"}
+	case !utf8.ValidString(name):
+		return &NameError{"room", name, "is not valid UTF-8"}
+	case utf8.RuneCountInString(name) > maxRoomName:
+		return &NameError{"room", name, fmt.Sprintf("is longer than %d characters", maxRoomName)}
+	case strings.HasPrefix(name, "/"):
+		return &NameError{"room", name, "can't start with /"}
+	case strings.TrimSpace(name) != name:
+		return &NameError{"room", name, "can't start or end with spaces"}
+	}
+	for _, c := range name {
+		if c == ' ' {
+			continue
+		}
+		if !unicode.IsGraphic(c) || unicode.IsSpace(c) {
+			return &NameError{"room", name, fmt.Sprintf("can't contain %q", c)}
+		}
+	}
+	return nil
+}
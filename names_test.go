@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidNick(t *testing.T) {
+	for _, nick := range []string{"batman", "Robin_2", "dr.freeze", "ｂａｔｍａｎ", "josé"} {
+		if err := validNick(nick); err != nil {
+			t.Errorf("expected [%s] to be valid, got %v", nick, err)
+		}
+	}
+	for _, nick := range []string{"", "   ", "/batman", "bat man", "bat\x00man", "bat\u200bman", "jose\u0301", "[batman]", strings.Repeat("a", maxNick+1)} {
+		err := validNick(nick)
+		if _, ok := err.(*NameError); !ok {
+			t.Errorf("expected [%q] to be refused, got %v", nick, err)
+		}
+	}
+}
+
+func TestValidRoom(t *testing.T) {
+	for _, name := range []string{"gotham", DefaultRoom, "wayne manor #2"} {
+		if err := validRoom(name); err != nil {
+			t.Errorf("expected [%s] to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "  ", "/gotham", " gotham", "got\tham", "got\nham", strings.Repeat("a", maxRoomName+1)} {
+		err := validRoom(name)
+		if _, ok := err.(*NameError); !ok {
+			t.Errorf("expected [%q] to be refused, got %v", name, err)
+		}
+	}
+
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	if err := serv.JoinRoom("/gotham", batman); err == nil || err.Error() != "room [/gotham] can't start with /\r\n" {
+		t.Errorf("expected the room refused, got %v", err)
+	}
+	serv.JoinRoom("gotham", batman)
+	if err := serv.ChangeNick("batman", "bat man"); err == nil {
+		t.Errorf("expected the nick refused")
+	}
+}
+
+func TestRoomDisplay(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("Wayne  Manor", batman)
+	serv.JoinRoom("wayne manor", robin)
+
+	r, ok := serv.Rooms["wayne manor"]
+	if !ok || len(r.Clients) != 2 {
+		t.Fatalf("expected both in room [wayne manor], got %v", serv.Rooms)
+	}
+	if r.Display != "Wayne Manor" {
+		t.Errorf("expected the name kept for display, got [%s]", r.Display)
+	}
+	if out, _ := serv.Who(robin); !strings.HasPrefix(out, "Members of room [Wayne Manor]: ") {
+		t.Errorf("expected the display name, got [%s]", out)
+	}
+
+	serv.JoinRoom(DefaultRoom, batman)
+	if r := serv.Rooms[roomKey("Gotham City")]; r == nil || r.Display != "Gotham City" {
+		t.Errorf("expected the default room shown as Gotham City, got %+v", r)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldNick returns the form of a nick used to tell nicks apart, case is
+// folded, fullwidth letters and digits become their ASCII forms, and
+// invisible formatting characters are dropped, so "Batman", "BATMAN" and
+// "ｂａｔｍａｎ" are all the same nick
+func foldNick(nick string) string {
+	var b strings.Builder
+	for _, c := range nick {
+		if unicode.Is(unicode.Cf, c) {
+			continue
+		}
+		if c >= '！' && c <= '～' {
+			c = c - '！' + '!'
+		}
+		b.WriteRune(foldRune(c))
+	}
+	return b.String()
+}
+
+// foldRune returns the smallest rune c folds to, the same for every case
+// of a letter
+func foldRune(c rune) rune {
+	min := c
+	for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// findNick returns the local client whose nick folds the same as nick, it
+// must be called with the server lock held
+func (s *Server) findNick(nick string) (*Client, bool) {
+	if c, ok := s.Clients[nick]; ok {
+		return c, true
+	}
+	key := foldNick(nick)
+	for n, c := range s.Clients {
+		if foldNick(n) == key {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// nickInUse returns true if a client other than cl already uses nick in
+// any casing, here or elsewhere, it must be called with the server lock held
+func (s *Server) nickInUse(nick string, cl *Client) bool {
+	if c, ok := s.findNick(nick); ok && c != cl {
+		return true
+	}
+	return s.remoteNick(nick)
+}
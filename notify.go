@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// offline returns true if the account has no connection to receive on, it
+// must be called with the server lock held
+func (s *Server) offline(name string) bool {
+	cl := s.findAccount(name)
+	if cl == nil {
+		return true
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return len(cl.Conns) == 0 && cl.relay == nil
+}
+
+// notifyOffline sends a push notification and email to the account if it's
+// offline and has opted in, it returns true if a notification was sent, it
+// must be called with the server lock held
+func (s *Server) notifyOffline(name, title, body string) bool {
+	a, ok := s.Accounts.Get(name)
+	if !ok || (a.Push == nil && a.EmailMode == "") || !s.offline(name) {
+		return false
+	}
+	if a.Push != nil {
+		s.Push.Notify(*a.Push, title, body)
+	}
+	if a.EmailMode != "" {
+		s.Mail.Notify(a.Email, a.EmailMode, title, body)
+	}
+	return true
+}
+
+// notifyMentions notifies offline accounts the event mentions, it must be
+// called with the server lock held
+func (s *Server) notifyMentions(ev Event) {
+	for _, name := range s.Accounts.Notifiable() {
+		if name != ev.From && mentions(ev.Text, name) {
+			s.notifyOffline(name, fmt.Sprintf("%s mentioned you in %s", ev.From, ev.Room), ev.Text)
+		}
+	}
+}
+
+// mentions returns true if text contains nick as a word
+func mentions(text, nick string) bool {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+	})
+	for _, w := range words {
+		if strings.EqualFold(w, nick) {
+			return true
+		}
+	}
+	return false
+}
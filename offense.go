@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaults for the offense settings, strikes are off unless TCStrikes is set
+const (
+	DefaultStrikeWindow = 10 * time.Minute
+	DefaultStrikeBan    = 5 * time.Minute
+	DefaultStrikeBanMax = 24 * time.Hour
+)
+
+// offenseMemory is how long an address is remembered after its last strike
+// or ban, repeat bans grow longer until it's forgotten
+const offenseMemory = 24 * time.Hour
+
+// offender is the record of an address
+type offender struct {
+	strikes []time.Time
+	last    map[string]time.Time
+	bans    int
+	until   time.Time
+	seen    time.Time
+}
+
+// Offenses counts strikes against addresses, for flooding, messages the
+// filters drop, and failed logins, and bans an address for a while once it
+// has Limit strikes within Window, each ban after the first lasts twice as
+// long as the one before, up to BanMax
+type Offenses struct {
+	mu     sync.Mutex
+	Limit  int
+	Window time.Duration
+	Ban    time.Duration
+	BanMax time.Duration
+	addrs  map[string]*offender
+}
+
+// NewOffenses returns an offense tracker that bans nobody until Limit is set
+func NewOffenses() *Offenses {
+	return &Offenses{
+		Window: DefaultStrikeWindow,
+		Ban:    DefaultStrikeBan,
+		BanMax: DefaultStrikeBanMax,
+		addrs:  make(map[string]*offender),
+	}
+}
+
+// parseOffenses reads the offense settings from the environment
+func parseOffenses() (limit int, window, ban, banMax time.Duration, err error) {
+	window, ban, banMax = DefaultStrikeWindow, DefaultStrikeBan, DefaultStrikeBanMax
+	if v := os.Getenv("TCStrikes"); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("error parsing TCStrikes: %v", err)
+		}
+	}
+	for _, d := range []struct {
+		env string
+		val *time.Duration
+	}{{"TCStrikeWindow", &window}, {"TCStrikeBan", &ban}, {"TCStrikeBanMax", &banMax}} {
+		if v := os.Getenv(d.env); len(v) > 0 {
+			if *d.val, err = time.ParseDuration(v); err != nil {
+				return 0, 0, 0, 0, fmt.Errorf("error parsing %s: %v", d.env, err)
+			}
+		}
+	}
+	return limit, window, ban, banMax, nil
+}
+
+// Configure changes the settings, the strikes and bans so far are kept
+func (o *Offenses) Configure(limit int, window, ban, banMax time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Limit, o.Window, o.Ban, o.BanMax = limit, window, ban, banMax
+}
+
+// Enabled returns true if strikes lead to bans
+func (o *Offenses) Enabled() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.Limit > 0
+}
+
+// Strike counts a strike against addr at now, strikes for the same reason
+// within a second count once so a burst isn't punished many times over, it
+// returns how long addr is banned for if this strike bans it
+func (o *Offenses) Strike(addr, why string, now time.Time) (time.Duration, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.Limit <= 0 || addr == "" {
+		return 0, false
+	}
+	off, ok := o.addrs[addr]
+	if !ok {
+		off = &offender{last: make(map[string]time.Time)}
+		o.addrs[addr] = off
+	}
+	off.seen = now
+	if now.Before(off.until) || now.Sub(off.last[why]) < time.Second {
+		return 0, false
+	}
+	off.last[why] = now
+
+	var recent []time.Time
+	for _, t := range off.strikes {
+		if now.Sub(t) < o.Window {
+			recent = append(recent, t)
+		}
+	}
+	off.strikes = append(recent, now)
+	if len(off.strikes) < o.Limit {
+		return 0, false
+	}
+
+	d := o.Ban
+	for i := 0; i < off.bans && d < o.BanMax; i++ {
+		d *= 2
+	}
+	if d > o.BanMax {
+		d = o.BanMax
+	}
+	off.bans++
+	off.strikes = nil
+	off.until = now.Add(d)
+	return d, true
+}
+
+// Banned returns how much longer addr is banned for at now
+func (o *Offenses) Banned(addr string, now time.Time) (time.Duration, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	off, ok := o.addrs[addr]
+	if !ok || !now.Before(off.until) {
+		return 0, false
+	}
+	return off.until.Sub(now), true
+}
+
+// Pardon lifts the ban on addr and forgets its strikes
+func (o *Offenses) Pardon(addr string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.addrs[addr]; !ok {
+		return fmt.Errorf("[%s] has no strikes", addr)
+	}
+	delete(o.addrs, addr)
+	return nil
+}
+
+// Prune forgets the addresses not seen for a while
+func (o *Offenses) Prune(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for addr, off := range o.addrs {
+		if now.After(off.until) && now.Sub(off.seen) > offenseMemory {
+			delete(o.addrs, addr)
+		}
+	}
+}
+
+// Run prunes the addresses every so often
+func (o *Offenses) Run() {
+	for now := range time.Tick(time.Hour) {
+		o.Prune(now)
+	}
+}
+
+// strike counts a strike against the address the input came from, and
+// bans it if that was one too many, input from gateways carries no address
+func (s *Server) strike(in *Input, why string) {
+	if in.Conn == nil || !s.Offenses.Enabled() {
+		return
+	}
+	s.strikeAddr(addrIP(in.Conn.RemoteAddr()), why)
+}
+
+// strikeAddr counts a strike against ip, and bans it if that was one too
+// many
+func (s *Server) strikeAddr(ip net.IP, why string) {
+	if ip == nil || !s.Offenses.Enabled() {
+		return
+	}
+	if d, ok := s.Offenses.Strike(ip.String(), why, time.Now()); ok {
+		s.banAddr(ip, d, why)
+	}
+}
+
+// banAddr closes every connection from ip, it stays banned for d
+func (s *Server) banAddr(ip net.IP, d time.Duration, why string) {
+	s.mu.Lock()
+	var conns []*Conn
+	for _, cl := range s.Clients {
+		cl.mu.Lock()
+		for _, c := range cl.Conns {
+			if ip.Equal(addrIP(c.RemoteAddr())) {
+				conns = append(conns, c)
+			}
+		}
+		cl.mu.Unlock()
+	}
+	s.logMod("server", fmt.Sprintf("ban %s for %s", d, why), anonymizeIP(ip), "")
+	s.mu.Unlock()
+
+	errl(nil, fmt.Sprintf("[%s] banned for %s after too many strikes, the last for %s", anonymizeIP(ip), d, why))
+	for _, c := range conns {
+		c.sendClose(fmt.Sprintf("You are banned for %s, too many strikes\r\n", d))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOffenses(t *testing.T) {
+	o := NewOffenses()
+	now := time.Now()
+	if _, ok := o.Strike("10.0.0.1", "flood", now); ok {
+		t.Errorf("expected no bans while strikes are off")
+	}
+
+	o.Configure(3, time.Minute, time.Minute, 3*time.Minute)
+	strike := func(why string, at time.Duration) bool {
+		_, ok := o.Strike("10.0.0.1", why, now.Add(at))
+		return ok
+	}
+	if strike("flood", 0) || strike("flood", 100*time.Millisecond) || strike("login", time.Second) {
+		t.Fatalf("expected a burst to count once and two strikes not to ban")
+	}
+	if strike("login", 2*time.Minute) || strike("filter", 2*time.Minute) {
+		t.Fatalf("expected old strikes to be forgotten")
+	}
+	if !strike("flood", 2*time.Minute+time.Second) {
+		t.Fatalf("expected the third strike to ban")
+	}
+	if d, ok := o.Banned("10.0.0.1", now.Add(2*time.Minute+31*time.Second)); !ok || d != 30*time.Second {
+		t.Errorf("expected 30s left of a 1m ban, got %s %t", d, ok)
+	}
+	if _, ok := o.Banned("10.0.0.2", now); ok {
+		t.Errorf("expected other addresses not to be banned")
+	}
+
+	// bans double, up to the most
+	next := 4 * time.Minute
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		var d time.Duration
+		var ok bool
+		for i := 0; i < 3; i++ {
+			d, ok = o.Strike("10.0.0.1", "flood", now.Add(next+time.Duration(i)*time.Second))
+		}
+		if !ok || d != want {
+			t.Errorf("expected a ban of %s, got %s %t", want, d, ok)
+		}
+		next += 10 * time.Minute
+	}
+
+	if err := o.Pardon("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := o.Banned("10.0.0.1", now.Add(next-9*time.Minute)); ok {
+		t.Errorf("expected the ban to be lifted")
+	}
+	o.Strike("10.0.0.3", "flood", now)
+	o.Prune(now.Add(offenseMemory + time.Second))
+	if err := o.Pardon("10.0.0.3"); err == nil {
+		t.Errorf("expected old addresses to be forgotten")
+	}
+}
+
+func TestStrikeBans(t *testing.T) {
+	serv := NewServer()
+	serv.Offenses.Configure(2, time.Minute, time.Minute, time.Hour)
+	if err := serv.Accounts.Register("batman", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1024)
+		for {
+			if _, err := c1.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	joker := &Client{nick: "joker", Conns: []*Conn{NewConn(c2)}}
+	serv.JoinRoom("gotham", joker)
+
+	login := func() {
+		serv.Dispatch(&Input{Client: joker, Conn: joker.Conns[0], Command: "/login", Args: []string{"/login", "batman", "wrong"}})
+	}
+	login()
+	time.Sleep(1100 * time.Millisecond)
+	login()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the connection to be closed")
+	}
+	if d, ok := serv.Offenses.Banned("127.0.0.1", time.Now()); !ok || d > time.Minute {
+		t.Errorf("expected the address to be banned for a minute, got %s %t", d, ok)
+	}
+	if log := serv.ModLog(); len(log) != 1 || log[0].By != "server" || log[0].Target != "127.0.0.1" {
+		t.Errorf("expected the ban to be logged, got %+v", log)
+	}
+}
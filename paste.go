@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultLineLimit is how many characters a room message may have before
+// it is pasted
+const DefaultLineLimit = 1000
+
+// minLineLimit is the lowest line limit a room may set
+const minLineLimit = 80
+
+// pastePreview is how many characters of a pasted message are posted
+const pastePreview = 200
+
+// maxPastes is how many pastes are kept, the oldest are dropped first
+const maxPastes = 1000
+
+// Paste is the full text of a message too long for its room
+type Paste struct {
+	ID   string
+	Msg  int64
+	Room string
+	From string
+	Time string
+	Text string
+}
+
+// parseLineLimit parses a line limit, off is no limit and parsed as -1
+func parseLineLimit(arg string) (int, error) {
+	if arg == "off" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < minLineLimit {
+		return 0, fmt.Errorf("[%s] is not a line limit, use a number of at least %d characters, or off\r\n", arg, minLineLimit)
+	}
+	return n, nil
+}
+
+// lineLimit returns how many characters a message of the room may have, 0
+// or less is no limit
+func (s *Server) lineLimit(r *Room) int {
+	if r.LineLimit != 0 {
+		return r.LineLimit
+	}
+	return s.LineLimit
+}
+
+// paste keeps the full text of a message longer than the room's line limit
+// and returns the message with a preview and how to read the rest in its
+// place, shorter messages are returned as they are, it must be called with
+// the server lock held
+func (s *Server) paste(r *Room, ev Event) Event {
+	limit := s.lineLimit(r)
+	text := []rune(ev.Text)
+	if limit <= 0 || len(text) <= limit {
+		return ev
+	}
+	id, err := shortID()
+	if err != nil {
+		errl(err, "")
+		return ev
+	}
+	if s.pastes == nil {
+		s.pastes = make(map[string]*Paste)
+	}
+	s.pastes[id] = &Paste{ID: id, Msg: ev.ID, Room: r.Name, From: ev.From, Time: ev.Time, Text: ev.Text}
+	s.pasteOrder = append(s.pasteOrder, id)
+	if len(s.pasteOrder) > maxPastes {
+		delete(s.pastes, s.pasteOrder[0])
+		s.pasteOrder = s.pasteOrder[1:]
+	}
+
+	preview := pastePreview
+	if preview > limit {
+		preview = limit
+	}
+	where := "/paste " + id
+	if s.PublicURL != "" {
+		where += " or " + s.PasteLink(id)
+	}
+	ev.Text = fmt.Sprintf("%s... [%d more characters, %s]", strings.TrimSpace(string(text[:preview])), len(text)-preview, where)
+	ev.Paste = id
+	return ev
+}
+
+// PasteLink returns where the paste is read over the HTTP API
+func (s *Server) PasteLink(id string) string {
+	return fmt.Sprintf("%s/paste/%s", strings.TrimSuffix(s.PublicURL, "/"), id)
+}
+
+// dropPastes removes the pastes of messages that expired from the room, it
+// must be called with the server lock held
+func (s *Server) dropPastes(r *Room, ids []int64) {
+	gone := make(map[int64]bool)
+	for _, id := range ids {
+		gone[id] = true
+	}
+	kept := s.pasteOrder[:0]
+	for _, id := range s.pasteOrder {
+		if p := s.pastes[id]; p.Room == r.Name && gone[p.Msg] {
+			delete(s.pastes, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.pasteOrder = kept
+}
+
+// ReadPaste returns the full text of a pasted message, members of the room
+// it was posted to and anyone when the room is public may read it
+// example: /paste Xk2_9aQe
+func (s *Server) ReadPaste(cl *Client, id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pastes[id]
+	if !ok {
+		return "", fmt.Errorf("paste [%s] does not exist\r\n", id)
+	}
+	r, ok := s.Rooms[roomKey(p.Room)]
+	if !ok || (!r.shownPublicly(p.Msg) && r.Clients[cl.Nick()] != cl) {
+		return "", fmt.Errorf("paste [%s] is from room [%s], join it to read it\r\n", id, p.Room)
+	}
+	return fmt.Sprintf("[%s:%s] %s\r\n", p.Time, p.From, p.Text), nil
+}
+
+// RoomLineLimit returns how many characters a message of the client's room
+// may have, 0 or less is no limit
+func (s *Server) RoomLineLimit(cl *Client) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return 0, err
+	}
+	return s.lineLimit(r), nil
+}
+
+// SetLineLimit pastes the messages of the client's room longer than limit,
+// -1 is no limit and 0 goes back to the server's limit, only the owner of
+// the room, its ops, and moderators may change it
+func (s *Server) SetLineLimit(cl *Client, limit int) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can change the line limit\r\n")
+	}
+	s.mu.Unlock()
+
+	if err := s.change(raftCommand{Op: raftRoom, Room: r.Name, LineLimit: limit, Fields: []string{"line_limit"}}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := fmt.Sprintf("[%s] set the line limit to %d characters, longer messages are pasted\r\n", cl.Nick(), s.lineLimit(r))
+	if s.lineLimit(r) <= 0 {
+		text = fmt.Sprintf("[%s] removed the line limit\r\n", cl.Nick())
+	}
+	for _, c := range r.Clients {
+		c.Write(text)
+	}
+	return nil
+}
+
+// lineLimitCommand runs /linelimit
+func (s *Server) lineLimitCommand(in *Input) {
+	if len(in.Args) == 1 {
+		limit, err := s.RoomLineLimit(in.Client)
+		if err == nil && limit <= 0 {
+			in.Client.Write("Messages in this room have no line limit\r\n")
+			return
+		}
+		reply(in.Client, fmt.Sprintf("Messages in this room longer than %d characters are pasted\r\n", limit), err)
+		return
+	}
+	limit := 0
+	var err error
+	if in.Args[1] != "default" {
+		limit, err = parseLineLimit(in.Args[1])
+	}
+	if err == nil {
+		err = s.SetLineLimit(in.Client, limit)
+	}
+	reply(in.Client, "", err)
+}
+
+// handlePaste serves the full text of a paste at /paste/<id>, the link is
+// what grants access, like the link to a file
+func (s *Server) handlePaste(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/paste/")
+	s.mu.Lock()
+	p, ok := s.pastes[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fmt.Fprintf(w, "[%s:%s] %s\n", p.Time, p.From, p.Text)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaste(t *testing.T) {
+	serv := NewServer()
+	serv.PublicURL = "http://chat.example"
+	alfred := &Client{nick: "alfred"}
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("batcave", alfred)
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("arkham", joker)
+
+	serv.Message([]string{"short", "and", "sweet"}, alfred)
+	long := strings.Repeat("master wayne ", 100)
+	serv.Message([]string{long}, alfred)
+
+	h := serv.Rooms["batcave"].History
+	if len(h) != 2 || h[0].Paste != "" || h[0].Text != "short and sweet" {
+		t.Fatalf("expected a short message posted as is, got %+v", h)
+	}
+	ev := h[1]
+	if ev.Paste == "" || len(ev.Text) >= len(long) || !strings.Contains(ev.Text, "/paste "+ev.Paste) {
+		t.Fatalf("expected a preview with the paste id, got %+v", ev)
+	}
+	if !strings.Contains(ev.Text, "http://chat.example/paste/"+ev.Paste) {
+		t.Errorf("expected the paste link, got %s", ev.Text)
+	}
+
+	out, err := serv.ReadPaste(batman, ev.Paste)
+	if err != nil || !strings.Contains(out, long) {
+		t.Errorf("expected the full text, got %q, %v", out, err)
+	}
+	if _, err := serv.ReadPaste(joker, ev.Paste); err == nil {
+		t.Errorf("expected the paste of a private room kept from others")
+	}
+
+	req := httptest.NewRequest("GET", "/paste/"+ev.Paste, nil)
+	w := httptest.NewRecorder()
+	serv.HTTPHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), long) {
+		t.Errorf("expected the paste served, got %d", w.Code)
+	}
+
+	// the room's limit
+	if err := serv.SetLineLimit(batman, -1); err == nil {
+		t.Errorf("expected members who don't moderate the room refused")
+	}
+	serv.Rooms["batcave"].Owner = "alfred"
+	if err := serv.SetLineLimit(alfred, -1); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Message([]string{long}, alfred)
+	if h := serv.Rooms["batcave"].History; h[len(h)-1].Text != long {
+		t.Errorf("expected no paste without a limit")
+	}
+	if _, err := parseLineLimit("10"); err == nil {
+		t.Errorf("expected a tiny limit refused")
+	}
+
+	// expiring the message drops its paste
+	serv.Rooms["batcave"].TTL = time.Minute
+	serv.Expire(time.Now().Add(time.Hour))
+	if _, err := serv.ReadPaste(batman, ev.Paste); err == nil {
+		t.Errorf("expected the paste gone with its message")
+	}
+}
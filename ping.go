@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxPings is how many pings a connection may have waiting for their pong
+const maxPings = 4
+
+// pingTimeout is how long a ping waits for its pong
+const pingTimeout = time.Minute
+
+// latency formats a duration for people, in milliseconds with a fraction
+func latency(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+// expectPong remembers a ping sent to the connection, the oldest is
+// forgotten when too many are waiting
+func (c *Conn) expectPong(token string, now time.Time) {
+	c.pingMu.Lock()
+	defer c.pingMu.Unlock()
+	if c.pings == nil {
+		c.pings = make(map[string]time.Time)
+	}
+	for t, sent := range c.pings {
+		if now.Sub(sent) > pingTimeout {
+			delete(c.pings, t)
+		}
+	}
+	for len(c.pings) >= maxPings {
+		oldest := ""
+		for t, sent := range c.pings {
+			if oldest == "" || sent.Before(c.pings[oldest]) {
+				oldest = t
+			}
+		}
+		delete(c.pings, oldest)
+	}
+	c.pings[token] = now
+}
+
+// pong returns how long ago the ping with token was sent, false if the
+// connection isn't waiting for it
+func (c *Conn) pong(token string, now time.Time) (time.Duration, bool) {
+	c.pingMu.Lock()
+	defer c.pingMu.Unlock()
+	sent, ok := c.pings[token]
+	if !ok || now.Sub(sent) > pingTimeout {
+		return 0, false
+	}
+	delete(c.pings, token)
+	return now.Sub(sent), true
+}
+
+// pingCommand runs /ping, it tells how long the server took to handle the
+// line since it was read, then sends the connection a token that clients
+// able to echo it answer with /pong to time the round trip
+func (s *Server) pingCommand(in *Input) {
+	if in.Conn == nil {
+		in.Client.Write("Pong\r\n")
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Pong, the server handled your ping in %s\r\n", latency(in.Conn.Idle())))
+	token, err := newToken()
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	ev := Event{Type: EventPing, Time: time.Now().Format(time.RFC3339), Token: token}
+	in.Conn.expectPong(token, time.Now())
+	in.Client.mu.Lock()
+	defer in.Client.mu.Unlock()
+	in.Conn.send(in.Conn.Render(ev))
+}
+
+// pongCommand runs /pong
+func (s *Server) pongCommand(in *Input) {
+	if in.Conn == nil {
+		return
+	}
+	rtt, ok := in.Conn.pong(in.Args[1], time.Now())
+	if !ok {
+		in.Client.Write(fmt.Sprintf("[%s] is not a ping sent to this connection in the last %s\r\n", in.Args[1], pingTimeout))
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Round trip to your client took %s\r\n", latency(rtt)))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	serv := NewServer()
+	conn := NewConn(nil)
+	batman := &Client{nick: "batman", Conns: []*Conn{conn}}
+	serv.JoinRoom("gotham", batman)
+
+	if rtt, ok := conn.pong("nope", time.Now()); ok {
+		t.Errorf("expected an unknown token refused, got %s", rtt)
+	}
+	sent := time.Now()
+	conn.expectPong("abc", sent)
+	if rtt, ok := conn.pong("abc", sent.Add(42*time.Millisecond)); !ok || rtt != 42*time.Millisecond {
+		t.Errorf("expected a round trip of 42ms, got %s", rtt)
+	}
+	if _, ok := conn.pong("abc", sent.Add(time.Second)); ok {
+		t.Errorf("expected a token answered once")
+	}
+	conn.expectPong("late", sent)
+	if _, ok := conn.pong("late", sent.Add(pingTimeout+time.Second)); ok {
+		t.Errorf("expected a late pong refused")
+	}
+
+	for _, token := range []string{"a", "b", "c", "d", "e"} {
+		conn.expectPong(token, sent)
+		sent = sent.Add(time.Millisecond)
+	}
+	if len(conn.pings) != maxPings || conn.pings["a"] != (time.Time{}) {
+		t.Errorf("expected the oldest ping forgotten, got %v", conn.pings)
+	}
+
+	if got := latency(1500 * time.Microsecond); got != "1.5ms" {
+		t.Errorf("expected 1.5ms, got %s", got)
+	}
+	ev := Event{Type: EventPing, Token: "abc"}
+	if got := ev.String(); got != "PING abc\r\n" {
+		t.Errorf("expected a PING line, got [%s]", got)
+	}
+	conn.SetJSON(true)
+	if got := conn.Render(ev); !strings.Contains(got, `"type":"ping"`) || !strings.Contains(got, `"token":"abc"`) {
+		t.Errorf("expected a ping event, got [%s]", got)
+	}
+
+	serv.Dispatch(&Input{Client: batman, Conn: conn, Command: "/ping", Args: []string{"/ping"}})
+	if _, ok := conn.pings["b"]; ok || len(conn.pings) != maxPings {
+		t.Errorf("expected /ping to send a token, got %v", conn.pings)
+	}
+}
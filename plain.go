@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// frameRe matches a decorative heading like --|Welcome|-----
+	frameRe = regexp.MustCompile(`^[-=]+\|(.*?)\|[-=]*$`)
+	// ruleRe matches a line drawn only to separate others
+	ruleRe = regexp.MustCompile(`^[-=_*\x{2500}-\x{259f}\s]{3,}$`)
+)
+
+// plainText strips colors, box drawing and decorative framing from out,
+// so a screen reader only reads the words, headings are kept as words
+func plainText(out string) string {
+	out = ansiRe.ReplaceAllString(out, "")
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(out, "\n") {
+		body := strings.TrimRight(line, "\r\n")
+		end := line[len(body):]
+		switch {
+		case frameRe.MatchString(body):
+			body = frameRe.FindStringSubmatch(body)[1]
+		case ruleRe.MatchString(body):
+			continue
+		}
+		body = strings.Map(func(c rune) rune {
+			if c >= 0x2500 && c <= 0x259f {
+				return -1
+			}
+			return c
+		}, body)
+		b.WriteString(body + end)
+	}
+	return b.String()
+}
+
+// Plain returns true if the client gets plain output
+func (cl *Client) Plain() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.plain
+}
+
+// SetPlain switches the client to plain output for screen readers, saved
+// to its account if it is logged in
+func (s *Server) SetPlain(cl *Client, on bool) error {
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.Plain = on }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.plain = on
+	cl.mu.Unlock()
+	return nil
+}
+
+// plainCommand runs /plain
+func (s *Server) plainCommand(in *Input) {
+	on, err := onOff(in.Args, "set plain output")
+	if err == nil {
+		err = s.SetPlain(in.Client, on)
+	}
+	reply(in.Client, fmt.Sprintf("Plain output is %s\r\n", in.Args[1]), err)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	serv := NewServer()
+	out := plainText(serv.Banner("batman", ""))
+	if strings.Contains(out, "\n--") || strings.Contains(out, "|Welcome|") {
+		t.Errorf("expected the framing to be gone, got [%s]", out)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(out), "Welcome\n") || !strings.Contains(out, "\nHelp\n") {
+		t.Errorf("expected the headings to be kept as words, got [%s]", out)
+	}
+
+	for in, want := range map[string]string{
+		ansiBold + "notice" + ansiReset + "\r\n": "notice\r\n",
+		"┌──────┐\r\n│ bat │\r\n└──────┘\r\n":    " bat \r\n",
+		"a - b - c\r\n": "a - b - c\r\n",
+	} {
+		if got := plainText(in); got != want {
+			t.Errorf("expected [%q], got [%q]", want, got)
+		}
+	}
+}
+
+func TestPlain(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman := &Client{nick: "user1"}
+	serv.JoinRoom("batcave", batman)
+	if _, err := serv.Login("batman", "alfred", batman); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Dispatch(&Input{Client: batman, Command: "/plain", Args: []string{"/plain", "on"}})
+	if !batman.Plain() {
+		t.Errorf("expected plain output to be on")
+	}
+
+	cn := NewConn(nil)
+	cn.SetColor(true)
+	ev := Event{Type: EventMessage, Time: "now", From: "robin", Text: "*holy* smokes"}
+	if out := cn.render(ev, true); out != "[now:robin] *holy* smokes\r\n" {
+		t.Errorf("expected plain output without colors, got [%q]", out)
+	}
+
+	if acct, _ := serv.Accounts.Get("batman"); !acct.Plain {
+		t.Errorf("expected plain output to be saved with the account")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+	"time"
+)
+
+// pluginTimeout is how long the server waits on an out of process plugin
+// before carrying on without it
+const pluginTimeout = 2 * time.Second
+
+// Plugin extends the server without changing it, Message may rewrite the
+// text of a room message or drop it by returning false, Command is offered
+// every command the server doesn't know and returns the reply if handled
+type Plugin interface {
+	Connect(nick string)
+	Message(nick, room, text string) (string, bool)
+	Command(nick string, args []string) (string, bool)
+	Disconnect(nick string)
+}
+
+// Plugins calls every loaded plugin in the order they were loaded
+type Plugins struct {
+	list []Plugin
+}
+
+// NewPlugins returns the plugins
+func NewPlugins(list ...Plugin) *Plugins {
+	return &Plugins{list: list}
+}
+
+// LoadPlugin loads a Go plugin when spec is a .so file, otherwise spec is
+// a command started as an out of process plugin
+func LoadPlugin(spec string) (Plugin, error) {
+	if strings.HasSuffix(spec, ".so") {
+		return openGoPlugin(spec)
+	}
+	return startRPCPlugin(spec)
+}
+
+// Connect tells the plugins a client connected
+func (p *Plugins) Connect(nick string) {
+	if p == nil {
+		return
+	}
+	for _, pl := range p.list {
+		pl.Connect(nick)
+	}
+}
+
+// Message passes a room message through the plugins, it returns false if
+// a plugin dropped it
+func (p *Plugins) Message(nick, room, text string) (string, bool) {
+	if p == nil {
+		return text, true
+	}
+	for _, pl := range p.list {
+		var ok bool
+		if text, ok = pl.Message(nick, room, text); !ok {
+			return "", false
+		}
+	}
+	return text, true
+}
+
+// Command offers a command to the plugins, the first to handle it replies,
+// input that isn't a command is never offered
+func (p *Plugins) Command(nick string, args []string) (string, bool) {
+	if p == nil || len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return "", false
+	}
+	for _, pl := range p.list {
+		if out, ok := pl.Command(nick, args); ok {
+			if out != "" && !strings.HasSuffix(out, "\n") {
+				out = out + "\r\n"
+			}
+			return out, true
+		}
+	}
+	return "", false
+}
+
+// Disconnect tells the plugins a client disconnected
+func (p *Plugins) Disconnect(nick string) {
+	if p == nil {
+		return
+	}
+	for _, pl := range p.list {
+		pl.Disconnect(nick)
+	}
+}
+
+// goPlugin is a plugin built with go build -buildmode=plugin, it may export
+// any of the functions Connect, Message, Command, and Disconnect with the
+// signatures of the Plugin methods
+type goPlugin struct {
+	connect    func(string)
+	message    func(string, string, string) (string, bool)
+	command    func(string, []string) (string, bool)
+	disconnect func(string)
+}
+
+func openGoPlugin(path string) (*goPlugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gp := &goPlugin{}
+	ok := true
+	if sym, err := p.Lookup("Connect"); err == nil && ok {
+		gp.connect, ok = sym.(func(string))
+	}
+	if sym, err := p.Lookup("Message"); err == nil && ok {
+		gp.message, ok = sym.(func(string, string, string) (string, bool))
+	}
+	if sym, err := p.Lookup("Command"); err == nil && ok {
+		gp.command, ok = sym.(func(string, []string) (string, bool))
+	}
+	if sym, err := p.Lookup("Disconnect"); err == nil && ok {
+		gp.disconnect, ok = sym.(func(string))
+	}
+	if !ok {
+		return nil, fmt.Errorf("plugin [%s] exports a hook with the wrong signature", path)
+	}
+	if gp.connect == nil && gp.message == nil && gp.command == nil && gp.disconnect == nil {
+		return nil, fmt.Errorf("plugin [%s] exports no hooks", path)
+	}
+	return gp, nil
+}
+
+func (gp *goPlugin) Connect(nick string) {
+	if gp.connect != nil {
+		gp.connect(nick)
+	}
+}
+
+func (gp *goPlugin) Message(nick, room, text string) (string, bool) {
+	if gp.message == nil {
+		return text, true
+	}
+	return gp.message(nick, room, text)
+}
+
+func (gp *goPlugin) Command(nick string, args []string) (string, bool) {
+	if gp.command == nil {
+		return "", false
+	}
+	return gp.command(nick, args)
+}
+
+func (gp *goPlugin) Disconnect(nick string) {
+	if gp.disconnect != nil {
+		gp.disconnect(nick)
+	}
+}
+
+// PluginArgs is sent to every hook of an out of process plugin
+type PluginArgs struct {
+	Nick string   `json:"nick"`
+	Room string   `json:"room,omitempty"`
+	Text string   `json:"text,omitempty"`
+	Args []string `json:"args,omitempty"`
+}
+
+// PluginReply is returned by the hooks of an out of process plugin, Drop
+// drops a message and Handled claims a command
+type PluginReply struct {
+	Text    string `json:"text,omitempty"`
+	Drop    bool   `json:"drop,omitempty"`
+	Handled bool   `json:"handled,omitempty"`
+}
+
+// rpcPlugin is a plugin running in another process, the server calls the
+// methods Plugin.Connect, Plugin.Message, Plugin.Command, and
+// Plugin.Disconnect over JSON-RPC on the plugin's stdin and stdout
+type rpcPlugin struct {
+	name   string
+	client *rpc.Client
+}
+
+// pluginPipe joins the stdin and stdout of a plugin process
+type pluginPipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func startRPCPlugin(spec string) (*rpcPlugin, error) {
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty plugin command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		errl(fmt.Errorf("plugin [%s] exited: %v", spec, cmd.Wait()), "")
+	}()
+
+	return newRPCPlugin(spec, pluginPipe{out, in}), nil
+}
+
+func newRPCPlugin(name string, conn io.ReadWriteCloser) *rpcPlugin {
+	return &rpcPlugin{name: name, client: jsonrpc.NewClient(conn)}
+}
+
+// call invokes a hook, errors and timeouts are logged and leave reply as is
+func (rp *rpcPlugin) call(method string, args PluginArgs, reply *PluginReply) bool {
+	call := rp.client.Go("Plugin."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			errl(fmt.Errorf("plugin [%s] %s failed: %v", rp.name, method, call.Error), "")
+			return false
+		}
+		return true
+	case <-time.After(pluginTimeout):
+		errl(fmt.Errorf("plugin [%s] %s timed out", rp.name, method), "")
+		return false
+	}
+}
+
+func (rp *rpcPlugin) Connect(nick string) {
+	rp.call("Connect", PluginArgs{Nick: nick}, &PluginReply{})
+}
+
+func (rp *rpcPlugin) Message(nick, room, text string) (string, bool) {
+	var reply PluginReply
+	if !rp.call("Message", PluginArgs{Nick: nick, Room: room, Text: text}, &reply) {
+		return text, true
+	}
+	if reply.Drop {
+		return "", false
+	}
+	if reply.Text == "" {
+		return text, true
+	}
+	return reply.Text, true
+}
+
+func (rp *rpcPlugin) Command(nick string, args []string) (string, bool) {
+	var reply PluginReply
+	if !rp.call("Command", PluginArgs{Nick: nick, Args: args}, &reply) {
+		return "", false
+	}
+	return reply.Text, reply.Handled
+}
+
+func (rp *rpcPlugin) Disconnect(nick string) {
+	rp.call("Disconnect", PluginArgs{Nick: nick}, &PluginReply{})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"testing"
+)
+
+type filterPlugin struct {
+	connected []string
+}
+
+func (f *filterPlugin) Connect(nick string) {
+	f.connected = append(f.connected, nick)
+}
+
+func (f *filterPlugin) Message(nick, room, text string) (string, bool) {
+	if strings.Contains(text, "joker") {
+		return "", false
+	}
+	return strings.ToUpper(text), true
+}
+
+func (f *filterPlugin) Command(nick string, args []string) (string, bool) {
+	if args[0] == "/ping" {
+		return "pong", true
+	}
+	return "", false
+}
+
+func (f *filterPlugin) Disconnect(nick string) {}
+
+func TestPlugins(t *testing.T) {
+	serv := NewServer()
+	f := &filterPlugin{}
+	serv.Plugins = NewPlugins(f)
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	say(serv, batman, []string{"hello", "gotham"})
+	say(serv, batman, []string{"the", "joker"})
+
+	r := serv.Rooms["gotham"]
+	if len(r.History) != 1 || r.History[0].Text != "HELLO GOTHAM" {
+		t.Errorf("expected one rewritten message, got %+v", r.History)
+	}
+
+	if out, ok := serv.Plugins.Command("batman", []string{"/ping"}); !ok || out != "pong\r\n" {
+		t.Errorf("expected pong, got %q", out)
+	}
+	if _, ok := serv.Plugins.Command("batman", []string{"ping"}); ok {
+		t.Errorf("expected plain text not to be offered as a command")
+	}
+
+	serv.Plugins.Connect("robin")
+	if len(f.connected) != 1 || f.connected[0] != "robin" {
+		t.Errorf("expected connect hook to be called")
+	}
+}
+
+type echoPlugin struct{}
+
+func (echoPlugin) Connect(args PluginArgs, reply *PluginReply) error { return nil }
+
+func (echoPlugin) Message(args PluginArgs, reply *PluginReply) error {
+	reply.Drop = args.Room == "arkham"
+	reply.Text = args.Nick + ": " + args.Text
+	return nil
+}
+
+func (echoPlugin) Command(args PluginArgs, reply *PluginReply) error {
+	reply.Handled = args.Args[0] == "/echo"
+	reply.Text = strings.Join(args.Args[1:], " ")
+	return nil
+}
+
+func (echoPlugin) Disconnect(args PluginArgs, reply *PluginReply) error { return nil }
+
+func TestRPCPlugin(t *testing.T) {
+	c1, c2 := net.Pipe()
+	srv := rpc.NewServer()
+	srv.RegisterName("Plugin", echoPlugin{})
+	go srv.ServeCodec(jsonrpc.NewServerCodec(c2))
+
+	p := newRPCPlugin("echo", c1)
+	p.Connect("batman")
+
+	if text, ok := p.Message("batman", "gotham", "hello"); !ok || text != "batman: hello" {
+		t.Errorf("expected rewritten message, got %q", text)
+	}
+	if _, ok := p.Message("batman", "arkham", "hello"); ok {
+		t.Errorf("expected message to be dropped")
+	}
+	if out, ok := p.Command("batman", []string{"/echo", "to", "the", "batcave"}); !ok || out != "to the batcave" {
+		t.Errorf("expected command to be handled, got %q", out)
+	}
+	if _, ok := p.Command("batman", []string{"/nope"}); ok {
+		t.Errorf("expected command not to be handled")
+	}
+
+	// a plugin that went away leaves messages alone
+	c2.Close()
+	if text, ok := p.Message("batman", "gotham", "hello"); !ok || text != "hello" {
+		t.Errorf("expected message to pass through, got %q", text)
+	}
+}
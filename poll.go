@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPollOptions is how many options a poll may offer
+const maxPollOptions = 10
+
+// Poll is a question put to a room, each member gets one vote which they
+// may change until the poll is closed
+type Poll struct {
+	Question string
+	Options  []string
+	Creator  string
+	votes    map[string]int
+}
+
+// splitQuoted splits s on spaces, keeping double quoted runs together
+func splitQuoted(s string) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+	quoted, inWord := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			if quoted {
+				out = append(out, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if inWord {
+				out = append(out, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("missing closing quote\r\n")
+	}
+	if inWord {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// voter returns who a vote is counted for, the account if logged in
+func voter(cl *Client) string {
+	if a := cl.Account(); a != "" {
+		return a
+	}
+	return cl.Nick()
+}
+
+// tally returns the votes of each option
+func (p *Poll) tally() string {
+	counts := make([]int, len(p.Options))
+	for _, v := range p.votes {
+		counts[v]++
+	}
+	var parts []string
+	for i, o := range p.Options {
+		parts = append(parts, fmt.Sprintf("%d) %s: %d", i+1, o, counts[i]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pollEvent returns a poll notice for the room
+func pollEvent(room, text string) Event {
+	return Event{
+		Type: EventService,
+		Time: time.Now().Format(time.RFC3339),
+		From: "poll",
+		Room: room,
+		Text: text,
+	}
+}
+
+// StartPoll opens a poll in the client's room, a room has one poll at a time
+func (s *Server) StartPoll(cl *Client, question string, options []string) error {
+	if question == "" || len(options) < 2 {
+		return errors.New("a poll needs a question and at least 2 options\r\n")
+	}
+	if len(options) > maxPollOptions {
+		return fmt.Errorf("a poll can have at most %d options\r\n", maxPollOptions)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	if r.Poll != nil {
+		return fmt.Errorf("room [%s] already has a poll, /poll close ends it\r\n", r.Name)
+	}
+
+	r.Poll = &Poll{Question: question, Options: options, Creator: cl.Nick(), votes: make(map[string]int)}
+	var opts []string
+	for i, o := range options {
+		opts = append(opts, fmt.Sprintf("%d) %s", i+1, o))
+	}
+	return s.deliver(pollEvent(r.Name, fmt.Sprintf("[%s] asks: %s %s, vote with /vote <number>", cl.Nick(), question, strings.Join(opts, " "))))
+}
+
+// Vote records the client's vote in the poll of its room and returns the
+// option voted for
+func (s *Server) Vote(cl *Client, choice string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return "", err
+	}
+	if r.Poll == nil {
+		return "", errors.New("there is no poll in this room\r\n")
+	}
+
+	n, err := strconv.Atoi(choice)
+	if err != nil || n < 1 || n > len(r.Poll.Options) {
+		return "", fmt.Errorf("vote for an option from 1 to %d\r\n", len(r.Poll.Options))
+	}
+	r.Poll.votes[voter(cl)] = n - 1
+	return r.Poll.Options[n-1], nil
+}
+
+// PollStatus returns the question and current tallies of the room's poll
+func (s *Server) PollStatus(cl *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return "", err
+	}
+	if r.Poll == nil {
+		return "", errors.New("there is no poll in this room\r\n")
+	}
+	return fmt.Sprintf("Poll [%s]: %s\r\n", r.Poll.Question, r.Poll.tally()), nil
+}
+
+// ClosePoll ends the poll of the client's room and tells the room the
+// results, only whoever started the poll and the room's moderators may
+func (s *Server) ClosePoll(cl *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	if r.Poll == nil {
+		return errors.New("there is no poll in this room\r\n")
+	}
+	if r.Poll.Creator != cl.Nick() && !s.moderates(r, cl) {
+		return errors.New("only whoever started the poll, the owner of the room, its ops, and moderators can close it\r\n")
+	}
+
+	p := r.Poll
+	r.Poll = nil
+	return s.deliver(pollEvent(r.Name, fmt.Sprintf("Poll closed by [%s]: %s %s (%d votes)", cl.Nick(), p.Question, p.tally(), len(p.votes))))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitQuoted(t *testing.T) {
+	words, err := splitQuoted(`"who is the best robin?" dick "jason todd" tim`)
+	if err != nil || strings.Join(words, "|") != "who is the best robin?|dick|jason todd|tim" {
+		t.Errorf("unexpected split %q %v", words, err)
+	}
+	if _, err := splitQuoted(`"unfinished`); err == nil {
+		t.Errorf("expected missing quote to fail")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	robin := &Client{nick: "robin"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+	serv.JoinRoom("gotham", joker)
+
+	if err := serv.StartPoll(robin, "best gadget?", []string{"batarang"}); err == nil {
+		t.Errorf("expected a poll to need two options")
+	}
+	if err := serv.StartPoll(robin, "best gadget?", []string{"batarang", "grapple", "cape"}); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := serv.StartPoll(batman, "another?", []string{"a", "b"}); err == nil {
+		t.Errorf("expected one poll per room")
+	}
+
+	serv.Vote(batman, "1")
+	serv.Vote(joker, "1")
+	if option, err := serv.Vote(joker, "3"); err != nil || option != "cape" {
+		t.Errorf("expected joker to change vote, got %q %v", option, err)
+	}
+	if _, err := serv.Vote(joker, "4"); err == nil {
+		t.Errorf("expected out of range vote to fail")
+	}
+
+	out, _ := serv.PollStatus(batman)
+	if out != "Poll [best gadget?]: 1) batarang: 1, 2) grapple: 0, 3) cape: 1\r\n" {
+		t.Errorf("unexpected status %q", out)
+	}
+
+	if err := serv.ClosePoll(joker); err == nil {
+		t.Errorf("expected joker not to close the poll")
+	}
+	if err := serv.ClosePoll(robin); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	r := serv.Rooms["gotham"]
+	if len(r.History) != 2 || !strings.HasSuffix(r.History[1].Text, "1) batarang: 1, 2) grapple: 0, 3) cape: 1 (2 votes)") {
+		t.Errorf("expected results to be announced, got %+v", r.History)
+	}
+	if _, err := serv.Vote(batman, "1"); err == nil {
+		t.Errorf("expected no poll after close")
+	}
+}
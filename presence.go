@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxStatusText is how long a custom status may be
+const maxStatusText = 64
+
+// Away returns the away message of the client, empty while present
+func (cl *Client) Away() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.away
+}
+
+// Status returns the presence of the client, offline while its session
+// waits to be resumed, away while it has an away message, or else online
+func (cl *Client) Status() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	switch {
+	case cl.expire != nil && len(cl.Conns) == 0:
+		return StatusOffline
+	case cl.away != "":
+		return StatusAway
+	}
+	return StatusOnline
+}
+
+// StatusText returns the status the client set for others to see
+func (cl *Client) StatusText() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.status
+}
+
+// SetStatusText sets the status others see next to the client's nick, it is
+// kept with the account of clients logged in, an empty text clears it
+func (s *Server) SetStatusText(cl *Client, text string) error {
+	if utf8.RuneCountInString(text) > maxStatusText {
+		return fmt.Errorf("a status can be at most %d characters\r\n", maxStatusText)
+	}
+	if a := cl.Account(); a != "" {
+		if err := s.Accounts.Update(a, func(acct *Account) { acct.Status = text }); err != nil {
+			return err
+		}
+	}
+	cl.mu.Lock()
+	cl.status = text
+	cl.mu.Unlock()
+	return nil
+}
+
+// SetAway marks the client away with the message, an empty message marks
+// it back
+func (s *Server) SetAway(cl *Client, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl.mu.Lock()
+	cl.away = message
+	cl.idle = false
+	cl.mu.Unlock()
+	s.announce(cl)
+}
+
+// announce tells the bridges and the other nodes of the client's presence
+// after it changed, and the client's room if it opted into presence
+// notices, it must be called with the server lock held
+func (s *Server) announce(cl *Client) {
+	ev := Event{
+		Type:   EventPresence,
+		Time:   time.Now().Format(time.RFC3339),
+		From:   cl.Nick(),
+		Status: cl.Status(),
+	}
+	if ev.Status == StatusAway {
+		ev.Text = cl.Away()
+	}
+	if ev.Status == StatusOffline {
+		s.sawLast(cl)
+	}
+	if r, err := s.findRoom(cl); err == nil {
+		ev.Room = r.Name
+		for _, c := range r.Clients {
+			if c != cl && r.Notices {
+				c.Send(ev)
+			}
+		}
+	}
+	s.emit(ev)
+}
+
+// SetNotices makes the client's room see, or stop seeing, when its members
+// go away, come back, or go offline, only the owner of the room, its ops,
+// and moderators may change it
+func (s *Server) SetNotices(cl *Client, on bool) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can change presence notices\r\n")
+	}
+	s.mu.Unlock()
+
+	return s.change(raftCommand{Op: raftRoom, Room: r.Name, Notices: on, Fields: []string{"notices"}})
+}
+
+// sawLast records when the client's account was last connected, it must be
+// called with the server lock held
+func (s *Server) sawLast(cl *Client) {
+	a := cl.Account()
+	if a == "" {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]time.Time)
+	}
+	s.seen[a] = time.Now()
+}
+
+// Whois describes a nick, whether it is connected here or elsewhere in
+// the cluster
+func (s *Server) Whois(nick string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.Clients[nick]; ok {
+		var b strings.Builder
+		status := c.Status()
+		if status == StatusAway {
+			status = fmt.Sprintf("%s (%s)", StatusAway, c.Away())
+		}
+		fmt.Fprintf(&b, "[%s] is %s", nick, status)
+		if c.Bot() {
+			b.WriteString(", a [bot]")
+		}
+		if r, err := s.findRoom(c); err == nil {
+			fmt.Fprintf(&b, " in room [%s]", r.Display)
+		}
+		if a := c.Account(); a != "" {
+			fmt.Fprintf(&b, ", logged in as [%s]", a)
+		}
+		if text := c.StatusText(); text != "" {
+			fmt.Fprintf(&b, ", status [%s]", text)
+		}
+		if idle, ok := c.Idle(); ok {
+			fmt.Fprintf(&b, ", idle %s", idle.Truncate(time.Second))
+		}
+		if key := c.PublicKey(); key != "" {
+			fmt.Fprintf(&b, ", key fingerprint [%s]", fingerprint(key))
+		}
+		b.WriteString("\r\n")
+		return b.String(), nil
+	}
+
+	if e, ok := s.Gossip.Lookup(nick); ok {
+		status := e.Status
+		if e.Away != "" {
+			status = fmt.Sprintf("%s (%s)", StatusAway, e.Away)
+		}
+		return fmt.Sprintf("[%s] is %s on node [%s]\r\n", nick, status, e.Node), nil
+	}
+
+	if s.remoteNick(nick) {
+		return fmt.Sprintf("[%s] is %s on another server\r\n", nick, StatusOnline), nil
+	}
+
+	if s.Accounts.Exists(nick) {
+		if t, ok := s.seen[nick]; ok {
+			return fmt.Sprintf("[%s] is %s, last seen %s\r\n", nick, StatusOffline, t.Format(time.RFC1123)), nil
+		}
+		return fmt.Sprintf("[%s] is %s\r\n", nick, StatusOffline), nil
+	}
+
+	return "", fmt.Errorf("user [%s] does not exist\r\n", nick)
+}
+
+// Capabilities lists what the connection has switched on
+func (c *Conn) Capabilities() []string {
+	var caps []string
+	if c.JSON() {
+		caps = append(caps, "json")
+	}
+	if c.Color() {
+		caps = append(caps, "color")
+	}
+	if c.IDs() {
+		caps = append(caps, "ids")
+	}
+	return caps
+}
+
+// Whoami describes the client's own session, its nick, account, room and
+// status, and for each connection where it comes from, since when, and its
+// capabilities, cur marks the connection asking
+func (s *Server) Whoami(cl *Client, cur *Conn) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are [%s]", cl.Nick())
+	switch a := cl.Account(); {
+	case a != "":
+		fmt.Fprintf(&b, ", logged in as [%s]", a)
+	case s.Accounts.Exists(cl.Nick()):
+		b.WriteString(", a registered nick, not logged in")
+	default:
+		b.WriteString(", not registered")
+	}
+	if r, err := s.findRoom(cl); err == nil {
+		fmt.Fprintf(&b, ", in room [%s]", r.Display)
+	}
+	fmt.Fprintf(&b, ", %s", cl.Status())
+	if text := cl.StatusText(); text != "" {
+		fmt.Fprintf(&b, ", status [%s]", text)
+	}
+	b.WriteString("\r\n")
+
+	cl.mu.Lock()
+	conns := append([]*Conn{}, cl.Conns...)
+	cl.mu.Unlock()
+	for _, cn := range conns {
+		addr := "unknown"
+		if cn.Conn != nil {
+			addr = cn.RemoteAddr().String()
+		}
+		fmt.Fprintf(&b, "Connected from %s since %s, capabilities: %s", addr, cn.Connected.Format(time.RFC1123), listOrNone(cn.Capabilities()))
+		if cn == cur {
+			b.WriteString(" (this connection)")
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// Who lists the members of the client's room, bots are marked [bot], ops
+// [op], away members [away], and members whose session waits to be
+// resumed [offline], members idle for a minute or more show for how long,
+// and members with a status show it quoted
+func (s *Server) Who(cl *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return "", err
+	}
+
+	var members []string
+	for nick, c := range r.Clients {
+		if c.Bot() {
+			nick = nick + " [bot]"
+		} else if r.opped(c) {
+			nick = nick + " [op]"
+		} else if status := c.Status(); status != StatusOnline {
+			nick = nick + " [" + status + "]"
+		}
+		if idle, ok := c.Idle(); ok && idle >= idleShown {
+			nick = fmt.Sprintf("%s (idle %s)", nick, idle.Truncate(time.Minute))
+		}
+		if text := c.StatusText(); text != "" {
+			nick = fmt.Sprintf("%s %q", nick, text)
+		}
+		members = append(members, nick)
+	}
+	sort.Strings(members)
+	return fmt.Sprintf("Members of room [%s]: %s\r\n", r.Display, strings.Join(members, ", ")), nil
+}
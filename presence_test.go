@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPresence(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	c1, _ := net.Pipe()
+	conn := NewConn(c1)
+	batman, robin := &Client{nick: "batman", account: "batman", Conns: []*Conn{conn}}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", robin)
+	token, _ := serv.NewSession(batman)
+
+	last := func() Event { return robin.unread[len(robin.unread)-1] }
+
+	// rooms only see presence changes once they opt in
+	n := len(robin.unread)
+	serv.SetAway(batman, "brooding")
+	if len(robin.unread) != n {
+		t.Errorf("expected no presence notice, got %+v", last())
+	}
+	if err := serv.SetNotices(robin, true); err == nil {
+		t.Errorf("expected only the owner to turn on presence notices")
+	}
+	if err := serv.SetNotices(batman, true); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	serv.SetAway(batman, "patrolling")
+	if ev := last(); ev.Type != EventPresence || ev.Status != StatusAway || ev.Text != "patrolling" {
+		t.Errorf("expected batman away, got %+v", ev)
+	}
+	if out, _ := serv.Who(robin); out != "Members of room [batcave]: batman [away], robin\r\n" {
+		t.Errorf("unexpected who [%s]", out)
+	}
+
+	serv.Detach(batman, conn)
+	if ev := last(); ev.Status != StatusOffline || batman.Status() != StatusOffline {
+		t.Errorf("expected batman offline, got %+v", ev)
+	}
+	if out, _ := serv.Whois("batman"); !strings.Contains(out, "is offline") {
+		t.Errorf("expected whois to show batman offline, got [%s]", out)
+	}
+
+	if _, err := serv.Resume(token, &Client{nick: "user1"}); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if ev := last(); ev.Status != StatusAway {
+		t.Errorf("expected batman back and still away, got %+v", ev)
+	}
+	serv.SetAway(batman, "")
+	if ev := last(); ev.Status != StatusOnline || ev.String() != "[batman] is online\r\n" {
+		t.Errorf("expected batman online, got %+v", ev)
+	}
+
+	// registered accounts that left are offline, with when they were seen
+	serv.mu.Lock()
+	serv.tryDeleteFromRoom(batman)
+	delete(serv.Clients, "batman")
+	serv.mu.Unlock()
+	if out, _ := serv.Whois("batman"); !strings.Contains(out, "[batman] is offline, last seen") {
+		t.Errorf("expected last seen, got [%s]", out)
+	}
+	if _, err := serv.Whois("joker"); err == nil {
+		t.Errorf("expected an unknown nick to fail")
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman, robin := &Client{nick: "batman", account: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", robin)
+
+	if err := serv.SetStatusText(robin, strings.Repeat("x", maxStatusText+1)); err == nil {
+		t.Errorf("expected a long status to be refused")
+	}
+	serv.SetStatusText(batman, "in a meeting")
+	if out, _ := serv.Who(robin); out != "Members of room [batcave]: batman \"in a meeting\", robin\r\n" {
+		t.Errorf("unexpected who [%s]", out)
+	}
+	if out, _ := serv.Whois("batman"); !strings.Contains(out, ", status [in a meeting]") {
+		t.Errorf("expected whois to show the status, got [%s]", out)
+	}
+
+	// the status is kept with the account
+	serv.mu.Lock()
+	serv.tryDeleteFromRoom(batman)
+	delete(serv.Clients, "batman")
+	serv.mu.Unlock()
+	again := &Client{nick: "user1"}
+	serv.JoinRoom("batcave", again)
+	if _, err := serv.Login("batman", "alfred", again); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if again.StatusText() != "in a meeting" {
+		t.Errorf("expected the status restored at login, got [%s]", again.StatusText())
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	c1, _ := net.Pipe()
+	conn, other := NewConn(c1), NewConn(nil)
+	conn.SetJSON(true)
+	conn.SetIDs(true)
+	batman := &Client{nick: "batman", account: "batman", Conns: []*Conn{conn, other}}
+	serv.JoinRoom("batcave", batman)
+
+	out := serv.Whoami(batman, conn)
+	if !strings.HasPrefix(out, "You are [batman], logged in as [batman], in room [batcave], online\r\n") {
+		t.Errorf("expected the session described, got [%s]", out)
+	}
+	if !strings.Contains(out, "capabilities: json, ids (this connection)\r\n") {
+		t.Errorf("expected the capabilities of this connection, got [%s]", out)
+	}
+	if !strings.Contains(out, "Connected from unknown since") || !strings.Contains(out, "capabilities: none\r\n") {
+		t.Errorf("expected the other connection listed, got [%s]", out)
+	}
+
+	robin := &Client{nick: "robin"}
+	if out := serv.Whoami(robin, nil); out != "You are [robin], not registered, online\r\n" {
+		t.Errorf("expected a guest without a room, got [%s]", out)
+	}
+}
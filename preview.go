@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// previewQueue is how many messages may wait for a preview before new
+	// ones are dropped
+	previewQueue = 64
+	// previewTimeout bounds a whole fetch, redirects included
+	previewTimeout = 5 * time.Second
+	// previewMaxBytes is how much of a page is read looking for its title
+	previewMaxBytes = 64 << 10
+	// previewMaxRedirects is how many redirects a fetch follows
+	previewMaxRedirects = 3
+	// previewMaxTitle is the longest title posted, in runes
+	previewMaxTitle = 120
+)
+
+var (
+	urlRe   = regexp.MustCompile(`https?://[^\s<>"]+`)
+	titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+	errNotPublic = errors.New("address is not public")
+)
+
+// privateNets are the ranges previews never connect to, so a message can't
+// make the server probe its own network
+var privateNets = parseNets(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15",
+	"224.0.0.0/4", "240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// parseNets parses CIDRs known to be valid
+func parseNets(cidrs ...string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// publicIP returns true if ip is outside every private range
+func publicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowDialer returns a dialer that only connects to addresses allow
+// accepts, checked after DNS so a name can't point around it
+func allowDialer(timeout time.Duration, allow func(net.IP) bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return fmt.Errorf("%s: %v", host, errNotPublic)
+			}
+			return nil
+		},
+	}
+}
+
+// Previews posts the title of the first link of room messages as a short
+// preview line, fetches happen one at a time off the message path
+type Previews struct {
+	client  *http.Client
+	queue   chan Event
+	deliver func(Event) error
+}
+
+// NewPreviews returns previews posted with deliver, fetches only connect
+// to addresses allow accepts, checked after DNS so a name can't point
+// around it
+func NewPreviews(deliver func(Event) error, allow func(net.IP) bool) *Previews {
+	dialer := allowDialer(previewTimeout, allow)
+	p := &Previews{
+		client: &http.Client{
+			Timeout: previewTimeout,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   previewTimeout,
+				ResponseHeaderTimeout: previewTimeout,
+				MaxIdleConns:          4,
+				IdleConnTimeout:       30 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= previewMaxRedirects {
+					return fmt.Errorf("stopped after %d redirects", previewMaxRedirects)
+				}
+				return checkPreviewURL(req.URL)
+			},
+		},
+		queue:   make(chan Event, previewQueue),
+		deliver: deliver,
+	}
+	go p.run()
+	return p
+}
+
+// Fire queues a preview of the message's first link, it never blocks the
+// caller
+func (p *Previews) Fire(ev Event) {
+	if p == nil || ev.Type != EventMessage || !urlRe.MatchString(ev.Text) {
+		return
+	}
+	select {
+	case p.queue <- ev:
+	default:
+		errl(fmt.Errorf("preview queue is full, dropping message %d", ev.ID), "")
+	}
+}
+
+// run previews queued messages until the queue is closed
+func (p *Previews) run() {
+	for ev := range p.queue {
+		link := urlRe.FindString(ev.Text)
+		title, err := p.title(link)
+		if err != nil {
+			errl(err, fmt.Sprintf("Unable to preview %s", link))
+			continue
+		}
+		if title == "" {
+			continue
+		}
+		u, _ := url.Parse(link)
+		err = p.deliver(Event{
+			Type:   EventService,
+			Time:   time.Now().Format(time.RFC3339),
+			From:   "preview",
+			Room:   ev.Room,
+			Parent: ev.ID,
+			Text:   fmt.Sprintf("%s: %s", u.Hostname(), title),
+		})
+		if err != nil {
+			errl(err, "")
+		}
+	}
+}
+
+// checkPreviewURL refuses links that aren't plain http or https
+func checkPreviewURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unable to preview %s links", u.Scheme)
+	}
+	if u.User != nil || u.Hostname() == "" {
+		return fmt.Errorf("unable to preview %s", u.Redacted())
+	}
+	return nil
+}
+
+// title fetches link and returns the title of the page, empty if it isn't
+// HTML or has none within the first previewMaxBytes
+func (p *Previews) title(link string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(link, ".,;:!?)]'"))
+	if err != nil {
+		return "", err
+	}
+	if err := checkPreviewURL(u); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "tinychat-preview")
+	req.Header.Set("Accept", "text/html")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s returned %s", u.Redacted(), resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" {
+		return "", nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, previewMaxBytes))
+	if err != nil {
+		return "", err
+	}
+	m := titleRe.FindSubmatch(b)
+	if m == nil {
+		return "", nil
+	}
+	return cleanTitle(string(m[1])), nil
+}
+
+// cleanTitle unescapes a title, folds its whitespace, drops control
+// characters, and shortens it to previewMaxTitle runes
+func cleanTitle(title string) string {
+	title = strings.Join(strings.Fields(html.UnescapeString(title)), " ")
+	title = strings.Map(func(c rune) rune {
+		if c < ' ' || c == 0x7f {
+			return -1
+		}
+		return c
+	}, title)
+	return truncate(title, previewMaxTitle)
+}
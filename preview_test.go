@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"::ffff:10.0.0.1": false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("expected public %v for %s, got %v", want, addr, got)
+		}
+	}
+}
+
+func TestPreviewTitle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><head><TITLE>\n  Wayne &amp; Enterprises\x1b[31m </TITLE></head></html>")
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, strings.Repeat(" ", previewMaxBytes)+"<title>too far</title>")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "<title>not html</title>")
+		case "/hop":
+			http.Redirect(w, r, "/hop", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	local := NewPreviews(nil, func(net.IP) bool { return true })
+	if title, err := local.title(ts.URL + "/page"); err != nil || title != "Wayne & Enterprises[31m" {
+		t.Errorf("expected the page title, got [%q] %v", title, err)
+	}
+	for _, path := range []string{"/big", "/image"} {
+		if title, err := local.title(ts.URL + path); err != nil || title != "" {
+			t.Errorf("expected no title for %s, got [%q] %v", path, title, err)
+		}
+	}
+	if _, err := local.title(ts.URL + "/hop"); err == nil {
+		t.Errorf("expected endless redirects to fail")
+	}
+	if _, err := local.title("ftp://example.com/file"); err == nil {
+		t.Errorf("expected ftp links to be refused")
+	}
+
+	public := NewPreviews(nil, publicIP)
+	if _, err := public.title(ts.URL + "/page"); err == nil || !strings.Contains(err.Error(), errNotPublic.Error()) {
+		t.Errorf("expected a loopback fetch to be refused, got %v", err)
+	}
+}
+
+func TestPreviewPosted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>The Daily Planet</title>")
+	}))
+	defer ts.Close()
+
+	serv := NewServer()
+	serv.Previews = NewPreviews(serv.Deliver, func(net.IP) bool { return true })
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("batcave", batman)
+	serv.Message([]string{"read", ts.URL + "/story,", "now"}, batman)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		batman.mu.Lock()
+		unread := append([]Event{}, batman.unread...)
+		batman.mu.Unlock()
+		for _, ev := range unread {
+			if ev.From == "preview" {
+				if !strings.HasSuffix(ev.Text, ": The Daily Planet") || ev.Parent == 0 {
+					t.Errorf("unexpected preview %+v", ev)
+				}
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected a preview to be posted")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// how addresses are written to logs and the moderation log
+const (
+	LogIPsFull     = "full"
+	LogIPsTruncate = "truncate"
+	LogIPsHash     = "hash"
+)
+
+// logIPs is how addresses are logged, hashes are keyed with salt so they
+// can't be reversed by hashing every address, but the same address always
+// logs the same while the salt is kept
+var logIPs = struct {
+	sync.RWMutex
+	mode string
+	salt []byte
+}{mode: LogIPsFull}
+
+// randomSalt keys hashes when TCLogIPSalt isn't set, they then only
+// correlate until the server restarts
+var randomSalt = func() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}()
+
+// configureLogIPs sets how addresses are logged, full by default, salt
+// keeps hashes the same across restarts
+func configureLogIPs(mode, salt string) error {
+	switch mode {
+	case "":
+		mode = LogIPsFull
+	case LogIPsFull, LogIPsTruncate, LogIPsHash:
+	default:
+		return fmt.Errorf("TCLogIPs must be %s, %s, or %s", LogIPsFull, LogIPsTruncate, LogIPsHash)
+	}
+	key := randomSalt
+	if salt != "" {
+		key = []byte(salt)
+	}
+	logIPs.Lock()
+	defer logIPs.Unlock()
+	logIPs.mode, logIPs.salt = mode, key
+	return nil
+}
+
+// anonymizeIP returns ip as it may be logged, truncated to its /24 or /48
+// network, or a short keyed hash
+func anonymizeIP(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+	logIPs.RLock()
+	mode, salt := logIPs.mode, logIPs.salt
+	logIPs.RUnlock()
+
+	switch mode {
+	case LogIPsTruncate:
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	case LogIPsHash:
+		mac := hmac.New(sha256.New, salt)
+		mac.Write(ip.To16())
+		return "ip-" + hex.EncodeToString(mac.Sum(nil)[:6])
+	}
+	return ip.String()
+}
+
+// logAddr returns the remote address of a connection as it may be logged,
+// the port is only kept when addresses are logged in full
+func logAddr(addr net.Addr) string {
+	if addr == nil {
+		return "unknown"
+	}
+	logIPs.RLock()
+	full := logIPs.mode == LogIPsFull
+	logIPs.RUnlock()
+	if full {
+		return addr.String()
+	}
+	return anonymizeIP(addrIP(addr))
+}
+
+// cloakKey keys the hostmasks shown instead of addresses, TCCloakKey keeps
+// them the same across restarts
+var cloakKey = struct {
+	sync.RWMutex
+	key []byte
+}{key: randomSalt}
+
+// configureCloak sets the key of hostmasks, empty picks a random one
+func configureCloak(key string) {
+	cloakKey.Lock()
+	defer cloakKey.Unlock()
+	cloakKey.key = randomSalt
+	if key != "" {
+		cloakKey.key = []byte(key)
+	}
+}
+
+// cloak returns the hostmask shown for ip to those who may not see it,
+// like IRC networks do, each part hashes a wider network, so the same
+// address always gets the same mask and neighbours share its last parts
+func cloak(ip net.IP) string {
+	if ip == nil {
+		return "unknown"
+	}
+	cloakKey.RLock()
+	key := cloakKey.key
+	cloakKey.RUnlock()
+
+	bits, widths := 128, []int{128, 64, 48}
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, widths = v4, 32, []int{32, 24, 16}
+	}
+	var parts []string
+	for _, w := range widths {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(ip.Mask(net.CIDRMask(w, bits)))
+		parts = append(parts, strings.ToUpper(hex.EncodeToString(mac.Sum(nil)[:4])))
+	}
+	return strings.Join(parts, ".") + ".IP"
+}
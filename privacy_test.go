@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	defer configureLogIPs("", "")
+
+	v4, v6 := net.ParseIP("192.0.2.77"), net.ParseIP("2001:db8:1234:5678::1")
+	addr := &net.TCPAddr{IP: v4, Port: 40000}
+	if got := logAddr(addr); got != "192.0.2.77:40000" {
+		t.Errorf("expected the full address by default, got [%s]", got)
+	}
+
+	configureLogIPs(LogIPsTruncate, "")
+	if got := anonymizeIP(v4); got != "192.0.2.0/24" {
+		t.Errorf("expected a /24, got [%s]", got)
+	}
+	if got := anonymizeIP(v6); got != "2001:db8:1234::/48" {
+		t.Errorf("expected a /48, got [%s]", got)
+	}
+	if got := logAddr(addr); got != "192.0.2.0/24" {
+		t.Errorf("expected the port to be dropped, got [%s]", got)
+	}
+
+	configureLogIPs(LogIPsHash, "pepper")
+	hashed := anonymizeIP(v4)
+	if !strings.HasPrefix(hashed, "ip-") || strings.Contains(hashed, "192") || anonymizeIP(net.ParseIP("192.0.2.77")) != hashed {
+		t.Errorf("expected a stable hash, got [%s]", hashed)
+	}
+	if anonymizeIP(net.ParseIP("192.0.2.78")) == hashed {
+		t.Errorf("expected other addresses to hash differently")
+	}
+	configureLogIPs(LogIPsHash, "salt")
+	if anonymizeIP(v4) == hashed {
+		t.Errorf("expected the salt to key the hash")
+	}
+
+	if err := configureLogIPs("scramble", ""); err == nil {
+		t.Errorf("expected an unknown mode to be refused")
+	}
+}
+
+func TestBanLogAnonymized(t *testing.T) {
+	defer configureLogIPs("", "")
+	configureLogIPs(LogIPsTruncate, "")
+
+	serv := NewServer()
+	serv.banAddr(net.ParseIP("192.0.2.77"), 0, "flood")
+	if log := serv.modlog; len(log) != 1 || log[0].Target != "192.0.2.0/24" {
+		t.Errorf("expected the moderation log to hold the network, got %+v", log)
+	}
+}
+
+// addrConn is a connection from a given address
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestCloak(t *testing.T) {
+	defer configureCloak("")
+	configureCloak("s3cret")
+
+	a, b := cloak(net.ParseIP("192.0.2.77")), cloak(net.ParseIP("192.0.2.78"))
+	if a != cloak(net.ParseIP("192.0.2.77")) || !strings.HasSuffix(a, ".IP") || strings.Contains(a, "192") {
+		t.Errorf("expected a stable cloak, got [%s]", a)
+	}
+	if a == b || a[strings.Index(a, "."):] != b[strings.Index(b, "."):] {
+		t.Errorf("expected neighbours to share the wider parts, got [%s] [%s]", a, b)
+	}
+	if v6 := cloak(net.ParseIP("2001:db8::1")); len(strings.Split(v6, ".")) != 4 {
+		t.Errorf("expected three parts for IPv6, got [%s]", v6)
+	}
+
+	serv := NewServer()
+	pipe, _ := net.Pipe()
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.77"), Port: 40000}
+	batman := &Client{nick: "batman", Conns: []*Conn{NewConn(addrConn{pipe, addr}), NewConn(addrConn{pipe, addr})}}
+	serv.JoinRoom("batcave", batman)
+
+	if out := serv.whoisAddrs("batman", false); out != "[batman] is connected from "+a+"\r\n" {
+		t.Errorf("expected the cloak once, got [%s]", out)
+	}
+	if out := serv.whoisAddrs("batman", true); !strings.Contains(out, "192.0.2.77:40000") {
+		t.Errorf("expected admins to see the address, got [%s]", out)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultProbationRateLimit is how many lines per second a client on
+// probation may send unless TCProbationRateLimit is set
+const DefaultProbationRateLimit = 1
+
+// parseProbation reads TCProbation and TCProbationRateLimit, an empty
+// TCProbation puts no one on probation
+func parseProbation() (time.Duration, int, error) {
+	var period time.Duration
+	if v := os.Getenv("TCProbation"); len(v) > 0 && v != "off" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("error parsing TCProbation: [%s] is not a duration", v)
+		}
+		period = d
+	}
+	rate := DefaultProbationRateLimit
+	if v := os.Getenv("TCProbationRateLimit"); len(v) > 0 {
+		var err error
+		if rate, err = strconv.Atoi(v); err != nil {
+			return 0, 0, fmt.Errorf("error parsing TCProbationRateLimit: %v", err)
+		}
+	}
+	return period, rate, nil
+}
+
+// probationRules returns how long new connections are on probation and
+// how many lines per second they may send meanwhile
+func (s *Server) probationRules() (time.Duration, int) {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	return s.ProbationPeriod, s.ProbationRate
+}
+
+// connectedFor returns how long the client's oldest connection has been
+// connected, false for a client without connections
+func (cl *Client) connectedFor() (time.Duration, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var oldest time.Time
+	for _, c := range cl.Conns {
+		if oldest.IsZero() || c.Connected.Before(oldest) {
+			oldest = c.Connected
+		}
+	}
+	if oldest.IsZero() {
+		return 0, false
+	}
+	return time.Since(oldest), true
+}
+
+// onProbation returns true if the client is on probation, and for how
+// much longer, guests are on probation until they register and everyone
+// else until they have been connected for TCProbation, moderators never
+// are
+func (s *Server) onProbation(cl *Client) (time.Duration, bool) {
+	period, _ := s.probationRules()
+	if period <= 0 || s.hasRole(cl, RoleModerator) {
+		return 0, false
+	}
+	if cl.Account() == "" {
+		return 0, true
+	}
+	if age, ok := cl.connectedFor(); ok && age < period {
+		return period - age, true
+	}
+	return 0, false
+}
+
+// probation returns why the client may not do what yet, nil when it isn't
+// on probation
+func (s *Server) probation(cl *Client, what string) error {
+	wait, on := s.onProbation(cl)
+	switch {
+	case !on:
+		return nil
+	case cl.Account() == "":
+		return fmt.Errorf("guests can't %s, /register first\r\n", what)
+	}
+	return fmt.Errorf("new connections can't %s for another %s\r\n", what, wait.Round(time.Second))
+}
+
+// limiter returns the rate limiter input on the connection counts
+// against, the lower probation limit while the client is on probation
+func (s *Server) limiter(in *Input) *rateLimiter {
+	if _, on := s.onProbation(in.Client); on && in.Conn.probation != nil {
+		return in.Conn.probation
+	}
+	return in.Conn.limit
+}
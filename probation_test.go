@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbation(t *testing.T) {
+	serv := NewServer()
+	serv.Moderators = map[string]bool{"gordon": true}
+	serv.ProbationPeriod = 10 * time.Minute
+	serv.ProbationRate = 1
+	serv.BlastPolicy = BlastLimited
+	serv.JoinRoom("gotham", &Client{nick: "batman", account: "batman"})
+
+	conn := func(age time.Duration) []*Conn {
+		c := NewConn(nil)
+		c.Connected = time.Now().Add(-age)
+		c.limit, c.probation = newRateLimiter(5), newRateLimiter(1)
+		return []*Conn{c}
+	}
+	joker := &Client{nick: "joker", Conns: conn(time.Hour)}
+	robin := &Client{nick: "robin", account: "robin", Conns: conn(time.Minute)}
+	alfred := &Client{nick: "alfred", account: "alfred", Conns: conn(time.Hour)}
+	gordon := &Client{nick: "gordon", account: "gordon", Conns: conn(0)}
+
+	if err := serv.JoinRoom(DefaultRoom, joker); err != nil {
+		t.Errorf("expected guests to join the default room, got %v", err)
+	}
+	if err := serv.JoinRoom("gotham", joker); err != nil {
+		t.Errorf("expected guests to join rooms that exist, got %v", err)
+	}
+	if err := serv.JoinRoom("arkham", joker); err == nil || !strings.Contains(err.Error(), "/register first") {
+		t.Errorf("expected guests kept from creating rooms, got %v", err)
+	}
+	if err := serv.JoinRoom("arkham", robin); err == nil || !strings.Contains(err.Error(), "for another 9m") {
+		t.Errorf("expected new connections kept from creating rooms, got %v", err)
+	}
+	if err := serv.Blast([]string{"/blast", "holy", "blasts"}, robin); err == nil {
+		t.Errorf("expected new connections kept from blasting")
+	}
+	if err := serv.JoinRoom("arkham", alfred); err != nil {
+		t.Errorf("expected older connections to create rooms, got %v", err)
+	}
+	if err := serv.JoinRoom("blackgate", gordon); err != nil {
+		t.Errorf("expected moderators never on probation, got %v", err)
+	}
+
+	if l := serv.limiter(&Input{Client: joker, Conn: joker.Conns[0]}); l != joker.Conns[0].probation {
+		t.Errorf("expected the probation limit while on probation")
+	}
+	if l := serv.limiter(&Input{Client: alfred, Conn: alfred.Conns[0]}); l != alfred.Conns[0].limit {
+		t.Errorf("expected the usual limit after probation")
+	}
+	sent := 0
+	for i := 0; i < 2; i++ {
+		serv.Inbound.Run(&Input{Client: joker, Conn: joker.Conns[0], Args: []string{"hello"}}, func(*Input) { sent++ })
+	}
+	if sent != 1 {
+		t.Errorf("expected guests held to the probation rate, %d of 2 lines went through", sent)
+	}
+
+	serv.ProbationPeriod = 0
+	if err := serv.JoinRoom("ace", joker); err != nil {
+		t.Errorf("expected no probation when it is off, got %v", err)
+	}
+}
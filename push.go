@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// push providers
+const (
+	PushNtfy   = "ntfy"
+	PushGotify = "gotify"
+)
+
+// pushQueue is how many notifications may wait for delivery
+const pushQueue = 256
+
+// PushTarget is where an account wants its push notifications sent
+type PushTarget struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Token    string `json:"token,omitempty"`
+}
+
+type push struct {
+	target PushTarget
+	title  string
+	body   string
+}
+
+// Pusher delivers push notifications to ntfy topics and Gotify servers
+type Pusher struct {
+	client *http.Client
+	queue  chan push
+}
+
+// NewPusher returns a pusher with its delivery goroutine running, it only
+// connects to addresses allow accepts, the URLs are given by users
+func NewPusher(allow func(net.IP) bool) *Pusher {
+	dialer := allowDialer(10*time.Second, allow)
+	p := &Pusher{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		queue: make(chan push, pushQueue),
+	}
+	go p.run()
+	return p
+}
+
+// Notify queues a notification, it never blocks the caller
+func (p *Pusher) Notify(t PushTarget, title, body string) {
+	if p == nil {
+		return
+	}
+
+	select {
+	case p.queue <- push{target: t, title: title, body: body}:
+	default:
+		errl(fmt.Errorf("push queue is full, dropping notification for %s", t.URL), "")
+	}
+}
+
+func (p *Pusher) run() {
+	for n := range p.queue {
+		if err := p.send(n); err != nil {
+			errl(err, "")
+		}
+	}
+}
+
+// send delivers a single notification
+func (p *Pusher) send(n push) error {
+	var req *http.Request
+	var err error
+
+	switch n.target.Provider {
+	case PushNtfy:
+		req, err = http.NewRequest("POST", n.target.URL, strings.NewReader(n.body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", n.title)
+		if n.target.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+n.target.Token)
+		}
+	case PushGotify:
+		b, _ := json.Marshal(map[string]interface{}{"title": n.title, "message": n.body, "priority": 5})
+		u := strings.TrimRight(n.target.URL, "/") + "/message"
+		req, err = http.NewRequest("POST", u, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", n.target.Token)
+	default:
+		return fmt.Errorf("unknown push provider [%s]", n.target.Provider)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push to %s returned %s", n.target.URL, resp.Status)
+	}
+	return nil
+}
+
+// parsePushTarget parses the arguments of /push
+func parsePushTarget(args []string) (*PushTarget, error) {
+	e := fmt.Errorf("use /push ntfy <topic-url> [token], /push gotify <server-url> <app-token>, or /push off\r\n")
+	if len(args) < 2 {
+		return nil, e
+	}
+
+	t := &PushTarget{Provider: args[0], URL: args[1]}
+	if len(args) >= 3 {
+		t.Token = args[2]
+	}
+
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return nil, e
+	}
+
+	switch t.Provider {
+	case PushNtfy:
+	case PushGotify:
+		if t.Token == "" {
+			return nil, e
+		}
+	default:
+		return nil, e
+	}
+	return t, nil
+}
+
+// SetPush configures, or clears with a nil target, the push notifications
+// of the client's account
+func (s *Server) SetPush(cl *Client, t *PushTarget) error {
+	name := cl.Account()
+	if name == "" {
+		return fmt.Errorf("you must be logged in to receive push notifications\r\n")
+	}
+	return s.Accounts.Update(name, func(a *Account) {
+		a.Push = t
+	})
+}
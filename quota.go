@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaLimits are how many messages may be sent an hour and a day, 0 is no
+// limit
+type QuotaLimits struct {
+	Hour int
+	Day  int
+}
+
+// usage counts the messages sent this hour and today
+type usage struct {
+	hour      time.Time
+	hourCount int
+	day       time.Time
+	dayCount  int
+}
+
+// roll starts new counts once the hour or day is over
+func (u *usage) roll(now time.Time) {
+	if h := now.Truncate(time.Hour); !h.Equal(u.hour) {
+		u.hour, u.hourCount = h, 0
+	}
+	if d := now.Truncate(24 * time.Hour); !d.Equal(u.day) {
+		u.day, u.dayCount = d, 0
+	}
+}
+
+// Quotas counts the messages each account, and each guest address, sends
+// and stops them at their limits, days start at midnight UTC
+type Quotas struct {
+	mu     sync.Mutex
+	Users  QuotaLimits
+	Guests QuotaLimits
+	counts map[string]*usage
+}
+
+// NewQuotas returns quotas without limits
+func NewQuotas() *Quotas {
+	return &Quotas{counts: make(map[string]*usage)}
+}
+
+// parseQuotas reads the quota limits from the environment
+func parseQuotas() (users, guests QuotaLimits, err error) {
+	for _, q := range []struct {
+		env string
+		val *int
+	}{
+		{"TCQuotaHour", &users.Hour}, {"TCQuotaDay", &users.Day},
+		{"TCGuestQuotaHour", &guests.Hour}, {"TCGuestQuotaDay", &guests.Day},
+	} {
+		if v := os.Getenv(q.env); len(v) > 0 {
+			if *q.val, err = strconv.Atoi(v); err != nil {
+				return users, guests, fmt.Errorf("error parsing %s: %v", q.env, err)
+			}
+		}
+	}
+	return users, guests, nil
+}
+
+// Configure changes the limits, the counts so far are kept
+func (q *Quotas) Configure(users, guests QuotaLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Users, q.Guests = users, guests
+}
+
+// Enabled returns true if there is any limit
+func (q *Quotas) Enabled() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.Users.Hour > 0 || q.Users.Day > 0 || q.Guests.Hour > 0 || q.Guests.Day > 0
+}
+
+// limits returns the limits for an account or a guest
+func (q *Quotas) limits(guest bool) QuotaLimits {
+	if guest {
+		return q.Guests
+	}
+	return q.Users
+}
+
+// Take counts a message against key at now, it returns an error telling
+// the sender when the quota resets if the message is over it
+func (q *Quotas) Take(key string, guest bool, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l := q.limits(guest)
+	if l.Hour <= 0 && l.Day <= 0 {
+		return nil
+	}
+	u, ok := q.counts[key]
+	if !ok {
+		u = &usage{}
+		q.counts[key] = u
+	}
+	u.roll(now)
+
+	if l.Day > 0 && u.dayCount >= l.Day {
+		return fmt.Errorf("You reached your quota of %d message(s) a day, it resets in %s\r\n", l.Day, u.day.Add(24*time.Hour).Sub(now).Truncate(time.Minute))
+	}
+	if l.Hour > 0 && u.hourCount >= l.Hour {
+		return fmt.Errorf("You reached your quota of %d message(s) an hour, it resets in %s\r\n", l.Hour, u.hour.Add(time.Hour).Sub(now).Truncate(time.Second))
+	}
+	u.hourCount++
+	u.dayCount++
+	return nil
+}
+
+// Usage describes how much of its quota key has used at now
+func (q *Quotas) Usage(key string, guest bool, now time.Time) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l := q.limits(guest)
+	if l.Hour <= 0 && l.Day <= 0 {
+		return "You have no message quota\r\n"
+	}
+	u := &usage{}
+	if c, ok := q.counts[key]; ok {
+		u = c
+	}
+	u.roll(now)
+
+	of := func(n, limit int) string {
+		if limit <= 0 {
+			return fmt.Sprintf("%d", n)
+		}
+		return fmt.Sprintf("%d of %d", n, limit)
+	}
+	return fmt.Sprintf("You sent %s message(s) this hour and %s today\r\n", of(u.hourCount, l.Hour), of(u.dayCount, l.Day))
+}
+
+// Prune forgets the counts from before today
+func (q *Quotas) Prune(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	today := now.Truncate(24 * time.Hour)
+	for key, u := range q.counts {
+		if u.day.Before(today) {
+			delete(q.counts, key)
+		}
+	}
+}
+
+// Run prunes the counts every hour
+func (q *Quotas) Run() {
+	for now := range time.Tick(time.Hour) {
+		q.Prune(now)
+	}
+}
+
+// quotaKey returns who a client's messages count against, its account, or
+// for guests the address it connects from, and whether it's a guest
+func quotaKey(in *Input) (string, bool) {
+	if a := in.Client.Account(); a != "" {
+		return "account:" + a, false
+	}
+	if in.Conn != nil {
+		if ip := addrIP(in.Conn.RemoteAddr()); ip != nil {
+			return "addr:" + ip.String(), true
+		}
+	}
+	return "nick:" + in.Client.Nick(), true
+}
+
+// metered is the Metered of the commands that always send a message
+func metered(*Input) bool { return true }
+
+// meters returns true if the input counts against the quota, messages and
+// the commands that send one do, a command that isn't registered may end
+// up a message so it counts too
+func (s *Server) meters(in *Input) bool {
+	if in.Command == "" {
+		return true
+	}
+	c, ok := s.Commands.Lookup(in.Command)
+	return !ok || (c.Metered != nil && c.Metered(in))
+}
+
+// quotaStage drops messages over the sender's quota
+func (s *Server) quotaStage(next Handler) Handler {
+	return func(in *Input) {
+		if !s.meters(in) || !s.Quotas.Enabled() || s.exemptQuota(in.Client) {
+			next(in)
+			return
+		}
+		key, guest := quotaKey(in)
+		if err := s.Quotas.Take(key, guest, time.Now()); err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+		next(in)
+	}
+}
+
+// takeQuota counts a message from cl against its quota, for messages sent
+// outside the pipeline, it returns the error telling cl when the quota
+// resets if it's over
+func (s *Server) takeQuota(cl *Client) error {
+	if !s.Quotas.Enabled() || s.exemptQuota(cl) {
+		return nil
+	}
+	in := &Input{Client: cl}
+	cl.mu.Lock()
+	if len(cl.Conns) > 0 {
+		in.Conn = cl.Conns[0]
+	}
+	cl.mu.Unlock()
+	key, guest := quotaKey(in)
+	return s.Quotas.Take(key, guest, time.Now())
+}
+
+// exemptQuota returns true for moderators and bots
+func (s *Server) exemptQuota(cl *Client) bool {
+	return cl.Bot() || s.hasRole(cl, RoleModerator)
+}
+
+// quotaCommand runs /quota
+func (s *Server) quotaCommand(in *Input) {
+	if s.exemptQuota(in.Client) || !s.Quotas.Enabled() {
+		in.Client.Write("You have no message quota\r\n")
+		return
+	}
+	key, guest := quotaKey(in)
+	in.Client.Write(s.Quotas.Usage(key, guest, time.Now()))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotas(t *testing.T) {
+	q := NewQuotas()
+	now := time.Date(2026, 1, 2, 10, 59, 0, 0, time.UTC)
+	if err := q.Take("addr:10.0.0.1", true, now); err != nil {
+		t.Errorf("expected no limits by default, got %v", err)
+	}
+
+	q.Configure(QuotaLimits{Hour: 3, Day: 4}, QuotaLimits{Hour: 1})
+	for i := 0; i < 3; i++ {
+		if err := q.Take("account:batman", false, now); err != nil {
+			t.Fatalf("expected message %d to be within the quota, got %v", i, err)
+		}
+	}
+	err := q.Take("account:batman", false, now)
+	if err == nil || err.Error() != "You reached your quota of 3 message(s) an hour, it resets in 1m0s\r\n" {
+		t.Errorf("expected the hourly quota, got %v", err)
+	}
+
+	// a new hour, but the day's quota runs out
+	now = now.Add(2 * time.Minute)
+	if err := q.Take("account:batman", false, now); err != nil {
+		t.Errorf("expected a new hour to reset the hourly count, got %v", err)
+	}
+	if err := q.Take("account:batman", false, now); err == nil || !strings.Contains(err.Error(), "4 message(s) a day, it resets in 12h59m0s") {
+		t.Errorf("expected the daily quota, got %v", err)
+	}
+	if u := q.Usage("account:batman", false, now); u != "You sent 1 of 3 message(s) this hour and 4 of 4 today\r\n" {
+		t.Errorf("unexpected usage %q", u)
+	}
+
+	if err := q.Take("addr:10.0.0.1", true, now); err != nil {
+		t.Errorf("expected guests to have their own count, got %v", err)
+	}
+	if err := q.Take("addr:10.0.0.1", true, now); err == nil {
+		t.Errorf("expected the guest quota")
+	}
+
+	q.Prune(now.Add(24 * time.Hour))
+	if len(q.counts) != 0 {
+		t.Errorf("expected yesterday's counts to be forgotten, got %d", len(q.counts))
+	}
+}
+
+func TestQuotaStage(t *testing.T) {
+	serv := NewServer()
+	serv.Moderators = map[string]bool{"gordon": true}
+	serv.Quotas.Configure(QuotaLimits{}, QuotaLimits{Hour: 1})
+
+	joker := &Client{nick: "joker"}
+	gordon := &Client{nick: "gordon", account: "gordon"}
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("gotham", gordon)
+
+	send := func(cl *Client, args ...string) {
+		in := &Input{Client: cl, Args: args}
+		if strings.HasPrefix(args[0], "/") {
+			in.Command = args[0]
+		}
+		serv.Inbound.Run(in, serv.Dispatch)
+	}
+	send(joker, "hahaha")
+	send(joker, "/who")
+	send(joker, "/msg", "gordon", "hahaha")
+	send(gordon, "one")
+	send(gordon, "two")
+
+	var texts []string
+	for _, ev := range joker.unread {
+		texts = append(texts, ev.Text)
+	}
+	if len(texts) != 5 || !strings.HasPrefix(texts[2], "You reached your quota of 1 message(s) an hour") {
+		t.Errorf("expected /who to pass and /msg to be over the quota, got %q", texts)
+	}
+	if len(gordon.unread) != 3 {
+		t.Errorf("expected moderators to have no quota, got %d events", len(gordon.unread))
+	}
+}
+
+func TestMetered(t *testing.T) {
+	serv := NewServer()
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"hahaha"}, true},
+		{[]string{"/roll", "2d6"}, true},
+		{[]string{"/react", "42", "👍"}, true},
+		{[]string{"/notice", "build passed"}, true},
+		{[]string{"/poll", "\"why?\"", "a", "b"}, true},
+		{[]string{"/poll"}, false},
+		{[]string{"/remind", "room", "1h", "patrol"}, true},
+		{[]string{"/remind", "1h", "patrol"}, false},
+		{[]string{"/who"}, false},
+		{[]string{"/notacommand", "hahaha"}, true},
+	}
+	for _, tt := range tests {
+		in := &Input{Args: tt.args}
+		if strings.HasPrefix(tt.args[0], "/") {
+			in.Command = tt.args[0]
+		}
+		if got := serv.meters(in); got != tt.want {
+			t.Errorf("expected %v metered to be %v, got %v", tt.args, tt.want, got)
+		}
+	}
+}
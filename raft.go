@@ -0,0 +1,1105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const raftName = "raft.json"
+
+// raft roles
+const (
+	raftFollower = iota
+	raftCandidate
+	raftLeader
+)
+
+// raft commands applied to the replicated state
+const (
+	raftRoom    = "room"
+	raftBan     = "ban"
+	raftUnban   = "unban"
+	raftOp      = "op"
+	raftDeop    = "deop"
+	raftAccount = "account"
+)
+
+// raftHeartbeat is how often the leader replicates to its followers, a
+// follower that hears nothing for raftElection to twice that calls an
+// election
+const (
+	raftHeartbeat = 100 * time.Millisecond
+	raftElection  = 500 * time.Millisecond
+	raftTick      = 20 * time.Millisecond
+)
+
+// raftCommitWait is how long a change waits for a majority of nodes to
+// store it
+const raftCommitWait = 2 * raftElection
+
+// maxRaftBody is the largest RPC a node reads
+const maxRaftBody = 32 << 20
+
+// raftCompact is how many applied entries the log keeps before they are
+// replaced by a snapshot of the state they built
+const raftCompact = 1000
+
+// raftCommand is a change to the rooms, bans, or accounts
+type raftCommand struct {
+	Op       string        `json:"op"`
+	Room     string        `json:"room,omitempty"`
+	Display  string        `json:"display,omitempty"`
+	Owner    string        `json:"owner,omitempty"`
+	Topic    string        `json:"topic,omitempty"`
+	Public   bool          `json:"public,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Notices  bool          `json:"notices,omitempty"`
+	MOTD     string        `json:"motd,omitempty"`
+	Spam     string        `json:"spam,omitempty"`
+	SpamDrop bool          `json:"spam_drop,omitempty"`
+	Created  string        `json:"created,omitempty"`
+	Peak     int           `json:"peak,omitempty"`
+	Messages int64         `json:"messages,omitempty"`
+	Nick     string        `json:"nick,omitempty"`
+	Account  *Account      `json:"account,omitempty"`
+
+	LineLimit int `json:"line_limit,omitempty"`
+
+	PublicSince int64 `json:"public_since,omitempty"`
+
+	// Fields are the settings a room command changes, all of them when
+	// empty
+	Fields []string `json:"fields,omitempty"`
+}
+
+// sets returns true if the room command changes the setting field
+func (cmd raftCommand) sets(field string) bool {
+	if len(cmd.Fields) == 0 {
+		return true
+	}
+	for _, f := range cmd.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+type raftEntry struct {
+	Term int64       `json:"term"`
+	Cmd  raftCommand `json:"cmd"`
+}
+
+// raftLine is an entry as it is appended to the log file
+type raftLine struct {
+	Index int64 `json:"index"`
+	raftEntry
+}
+
+type voteRequest struct {
+	Term      int64  `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex int64  `json:"last_index"`
+	LastTerm  int64  `json:"last_term"`
+}
+
+type voteResponse struct {
+	Term    int64 `json:"term"`
+	Granted bool  `json:"granted"`
+}
+
+type appendRequest struct {
+	Term      int64       `json:"term"`
+	Leader    string      `json:"leader"`
+	PrevIndex int64       `json:"prev_index"`
+	PrevTerm  int64       `json:"prev_term"`
+	Entries   []raftEntry `json:"entries"`
+	Commit    int64       `json:"commit"`
+}
+
+type appendResponse struct {
+	Term    int64 `json:"term"`
+	Success bool  `json:"success"`
+	Match   int64 `json:"match"`
+}
+
+// snapshotRequest hands a follower that is missing compacted entries the
+// state they built
+type snapshotRequest struct {
+	Term     int64         `json:"term"`
+	Leader   string        `json:"leader"`
+	Index    int64         `json:"index"`
+	LastTerm int64         `json:"last_term"`
+	State    []raftCommand `json:"state"`
+}
+
+// raftState is what a node persists between restarts besides its log,
+// which is appended to a file of its own, Log is only read from the files
+// of older versions
+type raftState struct {
+	Term      int64         `json:"term"`
+	VotedFor  string        `json:"voted_for"`
+	SnapIndex int64         `json:"snap_index,omitempty"`
+	SnapTerm  int64         `json:"snap_term,omitempty"`
+	Snapshot  []raftCommand `json:"snapshot,omitempty"`
+	Log       []raftEntry   `json:"log,omitempty"`
+}
+
+// raftApply is a committed entry for the applier, or the snapshot of
+// entries a follower only got as state
+type raftApply struct {
+	index int64
+	cmd   raftCommand
+	state []raftCommand
+}
+
+// Raft replicates the rooms, bans, and accounts of the leader to standby
+// nodes, only the leader accepts chat connections and a standby takes over
+// with the same state when a majority of nodes elect it
+type Raft struct {
+	Server *Server
+	ID     string
+	Peers  map[string]string
+
+	path   string
+	client *http.Client
+	key    *clusterKey
+
+	mu       sync.Mutex
+	role     int
+	term     int64
+	votedFor string
+	log      []raftEntry
+	commit   int64
+	applied  int64
+	leader   string
+	next     map[string]int64
+	match    map[string]int64
+	inflight map[string]bool
+	deadline time.Time
+	beat     time.Time
+	pending  []raftApply
+	notify   chan struct{}
+
+	// waits are the changes proposed on this node waiting to be applied
+	// by log index, told true when they are and false if they never will
+	waits map[int64]chan bool
+
+	// the log starts after the entries compacted into snapshot, log[0]
+	// stands for the last of them, queued is the last entry handed to the
+	// applier and applied the last one it applied, compactAt entries past
+	// the snapshot make a new one
+	snapIndex int64
+	snapTerm  int64
+	snapshot  []raftCommand
+	queued    int64
+	compactAt int64
+}
+
+// NewRaft returns the node id of a cluster with peers, a map of node id to
+// base URL, its state is persisted to path unless it is empty, and its RPCs
+// are sealed with key
+func NewRaft(s *Server, id string, peers map[string]string, path string, key *clusterKey) *Raft {
+	r := &Raft{
+		Server:   s,
+		ID:       id,
+		Peers:    peers,
+		path:     path,
+		client:   &http.Client{Timeout: raftElection / 2},
+		key:      key,
+		log:      []raftEntry{{}},
+		next:     make(map[string]int64),
+		match:    make(map[string]int64),
+		inflight: make(map[string]bool),
+		notify:   make(chan struct{}, 1),
+		waits:    make(map[int64]chan bool),
+	}
+	r.compactAt = raftCompact
+	r.resetDeadline()
+	return r
+}
+
+// parsePeers parses "id=url,id=url" into a map of node id to base URL,
+// leaving out self
+func parsePeers(s, self string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, p := range splitList(s) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid raft peer [%s], use id=url", p)
+		}
+		if kv[0] != self {
+			peers[kv[0]] = strings.TrimRight(kv[1], "/")
+		}
+	}
+	return peers, nil
+}
+
+// Load reads the persisted term, vote, snapshot, and log and restores the
+// state of the snapshot, the entries after it are applied again as they
+// are committed, missing files are not an error
+func (r *Raft) Load() error {
+	r.mu.Lock()
+	state, err := r.load()
+	r.mu.Unlock()
+	if err != nil || state == nil {
+		return err
+	}
+	r.Server.restore(state)
+	return nil
+}
+
+// load reads the files of the node, it must be called with the lock held
+func (r *Raft) load() ([]raftCommand, error) {
+	if r.path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b, err = openData(b); err != nil {
+		return nil, err
+	}
+
+	var st raftState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	r.term, r.votedFor = st.Term, st.VotedFor
+	r.snapIndex, r.snapTerm, r.snapshot = st.SnapIndex, st.SnapTerm, st.Snapshot
+	r.commit, r.queued, r.applied = r.snapIndex, r.snapIndex, r.snapIndex
+	r.log = []raftEntry{{Term: r.snapTerm}}
+
+	// a file of an older version keeps the log itself
+	if len(st.Log) > 1 {
+		r.log = st.Log
+		r.save()
+		return r.snapshot, r.saveLog()
+	}
+
+	f, err := os.Open(r.path + ".log")
+	if os.IsNotExist(err) {
+		return r.snapshot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), maxRaftBody)
+	for sc.Scan() {
+		line, err := openData(sc.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		var l raftLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, err
+		}
+		switch {
+		case l.Index <= r.snapIndex:
+		case l.Index <= r.lastIndex()+1:
+			r.log = append(r.log[:l.Index-r.snapIndex], l.raftEntry)
+		default:
+			return nil, fmt.Errorf("raft log is missing entries before %d", l.Index)
+		}
+	}
+	return r.snapshot, sc.Err()
+}
+
+// save persists the term, vote, and snapshot, it must be called with the
+// lock held
+func (r *Raft) save() {
+	if r.path == "" {
+		return
+	}
+
+	b, err := json.Marshal(raftState{Term: r.term, VotedFor: r.votedFor, SnapIndex: r.snapIndex, SnapTerm: r.snapTerm, Snapshot: r.snapshot})
+	if err == nil {
+		b, err = sealData(b)
+	}
+	if err != nil {
+		errl(err, "")
+		return
+	}
+
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		errl(err, "")
+		return
+	}
+	errl(os.Rename(tmp, r.path), "")
+}
+
+// logLines seals the entries of the log from index on, one per line
+func (r *Raft) logLines(from int64) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := from; i <= r.lastIndex(); i++ {
+		b, err := json.Marshal(raftLine{Index: i, raftEntry: r.entry(i)})
+		if err == nil {
+			b, err = sealData(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// appendLog appends the entries from index on to the log file, it must be
+// called with the lock held
+func (r *Raft) appendLog(from int64) {
+	if r.path == "" {
+		return
+	}
+
+	b, err := r.logLines(from)
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	f, err := os.OpenFile(r.path+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	errl(err, "")
+}
+
+// saveLog rewrites the log file, for when entries are dropped from the
+// log, it must be called with the lock held
+func (r *Raft) saveLog() error {
+	if r.path == "" {
+		return nil
+	}
+
+	b, err := r.logLines(r.snapIndex + 1)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".log.tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path+".log")
+}
+
+// Start runs the election timer, heartbeats, and the applier
+func (r *Raft) Start() {
+	go r.run()
+	go r.apply()
+}
+
+// Leader returns true if this node is the leader
+func (r *Raft) Leader() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == raftLeader
+}
+
+// Propose appends a command to the replicated log and waits for a majority
+// of nodes to store it and for this node to apply it, only the leader may
+// change the state, a change that is committed after Propose gave up is
+// applied all the same, the applier needs the server lock so it must not
+// be held
+func (r *Raft) Propose(cmd raftCommand) error {
+	if r == nil {
+		return nil
+	}
+	idx, done, err := r.append(cmd)
+	if err != nil {
+		return err
+	}
+	return r.wait(idx, done)
+}
+
+// Submit appends a command to the replicated log without waiting for it,
+// for callers holding the server lock, it is applied once committed and
+// told true to done, or false if it won't be
+func (r *Raft) Submit(cmd raftCommand, done func(bool)) {
+	idx, ch, err := r.append(cmd)
+	if err != nil {
+		errl(err, "")
+		done(false)
+		return
+	}
+	go func() {
+		done(r.wait(idx, ch) == nil)
+	}()
+}
+
+// append appends cmd to the log of the leader and starts replicating it
+func (r *Raft) append(cmd raftCommand) (int64, chan bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != raftLeader {
+		return 0, nil, fmt.Errorf("this node is a standby, the leader is [%s]\r\n", r.leader)
+	}
+
+	r.log = append(r.log, raftEntry{Term: r.term, Cmd: cmd})
+	idx := r.lastIndex()
+	done := make(chan bool, 1)
+	r.waits[idx] = done
+	r.appendLog(idx)
+	r.broadcast()
+	r.advanceCommit()
+	return idx, done, nil
+}
+
+// wait waits for the entry at idx to be applied
+func (r *Raft) wait(idx int64, done chan bool) error {
+	applied := false
+	select {
+	case applied = <-done:
+	case <-time.After(raftCommitWait):
+		r.mu.Lock()
+		delete(r.waits, idx)
+		r.mu.Unlock()
+		// it may have been applied as the wait ran out
+		select {
+		case applied = <-done:
+		default:
+		}
+	}
+	if !applied {
+		return errors.New("the change was not stored by a majority of nodes, try again\r\n")
+	}
+	return nil
+}
+
+func (r *Raft) resetDeadline() {
+	r.deadline = time.Now().Add(raftElection + time.Duration(rand.Int63n(int64(raftElection))))
+}
+
+func (r *Raft) lastIndex() int64 {
+	return r.snapIndex + int64(len(r.log)-1)
+}
+
+// entry returns the entry at index, which must not be compacted
+func (r *Raft) entry(index int64) raftEntry {
+	return r.log[index-r.snapIndex]
+}
+
+func (r *Raft) run() {
+	for {
+		time.Sleep(raftTick)
+
+		r.mu.Lock()
+		if r.role == raftLeader {
+			if time.Since(r.beat) >= raftHeartbeat {
+				r.broadcast()
+			}
+		} else if time.Now().After(r.deadline) {
+			r.campaign()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// stepDown returns to following, adopting term if it is newer
+func (r *Raft) stepDown(term int64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.save()
+	}
+	if r.role == raftLeader {
+		errl(nil, fmt.Sprintf("Raft node [%s] is no longer the leader", r.ID))
+	}
+	r.role = raftFollower
+	// the changes still waiting may never be committed, whoever proposed
+	// them doesn't apply them, those that are committed after all are
+	// applied like any other
+	for idx, done := range r.waits {
+		done <- false
+		delete(r.waits, idx)
+	}
+}
+
+// campaign starts an election for the next term
+func (r *Raft) campaign() {
+	r.role = raftCandidate
+	r.term++
+	r.votedFor = r.ID
+	r.leader = ""
+	r.save()
+	r.resetDeadline()
+
+	term := r.term
+	req := voteRequest{Term: term, Candidate: r.ID, LastIndex: r.lastIndex(), LastTerm: r.entry(r.lastIndex()).Term}
+	votes := 1
+	if votes > len(r.Peers)/2 {
+		r.lead()
+		return
+	}
+
+	for id, url := range r.Peers {
+		go func(id, url string) {
+			var resp voteResponse
+			if err := r.call(url+"/raft/vote", req, &resp); err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if resp.Term > r.term {
+				r.stepDown(resp.Term)
+				return
+			}
+			if r.role != raftCandidate || r.term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes > len(r.Peers)/2 {
+				r.lead()
+			}
+		}(id, url)
+	}
+}
+
+// lead makes the node the leader of the current term
+func (r *Raft) lead() {
+	r.role = raftLeader
+	r.leader = r.ID
+	for id := range r.Peers {
+		r.next[id] = r.lastIndex() + 1
+		r.match[id] = 0
+	}
+	errl(nil, fmt.Sprintf("Raft node [%s] is the leader for term %d", r.ID, r.term))
+	r.broadcast()
+	r.advanceCommit()
+}
+
+// broadcast replicates the log to every follower, it must be called with
+// the lock held
+func (r *Raft) broadcast() {
+	r.beat = time.Now()
+	for id := range r.Peers {
+		if !r.inflight[id] {
+			r.replicate(id)
+		}
+	}
+}
+
+// replicate sends the entries a follower is missing, or the snapshot when
+// they were compacted
+func (r *Raft) replicate(id string) {
+	prev := r.next[id] - 1
+	if prev < r.snapIndex {
+		r.sendSnapshot(id)
+		return
+	}
+	req := appendRequest{
+		Term:      r.term,
+		Leader:    r.ID,
+		PrevIndex: prev,
+		PrevTerm:  r.entry(prev).Term,
+		Entries:   append([]raftEntry{}, r.log[prev+1-r.snapIndex:]...),
+		Commit:    r.commit,
+	}
+	r.inflight[id] = true
+
+	go func() {
+		var resp appendResponse
+		err := r.call(r.Peers[id]+"/raft/append", req, &resp)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.inflight[id] = false
+		if err != nil {
+			return
+		}
+		if resp.Term > r.term {
+			r.stepDown(resp.Term)
+			return
+		}
+		if r.role != raftLeader || r.term != req.Term {
+			return
+		}
+
+		if resp.Success {
+			r.match[id] = resp.Match
+			r.next[id] = resp.Match + 1
+			r.advanceCommit()
+			return
+		}
+
+		// back up to the follower's hint and retry
+		next := r.next[id] - 1
+		if resp.Match+1 < next {
+			next = resp.Match + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		r.next[id] = next
+		r.replicate(id)
+	}()
+}
+
+// sendSnapshot sends a follower the snapshot of the entries compacted
+// before it got them
+func (r *Raft) sendSnapshot(id string) {
+	req := snapshotRequest{Term: r.term, Leader: r.ID, Index: r.snapIndex, LastTerm: r.snapTerm, State: r.snapshot}
+	r.inflight[id] = true
+
+	go func() {
+		var resp appendResponse
+		err := r.call(r.Peers[id]+"/raft/snapshot", req, &resp)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.inflight[id] = false
+		if err != nil {
+			return
+		}
+		if resp.Term > r.term {
+			r.stepDown(resp.Term)
+			return
+		}
+		if r.role != raftLeader || r.term != req.Term || !resp.Success {
+			return
+		}
+		r.match[id] = resp.Match
+		r.next[id] = resp.Match + 1
+		r.advanceCommit()
+	}()
+}
+
+// advanceCommit commits the entries of the current term stored on a
+// majority of nodes
+func (r *Raft) advanceCommit() {
+	matches := []int64{r.lastIndex()}
+	for id := range r.Peers {
+		matches = append(matches, r.match[id])
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	n := matches[len(matches)/2]
+	if n > r.commit && r.entry(n).Term == r.term {
+		r.commit = n
+		r.queueCommitted()
+	}
+}
+
+// queueCommitted hands committed entries to the applier
+func (r *Raft) queueCommitted() {
+	for r.queued < r.commit {
+		r.queued++
+		r.pending = append(r.pending, raftApply{index: r.queued, cmd: r.entry(r.queued).Cmd})
+	}
+	r.wake()
+}
+
+func (r *Raft) wake() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// apply applies committed commands to the server in log order, telling
+// whoever proposed them, and compacts the log as it grows
+func (r *Raft) apply() {
+	for range r.notify {
+		r.mu.Lock()
+		todo := r.pending
+		r.pending = nil
+		r.mu.Unlock()
+
+		for _, a := range todo {
+			if a.state != nil {
+				r.Server.restore(a.state)
+			} else {
+				r.Server.applyCommand(a.cmd)
+			}
+
+			r.mu.Lock()
+			r.applied = a.index
+			if done, ok := r.waits[a.index]; ok {
+				done <- true
+				delete(r.waits, a.index)
+			}
+			r.mu.Unlock()
+		}
+		r.compact()
+	}
+}
+
+// compact replaces the applied entries with a snapshot of the state they
+// built once there are compactAt of them, so the log file stays small
+// and a restart doesn't apply the whole history again
+func (r *Raft) compact() {
+	r.mu.Lock()
+	index := r.applied
+	due := index-r.snapIndex >= r.compactAt
+	r.mu.Unlock()
+	if !due {
+		return
+	}
+
+	// only the applier changes the state, it is as of index
+	state := r.Server.replicated()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if index <= r.snapIndex {
+		return
+	}
+	r.snapTerm = r.entry(index).Term
+	r.log = append([]raftEntry{{Term: r.snapTerm}}, r.log[index+1-r.snapIndex:]...)
+	r.snapIndex, r.snapshot = index, state
+	r.save()
+	errl(r.saveLog(), "")
+}
+
+// call posts a request to a peer and decodes its response, both sealed
+// with the cluster key so only nodes that know the secret can take part
+// and the accounts replicated can't be read on the way
+func (r *Raft) call(url string, req, resp interface{}) error {
+	rpc := url[strings.LastIndex(url, "/raft/"):]
+	b, err := json.Marshal(req)
+	if err == nil {
+		b, err = r.key.seal(b, rpc)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Post(url, "application/octet-stream", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("raft peer returned %s", res.Status)
+	}
+	if b, err = ioutil.ReadAll(io.LimitReader(res.Body, maxRaftBody)); err != nil {
+		return err
+	}
+	if b, err = r.key.open(b, rpc+" response"); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, resp)
+}
+
+// Handler serves the vote and append RPCs of the other nodes, requests not
+// sealed with the cluster key are refused
+func (r *Raft) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", func(w http.ResponseWriter, req *http.Request) {
+		var vr voteRequest
+		if r.read(w, req, &vr) {
+			r.write(w, req, r.handleVote(vr))
+		}
+	})
+	mux.HandleFunc("/raft/append", func(w http.ResponseWriter, req *http.Request) {
+		var ar appendRequest
+		if r.read(w, req, &ar) {
+			r.write(w, req, r.handleAppend(ar))
+		}
+	})
+	mux.HandleFunc("/raft/snapshot", func(w http.ResponseWriter, req *http.Request) {
+		var sr snapshotRequest
+		if r.read(w, req, &sr) {
+			r.write(w, req, r.handleSnapshot(sr))
+		}
+	})
+	return mux
+}
+
+// read opens and decodes a sealed RPC, it returns false once it has
+// answered with an error
+func (r *Raft) read(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRaftBody))
+	if err == nil {
+		b, err = r.key.open(b, req.URL.Path)
+	}
+	if err != nil {
+		errl(fmt.Errorf("raft rpc from %s refused: %v", req.RemoteAddr, err), "")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// write seals and sends the response to an RPC
+func (r *Raft) write(w http.ResponseWriter, req *http.Request, v interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		b, err = r.key.seal(b, req.URL.Path+" response")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
+
+func (r *Raft) handleVote(req voteRequest) voteResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Term > r.term {
+		r.stepDown(req.Term)
+	}
+
+	last := r.lastIndex()
+	upToDate := req.LastTerm > r.entry(last).Term || (req.LastTerm == r.entry(last).Term && req.LastIndex >= last)
+	granted := req.Term == r.term && (r.votedFor == "" || r.votedFor == req.Candidate) && upToDate
+	if granted {
+		r.votedFor = req.Candidate
+		r.save()
+		r.resetDeadline()
+	}
+	return voteResponse{Term: r.term, Granted: granted}
+}
+
+func (r *Raft) handleAppend(req appendRequest) appendResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Term < r.term {
+		return appendResponse{Term: r.term}
+	}
+	if req.Term > r.term || r.role != raftFollower {
+		r.stepDown(req.Term)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+
+	if req.PrevIndex > r.lastIndex() {
+		return appendResponse{Term: r.term, Match: r.lastIndex()}
+	}
+	prev, entries := req.PrevIndex, req.Entries
+	if prev < r.snapIndex {
+		// the compacted entries are committed, so they match
+		skip := r.snapIndex - prev
+		if skip > int64(len(entries)) {
+			skip = int64(len(entries))
+		}
+		prev, entries = prev+skip, entries[skip:]
+	} else if r.entry(prev).Term != req.PrevTerm {
+		return appendResponse{Term: r.term, Match: req.PrevIndex - 1}
+	}
+
+	from, truncated := int64(0), false
+	for i, e := range entries {
+		idx := prev + 1 + int64(i)
+		if idx <= r.lastIndex() {
+			if r.entry(idx).Term == e.Term {
+				continue
+			}
+			r.log = r.log[:idx-r.snapIndex]
+			truncated = true
+		}
+		r.log = append(r.log, e)
+		if from == 0 {
+			from = idx
+		}
+	}
+	if truncated {
+		errl(r.saveLog(), "")
+	} else if from > 0 {
+		r.appendLog(from)
+	}
+
+	match := req.PrevIndex + int64(len(req.Entries))
+	if req.Commit > r.commit {
+		r.commit = req.Commit
+		if match < r.commit {
+			r.commit = match
+		}
+		r.queueCommitted()
+	}
+	return appendResponse{Term: r.term, Success: true, Match: match}
+}
+
+func (r *Raft) handleSnapshot(req snapshotRequest) appendResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Term < r.term {
+		return appendResponse{Term: r.term}
+	}
+	if req.Term > r.term || r.role != raftFollower {
+		r.stepDown(req.Term)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+
+	if req.Index <= r.snapIndex {
+		return appendResponse{Term: r.term, Success: true, Match: req.Index}
+	}
+	// the entries after the snapshot are kept if they follow on from it
+	if req.Index <= r.lastIndex() && r.entry(req.Index).Term == req.LastTerm {
+		r.log = append([]raftEntry{{Term: req.LastTerm}}, r.log[req.Index+1-r.snapIndex:]...)
+	} else {
+		r.log = []raftEntry{{Term: req.LastTerm}}
+	}
+	r.snapIndex, r.snapTerm, r.snapshot = req.Index, req.LastTerm, req.State
+	r.save()
+	errl(r.saveLog(), "")
+
+	if r.commit < req.Index {
+		r.commit = req.Index
+	}
+	if r.queued < req.Index {
+		r.queued = req.Index
+		r.pending = append(r.pending, raftApply{index: req.Index, state: req.State})
+		r.wake()
+	}
+	return appendResponse{Term: r.term, Success: true, Match: req.Index}
+}
+
+// applyCommand applies a replicated change made on the leader
+func (s *Server) applyCommand(cmd raftCommand) {
+	if cmd.Op == raftAccount {
+		if cmd.Account != nil {
+			errl(s.Accounts.Put(*cmd.Account), "")
+		}
+		return
+	}
+	if cmd.Op == raftForget {
+		s.forget(cmd.Nick)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyRoom(cmd)
+}
+
+// applyRoom applies a change to a room, it must be called with the server
+// lock held
+func (s *Server) applyRoom(cmd raftCommand) {
+	r, ok := s.Rooms[cmd.Room]
+	if !ok {
+		r = s.createRoom(cmd.Room)
+	}
+
+	switch cmd.Op {
+	case raftRoom:
+		if cmd.sets("display") && cmd.Display != "" {
+			r.Display = cmd.Display
+		}
+		if cmd.sets("owner") {
+			r.Owner = cmd.Owner
+		}
+		if cmd.sets("topic") {
+			r.Topic = cmd.Topic
+		}
+		if cmd.sets("public") {
+			r.Public, r.PublicSince = cmd.Public, cmd.PublicSince
+		}
+		if cmd.sets("ttl") {
+			r.TTL = cmd.TTL
+		}
+		if cmd.sets("notices") {
+			r.Notices = cmd.Notices
+		}
+		if cmd.sets("motd") {
+			r.MOTD = cmd.MOTD
+		}
+		if cmd.sets("spam") {
+			r.Spam, r.SpamDrop = cmd.Spam, cmd.SpamDrop
+		}
+		if cmd.sets("line_limit") {
+			r.LineLimit = cmd.LineLimit
+		}
+		r.count(cmd.Created, cmd.Peak, cmd.Messages)
+	case raftBan:
+		r.Bans[cmd.Nick] = true
+	case raftUnban:
+		delete(r.Bans, cmd.Nick)
+	case raftOp:
+		r.Ops[cmd.Nick] = true
+	case raftDeop:
+		delete(r.Ops, cmd.Nick)
+	}
+}
+
+// replicated returns the rooms, bans, ops, and accounts as the commands
+// that build them, for a raft snapshot
+func (s *Server) replicated() []raftCommand {
+	var state []raftCommand
+
+	s.mu.Lock()
+	var names []string
+	for name := range s.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := s.Rooms[name]
+		state = append(state, r.settings())
+		for nick := range r.Bans {
+			state = append(state, raftCommand{Op: raftBan, Room: r.Name, Nick: nick})
+		}
+		for nick := range r.Ops {
+			state = append(state, raftCommand{Op: raftOp, Room: r.Name, Nick: nick})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, a := range s.Accounts.All() {
+		a := a
+		state = append(state, raftCommand{Op: raftAccount, Account: &a})
+	}
+	return state
+}
+
+// restore replaces the bans, ops, and accounts with those of a raft
+// snapshot and applies its room settings
+func (s *Server) restore(state []raftCommand) {
+	var accounts []Account
+
+	s.mu.Lock()
+	for _, r := range s.Rooms {
+		r.Bans = make(map[string]bool)
+		r.Ops = make(map[string]bool)
+	}
+	for _, cmd := range state {
+		if cmd.Op == raftAccount {
+			if cmd.Account != nil {
+				accounts = append(accounts, *cmd.Account)
+			}
+			continue
+		}
+		s.applyRoom(cmd)
+	}
+	s.mu.Unlock()
+
+	errl(s.Accounts.Replace(accounts), "")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRaftReplication(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	servers := make(map[string]*httptest.Server)
+	nodes := make(map[string]*Raft)
+	peers := make(map[string]string)
+
+	for _, id := range ids {
+		srv := httptest.NewUnstartedServer(nil)
+		servers[id] = srv
+		peers[id] = "http://" + srv.Listener.Addr().String()
+	}
+
+	key, err := newClusterKey("s3cret")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for _, id := range ids {
+		p := make(map[string]string)
+		for other, url := range peers {
+			if other != id {
+				p[other] = url
+			}
+		}
+		nodes[id] = NewRaft(NewServer(), id, p, "", key)
+		servers[id].Config.Handler = nodes[id].Handler()
+		servers[id].Start()
+		defer servers[id].Close()
+		nodes[id].Start()
+	}
+
+	var leader *Raft
+	deadline := time.Now().Add(10 * time.Second)
+	for leader == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		for _, n := range nodes {
+			if n.Leader() {
+				leader = n
+			}
+		}
+	}
+	if leader == nil {
+		t.Fatalf("expected a leader to be elected")
+	}
+
+	for _, cmd := range []raftCommand{
+		{Op: raftRoom, Room: "gotham", Owner: "batman", Public: true},
+		{Op: raftBan, Room: "gotham", Nick: "joker"},
+		{Op: raftAccount, Account: &Account{Name: "batman", Salt: "00", Hash: "00"}},
+	} {
+		if err := leader.Propose(cmd); err != nil {
+			t.Errorf("expected the change committed, got %v", err)
+		}
+	}
+
+	for _, n := range nodes {
+		if n == leader {
+			continue
+		}
+		if err := n.Propose(raftCommand{Op: raftRoom, Room: "arkham"}); err == nil {
+			t.Errorf("expected standby to refuse changes")
+		}
+
+		ok := false
+		for !ok && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			s := n.Server
+			s.mu.Lock()
+			r := s.Rooms["gotham"]
+			ok = r != nil && r.Owner == "batman" && r.Public && r.Bans["joker"] && s.Accounts.Exists("batman")
+			s.mu.Unlock()
+		}
+		if !ok {
+			t.Errorf("expected node [%s] to apply the replicated state", n.ID)
+		}
+	}
+}
+
+func TestRaftSecret(t *testing.T) {
+	key, _ := newClusterKey("s3cret")
+	other, _ := newClusterKey("guess")
+	node := NewRaft(NewServer(), "a", map[string]string{}, "", key)
+	srv := httptest.NewServer(node.Handler())
+	defer srv.Close()
+
+	// a plain request, or one sealed with another secret, is refused
+	forged := appendRequest{Term: 99, Leader: "joker", Entries: []raftEntry{{Term: 99, Cmd: raftCommand{Op: raftAccount, Account: &Account{Name: "batman", Role: RoleOwner}}}}}
+	res, err := http.Post(srv.URL+"/raft/append", "application/json", strings.NewReader(`{"term":99,"leader":"joker"}`))
+	if err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a plain rpc refused, got %v %v", res, err)
+	}
+	intruder := NewRaft(NewServer(), "joker", nil, "", other)
+	var resp appendResponse
+	if err := intruder.call(srv.URL+"/raft/append", forged, &resp); err == nil {
+		t.Errorf("expected an rpc sealed with another secret refused")
+	}
+	// a request sealed for another rpc is refused too
+	b, _ := json.Marshal(forged)
+	sealed, _ := key.seal(b, "/raft/vote")
+	res, err = http.Post(srv.URL+"/raft/append", "application/octet-stream", bytes.NewReader(sealed))
+	if err != nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected an rpc sealed for another path refused, got %v %v", res, err)
+	}
+	if node.term != 0 || node.Server.Accounts.Exists("batman") {
+		t.Errorf("expected the node untouched")
+	}
+
+	// a lone node commits on its own, a standby refuses at once
+	node.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for !node.Leader() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := node.Propose(raftCommand{Op: raftRoom, Room: "gotham"}); err != nil {
+		t.Errorf("expected a lone leader to commit, got %v", err)
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := parsePeers("a=http://10.0.0.1:8093/,b=http://10.0.0.2:8093", "a")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	if len(peers) != 1 || peers["b"] != "http://10.0.0.2:8093" {
+		t.Errorf("unexpected peers %v", peers)
+	}
+
+	if _, err := parsePeers("a", "a"); err == nil {
+		t.Errorf("expected invalid peer to fail")
+	}
+}
+
+func TestBan(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+
+	if err := serv.Ban(joker, "batman"); err == nil {
+		t.Errorf("expected only the owner to ban")
+	}
+
+	if err := serv.Ban(batman, "joker"); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	if r, _ := serv.findRoom(joker); r == nil || r.Name != DefaultRoom {
+		t.Errorf("expected banned client to be moved to the default room")
+	}
+	if err := serv.JoinRoom("gotham", joker); err == nil {
+		t.Errorf("expected banned client to be refused")
+	}
+
+	if err := serv.Unban(batman, "joker"); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	if err := serv.JoinRoom("gotham", joker); err != nil {
+		t.Errorf("expected unbanned client to join")
+	}
+}
+
+// electLeader waits for one of the nodes to lead
+func electLeader(t *testing.T, nodes []*Raft) *Raft {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		for _, n := range nodes {
+			if n.Leader() {
+				return n
+			}
+		}
+	}
+	t.Fatalf("expected a leader to be elected")
+	return nil
+}
+
+func TestRaftCompact(t *testing.T) {
+	key, _ := newClusterKey("s3cret")
+	p := path.Join(t.TempDir(), raftName)
+	node := NewRaft(NewServer(), "a", map[string]string{}, p, key)
+	node.compactAt = 5
+	node.Start()
+	electLeader(t, []*Raft{node})
+
+	if err := node.Propose(raftCommand{Op: raftBan, Room: "gotham", Nick: "joker"}); err != nil {
+		t.Fatalf("expected the change committed, got %v", err)
+	}
+	for i := 0; i < 11; i++ {
+		cmd := raftCommand{Op: raftRoom, Room: "gotham", Topic: fmt.Sprintf("night %d", i), Fields: []string{"topic"}}
+		if err := node.Propose(cmd); err != nil {
+			t.Fatalf("expected the change committed, got %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var snap, last int64
+	for snap < 10 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		node.mu.Lock()
+		snap, last = node.snapIndex, node.lastIndex()
+		node.mu.Unlock()
+	}
+	if snap < 10 || last != 12 {
+		t.Fatalf("expected the log compacted, got snapshot %d of %d entries", snap, last)
+	}
+	b, err := ioutil.ReadFile(p + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := int64(bytes.Count(b, []byte("\n"))); n != last-snap {
+		t.Errorf("expected %d entries in the log file, got %d", last-snap, n)
+	}
+
+	// a restart starts from the snapshot and the entries after it
+	again := NewRaft(NewServer(), "a", map[string]string{}, p, key)
+	if err := again.Load(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if again.snapIndex != snap || again.lastIndex() != last {
+		t.Errorf("expected snapshot %d of %d entries, got %d of %d", snap, last, again.snapIndex, again.lastIndex())
+	}
+	r := again.Server.Rooms["gotham"]
+	if r == nil || !r.Bans["joker"] || r.Topic == "" {
+		t.Errorf("expected the snapshot restored, got %+v", r)
+	}
+}
+
+func TestRaftSnapshotInstall(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	servers := make(map[string]*httptest.Server)
+	peers := make(map[string]string)
+	for _, id := range ids {
+		srv := httptest.NewUnstartedServer(nil)
+		defer srv.Close()
+		servers[id] = srv
+		peers[id] = "http://" + srv.Listener.Addr().String()
+	}
+
+	key, _ := newClusterKey("s3cret")
+	var nodes []*Raft
+	for _, id := range ids {
+		p := make(map[string]string)
+		for other, url := range peers {
+			if other != id {
+				p[other] = url
+			}
+		}
+		n := NewRaft(NewServer(), id, p, "", key)
+		n.compactAt = 5
+		servers[id].Config.Handler = n.Handler()
+		nodes = append(nodes, n)
+	}
+
+	// c is down while the others compact the entries it misses
+	for _, n := range nodes[:2] {
+		servers[n.ID].Start()
+		n.Start()
+	}
+	leader := electLeader(t, nodes[:2])
+	if err := leader.Propose(raftCommand{Op: raftBan, Room: "gotham", Nick: "joker"}); err != nil {
+		t.Fatalf("expected the change committed, got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := leader.Propose(raftCommand{Op: raftRoom, Room: "gotham", Owner: "batman", Fields: []string{"owner"}}); err != nil {
+			t.Fatalf("expected the change committed, got %v", err)
+		}
+	}
+
+	late := nodes[2]
+	servers[late.ID].Start()
+	late.Start()
+
+	ok := false
+	deadline := time.Now().Add(10 * time.Second)
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		s := late.Server
+		s.mu.Lock()
+		r := s.Rooms["gotham"]
+		ok = r != nil && r.Owner == "batman" && r.Bans["joker"]
+		s.mu.Unlock()
+	}
+	late.mu.Lock()
+	snap := late.snapIndex
+	late.mu.Unlock()
+	if !ok || snap == 0 {
+		t.Errorf("expected the late node to catch up from a snapshot, got snapshot %d", snap)
+	}
+}
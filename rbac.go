@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// roles, from the least to the most trusted, guests are clients not logged
+// in to an account and users are logged in, an empty role is a guest
+const (
+	RoleGuest     = "guest"
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+	RoleOwner     = "owner"
+)
+
+// roles lists the roles in rank order
+var roles = []string{RoleGuest, RoleUser, RoleModerator, RoleAdmin, RoleOwner}
+
+// rolePlurals names who holds a role in messages
+var rolePlurals = map[string]string{
+	RoleGuest:     "guests",
+	RoleUser:      "registered users",
+	RoleModerator: "moderators",
+	RoleAdmin:     "admins",
+	RoleOwner:     "owners",
+}
+
+// rank returns how trusted a role is, unknown roles rank as guests
+func rank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return 0
+}
+
+// validRole returns true if role is one of the roles
+func validRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePermissions parses a comma separated list of command:role pairs,
+// command names may be given without the slash
+func parsePermissions(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, v := range splitList(s) {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 || kv[0] == "" || !validRole(kv[1]) {
+			return nil, fmt.Errorf("expected command:role, got [%s]", v)
+		}
+		out["/"+strings.TrimPrefix(kv[0], "/")] = kv[1]
+	}
+	return out, nil
+}
+
+// configRole returns the role the config gives the account, or false
+func (s *Server) configRole(a string) (string, bool) {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	switch {
+	case s.Owners[a]:
+		return RoleOwner, true
+	case s.Admins[a]:
+		return RoleAdmin, true
+	case s.Moderators[a]:
+		return RoleModerator, true
+	}
+	return "", false
+}
+
+// accountRole returns the role of an account, set in the config or with
+// /role, accounts have the user role otherwise
+func (s *Server) accountRole(a string) string {
+	if a == "" {
+		return RoleGuest
+	}
+	if role, ok := s.configRole(a); ok {
+		return role
+	}
+	if acct, ok := s.Accounts.Get(a); ok && validRole(acct.Role) {
+		return acct.Role
+	}
+	return RoleUser
+}
+
+// RoleOf returns the role of the client
+func (s *Server) RoleOf(cl *Client) string {
+	return s.accountRole(cl.Account())
+}
+
+// hasRole returns true if the client's role is at least role
+func (s *Server) hasRole(cl *Client, role string) bool {
+	return rank(s.RoleOf(cl)) >= rank(role)
+}
+
+// isAdmin returns true if the client is logged in to an admin or owner
+// account
+func (s *Server) isAdmin(cl *Client) bool {
+	return s.hasRole(cl, RoleAdmin)
+}
+
+// adminAccount returns true if the account is an admin or owner
+func (s *Server) adminAccount(a string) bool {
+	return rank(s.accountRole(a)) >= rank(RoleAdmin)
+}
+
+// moderates returns true if the client owns or is an op of the room, or is
+// a moderator of the whole server
+func (s *Server) moderates(r *Room, cl *Client) bool {
+	return r.moderatedBy(cl) || s.hasRole(cl, RoleModerator)
+}
+
+// permission returns the role needed to run the command, TCPermissions
+// overrides the role it was registered with
+func (s *Server) permission(name, role string) string {
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	if r, ok := s.Permissions[name]; ok {
+		return r
+	}
+	return role
+}
+
+// authorize returns true if the client may run the command, and tells the
+// client why not otherwise
+func (s *Server) authorize(cl *Client, name, role string) bool {
+	need := s.permission(name, role)
+	if s.hasRole(cl, need) {
+		return true
+	}
+	if need == RoleUser {
+		cl.Write(fmt.Sprintf("only registered users can use %s, /register first\r\n", name))
+	} else {
+		cl.Write(fmt.Sprintf("only %s can use %s\r\n", rolePlurals[need], name))
+	}
+	return false
+}
+
+// SetRole gives an account a role, nobody may change the role of someone
+// as trusted as themselves or give a role as trusted as their own, and
+// roles set in the config can only be changed there
+func (s *Server) SetRole(by, byRole, account, role string) error {
+	if !validRole(role) {
+		return fmt.Errorf("[%s] is not a role, try %s\r\n", role, strings.Join(roles, ", "))
+	}
+	if !s.Accounts.Exists(account) {
+		return fmt.Errorf("nick [%s] is not registered\r\n", account)
+	}
+	if _, ok := s.configRole(account); ok {
+		return fmt.Errorf("the role of [%s] is set in the config file\r\n", account)
+	}
+	if rank(s.accountRole(account)) >= rank(byRole) || rank(role) >= rank(byRole) {
+		return errors.New("you can only change the roles of those less trusted than you, to roles below your own\r\n")
+	}
+
+	err := s.Accounts.Update(account, func(a *Account) {
+		a.Role = role
+		if role == RoleUser {
+			a.Role = ""
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logMod(by, "role "+role, account, "")
+	errl(nil, fmt.Sprintf("[%s] given role [%s] by [%s]", account, role, by))
+	if c := s.findAccount(account); c != nil {
+		c.Write(fmt.Sprintf("You are now a %s\r\n", role))
+	}
+	return nil
+}
+
+// roleCommand runs /role
+func (s *Server) roleCommand(in *Input) {
+	switch len(in.Args) {
+	case 1:
+		in.Client.Write(fmt.Sprintf("You are a %s\r\n", s.RoleOf(in.Client)))
+	case 2:
+		if !s.Accounts.Exists(in.Args[1]) {
+			in.Client.Write(fmt.Sprintf("[%s] is a %s\r\n", in.Args[1], RoleGuest))
+			return
+		}
+		in.Client.Write(fmt.Sprintf("[%s] is a %s\r\n", in.Args[1], s.accountRole(in.Args[1])))
+	default:
+		err := s.SetRole(in.Client.Nick(), s.RoleOf(in.Client), in.Args[1], in.Args[2])
+		reply(in.Client, fmt.Sprintf("[%s] is now a %s\r\n", in.Args[1], in.Args[2]), err)
+	}
+}
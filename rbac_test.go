@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	serv := NewServer()
+	serv.Owners = map[string]bool{"bruce": true}
+	serv.Admins = map[string]bool{"alfred": true}
+	serv.Moderators = map[string]bool{"gordon": true}
+	for _, a := range []string{"bruce", "alfred", "gordon", "robin", "dick"} {
+		if err := serv.Accounts.Register(a, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := map[string]string{"bruce": RoleOwner, "alfred": RoleAdmin, "gordon": RoleModerator, "robin": RoleUser, "": RoleGuest}
+	for a, role := range expect {
+		if got := serv.RoleOf(&Client{nick: "x", account: a}); got != role {
+			t.Errorf("expected [%s] to be a %s, got %s", a, role, got)
+		}
+	}
+
+	if err := serv.SetRole("alfred", RoleAdmin, "robin", RoleModerator); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if got := serv.accountRole("robin"); got != RoleModerator {
+		t.Errorf("expected robin to be a moderator, got %s", got)
+	}
+	if err := serv.SetRole("alfred", RoleAdmin, "dick", RoleAdmin); err == nil {
+		t.Errorf("expected admins to be unable to make admins")
+	}
+	if err := serv.SetRole("robin", RoleModerator, "alfred", RoleUser); err == nil {
+		t.Errorf("expected moderators to be unable to demote admins")
+	}
+	if err := serv.SetRole("bruce", RoleOwner, "gordon", RoleUser); err == nil {
+		t.Errorf("expected roles in the config to be refused")
+	}
+	if err := serv.SetRole("bruce", RoleOwner, "joker", RoleUser); err == nil {
+		t.Errorf("expected unregistered nicks to be refused")
+	}
+	if err := serv.SetRole("bruce", RoleOwner, "dick", "sidekick"); err == nil {
+		t.Errorf("expected unknown roles to be refused")
+	}
+	if err := serv.SetRole("bruce", RoleOwner, "robin", RoleUser); err != nil || serv.accountRole("robin") != RoleUser {
+		t.Errorf("expected robin to be a user again, got %v", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	serv := NewServer()
+	serv.Moderators = map[string]bool{"gordon": true}
+	var err error
+	if serv.Permissions, err = parsePermissions("blast:moderator,/poll:user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePermissions("blast:sidekick"); err == nil {
+		t.Errorf("expected unknown roles to be refused")
+	}
+
+	joker := &Client{nick: "joker"}
+	gordon := &Client{nick: "gordon", account: "gordon"}
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("main", gordon)
+
+	serv.Dispatch(&Input{Client: joker, Command: "/blast", Args: []string{"/blast", "hahaha"}})
+	serv.Dispatch(&Input{Client: joker, Command: "/poll", Args: []string{"/poll"}})
+	expect := []string{"only moderators can use /blast\r\n", "only registered users can use /poll, /register first\r\n"}
+	if len(joker.unread) != len(expect) {
+		t.Fatalf("expected joker to be refused, got %+v", joker.unread)
+	}
+	for i, text := range expect {
+		if joker.unread[i].Text != text {
+			t.Errorf("expected %q, got %q", text, joker.unread[i].Text)
+		}
+	}
+
+	// moderators moderate every room
+	serv.JoinRoom("gotham", gordon)
+	if err := serv.Ban(gordon, "joker"); err != nil {
+		t.Errorf("expected moderators to ban in any room, got %v", err)
+	}
+	if err := serv.Ban(gordon, "batman"); err == nil {
+		t.Errorf("expected the owner of the room to be unbannable")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmoji is how many bytes a reaction may be, enough for emoji joined
+// with modifiers
+const maxEmoji = 32
+
+// validEmoji returns true if e can be a reaction, a single word of
+// printable characters, zero width joiners may join emoji
+func validEmoji(e string) bool {
+	if e == "" || len(e) > maxEmoji || !utf8.ValidString(e) {
+		return false
+	}
+	for _, c := range e {
+		if unicode.IsSpace(c) || !unicode.IsGraphic(c) && c != '\u200d' {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionSummary renders reaction counts, the most used first
+func reactionSummary(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	var emoji []string
+	for e := range counts {
+		emoji = append(emoji, e)
+	}
+	sort.Slice(emoji, func(i, j int) bool {
+		if counts[emoji[i]] != counts[emoji[j]] {
+			return counts[emoji[i]] > counts[emoji[j]]
+		}
+		return emoji[i] < emoji[j]
+	})
+	var out []string
+	for _, e := range emoji {
+		out = append(out, fmt.Sprintf("%s %d", e, counts[e]))
+	}
+	return strings.Join(out, ", ")
+}
+
+// counts returns how many nicks reacted to the message with id with each
+// emoji, it must be called with the server lock held
+func (r *Room) counts(id int64) map[string]int {
+	out := make(map[string]int)
+	for e, nicks := range r.Reactions[id] {
+		out[e] = len(nicks)
+	}
+	return out
+}
+
+// toggle adds the nick's reaction to the message with id, or takes it back
+// if the nick already reacted so, it returns true if it was taken back, it
+// must be called with the server lock held
+func (r *Room) toggle(id int64, emoji, nick string) bool {
+	if r.Reactions == nil {
+		r.Reactions = make(map[int64]map[string][]string)
+	}
+	m, ok := r.Reactions[id]
+	if !ok {
+		m = make(map[string][]string)
+		r.Reactions[id] = m
+	}
+	for i, n := range m[emoji] {
+		if n == nick {
+			m[emoji] = append(m[emoji][:i:i], m[emoji][i+1:]...)
+			if len(m[emoji]) == 0 {
+				delete(m, emoji)
+			}
+			if len(m) == 0 {
+				delete(r.Reactions, id)
+			}
+			return true
+		}
+	}
+	m[emoji] = append(m[emoji], nick)
+	return false
+}
+
+// forgetReactions drops the reactions to messages no longer in the history,
+// it must be called with the server lock held
+func (r *Room) forgetReactions() {
+	if len(r.Reactions) == 0 {
+		return
+	}
+	var oldest int64
+	for _, ev := range r.History {
+		if ev.ID != 0 {
+			oldest = ev.ID
+			break
+		}
+	}
+	for id := range r.Reactions {
+		if id < oldest {
+			delete(r.Reactions, id)
+		}
+	}
+}
+
+// React adds a reaction to a message of the client's room, or takes it back
+// when sent again, the room is sent the new counts
+// example: /react 42 👍
+func (s *Server) React(inputs []string, cl *Client) error {
+	id, err := parseMessageID(inputs[1])
+	if err != nil {
+		return err
+	}
+	emoji := inputs[2]
+	if !validEmoji(emoji) {
+		return fmt.Errorf("[%s] is not a reaction\r\n", emoji)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+	p, ok := r.find(id)
+	if !ok {
+		return fmt.Errorf("message [%d] is not in room [%s]\r\n", id, r.Name)
+	}
+
+	removed := r.toggle(id, emoji, cl.Nick())
+	ev := Event{
+		Type:      EventReaction,
+		Time:      time.Now().Format(time.RFC3339),
+		From:      cl.Nick(),
+		Room:      r.Name,
+		Text:      emoji,
+		Parent:    id,
+		Quote:     quote(p),
+		Reactions: r.counts(id),
+		Removed:   removed,
+	}
+	for _, c := range r.Clients {
+		c.Send(ev)
+	}
+	s.emit(ev)
+	return nil
+}
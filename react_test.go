@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestReact(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+
+	serv.Message([]string{"to", "the", "batmobile"}, batman)
+	id := strconv.FormatInt(robin.unread[len(robin.unread)-1].ID, 10)
+
+	if err := serv.React([]string{"/react", "999", "👍"}, robin); err == nil {
+		t.Errorf("expected a reaction to a missing message to fail")
+	}
+	if err := serv.React([]string{"/react", id, "\x1b[31m"}, robin); err == nil {
+		t.Errorf("expected control characters to be refused")
+	}
+
+	serv.React([]string{"/react", id, "👍"}, robin)
+	serv.React([]string{"/react", id, "👍"}, batman)
+	serv.React([]string{"/react", id, "🦇"}, batman)
+	ev := robin.unread[len(robin.unread)-1]
+	if ev.Type != EventReaction || ev.Reactions["👍"] != 2 || ev.Reactions["🦇"] != 1 || ev.Removed {
+		t.Fatalf("expected 👍 2 and 🦇 1, got %+v", ev)
+	}
+	if want := "[" + ev.Time + ":batman] reacted 🦇 to (batman: to the batmobile), reactions: 👍 2, 🦇 1\r\n"; ev.String() != want {
+		t.Errorf("expected [%s], got [%s]", want, ev.String())
+	}
+
+	// reacting again takes it back
+	serv.React([]string{"/react", "#" + id, "👍"}, robin)
+	ev = batman.unread[len(batman.unread)-1]
+	if !ev.Removed || ev.Reactions["👍"] != 1 {
+		t.Errorf("expected robin's 👍 taken back, got %+v", ev)
+	}
+
+	c := NewConn(nil)
+	c.SetJSON(true)
+	var got Event
+	if err := json.Unmarshal([]byte(c.Render(ev)), &got); err != nil || got.Reactions["🦇"] != 1 || got.Parent != ev.Parent {
+		t.Errorf("expected the counts in JSON, got %+v", got)
+	}
+
+	// reactions are forgotten along with their message
+	r := serv.Rooms["gotham"]
+	for i := 0; i < maxHistory; i++ {
+		serv.Message([]string{"na"}, robin)
+	}
+	if len(r.Reactions) != 0 {
+		t.Errorf("expected reactions to old messages to be dropped, got %v", r.Reactions)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// receipt statuses of a private message
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// maxDirects is how many private messages are remembered for read receipts
+const maxDirects = 1000
+
+// sentDirect is who sent a private message and who it was sent to
+type sentDirect struct {
+	from string
+	to   string
+}
+
+// online returns true if the client has a connection, or is bridged
+func (cl *Client) online() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return len(cl.Conns) > 0 || cl.relay != nil
+}
+
+// receipt returns the receipt of a private message sent to from its
+// recipient
+func receipt(ev Event, status string) Event {
+	return Event{
+		Type:   EventReceipt,
+		Time:   time.Now().Format(time.RFC3339),
+		From:   ev.To,
+		To:     ev.From,
+		Parent: ev.ID,
+		Status: status,
+	}
+}
+
+// delivered tells the sender of a private message it reached the
+// recipient, and remembers it so the recipient can mark it read, receipts
+// are only rendered for JSON connections, it must be called with the server
+// lock held
+func (s *Server) delivered(ev Event, to, from *Client) {
+	if s.directs == nil {
+		s.directs = make(map[int64]sentDirect)
+	}
+	s.directs[ev.ID] = sentDirect{from: ev.From, to: ev.To}
+	s.directOrder = append(s.directOrder, ev.ID)
+	if len(s.directOrder) > maxDirects {
+		delete(s.directs, s.directOrder[0])
+		s.directOrder = s.directOrder[1:]
+	}
+
+	if to.online() {
+		from.Send(receipt(ev, ReceiptDelivered))
+	}
+}
+
+// Read tells the sender of the private message with id that the client
+// read it
+// example: /read 42
+func (s *Server) Read(cl *Client, arg string) error {
+	id, err := parseMessageID(arg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.directs[id]
+	if !ok || d.to != cl.Nick() {
+		return fmt.Errorf("you have no private message [%d]\r\n", id)
+	}
+	delete(s.directs, id)
+	if from, ok := s.Clients[d.from]; ok {
+		from.Send(receipt(Event{ID: id, From: d.from, To: d.to}, ReceiptRead))
+	}
+	return nil
+}
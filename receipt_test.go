@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReceipts(t *testing.T) {
+	serv := NewServer()
+	batman, alfred, robin := &Client{nick: "batman"}, &Client{nick: "alfred"}, &Client{nick: "robin"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("batcave", alfred)
+	serv.JoinRoom("batcave", robin)
+
+	// alfred is connected, or bridged, so the message is delivered
+	alfred.relay = func(Event) {}
+	if err := serv.Direct([]string{"/msg", "alfred", "tea", "please"}, batman); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	ev := batman.unread[len(batman.unread)-1]
+	if ev.Type != EventReceipt || ev.Status != ReceiptDelivered || ev.From != "alfred" || ev.Parent == 0 {
+		t.Fatalf("expected a delivered receipt, got %+v", ev)
+	}
+	if ev.String() != "" {
+		t.Errorf("expected receipts hidden from text connections, got [%s]", ev.String())
+	}
+	id := strconv.FormatInt(ev.Parent, 10)
+
+	if err := serv.Read(robin, id); err == nil {
+		t.Errorf("expected robin to be unable to read alfred's message")
+	}
+	if err := serv.Read(alfred, id); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	ev = batman.unread[len(batman.unread)-1]
+	if ev.Type != EventReceipt || ev.Status != ReceiptRead || strconv.FormatInt(ev.Parent, 10) != id {
+		t.Errorf("expected a read receipt for %s, got %+v", id, ev)
+	}
+	if err := serv.Read(alfred, id); err == nil {
+		t.Errorf("expected a message to be read once")
+	}
+
+	// robin isn't connected, the message waits undelivered
+	n := len(batman.unread)
+	serv.Direct([]string{"/msg", "robin", "patrol"}, batman)
+	if ev := batman.unread[len(batman.unread)-1]; len(batman.unread) != n+1 || ev.Type != EventDirect {
+		t.Errorf("expected no receipt for robin, got %+v", ev)
+	}
+}
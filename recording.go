@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingNotice is shown atop the banner while sessions are recorded
+const recordingNotice = "NOTICE: this server records complete transcripts of every session, including direct messages\n"
+
+// transcriptPattern matches the daily files of a recording directory
+const transcriptPattern = "transcript-*.log"
+
+// secretArgs are the commands whose arguments from the index on are
+// credentials, they are redacted before input is recorded
+var secretArgs = map[string]int{
+	"/login":    2,
+	"/register": 1,
+	"/forget":   1,
+	"/resume":   1,
+	"/push":     2,
+}
+
+// transcriptEntry is a line of a transcript, what a session typed or an
+// event it was shown, hash covers the entry with hash empty, and prev is
+// the hash of the entry before it, so editing, dropping, or reordering
+// lines breaks the chain
+type transcriptEntry struct {
+	Seq     int64           `json:"seq"`
+	Time    string          `json:"time"`
+	Nick    string          `json:"nick"`
+	Account string          `json:"account,omitempty"`
+	Dir     string          `json:"dir"`
+	Input   string          `json:"input,omitempty"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Prev    string          `json:"prev"`
+	Hash    string          `json:"hash,omitempty"`
+}
+
+// sum returns the hash of the entry
+func (e transcriptEntry) sum() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Recorder appends the transcript of every session to a file a day in
+// dir, each entry chained to the one before it across files
+type Recorder struct {
+	mu   sync.Mutex
+	dir  string
+	day  string
+	f    *os.File
+	seq  int64
+	head string
+}
+
+// recording is the recorder of the server, nil while sessions aren't
+// recorded
+var recording struct {
+	sync.RWMutex
+	r *Recorder
+}
+
+// recorder returns the recorder, nil when recording is off
+func recorder() *Recorder {
+	recording.RLock()
+	defer recording.RUnlock()
+	return recording.r
+}
+
+// setRecorder starts recording sessions to r, nil stops
+func setRecorder(r *Recorder) {
+	recording.Lock()
+	defer recording.Unlock()
+	recording.r = r
+}
+
+// OpenRecorder records to dir, continuing the chain of the transcripts
+// already there
+func OpenRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	r := &Recorder{dir: dir}
+	files, err := transcripts(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last, err := lastEntry(files[len(files)-1])
+		if err != nil {
+			return nil, err
+		}
+		r.seq, r.head = last.Seq, last.Hash
+	}
+	return r, nil
+}
+
+// transcripts returns the transcript files of dir, oldest first
+func transcripts(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, transcriptPattern))
+	sort.Strings(files)
+	return files, err
+}
+
+// readEntries calls fn with each entry of the transcript at path and its
+// line number
+func readEntries(path string, fn func(line int, e transcriptEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		b, err := openData(sc.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		var e transcriptEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if err := fn(n, e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// lastEntry returns the last entry of the transcript at path
+func lastEntry(path string) (transcriptEntry, error) {
+	var last transcriptEntry
+	err := readEntries(path, func(_ int, e transcriptEntry) error {
+		last = e
+		return nil
+	})
+	return last, err
+}
+
+// Input records a line the client typed, with credentials redacted
+func (r *Recorder) Input(cl *Client, args []string) {
+	if r == nil || len(args) == 0 {
+		return
+	}
+	if from, ok := secretArgs[strings.ToLower(args[0])]; ok {
+		redacted := append([]string(nil), args...)
+		for i := from; i < len(redacted); i++ {
+			redacted[i] = "[redacted]"
+		}
+		args = redacted
+	}
+	r.record(transcriptEntry{
+		Nick:    cl.Nick(),
+		Account: cl.Account(),
+		Dir:     "in",
+		Input:   strings.Join(args, " "),
+	})
+}
+
+// Output records an event shown to the client, called with its lock held
+// so the nick and account are read directly
+func (r *Recorder) Output(cl *Client, ev Event) {
+	if r == nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	r.record(transcriptEntry{Nick: cl.nick, Account: cl.account, Dir: "out", Event: b})
+}
+
+func (r *Recorder) record(e transcriptEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	if err := r.open(now.Format("2006-01-02")); err != nil {
+		errl(fmt.Errorf("error recording transcript: %v", err), "")
+		return
+	}
+	e.Seq = r.seq + 1
+	e.Time = now.Format(time.RFC3339Nano)
+	e.Prev = r.head
+	hash, err := e.sum()
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	e.Hash = hash
+	b, err := json.Marshal(e)
+	if err == nil {
+		b, err = sealData(b)
+	}
+	if err == nil {
+		_, err = r.f.Write(append(b, '\n'))
+	}
+	if err != nil {
+		errl(fmt.Errorf("error recording transcript: %v", err), "")
+		return
+	}
+	r.seq, r.head = e.Seq, hash
+}
+
+// open switches to the transcript of day, it must be called with the
+// lock held
+func (r *Recorder) open(day string) error {
+	if r.f != nil && r.day == day {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(r.dir, "transcript-"+day+".log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.f, r.day = f, day
+	return nil
+}
+
+// Close closes the current transcript
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Verify checks the chain of every transcript, it returns how many
+// entries were checked and the hash of the last one
+func (r *Recorder) Verify() (int64, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return verifyTranscripts(r.dir)
+}
+
+// verifyTranscripts checks the chain of the transcripts in dir
+func verifyTranscripts(dir string) (int64, string, error) {
+	files, err := transcripts(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	var n, seq int64
+	head := ""
+	for _, path := range files {
+		err := readEntries(path, func(line int, e transcriptEntry) error {
+			hash, err := e.sum()
+			if err != nil {
+				return err
+			}
+			switch {
+			case hash != e.Hash:
+				return fmt.Errorf("%s:%d: entry %d was altered", path, line, e.Seq)
+			case e.Prev != head:
+				return fmt.Errorf("%s:%d: entry %d doesn't follow the entry before it", path, line, e.Seq)
+			case seq != 0 && e.Seq != seq+1:
+				return fmt.Errorf("%s:%d: entries %d to %d are missing", path, line, seq+1, e.Seq-1)
+			}
+			n, seq, head = n+1, e.Seq, e.Hash
+			return nil
+		})
+		if err != nil {
+			return n, head, err
+		}
+	}
+	return n, head, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := OpenRecorder(dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	setRecorder(r)
+	defer setRecorder(nil)
+
+	serv := NewServer()
+	batman, joker := &Client{nick: "batman"}, &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+	if banner := serv.Banner("batman", ""); !strings.HasPrefix(banner, "\n"+recordingNotice) {
+		t.Errorf("expected the banner to disclose the recording, got [%s]", banner)
+	}
+
+	r.Input(joker, []string{"/msg", "batman", "meet", "me", "at", "the", "docks"})
+	serv.Direct([]string{"/msg", "batman", "meet", "me", "at", "the", "docks"}, joker)
+	r.Input(batman, []string{"/login", "batman", "hunter2"})
+	r.Input(batman, []string{"/push", "gotify", "https://gotify.example.com", "AbCdEf123"})
+	r.Close()
+
+	n, head, err := verifyTranscripts(dir)
+	if err != nil || n < 3 || head == "" {
+		t.Fatalf("expected the chain to verify, got %d entries, %v", n, err)
+	}
+	files, _ := transcripts(dir)
+	b, _ := ioutil.ReadFile(files[0])
+	if bytes.Count(b, []byte("meet me at the docks")) < 2 {
+		t.Errorf("expected the direct message recorded as typed and as shown")
+	}
+	if bytes.Contains(b, []byte("hunter2")) || !bytes.Contains(b, []byte("/login batman [redacted]")) {
+		t.Errorf("expected the password redacted")
+	}
+	if bytes.Contains(b, []byte("AbCdEf123")) || !bytes.Contains(b, []byte("/push gotify [redacted] [redacted]")) {
+		t.Errorf("expected the push target redacted")
+	}
+
+	r, err = OpenRecorder(dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	r.Input(batman, []string{"/who"})
+	r.Close()
+	if m, _, err := verifyTranscripts(dir); err != nil || m != n+1 {
+		t.Errorf("expected the chain continued after reopening, got %d entries, %v", m, err)
+	}
+
+	for name, tamper := range map[string]func([]string) []string{
+		"altered": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "docks", "manor", 1)
+			return lines
+		},
+		"dropped": func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reordered": func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+	} {
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		bad := filepath.Join(dir, "bad")
+		os.Mkdir(bad, 0700)
+		ioutil.WriteFile(filepath.Join(bad, filepath.Base(files[0])), []byte(strings.Join(tamper(lines), "\n")+"\n"), 0600)
+		if _, _, err := verifyTranscripts(bad); err == nil {
+			t.Errorf("expected a %s line to break the chain", name)
+		}
+		os.RemoveAll(bad)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisTimeout bounds dialing and request round trips to redis
+const redisTimeout = 5 * time.Second
+
+// redisConn is a minimal RESP client, enough for pub/sub and hashes
+type redisConn struct {
+	conn net.Conn
+	buf  *bufio.Reader
+}
+
+// dialRedis connects to redis at addr, authenticating if password is set
+func dialRedis(addr, password string) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{conn: conn, buf: bufio.NewReader(conn)}
+	if password != "" {
+		if _, err := rc.Do("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Close closes the connection
+func (rc *redisConn) Close() error {
+	return rc.conn.Close()
+}
+
+// Send writes a command without waiting for the reply
+func (rc *redisConn) Send(args ...string) error {
+	out := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		out = out + fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+	rc.conn.SetWriteDeadline(time.Now().Add(redisTimeout))
+	_, err := io.WriteString(rc.conn, out)
+	return err
+}
+
+// Do sends a command and reads its reply
+func (rc *redisConn) Do(args ...string) (interface{}, error) {
+	if err := rc.Send(args...); err != nil {
+		return nil, err
+	}
+	rc.conn.SetReadDeadline(time.Now().Add(redisTimeout))
+	defer rc.conn.SetReadDeadline(time.Time{})
+	return rc.Receive()
+}
+
+// Receive reads a single reply, strings are returned as string, integers
+// as int64, arrays as []interface{}, and nil replies as nil
+func (rc *redisConn) Receive() (interface{}, error) {
+	line, err := rc.buf.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("invalid redis reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.buf, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			if out[i], err = rc.Receive(); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply [%s]", line)
+}
+
+// redisStrings converts an array reply to strings
+func redisStrings(v interface{}) []string {
+	arr, _ := v.([]interface{})
+	out := make([]string, 0, len(arr))
+	for _, a := range arr {
+		s, _ := a.(string)
+		out = append(out, s)
+	}
+	return out
+}
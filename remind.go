@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remindersName is the file reminders are kept in so they survive restarts
+const remindersName = "reminders.json"
+
+// maxReminders is how many pending reminders a user may have
+const maxReminders = 20
+
+// maxRemindIn is the furthest ahead a reminder may be set
+const maxRemindIn = 365 * 24 * time.Hour
+
+// reminderTick is how often due reminders are checked for
+const reminderTick = time.Second
+
+// Reminder is a message delivered back to a user, or to a room, once it's
+// due, reminders for a user wait until the user is connected, scheduled
+// messages are sent to the room as the user
+type Reminder struct {
+	ID      int64     `json:"id"`
+	Nick    string    `json:"nick"`
+	Account string    `json:"account,omitempty"`
+	Room    string    `json:"room,omitempty"`
+	Text    string    `json:"text"`
+	Due     time.Time `json:"due"`
+	Send    bool      `json:"send,omitempty"`
+}
+
+// Reminders holds the pending reminders and saves them to path
+type Reminders struct {
+	mu     sync.Mutex
+	server *Server
+	path   string
+	lastID int64
+	list   []*Reminder
+}
+
+// NewReminders returns a reminder store saved to path, an empty path keeps
+// reminders in memory only
+func NewReminders(s *Server, path string) *Reminders {
+	return &Reminders{server: s, path: path}
+}
+
+// Load reads the reminders from disk, a missing file is not an error
+func (rs *Reminders) Load() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(rs.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = openData(b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &rs.list); err != nil {
+		return err
+	}
+	for _, r := range rs.list {
+		if r.ID > rs.lastID {
+			rs.lastID = r.ID
+		}
+	}
+	return nil
+}
+
+// save writes the reminders to disk, it must be called with the lock held
+func (rs *Reminders) save() error {
+	if rs.path == "" {
+		return nil
+	}
+
+	list := rs.list
+	if list == nil {
+		list = []*Reminder{}
+	}
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if b, err = sealData(b); err != nil {
+		return err
+	}
+
+	tmp := rs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rs.path)
+}
+
+// parseRemindIn parses a duration such as 90s, 10m, 1h30m, or 2d
+func parseRemindIn(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("[%s] is not a duration, try 10m, 2h, or 1d\r\n", s)
+	}
+	if d > maxRemindIn {
+		return 0, errors.New("reminders can be set at most a year ahead\r\n")
+	}
+	return d, nil
+}
+
+// Remind sets a reminder for the client, delivered to the client's room
+// instead when room is true
+func (rs *Reminders) Remind(cl *Client, in time.Duration, text string, room bool) (*Reminder, error) {
+	r := &Reminder{
+		Nick:    cl.Nick(),
+		Account: cl.Account(),
+		Text:    text,
+		Due:     time.Now().Add(in),
+	}
+	if room {
+		if r.Room = rs.server.RoomOf(cl); r.Room == "" {
+			return nil, errors.New("you are not in a room\r\n")
+		}
+	}
+
+	if err := rs.add(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// add queues a reminder, it fails if its user has too many pending
+func (rs *Reminders) add(r *Reminder) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	n := 0
+	for _, p := range rs.list {
+		if p.Nick == r.Nick {
+			n++
+		}
+	}
+	if n >= maxReminders {
+		return fmt.Errorf("you already have %d reminders pending\r\n", maxReminders)
+	}
+
+	rs.lastID++
+	r.ID = rs.lastID
+	rs.list = append(rs.list, r)
+	sort.SliceStable(rs.list, func(i, j int) bool { return rs.list[i].Due.Before(rs.list[j].Due) })
+	if err := rs.save(); err != nil {
+		errl(err, "")
+	}
+	return nil
+}
+
+// Run delivers reminders as they fall due
+func (rs *Reminders) Run() {
+	for range time.Tick(reminderTick) {
+		rs.Fire(time.Now())
+	}
+}
+
+// Fire delivers the reminders due by now, a reminder for a user who isn't
+// connected stays pending until they are
+func (rs *Reminders) Fire(now time.Time) {
+	rs.mu.Lock()
+	var due []*Reminder
+	for _, r := range rs.list {
+		if !r.Due.After(now) {
+			due = append(due, r)
+		}
+	}
+	rs.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	// deliver without the lock, delivering takes the server lock
+	done := make(map[int64]bool)
+	for _, r := range due {
+		if rs.deliver(r) {
+			done[r.ID] = true
+		}
+	}
+	if len(done) == 0 {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var list []*Reminder
+	for _, r := range rs.list {
+		if !done[r.ID] {
+			list = append(list, r)
+		}
+	}
+	rs.list = list
+	if err := rs.save(); err != nil {
+		errl(err, "")
+	}
+}
+
+// deliver sends a due reminder, it returns false if it has to wait
+func (rs *Reminders) deliver(r *Reminder) bool {
+	ev := Event{
+		Type: EventService,
+		Time: time.Now().Format(time.RFC3339),
+		From: "reminder",
+	}
+	if r.Send {
+		if err := rs.server.sendScheduled(r); err != nil {
+			errl(fmt.Errorf("dropping scheduled message %d: %v", r.ID, err), "")
+		}
+		return true
+	}
+	if r.Room != "" {
+		ev.Room = r.Room
+		ev.Text = fmt.Sprintf("[%s] asked to remind this room: %s", r.Nick, r.Text)
+		if err := rs.server.Deliver(ev); err != nil {
+			errl(fmt.Errorf("dropping reminder %d: %v", r.ID, err), "")
+		}
+		return true
+	}
+
+	cl := rs.server.reminderTarget(r)
+	if cl == nil {
+		return false
+	}
+	ev.To = cl.Nick()
+	ev.Text = "Reminder: " + r.Text
+	cl.Send(ev)
+	return true
+}
+
+// reminderTarget returns the client a reminder is for, found by account
+// when it was set while logged in
+func (s *Server) reminderTarget(r *Reminder) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Account != "" {
+		for _, c := range s.Clients {
+			if c.Account() == r.Account {
+				return c
+			}
+		}
+		return nil
+	}
+	return s.Clients[r.Nick]
+}
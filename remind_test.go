@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRemindIn(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"90s", 90 * time.Second, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"2d", 48 * time.Hour, true},
+		{"soon", 0, false},
+		{"-5m", 0, false},
+		{"400d", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRemindIn(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("expected %v %t for %s, got %v %v", tt.want, tt.ok, tt.in, got, err)
+		}
+	}
+}
+
+func TestReminders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, remindersName)
+
+	serv := NewServer()
+	serv.Reminders = NewReminders(serv, file)
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+
+	if _, err := serv.Reminders.Remind(batman, time.Minute, "check the signal", false); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Reminders.Remind(batman, time.Hour, "patrol starts", true)
+
+	// reminders survive a restart
+	serv = NewServer()
+	serv.Reminders = NewReminders(serv, file)
+	if err := serv.Reminders.Load(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Reminders.Fire(time.Now().Add(2 * time.Minute))
+	if len(serv.Reminders.list) != 2 {
+		t.Errorf("expected the reminder to wait for batman to connect")
+	}
+
+	batman = &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.Reminders.Fire(time.Now().Add(2 * time.Minute))
+	if len(serv.Reminders.list) != 1 || len(batman.unread) != 1 || batman.unread[0].Text != "Reminder: check the signal" {
+		t.Errorf("expected reminder to be delivered, got %+v", batman.unread)
+	}
+
+	serv.Reminders.Fire(time.Now().Add(2 * time.Hour))
+	r := serv.Rooms["gotham"]
+	if len(r.History) != 1 || !strings.Contains(r.History[0].Text, "patrol starts") {
+		t.Errorf("expected room reminder, got %+v", r.History)
+	}
+
+	b, _ := ioutil.ReadFile(file)
+	if strings.TrimSpace(string(b)) != "[]" {
+		t.Errorf("expected no reminders left, got %s", b)
+	}
+}
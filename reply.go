@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// quoteLen is how many characters of a message a reply quotes
+const quoteLen = 40
+
+// quote returns the snippet of ev a reply to it carries
+func quote(ev Event) string {
+	return ev.From + ": " + truncate(strings.Join(strings.Fields(ev.Text), " "), quoteLen)
+}
+
+// body returns the text of a message, a reply starts with the message it
+// quotes and a code message is set out on lines of its own
+func (ev Event) body() string {
+	if ev.Code {
+		return codeBlock(ev.Text)
+	}
+	if ev.Parent == 0 || ev.Quote == "" {
+		return ev.Text
+	}
+	return fmt.Sprintf("(re %s) %s", ev.Quote, ev.Text)
+}
+
+// parseMessageID parses the id of a message, as shown by /ids with or
+// without its #
+func parseMessageID(arg string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("[%s] is not a message id\r\n", arg)
+	}
+	return id, nil
+}
+
+// find returns the message with id from the room's history, it must be
+// called with the server lock held
+func (r *Room) find(id int64) (Event, bool) {
+	for i := len(r.History) - 1; i >= 0; i-- {
+		if ev := r.History[i]; ev.ID == id && ev.Type == EventMessage {
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+// Reply sends a message to the client's room as a reply to the message
+// with id
+// example: /reply 42 the joker is loose
+func (s *Server) Reply(inputs []string, cl *Client) error {
+	id, err := parseMessageID(inputs[1])
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.message(cl, strings.Join(inputs[2:], " "), id)
+}
+
+// SetIDs switches showing message ids on or off for the connection
+func (c *Conn) SetIDs(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.ids, v)
+}
+
+// IDs returns true if the connection is shown the id of each message
+func (c *Conn) IDs() bool {
+	return atomic.LoadInt32(&c.ids) == 1
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReply(t *testing.T) {
+	serv := NewServer()
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+
+	serv.Message([]string{"the", "joker", "is", "loose", "again", "and", "he", "took", "the", "batmobile"}, batman)
+	parent := robin.unread[len(robin.unread)-1]
+
+	if err := serv.Reply([]string{"/reply", "#999", "huh"}, robin); err == nil {
+		t.Errorf("expected a reply to a missing message to fail")
+	}
+	if err := serv.Reply([]string{"/reply", "joker", "huh"}, robin); err == nil {
+		t.Errorf("expected a bad id to fail")
+	}
+	if err := serv.Reply([]string{"/reply", "#" + strconv.FormatInt(parent.ID, 10), "holy", "car", "theft"}, robin); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	ev := batman.unread[len(batman.unread)-1]
+	if ev.Parent != parent.ID || ev.Text != "holy car theft" {
+		t.Fatalf("expected a reply to %d, got %+v", parent.ID, ev)
+	}
+	if ev.Quote != "batman: the joker is loose again and he took the…" {
+		t.Errorf("unexpected quote [%s]", ev.Quote)
+	}
+
+	c := NewConn(nil)
+	if out := c.Render(ev); !strings.HasSuffix(out, "] (re batman: the joker is loose again and he took the…) holy car theft\r\n") {
+		t.Errorf("expected the quote in the text rendering, got [%s]", out)
+	}
+	c.SetIDs(true)
+	if out := c.Render(ev); !strings.HasPrefix(out, "#"+strconv.FormatInt(ev.ID, 10)+" [") {
+		t.Errorf("expected the id shown, got [%s]", out)
+	}
+
+	c.SetJSON(true)
+	var got Event
+	if err := json.Unmarshal([]byte(c.Render(ev)), &got); err != nil || got.Parent != parent.ID {
+		t.Errorf("expected the parent in JSON, got %+v", got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// serviceNicks are the names the server sends notices as, they are always
+// reserved so nobody can pass as the server
+var serviceNicks = []string{"server", "control", "dice", "poll", "reminder"}
+
+// reservedMatch returns true if nick matches a reserved pattern, patterns
+// ignore case and may end in * to match a prefix
+func reservedMatch(nick, pattern string) bool {
+	nick, pattern = strings.ToLower(nick), strings.ToLower(pattern)
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(nick, strings.TrimSuffix(pattern, "*"))
+	}
+	return nick == pattern
+}
+
+// reservedNick returns true if only clients logged in to an account may
+// take nick
+func (s *Server) reservedNick(nick string) bool {
+	for _, p := range serviceNicks {
+		if reservedMatch(nick, p) {
+			return true
+		}
+	}
+	s.cfg.RLock()
+	defer s.cfg.RUnlock()
+	for _, p := range s.Reserved {
+		if reservedMatch(nick, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// guestNick returns a nick for a new connection that isn't reserved
+func (s *Server) guestNick() string {
+	n := time.Now().UnixNano()
+	for _, prefix := range []string{"user", "guest", "tc"} {
+		nick := fmt.Sprintf("%s%d", prefix, n)
+		if !s.reservedNick(nick) {
+			return nick
+		}
+	}
+	errl(fmt.Errorf("TCReservedNicks reserves every guest nick"), "")
+	return fmt.Sprintf("user%d", n)
+}
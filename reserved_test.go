@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReservedNicks(t *testing.T) {
+	serv := NewServer()
+	serv.Reserved = []string{"admin", "root", "gotham*"}
+	if err := serv.Accounts.Register("batman", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	joker := &Client{nick: "joker"}
+	batman := &Client{nick: "bruce"}
+	serv.JoinRoom("gotham", joker)
+	serv.JoinRoom("gotham", batman)
+
+	for _, nick := range []string{"Admin", "root", "GothamPD", "server", "dice"} {
+		if err := serv.ChangeNick("joker", nick); err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Errorf("expected [%s] to be reserved, got %v", nick, err)
+		}
+	}
+	if err := serv.ChangeNick("joker", "harley"); err != nil {
+		t.Errorf("expected other nicks to be free, got %v", err)
+	}
+
+	if _, err := serv.Login("batman", "hunter2", batman); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.ChangeNick("batman", "admin"); err != nil {
+		t.Errorf("expected a logged in user to take a reserved nick, got %v", err)
+	}
+
+	if _, err := serv.JoinNew("gotham", &Client{nick: "root"}); err == nil {
+		t.Errorf("expected gateway guests to be kept off reserved nicks")
+	}
+
+	serv.Reserved = []string{"user*"}
+	if nick := serv.guestNick(); !strings.HasPrefix(nick, "guest") {
+		t.Errorf("expected a guest nick outside the reserved ones, got %s", nick)
+	}
+}
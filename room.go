@@ -0,0 +1,459 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of events a room keeps
+const maxHistory = 500
+
+// maxMOTD is how long a room's message of the day may be
+const maxMOTD = 400
+
+// maxSummaryNicks is how many members are listed to someone joining a room
+const maxSummaryNicks = 20
+
+// nextID returns the next event ID, it must be called with the server lock
+// held
+func (s *Server) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+// record appends the event to the room's history, it must be called with
+// the server lock held
+func (r *Room) record(ev Event) {
+	if ev.Type == EventMessage {
+		r.Messages++
+	}
+	r.History = append(r.History, ev)
+	if len(r.History) > maxHistory {
+		r.History = r.History[len(r.History)-maxHistory:]
+		r.forgetReactions()
+	}
+}
+
+// settings returns the command that replicates the room's owner, topic,
+// visibility and since when, message lifetime, presence notices, spam filter, line limit,
+// and counters
+func (r *Room) settings() raftCommand {
+	return raftCommand{Op: raftRoom, Room: r.Name, Display: r.Display, Owner: r.Owner, Topic: r.Topic, Public: r.Public, TTL: r.TTL, Notices: r.Notices, MOTD: r.MOTD, Spam: r.Spam, SpamDrop: r.SpamDrop,
+		Created: r.Created.UTC().Format(time.RFC3339), Peak: r.Peak, Messages: r.Messages, LineLimit: r.LineLimit,
+		PublicSince: r.PublicSince}
+}
+
+// count takes on the counters of a copy of the room, the room is as old as
+// the older of the two and has seen the most of either
+func (r *Room) count(created string, peak int, messages int64) {
+	if t, err := time.Parse(time.RFC3339, created); err == nil && (r.Created.IsZero() || t.Before(r.Created)) {
+		r.Created = t
+	}
+	if peak > r.Peak {
+		r.Peak = peak
+	}
+	if messages > r.Messages {
+		r.Messages = messages
+	}
+}
+
+// ownedBy returns true if the client created the room
+func (r *Room) ownedBy(cl *Client) bool {
+	if r.Owner == "" {
+		return false
+	}
+	if a := cl.Account(); a != "" {
+		return r.Owner == a
+	}
+	return r.Owner == cl.Nick()
+}
+
+// opped returns true if the owner of the room made the client an op
+func (r *Room) opped(cl *Client) bool {
+	if r.Ops[cl.Nick()] {
+		return true
+	}
+	a := cl.Account()
+	return a != "" && r.Ops[a]
+}
+
+// moderatedBy returns true if the client owns the room or is an op
+func (r *Room) moderatedBy(cl *Client) bool {
+	return r.ownedBy(cl) || r.opped(cl)
+}
+
+// SetPublic flags the client's room as public or private, only the owner
+// of the room may change it
+func (s *Server) SetPublic(cl *Client, public bool) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	if !r.ownedBy(cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room can change it\r\n")
+	}
+
+	cmd := raftCommand{Op: raftRoom, Room: r.Name, Public: public, PublicSince: r.PublicSince, Fields: []string{"public"}}
+	// the history from while the room was private stays private
+	if public && !r.Public {
+		cmd.PublicSince = s.lastID
+	}
+	s.mu.Unlock()
+
+	if err := s.change(cmd); err != nil {
+		return err
+	}
+	errl(nil, fmt.Sprintf("Room [%s] public set to %t by [%s]", r.Name, public, cl.Nick()))
+	return nil
+}
+
+// Topic returns the topic of the client's room
+func (s *Server) Topic(cl *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return "", err
+	}
+	return r.Topic, nil
+}
+
+// SetTopic sets the topic of the client's room and tells its members,
+// only the owner of the room, its ops, and moderators may change it
+func (s *Server) SetTopic(cl *Client, topic string) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	if !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can change the topic\r\n")
+	}
+	s.mu.Unlock()
+
+	if err := s.change(raftCommand{Op: raftRoom, Room: r.Name, Topic: topic, Fields: []string{"topic"}}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range r.Clients {
+		c.Write(fmt.Sprintf("[%s] set the topic to [%s]\r\n", cl.Nick(), topic))
+	}
+	return nil
+}
+
+// RoomInfo describes a room, the client's own when name is empty, when it
+// was made, who owns it, its topic, how many are in it and have been at
+// most, and how many messages were sent to it
+func (s *Server) RoomInfo(cl *Client, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var r *Room
+	if name == "" {
+		var err error
+		if r, err = s.findRoom(cl); err != nil {
+			return "", err
+		}
+	} else {
+		var ok bool
+		if r, ok = s.Rooms[roomKey(name)]; !ok {
+			return "", fmt.Errorf("room [%s] does not exist\r\n", name)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Room [%s] was created %s", r.Display, r.Created.Format(time.RFC1123))
+	if r.Owner != "" {
+		fmt.Fprintf(&b, " by [%s]", r.Owner)
+	}
+	b.WriteString("\r\n")
+	if r.Topic != "" {
+		fmt.Fprintf(&b, "Topic: %s\r\n", r.Topic)
+	}
+	fmt.Fprintf(&b, "Members: %d now, %d at most\r\n", len(r.Clients), r.Peak)
+	fmt.Fprintf(&b, "Messages: %d\r\n", r.Messages)
+	return b.String(), nil
+}
+
+// RoomSummary describes the client's room to someone joining it, its
+// topic, how many members it has, who they are, and its message of the day
+func (s *Server) RoomSummary(cl *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return "", err
+	}
+
+	var nicks []string
+	for nick := range r.Clients {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	more := ""
+	if len(nicks) > maxSummaryNicks {
+		more = fmt.Sprintf(" and %d more", len(nicks)-maxSummaryNicks)
+		nicks = nicks[:maxSummaryNicks]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Joined room [%s]", r.Display)
+	if r.Topic != "" {
+		fmt.Fprintf(&b, ", the topic is [%s]", r.Topic)
+	}
+	fmt.Fprintf(&b, ", %d member(s): %s%s\r\n", len(r.Clients), strings.Join(nicks, ", "), more)
+	if r.MOTD != "" {
+		fmt.Fprintf(&b, "Message of the day: %s\r\n", r.MOTD)
+	}
+	return b.String(), nil
+}
+
+// SetMOTD sets the message of the day of the client's room, shown to
+// everyone who joins it, an empty text clears it, only the owner of the
+// room may set it
+func (s *Server) SetMOTD(cl *Client, text string) error {
+	if utf8.RuneCountInString(text) > maxMOTD {
+		return fmt.Errorf("a message of the day can be at most %d characters\r\n", maxMOTD)
+	}
+
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if !r.ownedBy(cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room can change it\r\n")
+	}
+	s.mu.Unlock()
+
+	if err := s.change(raftCommand{Op: raftRoom, Room: r.Name, MOTD: text, Fields: []string{"motd"}}); err != nil {
+		return err
+	}
+	errl(nil, fmt.Sprintf("Room [%s] message of the day set by [%s]", r.Name, cl.Nick()))
+	return nil
+}
+
+// RoomOf returns the name of the room the client is in
+func (s *Server) RoomOf(cl *Client) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, err := s.findRoom(cl); err == nil {
+		return r.Name
+	}
+	return ""
+}
+
+// PublicHistory returns a copy of the history of a public room since it
+// went public
+func (s *Server) PublicHistory(roomname string) ([]Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Rooms[roomKey(roomname)]
+	if !ok || !r.Public {
+		return nil, false
+	}
+	out := []Event{}
+	for _, ev := range r.History {
+		if r.shownPublicly(ev.ID) {
+			out = append(out, ev)
+		}
+	}
+	return out, true
+}
+
+// shownPublicly returns true if the event of the room may be shown to
+// those outside it, it must be called with the server lock held
+func (r *Room) shownPublicly(id int64) bool {
+	return r.Public && id > r.PublicSince
+}
+
+// PublicRooms returns the names of the public rooms
+func (s *Server) PublicRooms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []string
+	for name, r := range s.Rooms {
+		if r.Public {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// banned returns true if the client may not join the room
+func (r *Room) banned(cl *Client) bool {
+	if r.Bans[cl.Nick()] {
+		return true
+	}
+	a := cl.Account()
+	return a != "" && r.Bans[a]
+}
+
+// Ban keeps nick out of the client's room, if nick is in the room it is
+// moved to the default room, only the owner of the room, its ops, and
+// moderators may ban, and nobody can ban the owner
+func (s *Server) Ban(cl *Client, nick string) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	if r.Name == DefaultRoom || !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can ban\r\n")
+	}
+
+	if nick == r.Owner {
+		s.mu.Unlock()
+		return errors.New("the owner of the room can't be banned\r\n")
+	}
+	s.mu.Unlock()
+
+	return s.ban(r, nick, cl.Nick())
+}
+
+// ban bans nick from the room and moves it out if it's there, it must not
+// be called with the server lock held
+func (s *Server) ban(r *Room, nick, by string) error {
+	if err := s.change(raftCommand{Op: raftBan, Room: r.Name, Nick: nick}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logMod(by, "ban", nick, r.Name)
+	errl(nil, fmt.Sprintf("[%s] banned from room [%s] by [%s]", nick, r.Name, by))
+
+	if c, ok := r.Clients[nick]; ok {
+		s.tryDeleteFromRoom(c)
+		s.joinRoom(DefaultRoom, c)
+		s.emit(Event{
+			Type: EventJoin,
+			Time: time.Now().Format(time.RFC3339),
+			From: c.Nick(),
+			Room: DefaultRoom,
+		})
+		c.Write(fmt.Sprintf("You were banned from room [%s]\r\n", r.Name))
+	}
+	return nil
+}
+
+// Unban lets nick join the client's room again
+func (s *Server) Unban(cl *Client, nick string) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	if !s.moderates(r, cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room, its ops, and moderators can unban\r\n")
+	}
+	s.mu.Unlock()
+
+	return s.unban(r, nick, cl.Nick())
+}
+
+// unban lets nick back into the room, it must not be called with the
+// server lock held
+func (s *Server) unban(r *Room, nick, by string) error {
+	s.mu.Lock()
+	banned := r.Bans[nick]
+	s.mu.Unlock()
+	if !banned {
+		return fmt.Errorf("[%s] is not banned\r\n", nick)
+	}
+
+	if err := s.change(raftCommand{Op: raftUnban, Room: r.Name, Nick: nick}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logMod(by, "unban", nick, r.Name)
+	return nil
+}
+
+// Op lets nick set the topic, ban, and unban in the client's room, only the
+// owner of the room may op
+func (s *Server) Op(cl *Client, nick string) error {
+	return s.setOp(cl, nick, true)
+}
+
+// Deop takes back what Op gave
+func (s *Server) Deop(cl *Client, nick string) error {
+	return s.setOp(cl, nick, false)
+}
+
+func (s *Server) setOp(cl *Client, nick string, op bool) error {
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	if r.Name == DefaultRoom || !r.ownedBy(cl) {
+		s.mu.Unlock()
+		return errors.New("only the owner of the room can change its ops\r\n")
+	}
+
+	if r.Ops[nick] == op {
+		s.mu.Unlock()
+		if op {
+			return fmt.Errorf("[%s] is already an op\r\n", nick)
+		}
+		return fmt.Errorf("[%s] is not an op\r\n", nick)
+	}
+	s.mu.Unlock()
+
+	cmd := raftCommand{Op: raftOp, Room: r.Name, Nick: nick}
+	if !op {
+		cmd.Op = raftDeop
+	}
+	if err := s.change(cmd); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if op {
+		s.logMod(cl.Nick(), "op", nick, r.Name)
+	} else {
+		s.logMod(cl.Nick(), "deop", nick, r.Name)
+	}
+	errl(nil, fmt.Sprintf("[%s] %s in room [%s] by [%s]", nick, map[bool]string{true: "opped", false: "deopped"}[op], r.Name, cl.Nick()))
+
+	if c, ok := r.Clients[nick]; ok {
+		if op {
+			c.Write(fmt.Sprintf("You are now an op of room [%s]\r\n", r.Name))
+		} else {
+			c.Write(fmt.Sprintf("You are no longer an op of room [%s]\r\n", r.Name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "journal.log")
+
+	serv := NewServer()
+	serv.Journal, err = OpenJournal(serv, file, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	batman := &Client{nick: "batman"}
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+	serv.SetTopic(batman, "the dark knight")
+	serv.Message([]string{"hello"}, batman)
+	serv.Message([]string{"holy", "smokes"}, robin)
+	serv.JoinRoom("metropolis", robin)
+
+	out, err := serv.RoomInfo(robin, "gotham")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for _, want := range []string{"Room [gotham] was created", "by [batman]", "Topic: the dark knight", "Members: 1 now, 2 at most", "Messages: 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected [%s] in %q", want, out)
+		}
+	}
+	if out, _ := serv.RoomInfo(robin, ""); !strings.Contains(out, "Room [metropolis]") {
+		t.Errorf("expected the client's own room, got %q", out)
+	}
+	if _, err := serv.RoomInfo(robin, "arkham"); err == nil {
+		t.Errorf("expected an unknown room refused")
+	}
+
+	// the counters survive a rotation without counting the history twice
+	created := serv.Rooms["gotham"].Created
+	if _, err := serv.Journal.Rotate(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	replica := NewServer()
+	if _, err := OpenJournal(replica, file, nil); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	r := replica.Rooms["gotham"]
+	if r.Peak != 2 || r.Messages != 2 || !r.Created.Equal(created.Truncate(time.Second)) {
+		t.Errorf("expected the counters replayed, got peak %d, %d message(s), created %s", r.Peak, r.Messages, r.Created)
+	}
+}
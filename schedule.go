@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseScheduleAt parses when to send a scheduled message, a duration such
+// as 10m or 1d, a time of day such as 18:30 in UTC, the next one to come, or
+// an RFC 3339 timestamp
+func parseScheduleAt(s string, now time.Time) (time.Time, error) {
+	if d, err := parseRemindIn(s); err == nil {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse("15:04", s); err == nil {
+		now = now.UTC()
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+		if !at.After(now) {
+			at = at.Add(24 * time.Hour)
+		}
+		return at, nil
+	}
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("[%s] is not a time, try 10m, 18:30, or 2024-12-24T18:00:00Z\r\n", s)
+	}
+	if !at.After(now) {
+		return time.Time{}, errors.New("scheduled messages must be sent in the future\r\n")
+	}
+	if at.Sub(now) > maxRemindIn {
+		return time.Time{}, errors.New("messages can be scheduled at most a year ahead\r\n")
+	}
+	return at, nil
+}
+
+// Schedule queues text to be sent to the client's room as the client at
+func (rs *Reminders) Schedule(cl *Client, at time.Time, text string) (*Reminder, error) {
+	r := &Reminder{
+		Nick:    cl.Nick(),
+		Account: cl.Account(),
+		Room:    rs.server.RoomOf(cl),
+		Text:    text,
+		Due:     at,
+		Send:    true,
+	}
+	if r.Room == "" {
+		return nil, errors.New("you are not in a room\r\n")
+	}
+	if err := rs.add(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// scheduledBy returns true if the client queued the scheduled message
+func scheduledBy(r *Reminder, cl *Client) bool {
+	if !r.Send {
+		return false
+	}
+	if a := cl.Account(); a != "" && r.Account == a {
+		return true
+	}
+	return r.Account == "" && r.Nick == cl.Nick()
+}
+
+// Scheduled returns the client's pending scheduled messages, soonest first
+func (rs *Reminders) Scheduled(cl *Client) []Reminder {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var out []Reminder
+	for _, r := range rs.list {
+		if scheduledBy(r, cl) {
+			out = append(out, *r)
+		}
+	}
+	return out
+}
+
+// Cancel drops one of the client's pending scheduled messages
+func (rs *Reminders) Cancel(cl *Client, id int64) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for i, r := range rs.list {
+		if r.ID == id && scheduledBy(r, cl) {
+			rs.list = append(rs.list[:i:i], rs.list[i+1:]...)
+			if err := rs.save(); err != nil {
+				errl(err, "")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("you have no scheduled message [%d]\r\n", id)
+}
+
+// sendScheduled sends a scheduled message to its room as the user who
+// queued it, unless they have been muted since
+func (s *Server) sendScheduled(r *Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Muted[r.Nick] || (r.Account != "" && s.Muted[r.Account]) {
+		return fmt.Errorf("[%s] is muted", r.Nick)
+	}
+	ev := Event{
+		Type: EventMessage,
+		Time: time.Now().Format(time.RFC3339),
+		From: r.Nick,
+		Room: r.Room,
+		Text: r.Text,
+	}
+	if err := s.deliver(ev); err != nil {
+		return err
+	}
+	s.notifyMentions(ev)
+	return nil
+}
+
+// scheduleCommand runs /schedule
+func (s *Server) scheduleCommand(in *Input) {
+	at, err := parseScheduleAt(in.Args[1], time.Now())
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	r, err := s.Reminders.Schedule(in.Client, at, strings.Join(in.Args[2:], " "))
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	in.Client.Write(fmt.Sprintf("Message [%d] scheduled for %s\r\n", r.ID, r.Due.Format(time.RFC1123)))
+}
+
+// scheduledCommand runs /scheduled
+func (s *Server) scheduledCommand(in *Input) {
+	if len(in.Args) > 1 {
+		if in.Args[1] != "cancel" || len(in.Args) < 3 {
+			in.Client.Write("Unable to manage scheduled messages, use /scheduled or /scheduled cancel <id>\r\n")
+			return
+		}
+		id, err := strconv.ParseInt(in.Args[2], 10, 64)
+		if err != nil {
+			in.Client.Write(fmt.Sprintf("[%s] is not a scheduled message\r\n", in.Args[2]))
+			return
+		}
+		reply(in.Client, fmt.Sprintf("Scheduled message [%d] cancelled\r\n", id), s.Reminders.Cancel(in.Client, id))
+		return
+	}
+
+	list := s.Reminders.Scheduled(in.Client)
+	if len(list) == 0 {
+		in.Client.Write("You have no scheduled messages\r\n")
+		return
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("You have %d scheduled message(s):\r\n", len(list)))
+	for _, r := range list {
+		b.WriteString(fmt.Sprintf("[%d] %s to [%s]: %s\r\n", r.ID, r.Due.Format(time.RFC1123), r.Room, r.Text))
+	}
+	in.Client.Write(b.String())
+}
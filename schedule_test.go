@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleAt(t *testing.T) {
+	now := time.Date(2024, 12, 24, 20, 0, 0, 0, time.UTC)
+	for in, want := range map[string]time.Time{
+		"10m":                  now.Add(10 * time.Minute),
+		"21:15":                time.Date(2024, 12, 24, 21, 15, 0, 0, time.UTC),
+		"08:00":                time.Date(2024, 12, 25, 8, 0, 0, 0, time.UTC),
+		"2024-12-31T23:59:00Z": time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC),
+	} {
+		if got, err := parseScheduleAt(in, now); err != nil || !got.Equal(want) {
+			t.Errorf("expected %s for [%s], got %s %v", want, in, got, err)
+		}
+	}
+	for _, in := range []string{"soon", "2020-01-01T00:00:00Z", "2030-01-01T00:00:00Z"} {
+		if _, err := parseScheduleAt(in, now); err == nil {
+			t.Errorf("expected [%s] to be refused", in)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	serv := NewServer()
+	serv.Reminders = NewReminders(serv, "")
+	batman, robin := &Client{nick: "batman"}, &Client{nick: "robin"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+
+	first, err := serv.Reminders.Schedule(batman, time.Now().Add(time.Minute), "patrol starts")
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	second, _ := serv.Reminders.Schedule(batman, time.Now().Add(time.Hour), "dinner at the manor")
+	if list := serv.Reminders.Scheduled(robin); len(list) != 0 {
+		t.Errorf("expected robin to have nothing scheduled, got %v", list)
+	}
+	if err := serv.Reminders.Cancel(robin, second.ID); err == nil {
+		t.Errorf("expected robin to be unable to cancel batman's message")
+	}
+	if err := serv.Reminders.Cancel(batman, second.ID); err != nil {
+		t.Errorf("expected error to be nil, got %v", err)
+	}
+	if list := serv.Reminders.Scheduled(batman); len(list) != 1 || list[0].ID != first.ID {
+		t.Errorf("expected one scheduled message, got %v", list)
+	}
+
+	serv.Reminders.Fire(time.Now().Add(2 * time.Hour))
+	ev := robin.unread[len(robin.unread)-1]
+	if ev.Type != EventMessage || ev.From != "batman" || ev.Text != "patrol starts" || ev.ID == 0 {
+		t.Errorf("expected the message sent as batman, got %+v", ev)
+	}
+	if len(serv.Reminders.list) != 0 {
+		t.Errorf("expected nothing left scheduled, got %v", serv.Reminders.list)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// script is a loaded Lua script and the handlers it registered
+type script struct {
+	mu           sync.Mutex
+	name         string
+	L            *luaState
+	onConnect    []interface{}
+	onMessage    []interface{}
+	onDisconnect []interface{}
+	commands     map[string]interface{}
+}
+
+// Scripts runs the Lua scripts of a directory as a plugin, each script has
+// its own interpreter and reacts to the server through the chat table:
+//
+//	chat.on_connect(function(nick) end)
+//	chat.on_message(function(nick, room, text) return text end)
+//	chat.on_disconnect(function(nick) end)
+//	chat.command("name", function(nick, args) return "reply" end, "help")
+//	chat.say(room, text)
+//
+// An on_message handler returns a string to rewrite the message, false to
+// drop it, or nothing to leave it alone
+type Scripts struct {
+	Server *Server
+	list   []*script
+}
+
+// LoadScripts loads every .lua file in dir in name order
+func LoadScripts(s *Server, dir string) (*Scripts, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	sc := &Scripts{Server: s}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(f), ".lua")
+		if err := sc.Load(name, string(b)); err != nil {
+			return nil, fmt.Errorf("script [%s]: %v", f, err)
+		}
+		errl(nil, fmt.Sprintf("Loaded script [%s]", f))
+	}
+	return sc, nil
+}
+
+// Load runs the source of a script named name
+func (sc *Scripts) Load(name, src string) error {
+	st := &script{name: name, L: newLuaState(), commands: make(map[string]interface{})}
+	st.L.Globals.Set("chat", sc.api(st))
+	st.L.Register("print", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		var parts []string
+		for _, a := range args {
+			parts = append(parts, luaToString(a))
+		}
+		errl(nil, fmt.Sprintf("script [%s]: %s", name, strings.Join(parts, "\t")))
+		return nil, nil
+	})
+
+	if err := st.L.DoString(src); err != nil {
+		return err
+	}
+	sc.list = append(sc.list, st)
+	return nil
+}
+
+// api returns the chat table of a script
+func (sc *Scripts) api(st *script) *luaTable {
+	t := newLuaTable()
+	on := func(name string, list *[]interface{}) {
+		st.L.lib(t, name, func(L *luaState, args []interface{}) ([]interface{}, error) {
+			if luaType(luaArg(args, 0)) != "function" {
+				return nil, fmt.Errorf("bad argument #1 to '%s' (function expected)", name)
+			}
+			*list = append(*list, args[0])
+			return nil, nil
+		})
+	}
+	on("on_connect", &st.onConnect)
+	on("on_message", &st.onMessage)
+	on("on_disconnect", &st.onDisconnect)
+
+	st.L.lib(t, "command", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		name, err := luaCheckString("command", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		if luaType(luaArg(args, 1)) != "function" {
+			return nil, fmt.Errorf("bad argument #2 to 'command' (function expected)")
+		}
+		name = "/" + strings.TrimPrefix(name, "/")
+		st.commands[name] = args[1]
+		if sc.Server == nil {
+			return nil, nil
+		}
+
+		// the command is listed by /help, its handler runs on the
+		// goroutine of the client, not while the script is loading
+		help, _ := luaArg(args, 2).(string)
+		if help == "" {
+			help = fmt.Sprintf("added by the %s script", st.name)
+		}
+		fn := args[1]
+		return nil, sc.Server.Commands.Register(&Command{
+			Name: name,
+			Args: "[args]",
+			Help: help,
+			Run: func(in *Input) {
+				out := st.command(fn, in.Client.Nick(), in.Args)
+				if out != "" {
+					in.Client.Write(out + "\r\n")
+				}
+			},
+		})
+	})
+
+	// say is delivered once the hook that called it has returned, so a
+	// reply follows the message it answers
+	st.L.lib(t, "say", func(L *luaState, args []interface{}) ([]interface{}, error) {
+		room, err := luaCheckString("say", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		text, err := luaCheckString("say", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		ev := Event{
+			Type: EventService,
+			Time: time.Now().Format(time.RFC3339),
+			From: st.name,
+			Room: room,
+			Text: text,
+		}
+		go func() {
+			errl(sc.Server.Deliver(ev), "")
+		}()
+		return nil, nil
+	})
+	return t
+}
+
+// call runs a handler, errors are logged and give no results
+func (st *script) call(fn interface{}, args ...interface{}) []interface{} {
+	vals, err := st.L.Call(fn, args)
+	if err != nil {
+		errl(fmt.Errorf("script [%s]: %v", st.name, err), "")
+		return nil
+	}
+	return vals
+}
+
+// Connect runs the on_connect handlers
+func (sc *Scripts) Connect(nick string) {
+	for _, st := range sc.list {
+		st.each(st.onConnect, nick)
+	}
+}
+
+// each runs handlers one after another with the same args
+func (st *script) each(fns []interface{}, args ...interface{}) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, fn := range fns {
+		st.call(fn, args...)
+	}
+}
+
+// Message runs the on_message handlers, each sees the text as rewritten
+// by the ones before it
+func (sc *Scripts) Message(nick, room, text string) (string, bool) {
+	for _, st := range sc.list {
+		var ok bool
+		if text, ok = st.message(nick, room, text); !ok {
+			return "", false
+		}
+	}
+	return text, true
+}
+
+// message runs the on_message handlers of the script
+func (st *script) message(nick, room, text string) (string, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, fn := range st.onMessage {
+		vals := st.call(fn, nick, room, text)
+		if len(vals) == 0 {
+			continue
+		}
+		switch v := vals[0].(type) {
+		case bool:
+			if !v {
+				return "", false
+			}
+		case string:
+			text = v
+		}
+	}
+	return text, true
+}
+
+// Command runs the handler a script registered for the command
+func (sc *Scripts) Command(nick string, args []string) (string, bool) {
+	for _, st := range sc.list {
+		st.mu.Lock()
+		fn, ok := st.commands[args[0]]
+		st.mu.Unlock()
+		if ok {
+			return st.command(fn, nick, args), true
+		}
+	}
+	return "", false
+}
+
+// command runs a command handler and returns its reply
+func (st *script) command(fn interface{}, nick string, args []string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t := newLuaTable()
+	for i, a := range args[1:] {
+		t.Set(float64(i+1), a)
+	}
+	vals := st.call(fn, nick, t)
+	if len(vals) > 0 && vals[0] != nil {
+		return luaToString(vals[0])
+	}
+	return ""
+}
+
+// Disconnect runs the on_disconnect handlers
+func (sc *Scripts) Disconnect(nick string) {
+	for _, st := range sc.list {
+		st.each(st.onDisconnect, nick)
+	}
+}
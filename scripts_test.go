@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const responder = `
+local greeted = {}
+
+chat.on_connect(function(nick)
+	greeted[nick] = true
+end)
+
+chat.on_message(function(nick, room, text)
+	if text:lower():find("joker") then
+		return false
+	end
+	if text == "ping" then
+		chat.say(room, "pong " .. nick)
+	end
+	return text
+end)
+
+chat.command("pick", function(nick, args)
+	return nick .. " picked " .. (args[1] or "6")
+end)
+
+chat.command("greeted", function(nick, args)
+	return tostring(greeted[args[1]] == true)
+end)
+`
+
+func TestScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "responder.lua"), []byte(responder), 0600)
+	ioutil.WriteFile(path.Join(dir, "shout.lua"), []byte(`chat.on_message(function(nick, room, text) return text .. "!" end)`), 0600)
+
+	serv := NewServer()
+	sc, err := LoadScripts(serv, dir)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	serv.Plugins = NewPlugins(sc)
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.Plugins.Connect("batman")
+
+	say(serv, batman, []string{"the", "Joker", "is", "loose"})
+	say(serv, batman, []string{"ping"})
+
+	waitFor(t, "reply to be delivered", func() bool {
+		serv.mu.Lock()
+		defer serv.mu.Unlock()
+		return len(serv.Rooms["gotham"].History) == 2
+	})
+	serv.mu.Lock()
+	history := serv.Rooms["gotham"].History
+	serv.mu.Unlock()
+	var texts []string
+	for _, ev := range history {
+		texts = append(texts, ev.From+":"+ev.Text)
+	}
+	if !(texts[0] == "batman:ping!" && texts[1] == "responder:pong batman" || texts[1] == "batman:ping!" && texts[0] == "responder:pong batman") {
+		t.Errorf("unexpected history %v", texts)
+	}
+
+	if out, ok := serv.Plugins.Command("batman", []string{"/pick", "20"}); !ok || out != "batman picked 20\r\n" {
+		t.Errorf("expected pick, got %q", out)
+	}
+	if out, _ := serv.Plugins.Command("batman", []string{"/greeted", "batman"}); out != "true\r\n" {
+		t.Errorf("expected connect handler to run, got %q", out)
+	}
+	if c, ok := serv.Commands.Lookup("/pick"); !ok || c.Help != "added by the responder script" {
+		t.Errorf("expected /pick to be registered")
+	}
+	if _, ok := serv.Plugins.Command("batman", []string{"/unknown"}); ok {
+		t.Errorf("expected unknown command not to be handled")
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	serv := NewServer()
+	sc := &Scripts{Server: serv}
+	if err := sc.Load("broken", "chat.on_message("); err == nil {
+		t.Errorf("expected syntax error")
+	}
+
+	// a failing handler leaves the message alone
+	if err := sc.Load("failing", `chat.on_message(function() error("oops") end)`); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if text, ok := sc.Message("batman", "gotham", "hello"); !ok || text != "hello" {
+		t.Errorf("expected message to pass through, got %q", text)
+	}
+
+	// a handler given numbers that aren't finite fails without locking the script
+	if err := sc.Load("nan", `chat.on_message(function(n, r, text) return string.rep(text, 0/0) end)`); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if text, ok := sc.Message("batman", "gotham", "hello"); !ok || text != "hello" {
+			t.Errorf("expected message to pass through, got %q", text)
+		}
+	}
+}
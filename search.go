@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// search result limits, how many a page holds unless asked for fewer and
+// at most
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// SearchToken lets a tool search the history of rooms, every room when
+// Rooms is nil
+type SearchToken struct {
+	Name  string
+	Token string
+	Rooms map[string]bool
+}
+
+// parseSearchTokens parses a comma separated list of name:token or
+// name:token:rooms entries, the rooms separated by |
+func parseSearchTokens(s string) []SearchToken {
+	var out []SearchToken
+	for _, v := range splitList(s) {
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			errl(fmt.Errorf("ignoring malformed search token for [%s]", parts[0]), "")
+			continue
+		}
+		t := SearchToken{Name: parts[0], Token: parts[1]}
+		if len(parts) == 3 {
+			t.Rooms = make(map[string]bool)
+			for _, room := range strings.Split(parts[2], "|") {
+				if room = strings.TrimSpace(room); room != "" {
+					t.Rooms[roomKey(room)] = true
+				}
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// searchToken returns the search token of a bearer token, or false if the
+// token isn't configured
+func (s *Server) searchToken(r *http.Request) (SearchToken, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return SearchToken{}, false
+	}
+	for _, t := range s.SearchTokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t, true
+		}
+	}
+	return SearchToken{}, false
+}
+
+// SearchQuery selects messages from the history of rooms, every field left
+// empty matches anything, After pages past the message with that id
+type SearchQuery struct {
+	Rooms []string
+	From  string
+	Text  string
+	Since time.Time
+	Until time.Time
+	After int64
+	Limit int
+}
+
+// matches returns true if the event is a message the query selects
+func (q SearchQuery) matches(ev Event) bool {
+	if ev.Type != EventMessage && ev.Type != EventService && ev.Type != EventNotice {
+		return false
+	}
+	if ev.ID <= q.After || (q.From != "" && !strings.EqualFold(ev.From, q.From)) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(ev.Text), strings.ToLower(q.Text)) {
+		return false
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339, ev.Time)
+		if err != nil || t.Before(q.Since) || (!q.Until.IsZero() && t.After(q.Until)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the messages the query selects from the history of the
+// rooms the token may search, oldest first, and whether there are more
+func (s *Server) Search(t SearchToken, q SearchQuery) ([]Event, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms := make(map[string]bool)
+	for _, name := range q.Rooms {
+		key := roomKey(name)
+		if t.Rooms != nil && !t.Rooms[key] {
+			return nil, false, fmt.Errorf("the token may not search room [%s]", name)
+		}
+		rooms[key] = true
+	}
+	if len(rooms) == 0 {
+		for name := range s.Rooms {
+			if t.Rooms == nil || t.Rooms[name] {
+				rooms[name] = true
+			}
+		}
+	}
+
+	var out []Event
+	for name := range rooms {
+		r, ok := s.Rooms[name]
+		if !ok {
+			continue
+		}
+		for _, ev := range r.History {
+			if q.matches(ev) {
+				out = append(out, ev)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if len(out) > q.Limit {
+		return out[:q.Limit], true, nil
+	}
+	return out, false, nil
+}
+
+// searchResponse is the body the search endpoint answers with, Next is
+// the after to ask for the next page with
+type searchResponse struct {
+	Results []Event `json:"results"`
+	Next    int64   `json:"next,omitempty"`
+}
+
+// parseSearchQuery reads the query of a search request, ?room may be given
+// more than once, ?since and ?until are RFC 3339 times
+func parseSearchQuery(r *http.Request) (SearchQuery, error) {
+	v := r.URL.Query()
+	q := SearchQuery{Rooms: v["room"], From: v.Get("from"), Text: v.Get("q"), Limit: defaultSearchLimit}
+	var err error
+	if since := v.Get("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return q, fmt.Errorf("since is not an RFC 3339 time")
+		}
+	}
+	if until := v.Get("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return q, fmt.Errorf("until is not an RFC 3339 time")
+		}
+	}
+	if after := v.Get("after"); after != "" {
+		if q.After, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return q, fmt.Errorf("after is not a message id")
+		}
+	}
+	if limit := v.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 || q.Limit > maxSearchLimit {
+			return q, fmt.Errorf("limit must be from 1 to %d", maxSearchLimit)
+		}
+	}
+	return q, nil
+}
+
+// handleSearch answers a token authenticated search of room history with
+// the messages found as JSON, every search is logged
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, ok := s.searchToken(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, more, err := s.Search(t, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	errl(nil, fmt.Sprintf("Search by [%s] for %s, %d result(s)", t.Name, r.URL.RawQuery, len(results)))
+
+	resp := searchResponse{Results: results}
+	if resp.Results == nil {
+		resp.Results = []Event{}
+	}
+	if more {
+		resp.Next = results[len(results)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		errl(err, "")
+	}
+}
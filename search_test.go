@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tokens := parseSearchTokens("compliance:abc123,ops:def456:Gotham|arkham,broken")
+	if len(tokens) != 2 || tokens[0].Rooms != nil {
+		t.Fatalf("expected 2 tokens, the first for every room, got %+v", tokens)
+	}
+	if r := tokens[1].Rooms; len(r) != 2 || !r["gotham"] || !r["arkham"] {
+		t.Errorf("expected the rooms of the second token, got %v", r)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	serv := NewServer()
+	serv.SearchTokens = parseSearchTokens("compliance:abc123,ops:def456:gotham")
+	batman := &Client{nick: "batman"}
+	joker := &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("arkham", joker)
+	serv.Message([]string{"meet", "at", "the", "bank"}, batman)
+	serv.Message([]string{"rob", "the", "BANK"}, joker)
+	serv.Message([]string{"nothing", "to", "see"}, joker)
+	serv.Rooms["gotham"].History[0].Time = "2020-01-01T00:00:00Z"
+
+	search := func(token, query string) (int, searchResponse) {
+		req := httptest.NewRequest("GET", "/search?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		serv.HTTPHandler().ServeHTTP(w, req)
+		var resp searchResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	if code, _ := search("wrong", "q=bank"); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %d", code)
+	}
+	if code, resp := search("abc123", "q=bank"); code != http.StatusOK || len(resp.Results) != 2 || resp.Results[0].From != "batman" {
+		t.Errorf("expected both rooms searched, got %d %+v", code, resp)
+	}
+	if _, resp := search("def456", "q=bank"); len(resp.Results) != 1 || resp.Results[0].Room != "gotham" {
+		t.Errorf("expected only gotham searched, got %+v", resp)
+	}
+	if code, _ := search("def456", "room=arkham"); code != http.StatusForbidden {
+		t.Errorf("expected a room out of scope forbidden, got %d", code)
+	}
+	if _, resp := search("abc123", "from=JOKER&room=arkham"); len(resp.Results) != 2 {
+		t.Errorf("expected the sender filtered, got %+v", resp)
+	}
+	since := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if _, resp := search("abc123", "q=bank&since="+since); len(resp.Results) != 1 || resp.Results[0].From != "joker" {
+		t.Errorf("expected older messages left out, got %+v", resp)
+	}
+	if _, resp := search("abc123", "until=2021-01-01T00:00:00Z"); len(resp.Results) != 1 || resp.Results[0].From != "batman" {
+		t.Errorf("expected newer messages left out, got %+v", resp)
+	}
+
+	_, page := search("abc123", "limit=2")
+	if len(page.Results) != 2 || page.Next != page.Results[1].ID {
+		t.Fatalf("expected a page of 2 and a next, got %+v", page)
+	}
+	_, rest := search("abc123", "limit=2&after="+strconv.FormatInt(page.Next, 10))
+	if len(rest.Results) != 1 || rest.Next != 0 || rest.Results[0].Text != "nothing to see" {
+		t.Errorf("expected the last page, got %+v", rest)
+	}
+
+	for _, q := range []string{"since=yesterday", "limit=0", "limit=5000", "after=x"} {
+		if code, _ := search("abc123", q); code != http.StatusBadRequest {
+			t.Errorf("expected %s refused, got %d", q, code)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// newToken returns a random hex token suitable for resuming a session
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewSession issues a session token to the client and registers it for resume
+func (s *Server) NewSession(cl *Client) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cl.setToken(token)
+	s.Sessions[token] = cl
+	return token, nil
+}
+
+// Detach removes a closed connection from the client, when the last one is
+// gone its nick and room are held for the resume window, after which the
+// session is expired
+func (s *Server) Detach(cl *Client, conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cl.mu.Lock()
+	conn.sendClose("")
+	cl.Conns = removeConn(cl.Conns, conn)
+	remaining := len(cl.Conns)
+	cl.mu.Unlock()
+
+	// other devices are still connected
+	if remaining > 0 {
+		return
+	}
+
+	// a client that quit or never got a token has nothing to resume
+	token := cl.Token()
+	if _, ok := s.Sessions[token]; !ok || token == "" {
+		s.tryDeleteFromRoom(cl)
+		s.removeClient(cl)
+		return
+	}
+
+	cl.mu.Lock()
+	cl.expire = time.AfterFunc(s.ResumeWindow, func() {
+		s.expireSession(token, cl)
+	})
+	cl.mu.Unlock()
+	s.announce(cl)
+}
+
+// removeConn returns conns without conn
+func removeConn(conns []*Conn, conn *Conn) []*Conn {
+	out := conns[:0]
+	for _, c := range conns {
+		if c != conn {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// expireSession removes a detached session that was never resumed
+func (s *Server) expireSession(token string, cl *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Sessions[token] != cl {
+		return
+	}
+
+	cl.mu.Lock()
+	detached := len(cl.Conns) == 0
+	cl.mu.Unlock()
+	if !detached {
+		return
+	}
+
+	s.tryDeleteFromRoom(cl)
+	s.removeClient(cl)
+	delete(s.Sessions, token)
+	errl(nil, fmt.Sprintf("Session for [%s] expired", cl.Nick()))
+}
+
+// Resume moves the connection of cl onto the session identified by token,
+// cl is discarded and the restored client is returned with its unread
+// messages delivered, sessions handed off by another node are restored
+// onto cl itself
+func (s *Server) Resume(token string, cl *Client) (*Client, error) {
+	s.mu.Lock()
+	_, local := s.Sessions[token]
+	s.mu.Unlock()
+
+	// the session may have been handed off by a drained node
+	if !local {
+		h, ok, err := s.Cluster.TakeHandoff(token)
+		errl(err, "")
+		if ok {
+			return s.resumeHandoff(token, h, cl)
+		}
+	}
+
+	s.mu.Lock()
+
+	old, ok := s.Sessions[token]
+	if !ok || old == cl {
+		s.mu.Unlock()
+		return nil, errors.New("session token is invalid or expired\r\n")
+	}
+
+	unread := s.adopt(old, cl)
+	s.mu.Unlock()
+
+	old.Write(fmt.Sprintf("Session resumed as [%s], %d unread message(s)\r\n", old.Nick(), len(unread)))
+	for _, ev := range unread {
+		old.Send(ev)
+	}
+	return old, nil
+}
+
+// adopt moves the connections of cl onto old and discards cl, it returns
+// the messages old missed while it had no connections
+func (s *Server) adopt(old, cl *Client) []Event {
+	cl.mu.Lock()
+	conns := cl.Conns
+	cl.Conns = nil
+	cl.mu.Unlock()
+
+	// discard the freshly connected client
+	s.tryDeleteFromRoom(cl)
+	s.removeClient(cl)
+	delete(s.Sessions, cl.Token())
+
+	old.mu.Lock()
+	detached := old.expire != nil
+	if detached {
+		old.expire.Stop()
+		old.expire = nil
+	}
+	old.Conns = append(old.Conns, conns...)
+	unread := old.unread
+	old.unread = nil
+	old.mu.Unlock()
+
+	if detached {
+		s.announce(old)
+	}
+	return unread
+}
+
+// ListSessions describes every connection of the client's account, current
+// is marked as the connection asking
+func (s *Server) ListSessions(cl *Client, current *Conn) (string, error) {
+	if cl.Account() == "" {
+		return "", errors.New("you must be logged in to list sessions\r\n")
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	out := fmt.Sprintf("Sessions for [%s]:\r\n", cl.account)
+	for i, c := range cl.Conns {
+		line := fmt.Sprintf("%d) %s connected %s idle %s", i+1, c.RemoteAddr(), c.Connected.Format(time.RFC3339), c.Idle().Truncate(time.Second))
+		if c == current {
+			line = line + " (this session)"
+		}
+		out = out + line + "\r\n"
+	}
+	return out, nil
+}
+
+// Logout closes the numbered connection of the client's account, as shown
+// by ListSessions, the session token is replaced so the closed connection
+// can't resume the session or use it to upload
+func (s *Server) Logout(cl *Client, which string) error {
+	if cl.Account() == "" {
+		return errors.New("you must be logged in to log out sessions\r\n")
+	}
+
+	n, err := strconv.Atoi(which)
+	token, terr := newToken()
+	if terr != nil {
+		return terr
+	}
+
+	s.mu.Lock()
+	cl.mu.Lock()
+	if err != nil || n < 1 || n > len(cl.Conns) {
+		cl.mu.Unlock()
+		s.mu.Unlock()
+		return fmt.Errorf("session [%s] does not exist\r\n", which)
+	}
+	c := cl.Conns[n-1]
+	cl.Conns = removeConn(cl.Conns, c)
+	old := cl.token
+	if old != "" {
+		delete(s.Sessions, old)
+		cl.token = token
+		s.Sessions[token] = cl
+	}
+	cl.mu.Unlock()
+	s.mu.Unlock()
+
+	c.sendClose("This session was logged out from another device\r\n")
+
+	errl(nil, fmt.Sprintf("Session %s of [%s] logged out", logAddr(c.RemoteAddr()), cl.Nick()))
+	cl.Write(fmt.Sprintf("Session [%d] logged out\r\n", n))
+	if old != "" {
+		cl.Write(fmt.Sprintf("Your session token is [%s], use /resume %s to restore this session within %s\r\n", token, token, s.ResumeWindow))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionResume(t *testing.T) {
+	serv := NewServer()
+
+	c1, _ := net.Pipe()
+	cl := &Client{nick: "batman", Conns: []*Conn{NewConn(c1)}}
+	if err := serv.JoinRoom("gotham", cl); err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	token, err := serv.NewSession(cl)
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	serv.Detach(cl, cl.Conns[0])
+	cl.Write("missed message\r\n")
+
+	c2, p2 := net.Pipe()
+	fresh := &Client{nick: "user1", Conns: []*Conn{NewConn(c2)}}
+	serv.JoinRoom(DefaultRoom, fresh)
+
+	out := bufio.NewReader(p2)
+	done := make(chan *Client)
+	go func() {
+		restored, err := serv.Resume(token, fresh)
+		if err != nil {
+			t.Errorf("expected error to be nil")
+		}
+		done <- restored
+	}()
+
+	line, _ := out.ReadString('\n')
+	if !strings.Contains(line, "1 unread") {
+		t.Errorf("expected resume notice, got [%s]", line)
+	}
+
+	line, _ = out.ReadString('\n')
+	if line != "missed message\r\n" {
+		t.Errorf("expected unread message to be delivered, got [%s]", line)
+	}
+
+	restored := <-done
+	if restored != cl || restored.Nick() != "batman" {
+		t.Errorf("expected session for [batman] to be restored")
+	}
+
+	if serv.clientExists("user1") {
+		t.Errorf("expected the fresh client to be discarded")
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	serv := NewServer()
+	serv.ResumeWindow = 10 * time.Millisecond
+
+	c1, _ := net.Pipe()
+	cl := &Client{nick: "batman", Conns: []*Conn{NewConn(c1)}}
+	serv.JoinRoom("gotham", cl)
+	token, _ := serv.NewSession(cl)
+
+	serv.Detach(cl, cl.Conns[0])
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := serv.Resume(token, &Client{nick: "robin"}); err == nil {
+		t.Errorf("expected expired session to fail to resume")
+	}
+
+	serv.mu.Lock()
+	defer serv.mu.Unlock()
+	if serv.clientExists("batman") {
+		t.Errorf("expected expired client to be removed")
+	}
+}
+
+func TestSessionsLogout(t *testing.T) {
+	serv := NewServer()
+
+	c1, p1 := net.Pipe()
+	c2, p2 := net.Pipe()
+	cl := &Client{nick: "batman", Conns: []*Conn{NewConn(c1), NewConn(c2)}}
+	serv.JoinRoom("gotham", cl)
+
+	if _, err := serv.ListSessions(cl, cl.Conns[0]); err == nil {
+		t.Errorf("expected guests to be refused")
+	}
+
+	serv.Register(cl, "hunter2")
+	out, err := serv.ListSessions(cl, cl.Conns[0])
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+
+	if !strings.Contains(out, "1) pipe") || !strings.Contains(out, "2) pipe") || !strings.Contains(out, "(this session)") {
+		t.Errorf("expected both sessions to be listed, got [%s]", out)
+	}
+
+	if err := serv.Logout(cl, "3"); err == nil {
+		t.Errorf("expected unknown session to fail")
+	}
+
+	go bufio.NewReader(p1).ReadString('\n')
+	r2 := bufio.NewReader(p2)
+	go serv.Logout(cl, "2")
+
+	line, _ := r2.ReadString('\n')
+	if !strings.Contains(line, "logged out") {
+		t.Errorf("expected logout notice, got [%s]", line)
+	}
+
+	if _, err := r2.ReadString('\n'); err == nil {
+		t.Errorf("expected the logged out connection to be closed")
+	}
+
+	// the old token no longer resumes the session
+	token, _ := serv.NewSession(cl)
+	c3, p3 := net.Pipe()
+	cl.mu.Lock()
+	cl.Conns = append(cl.Conns, NewConn(c3))
+	cl.mu.Unlock()
+	go io.Copy(ioutil.Discard, p1)
+	go io.Copy(ioutil.Discard, p3)
+	if err := serv.Logout(cl, "2"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if _, ok := serv.Sessions[token]; ok || cl.Token() == token || serv.Sessions[cl.Token()] != cl {
+		t.Errorf("expected the session token replaced")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each node has on the hash ring,
+// more points spread the rooms more evenly
+const ringReplicas = 64
+
+// hashRing assigns every room to one node with consistent hashing, so
+// adding or removing a node only moves the rooms of its neighbours
+type hashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// newHashRing returns a ring of the nodes
+func newHashRing(nodes []string) *hashRing {
+	h := &hashRing{owners: make(map[uint32]string)}
+	for _, n := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			p := ringHash(n + "#" + strconv.Itoa(i))
+			if _, ok := h.owners[p]; !ok {
+				h.owners[p] = n
+				h.points = append(h.points, p)
+			}
+		}
+	}
+	sort.Slice(h.points, func(i, j int) bool { return h.points[i] < h.points[j] })
+	return h
+}
+
+// Owner returns the node owning the room, empty if the ring has no nodes
+func (h *hashRing) Owner(room string) string {
+	if h == nil || len(h.points) == 0 {
+		return ""
+	}
+	p := ringHash(room)
+	i := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= p })
+	if i == len(h.points) {
+		i = 0
+	}
+	return h.owners[h.points[i]]
+}
+
+// ringHash places a key on the ring
+func ringHash(key string) uint32 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := newHashRing([]string{"node-1", "node-2", "node-3"})
+
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		room := fmt.Sprintf("room%d", i)
+		owners[room] = ring.Owner(room)
+		counts[owners[room]]++
+	}
+	for _, n := range []string{"node-1", "node-2", "node-3"} {
+		if counts[n] < 50 {
+			t.Errorf("expected rooms to be spread, %s owns %d", n, counts[n])
+		}
+	}
+
+	// removing a node only moves its own rooms
+	smaller := newHashRing([]string{"node-1", "node-2"})
+	for room, owner := range owners {
+		if owner != "node-3" && smaller.Owner(room) != owner {
+			t.Errorf("expected room [%s] to stay on [%s]", room, owner)
+		}
+	}
+
+	var empty *hashRing
+	if empty.Owner("gotham") != "" {
+		t.Errorf("expected an empty ring to have no owner")
+	}
+}
+
+func TestClusterRoute(t *testing.T) {
+	c := NewCluster(NewServer(), "node-1", "", "")
+	now := time.Now()
+	c.setNodes([]string{"node-2", fmt.Sprint(now.Unix()), "node-3", fmt.Sprint(now.Unix()), "node-4", "0"}, now)
+
+	c.remote["robin"] = remoteUser{via: "node-2", room: "gotham"}
+	c.remote["alfred"] = remoteUser{via: "node-3", room: "gotham"}
+	c.remote["joker"] = remoteUser{via: "node-3", room: "arkham"}
+
+	var owned, other string
+	for i := 0; owned == "" || other == ""; i++ {
+		room := fmt.Sprintf("room%d", i)
+		switch c.ring.Owner(room) {
+		case "node-1":
+			owned = room
+		case "node-4":
+			t.Fatalf("expected stale nodes to be dropped")
+		default:
+			other = room
+		}
+	}
+
+	msgs := c.route(envelope{Node: "node-1", Event: Event{Type: EventMessage, Room: other}})
+	if len(msgs) != 1 || msgs[0].channel != nodeChannel(c.ring.Owner(other)) || msgs[0].env.Route != routeOwner {
+		t.Errorf("expected message to be routed to the owner, got %+v", msgs)
+	}
+
+	for name := range c.remote {
+		u := c.remote[name]
+		if u.room == "gotham" {
+			u.room = owned
+			c.remote[name] = u
+		}
+	}
+	msgs = c.route(envelope{Node: "node-1", Event: Event{Type: EventMessage, Room: owned}})
+	if len(msgs) != 2 || msgs[0].channel != nodeChannel("node-2") || msgs[1].channel != nodeChannel("node-3") {
+		t.Errorf("expected owner to deliver to member nodes only, got %+v", msgs)
+	}
+
+	msgs = c.route(envelope{Node: "node-1", Event: Event{Type: EventDirect, To: "joker"}})
+	if len(msgs) != 1 || msgs[0].channel != nodeChannel("node-3") {
+		t.Errorf("expected direct message to go to the recipient's node, got %+v", msgs)
+	}
+
+	msgs = c.route(envelope{Node: "node-1", Event: Event{Type: EventBlast}})
+	if len(msgs) != 1 || msgs[0].channel != clusterChannel {
+		t.Errorf("expected blast to be broadcast, got %+v", msgs)
+	}
+}
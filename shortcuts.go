@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFavorites is how many favorite rooms an account may keep
+const maxFavorites = 20
+
+// favorites returns the favorite rooms of the client's account by alias
+func (s *Server) favorites(cl *Client) map[string]string {
+	a, _ := s.Accounts.Get(cl.Account())
+	return a.Favorites
+}
+
+// RoomList lists the rooms numbered, the client's favorites first by
+// alias and then the rest by name, the numbers are remembered so /room 3
+// joins the third room of the list the client was last shown
+func (s *Server) RoomList(cl *Client) string {
+	favs := s.favorites(cl)
+	var aliases []string
+	for alias := range favs {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	alias := make(map[string]string)
+	for _, a := range aliases {
+		key := roomKey(favs[a])
+		if _, ok := alias[key]; !ok {
+			names = append(names, key)
+			alias[key] = a
+		}
+	}
+	var rest []string
+	for name := range s.Rooms {
+		if _, ok := alias[name]; !ok {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	names = append(names, rest...)
+
+	cl.mu.Lock()
+	cl.roomList = names
+	cl.mu.Unlock()
+
+	var b strings.Builder
+	for i, name := range names {
+		members, topic := 0, ""
+		if r, ok := s.Rooms[name]; ok {
+			members, topic = len(r.Clients), r.Topic
+		}
+		fmt.Fprintf(&b, "%d. [%s] %d member(s)", i+1, name, members)
+		if a, ok := alias[name]; ok {
+			fmt.Fprintf(&b, ", favorite [%s]", a)
+		}
+		if topic != "" {
+			fmt.Fprintf(&b, ", %s", topic)
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("Join a room with /room <number>\r\n")
+	return b.String()
+}
+
+// shortcut returns the room arg stands for, a number of the room list the
+// client was last shown or the alias of one of its favorites, or arg
+// itself
+func (s *Server) shortcut(cl *Client, arg string) string {
+	if n, err := strconv.Atoi(arg); err == nil {
+		cl.mu.Lock()
+		list := cl.roomList
+		cl.mu.Unlock()
+		if n >= 1 && n <= len(list) {
+			return list[n-1]
+		}
+	}
+	if room, ok := s.favorites(cl)[strings.ToLower(arg)]; ok {
+		return room
+	}
+	return arg
+}
+
+// Favorite keeps room as a favorite of the client's account under alias,
+// an empty room removes the favorite, it returns the favorites as they are
+// now
+// example: /fav add g "gotham city"
+func (s *Server) Favorite(cl *Client, alias, room string) (map[string]string, error) {
+	name := cl.Account()
+	if name == "" {
+		return nil, fmt.Errorf("you must be logged in to keep favorite rooms\r\n")
+	}
+	alias = strings.ToLower(alias)
+	if _, err := strconv.Atoi(alias); err == nil || strings.ContainsAny(alias, " \"'") {
+		return nil, fmt.Errorf("[%s] can't be an alias, use a word that isn't a number\r\n", alias)
+	}
+	if room != "" {
+		if err := validRoom(room); err != nil {
+			return nil, err
+		}
+		room = roomDisplay(room)
+	}
+
+	var favs map[string]string
+	var err error
+	uerr := s.Accounts.Update(name, func(a *Account) {
+		defer func() {
+			favs = make(map[string]string)
+			for k, v := range a.Favorites {
+				favs[k] = v
+			}
+		}()
+		_, ok := a.Favorites[alias]
+		switch {
+		case room == "" && !ok:
+			err = fmt.Errorf("you have no favorite [%s]\r\n", alias)
+		case room == "":
+			delete(a.Favorites, alias)
+		case !ok && len(a.Favorites) >= maxFavorites:
+			err = fmt.Errorf("you can keep up to %d favorite rooms\r\n", maxFavorites)
+		default:
+			if a.Favorites == nil {
+				a.Favorites = make(map[string]string)
+			}
+			a.Favorites[alias] = room
+		}
+	})
+	if uerr != nil {
+		return nil, uerr
+	}
+	return favs, err
+}
+
+// favoriteList describes the favorites
+func favoriteList(favs map[string]string) string {
+	var out []string
+	for alias, room := range favs {
+		out = append(out, fmt.Sprintf("%s=%s", alias, room))
+	}
+	sort.Strings(out)
+	return fmt.Sprintf("Favorite rooms: %s\r\n", listOrNone(out))
+}
+
+// favCommand runs /fav
+func (s *Server) favCommand(in *Input) {
+	if len(in.Args) == 1 {
+		in.Client.Write(favoriteList(s.favorites(in.Client)))
+		return
+	}
+	args, err := in.Quoted(1)
+	if err != nil {
+		in.Client.Write(err.Error())
+		return
+	}
+	var favs map[string]string
+	switch {
+	case strings.EqualFold(args[0], "add") && len(args) == 3:
+		favs, err = s.Favorite(in.Client, args[1], args[2])
+	case strings.EqualFold(args[0], "del") && len(args) == 2:
+		favs, err = s.Favorite(in.Client, args[1], "")
+	default:
+		in.Client.Write("Use /fav add <alias> <room> or /fav del <alias>, quote names with spaces\r\n")
+		return
+	}
+	reply(in.Client, favoriteList(favs), err)
+}
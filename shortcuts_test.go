@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoomShortcuts(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman := &Client{nick: "batman", account: "batman"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("gotham", &Client{nick: "robin"})
+	serv.JoinRoom("wayne manor", &Client{nick: "alfred"})
+	serv.SetTopic(batman, "the dark knight")
+
+	if _, err := serv.Favorite(&Client{nick: "joker"}, "g", "gotham"); err == nil {
+		t.Errorf("expected guests to be unable to keep favorites")
+	}
+	if _, err := serv.Favorite(batman, "3", "gotham"); err == nil {
+		t.Errorf("expected a number refused as an alias")
+	}
+	if _, err := serv.Favorite(batman, "WM", "Wayne  Manor"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	out := serv.RoomList(batman)
+	lines := strings.Split(out, "\r\n")
+	if !strings.HasPrefix(lines[0], "1. [wayne manor] 1 member(s), favorite [wm]") {
+		t.Errorf("expected favorites first, got [%s]", out)
+	}
+	if !strings.Contains(out, "2. [batcave] 1 member(s), the dark knight\r\n") || !strings.Contains(out, "3. [gotham]") {
+		t.Errorf("expected the rest by name, got [%s]", out)
+	}
+
+	if got := serv.shortcut(batman, "3"); got != "gotham" {
+		t.Errorf("expected 3 to be gotham, got %s", got)
+	}
+	if got := serv.shortcut(batman, "wm"); got != "Wayne Manor" {
+		t.Errorf("expected the favorite, got %s", got)
+	}
+	if got := serv.shortcut(batman, "9"); got != "9" {
+		t.Errorf("expected a number out of the list left alone, got %s", got)
+	}
+
+	serv.Dispatch(&Input{Client: batman, Command: "/room", Args: []string{"/room", "3"}})
+	serv.mu.Lock()
+	r, _ := serv.findRoom(batman)
+	serv.mu.Unlock()
+	if r == nil || r.Name != "gotham" {
+		t.Errorf("expected /room 3 to join gotham, got %+v", r)
+	}
+
+	favs, err := serv.Favorite(batman, "wm", "")
+	if err != nil || len(favs) != 0 {
+		t.Errorf("expected the favorite removed, got %v, %v", favs, err)
+	}
+	if _, err := serv.Favorite(batman, "wm", ""); err == nil {
+		t.Errorf("expected an unknown favorite refused")
+	}
+}
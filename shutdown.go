@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownDelay is how long /shutdown and /restart count down
+// when no delay is given
+const DefaultShutdownDelay = time.Minute
+
+// maxShutdownDelay is the longest countdown
+const maxShutdownDelay = time.Hour
+
+// shutdownWarnings are the times left at which clients are warned again
+var shutdownWarnings = []time.Duration{30 * time.Minute, 10 * time.Minute, 5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second, 5 * time.Second}
+
+// maintenanceGap is the pause between closing connections once maintenance
+// begins, so clients don't all reconnect elsewhere at once
+const maintenanceGap = 100 * time.Millisecond
+
+// shutdown is a countdown to stopping or restarting the server, or to
+// maintenance, which closes every connection but keeps the server running
+type shutdown struct {
+	restart     bool
+	maintenance bool
+	cancel      chan struct{}
+}
+
+// verb returns what the server is about to do
+func (sd *shutdown) verb() string {
+	if sd.maintenance {
+		return "going down for maintenance"
+	}
+	if sd.restart {
+		return "restarting"
+	}
+	return "shutting down"
+}
+
+// parseShutdownDelay parses a delay given in seconds or as a duration
+func parseShutdownDelay(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		var n int
+		n, err = strconv.Atoi(s)
+		d = time.Duration(n) * time.Second
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("[%s] is not a delay, try 30 or 5m\r\n", s)
+	}
+	if d > maxShutdownDelay {
+		return 0, errors.New("the countdown can be at most an hour\r\n")
+	}
+	return d, nil
+}
+
+// Shutdown counts down from delay, warning every client, then closes every
+// connection and stops the server, or restarts it, new connections are
+// refused during the countdown
+func (s *Server) Shutdown(by string, delay time.Duration, restart bool) error {
+	return s.begin(by, delay, &shutdown{restart: restart, cancel: make(chan struct{})})
+}
+
+// Maintenance counts down from delay like Shutdown, then closes every
+// connection a little apart, the server keeps running but refuses new
+// connections until the maintenance is cancelled
+func (s *Server) Maintenance(by string, delay time.Duration) error {
+	return s.begin(by, delay, &shutdown{maintenance: true, cancel: make(chan struct{})})
+}
+
+// begin starts the countdown sd, one countdown may run at a time
+func (s *Server) begin(by string, delay time.Duration, sd *shutdown) error {
+	s.mu.Lock()
+	if s.stopping != nil {
+		s.mu.Unlock()
+		return errors.New("the server is already counting down, /shutdown cancel stops it\r\n")
+	}
+	s.stopping = sd
+	s.draining = true
+	s.mu.Unlock()
+
+	errl(nil, fmt.Sprintf("Server %s in %s by [%s]", sd.verb(), delay, by))
+	s.Notice(fmt.Sprintf("The server is %s in %s", sd.verb(), delay))
+	go s.countdown(sd, delay)
+	return nil
+}
+
+// CancelShutdown stops the countdown and accepts connections again
+func (s *Server) CancelShutdown(by string) error {
+	s.mu.Lock()
+	sd := s.stopping
+	if sd == nil {
+		s.mu.Unlock()
+		return errors.New("the server is not counting down\r\n")
+	}
+	close(sd.cancel)
+	s.stopping = nil
+	s.draining = false
+	s.mu.Unlock()
+
+	errl(nil, fmt.Sprintf("Server %s cancelled by [%s]", sd.verb(), by))
+	s.Notice(fmt.Sprintf("The server is no longer %s", sd.verb()))
+	return nil
+}
+
+// countdown warns clients as the deadline nears and stops the server
+// unless cancelled
+func (s *Server) countdown(sd *shutdown, delay time.Duration) {
+	deadline := time.Now().Add(delay)
+
+	for _, w := range append(shutdownWarnings, 0) {
+		if w >= delay {
+			continue
+		}
+		select {
+		case <-time.After(time.Until(deadline.Add(-w))):
+		case <-sd.cancel:
+			return
+		}
+		if w > 0 {
+			s.Notice(fmt.Sprintf("The server is %s in %s", sd.verb(), w))
+		}
+	}
+	s.stop(sd)
+}
+
+// stop closes every connection, saves a snapshot, and exits, for
+// maintenance the connections are closed gradually and the server stays up
+func (s *Server) stop(sd *shutdown) {
+	s.Notice(fmt.Sprintf("The server is %s now", sd.verb()))
+
+	s.mu.Lock()
+	var conns []*Conn
+	for _, cl := range s.Clients {
+		cl.mu.Lock()
+		conns = append(conns, cl.Conns...)
+		cl.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	if sd.maintenance {
+		for i, c := range conns {
+			if i > 0 {
+				select {
+				case <-time.After(maintenanceGap):
+				case <-sd.cancel:
+					return
+				}
+			}
+			c.sendClose("")
+		}
+		errl(nil, fmt.Sprintf("Server down for maintenance, %d connection(s) closed", len(conns)))
+		return
+	}
+
+	for _, c := range conns {
+		c.sendClose("")
+	}
+	// give what is queued writeTimeout to reach the clients before exiting
+	flushed := time.After(writeTimeout)
+	for _, c := range conns {
+		select {
+		case <-c.closed():
+		case <-flushed:
+		}
+	}
+
+	if s.SnapshotPath != "" {
+		if err := s.writeSnapshot(s.SnapshotPath); err != nil {
+			errl(err, "")
+		}
+	}
+	errl(nil, fmt.Sprintf("Server %s, %d connection(s) closed", sd.verb(), len(conns)))
+	s.exit(sd.restart)
+}
+
+// exitProcess exits, or replaces the process with a new copy of itself to
+// restart
+func exitProcess(restart bool) {
+	if restart {
+		exe, err := os.Executable()
+		if err == nil {
+			err = syscall.Exec(exe, os.Args, os.Environ())
+		}
+		errl(fmt.Errorf("unable to restart: %v", err), "")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// maintenanceCommand runs /maintenance
+func (s *Server) maintenanceCommand(in *Input) {
+	if len(in.Args) >= 2 && (in.Args[1] == "cancel" || in.Args[1] == "off") {
+		reply(in.Client, "", s.CancelShutdown(in.Client.Nick()))
+		return
+	}
+	delay := DefaultShutdownDelay
+	if len(in.Args) >= 2 {
+		var err error
+		if delay, err = parseShutdownDelay(in.Args[1]); err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+	}
+	reply(in.Client, "", s.Maintenance(in.Client.Nick(), delay))
+}
+
+// shutdownCommand runs /shutdown and /restart
+func (s *Server) shutdownCommand(in *Input, restart bool) {
+	if len(in.Args) >= 2 && in.Args[1] == "cancel" {
+		reply(in.Client, "", s.CancelShutdown(in.Client.Nick()))
+		return
+	}
+	delay := DefaultShutdownDelay
+	if len(in.Args) >= 2 {
+		var err error
+		if delay, err = parseShutdownDelay(in.Args[1]); err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+	}
+	reply(in.Client, "", s.Shutdown(in.Client.Nick(), delay, restart))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	serv := NewServer()
+	exited := make(chan bool, 1)
+	serv.exit = func(restart bool) { exited <- restart }
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := c1.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	batman := &Client{nick: "batman", Conns: []*Conn{NewConn(c2)}}
+	serv.JoinRoom("gotham", batman)
+
+	// robin isn't an admin
+	robin := &Client{nick: "robin"}
+	serv.JoinRoom("gotham", robin)
+	serv.Dispatch(&Input{Client: robin, Command: "/shutdown", Args: []string{"/shutdown"}})
+	if serv.Draining() || len(robin.unread) != 1 || robin.unread[0].Text != "only admins can use /shutdown\r\n" {
+		t.Fatalf("expected robin to be refused, got %+v", robin.unread)
+	}
+
+	if err := serv.Shutdown("batman", time.Hour, false); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if !serv.Draining() {
+		t.Errorf("expected new connections to be refused")
+	}
+	if err := serv.Shutdown("batman", time.Hour, false); err == nil {
+		t.Errorf("expected one countdown at a time")
+	}
+	if err := serv.CancelShutdown("batman"); err != nil || serv.Draining() {
+		t.Errorf("expected countdown to be cancelled, got %v", err)
+	}
+	if err := serv.CancelShutdown("batman"); err == nil {
+		t.Errorf("expected nothing to cancel")
+	}
+
+	serv.Shutdown("batman", 20*time.Millisecond, true)
+	select {
+	case restart := <-exited:
+		if !restart {
+			t.Errorf("expected a restart")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to stop")
+	}
+	if _, err := c2.Write([]byte("x")); err == nil {
+		t.Errorf("expected connections to be closed")
+	}
+
+	var texts []string
+	for _, ev := range robin.unread[1:] {
+		texts = append(texts, ev.Text)
+	}
+	want := []string{
+		"The server is shutting down in 1h0m0s",
+		"The server is no longer shutting down",
+		"The server is restarting in 20ms",
+		"The server is restarting now",
+	}
+	if len(texts) != len(want) {
+		t.Fatalf("expected %v, got %v", want, texts)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("expected %q, got %q", want[i], texts[i])
+		}
+	}
+}
+
+func TestParseShutdownDelay(t *testing.T) {
+	if d, err := parseShutdownDelay("30"); err != nil || d != 30*time.Second {
+		t.Errorf("expected 30s, got %v %v", d, err)
+	}
+	if d, err := parseShutdownDelay("5m"); err != nil || d != 5*time.Minute {
+		t.Errorf("expected 5m, got %v %v", d, err)
+	}
+	if _, err := parseShutdownDelay("2h"); err == nil {
+		t.Errorf("expected countdown over an hour to be refused")
+	}
+	if _, err := parseShutdownDelay("soon"); err == nil {
+		t.Errorf("expected bad delay to be refused")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	serv := NewServer()
+	serv.exit = func(restart bool) { t.Errorf("expected the server to keep running") }
+
+	var conns []net.Conn
+	for _, nick := range []string{"batman", "robin", "alfred"} {
+		c1, c2 := net.Pipe()
+		conns = append(conns, c1)
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				if _, err := c1.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		serv.JoinRoom("gotham", &Client{nick: nick, Conns: []*Conn{NewConn(c2)}})
+	}
+
+	start := time.Now()
+	if err := serv.Maintenance("batman", 0); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if err := serv.Shutdown("batman", time.Hour, false); err == nil {
+		t.Errorf("expected one countdown at a time")
+	}
+
+	// the last connection closes after two gaps
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := conns[len(conns)-1].Write([]byte("x")); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, c := range conns {
+		if _, err := c.Write([]byte("x")); err == nil {
+			t.Errorf("expected every connection to be closed")
+		}
+	}
+	if time.Since(start) < 2*maintenanceGap {
+		t.Errorf("expected the connections to be closed a little apart")
+	}
+	if !serv.Draining() {
+		t.Errorf("expected new connections to be refused")
+	}
+
+	if err := serv.CancelShutdown("batman"); err != nil || serv.Draining() {
+		t.Errorf("expected maintenance to end, got %v", err)
+	}
+}
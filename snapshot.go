@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const snapshotName = "snapshot.json"
+
+// snapshot is the in-memory state of the server written to disk
+type snapshot struct {
+	Time     string              `json:"time"`
+	LastID   int64               `json:"last_id"`
+	Rooms    []roomSnapshot      `json:"rooms"`
+	Accounts map[string]*Account `json:"accounts"`
+}
+
+type roomSnapshot struct {
+	Name      string                        `json:"name"`
+	Display   string                        `json:"display,omitempty"`
+	Owner     string                        `json:"owner,omitempty"`
+	Topic     string                        `json:"topic,omitempty"`
+	Public    bool                          `json:"public,omitempty"`
+	TTL       time.Duration                 `json:"ttl,omitempty"`
+	Notices   bool                          `json:"notices,omitempty"`
+	MOTD      string                        `json:"motd,omitempty"`
+	Spam      string                        `json:"spam,omitempty"`
+	SpamDrop  bool                          `json:"spam_drop,omitempty"`
+	Created   string                        `json:"created,omitempty"`
+	Peak      int                           `json:"peak,omitempty"`
+	Messages  int64                         `json:"messages,omitempty"`
+	LineLimit int                           `json:"line_limit,omitempty"`
+	Since     int64                         `json:"public_since,omitempty"`
+	Bans      []string                      `json:"bans,omitempty"`
+	Ops       []string                      `json:"ops,omitempty"`
+	History   []Event                       `json:"history,omitempty"`
+	Reactions map[int64]map[string][]string `json:"reactions,omitempty"`
+}
+
+// Snapshot writes the rooms, topics, bans, history, and registered nicks
+// to the snapshot file, only admins may take a snapshot
+func (s *Server) Snapshot(cl *Client) error {
+	if !s.isAdmin(cl) {
+		return errors.New("only admins can take a snapshot\r\n")
+	}
+	if s.SnapshotPath == "" {
+		return errors.New("snapshots are not enabled\r\n")
+	}
+	return s.writeSnapshot(s.SnapshotPath)
+}
+
+func (s *Server) writeSnapshot(path string) error {
+	s.mu.Lock()
+	snap := snapshot{Time: time.Now().Format(time.RFC3339), LastID: s.lastID}
+	for _, r := range s.Rooms {
+		rs := roomSnapshot{
+			Name:      r.Name,
+			Display:   r.Display,
+			Owner:     r.Owner,
+			Topic:     r.Topic,
+			Public:    r.Public,
+			TTL:       r.TTL,
+			Notices:   r.Notices,
+			MOTD:      r.MOTD,
+			Spam:      r.Spam,
+			SpamDrop:  r.SpamDrop,
+			Created:   r.Created.UTC().Format(time.RFC3339),
+			Peak:      r.Peak,
+			Messages:  r.Messages,
+			LineLimit: r.LineLimit,
+			Since:     r.PublicSince,
+			History:   r.History,
+			Reactions: r.Reactions,
+		}
+		for nick := range r.Bans {
+			rs.Bans = append(rs.Bans, nick)
+		}
+		sort.Strings(rs.Bans)
+		for nick := range r.Ops {
+			rs.Ops = append(rs.Ops, nick)
+		}
+		sort.Strings(rs.Ops)
+		snap.Rooms = append(snap.Rooms, rs)
+	}
+	sort.Slice(snap.Rooms, func(i, j int) bool { return snap.Rooms[i].Name < snap.Rooms[j].Name })
+
+	s.Accounts.mu.Lock()
+	snap.Accounts = s.Accounts.Accounts
+	b, err := json.MarshalIndent(snap, "", "  ")
+	s.Accounts.mu.Unlock()
+	s.mu.Unlock()
+	if err == nil {
+		b, err = sealData(b)
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	errl(nil, fmt.Sprintf("Snapshot of %d room(s) written to [%s]", len(snap.Rooms), path))
+	return nil
+}
+
+// Restore loads a snapshot taken by Snapshot, accounts already in the
+// account store are kept, a missing file is not an error
+func (s *Server) Restore(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = openData(b); err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return err
+	}
+
+	for name, a := range snap.Accounts {
+		if !s.Accounts.Exists(name) {
+			if err := s.Accounts.Put(*a); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap.LastID > s.lastID {
+		s.lastID = snap.LastID
+	}
+	for _, rs := range snap.Rooms {
+		r, ok := s.Rooms[roomKey(rs.Name)]
+		if !ok {
+			r = s.createRoom(roomKey(rs.Name))
+		}
+		if rs.Display != "" {
+			r.Display = rs.Display
+		}
+		r.Owner = rs.Owner
+		r.Topic = rs.Topic
+		r.Public = rs.Public
+		r.TTL = rs.TTL
+		r.Notices = rs.Notices
+		r.MOTD = rs.MOTD
+		r.Spam, r.SpamDrop = rs.Spam, rs.SpamDrop
+		r.count(rs.Created, rs.Peak, rs.Messages)
+		r.LineLimit = rs.LineLimit
+		r.PublicSince = rs.Since
+		r.History = rs.History
+		r.Reactions = rs.Reactions
+		for _, nick := range rs.Bans {
+			r.Bans[nick] = true
+		}
+		for _, nick := range rs.Ops {
+			r.Ops[nick] = true
+		}
+	}
+	errl(nil, fmt.Sprintf("Restored %d room(s) from snapshot taken %s", len(snap.Rooms), snap.Time))
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tinychat")
+	if err != nil {
+		t.Fatalf("expected error to be nil")
+	}
+	defer os.RemoveAll(dir)
+
+	serv := NewServer()
+	serv.SnapshotPath = path.Join(dir, snapshotName)
+	serv.Admins = map[string]bool{"batman": true}
+
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", batman)
+	serv.Register(batman, "hunter2")
+	serv.SetTopic(batman, "the dark knight")
+	serv.Ban(batman, "joker")
+	serv.Message([]string{"hello"}, batman)
+
+	if err := serv.Snapshot(&Client{nick: "robin"}); err == nil {
+		t.Errorf("expected only admins to take a snapshot")
+	}
+	if err := serv.Snapshot(batman); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	fresh := NewServer()
+	if err := fresh.Restore(serv.SnapshotPath); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	r, ok := fresh.Rooms["gotham"]
+	if !ok {
+		t.Fatalf("expected room to be restored")
+	}
+	if r.Owner != "batman" || r.Topic != "the dark knight" || !r.Bans["joker"] || len(r.History) != 1 {
+		t.Errorf("unexpected room %+v", r)
+	}
+	if !fresh.Accounts.Exists("batman") {
+		t.Errorf("expected account to be restored")
+	}
+	if fresh.nextID() != 2 {
+		t.Errorf("expected event ids to continue after the snapshot")
+	}
+}
+
+func TestRestoreMissing(t *testing.T) {
+	if err := NewServer().Restore("/nonexistent/snapshot.json"); err != nil {
+		t.Errorf("expected a missing snapshot to be ignored")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// spamName is the file the spam classifier is kept in so its training
+// survives restarts
+const spamName = "spam.json"
+
+// minSpamTraining is how many messages of each kind the classifier must be
+// taught before it scores anything
+const minSpamTraining = 10
+
+// spamTokens is how many of the most telling words of a message decide its
+// score
+const spamTokens = 15
+
+// maxHeld is how many messages a room holds for review, the oldest are
+// dropped first
+const maxHeld = 50
+
+// spamLevels are the sensitivities a room may pick, and the score from
+// which a message is taken for spam
+var spamLevels = map[string]float64{
+	"low":    0.99,
+	"medium": 0.95,
+	"high":   0.9,
+}
+
+// spamModel counts the messages taught as spam and ham, and the words in
+// them
+type spamModel struct {
+	Spam     map[string]int `json:"spam"`
+	Ham      map[string]int `json:"ham"`
+	SpamDocs int            `json:"spam_docs"`
+	HamDocs  int            `json:"ham_docs"`
+}
+
+// SpamFilter is a naive Bayes classifier taught by the messages moderators
+// report as spam, or as not spam
+type SpamFilter struct {
+	mu    sync.Mutex
+	path  string
+	model spamModel
+}
+
+// NewSpamFilter returns an untaught filter kept in the file at path, an
+// empty path keeps it in memory
+func NewSpamFilter(path string) *SpamFilter {
+	return &SpamFilter{path: path, model: spamModel{Spam: make(map[string]int), Ham: make(map[string]int)}}
+}
+
+// Load reads the filter from disk, a missing file is not an error
+func (f *SpamFilter) Load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = openData(b); err != nil {
+		return err
+	}
+	var m spamModel
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if m.Spam == nil {
+		m.Spam = make(map[string]int)
+	}
+	if m.Ham == nil {
+		m.Ham = make(map[string]int)
+	}
+	f.model = m
+	return nil
+}
+
+func (f *SpamFilter) save() error {
+	if f.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(f.model)
+	if err != nil {
+		return err
+	}
+	if b, err = sealData(b); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// spamWords returns the distinct words of text, lower cased, words too
+// short or too long to tell anything are skipped
+func spamWords(text string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '$' && c != '!' && c != '.' && c != '/'
+	}) {
+		w = strings.Trim(w, "./")
+		if n := utf8.RuneCountInString(w); n < 2 || n > 24 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	return out
+}
+
+// Train teaches the filter that text is spam, or isn't
+func (f *SpamFilter) Train(text string, spam bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counts := f.model.Ham
+	if spam {
+		counts = f.model.Spam
+		f.model.SpamDocs++
+	} else {
+		f.model.HamDocs++
+	}
+	for _, w := range spamWords(text) {
+		counts[w]++
+	}
+	return f.save()
+}
+
+// Trained returns how many messages were taught as spam and as ham
+func (f *SpamFilter) Trained() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.model.SpamDocs, f.model.HamDocs
+}
+
+// Score returns how likely text is spam, from 0 to 1, combining the most
+// telling of its words, it is 0 until the filter was taught enough of both
+func (f *SpamFilter) Score(text string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.model
+	if m.SpamDocs < minSpamTraining || m.HamDocs < minSpamTraining {
+		return 0
+	}
+	var probs []float64
+	for _, w := range spamWords(text) {
+		s, h := m.Spam[w], m.Ham[w]
+		if s+h == 0 {
+			continue
+		}
+		ps, ph := float64(s)/float64(m.SpamDocs), float64(h)/float64(m.HamDocs)
+		// words seen rarely lean towards neutral, Robinson's adjustment
+		n := float64(s + h)
+		p := (0.5 + n*ps/(ps+ph)) / (1 + n)
+		probs = append(probs, math.Max(0.01, math.Min(0.99, p)))
+	}
+	if len(probs) == 0 {
+		return 0
+	}
+	sort.Slice(probs, func(i, j int) bool { return math.Abs(probs[i]-0.5) > math.Abs(probs[j]-0.5) })
+	if len(probs) > spamTokens {
+		probs = probs[:spamTokens]
+	}
+	eta := 0.0
+	for _, p := range probs {
+		eta += math.Log(1-p) - math.Log(p)
+	}
+	return 1 / (1 + math.Exp(eta))
+}
+
+// spam holds or drops the message if the room's filter takes it for spam,
+// returning true when it did, it must be called with the server lock held
+func (s *Server) spam(r *Room, cl *Client, ev Event) bool {
+	threshold, ok := spamLevels[r.Spam]
+	if !ok || s.Spam == nil {
+		return false
+	}
+	score := s.Spam.Score(ev.Text)
+	if score < threshold {
+		return false
+	}
+	if r.SpamDrop {
+		errl(nil, fmt.Sprintf("Dropped spam from [%s] in [%s] scoring %.2f", cl.Nick(), r.Name, score))
+		return true
+	}
+
+	r.Held = append(r.Held, ev)
+	if len(r.Held) > maxHeld {
+		r.Held = r.Held[len(r.Held)-maxHeld:]
+	}
+	cl.Write("Your message is held for review by the moderators\r\n")
+	text := fmt.Sprintf("Held message [%d] from [%s] as likely spam (%.0f%%), /ham %d posts it, /spam %d drops it: %s\r\n", ev.ID, ev.From, score*100, ev.ID, ev.ID, ev.Text)
+	for _, c := range r.Clients {
+		if c != cl && s.moderates(r, c) {
+			c.Write(text)
+		}
+	}
+	return true
+}
+
+// unhold removes the held message with id from the room, it must be called
+// with the server lock held
+func (r *Room) unhold(id int64) (Event, bool) {
+	for i, ev := range r.Held {
+		if ev.ID == id {
+			r.Held = append(r.Held[:i:i], r.Held[i+1:]...)
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+// unrecord removes the message with id from the room's history, it must be
+// called with the server lock held
+func (r *Room) unrecord(id int64) (Event, bool) {
+	for i, ev := range r.History {
+		if ev.ID == id && ev.Type == EventMessage {
+			r.History = append(r.History[:i:i], r.History[i+1:]...)
+			delete(r.Reactions, id)
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+// moderatedRoom returns the client's room if the client moderates it, it
+// must be called with the server lock held
+func (s *Server) moderatedRoom(cl *Client, what string) (*Room, error) {
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return nil, err
+	}
+	if !s.moderates(r, cl) {
+		return nil, fmt.Errorf("only the owner of the room, its ops, and moderators can %s\r\n", what)
+	}
+	return r, nil
+}
+
+// ReportSpam teaches the filter the message with id is spam, a held
+// message is dropped and one already posted is removed from the room
+func (s *Server) ReportSpam(cl *Client, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.moderatedRoom(cl, "report spam")
+	if err != nil {
+		return err
+	}
+	ev, ok := r.unhold(id)
+	if !ok {
+		if ev, ok = r.unrecord(id); !ok {
+			return fmt.Errorf("message [%d] is not in room [%s]\r\n", id, r.Name)
+		}
+		gone := Event{Type: EventExpire, Time: ev.Time, Room: r.Name, IDs: []int64{id}}
+		for _, c := range r.Clients {
+			c.Send(gone)
+		}
+	}
+	errl(nil, fmt.Sprintf("[%s] reported message [%d] from [%s] as spam", cl.Nick(), id, ev.From))
+	return s.Spam.Train(ev.Text, true)
+}
+
+// ReportHam teaches the filter the message with id isn't spam, a held
+// message is posted to the room
+func (s *Server) ReportHam(cl *Client, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.moderatedRoom(cl, "report spam")
+	if err != nil {
+		return err
+	}
+	if ev, ok := r.unhold(id); ok {
+		s.post(r, ev)
+		return s.Spam.Train(ev.Text, false)
+	}
+	ev, ok := r.find(id)
+	if !ok {
+		return fmt.Errorf("message [%d] is not in room [%s]\r\n", id, r.Name)
+	}
+	return s.Spam.Train(ev.Text, false)
+}
+
+// Held lists the messages held for review in the client's room
+func (s *Server) Held(cl *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.moderatedRoom(cl, "review held messages")
+	if err != nil {
+		return "", err
+	}
+	if len(r.Held) == 0 {
+		return "No messages are held for review\r\n", nil
+	}
+	var b strings.Builder
+	for _, ev := range r.Held {
+		fmt.Fprintf(&b, "[%d] [%s] %s\r\n", ev.ID, ev.From, ev.Text)
+	}
+	return b.String(), nil
+}
+
+// SetSpamFilter sets how readily the client's room takes messages for
+// spam, and whether they are dropped instead of held, only the owner of
+// the room, its ops, and moderators may change it
+func (s *Server) SetSpamFilter(cl *Client, level string, drop bool) error {
+	s.mu.Lock()
+	r, err := s.moderatedRoom(cl, "change the spam filter")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if level == "off" {
+		level, drop = "", false
+	}
+	return s.change(raftCommand{Op: raftRoom, Room: r.Name, Spam: level, SpamDrop: drop, Fields: []string{"spam"}})
+}
+
+// spamfilterCommand runs /spamfilter
+func (s *Server) spamfilterCommand(in *Input) {
+	if len(in.Args) == 1 {
+		s.mu.Lock()
+		r, err := s.findRoom(in.Client)
+		level, drop := "off", false
+		if err == nil && r.Spam != "" {
+			level, drop = r.Spam, r.SpamDrop
+		}
+		s.mu.Unlock()
+		spam, ham := s.Spam.Trained()
+		action := "held for review"
+		if drop {
+			action = "dropped"
+		}
+		reply(in.Client, fmt.Sprintf("Spam filter is [%s], likely spam is %s, taught %d spam and %d other messages\r\n", level, action, spam, ham), err)
+		return
+	}
+	level := strings.ToLower(in.Args[1])
+	if _, ok := spamLevels[level]; !ok && level != "off" {
+		in.Client.Write(fmt.Sprintf("[%s] is not a sensitivity, use off, low, medium, or high\r\n", in.Args[1]))
+		return
+	}
+	drop := false
+	if len(in.Args) > 2 {
+		switch strings.ToLower(in.Args[2]) {
+		case "hold":
+		case "drop":
+			drop = true
+		default:
+			in.Client.Write(fmt.Sprintf("[%s] is not an action, use hold or drop\r\n", in.Args[2]))
+			return
+		}
+	}
+	reply(in.Client, fmt.Sprintf("Spam filter set to [%s]\r\n", level), s.SetSpamFilter(in.Client, level, drop))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// teach trains the filter with enough spam and ham to score
+func teach(f *SpamFilter) {
+	for i := 0; i < minSpamTraining; i++ {
+		f.Train(fmt.Sprintf("cheap pills %d buy now at pills.example free money", i), true)
+		f.Train(fmt.Sprintf("the joker was seen near the docks %d, meet at the manor", i), false)
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, spamName)
+
+	f := NewSpamFilter(file)
+	if score := f.Score("cheap pills buy now"); score != 0 {
+		t.Errorf("expected an untaught filter to score 0, got %f", score)
+	}
+	teach(f)
+	if score := f.Score("free pills, buy now"); score < spamLevels["low"] {
+		t.Errorf("expected spam to score high, got %f", score)
+	}
+	if score := f.Score("meet me at the docks"); score > 0.5 {
+		t.Errorf("expected ham to score low, got %f", score)
+	}
+	if score := f.Score("something else entirely"); score != 0 {
+		t.Errorf("expected unknown words to score 0, got %f", score)
+	}
+
+	loaded := NewSpamFilter(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if spam, ham := loaded.Trained(); spam != minSpamTraining || ham != minSpamTraining {
+		t.Errorf("expected the training to be saved, got %d spam and %d ham", spam, ham)
+	}
+}
+
+func TestSpamHold(t *testing.T) {
+	serv := NewServer()
+	teach(serv.Spam)
+	batman, joker := &Client{nick: "batman"}, &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+	r := serv.Rooms["gotham"]
+
+	if err := serv.SetSpamFilter(joker, "high", false); err == nil {
+		t.Errorf("expected only moderators to change the spam filter")
+	}
+	serv.Dispatch(&Input{Client: batman, Command: "/spamfilter", Args: []string{"/spamfilter", "medium"}})
+	if r.Spam != "medium" || r.SpamDrop {
+		t.Errorf("expected the room to hold likely spam, got [%s] %t", r.Spam, r.SpamDrop)
+	}
+
+	serv.Message([]string{"free", "pills", "buy", "now"}, joker)
+	if len(r.Held) != 1 || len(r.History) != 0 {
+		t.Fatalf("expected the spam held, got %d held and %d in history", len(r.Held), len(r.History))
+	}
+	id := r.Held[0].ID
+	if last := batman.unread[len(batman.unread)-1].Text; !strings.Contains(last, fmt.Sprintf("/ham %d", id)) {
+		t.Errorf("expected moderators told of the held message, got [%s]", last)
+	}
+	if out, _ := serv.Held(batman); !strings.Contains(out, "free pills") {
+		t.Errorf("expected /held to list it, got [%s]", out)
+	}
+
+	serv.Dispatch(&Input{Client: batman, Command: "/ham", Args: []string{"/ham", fmt.Sprint(id)}})
+	if len(r.Held) != 0 || len(r.History) != 1 || r.History[0].ID != id {
+		t.Errorf("expected /ham to post the held message")
+	}
+	if _, ham := serv.Spam.Trained(); ham != minSpamTraining+1 {
+		t.Errorf("expected /ham to teach the filter")
+	}
+
+	serv.Dispatch(&Input{Client: batman, Command: "/spam", Args: []string{"/spam", fmt.Sprint(id)}})
+	if len(r.History) != 0 {
+		t.Errorf("expected /spam to remove the posted message")
+	}
+	if spam, _ := serv.Spam.Trained(); spam != minSpamTraining+1 {
+		t.Errorf("expected /spam to teach the filter")
+	}
+
+	serv.SetSpamFilter(batman, "medium", true)
+	serv.Message([]string{"cheap", "pills", "free", "money"}, joker)
+	if len(r.Held) != 0 || len(r.History) != 0 {
+		t.Errorf("expected the spam dropped")
+	}
+	serv.Message([]string{"meet", "at", "the", "manor"}, joker)
+	if len(r.History) != 1 {
+		t.Errorf("expected other messages posted")
+	}
+}
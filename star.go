@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxStars is how many messages an account may star
+const maxStars = 100
+
+// Star saves a message of the client's room to the starred list of its
+// account, or removes it if it was starred already
+// example: /star 42
+func (s *Server) Star(cl *Client, arg string) (string, error) {
+	name := cl.Account()
+	if name == "" {
+		return "", fmt.Errorf("you must be logged in to star messages\r\n")
+	}
+	id, err := parseMessageID(arg)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	r, err := s.findRoom(cl)
+	if err != nil {
+		s.mu.Unlock()
+		return "", err
+	}
+	ev, ok := r.find(id)
+	s.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("message [%d] is not in room [%s]\r\n", id, r.Name)
+	}
+
+	var text string
+	uerr := s.Accounts.Update(name, func(a *Account) {
+		for i, st := range a.Stars {
+			if st.ID == id {
+				a.Stars = append(a.Stars[:i:i], a.Stars[i+1:]...)
+				text = fmt.Sprintf("Message [%d] is no longer starred\r\n", id)
+				return
+			}
+		}
+		if len(a.Stars) >= maxStars {
+			err = fmt.Errorf("you can star up to %d messages, remove one first\r\n", maxStars)
+			return
+		}
+		a.Stars = append(a.Stars, ev)
+		text = fmt.Sprintf("Message [%d] is starred\r\n", id)
+	})
+	if uerr != nil {
+		return "", uerr
+	}
+	return text, err
+}
+
+// Starred lists the messages starred by the client's account
+func (s *Server) Starred(cl *Client) (string, error) {
+	name := cl.Account()
+	if name == "" {
+		return "", fmt.Errorf("you must be logged in to star messages\r\n")
+	}
+	a, ok := s.Accounts.Get(name)
+	if !ok {
+		return "", fmt.Errorf("nick [%s] is not registered\r\n", name)
+	}
+	if len(a.Stars) == 0 {
+		return "You have no starred messages\r\n", nil
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("You starred %d message(s):\r\n", len(a.Stars)))
+	for _, ev := range a.Stars {
+		b.WriteString(fmt.Sprintf("#%d in [%s] %s", ev.ID, ev.Room, ev.String()))
+	}
+	return b.String(), nil
+}
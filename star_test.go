@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStar(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman, joker := &Client{nick: "batman", account: "batman"}, &Client{nick: "joker"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", joker)
+
+	serv.Message([]string{"the", "vault", "code", "is", "1939"}, joker)
+	id := strconv.FormatInt(batman.unread[len(batman.unread)-1].ID, 10)
+
+	if _, err := serv.Star(joker, id); err == nil {
+		t.Errorf("expected guests to be unable to star")
+	}
+	if _, err := serv.Star(batman, "999"); err == nil {
+		t.Errorf("expected a missing message to fail")
+	}
+	if _, err := serv.Star(batman, id); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+
+	out, _ := serv.Starred(batman)
+	if !strings.Contains(out, "#"+id+" in [gotham]") || !strings.Contains(out, "joker] the vault code is 1939") {
+		t.Errorf("expected the starred message listed, got [%s]", out)
+	}
+	if a, _ := serv.Accounts.Get("batman"); len(a.Stars) != 1 {
+		t.Errorf("expected the star saved with the account, got %v", a.Stars)
+	}
+
+	// starring again removes it
+	serv.Star(batman, id)
+	if out, _ := serv.Starred(batman); out != "You have no starred messages\r\n" {
+		t.Errorf("expected no stars, got [%s]", out)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// set at build time with
+// -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo returns the version, commit, and build date of the server,
+// those not set at build time come from what the go tool stamped
+func buildInfo() (string, string, string) {
+	v, c, d := version, commit, date
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, c, d
+	}
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	stamped, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			stamped = s.Value
+		case "vcs.time":
+			if d == "" {
+				d = s.Value
+			}
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if c == "" && stamped != "" {
+		c = stamped
+		if dirty {
+			c += "-dirty"
+		}
+	}
+	return v, c, d
+}
+
+// Version describes the build of the server in a line
+func Version() string {
+	v, c, d := buildInfo()
+	out := "TinyChat " + v
+	if c != "" {
+		out += ", commit " + c
+	}
+	if d != "" {
+		out += ", built " + d
+	}
+	return out + ", " + runtime.Version()
+}
+
+// Uptime describes how long the server has been running and how busy it
+// is
+func (s *Server) Uptime() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up := time.Since(s.started).Truncate(time.Second)
+	return fmt.Sprintf("Up %s since %s, %d client(s) in %d room(s)\r\n", up, s.started.Format(time.RFC1123), len(s.Clients), len(s.Rooms))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.0", "0123456789abcdef", "2026-10-16T00:00:00Z"
+	if got := Version(); got != "TinyChat v1.2.0, commit 0123456789abcdef, built 2026-10-16T00:00:00Z, "+runtime.Version() {
+		t.Errorf("expected the version set at build time, got [%s]", got)
+	}
+
+	serv := NewServer()
+	serv.started = time.Now().Add(-90 * time.Minute)
+	serv.JoinRoom("gotham", &Client{nick: "batman"})
+	if got := serv.Uptime(); !strings.HasPrefix(got, "Up 1h30m0s since ") || !strings.HasSuffix(got, "1 client(s) in 1 room(s)\r\n") {
+		t.Errorf("expected the uptime, got [%s]", got)
+	}
+}
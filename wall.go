@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Wall posts a server notice to the rooms named, separated by commas, or
+// to every room for all, unlike a blast it is kept in each room's history
+// and reaches only those in the rooms, it returns how many rooms it was
+// posted to
+// example: /wall gotham,metropolis the bridges are closed tonight
+func (s *Server) Wall(cl *Client, rooms, text string) (int, error) {
+	if text == "" {
+		return 0, errors.New("a wall needs text\r\n")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	if strings.EqualFold(rooms, "all") {
+		for name := range s.Rooms {
+			names = append(names, name)
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, name := range splitList(rooms) {
+			key := roomKey(name)
+			if _, ok := s.Rooms[key]; !ok {
+				return 0, fmt.Errorf("room [%s] does not exist\r\n", name)
+			}
+			if !seen[key] {
+				seen[key] = true
+				names = append(names, key)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return 0, errors.New("name the rooms to wall, or all\r\n")
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ev := Event{
+			Type: EventNotice,
+			Time: time.Now().Format(time.RFC3339),
+			From: cl.Nick(),
+			Room: name,
+			Text: text,
+		}
+		if err := s.deliver(ev); err != nil {
+			return 0, err
+		}
+	}
+	s.logMod(cl.Nick(), "wall", truncate(text, 80), strings.Join(names, ","))
+	errl(nil, fmt.Sprintf("Wall from [%s] to %d room(s): %s", cl.Nick(), len(names), text))
+	return len(names), nil
+}
+
+// RoomNotice posts a notice to the client's room, bots send their
+// automated replies as notices since other bots must never answer one, so
+// two bots can't set each other off
+// example: /notice build 42 passed
+func (s *Server) RoomNotice(cl *Client, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.findRoom(cl)
+	if err != nil {
+		return err
+	}
+	if err := s.silenced(cl); err != nil {
+		return err
+	}
+	ev := Event{
+		Type: EventNotice,
+		Time: time.Now().Format(time.RFC3339),
+		From: cl.Nick(),
+		Room: r.Name,
+		Text: text,
+		ID:   s.nextID(),
+	}
+	if !s.spam(r, cl, ev) {
+		s.post(r, s.paste(r, ev))
+	}
+	return nil
+}
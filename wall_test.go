@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWall(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	robin := &Client{nick: "robin"}
+	lois := &Client{nick: "lois"}
+	serv.JoinRoom("gotham", batman)
+	serv.JoinRoom("gotham", robin)
+	serv.JoinRoom("metropolis", lois)
+	serv.JoinRoom("arkham", &Client{nick: "joker"})
+	robin.unread, lois.unread = nil, nil
+
+	n, err := serv.Wall(batman, "Gotham, metropolis,gotham", "the bridges are closed")
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 rooms walled, got %d, %v", n, err)
+	}
+	for _, cl := range []*Client{robin, lois} {
+		if len(cl.unread) != 1 || cl.unread[0].Type != EventNotice || cl.unread[0].From != "batman" || cl.unread[0].Text != "the bridges are closed" {
+			t.Errorf("expected [%s] to get the notice, got %+v", cl.nick, cl.unread)
+		}
+	}
+	if h := serv.Rooms["arkham"].History; len(h) != 0 {
+		t.Errorf("expected arkham left alone, got %+v", h)
+	}
+	if h := serv.Rooms["metropolis"].History; len(h) != 1 || h[0].ID == 0 {
+		t.Errorf("expected the notice kept in history, got %+v", h)
+	}
+	if log := serv.ModLog(); len(log) != 1 || log[0].Action != "wall" || log[0].Room != "gotham,metropolis" {
+		t.Errorf("expected the wall logged, got %+v", log)
+	}
+
+	if n, _ := serv.Wall(batman, "ALL", "maintenance at midnight"); n != len(serv.Rooms) {
+		t.Errorf("expected every room walled, got %d", n)
+	}
+	if _, err := serv.Wall(batman, "gotham,bludhaven", "hi"); err == nil || !strings.Contains(err.Error(), "bludhaven") {
+		t.Errorf("expected an unknown room refused, got %v", err)
+	}
+	if _, err := serv.Wall(batman, ",", "hi"); err == nil {
+		t.Errorf("expected no rooms refused")
+	}
+}
+
+func TestRoomNotice(t *testing.T) {
+	serv := NewServer()
+	alfred := &Client{nick: "alfred", bot: true}
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("batcave", alfred)
+	serv.JoinRoom("batcave", batman)
+	batman.unread = nil
+
+	if err := serv.RoomNotice(alfred, "build 42 passed"); err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if len(batman.unread) != 1 || batman.unread[0].Type != EventNotice || batman.unread[0].ID == 0 {
+		t.Fatalf("expected a notice, got %+v", batman.unread)
+	}
+	ev := batman.unread[0]
+	ev.Time = "now"
+	if got := ev.String(); got != "-!- [now:alfred] build 42 passed\r\n" {
+		t.Errorf("expected a notice line, got [%s]", got)
+	}
+	if got := plainText(ev.Colored()); got != ev.String() {
+		t.Errorf("expected the colored notice to read the same, got [%s]", got)
+	}
+	if r := serv.Rooms["batcave"]; len(r.History) != 1 || r.Messages != 0 {
+		t.Errorf("expected the notice kept but not counted as a message, got %d, %d", len(r.History), r.Messages)
+	}
+
+	if serv.Notice("the server restarts at noon") != 2 || batman.unread[1].Type != EventNotice {
+		t.Errorf("expected server notices to be notices, got %+v", batman.unread)
+	}
+	if err := serv.RoomNotice(&Client{nick: "joker"}, "hi"); err == nil {
+		t.Errorf("expected a client without a room refused")
+	}
+
+	// long notices are pasted like messages
+	serv.RoomNotice(batman, strings.Repeat("na ", 400)+"batman")
+	if h := serv.Rooms["batcave"].History; h[len(h)-1].Paste == "" {
+		t.Errorf("expected a notice over the line limit pasted")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxWatch is how many nicks an account may watch
+const maxWatch = 50
+
+// Watching returns the nicks the client is told about
+func (cl *Client) Watching() []string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return append([]string{}, cl.watch...)
+}
+
+// watches returns true if the client is told when nick connects
+func (cl *Client) watches(nick string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, w := range cl.watch {
+		if strings.EqualFold(w, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch adds nick to the watch list of the client's account, or removes it
+// if it was there already, it returns true if nick is now watched
+// example: /watch robin
+func (s *Server) Watch(cl *Client, nick string) (bool, error) {
+	name := cl.Account()
+	if name == "" {
+		return false, fmt.Errorf("you must be logged in to watch nicks\r\n")
+	}
+
+	var watched bool
+	var list []string
+	var err error
+	uerr := s.Accounts.Update(name, func(a *Account) {
+		defer func() { list = append([]string{}, a.Watch...) }()
+		for i, w := range a.Watch {
+			if strings.EqualFold(w, nick) {
+				a.Watch = append(a.Watch[:i:i], a.Watch[i+1:]...)
+				return
+			}
+		}
+		if len(a.Watch) >= maxWatch {
+			err = fmt.Errorf("you can watch up to %d nicks\r\n", maxWatch)
+			return
+		}
+		a.Watch = append(a.Watch, nick)
+		watched = true
+	})
+	if uerr != nil {
+		return false, uerr
+	}
+
+	cl.mu.Lock()
+	cl.watch = list
+	cl.mu.Unlock()
+	return watched, err
+}
+
+// WatchList describes which of the nicks the client watches are connected
+func (s *Server) WatchList(cl *Client) string {
+	nicks := cl.Watching()
+	if len(nicks) == 0 {
+		return "You are not watching anyone, /watch <nick> to start\r\n"
+	}
+	sort.Strings(nicks)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var on, off []string
+	for _, n := range nicks {
+		if _, ok := s.Clients[n]; ok || s.remoteNick(n) {
+			on = append(on, n)
+		} else {
+			off = append(off, n)
+		}
+	}
+	return fmt.Sprintf("Watched and connected: %s\r\nWatched and not connected: %s\r\n", listOrNone(on), listOrNone(off))
+}
+
+// listOrNone joins the names, or returns none if there are none
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// watchNotify tells the clients watching nick that it connected or
+// disconnected, it must be called with the server lock held
+func (s *Server) watchNotify(nick string, connected bool) {
+	text := fmt.Sprintf("[%s] connected", nick)
+	if !connected {
+		text = fmt.Sprintf("[%s] disconnected", nick)
+	}
+	for _, c := range s.Clients {
+		if c.Nick() != nick && c.watches(nick) {
+			c.Send(Event{
+				Type: EventService,
+				Time: time.Now().Format(time.RFC3339),
+				From: "server",
+				To:   c.Nick(),
+				Text: text,
+			})
+		}
+	}
+}
+
+// watchCommand runs /watch
+func (s *Server) watchCommand(in *Input) {
+	if len(in.Args) == 1 {
+		in.Client.Write(s.WatchList(in.Client))
+		return
+	}
+	nick := in.Args[1]
+	watched, err := s.Watch(in.Client, nick)
+	if err == nil && !watched {
+		in.Client.Write(fmt.Sprintf("You are no longer watching [%s]\r\n", nick))
+		return
+	}
+	reply(in.Client, fmt.Sprintf("You are watching [%s]\r\n", nick), err)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	serv := NewServer()
+	serv.Accounts.Register("batman", "alfred")
+	batman, joker := &Client{nick: "batman", account: "batman"}, &Client{nick: "joker"}
+	serv.JoinRoom("batcave", batman)
+	serv.JoinRoom("gotham", joker)
+
+	if _, err := serv.Watch(joker, "batman"); err == nil {
+		t.Errorf("expected guests to be unable to watch")
+	}
+	if watched, err := serv.Watch(batman, "robin"); !watched || err != nil {
+		t.Fatalf("expected robin watched, got %v", err)
+	}
+	if out := serv.WatchList(batman); out != "Watched and connected: none\r\nWatched and not connected: robin\r\n" {
+		t.Errorf("unexpected watch list [%s]", out)
+	}
+
+	last := func() string { return batman.unread[len(batman.unread)-1].Text }
+	serv.ChangeNick("joker", "robin")
+	if last() != "[robin] connected" {
+		t.Errorf("expected batman told robin connected, got [%s]", last())
+	}
+	serv.mu.Lock()
+	serv.removeClient(serv.Clients["robin"])
+	serv.mu.Unlock()
+	if last() != "[robin] disconnected" {
+		t.Errorf("expected batman told robin disconnected, got [%s]", last())
+	}
+	if a, _ := serv.Accounts.Get("batman"); len(a.Watch) != 1 {
+		t.Errorf("expected the watch list kept with the account, got %v", a.Watch)
+	}
+
+	// watching again stops
+	if watched, _ := serv.Watch(batman, "Robin"); watched || !strings.Contains(serv.WatchList(batman), "not watching") {
+		t.Errorf("expected robin no longer watched")
+	}
+}
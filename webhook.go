@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// webhookQueue is how many events may wait for delivery before new ones
+// are dropped
+const webhookQueue = 256
+
+// Webhooks posts server events as JSON to the configured URLs
+type Webhooks struct {
+	URLs   []string
+	Events map[string]bool
+	Secret string
+	client *http.Client
+	queue  chan Event
+}
+
+// NewWebhooks returns webhooks for the urls, only the listed event types are
+// delivered, or every type if events is empty, a non empty secret signs
+// every payload
+func NewWebhooks(urls, events []string, secret string) *Webhooks {
+	w := &Webhooks{
+		URLs:   urls,
+		Events: make(map[string]bool),
+		Secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, webhookQueue),
+	}
+	for _, e := range events {
+		w.Events[e] = true
+	}
+	go w.run()
+	return w
+}
+
+// Fire queues the event for delivery, it never blocks the caller
+func (w *Webhooks) Fire(ev Event) {
+	if w == nil || len(w.URLs) == 0 {
+		return
+	}
+	if len(w.Events) > 0 && !w.Events[ev.Type] {
+		return
+	}
+
+	select {
+	case w.queue <- ev:
+	default:
+		errl(fmt.Errorf("webhook queue is full, dropping %s event", ev.Type), "")
+	}
+}
+
+// run delivers queued events until the queue is closed
+func (w *Webhooks) run() {
+	for ev := range w.queue {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			errl(err, "")
+			continue
+		}
+		for _, u := range w.URLs {
+			w.post(u, b)
+		}
+	}
+}
+
+// post sends a single payload to a single URL
+func (w *Webhooks) post(url string, b []byte) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(b)
+		req.Header.Set("X-TinyChat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		errl(err, "")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		errl(fmt.Errorf("webhook %s returned %s", url, resp.Status), "")
+	}
+}
+
+// splitList splits a comma separated env value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
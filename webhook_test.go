@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooksFire(t *testing.T) {
+	got := make(chan Event, 2)
+	sig := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		json.NewDecoder(r.Body).Decode(&ev)
+		sig <- r.Header.Get("X-TinyChat-Signature")
+		got <- ev
+	}))
+	defer ts.Close()
+
+	serv := NewServer()
+	serv.Hooks = NewWebhooks([]string{ts.URL}, []string{EventMessage}, "s3cret")
+
+	cl := &Client{nick: "batman"}
+	serv.JoinRoom("gotham", cl)
+	serv.Message([]string{"hi", "freeze"}, cl)
+
+	select {
+	case ev := <-got:
+		if ev.Type != EventMessage || ev.Room != "gotham" || ev.Text != "hi freeze" {
+			t.Errorf("expected message event, got %+v", ev)
+		}
+		if s := <-sig; len(s) == 0 {
+			t.Errorf("expected payload to be signed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected webhook to be delivered")
+	}
+
+	select {
+	case ev := <-got:
+		t.Errorf("expected join event to be filtered, got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	// minWidth and maxWidth bound the terminal width a connection may set
+	minWidth = 20
+	maxWidth = 1000
+)
+
+// telnet commands and the window size option of RFC 1073
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+	telnetNAWS = 31
+)
+
+// askNAWS asks a telnet client to report its window size
+var askNAWS = []byte{telnetIAC, telnetDO, telnetNAWS}
+
+// ansiRe matches the ANSI escapes output is colored with
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// SetWidth wraps the connection's output to the columns of its terminal,
+// 0 stops wrapping
+func (c *Conn) SetWidth(columns int) {
+	atomic.StoreInt32(&c.width, int32(columns))
+}
+
+// Width returns the columns output is wrapped to, 0 if it isn't
+func (c *Conn) Width() int {
+	return int(atomic.LoadInt32(&c.width))
+}
+
+// parseWidth parses the columns of /width, off is 0
+func parseWidth(arg string) (int, error) {
+	if arg == "off" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < minWidth || n > maxWidth {
+		return 0, fmt.Errorf("[%s] is not a width, use %d to %d columns or off\r\n", arg, minWidth, maxWidth)
+	}
+	return n, nil
+}
+
+// widthCommand runs /width
+func widthCommand(in *Input) {
+	if in.Conn == nil {
+		in.Client.Write("width is only available to direct connections\r\n")
+		return
+	}
+	if len(in.Args) >= 2 {
+		w, err := parseWidth(in.Args[1])
+		if err != nil {
+			in.Client.Write(err.Error())
+			return
+		}
+		in.Conn.SetWidth(w)
+	}
+	if w := in.Conn.Width(); w > 0 {
+		in.Client.Write(fmt.Sprintf("Output is wrapped at %d columns\r\n", w))
+	} else {
+		in.Client.Write("Output is not wrapped\r\n")
+	}
+}
+
+// visibleLen returns how many columns s takes, ANSI escapes take none
+func visibleLen(s string) int {
+	return utf8.RuneCountInString(ansiRe.ReplaceAllString(s, ""))
+}
+
+// wrap wraps every line of out that is wider than width, a width of 0
+// leaves out alone
+func wrap(out string, width int) string {
+	if width <= 0 {
+		return out
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(out, "\n") {
+		body := strings.TrimRight(line, "\r\n")
+		if visibleLen(body) > width {
+			body = wrapLine(body, width, hangIndent(body, width))
+		}
+		b.WriteString(body + line[len(strings.TrimRight(line, "\r\n")):])
+	}
+	return b.String()
+}
+
+// hangIndent returns how far the continuations of line are indented, up
+// to the text after a [time:nick] header when it is short enough, else 2
+func hangIndent(line string, width int) int {
+	plain := ansiRe.ReplaceAllString(line, "")
+	if i := strings.Index(plain, "] "); i >= 0 && (plain[0] == '[' || plain[0] == '#') {
+		if n := utf8.RuneCountInString(plain[:i+2]); n <= width/2 {
+			return n
+		}
+	}
+	return 2
+}
+
+// wrapLine breaks line at spaces into lines of at most width columns,
+// continuations indented by indent, words too long for a line are split
+func wrapLine(line string, width, indent int) string {
+	pad := strings.Repeat(" ", indent)
+	var b strings.Builder
+	col := 0
+	for _, word := range strings.Fields(line) {
+		n := visibleLen(word)
+		switch {
+		case col == 0:
+		case col+1+n <= width:
+			b.WriteString(" ")
+			col++
+		default:
+			b.WriteString("\r\n" + pad)
+			col = indent
+		}
+		for col+n > width {
+			head, rest := splitVisible(word, width-col)
+			b.WriteString(head + "\r\n" + pad)
+			word, n, col = rest, visibleLen(rest), indent
+		}
+		b.WriteString(word)
+		col += n
+	}
+	return b.String()
+}
+
+// splitVisible splits s after n visible columns, escapes stay whole
+func splitVisible(s string, n int) (string, string) {
+	col := 0
+	for i := 0; i < len(s); {
+		if loc := ansiRe.FindStringIndex(s[i:]); loc != nil && loc[0] == 0 {
+			i += loc[1]
+			continue
+		}
+		if col == n {
+			return s[:i], s[i:]
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		col++
+	}
+	return s, ""
+}
+
+// telnetReader strips telnet negotiation from what a client sends, answers
+// it, and reports the window size of clients that send one
+type telnetReader struct {
+	r     io.Reader
+	w     io.Writer
+	width func(int)
+	last  int
+	asked bool
+	state int
+	cmd   byte
+	sub   []byte
+}
+
+// states of the telnet reader
+const (
+	telnetData = iota
+	telnetCommand
+	telnetOption
+	telnetSub
+	telnetSubIAC
+)
+
+// newTelnetReader reads from r and answers negotiation on w, asked is set
+// when the client was already sent askNAWS
+func newTelnetReader(r io.Reader, w io.Writer, asked bool) *telnetReader {
+	t := &telnetReader{r: r, w: w, asked: asked}
+	t.width = func(w int) { t.last = w }
+	return t
+}
+
+// report passes window sizes on to width from now on, along with the last
+// one sent before, it must be called from the goroutine reading
+func (t *telnetReader) report(width func(int)) {
+	if t.last > 0 {
+		width(t.last)
+	}
+	t.width = width
+}
+
+// Read reads the data bytes of the stream, never returning 0 bytes
+// without an error
+func (t *telnetReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.r.Read(p)
+		out := 0
+		for _, c := range p[:n] {
+			if t.feed(c) {
+				p[out] = c
+				out++
+			}
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// feed runs one byte through the negotiation, returning true if it is data
+func (t *telnetReader) feed(c byte) bool {
+	switch t.state {
+	case telnetCommand:
+		switch {
+		case c == telnetIAC:
+			t.state = telnetData
+			return true
+		case c >= telnetWILL && c <= telnetDONT:
+			t.state, t.cmd = telnetOption, c
+		case c == telnetSB:
+			t.state, t.sub = telnetSub, t.sub[:0]
+		default:
+			t.state = telnetData
+		}
+	case telnetOption:
+		t.state = telnetData
+		t.answer(t.cmd, c)
+	case telnetSub:
+		if c == telnetIAC {
+			t.state = telnetSubIAC
+		} else if len(t.sub) < 16 {
+			t.sub = append(t.sub, c)
+		}
+	case telnetSubIAC:
+		switch c {
+		case telnetIAC:
+			t.state = telnetSub
+			if len(t.sub) < 16 {
+				t.sub = append(t.sub, c)
+			}
+		case telnetSE:
+			t.state = telnetData
+			if len(t.sub) == 5 && t.sub[0] == telnetNAWS {
+				w := int(t.sub[1])<<8 | int(t.sub[2])
+				if w > maxWidth {
+					w = maxWidth
+				}
+				if w >= minWidth {
+					t.width(w)
+				}
+			}
+		default:
+			t.state = telnetData
+		}
+	default:
+		if c == telnetIAC {
+			t.state = telnetCommand
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// answer replies to an option the client offers or asks for, the window
+// size is the only one taken
+func (t *telnetReader) answer(cmd, opt byte) {
+	switch {
+	case cmd == telnetWILL && opt == telnetNAWS:
+		if !t.asked {
+			t.asked = true
+			t.w.Write(askNAWS)
+		}
+	case cmd == telnetWILL:
+		t.w.Write([]byte{telnetIAC, telnetDONT, opt})
+	case cmd == telnetDO:
+		t.w.Write([]byte{telnetIAC, telnetWONT, opt})
+	}
+}
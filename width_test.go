@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	line := "[12:00:batman] the joker is loose in gotham again and robin is late\r\n"
+	want := "[12:00:batman] the joker is loose\r\n" +
+		"               in gotham again and\r\n" +
+		"               robin is late\r\n"
+	if got := wrap(line, 34); got != want {
+		t.Errorf("expected [%q], got [%q]", want, got)
+	}
+
+	if got := wrap(line, 0); got != line {
+		t.Errorf("expected no wrapping at width 0, got [%q]", got)
+	}
+	if got := wrap("short\r\nlines\r\n", 20); got != "short\r\nlines\r\n" {
+		t.Errorf("expected short lines untouched, got [%q]", got)
+	}
+
+	long := "see https://example.com/a/very/long/path/indeed ok"
+	for _, l := range strings.Split(wrap(long, 20), "\r\n") {
+		if visibleLen(l) > 20 {
+			t.Errorf("expected lines of at most 20 columns, got [%q]", l)
+		}
+	}
+
+	colored := ansiBold + "the joker" + ansiReset + " is loose in gotham"
+	if got := wrap(colored, 20); got != ansiBold+"the joker"+ansiReset+" is loose\r\n  in gotham" {
+		t.Errorf("expected escapes to take no columns, got [%q]", got)
+	}
+	if head, rest := splitVisible(ansiBold+"abcdef", 3); head != ansiBold+"abc" || rest != "def" {
+		t.Errorf("expected escapes to stay whole, got [%q] [%q]", head, rest)
+	}
+}
+
+func TestWidthCommand(t *testing.T) {
+	if _, err := parseWidth("5"); err == nil {
+		t.Errorf("expected a tiny width to be refused")
+	}
+	if w, err := parseWidth("off"); err != nil || w != 0 {
+		t.Errorf("expected off to be 0, got %d %v", w, err)
+	}
+
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	cn := NewConn(nil)
+	serv.Dispatch(&Input{Client: batman, Conn: cn, Command: "/width", Args: []string{"/width", "40"}})
+	if cn.Width() != 40 {
+		t.Errorf("expected width 40, got %d", cn.Width())
+	}
+	ev := Event{Type: EventMessage, Time: "now", From: "robin", Text: strings.Repeat("holy ", 20)}
+	if out := cn.Render(ev); strings.Count(out, "\r\n") < 2 {
+		t.Errorf("expected the message to be wrapped, got [%q]", out)
+	}
+	cn.SetJSON(true)
+	if out := cn.Render(ev); strings.Count(out, "\r\n") != 1 {
+		t.Errorf("expected JSON to be left alone, got [%q]", out)
+	}
+}
+
+func TestTelnetReader(t *testing.T) {
+	in := []byte{'h', 'i', telnetIAC, telnetWILL, telnetNAWS, telnetIAC, telnetDO, 1, ' ', telnetIAC, telnetIAC}
+	in = append(in, telnetIAC, telnetSB, telnetNAWS, 0, 100, 0, 24, telnetIAC, telnetSE, '\n')
+
+	var answers bytes.Buffer
+	tr := newTelnetReader(bytes.NewReader(in), &answers, false)
+	var width int
+	tr.report(func(w int) { width = w })
+	out, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("expected error to be nil, got %v", err)
+	}
+	if string(out) != "hi \xff\n" {
+		t.Errorf("expected negotiation to be stripped, got [%q]", out)
+	}
+	if width != 100 {
+		t.Errorf("expected a width of 100, got %d", width)
+	}
+	want := []byte{telnetIAC, telnetDO, telnetNAWS, telnetIAC, telnetWONT, 1}
+	if !bytes.Equal(answers.Bytes(), want) {
+		t.Errorf("expected answers %v, got %v", want, answers.Bytes())
+	}
+
+	early := newTelnetReader(bytes.NewReader([]byte{telnetIAC, telnetSB, telnetNAWS, 0, 80, 0, 24, telnetIAC, telnetSE}), &answers, true)
+	ioutil.ReadAll(early)
+	early.report(func(w int) { width = w })
+	if width != 80 {
+		t.Errorf("expected a width sent before to be reported, got %d", width)
+	}
+}
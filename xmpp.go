@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nsComponent = "jabber:component:accept"
+	nsStream    = "http://etherx.jabber.org/streams"
+	nsMUC       = "http://jabber.org/protocol/muc"
+	nsMUCUser   = "http://jabber.org/protocol/muc#user"
+	nsDiscoInfo = "http://jabber.org/protocol/disco#info"
+	nsStanzas   = "urn:ietf:params:xml:ns:xmpp-stanzas"
+)
+
+// xmppRetry is how long the gateway waits before reconnecting
+const xmppRetry = 10 * time.Second
+
+type xmppPresence struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type xmppMessage struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Body    string   `xml:"body"`
+}
+
+type xmppIQ struct {
+	XMLName xml.Name `xml:"iq"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	ID      string   `xml:"id,attr"`
+	Query   struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// XMPPGateway exposes the server's rooms as multi-user chats through an
+// XEP-0114 external component, each Jabber user in a room is represented
+// by a client relaying its events back over the component stream
+type XMPPGateway struct {
+	Server *Server
+	Addr   string
+	Domain string
+	Secret string
+
+	mu        sync.Mutex
+	out       io.Writer
+	occupants map[string]*Client
+}
+
+// NewXMPPGateway returns a gateway that connects to the XMPP server at addr
+// as the component domain
+func NewXMPPGateway(s *Server, addr, domain, secret string) *XMPPGateway {
+	return &XMPPGateway{
+		Server:    s,
+		Addr:      addr,
+		Domain:    domain,
+		Secret:    secret,
+		occupants: make(map[string]*Client),
+	}
+}
+
+// Run keeps the component connected, reconnecting when the stream drops
+func (g *XMPPGateway) Run() {
+	for {
+		conn, err := net.Dial("tcp", g.Addr)
+		if err != nil {
+			errl(err, "")
+		} else {
+			err = g.serve(conn)
+			errl(err, "XMPP gateway disconnected")
+			conn.Close()
+			g.leaveAll()
+		}
+		time.Sleep(xmppRetry)
+	}
+}
+
+// serve performs the component handshake and handles stanzas until the
+// stream ends
+func (g *XMPPGateway) serve(conn net.Conn) error {
+	g.mu.Lock()
+	g.out = conn
+	g.mu.Unlock()
+
+	fmt.Fprintf(conn, "<stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>", nsComponent, nsStream, xmlEscape(g.Domain))
+
+	dec := xml.NewDecoder(conn)
+	start, err := nextStart(dec)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	for _, a := range start.Attr {
+		if a.Name.Local == "id" {
+			id = a.Value
+		}
+	}
+
+	sum := sha1.Sum([]byte(id + g.Secret))
+	g.write("<handshake>%s</handshake>", hex.EncodeToString(sum[:]))
+
+	start, err = nextStart(dec)
+	if err != nil {
+		return err
+	}
+	if start.Name.Local != "handshake" {
+		return errors.New("XMPP handshake was refused")
+	}
+	dec.Skip()
+	errl(nil, fmt.Sprintf("XMPP gateway connected as [%s]", g.Domain))
+
+	for {
+		start, err := nextStart(dec)
+		if err != nil {
+			return err
+		}
+
+		switch start.Name.Local {
+		case "presence":
+			var p xmppPresence
+			if err := dec.DecodeElement(&p, &start); err != nil {
+				return err
+			}
+			g.handlePresence(p)
+		case "message":
+			var m xmppMessage
+			if err := dec.DecodeElement(&m, &start); err != nil {
+				return err
+			}
+			g.handleMessage(m)
+		case "iq":
+			var iq xmppIQ
+			if err := dec.DecodeElement(&iq, &start); err != nil {
+				return err
+			}
+			g.handleIQ(iq)
+		default:
+			dec.Skip()
+		}
+	}
+}
+
+// nextStart returns the next start element in the stream
+func nextStart(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Local == "stream" {
+				return xml.StartElement{}, io.EOF
+			}
+		}
+	}
+}
+
+// write sends a formatted stanza over the component stream
+func (g *XMPPGateway) write(format string, args ...interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.out == nil {
+		return
+	}
+	fmt.Fprintf(g.out, format, args...)
+}
+
+// roomJID returns the bare JID for a room
+func (g *XMPPGateway) roomJID(room string) string {
+	return jidEscape(room) + "@" + g.Domain
+}
+
+// splitRoomJID returns the room name and nick from a room@domain/nick JID
+func (g *XMPPGateway) splitRoomJID(jid string) (string, string, bool) {
+	bare, nick := jid, ""
+	if i := strings.Index(jid, "/"); i >= 0 {
+		bare, nick = jid[:i], jid[i+1:]
+	}
+	i := strings.LastIndex(bare, "@")
+	if i < 0 || bare[i+1:] != g.Domain {
+		return "", "", false
+	}
+	return jidUnescape(bare[:i]), nick, true
+}
+
+func occupantKey(jid, room string) string {
+	return jid + "|" + room
+}
+
+// handlePresence joins or leaves a Jabber user from a room
+func (g *XMPPGateway) handlePresence(p xmppPresence) {
+	room, nick, ok := g.splitRoomJID(p.To)
+	if !ok || room == "" {
+		return
+	}
+	key := occupantKey(p.From, room)
+	rj := g.roomJID(room)
+
+	g.mu.Lock()
+	cl, joined := g.occupants[key]
+	g.mu.Unlock()
+
+	if p.Type == "unavailable" {
+		if joined {
+			g.Server.Leave(cl)
+			g.mu.Lock()
+			delete(g.occupants, key)
+			g.mu.Unlock()
+			g.write("<presence from='%s/%s' to='%s' type='unavailable'><x xmlns='%s'><item affiliation='none' role='none'/><status code='110'/></x></presence>",
+				xmlEscape(rj), xmlEscape(cl.Nick()), xmlEscape(p.From), nsMUCUser)
+		}
+		return
+	}
+
+	if joined || p.Type != "" {
+		return
+	}
+
+	if nick == "" {
+		g.presenceError(p, "jid-malformed")
+		return
+	}
+
+	jid := p.From
+	cl = &Client{nick: nick}
+	cl.relay = func(ev Event) { g.relay(jid, rj, ev) }
+	members, err := g.Server.JoinNew(room, cl)
+	if err != nil {
+		g.presenceError(p, "conflict")
+		return
+	}
+
+	g.mu.Lock()
+	g.occupants[key] = cl
+	g.mu.Unlock()
+
+	for _, m := range members {
+		g.write("<presence from='%s/%s' to='%s'><x xmlns='%s'><item affiliation='none' role='participant'/></x></presence>",
+			xmlEscape(rj), xmlEscape(m), xmlEscape(jid), nsMUCUser)
+	}
+	g.write("<presence from='%s/%s' to='%s'><x xmlns='%s'><item affiliation='none' role='participant'/><status code='110'/></x></presence>",
+		xmlEscape(rj), xmlEscape(nick), xmlEscape(jid), nsMUCUser)
+}
+
+// presenceError rejects a join with the given stanza error condition
+func (g *XMPPGateway) presenceError(p xmppPresence, condition string) {
+	g.write("<presence from='%s' to='%s' type='error'><x xmlns='%s'/><error type='cancel'><%s xmlns='%s'/></error></presence>",
+		xmlEscape(p.To), xmlEscape(p.From), nsMUC, condition, nsStanzas)
+}
+
+// handleMessage sends a groupchat message from a Jabber user into its room
+func (g *XMPPGateway) handleMessage(m xmppMessage) {
+	if m.Type != "groupchat" {
+		return
+	}
+
+	room, _, ok := g.splitRoomJID(m.To)
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	cl, joined := g.occupants[occupantKey(m.From, room)]
+	g.mu.Unlock()
+
+	inputs := strings.Fields(m.Body)
+	if !joined || len(inputs) == 0 {
+		return
+	}
+
+	recorder().Input(cl, inputs)
+	g.Server.Inbound.Run(&Input{Client: cl, Args: inputs}, func(in *Input) {
+		err := g.Server.Message(in.Args, in.Client)
+		errl(err, "XMPP message sent to room successfully")
+	})
+}
+
+// handleIQ answers service discovery on rooms and rejects everything else
+func (g *XMPPGateway) handleIQ(iq xmppIQ) {
+	if iq.Type != "get" && iq.Type != "set" {
+		return
+	}
+
+	if iq.Type == "get" && iq.Query.XMLName.Space == nsDiscoInfo {
+		g.write("<iq type='result' id='%s' from='%s' to='%s'><query xmlns='%s'><identity category='conference' type='text' name='TinyChat'/><feature var='%s'/></query></iq>",
+			xmlEscape(iq.ID), xmlEscape(iq.To), xmlEscape(iq.From), nsDiscoInfo, nsMUC)
+		return
+	}
+
+	g.write("<iq type='error' id='%s' from='%s' to='%s'><error type='cancel'><service-unavailable xmlns='%s'/></error></iq>",
+		xmlEscape(iq.ID), xmlEscape(iq.To), xmlEscape(iq.From), nsStanzas)
+}
+
+// relay forwards an event delivered to an occupant to its Jabber user
+func (g *XMPPGateway) relay(jid, rj string, ev Event) {
+	from, body := rj, strings.TrimRight(ev.String(), "\r\n")
+	switch ev.Type {
+	case EventMessage, EventBlast, EventService, EventNotice:
+		from, body = rj+"/"+ev.From, ev.body()
+	}
+	if body == "" {
+		return
+	}
+
+	g.write("<message type='groupchat' from='%s' to='%s'><body>%s</body></message>", xmlEscape(from), xmlEscape(jid), xmlEscape(body))
+}
+
+// leaveAll removes every occupant after the stream is lost
+func (g *XMPPGateway) leaveAll() {
+	g.mu.Lock()
+	occupants := g.occupants
+	g.occupants = make(map[string]*Client)
+	g.out = nil
+	g.mu.Unlock()
+
+	for _, cl := range occupants {
+		g.Server.Leave(cl)
+	}
+}
+
+// JoinNew joins a client that isn't connected yet, refusing nicks already
+// in use, it returns the nicks already in the room
+func (s *Server) JoinNew(roomname string, cl *Client) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := validNick(cl.Nick()); err != nil {
+		return nil, err
+	}
+	if err := validRoom(roomname); err != nil {
+		return nil, err
+	}
+	if s.nickInUse(cl.Nick(), cl) || s.Accounts.Exists(cl.Nick()) || s.isBotName(cl.Nick()) {
+		return nil, fmt.Errorf("user [%s] already exists\r\n", cl.Nick())
+	}
+	if s.reservedNick(cl.Nick()) {
+		return nil, fmt.Errorf("nick [%s] is reserved\r\n", cl.Nick())
+	}
+
+	var members []string
+	if r, ok := s.Rooms[roomname]; ok {
+		for n := range r.Clients {
+			members = append(members, n)
+		}
+	}
+	return members, s.joinRoom(roomname, cl)
+}
+
+// Leave removes the client from its room and the server
+func (s *Server) Leave(cl *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tryDeleteFromRoom(cl)
+	s.removeClient(cl)
+}
+
+// xmlEscape escapes s for use in XML text and attributes
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// jidEscapes are the XEP-0106 escapes for JID localparts
+var jidEscapes = []string{`\`, `\5c`, " ", `\20`, `"`, `\22`, "&", `\26`, "'", `\27`, "/", `\2f`, ":", `\3a`, "<", `\3c`, ">", `\3e`, "@", `\40`}
+
+// jidEscape escapes a room name for use as a JID localpart
+func jidEscape(s string) string {
+	return strings.NewReplacer(jidEscapes...).Replace(s)
+}
+
+// jidUnescape reverses jidEscape
+func jidUnescape(s string) string {
+	var rev []string
+	for i := 0; i < len(jidEscapes); i += 2 {
+		rev = append(rev, jidEscapes[i+1], jidEscapes[i])
+	}
+	return strings.NewReplacer(rev...).Replace(s)
+}
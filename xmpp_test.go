@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestJIDEscape(t *testing.T) {
+	room := "gotham city"
+	if jidEscape(room) != `gotham\20city` {
+		t.Errorf("expected space to be escaped, got [%s]", jidEscape(room))
+	}
+
+	if jidUnescape(jidEscape(`a@b/c\d`)) != `a@b/c\d` {
+		t.Errorf("expected escaping to round trip")
+	}
+}
+
+func TestXMPPGateway(t *testing.T) {
+	serv := NewServer()
+	batman := &Client{nick: "batman"}
+	serv.JoinRoom("gotham city", batman)
+
+	g := NewXMPPGateway(serv, "", "chat.example.com", "s3cret")
+	c, p := net.Pipe()
+	go g.serve(c)
+
+	r := bufio.NewReader(p)
+	read := func(until string) string {
+		var out string
+		for !strings.Contains(out, until) {
+			b, err := r.ReadByte()
+			if err != nil {
+				t.Fatalf("expected [%s], got [%s]", until, out)
+			}
+			out = out + string(b)
+		}
+		return out
+	}
+
+	read("to='chat.example.com'>")
+	p.Write([]byte("<stream:stream xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:component:accept' id='abc'>"))
+	read("</handshake>")
+	p.Write([]byte("<handshake/>"))
+
+	p.Write([]byte(`<presence from='freeze@example.com/home' to='gotham\20city@chat.example.com/freeze'><x xmlns='http://jabber.org/protocol/muc'/></presence>`))
+	out := read("status code='110'/></x></presence>")
+	if !strings.Contains(out, `from='gotham\20city@chat.example.com/batman'`) {
+		t.Errorf("expected existing occupant presence, got [%s]", out)
+	}
+
+	if !serv.clientExists("freeze") {
+		t.Errorf("expected the Jabber user to join the room")
+	}
+
+	go serv.Message([]string{"hi", "freeze"}, batman)
+	out = read("</message>")
+	if !strings.Contains(out, "<body>hi freeze</body>") || !strings.Contains(out, "/batman'") {
+		t.Errorf("expected room message to be relayed, got [%s]", out)
+	}
+
+	p.Write([]byte(`<presence from='freeze@example.com/home' to='gotham\20city@chat.example.com/freeze' type='unavailable'/>`))
+	read("type='unavailable'")
+	if serv.clientExists("freeze") {
+		t.Errorf("expected the Jabber user to leave")
+	}
+}